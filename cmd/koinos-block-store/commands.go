@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/koinos/koinos-block-store/internal/bstore"
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	flag "github.com/spf13/pflag"
+)
+
+// command is an offline operation run against the database instead of the service, named by the first
+// argument, such as 'koinos-block-store export --to 1000 --out blocks.kba'. It takes the options of the
+// service, so it opens the database the service is configured with, and needs no AMQP server.
+type command struct {
+	// ReadOnly opens the database read-only, so the command can run alongside the service
+	ReadOnly bool
+
+	// Unmigrated runs the command without first migrating the database to the current format
+	Unmigrated bool
+
+	// Init registers the flags of the command and returns the function running it, which returns the
+	// exit code
+	Init func(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int
+}
+
+var commands = map[string]*command{
+	"export":  {ReadOnly: true, Init: initExportCommand},
+	"import":  {Init: initImportCommand},
+	"prune":   {Init: initPruneCommand},
+	"compact": {Init: initCompactCommand},
+	"inspect": {ReadOnly: true, Init: initInspectCommand},
+	"stats":   {ReadOnly: true, Init: initStatsCommand},
+	"repair":  {Init: initRepairCommand},
+	"migrate": {Unmigrated: true, Init: initMigrateCommand},
+}
+
+// parseCommand removes the command named by the first argument from the arguments, returning an empty
+// name if the first argument is not a command
+func parseCommand() (string, *command) {
+	if len(os.Args) < 2 {
+		return "", nil
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		return "", nil
+	}
+
+	name := os.Args[1]
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	return name, cmd
+}
+
+// runCommand runs a command against the database and returns the exit code. A writable database is
+// migrated to the current format first, a read-only one must not need migrating.
+func runCommand(name string, cmd *command, run func(handler *bstore.RequestHandler) int, backend storeBackend) int {
+	defer backend.Close()
+
+	handler := bstore.RequestHandler{Backend: bstore.NewNamespaceBackend(backend, bstore.NamespaceMetadata, bstore.NamespaceIndexes, bstore.NamespaceBlocks)}
+
+	if cmd.ReadOnly {
+		report, err := handler.CheckCompatibility()
+		if err != nil {
+			log.Errorf("Could not check database compatibility, %s", err.Error())
+			return 1
+		}
+		if !report.Compatible {
+			log.Errorf("Command '%s' cannot read the database, %s", name, strings.Join(report.Problems, ", "))
+			return 1
+		}
+		if len(report.Migrations) > 0 {
+			log.Errorf("Command '%s' cannot read the database before it is migrated, run the migrate command first", name)
+			return 1
+		}
+	} else if !cmd.Unmigrated {
+		migrations, err := handler.Migrate()
+		if err != nil {
+			log.Errorf("Could not migrate the database, %s", err.Error())
+			return 1
+		}
+		for _, m := range migrations {
+			log.Infof("Migrated %d record(s) to format version %d (%s)", m.Records, m.Version, m.Name)
+		}
+	}
+
+	return run(&handler)
+}
+
+// printResult prints the result of a command as JSON, returning the exit code
+func printResult(result interface{}) int {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Errorf("Could not serialize result, %s", err.Error())
+		return 1
+	}
+	fmt.Println(string(data))
+
+	return 0
+}
+
+func initExportCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	from := flags.Uint64("from", 0, "Height of the first exported block (0 for the first stored block)")
+	to := flags.Uint64("to", 0, "Height of the last exported block (0 for the highest block)")
+	out := flags.String("out", "", "File the blocks are written to")
+
+	return func(handler *bstore.RequestHandler) int {
+		if len(*out) == 0 {
+			log.Error("Option 'out' is required")
+			return 1
+		}
+
+		// The archive is written next to its destination, so an interrupted export leaves no partial file
+		tmp := *out + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			log.Errorf("Could not create %s, %s", tmp, err.Error())
+			return 1
+		}
+
+		result, err := handler.ExportBlocks(f, *from, *to)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp, *out)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			log.Errorf("Could not export blocks, %s", err.Error())
+			return 1
+		}
+
+		log.Infof("Exported %d block(s) to %s", result.Blocks, *out)
+		return printResult(result)
+	}
+}
+
+func initImportCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	in := flags.String("in", "", "File written by the export command the blocks are read from")
+
+	return func(handler *bstore.RequestHandler) int {
+		if len(*in) == 0 {
+			log.Error("Option 'in' is required")
+			return 1
+		}
+
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Errorf("Could not open %s, %s", *in, err.Error())
+			return 1
+		}
+		defer f.Close()
+
+		result, err := handler.ImportBlocks(f)
+		if err != nil {
+			log.Errorf("Could not import blocks, %s", err.Error())
+			return 1
+		}
+
+		return printResult(result)
+	}
+}
+
+func initPruneCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	keepBlocks := flags.Uint64("keep-blocks", 0, "Number of blocks of history kept below the highest block (0 to keep all)")
+	belowHeight := flags.Uint64("below-height", 0, "Height below which the history is removed (0 to keep all)")
+	force := flags.Bool("force", false, "Remove history above the irreversible block")
+
+	return func(handler *bstore.RequestHandler) int {
+		if *keepBlocks > 0 && *belowHeight > 0 {
+			log.Error("Options 'keep-blocks' and 'below-height' cannot be used together")
+			return 1
+		}
+
+		// The payer index is pruned along with the blocks it references
+		plan := &bstore.PrunePlan{BelowHeight: *belowHeight, Indexes: []string{bstore.PayerIndexName}, Force: *force}
+		if *keepBlocks > 0 {
+			highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+			if err != nil {
+				log.Errorf("Could not read the highest block, %s", err.Error())
+				return 1
+			}
+			if height := highest.GetTopology().GetHeight(); height > *keepBlocks {
+				plan.BelowHeight = height - *keepBlocks + 1
+			}
+		}
+
+		result, err := handler.Prune(plan)
+		if err != nil {
+			log.Errorf("Could not prune the database, %s", err.Error())
+			return 1
+		}
+
+		compaction, err := handler.CompactStore(&bstore.CompactStoreRequest{})
+		if err != nil {
+			log.Errorf("Could not compact the database, %s", err.Error())
+			return 1
+		}
+
+		return printResult(struct {
+			*bstore.PruneResult
+			Compaction *bstore.CompactStoreResponse `json:"compaction"`
+		}{result, compaction})
+	}
+}
+
+func initCompactCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	discardRatio := flags.Float64("discard-ratio", 0, "Share of stale data above which a value log file is rewritten (0 for the default of 0.5)")
+
+	return func(handler *bstore.RequestHandler) int {
+		result, err := handler.CompactStore(&bstore.CompactStoreRequest{DiscardRatio: *discardRatio})
+		if err != nil {
+			log.Errorf("Could not compact the database, %s", err.Error())
+			return 1
+		}
+
+		return printResult(result)
+	}
+}
+
+func initInspectCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	id := flags.String("id", "", "Hex encoded ID of the inspected block")
+	height := flags.Uint64("height", 0, "Height of the inspected block on the chain of the highest block")
+	part := flags.String("part", bstore.InspectRecord, "Part of the block printed ("+strings.Join([]string{bstore.InspectRecord, bstore.InspectBlock, bstore.InspectHeader, bstore.InspectTopology, bstore.InspectReceipt}, ", ")+")")
+
+	return func(handler *bstore.RequestHandler) int {
+		if (len(*id) > 0) == (*height > 0) {
+			log.Error("Exactly one of the options 'id' and 'height' is required")
+			return 1
+		}
+
+		blockID, err := hex.DecodeString(strings.TrimPrefix(*id, "0x"))
+		if err != nil {
+			log.Errorf("Option 'id' must be hex encoded, %s", err.Error())
+			return 1
+		}
+
+		result, err := handler.InspectBlockRecord(blockID, *height, *part)
+		if err != nil {
+			log.Errorf("Could not inspect block, %s", err.Error())
+			return 1
+		}
+
+		return printResult(result)
+	}
+}
+
+func initStatsCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	largest := flags.Int("largest", 10, "Number of the largest blocks listed")
+
+	return func(handler *bstore.RequestHandler) int {
+		result, err := handler.ScanStore(*largest)
+		if err != nil {
+			log.Errorf("Could not read the database, %s", err.Error())
+			return 1
+		}
+
+		return printResult(result)
+	}
+}
+
+func initRepairCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	return func(handler *bstore.RequestHandler) int {
+		result, err := handler.Repair()
+		if err != nil {
+			log.Errorf("Could not repair the database, %s", err.Error())
+			return 1
+		}
+
+		return printResult(result)
+	}
+}
+
+func initMigrateCommand(flags *flag.FlagSet) func(handler *bstore.RequestHandler) int {
+	dryRun := flags.Bool("dry-run", false, "List the migrations the database needs without running them")
+
+	return func(handler *bstore.RequestHandler) int {
+		if *dryRun {
+			pending, err := handler.PendingMigrations()
+			if err != nil {
+				log.Errorf("Could not read the database format, %s", err.Error())
+				return 1
+			}
+
+			return printResult(pending)
+		}
+
+		result, err := handler.Migrate()
+		if err != nil {
+			log.Errorf("Could not migrate the database, %s", err.Error())
+			return 1
+		}
+
+		return printResult(result)
+	}
+}
@@ -0,0 +1,427 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-block-store/internal/bstore"
+	koinosmq "github.com/koinos/koinos-mq-golang"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/broadcast"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+// The integration tests run the block store binary against a real AMQP broker. The broker must not
+// be shared with another block store, which would compete for the RPC queue.
+//
+//	go test -tags integration ./cmd/koinos-block-store
+//
+// KOINOS_AMQP overrides the broker URL. If KOINOS_AMQP_RESTART is set, it is run as a shell command
+// to restart the broker, for example "docker restart rabbitmq", to test reconnecting.
+const (
+	amqpEnv        = "KOINOS_AMQP"
+	amqpRestartEnv = "KOINOS_AMQP_RESTART"
+
+	protoContentType = "application/octet-stream"
+	serviceTimeout   = 30 * time.Second
+)
+
+var binaryPath string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "koinos-block-store-integration")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	binaryPath = filepath.Join(dir, "koinos-block-store")
+	build := exec.Command("go", "build", "-o", binaryPath, ".")
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not build block store, %s\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func amqpURL() string {
+	if addr := os.Getenv(amqpEnv); len(addr) > 0 {
+		return addr
+	}
+	return amqpDefault
+}
+
+// harness runs a block store process and talks to it over the broker
+type harness struct {
+	t       *testing.T
+	baseDir string
+	args    []string
+	cmd     *exec.Cmd
+	logFile *os.File
+
+	client  *koinosmq.Client
+	ready   chan *bstore.Ready
+	added   chan *bstore.BlockAdded
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+}
+
+func newHarness(t *testing.T, args ...string) *harness {
+	addr := amqpURL()
+	u, err := url.Parse(addr)
+	if err != nil {
+		t.Fatalf("Invalid AMQP URL %s, %s", addr, err)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 5*time.Second)
+	if err != nil {
+		t.Fatalf("AMQP broker is not reachable at %s, %s", u.Host, err)
+	}
+	_ = conn.Close()
+
+	h := &harness{
+		t:       t,
+		baseDir: t.TempDir(),
+		args:    args,
+		ready:   make(chan *bstore.Ready, 8),
+		added:   make(chan *bstore.BlockAdded, 256),
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	h.client = koinosmq.NewClient(addr, koinosmq.ExponentialBackoff)
+	listener := koinosmq.NewRequestHandler(addr, 1, koinosmq.ExponentialBackoff)
+	listener.SetBroadcastHandler(storeReady, func(topic string, data []byte) {
+		ready := &bstore.Ready{}
+		if err := json.Unmarshal(data, ready); err == nil {
+			h.ready <- ready
+		}
+	})
+	listener.SetBroadcastHandler(blockAdded, func(topic string, data []byte) {
+		added := &bstore.BlockAdded{}
+		if err := json.Unmarshal(data, added); err == nil {
+			h.added <- added
+		}
+	})
+	<-h.client.Start(h.ctx)
+	<-listener.Start(h.ctx)
+
+	t.Cleanup(h.close)
+	h.start()
+	return h
+}
+
+// start runs the block store and waits for its ready broadcast
+func (h *harness) start() {
+	h.t.Helper()
+
+	var err error
+	h.logFile, err = os.Create(filepath.Join(h.baseDir, "service.log"))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	args := append([]string{"--basedir", h.baseDir, "--amqp", amqpURL(), "--log-color=false"}, h.args...)
+	h.cmd = exec.Command(binaryPath, args...)
+	h.cmd.Stdout = h.logFile
+	h.cmd.Stderr = h.logFile
+	if err := h.cmd.Start(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.started = true
+
+	select {
+	case <-h.ready:
+	case <-time.After(serviceTimeout):
+		h.t.Fatalf("Block store did not become ready\n%s", h.log())
+	}
+}
+
+// stop interrupts the block store and waits for it to exit
+func (h *harness) stop() {
+	h.t.Helper()
+	if !h.started {
+		return
+	}
+	h.started = false
+
+	_ = h.cmd.Process.Signal(syscall.SIGINT)
+	done := make(chan error, 1)
+	go func() { done <- h.cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			h.t.Errorf("Block store exited with %s\n%s", err, h.log())
+		}
+	case <-time.After(serviceTimeout):
+		_ = h.cmd.Process.Kill()
+		h.t.Errorf("Block store did not shut down\n%s", h.log())
+	}
+	_ = h.logFile.Close()
+}
+
+func (h *harness) close() {
+	h.stop()
+	h.cancel()
+}
+
+func (h *harness) log() string {
+	data, _ := os.ReadFile(filepath.Join(h.baseDir, "service.log"))
+	return string(data)
+}
+
+func (h *harness) request(req *block_store.BlockStoreRequest) *block_store.BlockStoreResponse {
+	h.t.Helper()
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, serviceTimeout)
+	defer cancel()
+
+	result, err := h.client.RPC(ctx, protoContentType, blockstoreRPC, data)
+	if err != nil {
+		h.t.Fatalf("RPC failed, %s\n%s", err, h.log())
+	}
+
+	resp := &block_store.BlockStoreResponse{}
+	if err := proto.Unmarshal(result, resp); err != nil {
+		h.t.Fatal(err)
+	}
+	return resp
+}
+
+func (h *harness) extendedRequest(req *bstore.ExtendedRequest) *bstore.ExtendedResponse {
+	h.t.Helper()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, serviceTimeout)
+	defer cancel()
+
+	result, err := h.client.RPC(ctx, jsonContentType, blockstoreExtRPC, data)
+	if err != nil {
+		h.t.Fatalf("RPC failed, %s\n%s", err, h.log())
+	}
+
+	resp := &bstore.ExtendedResponse{}
+	if err := json.Unmarshal(result, resp); err != nil {
+		h.t.Fatal(err)
+	}
+	return resp
+}
+
+func (h *harness) addBlock(block *protocol.Block) {
+	h.t.Helper()
+
+	resp := h.request(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_AddBlock{
+		AddBlock: &block_store.AddBlockRequest{BlockToAdd: block, ReceiptToAdd: &protocol.BlockReceipt{}},
+	}})
+	if errval, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error); ok {
+		h.t.Fatalf("Could not add block at height %d, %s", block.GetHeader().GetHeight(), errval.Error.GetMessage())
+	}
+}
+
+func (h *harness) getBlocksByHeight(head []byte, numBlocks uint32) *block_store.BlockStoreResponse {
+	h.t.Helper()
+
+	return h.request(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+		GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{
+			HeadBlockId:         head,
+			AncestorStartHeight: 1,
+			NumBlocks:           numBlocks,
+			ReturnBlock:         true,
+		},
+	}})
+}
+
+// linearChain returns a chain of blocks at heights 1 through length
+func linearChain(length uint64) []*protocol.Block {
+	chain := []uint64{0}
+	for i := uint64(1); i <= length; i++ {
+		chain = append(chain, i)
+	}
+
+	bt := bstore.ToBlockTree(bstore.NewMockBlockTree([][]uint64{chain}))
+	blocks := make([]*protocol.Block, length)
+	for i := range blocks {
+		blocks[i] = bt.ByNum[uint64(i+1)]
+	}
+	return blocks
+}
+
+func TestIntegrationRPC(t *testing.T) {
+	h := newHarness(t)
+	blocks := linearChain(20)
+	for _, block := range blocks {
+		h.addBlock(block)
+	}
+
+	resp := h.getBlocksByHeight(blocks[19].GetId(), 20)
+	items := resp.GetGetBlocksByHeight().GetBlockItems()
+	if len(items) != 20 {
+		t.Fatalf("Expected 20 blocks, got %d", len(items))
+	}
+	for i, item := range items {
+		if !proto.Equal(item.GetBlock(), blocks[i]) {
+			t.Fatalf("Unexpected block at height %d", i+1)
+		}
+	}
+
+	resp = h.request(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetHighestBlock{
+		GetHighestBlock: &block_store.GetHighestBlockRequest{},
+	}})
+	if topology := resp.GetGetHighestBlock().GetTopology(); topology.GetHeight() != 20 || !bytes.Equal(topology.GetId(), blocks[19].GetId()) {
+		t.Errorf("Unexpected highest block %v", topology)
+	}
+
+	ext := h.extendedRequest(&bstore.ExtendedRequest{GetStatus: &bstore.GetStatusRequest{}})
+	if ext.Error != nil {
+		t.Fatal(ext.Error.Message)
+	}
+	if ext.GetStatus.Store.Head.Height != 20 {
+		t.Errorf("Expected status head at height 20, got %d", ext.GetStatus.Store.Head.Height)
+	}
+
+	// Requests the service cannot parse are answered with an error rather than dropped
+	ctx, cancel := context.WithTimeout(context.Background(), serviceTimeout)
+	defer cancel()
+	result, err := h.client.RPC(ctx, protoContentType, blockstoreRPC, []byte{0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := &block_store.BlockStoreResponse{}
+	if err := proto.Unmarshal(result, malformed); err != nil {
+		t.Fatal(err)
+	}
+	if code := bstore.ErrorCodeOfStatus(malformed.GetError()); code != bstore.ErrorCodeMalformedRequest {
+		t.Errorf("Expected malformed request error, got %s", code)
+	}
+}
+
+func TestIntegrationBroadcast(t *testing.T) {
+	h := newHarness(t)
+	blocks := linearChain(5)
+
+	for _, block := range blocks {
+		data, err := proto.Marshal(&broadcast.BlockAccepted{Block: block, Receipt: &protocol.BlockReceipt{}, Live: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.client.Broadcast(h.ctx, protoContentType, blockAccept, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := range blocks {
+		select {
+		case added := <-h.added:
+			if !bytes.Equal(added.BlockID, blocks[i].GetId()) || !added.New {
+				t.Errorf("Unexpected block added broadcast %+v", added)
+			}
+		case <-time.After(serviceTimeout):
+			t.Fatalf("Expected block added broadcast for height %d\n%s", i+1, h.log())
+		}
+	}
+
+	resp := h.getBlocksByHeight(blocks[4].GetId(), 5)
+	if n := len(resp.GetGetBlocksByHeight().GetBlockItems()); n != 5 {
+		t.Errorf("Expected 5 broadcast blocks to be stored, got %d", n)
+	}
+}
+
+func TestIntegrationOversizedResponse(t *testing.T) {
+	h := newHarness(t, "--max-message-size", "1024")
+	blocks := linearChain(20)
+	for _, block := range blocks {
+		h.addBlock(block)
+	}
+
+	// Block store responses cannot flag dropped blocks, so one which does not fit is an error
+	resp := h.getBlocksByHeight(blocks[19].GetId(), 20)
+	if code := bstore.ErrorCodeOfStatus(resp.GetError()); code != bstore.ErrorCodeMessageTooLarge {
+		t.Errorf("Expected message too large error, got %s", code)
+	}
+
+	// So is a block which does not fit on its own
+	large := &protocol.Block{Header: &protocol.BlockHeader{Previous: bstore.GetEmptyBlockID(), Height: 1, Timestamp: 1000}}
+	large.Signature = bytes.Repeat([]byte{1}, 2048)
+	large.Id = bstore.ComputeBlockID(large)
+	h.addBlock(large)
+
+	resp = h.request(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksById{
+		GetBlocksById: &block_store.GetBlocksByIdRequest{BlockIds: [][]byte{large.GetId()}, ReturnBlock: true},
+	}})
+	if code := bstore.ErrorCodeOfStatus(resp.GetError()); code != bstore.ErrorCodeMessageTooLarge {
+		t.Errorf("Expected message too large error, got %s", code)
+	}
+}
+
+func TestIntegrationServiceRestart(t *testing.T) {
+	h := newHarness(t)
+	blocks := linearChain(10)
+	for _, block := range blocks[:5] {
+		h.addBlock(block)
+	}
+
+	// The client keeps its connection while the service restarts on the same database
+	h.stop()
+	h.start()
+
+	for _, block := range blocks[5:] {
+		h.addBlock(block)
+	}
+
+	resp := h.getBlocksByHeight(blocks[9].GetId(), 10)
+	if n := len(resp.GetGetBlocksByHeight().GetBlockItems()); n != 10 {
+		t.Errorf("Expected 10 blocks after restart, got %d", n)
+	}
+}
+
+func TestIntegrationBrokerRestart(t *testing.T) {
+	restart := os.Getenv(amqpRestartEnv)
+	if len(restart) == 0 {
+		t.Skipf("%s is not set", amqpRestartEnv)
+	}
+
+	h := newHarness(t)
+	blocks := linearChain(2)
+	h.addBlock(blocks[0])
+
+	out, err := exec.Command("sh", "-c", restart).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Could not restart broker, %s\n%s", err, out)
+	}
+
+	// Both the service and the client reconnect, the request is retried until they have
+	h.addBlock(blocks[1])
+
+	resp := h.getBlocksByHeight(blocks[1].GetId(), 2)
+	if n := len(resp.GetGetBlocksByHeight().GetBlockItems()); n != 2 {
+		t.Errorf("Expected 2 blocks after broker restart, got %d", n)
+	}
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/signal"
 	"path"
 	"runtime"
+	"runtime/debug"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -18,7 +23,6 @@ import (
 	koinosmq "github.com/koinos/koinos-mq-golang"
 	"github.com/koinos/koinos-proto-golang/v2/koinos"
 	"github.com/koinos/koinos-proto-golang/v2/koinos/broadcast"
-	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc"
 	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
 	util "github.com/koinos/koinos-util-golang/v2"
 	"github.com/multiformats/go-multihash"
@@ -36,7 +40,93 @@ const (
 	logDatetimeOption = "log-datetime"
 	resetOption       = "reset"
 	jobsOption        = "jobs"
+	ingestJobsOption  = "ingest-jobs"
 	versionOption     = "version"
+	checkCompatOption = "check-compat"
+	rotateKeyOption   = "rotate-key-file"
+
+	storeBackendOption      = "store-backend"
+	backendOption           = "backend"
+	chainIDOption           = "chain-id"
+	duplicateWindowOption   = "duplicate-window"
+	staleHeadAfterOption    = "stale-head-after"
+	dropReceiptsOption      = "ingest-drop-receipts"
+	producersOption         = "ingest-producers"
+	maxHeightOption         = "ingest-max-height"
+	readOnlyOption          = "read-only"
+	resyncOption            = "resync"
+	resyncBatchSizeOption   = "resync-batch-size"
+	producerPolicyOption    = "incompatible-producer-policy"
+	strictOption            = "strict"
+	backupDirOption         = "backup-dir"
+	exportDirOption         = "export-dir"
+	checkpointFileOption    = "checkpoint-file"
+	allowRestoreOption      = "allow-restore"
+	adminSecretFileOption   = "admin-secret-file"
+	adminAllowlistOption    = "admin-allowlist"
+	logPayloadOption        = "log-payload"
+	captureDirOption        = "capture-dir"
+	captureFileSizeOption   = "capture-file-size"
+	captureFilesOption      = "capture-files"
+	maxMessageSizeOption    = "max-message-size"
+	memoryLimitOption       = "memory-limit"
+	maxBlocksByHeightOption = "max-blocks-by-height"
+	maxBlocksByIDOption     = "max-blocks-by-id"
+	rateLimitOption         = "rate-limit"
+	rateLimitBurstOption    = "rate-limit-burst"
+	requestTimeoutOption    = "request-timeout"
+	errorJournalSizeOption  = "error-journal-size"
+	cacheSizeMinOption      = "cache-size-min"
+	cacheSizeMaxOption      = "cache-size-max"
+	cacheTuneIntervalOption = "cache-tune-interval"
+	diskMinFreeOption       = "disk-min-free"
+	diskCheckIntervalOption = "disk-check-interval"
+	postgresURLOption       = "postgres-url"
+	segmentSizeOption       = "segment-size"
+	shardSizeOption         = "shard-size"
+	compressionOption       = "compression"
+	encryptionKeyFileOption = "encryption-key-file"
+	checksumsOption         = "value-checksums"
+	postgresTableOption     = "postgres-table"
+	coldEndpointOption      = "cold-storage-endpoint"
+	coldBucketOption        = "cold-storage-bucket"
+	coldRegionOption        = "cold-storage-region"
+	coldPrefixOption        = "cold-storage-prefix"
+	coldDepthOption         = "cold-storage-depth"
+	remoteAMQPOption        = "remote-amqp"
+	remoteSecretFileOption  = "remote-secret-file"
+	replicaDirOption        = "replica-dir"
+	replicaEndpointOption   = "replica-s3-endpoint"
+	replicaBucketOption     = "replica-s3-bucket"
+	replicaRegionOption     = "replica-s3-region"
+	replicaPrefixOption     = "replica-s3-prefix"
+	replicaReconcileOption  = "replica-reconcile-interval"
+	walOption               = "wal"
+	walRetainedOption       = "wal-retained-segments"
+	standbyAMQPOption       = "standby-amqp"
+	standbySecretFileOption = "standby-secret-file"
+	standbyPollOption       = "standby-poll-interval"
+
+	checkpointSignerOption   = "checkpoint-signer"
+	checkpointIntervalOption = "checkpoint-interval"
+	checkpointDirOption      = "checkpoint-dir"
+	checkpointURLOption      = "checkpoint-url"
+	checkpointKeyFileOption  = "checkpoint-key-file"
+
+	metricsPushURLOption      = "metrics-push-url"
+	metricsJobOption          = "metrics-job"
+	metricsStatsDOption       = "metrics-statsd-address"
+	metricsStatsDPrefixOption = "metrics-statsd-prefix"
+	metricsIntervalOption     = "metrics-push-interval"
+
+	amqpCredentialsFileOption = "amqp-credentials-file"
+	amqpCAFileOption          = "amqp-ca-file"
+	amqpCertFileOption        = "amqp-cert-file"
+	amqpKeyFileOption         = "amqp-key-file"
+	amqpServerNameOption      = "amqp-server-name"
+
+	pprofAddrOption  = "pprof-addr"
+	healthAddrOption = "health-addr"
 )
 
 const (
@@ -47,15 +137,93 @@ const (
 	logColorDefault    = true
 	logDatetimeDefault = true
 	resetDefault       = false
+
+	backendDefault           = badgerBackend
+	duplicateWindowDefault   = "0"
+	staleHeadAfterDefault    = "0"
+	dropReceiptsDefault      = false
+	maxHeightDefault         = 0
+	readOnlyDefault          = false
+	resyncDefault            = false
+	resyncBatchSizeDefault   = bstore.DefaultResyncBatchSize
+	producerPolicyDefault    = "warn"
+	strictDefault            = false
+	backupDirDefault         = "backups"
+	exportDirDefault         = "exports"
+	allowRestoreDefault      = false
+	logPayloadDefault        = "digest"
+	captureFileSizeDefault   = 64
+	captureFilesDefault      = 10
+	maxMessageSizeDefault    = bstore.DefaultMaxMessageSize
+	memoryLimitDefault       = 0
+	maxBlocksByHeightDefault = bstore.DefaultMaxBlocksByHeight
+	maxBlocksByIDDefault     = bstore.DefaultMaxBlocksByID
+	rateLimitDefault         = 0
+	rateLimitBurstDefault    = 0
+	requestTimeoutDefault    = "0"
+	errorJournalSizeDefault  = 0
+	cacheSizeMinDefault      = 8
+	cacheSizeMaxDefault      = 0
+	cacheTuneIntervalDefault = "1m"
+	diskMinFreeDefault       = 0
+	diskCheckIntervalDefault = "10s"
+	postgresTableDefault     = bstore.DefaultPostgresTable
+	segmentSizeDefault       = bstore.DefaultSegmentSize >> 20
+	shardSizeDefault         = bstore.DefaultShardSize
+	compressionDefault       = compressionNone
+	checksumsDefault         = false
+	coldRegionDefault        = "us-east-1"
+	coldDepthDefault         = 100000
+	replicaRegionDefault     = "us-east-1"
+	replicaReconcileDefault  = "1m"
+	walDefault               = false
+	walRetainedDefault       = bstore.DefaultWALRetainedSegments
+	standbyPollDefault       = "1s"
+
+	checkpointIntervalDefault = 0
+	checkpointDirDefault      = "checkpoints"
+
+	metricsJobDefault          = bstore.DefaultMetricsJob
+	metricsStatsDPrefixDefault = "koinos."
+	metricsIntervalDefault     = "15s"
 )
 
 const (
-	blockstoreRPC  = "block_store"
-	blockAccept    = "koinos.block.accept"
-	appName        = "block_store"
-	maxMessageSize = 536870912
+	blockstoreRPC     = "block_store"
+	blockstoreExtRPC  = "block_store_ext"
+	blockAccept       = "koinos.block.accept"
+	blockIrreversible = "koinos.block.irreversible"
+	blockAdded        = "koinos.block_store.block_added"
+	storeReady        = "koinos.block_store.ready"
+	jsonContentType   = "application/json"
+	broadcastQueueLen = 1000
+	encryptionKeyEnv  = "KOINOS_BLOCK_STORE_ENCRYPTION_KEY"
+	amqpUsernameEnv   = "KOINOS_BLOCK_STORE_AMQP_USERNAME"
+	amqpPasswordEnv   = "KOINOS_BLOCK_STORE_AMQP_PASSWORD"
+	appName           = "block_store"
 )
 
+// Database backends with flat options, other backends are only known to the backend registry
+const (
+	badgerBackend   = "badger"
+	postgresBackend = "postgres"
+	segmentBackend  = "segment"
+	remoteBackend   = "remote"
+	shardedBackend  = "sharded"
+)
+
+// Value compression algorithms
+const (
+	compressionNone = "none"
+	compressionZstd = "zstd"
+)
+
+// storeBackend is a database backend which must be closed on shutdown
+type storeBackend interface {
+	bstore.BlockStoreBackend
+	Close()
+}
+
 // Version display values
 const (
 	DisplayAppName = "Koinos Block Store"
@@ -67,9 +235,15 @@ var Commit string
 
 func main() {
 	jobsDefault := runtime.NumCPU()
+	ingestJobsDefault := runtime.NumCPU()
 
 	baseDirPtr := flag.StringP(basedirOption, "d", basedirDefault, "Koinos base directory")
 	amqp := flag.StringP(amqpOption, "a", "", "AMQP server URL")
+	amqpCredentialsFile := flag.String(amqpCredentialsFileOption, "", "File containing the 'username:password' credentials of the AMQP server")
+	amqpCAFile := flag.String(amqpCAFileOption, "", "PEM file of the CAs the AMQP server certificate is verified with (system CAs if empty)")
+	amqpCertFile := flag.String(amqpCertFileOption, "", "PEM client certificate file presented to the AMQP server")
+	amqpKeyFile := flag.String(amqpKeyFileOption, "", "PEM private key file of the AMQP client certificate")
+	amqpServerName := flag.String(amqpServerNameOption, "", "Name the AMQP server certificate is verified against (host of the URL if empty)")
 	reset := flag.BoolP(resetOption, "r", resetDefault, "Reset the database")
 	instanceID := flag.StringP(instanceIDOption, "i", instanceIDDefault, "The instance ID to identify this service")
 	logLevel := flag.StringP(logLevelOption, "l", logLevelDefault, "The log filtering level (debug, info, warning, error)")
@@ -77,7 +251,91 @@ func main() {
 	logColor := flag.Bool(logColorOption, logColorDefault, "Log color toggle")
 	logDatetime := flag.Bool(logDatetimeOption, logDatetimeDefault, "Log datetime on console toggle")
 	jobs := flag.IntP(jobsOption, "j", jobsDefault, "Number of RPC jobs to run")
+	ingestJobs := flag.Int(ingestJobsOption, ingestJobsDefault, "Number of jobs to run for block broadcasts")
 	version := flag.BoolP(versionOption, "v", false, "Print version and exit")
+	checkCompat := flag.Bool(checkCompatOption, false, "Report whether this binary can serve the existing database and exit")
+	rotateKeyFile := flag.String(rotateKeyOption, "", "File containing the new hex encoded key to re-encrypt the Badger database with, then exit")
+	backendType := flag.String(storeBackendOption, "", "The database backend ("+strings.Join(bstore.BackendNames(), ", ")+")")
+	legacyBackendType := flag.String(backendOption, "", "The database backend")
+	postgresURL := flag.String(postgresURLOption, "", "PostgreSQL connection URL of the postgres backend")
+	postgresTable := flag.String(postgresTableOption, "", "PostgreSQL table of the postgres backend")
+	segmentSize := flag.Int(segmentSizeOption, segmentSizeDefault, "Size in MiB at which the segment backend starts a new segment file")
+	compression := flag.String(compressionOption, "", "Compression of newly stored values (none, zstd)")
+	encryptionKeyFile := flag.String(encryptionKeyFileOption, "", "File containing the hex encoded AES key values are encrypted with")
+	checksums := flag.Bool(checksumsOption, checksumsDefault, "Store a checksum with each new value and verify it when read")
+	shardSize := flag.Int(shardSizeOption, shardSizeDefault, "Number of block heights stored in each shard of the sharded backend")
+	coldEndpoint := flag.String(coldEndpointOption, "", "S3 compatible endpoint old blocks are moved to (empty to disable)")
+	coldBucket := flag.String(coldBucketOption, "", "Bucket old blocks are moved to")
+	coldRegion := flag.String(coldRegionOption, "", "Region of the cold storage bucket")
+	coldPrefix := flag.String(coldPrefixOption, "", "Prefix of the cold storage object names")
+	coldDepth := flag.Int(coldDepthOption, coldDepthDefault, "Number of irreversible blocks kept in the local database")
+	remoteAMQP := flag.String(remoteAMQPOption, "", "AMQP server URL of the block store the remote backend stores blocks in")
+	remoteSecretFile := flag.String(remoteSecretFileOption, "", "File containing the admin secret of the remote block store")
+	replicaDir := flag.String(replicaDirOption, "", "Directory of a Badger database writes are replicated to (empty to disable)")
+	replicaEndpoint := flag.String(replicaEndpointOption, "", "S3 compatible endpoint writes are replicated to (empty to disable)")
+	replicaBucket := flag.String(replicaBucketOption, "", "Bucket writes are replicated to")
+	replicaRegion := flag.String(replicaRegionOption, "", "Region of the replica bucket")
+	replicaPrefix := flag.String(replicaPrefixOption, "", "Prefix of the replica object names")
+	replicaReconcile := flag.String(replicaReconcileOption, "", "Interval at which failed replica writes are retried")
+	wal := flag.Bool(walOption, walDefault, "Record writes in a write-ahead log standby block stores can follow")
+	walRetained := flag.Int(walRetainedOption, walRetainedDefault, "Number of 16 MiB write-ahead log segments kept for standbys")
+	standbyAMQP := flag.String(standbyAMQPOption, "", "AMQP server URL of the primary block store to follow as a standby (empty to disable)")
+	standbySecretFile := flag.String(standbySecretFileOption, "", "File containing the admin secret of the primary block store")
+	standbyPoll := flag.String(standbyPollOption, "", "Interval at which a standby asks its primary for new writes")
+	chainIDString := flag.String(chainIDOption, "", "Hex encoded chain ID included in broadcasts and responses")
+	duplicateWindow := flag.String(duplicateWindowOption, "", "Window in which repeated block broadcasts are ignored (0 to disable)")
+	staleHeadAfter := flag.String(staleHeadAfterOption, "", "Time without a new highest block after which the head is reported stale (0 to disable)")
+	dropReceipts := flag.Bool(dropReceiptsOption, dropReceiptsDefault, "Store blocks without their receipts")
+	producers := flag.StringSlice(producersOption, []string{}, "If set, only blocks signed by these producer addresses are stored")
+	maxHeight := flag.Int(maxHeightOption, maxHeightDefault, "Do not store blocks above this height (0 to disable)")
+	readOnly := flag.Bool(readOnlyOption, readOnlyDefault, "Serve queries only, refusing AddBlock and ignoring block broadcasts")
+	resync := flag.Bool(resyncOption, resyncDefault, "On start, request the blocks missing up to the head of the chain from the other block stores")
+	resyncBatchSize := flag.Int(resyncBatchSizeOption, resyncBatchSizeDefault, "Number of blocks requested at once when resyncing")
+	backupDir := flag.String(backupDirOption, "", "The directory backups are written to")
+	exportDir := flag.String(exportDirOption, "", "The directory chain exports are written to")
+	checkpointFile := flag.String(checkpointFileOption, "", "Trusted checkpoint to bootstrap an empty database from")
+	logPayload := flag.String(logPayloadOption, "", "How request payloads are written to the debug log (full, digest)")
+	captureDir := flag.String(captureDirOption, "", "If set, the directory requests, responses and broadcasts are captured to")
+	captureFileSize := flag.Int(captureFileSizeOption, captureFileSizeDefault, "Size in MiB at which a new capture file is started")
+	captureFiles := flag.Int(captureFilesOption, captureFilesDefault, "Number of capture files to keep (0 to keep all)")
+	maxMessageSize := flag.Int(maxMessageSizeOption, maxMessageSizeDefault, "Maximum size of a response message in bytes")
+	maxBlocksByHeight := flag.Int(maxBlocksByHeightOption, maxBlocksByHeightDefault, "Maximum number of blocks per request by height")
+	maxBlocksByID := flag.Int(maxBlocksByIDOption, maxBlocksByIDDefault, "Maximum number of blocks per request by ID")
+	rateLimit := flag.Int(rateLimitOption, rateLimitDefault, "Requests per second served to each client (0 to disable)")
+	rateLimitBurst := flag.Int(rateLimitBurstOption, rateLimitBurstDefault, "Requests served to each client at once (0 for one second of requests)")
+	requestTimeout := flag.String(requestTimeoutOption, "", "Time after which a query which has not completed fails (0 to disable)")
+	errorJournalSize := flag.Int(errorJournalSizeOption, errorJournalSizeDefault, "Number of request errors kept in the error journal (0 to disable)")
+	cacheSizeMin := flag.Int(cacheSizeMinOption, cacheSizeMinDefault, "Minimum size in MiB of the record cache")
+	cacheSizeMax := flag.Int(cacheSizeMaxOption, cacheSizeMaxDefault, "Maximum size in MiB of the record cache, which is enabled when set")
+	cacheTuneInterval := flag.String(cacheTuneIntervalOption, "", "Interval at which the record cache is resized")
+	diskMinFree := flag.Int(diskMinFreeOption, diskMinFreeDefault, "Free space in MiB on the database volume below which writes are refused (0 to disable)")
+	diskCheckInterval := flag.String(diskCheckIntervalOption, "", "Interval at which the free space on the database volume is checked")
+	memoryLimit := flag.Int(memoryLimitOption, 0, "Soft memory limit in MiB, Badger caches are sized from it (0 to disable)")
+	allowRestore := flag.Bool(allowRestoreOption, allowRestoreDefault, "Allow the database to be replaced from a backup over RPC")
+	adminSecretFile := flag.String(adminSecretFileOption, "", "File containing the shared secret which authorizes admin requests")
+	adminAllowlist := flag.StringSlice(adminAllowlistOption, []string{}, "Admin requests which are served without the admin secret")
+	producerPolicy := flag.String(producerPolicyOption, "", "Handling of messages from producers with a newer schema (accept, warn, reject)")
+	strict := flag.Bool(strictOption, strictDefault, "Reject requests containing unknown fields instead of ignoring them")
+	checkpointSigner := flag.String(checkpointSignerOption, "", "Address which must have signed the checkpoint file")
+	checkpointInterval := flag.Int(checkpointIntervalOption, 0, "Publish a signed checkpoint every N irreversible blocks (0 to disable)")
+	checkpointDir := flag.String(checkpointDirOption, "", "The directory published checkpoints are written to")
+	checkpointURL := flag.String(checkpointURLOption, "", "Base URL published checkpoints are uploaded to with HTTP PUT")
+	checkpointKeyFile := flag.String(checkpointKeyFileOption, "", "WIF private key file used to sign published checkpoints")
+	metricsPushURL := flag.String(metricsPushURLOption, "", "Prometheus Pushgateway URL metrics are pushed to")
+	metricsJob := flag.String(metricsJobOption, "", "Pushgateway job name metrics are grouped under")
+	metricsStatsD := flag.String(metricsStatsDOption, "", "StatsD host:port metrics are sent to over UDP")
+	metricsStatsDPrefix := flag.String(metricsStatsDPrefixOption, "", "Prefix of the metric names sent to StatsD")
+	metricsInterval := flag.String(metricsIntervalOption, "", "Interval at which metrics are pushed")
+	pprofAddr := flag.String(pprofAddrOption, "", "host:port runtime profiles are served on over HTTP (disabled if empty)")
+	healthAddr := flag.String(healthAddrOption, "", "host:port the /healthz and /readyz endpoints are served on (disabled if empty)")
+
+	_ = flag.CommandLine.MarkDeprecated(backendOption, "use --"+storeBackendOption+" instead")
+
+	cmdName, cmd := parseCommand()
+	var run func(handler *bstore.RequestHandler) int
+	if cmd != nil {
+		run = cmd.Init(flag.CommandLine)
+	}
 
 	flag.Parse()
 
@@ -95,6 +353,11 @@ func main() {
 	yamlConfig := util.InitYamlConfig(baseDir)
 
 	*amqp = util.GetStringOption(amqpOption, amqpDefault, *amqp, yamlConfig.BlockStore, yamlConfig.Global)
+	*amqpCredentialsFile = util.GetStringOption(amqpCredentialsFileOption, "", *amqpCredentialsFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*amqpCAFile = util.GetStringOption(amqpCAFileOption, "", *amqpCAFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*amqpCertFile = util.GetStringOption(amqpCertFileOption, "", *amqpCertFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*amqpKeyFile = util.GetStringOption(amqpKeyFileOption, "", *amqpKeyFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*amqpServerName = util.GetStringOption(amqpServerNameOption, "", *amqpServerName, yamlConfig.BlockStore, yamlConfig.Global)
 	*logLevel = util.GetStringOption(logLevelOption, logLevelDefault, *logLevel, yamlConfig.BlockStore, yamlConfig.Global)
 	*logDir = util.GetStringOption(logDirOption, *logDir, *logDir, yamlConfig.BlockStore, yamlConfig.Global)
 	*logColor = util.GetBoolOption(logColorOption, logColorDefault, *logColor, yamlConfig.BlockStore, yamlConfig.Global)
@@ -102,43 +365,582 @@ func main() {
 	*instanceID = util.GetStringOption(instanceIDOption, util.GenerateBase58ID(5), *instanceID, yamlConfig.BlockStore, yamlConfig.Global)
 	*reset = util.GetBoolOption(resetOption, resetDefault, *reset, yamlConfig.BlockStore, yamlConfig.Global)
 	*jobs = util.GetIntOption(jobsOption, jobsDefault, *jobs, yamlConfig.BlockStore, yamlConfig.Global)
+	*ingestJobs = util.GetIntOption(ingestJobsOption, ingestJobsDefault, *ingestJobs, yamlConfig.BlockStore, yamlConfig.Global)
+	*backendType = util.GetStringOption(storeBackendOption, "", *backendType, yamlConfig.BlockStore, yamlConfig.Global)
+	if len(*backendType) == 0 {
+		// backend was the name of the option before backends were registered
+		*backendType = util.GetStringOption(backendOption, backendDefault, *legacyBackendType, yamlConfig.BlockStore, yamlConfig.Global)
+	}
+	*postgresURL = util.GetStringOption(postgresURLOption, "", *postgresURL, yamlConfig.BlockStore, yamlConfig.Global)
+	*postgresTable = util.GetStringOption(postgresTableOption, postgresTableDefault, *postgresTable, yamlConfig.BlockStore, yamlConfig.Global)
+	*segmentSize = util.GetIntOption(segmentSizeOption, segmentSizeDefault, *segmentSize, yamlConfig.BlockStore, yamlConfig.Global)
+	*compression = util.GetStringOption(compressionOption, compressionDefault, *compression, yamlConfig.BlockStore, yamlConfig.Global)
+	*encryptionKeyFile = util.GetStringOption(encryptionKeyFileOption, "", *encryptionKeyFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*checksums = util.GetBoolOption(checksumsOption, checksumsDefault, *checksums, yamlConfig.BlockStore, yamlConfig.Global)
+	*shardSize = util.GetIntOption(shardSizeOption, shardSizeDefault, *shardSize, yamlConfig.BlockStore, yamlConfig.Global)
+	*coldEndpoint = util.GetStringOption(coldEndpointOption, "", *coldEndpoint, yamlConfig.BlockStore, yamlConfig.Global)
+	*coldBucket = util.GetStringOption(coldBucketOption, "", *coldBucket, yamlConfig.BlockStore, yamlConfig.Global)
+	*coldRegion = util.GetStringOption(coldRegionOption, coldRegionDefault, *coldRegion, yamlConfig.BlockStore, yamlConfig.Global)
+	*coldPrefix = util.GetStringOption(coldPrefixOption, "", *coldPrefix, yamlConfig.BlockStore, yamlConfig.Global)
+	*coldDepth = util.GetIntOption(coldDepthOption, coldDepthDefault, *coldDepth, yamlConfig.BlockStore, yamlConfig.Global)
+	*remoteAMQP = util.GetStringOption(remoteAMQPOption, "", *remoteAMQP, yamlConfig.BlockStore, yamlConfig.Global)
+	*remoteSecretFile = util.GetStringOption(remoteSecretFileOption, "", *remoteSecretFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*replicaDir = util.GetStringOption(replicaDirOption, "", *replicaDir, yamlConfig.BlockStore, yamlConfig.Global)
+	*replicaEndpoint = util.GetStringOption(replicaEndpointOption, "", *replicaEndpoint, yamlConfig.BlockStore, yamlConfig.Global)
+	*replicaBucket = util.GetStringOption(replicaBucketOption, "", *replicaBucket, yamlConfig.BlockStore, yamlConfig.Global)
+	*replicaRegion = util.GetStringOption(replicaRegionOption, replicaRegionDefault, *replicaRegion, yamlConfig.BlockStore, yamlConfig.Global)
+	*replicaPrefix = util.GetStringOption(replicaPrefixOption, "", *replicaPrefix, yamlConfig.BlockStore, yamlConfig.Global)
+	*replicaReconcile = util.GetStringOption(replicaReconcileOption, replicaReconcileDefault, *replicaReconcile, yamlConfig.BlockStore, yamlConfig.Global)
+	*wal = util.GetBoolOption(walOption, walDefault, *wal, yamlConfig.BlockStore, yamlConfig.Global)
+	*walRetained = util.GetIntOption(walRetainedOption, walRetainedDefault, *walRetained, yamlConfig.BlockStore, yamlConfig.Global)
+	*standbyAMQP = util.GetStringOption(standbyAMQPOption, "", *standbyAMQP, yamlConfig.BlockStore, yamlConfig.Global)
+	*standbySecretFile = util.GetStringOption(standbySecretFileOption, "", *standbySecretFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*standbyPoll = util.GetStringOption(standbyPollOption, standbyPollDefault, *standbyPoll, yamlConfig.BlockStore, yamlConfig.Global)
+	*chainIDString = util.GetStringOption(chainIDOption, "", *chainIDString, yamlConfig.BlockStore, yamlConfig.Global)
+	*duplicateWindow = util.GetStringOption(duplicateWindowOption, duplicateWindowDefault, *duplicateWindow, yamlConfig.BlockStore, yamlConfig.Global)
+	*staleHeadAfter = util.GetStringOption(staleHeadAfterOption, staleHeadAfterDefault, *staleHeadAfter, yamlConfig.BlockStore, yamlConfig.Global)
+	*dropReceipts = util.GetBoolOption(dropReceiptsOption, dropReceiptsDefault, *dropReceipts, yamlConfig.BlockStore, yamlConfig.Global)
+	*producers = util.GetStringSliceOption(producersOption, *producers, yamlConfig.BlockStore, yamlConfig.Global)
+	*maxHeight = util.GetIntOption(maxHeightOption, maxHeightDefault, *maxHeight, yamlConfig.BlockStore, yamlConfig.Global)
+	*readOnly = util.GetBoolOption(readOnlyOption, readOnlyDefault, *readOnly, yamlConfig.BlockStore, yamlConfig.Global)
+	*resync = util.GetBoolOption(resyncOption, resyncDefault, *resync, yamlConfig.BlockStore, yamlConfig.Global)
+	*resyncBatchSize = util.GetIntOption(resyncBatchSizeOption, resyncBatchSizeDefault, *resyncBatchSize, yamlConfig.BlockStore, yamlConfig.Global)
+	*backupDir = util.GetStringOption(backupDirOption, backupDirDefault, *backupDir, yamlConfig.BlockStore, yamlConfig.Global)
+	*exportDir = util.GetStringOption(exportDirOption, exportDirDefault, *exportDir, yamlConfig.BlockStore, yamlConfig.Global)
+	*checkpointFile = util.GetStringOption(checkpointFileOption, "", *checkpointFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*logPayload = util.GetStringOption(logPayloadOption, logPayloadDefault, *logPayload, yamlConfig.BlockStore, yamlConfig.Global)
+	*captureDir = util.GetStringOption(captureDirOption, "", *captureDir, yamlConfig.BlockStore, yamlConfig.Global)
+	*captureFileSize = util.GetIntOption(captureFileSizeOption, captureFileSizeDefault, *captureFileSize, yamlConfig.BlockStore, yamlConfig.Global)
+	*captureFiles = util.GetIntOption(captureFilesOption, captureFilesDefault, *captureFiles, yamlConfig.BlockStore, yamlConfig.Global)
+	*maxMessageSize = util.GetIntOption(maxMessageSizeOption, maxMessageSizeDefault, *maxMessageSize, yamlConfig.BlockStore, yamlConfig.Global)
+	*maxBlocksByHeight = util.GetIntOption(maxBlocksByHeightOption, maxBlocksByHeightDefault, *maxBlocksByHeight, yamlConfig.BlockStore, yamlConfig.Global)
+	*maxBlocksByID = util.GetIntOption(maxBlocksByIDOption, maxBlocksByIDDefault, *maxBlocksByID, yamlConfig.BlockStore, yamlConfig.Global)
+	*rateLimit = util.GetIntOption(rateLimitOption, rateLimitDefault, *rateLimit, yamlConfig.BlockStore, yamlConfig.Global)
+	*rateLimitBurst = util.GetIntOption(rateLimitBurstOption, rateLimitBurstDefault, *rateLimitBurst, yamlConfig.BlockStore, yamlConfig.Global)
+	*requestTimeout = util.GetStringOption(requestTimeoutOption, requestTimeoutDefault, *requestTimeout, yamlConfig.BlockStore, yamlConfig.Global)
+	*errorJournalSize = util.GetIntOption(errorJournalSizeOption, errorJournalSizeDefault, *errorJournalSize, yamlConfig.BlockStore, yamlConfig.Global)
+	*cacheSizeMin = util.GetIntOption(cacheSizeMinOption, cacheSizeMinDefault, *cacheSizeMin, yamlConfig.BlockStore, yamlConfig.Global)
+	*cacheSizeMax = util.GetIntOption(cacheSizeMaxOption, cacheSizeMaxDefault, *cacheSizeMax, yamlConfig.BlockStore, yamlConfig.Global)
+	*cacheTuneInterval = util.GetStringOption(cacheTuneIntervalOption, cacheTuneIntervalDefault, *cacheTuneInterval, yamlConfig.BlockStore, yamlConfig.Global)
+	*diskMinFree = util.GetIntOption(diskMinFreeOption, diskMinFreeDefault, *diskMinFree, yamlConfig.BlockStore, yamlConfig.Global)
+	*diskCheckInterval = util.GetStringOption(diskCheckIntervalOption, diskCheckIntervalDefault, *diskCheckInterval, yamlConfig.BlockStore, yamlConfig.Global)
+	*memoryLimit = util.GetIntOption(memoryLimitOption, memoryLimitDefault, *memoryLimit, yamlConfig.BlockStore, yamlConfig.Global)
+	*allowRestore = util.GetBoolOption(allowRestoreOption, allowRestoreDefault, *allowRestore, yamlConfig.BlockStore, yamlConfig.Global)
+	*adminSecretFile = util.GetStringOption(adminSecretFileOption, "", *adminSecretFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*adminAllowlist = util.GetStringSliceOption(adminAllowlistOption, *adminAllowlist, yamlConfig.BlockStore, yamlConfig.Global)
+	*producerPolicy = util.GetStringOption(producerPolicyOption, producerPolicyDefault, *producerPolicy, yamlConfig.BlockStore, yamlConfig.Global)
+	*strict = util.GetBoolOption(strictOption, strictDefault, *strict, yamlConfig.BlockStore, yamlConfig.Global)
+	*checkpointSigner = util.GetStringOption(checkpointSignerOption, "", *checkpointSigner, yamlConfig.BlockStore, yamlConfig.Global)
+	*checkpointInterval = util.GetIntOption(checkpointIntervalOption, checkpointIntervalDefault, *checkpointInterval, yamlConfig.BlockStore, yamlConfig.Global)
+	*checkpointDir = util.GetStringOption(checkpointDirOption, checkpointDirDefault, *checkpointDir, yamlConfig.BlockStore, yamlConfig.Global)
+	*checkpointURL = util.GetStringOption(checkpointURLOption, "", *checkpointURL, yamlConfig.BlockStore, yamlConfig.Global)
+	*checkpointKeyFile = util.GetStringOption(checkpointKeyFileOption, "", *checkpointKeyFile, yamlConfig.BlockStore, yamlConfig.Global)
+	*metricsPushURL = util.GetStringOption(metricsPushURLOption, "", *metricsPushURL, yamlConfig.BlockStore, yamlConfig.Global)
+	*metricsJob = util.GetStringOption(metricsJobOption, metricsJobDefault, *metricsJob, yamlConfig.BlockStore, yamlConfig.Global)
+	*metricsStatsD = util.GetStringOption(metricsStatsDOption, "", *metricsStatsD, yamlConfig.BlockStore, yamlConfig.Global)
+	*metricsStatsDPrefix = util.GetStringOption(metricsStatsDPrefixOption, metricsStatsDPrefixDefault, *metricsStatsDPrefix, yamlConfig.BlockStore, yamlConfig.Global)
+	*metricsInterval = util.GetStringOption(metricsIntervalOption, metricsIntervalDefault, *metricsInterval, yamlConfig.BlockStore, yamlConfig.Global)
+	*pprofAddr = util.GetStringOption(pprofAddrOption, "", *pprofAddr, yamlConfig.BlockStore, yamlConfig.Global)
+	*healthAddr = util.GetStringOption(healthAddrOption, "", *healthAddr, yamlConfig.BlockStore, yamlConfig.Global)
 
 	if len(*logDir) > 0 && !path.IsAbs(*logDir) {
 		*logDir = path.Join(util.GetAppDir(baseDir, appName), *logDir)
 	}
 
+	if !path.IsAbs(*backupDir) {
+		*backupDir = path.Join(util.GetAppDir(baseDir, appName), *backupDir)
+	}
+
+	if !path.IsAbs(*exportDir) {
+		*exportDir = path.Join(util.GetAppDir(baseDir, appName), *exportDir)
+	}
+
+	if len(*replicaDir) > 0 && !path.IsAbs(*replicaDir) {
+		*replicaDir = path.Join(util.GetAppDir(baseDir, appName), *replicaDir)
+	}
+
+	if len(*captureDir) > 0 && !path.IsAbs(*captureDir) {
+		*captureDir = path.Join(util.GetAppDir(baseDir, appName), *captureDir)
+	}
+
+	if !path.IsAbs(*checkpointDir) {
+		*checkpointDir = path.Join(util.GetAppDir(baseDir, appName), *checkpointDir)
+	}
+
 	err = log.InitLogger(appName, *instanceID, *logLevel, *logDir, *logColor, *logDatetime)
 	if err != nil {
 		fmt.Printf("Invalid log-level: %s. Please choose one of: debug, info, warning, error", *logLevel)
 		os.Exit(1)
 	}
+	bstore.RedactAMQPLogs()
 
 	log.Info(makeVersionString())
 
+	// Profiles are served from the start, so a stalled startup or migration can be profiled as well
+	if len(*pprofAddr) > 0 {
+		if err = startPprofServer(*pprofAddr); err != nil {
+			log.Errorf("Could not serve profiles on %s, %s", *pprofAddr, err.Error())
+			os.Exit(1)
+		}
+	}
+
 	if *jobs < 1 {
 		log.Errorf("Option '%v' must be greater than 0 (was %v)", jobsOption, *jobs)
 		os.Exit(1)
 	}
 
-	// Costruct the db directory and ensure it exists
-	dbDir := path.Join(util.GetAppDir((baseDir), appName), "db")
-	err = util.EnsureDir(dbDir)
+	if *ingestJobs < 1 {
+		log.Errorf("Option '%v' must be greater than 0 (was %v)", ingestJobsOption, *ingestJobs)
+		os.Exit(1)
+	}
+
+	duplicateWindowDuration, err := time.ParseDuration(*duplicateWindow)
+	if err != nil || duplicateWindowDuration < 0 {
+		log.Errorf("Option '%v' must be a non-negative duration (was %v)", duplicateWindowOption, *duplicateWindow)
+		os.Exit(1)
+	}
+
+	staleHeadAfterDuration, err := time.ParseDuration(*staleHeadAfter)
+	if err != nil || staleHeadAfterDuration < 0 {
+		log.Errorf("Option '%v' must be a non-negative duration (was %v)", staleHeadAfterOption, *staleHeadAfter)
+		os.Exit(1)
+	}
+
+	metricsIntervalDuration, err := time.ParseDuration(*metricsInterval)
+	if err != nil || metricsIntervalDuration <= 0 {
+		log.Errorf("Option '%v' must be a positive duration (was %v)", metricsIntervalOption, *metricsInterval)
+		os.Exit(1)
+	}
+
+	backendRegistration, ok := bstore.LookupBackend(*backendType)
+	if !ok {
+		log.Errorf("Option '%v' must be one of %s (was %v)", storeBackendOption, strings.Join(bstore.BackendNames(), ", "), *backendType)
+		os.Exit(1)
+	}
+
+	backendOptions, err := backendConfigBlock(yamlConfig.BlockStore, *backendType)
+	if err != nil {
+		log.Errorf("Option '%v' %s", *backendType, err.Error())
+		os.Exit(1)
+	}
+
+	// The flat backend options predate the backend blocks, they apply to the options the block does not
+	// set, or if given on the command line
+	for _, legacy := range []struct {
+		backend string
+		option  string
+		key     string
+		value   interface{}
+		set     bool
+	}{
+		{postgresBackend, postgresURLOption, "url", *postgresURL, len(*postgresURL) > 0},
+		{postgresBackend, postgresTableOption, "table", *postgresTable, true},
+		{segmentBackend, segmentSizeOption, "segment-size", *segmentSize, true},
+		{shardedBackend, shardSizeOption, "shard-size", *shardSize, true},
+		{remoteBackend, remoteAMQPOption, "amqp", *remoteAMQP, len(*remoteAMQP) > 0},
+		{remoteBackend, remoteSecretFileOption, "secret-file", *remoteSecretFile, len(*remoteSecretFile) > 0},
+	} {
+		if legacy.backend != *backendType || !legacy.set {
+			continue
+		}
+		if _, ok := backendOptions[legacy.key]; !ok || flag.CommandLine.Changed(legacy.option) {
+			backendOptions[legacy.key] = legacy.value
+		}
+	}
+
+	if *compression != compressionNone && *compression != compressionZstd {
+		log.Errorf("Option '%v' must be one of %s, %s (was %v)", compressionOption, compressionNone, compressionZstd, *compression)
+		os.Exit(1)
+	}
+
+	if len(*coldEndpoint) > 0 && len(*coldBucket) == 0 {
+		log.Errorf("Option '%v' is required with '%v'", coldBucketOption, coldEndpointOption)
+		os.Exit(1)
+	}
+
+	if len(*replicaEndpoint) > 0 && len(*replicaBucket) == 0 {
+		log.Errorf("Option '%v' is required with '%v'", replicaBucketOption, replicaEndpointOption)
+		os.Exit(1)
+	}
+
+	if *coldDepth < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", coldDepthOption, *coldDepth)
+		os.Exit(1)
+	}
+
+	if *maxHeight < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", maxHeightOption, *maxHeight)
+		os.Exit(1)
+	}
+
+	var ingestFilters []bstore.IngestFilter
+	if len(*producers) > 0 {
+		producerFilter, err := bstore.NewProducerFilter(*producers)
+		if err != nil {
+			log.Errorf("Option '%v' is invalid, %s", producersOption, err.Error())
+			os.Exit(1)
+		}
+		ingestFilters = append(ingestFilters, producerFilter)
+	}
+	if *maxHeight > 0 {
+		ingestFilters = append(ingestFilters, &bstore.MaxHeightFilter{MaxHeight: uint64(*maxHeight)})
+	}
+	if *dropReceipts {
+		ingestFilters = append(ingestFilters, &bstore.DropReceiptsFilter{})
+	}
+
+	chainID, err := hex.DecodeString(strings.TrimPrefix(*chainIDString, "0x"))
+	if err != nil {
+		log.Errorf("Option '%v' must be a hex string (was %v)", chainIDOption, *chainIDString)
+		os.Exit(1)
+	}
+
+	incompatiblePolicy, err := bstore.ParseProducerPolicy(*producerPolicy)
+	if err != nil {
+		log.Errorf("Option '%v' is invalid, %s", producerPolicyOption, err.Error())
+		os.Exit(1)
+	}
+
+	if *maxMessageSize <= 0 {
+		log.Errorf("Option '%v' must be greater than 0 (was %v)", maxMessageSizeOption, *maxMessageSize)
+		os.Exit(1)
+	}
+
+	if *maxBlocksByHeight <= 0 {
+		log.Errorf("Option '%v' must be greater than 0 (was %v)", maxBlocksByHeightOption, *maxBlocksByHeight)
+		os.Exit(1)
+	}
+
+	if *maxBlocksByID <= 0 {
+		log.Errorf("Option '%v' must be greater than 0 (was %v)", maxBlocksByIDOption, *maxBlocksByID)
+		os.Exit(1)
+	}
+
+	if *rateLimit < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", rateLimitOption, *rateLimit)
+		os.Exit(1)
+	}
+
+	if *rateLimitBurst < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", rateLimitBurstOption, *rateLimitBurst)
+		os.Exit(1)
+	}
+
+	requestTimeoutDuration, err := time.ParseDuration(*requestTimeout)
+	if err != nil || requestTimeoutDuration < 0 {
+		log.Errorf("Option '%v' must be a non-negative duration (was %v)", requestTimeoutOption, *requestTimeout)
+		os.Exit(1)
+	}
+
+	if *errorJournalSize < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", errorJournalSizeOption, *errorJournalSize)
+		os.Exit(1)
+	}
+
+	if *cacheSizeMax < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", cacheSizeMaxOption, *cacheSizeMax)
+		os.Exit(1)
+	}
+
+	if *cacheSizeMax > 0 && (*cacheSizeMin < 0 || *cacheSizeMin > *cacheSizeMax) {
+		log.Errorf("Option '%v' must be between 0 and %v (was %v)", cacheSizeMinOption, cacheSizeMaxOption, *cacheSizeMin)
+		os.Exit(1)
+	}
+
+	cacheTuneIntervalDuration, err := time.ParseDuration(*cacheTuneInterval)
+	if err != nil || cacheTuneIntervalDuration <= 0 {
+		log.Errorf("Option '%v' must be a positive duration (was %v)", cacheTuneIntervalOption, *cacheTuneInterval)
+		os.Exit(1)
+	}
+
+	if *diskMinFree < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", diskMinFreeOption, *diskMinFree)
+		os.Exit(1)
+	}
+
+	diskCheckIntervalDuration, err := time.ParseDuration(*diskCheckInterval)
+	if err != nil || diskCheckIntervalDuration <= 0 {
+		log.Errorf("Option '%v' must be a positive duration (was %v)", diskCheckIntervalOption, *diskCheckInterval)
+		os.Exit(1)
+	}
+
+	replicaReconcileDuration, err := time.ParseDuration(*replicaReconcile)
+	if err != nil || replicaReconcileDuration <= 0 {
+		log.Errorf("Option '%v' must be a positive duration (was %v)", replicaReconcileOption, *replicaReconcile)
+		os.Exit(1)
+	}
+
+	if *walRetained <= 0 {
+		log.Errorf("Option '%v' must be greater than 0 (was %v)", walRetainedOption, *walRetained)
+		os.Exit(1)
+	}
+
+	standbyPollDuration, err := time.ParseDuration(*standbyPoll)
+	if err != nil || standbyPollDuration <= 0 {
+		log.Errorf("Option '%v' must be a positive duration (was %v)", standbyPollOption, *standbyPoll)
+		os.Exit(1)
+	}
+
+	// A standby applies the log of its primary to its own log, so it can be promoted
+	if len(*standbyAMQP) > 0 {
+		*wal = true
+	}
+
+	if *resync && (*readOnly || len(*standbyAMQP) > 0) {
+		log.Errorf("Option '%v' cannot be used with '%v' or '%v'", resyncOption, readOnlyOption, standbyAMQPOption)
+		os.Exit(1)
+	}
+
+	if *resyncBatchSize <= 0 {
+		log.Errorf("Option '%v' must be greater than 0 (was %v)", resyncBatchSizeOption, *resyncBatchSize)
+		os.Exit(1)
+	}
+
+	if *memoryLimit < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", memoryLimitOption, *memoryLimit)
+		os.Exit(1)
+	}
+
+	// A limit set with GOMEMLIMIT is honored when the option is not set
+	memoryLimitBytes := debug.SetMemoryLimit(-1)
+	if *memoryLimit > 0 {
+		memoryLimitBytes = int64(*memoryLimit) * 1024 * 1024
+		debug.SetMemoryLimit(memoryLimitBytes)
+	}
+
+	payloadLogMode, err := bstore.ParsePayloadLogMode(*logPayload)
 	if err != nil {
-		log.Errorf("Could not create database folder %v", dbDir)
+		log.Errorf("Option '%v' is invalid, %s", logPayloadOption, err.Error())
 		os.Exit(1)
 	}
 
-	log.Infof("Opening database at %s", dbDir)
+	var capture *bstore.PayloadCapture
+	if len(*captureDir) > 0 {
+		capture, err = bstore.NewPayloadCapture(*captureDir, int64(*captureFileSize)*1024*1024, *captureFiles)
+		if err != nil {
+			log.Errorf("Could not start capture to %v, %s", *captureDir, err.Error())
+			os.Exit(1)
+		}
+		log.Infof("Capturing requests and broadcasts to %s", *captureDir)
+	}
+
+	var errorJournal *bstore.ErrorJournal
+	if *errorJournalSize > 0 {
+		journalPath := path.Join(util.GetAppDir(baseDir, appName), bstore.ErrorJournalFile)
+		errorJournal, err = bstore.NewErrorJournal(journalPath, *errorJournalSize, bstore.DefaultErrorSampleInterval)
+		if err != nil {
+			log.Errorf("Could not open error journal %v, %s", journalPath, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// captureRequest records a request if capture is enabled, returning the sequence number of its response
+	captureRequest := func(source string, data []byte) uint64 {
+		if capture == nil {
+			return 0
+		}
+		seq, err := capture.CaptureRequest(source, data)
+		if err != nil {
+			log.Warnf("Unable to capture %s request: %s", source, err)
+		}
+		return seq
+	}
+
+	captureResponse := func(seq uint64, source string, data []byte) {
+		if capture == nil {
+			return
+		}
+		if err := capture.CaptureResponse(seq, source, data); err != nil {
+			log.Warnf("Unable to capture %s response: %s", source, err)
+		}
+	}
+
+	captureBroadcast := func(topic string, data []byte) {
+		if capture == nil {
+			return
+		}
+		if err := capture.CaptureBroadcast(topic, data); err != nil {
+			log.Warnf("Unable to capture %s broadcast: %s", topic, err)
+		}
+	}
 
-	var opts = badger.DefaultOptions(dbDir)
-	opts.Logger = bstore.KoinosBadgerLogger{}
-	backend, err := bstore.NewBadgerBackend(opts)
+	var adminSecret string
+	if len(*adminSecretFile) > 0 {
+		data, err := os.ReadFile(*adminSecretFile)
+		if err != nil {
+			log.Errorf("Option '%v' must be a readable file, %s", adminSecretFileOption, err.Error())
+			os.Exit(1)
+		}
 
+		adminSecret = strings.TrimSpace(string(data))
+		if len(adminSecret) == 0 {
+			log.Errorf("Admin secret file %v is empty", *adminSecretFile)
+			os.Exit(1)
+		}
+	}
+
+	var standbySecret string
+	if len(*standbySecretFile) > 0 {
+		data, err := os.ReadFile(*standbySecretFile)
+		if err != nil {
+			log.Errorf("Option '%v' must be a readable file, %s", standbySecretFileOption, err.Error())
+			os.Exit(1)
+		}
+
+		standbySecret = strings.TrimSpace(string(data))
+	}
+
+	// The key file takes precedence over the environment
+	var encryptionKey []byte
+	encodedKey := os.Getenv(encryptionKeyEnv)
+	if len(*encryptionKeyFile) > 0 {
+		data, err := os.ReadFile(*encryptionKeyFile)
+		if err != nil {
+			log.Errorf("Option '%v' must be a readable file, %s", encryptionKeyFileOption, err.Error())
+			os.Exit(1)
+		}
+		encodedKey = string(data)
+	}
+	if len(encodedKey) > 0 {
+		encryptionKey, err = bstore.ParseEncryptionKey(encodedKey)
+		if err != nil {
+			log.Errorf("Invalid encryption key, %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	adminRequests := make(map[string]bool)
+	for _, name := range bstore.AdminRequestNames() {
+		adminRequests[name] = true
+	}
+	for _, name := range *adminAllowlist {
+		if !adminRequests[name] {
+			log.Errorf("Option '%v' contains unknown admin request '%v'", adminAllowlistOption, name)
+			os.Exit(1)
+		}
+	}
+
+	if *checkpointInterval < 0 {
+		log.Errorf("Option '%v' must not be negative (was %v)", checkpointIntervalOption, *checkpointInterval)
+		os.Exit(1)
+	}
+
+	var checkpointKey []byte
+	if *checkpointInterval > 0 {
+		wif, err := os.ReadFile(*checkpointKeyFile)
+		if err != nil {
+			log.Errorf("Option '%v' must be a readable key file when publishing checkpoints, %s", checkpointKeyFileOption, err.Error())
+			os.Exit(1)
+		}
+
+		checkpointKey, err = util.DecodeWIF(strings.TrimSpace(string(wif)))
+		if err != nil {
+			log.Errorf("Could not decode checkpoint key, %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Costruct the db directory and ensure it exists, each local backend uses its own directory
+	var dbDir string
+	if backendRegistration.Local {
+		dbDirName := "db"
+		if *backendType != badgerBackend {
+			dbDirName = *backendType
+		}
+		dbDir = path.Join(util.GetAppDir((baseDir), appName), dbDirName)
+		err = util.EnsureDir(dbDir)
+		if err != nil {
+			log.Errorf("Could not create database folder %v", dbDir)
+			os.Exit(1)
+		}
+
+		log.Infof("Opening database at %s", dbDir)
+	}
+
+	var budget *bstore.MemoryBudget
+	if memoryLimitBytes < math.MaxInt64 {
+		budget = bstore.NewMemoryBudget(memoryLimitBytes)
+		log.Infof("Memory limit is %d MiB, using %d MiB for database caches", memoryLimitBytes>>20, budget.Total()>>20)
+	}
+
+	backendConfig := &bstore.BackendConfig{
+		Dir:      dbDir,
+		Budget:   budget,
+		ReadOnly: *checkCompat || (cmd != nil && cmd.ReadOnly),
+		Options:  backendOptions,
+	}
+
+	if len(*rotateKeyFile) > 0 {
+		os.Exit(rotateEncryptionKey(*backendType, backendConfig, *rotateKeyFile))
+	}
+
+	var backend storeBackend
+	backend, err = backendRegistration.Open(backendConfig)
 	if err != nil {
-		log.Errorf("Could not open database, %s", err.Error())
+		log.Errorf("Could not open %s database, %s", *backendType, err.Error())
 		os.Exit(1)
 	}
 
+	// Storage latency is measured on the database itself, below encryption, compression and caching
+	metrics := bstore.NewMetrics()
+	backend = bstore.NewMetricsBackend(backend, metrics)
+
+	// Writes are refused before the volume of a local database runs out of space
+	if backendRegistration.Local && *diskMinFree > 0 && !backendConfig.ReadOnly {
+		backend = bstore.NewDiskGuardBackend(backend, backendConfig.Dirs(), uint64(*diskMinFree)<<20, diskCheckIntervalDuration)
+	}
+
+	if encryptionKey != nil {
+		backend, err = bstore.NewEncryptedBackend(backend, encryptionKey)
+		if err != nil {
+			log.Errorf("Could not initialize encryption, %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Checksums cover the values as compressed. Checksummed values are verified even with checksums
+	// disabled, so they can be turned off again
+	backend = bstore.NewChecksumBackend(backend, *checksums)
+
+	// Values are compressed before they are encrypted. Compressed values are read even with compression
+	// disabled, so it can be turned off again
+	backend, err = bstore.NewCompressedBackend(backend, *compression == compressionZstd)
+	if err != nil {
+		log.Errorf("Could not initialize compression, %s", err.Error())
+		os.Exit(1)
+	}
+
+	if *checkCompat {
+		os.Exit(checkCompatibility(backend))
+	}
+
+	if cmd != nil {
+		os.Exit(runCommand(cmdName, cmd, run, backend))
+	}
+
+	// Replicas receive the values as stored, compressed and encrypted
+	var replicating *bstore.ReplicatingBackend
+	var secondaries []bstore.BlockStoreBackend
+	if len(*replicaDir) > 0 {
+		opts := badger.DefaultOptions(*replicaDir)
+		opts.Logger = bstore.KoinosBadgerLogger{}
+		replica, err := bstore.NewBadgerBackend(opts)
+		if err != nil {
+			log.Errorf("Could not open replica database, %s", err.Error())
+			os.Exit(1)
+		}
+		secondaries = append(secondaries, replica)
+	}
+	if len(*replicaEndpoint) > 0 {
+		store := bstore.NewS3ObjectStore(*replicaEndpoint, *replicaBucket, *replicaRegion, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+		secondaries = append(secondaries, bstore.NewObjectStoreBackend(store, *replicaPrefix))
+	}
+	if len(secondaries) > 0 {
+		log.Infof("Replicating writes to %d secondary backend(s)", len(secondaries))
+		replicating = bstore.NewReplicatingBackend(backend, secondaries, replicaReconcileDuration)
+		backend = replicating
+	}
+
 	// Reset backend if requested
 	if *reset {
 		log.Info("Resetting database")
@@ -149,9 +951,218 @@ func main() {
 		}
 	}
 
-	requestHandler := koinosmq.NewRequestHandler(*amqp, uint(*jobs), koinosmq.ExponentialBackoff)
+	var tiered *bstore.TieredBackend
+	if len(*coldEndpoint) > 0 {
+		// Credentials are taken from the environment, like the AWS tooling
+		cold := bstore.NewS3ObjectStore(*coldEndpoint, *coldBucket, *coldRegion, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+		tiered = bstore.NewTieredBackend(backend, cold, *coldPrefix)
+		backend = tiered
+	}
 
-	handler := bstore.RequestHandler{Backend: backend}
+	// All reads and writes go through the record cache, so it never serves stale values
+	var cacheTuner *bstore.CacheTuner
+	if *cacheSizeMax > 0 {
+		cache := bstore.NewCacheBackend(backend, int64(*cacheSizeMin)<<20)
+		cacheTuner = &bstore.CacheTuner{
+			Cache:    cache,
+			MinSize:  int64(*cacheSizeMin) << 20,
+			MaxSize:  int64(*cacheSizeMax) << 20,
+			Interval: cacheTuneIntervalDuration,
+		}
+		backend = cache
+	}
+
+	// The log records the values as read, standbys store them with their own compression and encryption
+	var walBackend *bstore.WALBackend
+	if *wal {
+		walBackend, err = bstore.NewWALBackend(backend, path.Join(util.GetAppDir(baseDir, appName), "wal"), 0, *walRetained)
+		if err != nil {
+			log.Errorf("Could not open write-ahead log, %s", err.Error())
+			os.Exit(1)
+		}
+		backend = walBackend
+	}
+
+	// Requests only read and write the records of the block store, never those backends keep alongside
+	backend = bstore.NewNamespaceBackend(backend, bstore.NamespaceMetadata, bstore.NamespaceIndexes, bstore.NamespaceBlocks)
+
+	// The credentials file takes precedence over the environment, either replaces the credentials of
+	// the URL
+	amqpOptions := &bstore.AMQPOptions{
+		Username:   os.Getenv(amqpUsernameEnv),
+		Password:   os.Getenv(amqpPasswordEnv),
+		CAFile:     *amqpCAFile,
+		CertFile:   *amqpCertFile,
+		KeyFile:    *amqpKeyFile,
+		ServerName: *amqpServerName,
+	}
+	if len(*amqpCredentialsFile) > 0 {
+		amqpOptions.Username, amqpOptions.Password, err = bstore.ReadAMQPCredentials(*amqpCredentialsFile)
+		if err != nil {
+			log.Errorf("Option '%v' must be a readable credentials file, %s", amqpCredentialsFileOption, err.Error())
+			os.Exit(1)
+		}
+	}
+	*amqp, err = amqpOptions.URL(*amqp)
+	if err != nil {
+		log.Errorf("Option '%v' is invalid, %s", amqpOption, err.Error())
+		os.Exit(1)
+	}
+
+	// The RPC server passes the reply-to queue of each request, which the requests are rate limited by
+	requestHandler := bstore.NewRPCServer(*amqp, uint(*jobs))
+
+	// Broadcasts are consumed by their own jobs, so a flood of blocks while syncing does not delay RPCs
+	ingestHandler := koinosmq.NewRequestHandler(*amqp, uint(*ingestJobs), koinosmq.ExponentialBackoff)
+	client := koinosmq.NewClient(*amqp, koinosmq.ExponentialBackoff)
+
+	// Broadcasts are queued so they are published in order without blocking on the broker
+	broadcastQueue := make(chan []byte, broadcastQueueLen)
+
+	schemaTracker := bstore.NewSchemaTracker()
+	if err := schemaTracker.Load(backend); err != nil {
+		log.Warnf("Unable to load message schema counts: %s", err)
+	}
+
+	handler := bstore.RequestHandler{Backend: backend, SchemaTracker: schemaTracker, BackupDir: *backupDir, ExportDir: *exportDir, AllowRestore: *allowRestore, MaxMessageSize: *maxMessageSize}
+	handler.Strict = *strict
+	handler.MaxBlocksByHeight = uint64(*maxBlocksByHeight)
+	handler.MaxBlocksByID = uint64(*maxBlocksByID)
+	handler.RateLimit = float64(*rateLimit)
+	handler.RateLimitBurst = *rateLimitBurst
+	handler.RequestTimeout = requestTimeoutDuration
+	handler.AdminSecret = adminSecret
+	handler.AdminAllowlist = *adminAllowlist
+	handler.ErrorJournal = errorJournal
+	handler.Metrics = metrics
+	handler.StaleHeadAfter = staleHeadAfterDuration
+	handler.ReadOnly = *readOnly
+	if *readOnly {
+		log.Info("Serving queries only, blocks are not added and block broadcasts are ignored")
+	}
+	handler.IngestFilters = ingestFilters
+	handler.DependentIndexes = append(handler.DependentIndexes, handler.PayerIndex())
+	if cacheTuner != nil {
+		handler.StatusReporters = append(handler.StatusReporters, cacheTuner)
+	}
+	if replicating != nil {
+		handler.StatusReporters = append(handler.StatusReporters, replicating)
+	}
+	handler.WAL = walBackend
+
+	var standby *bstore.Standby
+	if len(*standbyAMQP) > 0 {
+		log.Info("Connecting to the primary block store")
+		standby, err = bstore.NewStandby(&handler, bstore.DialRemoteBackend(*standbyAMQP, standbySecret), standbyPollDuration)
+		if err != nil {
+			log.Errorf("Could not start standby, %s", err.Error())
+			os.Exit(1)
+		}
+		handler.Standby = standby
+		handler.IngestFilters = append(handler.IngestFilters, standby)
+		handler.StatusReporters = append(handler.StatusReporters, standby)
+	}
+	if len(chainID) > 0 {
+		handler.ChainID = chainID
+	}
+
+	// The broadcast is queued with the handler lock held, so it is dropped rather than blocking every
+	// request while the broker is slow or unreachable
+	var droppedBroadcasts, recentDroppedBroadcasts uint32
+	handler.OnBlockAdded = func(added *bstore.BlockAdded) {
+		data, err := json.Marshal(added)
+		if err != nil {
+			log.Warnf("Unable to serialize block added broadcast: %s", err)
+			return
+		}
+
+		select {
+		case broadcastQueue <- data:
+		default:
+			atomic.AddUint32(&droppedBroadcasts, 1)
+			atomic.AddUint32(&recentDroppedBroadcasts, 1)
+		}
+	}
+
+	handler.StatusReporters = append(handler.StatusReporters, bstore.NewStatusReporter("broadcast_queue", func() interface{} {
+		return map[string]int{"length": len(broadcastQueue), "capacity": cap(broadcastQueue), "dropped": int(atomic.LoadUint32(&droppedBroadcasts))}
+	}))
+
+	// checkSchema records the producer schema of a message, returning an error if it should be rejected
+	checkSchema := func(source string, msg proto.Message) error {
+		if schemaTracker.Record(source, msg) != bstore.NewerSchemaVersion {
+			return nil
+		}
+
+		switch incompatiblePolicy {
+		case bstore.ProducerPolicyWarn:
+			log.Warnf("Received %s message from a producer with a newer schema than %s", source, bstore.SchemaVersion)
+		case bstore.ProducerPolicyReject:
+			log.Warnf("Rejecting %s message from a producer with a newer schema than %s", source, bstore.SchemaVersion)
+			return &bstore.IncompatibleSchemaError{}
+		}
+
+		return nil
+	}
+
+	// SIGHUP reloads the options which can change at runtime from the configuration file. It is handled
+	// from here on, so it does not stop a node which is migrating or syncing.
+	reloadOnSignal(baseDir, &reloadableOptions{LogLevel: *logLevel, Settings: *handler.CurrentSettings()}, func(opts *reloadableOptions) error {
+		if err := log.InitLogger(appName, *instanceID, opts.LogLevel, *logDir, *logColor, *logDatetime); err != nil {
+			return fmt.Errorf("invalid %s %s", logLevelOption, opts.LogLevel)
+		}
+		bstore.RedactAMQPLogs()
+		handler.ApplySettings(&opts.Settings)
+		return nil
+	})
+
+	// Health checks are served before the migration, so a long migration does not fail liveness probes
+	var healthServer *bstore.HealthServer
+	if len(*healthAddr) > 0 {
+		healthServer = &bstore.HealthServer{Handler: &handler, Client: client}
+		if err = healthServer.Listen(*healthAddr); err != nil {
+			log.Errorf("Could not serve health checks on %s, %s", *healthAddr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Databases written by older releases are upgraded to the current format once, newer ones refused
+	migrations, err := handler.Migrate()
+	if err != nil {
+		log.Errorf("Could not migrate the database, %s", err.Error())
+		os.Exit(1)
+	}
+	for _, m := range migrations {
+		log.Infof("Migrated %d record(s) to format version %d (%s)", m.Records, m.Version, m.Name)
+	}
+
+	if len(*checkpointFile) > 0 {
+		data, err := os.ReadFile(*checkpointFile)
+		if err != nil {
+			log.Errorf("Could not read checkpoint file, %s", err.Error())
+			os.Exit(1)
+		}
+
+		checkpoint, err := bstore.ParseSignedCheckpoint(data)
+		if err != nil {
+			log.Errorf("Invalid checkpoint, %s", err.Error())
+			os.Exit(1)
+		}
+
+		if len(*checkpointSigner) > 0 {
+			if err = checkpoint.VerifySigner(*checkpointSigner); err != nil {
+				log.Errorf("Untrusted checkpoint, %s", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		if err = handler.BootstrapFromCheckpoint(&checkpoint.Checkpoint); err != nil {
+			log.Errorf("Could not bootstrap from checkpoint, %s", err.Error())
+			os.Exit(1)
+		}
+
+		log.Infof("Database bootstrapped from checkpoint - Height: %d, ID: 0x%s", checkpoint.Height, hex.EncodeToString(checkpoint.BlockID))
+	}
 
 	if _, err = handler.GetHighestBlock(&block_store.GetHighestBlockRequest{}); err != nil {
 		if _, ok := err.(*bstore.UnexpectedHeightError); ok {
@@ -163,41 +1174,127 @@ func main() {
 		}
 	}
 
-	requestHandler.SetRPCHandler(blockstoreRPC, func(rpcType string, data []byte) ([]byte, error) {
+	head, err := handler.ValidateHighestBlock()
+	if err != nil {
+		log.Errorf("Highest block is invalid, %s", err.Error())
+		os.Exit(1)
+	}
+
+	// The systemd watchdog is notified while the consumers handle messages
+	notifier := bstore.NewSystemdNotifier()
+	watchdog := &bstore.Watchdog{Notifier: notifier, Client: client}
+
+	requestHandler.SetRPCHandler(blockstoreRPC, func(rpcReq *bstore.RPCRequest) ([]byte, error) {
+		defer watchdog.Handled()
+
+		data := rpcReq.Data
+
 		req := &block_store.BlockStoreRequest{}
 		resp := &block_store.BlockStoreResponse{}
 
+		seq := captureRequest(blockstoreRPC, data)
+
 		err := proto.Unmarshal(data, req)
 		if err != nil {
-			log.Warnf("Received malformed request: 0x%v", hex.EncodeToString(data))
-			eResp := rpc.ErrorStatus{Message: err.Error()}
-			rErr := block_store.BlockStoreResponse_Error{Error: &eResp}
+			log.Warnf("Received malformed request: %s", bstore.FormatPayload(payloadLogMode, data))
+			rErr := block_store.BlockStoreResponse_Error{Error: bstore.NewErrorStatus(&bstore.MalformedRequestError{Err: err})}
+			resp.Response = &rErr
+		} else if err = checkSchema(blockstoreRPC, req); err != nil {
+			rErr := block_store.BlockStoreResponse_Error{Error: bstore.NewErrorStatus(err)}
 			resp.Response = &rErr
 		} else {
-			log.Debugf("Received RPC request: 0x%v", hex.EncodeToString(data))
-			resp = handler.HandleRequest(req)
+			log.Debugf("Received RPC request: %s (%s)", bstore.SummarizeRequest(req), bstore.FormatPayload(payloadLogMode, data))
+			resp = handler.HandleRequestFrom(rpcReq.ReplyTo, req)
 		}
 
 		var outputBytes []byte
 		outputBytes, err = proto.Marshal(resp)
 
-		if len(outputBytes) > maxMessageSize {
-			eResp := rpc.ErrorStatus{Message: "Response would exceed maximum MQ message size"}
-			rErr := block_store.BlockStoreResponse_Error{Error: &eResp}
+		if maxSize := handler.MaxResponseSize(); len(outputBytes) > maxSize {
+			tooLarge := &bstore.MessageTooLargeError{Size: len(outputBytes), MaxMessageSize: maxSize}
+			rErr := block_store.BlockStoreResponse_Error{Error: bstore.NewErrorStatus(tooLarge)}
 			resp.Response = &rErr
 			outputBytes, err = proto.Marshal(resp)
 		}
 
+		captureResponse(seq, blockstoreRPC, outputBytes)
+		return outputBytes, err
+	})
+
+	requestHandler.SetRPCHandler(blockstoreExtRPC, func(rpcReq *bstore.RPCRequest) ([]byte, error) {
+		defer watchdog.Handled()
+
+		data := rpcReq.Data
+
+		resp := &bstore.ExtendedResponse{}
+
+		// Admin secrets must not end up in logs or capture files
+		redacted := bstore.RedactExtendedRequest(data)
+		seq := captureRequest(blockstoreExtRPC, redacted)
+
+		req, err := bstore.DecodeExtendedRequest(data, *strict)
+		if err != nil {
+			log.Warnf("Received malformed extended request: %s", string(redacted))
+			resp.Error = bstore.NewExtendedError(err)
+		} else {
+			log.Debugf("Received extended RPC request: %s", string(redacted))
+			resp = handler.HandleExtendedRequestFrom(rpcReq.ReplyTo, req)
+		}
+
+		var outputBytes []byte
+		outputBytes, err = json.Marshal(resp)
+
+		if maxSize := handler.MaxResponseSize(); len(outputBytes) > maxSize {
+			tooLarge := &bstore.MessageTooLargeError{Size: len(outputBytes), MaxMessageSize: maxSize}
+			resp = &bstore.ExtendedResponse{Error: bstore.NewExtendedError(tooLarge)}
+			outputBytes, err = json.Marshal(resp)
+		}
+
+		captureResponse(seq, blockstoreExtRPC, outputBytes)
 		return outputBytes, err
 	})
 
 	var recentBlocks uint32
+	var duplicateBlocks uint32
+
+	var duplicateFilter *bstore.DuplicateFilter
+	if duplicateWindowDuration > 0 {
+		duplicateFilter = bstore.NewDuplicateFilter(duplicateWindowDuration)
+	}
+
+	ingestHandler.SetBroadcastHandler(blockAccept, func(topic string, data []byte) {
+		defer watchdog.Handled()
+
+		captureBroadcast(blockAccept, data)
+
+		// A read-only block store serves the blocks it has, it does not follow the chain
+		if *readOnly {
+			return
+		}
+
+		var blockID []byte
+		if duplicateFilter != nil {
+			if id, err := bstore.PeekBlockAcceptedID(data); err == nil {
+				if duplicateFilter.CheckAndAdd(id) {
+					log.Debugf("Ignoring duplicate broadcasted block - ID: 0x%s", hex.EncodeToString(id))
+					atomic.AddUint32(&duplicateBlocks, 1)
+					return
+				}
+				blockID = id
+			}
+		}
 
-	requestHandler.SetBroadcastHandler(blockAccept, func(topic string, data []byte) {
 		sub := broadcast.BlockAccepted{}
 		err := proto.Unmarshal(data, &sub)
 		if err != nil {
-			log.Warnf("Unable to parse koinos.block.accept broadcast: %s", string(data))
+			log.Warnf("Unable to parse koinos.block.accept broadcast: %s", bstore.FormatPayload(payloadLogMode, data))
+			return
+		}
+
+		if err = checkSchema(blockAccept, &sub); err != nil {
+			if blockID != nil {
+				duplicateFilter.Remove(blockID)
+			}
 			return
 		}
 
@@ -216,11 +1313,158 @@ func main() {
 		bsReq := block_store.BlockStoreRequest_AddBlock{AddBlock: &iReq}
 		req := block_store.BlockStoreRequest{Request: &bsReq}
 
-		_ = handler.HandleRequest(&req)
+		resp := handler.HandleRequest(&req)
+		if _, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error); ok && blockID != nil {
+			// Allow a retransmission of the block to be ingested
+			duplicateFilter.Remove(blockID)
+		}
+	})
+
+	var offloader *bstore.ColdStorageOffloader
+	if tiered != nil {
+		offloader = &bstore.ColdStorageOffloader{Handler: &handler, Backend: tiered, Depth: uint64(*coldDepth)}
+		handler.StatusReporters = append(handler.StatusReporters, offloader)
+	}
+
+	var publisher *bstore.CheckpointPublisher
+	if *checkpointInterval > 0 {
+		publisher = &bstore.CheckpointPublisher{
+			Handler:    &handler,
+			Interval:   uint64(*checkpointInterval),
+			PrivateKey: checkpointKey,
+			Dir:        *checkpointDir,
+			URL:        *checkpointURL,
+		}
+		handler.StatusReporters = append(handler.StatusReporters, publisher)
+	}
+
+	ingestHandler.SetBroadcastHandler(blockIrreversible, func(topic string, data []byte) {
+		defer watchdog.Handled()
+
+		captureBroadcast(blockIrreversible, data)
+
+		if *readOnly {
+			return
+		}
+
+		sub := broadcast.BlockIrreversible{}
+		err := proto.Unmarshal(data, &sub)
+		if err != nil {
+			log.Warnf("Unable to parse koinos.block.irreversible broadcast: %s", string(data))
+			return
+		}
+
+		if err = handler.UpdateIrreversibleBlock(sub.GetTopology()); err != nil {
+			log.Warnf("Unable to update irreversible block: %s", err)
+			return
+		}
+
+		if publisher != nil {
+			// Publishing may upload to a remote URL, do not hold up the broadcast consumer
+			go func() {
+				if err := publisher.HandleIrreversible(sub.GetTopology()); err != nil {
+					log.Warnf("Unable to publish checkpoint: %s", err)
+				}
+			}()
+		}
+
+		if offloader != nil {
+			// Uploads take a while, an offload in progress is continued by the next irreversible block
+			go func() {
+				if err := offloader.HandleIrreversible(sub.GetTopology()); err != nil {
+					log.Warnf("Unable to move blocks to cold storage: %s", err)
+				}
+			}()
+		}
 	})
 
 	ctx, ctxCancel := context.WithCancel(context.Background())
-	requestHandler.Start(ctx)
+	<-client.Start(ctx)
+
+	// The block_added broadcasts are published from the start, as the blocks added by the resync below
+	// are queued too
+	go func() {
+		for {
+			select {
+			case data := <-broadcastQueue:
+				if err := client.Broadcast(ctx, jsonContentType, blockAdded, data); err != nil {
+					log.Warnf("Unable to publish %s broadcast: %s", blockAdded, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// The blocks are requested before the block_store requests are consumed, so the other block stores
+	// of the deployment answer them, and once more afterwards for those added by the chain meanwhile
+	var resyncer *bstore.Resync
+	if *resync {
+		resyncer = &bstore.Resync{Handler: &handler, Client: client, BatchSize: uint64(*resyncBatchSize)}
+		result, err := resyncer.Run(ctx)
+		if err != nil {
+			log.Errorf("Could not resync, %s", err.Error())
+			os.Exit(1)
+		}
+		log.Infof("Resynced %d block(s) - Height: %d, ID: 0x%s", result.Blocks, result.HeadHeight, hex.EncodeToString(result.HeadID))
+
+		if head, err = handler.ValidateHighestBlock(); err != nil {
+			log.Errorf("Highest block is invalid, %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	<-requestHandler.Start(ctx)
+	<-ingestHandler.Start(ctx)
+
+	if resyncer != nil {
+		go func() {
+			result, err := resyncer.Run(ctx)
+			if err != nil {
+				log.Warnf("Unable to resync the blocks added while starting: %s", err)
+				return
+			}
+			if result.Blocks > 0 {
+				log.Infof("Resynced %d block(s) added while starting", result.Blocks)
+			}
+		}()
+	}
+
+	if cacheTuner != nil {
+		cacheTuner.Start(ctx)
+	}
+
+	if healthServer != nil {
+		healthServer.Start(ctx)
+	}
+
+	if len(*metricsPushURL) > 0 || len(*metricsStatsD) > 0 {
+		pusher := &bstore.MetricsPusher{
+			Handler:        &handler,
+			Interval:       metricsIntervalDuration,
+			PushgatewayURL: *metricsPushURL,
+			Job:            *metricsJob,
+			Instance:       *instanceID,
+			StatsDAddress:  *metricsStatsD,
+			StatsDPrefix:   *metricsStatsDPrefix,
+		}
+		pusher.Start(ctx)
+	}
+
+	readyBytes, err := json.Marshal(&bstore.Ready{HeadBlockID: head.GetId(), HeadHeight: head.GetHeight(), PreviousID: head.GetPrevious(), ChainID: handler.ChainID})
+	if err == nil {
+		err = client.Broadcast(ctx, jsonContentType, storeReady, readyBytes)
+	}
+	if err != nil {
+		log.Warnf("Unable to publish %s broadcast: %s", storeReady, err)
+	}
+
+	if notifier != nil {
+		if err := notifier.Notify(bstore.SystemdReady); err != nil {
+			log.Warnf("Unable to notify systemd: %s", err)
+		}
+		watchdog.Start(ctx)
+	}
 
 	go func() {
 		for {
@@ -231,6 +1475,30 @@ func main() {
 				if numBlocks > 0 {
 					log.Infof("Recently added %v block(s)", numBlocks)
 				}
+
+				numDuplicates := atomic.SwapUint32(&duplicateBlocks, 0)
+
+				if numDuplicates > 0 {
+					log.Infof("Recently ignored %v duplicate block broadcast(s)", numDuplicates)
+				}
+
+				numDropped := atomic.SwapUint32(&recentDroppedBroadcasts, 0)
+
+				if numDropped > 0 {
+					log.Warnf("Recently dropped %v %s broadcast(s), the broadcast queue was full", numDropped, blockAdded)
+				}
+
+				for _, summary := range handler.Metrics.TakeLatencySummaries() {
+					log.Infof("Recently served %v %s request(s) - p50: %s, p95: %s, p99: %s", summary.Count, summary.Request,
+						summary.P50.Round(time.Microsecond), summary.P95.Round(time.Microsecond), summary.P99.Round(time.Microsecond))
+				}
+
+				// A standby refuses writes until it is promoted
+				if standby == nil || standby.Promoted() {
+					if err := schemaTracker.Save(backend); err != nil {
+						log.Warnf("Unable to save message schema counts: %s", err)
+					}
+				}
 			case <-ctx.Done():
 				return
 			}
@@ -242,7 +1510,27 @@ func main() {
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	<-ch
 	log.Info("Shutting down node...")
+	if notifier != nil {
+		_ = notifier.Notify(bstore.SystemdStopping)
+	}
 	ctxCancel()
+	if standby == nil || standby.Promoted() {
+		if err := schemaTracker.Save(backend); err != nil {
+			log.Warnf("Unable to save message schema counts: %s", err)
+		}
+	}
+	if capture != nil {
+		_ = capture.Close()
+	}
+	if errorJournal != nil {
+		_ = errorJournal.Close()
+	}
+	if standby != nil {
+		standby.Close()
+	}
+	if err := backend.Flush(); err != nil {
+		log.Warnf("Unable to flush the database: %s", err)
+	}
 	backend.Close()
 }
 
@@ -254,3 +1542,84 @@ func makeVersionString() string {
 
 	return fmt.Sprintf("%s %s %s", DisplayAppName, Version, commitString)
 }
+
+// rotateEncryptionKey re-encrypts the Badger database with the key in keyFile and returns the exit
+// code, 0 if the key was rotated
+func rotateEncryptionKey(backendType string, config *bstore.BackendConfig, keyFile string) int {
+	if backendType != badgerBackend {
+		log.Errorf("Option '%v' is only supported by the %s backend", rotateKeyOption, badgerBackend)
+		return 1
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		log.Errorf("Option '%v' must be a readable file, %s", rotateKeyOption, err.Error())
+		return 1
+	}
+
+	key, err := bstore.ParseEncryptionKey(string(data))
+	if err != nil {
+		log.Errorf("Invalid encryption key, %s", err.Error())
+		return 1
+	}
+
+	if err = bstore.RotateBadgerEncryptionKey(config, key); err != nil {
+		log.Errorf("Could not rotate the encryption key of %s, %s", config.Dir, err.Error())
+		return 1
+	}
+
+	log.Infof("Rotated the encryption key of %s, set 'encryption-key-file' of the %s block to %s", config.Dir, badgerBackend, keyFile)
+	return 0
+}
+
+// checkCompatibility prints the compatibility report of the database and returns the exit code, 0 if
+// this binary can serve it
+func checkCompatibility(backend storeBackend) int {
+	defer backend.Close()
+
+	handler := bstore.RequestHandler{Backend: backend}
+	report, err := handler.CheckCompatibility()
+	if err != nil {
+		log.Errorf("Could not check database compatibility, %s", err.Error())
+		return 1
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Errorf("Could not serialize compatibility report, %s", err.Error())
+		return 1
+	}
+	fmt.Println(string(data))
+
+	if !report.Compatible {
+		return 1
+	}
+
+	return 0
+}
+
+// backendConfigBlock returns the options in the block named after a backend in the block_store config,
+// an empty map if there is none
+func backendConfigBlock(config map[string]interface{}, name string) (map[string]interface{}, error) {
+	options := make(map[string]interface{})
+
+	block, ok := config[name]
+	if !ok || block == nil {
+		return options, nil
+	}
+
+	entries, ok := block.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("must be a map of backend options")
+	}
+
+	for key, value := range entries {
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("contains a non-string key %v", key)
+		}
+		options[name] = value
+	}
+
+	return options, nil
+}
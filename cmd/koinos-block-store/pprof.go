@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// startPprofServer serves the runtime profiles of net/http/pprof under /debug/pprof/ on addr for the
+// lifetime of the process. The handlers are registered on their own mux, so nothing else is exposed.
+// The address is bound before returning, so a bad address is reported at startup.
+func startPprofServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Serving profiles at http://%s/debug/pprof/", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Warnf("Profile server stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
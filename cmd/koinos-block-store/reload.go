@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/koinos/koinos-block-store/internal/bstore"
+	log "github.com/koinos/koinos-log-golang/v2"
+	util "github.com/koinos/koinos-util-golang/v2"
+	flag "github.com/spf13/pflag"
+)
+
+// reloadableOptions are the options which are read again from the configuration file on SIGHUP and
+// applied without restarting
+type reloadableOptions struct {
+	LogLevel string
+	Settings bstore.Settings
+}
+
+// readYamlConfig reads the configuration file in baseDir, returning an error instead of panicking if
+// it cannot be parsed
+func readYamlConfig(baseDir string) (config *util.YamlConfig, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("could not read configuration file, %v", r)
+		}
+	}()
+
+	return util.InitYamlConfig(baseDir), nil
+}
+
+// loadReloadableOptions reads the reloadable options from the configuration file in baseDir. Options
+// given on the command line take precedence over the configuration file, so they keep their values
+// in current.
+func loadReloadableOptions(baseDir string, current *reloadableOptions) (*reloadableOptions, error) {
+	config, err := readYamlConfig(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := *current
+	if !flag.CommandLine.Changed(logLevelOption) {
+		opts.LogLevel = util.GetStringOption(logLevelOption, logLevelDefault, "", config.BlockStore, config.Global)
+	}
+	switch opts.LogLevel {
+	case "debug", "info", "warning", "error":
+	default:
+		return nil, fmt.Errorf("option '%v' must be one of debug, info, warning, error (was %v)", logLevelOption, opts.LogLevel)
+	}
+
+	if !flag.CommandLine.Changed(maxMessageSizeOption) {
+		maxSize := util.GetIntOption(maxMessageSizeOption, maxMessageSizeDefault, maxMessageSizeDefault, config.BlockStore, config.Global)
+		if maxSize <= 0 {
+			return nil, fmt.Errorf("option '%v' must be greater than 0 (was %v)", maxMessageSizeOption, maxSize)
+		}
+		opts.Settings.MaxMessageSize = maxSize
+	}
+
+	if !flag.CommandLine.Changed(maxBlocksByHeightOption) {
+		maxBlocks := util.GetIntOption(maxBlocksByHeightOption, maxBlocksByHeightDefault, maxBlocksByHeightDefault, config.BlockStore, config.Global)
+		if maxBlocks <= 0 {
+			return nil, fmt.Errorf("option '%v' must be greater than 0 (was %v)", maxBlocksByHeightOption, maxBlocks)
+		}
+		opts.Settings.MaxBlocksByHeight = uint64(maxBlocks)
+	}
+
+	if !flag.CommandLine.Changed(maxBlocksByIDOption) {
+		maxBlocks := util.GetIntOption(maxBlocksByIDOption, maxBlocksByIDDefault, maxBlocksByIDDefault, config.BlockStore, config.Global)
+		if maxBlocks <= 0 {
+			return nil, fmt.Errorf("option '%v' must be greater than 0 (was %v)", maxBlocksByIDOption, maxBlocks)
+		}
+		opts.Settings.MaxBlocksByID = uint64(maxBlocks)
+	}
+
+	if !flag.CommandLine.Changed(rateLimitOption) {
+		rate := util.GetIntOption(rateLimitOption, rateLimitDefault, rateLimitDefault, config.BlockStore, config.Global)
+		if rate < 0 {
+			return nil, fmt.Errorf("option '%v' must not be negative (was %v)", rateLimitOption, rate)
+		}
+		opts.Settings.RateLimit = float64(rate)
+	}
+
+	if !flag.CommandLine.Changed(rateLimitBurstOption) {
+		burst := util.GetIntOption(rateLimitBurstOption, rateLimitBurstDefault, rateLimitBurstDefault, config.BlockStore, config.Global)
+		if burst < 0 {
+			return nil, fmt.Errorf("option '%v' must not be negative (was %v)", rateLimitBurstOption, burst)
+		}
+		opts.Settings.RateLimitBurst = burst
+	}
+
+	if !flag.CommandLine.Changed(requestTimeoutOption) {
+		value := util.GetStringOption(requestTimeoutOption, requestTimeoutDefault, "", config.BlockStore, config.Global)
+		requestTimeout, err := time.ParseDuration(value)
+		if err != nil || requestTimeout < 0 {
+			return nil, fmt.Errorf("option '%v' must be a non-negative duration (was %v)", requestTimeoutOption, value)
+		}
+		opts.Settings.RequestTimeout = requestTimeout
+	}
+
+	if !flag.CommandLine.Changed(staleHeadAfterOption) {
+		value := util.GetStringOption(staleHeadAfterOption, staleHeadAfterDefault, "", config.BlockStore, config.Global)
+		staleHeadAfter, err := time.ParseDuration(value)
+		if err != nil || staleHeadAfter < 0 {
+			return nil, fmt.Errorf("option '%v' must be a non-negative duration (was %v)", staleHeadAfterOption, value)
+		}
+		opts.Settings.StaleHeadAfter = staleHeadAfter
+	}
+
+	return &opts, nil
+}
+
+// reloadOnSignal reads the reloadable options again on each SIGHUP for the lifetime of the process,
+// and applies them with apply if they are valid. An invalid configuration file is reported and leaves
+// the options as they were.
+func reloadOnSignal(baseDir string, current *reloadableOptions, apply func(opts *reloadableOptions) error) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			opts, err := loadReloadableOptions(baseDir, current)
+			if err == nil {
+				err = apply(opts)
+			}
+			if err != nil {
+				log.Warnf("Configuration not reloaded, %s", err)
+				continue
+			}
+
+			current = opts
+			log.Infof("Reloaded configuration: %s %s, %s %d, %s %d, %s %d, %s %g, %s %d, %s %s, %s %s", logLevelOption, opts.LogLevel,
+				maxMessageSizeOption, opts.Settings.MaxMessageSize, maxBlocksByHeightOption, opts.Settings.MaxBlocksByHeight,
+				maxBlocksByIDOption, opts.Settings.MaxBlocksByID, rateLimitOption, opts.Settings.RateLimit,
+				rateLimitBurstOption, opts.Settings.RateLimitBurst, requestTimeoutOption, opts.Settings.RequestTimeout,
+				staleHeadAfterOption, opts.Settings.StaleHeadAfter)
+		}
+	}()
+}
@@ -0,0 +1,206 @@
+package bstore
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// AdminRequest is the envelope for requests which modify the database or the filesystem of the node,
+// or which expose its operational history.
+// It is served as the admin field of an ExtendedRequest.
+//
+// A request is only served if Secret matches the configured admin secret, or if the request is on the
+// configured admin allowlist. Exactly one request field must be set.
+type AdminRequest struct {
+	Secret string `json:"secret,omitempty"`
+
+	CompactStore *CompactStoreRequest `json:"compact_store,omitempty"`
+	BackupStore  *BackupStoreRequest  `json:"backup_store,omitempty"`
+	RestoreStore *RestoreStoreRequest `json:"restore_store,omitempty"`
+	ExportChain  *ExportChainRequest  `json:"export_chain,omitempty"`
+
+	CompressBlocks *CompressBlocksRequest `json:"compress_blocks,omitempty"`
+
+	GetErrorJournal *GetErrorJournalRequest `json:"get_error_journal,omitempty"`
+
+	GetRecord    *GetRecordRequest    `json:"get_record,omitempty"`
+	PutRecord    *PutRecordRequest    `json:"put_record,omitempty"`
+	PutRecords   *PutRecordsRequest   `json:"put_records,omitempty"`
+	DeleteRecord *DeleteRecordRequest `json:"delete_record,omitempty"`
+
+	GetWAL         *GetWALRequest         `json:"get_wal,omitempty"`
+	PromoteStandby *PromoteStandbyRequest `json:"promote_standby,omitempty"`
+}
+
+// AdminResponse is the result of an AdminRequest. The field matching the request is set.
+type AdminResponse struct {
+	CompactStore *CompactStoreResponse `json:"compact_store,omitempty"`
+	BackupStore  *BackupStoreResponse  `json:"backup_store,omitempty"`
+	RestoreStore *RestoreStoreResponse `json:"restore_store,omitempty"`
+	ExportChain  *ExportChainResponse  `json:"export_chain,omitempty"`
+
+	CompressBlocks *CompressBlocksResponse `json:"compress_blocks,omitempty"`
+
+	GetErrorJournal *GetErrorJournalResponse `json:"get_error_journal,omitempty"`
+
+	GetRecord    *GetRecordResponse    `json:"get_record,omitempty"`
+	PutRecord    *PutRecordResponse    `json:"put_record,omitempty"`
+	PutRecords   *PutRecordsResponse   `json:"put_records,omitempty"`
+	DeleteRecord *DeleteRecordResponse `json:"delete_record,omitempty"`
+
+	GetWAL         *GetWALResponse         `json:"get_wal,omitempty"`
+	PromoteStandby *PromoteStandbyResponse `json:"promote_standby,omitempty"`
+}
+
+// UnauthorizedError is an error type thrown when an admin request is neither allowlisted nor carries
+// the admin secret
+type UnauthorizedError struct {
+	Request string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("Admin request '%s' is not authorized", e.Request)
+}
+
+// Code returns the error code
+func (e *UnauthorizedError) Code() ErrorCode {
+	return ErrorCodeUnauthorized
+}
+
+// Details returns the name of the rejected request
+func (e *UnauthorizedError) Details() map[string]interface{} {
+	return map[string]interface{}{"request": e.Request}
+}
+
+// HandleAdminRequest authorizes and routes admin requests
+func (handler *RequestHandler) HandleAdminRequest(req *AdminRequest) (*AdminResponse, error) {
+	if countSetFields(req) == 0 {
+		return nil, &InvalidRequestError{Reason: "expected admin request was nil"}
+	} else if countSetFields(req) > 1 {
+		return nil, &InvalidRequestError{Reason: "only one admin request may be set"}
+	}
+
+	name := setFieldName(req)
+	if err := handler.authorizeAdmin(name, req.Secret); err != nil {
+		return nil, err
+	}
+	if handler.ReadOnly && readOnlyAdminRequests[name] {
+		return nil, &ReadOnlyError{Request: name}
+	}
+
+	response := &AdminResponse{}
+	var err error
+
+	switch {
+	case req.CompactStore != nil:
+		// Compaction runs concurrently with reads and writes, the backend guards itself
+		response.CompactStore, err = handler.CompactStore(req.CompactStore)
+	case req.BackupStore != nil:
+		// Badger backups read from a consistent snapshot and do not block writers
+		response.BackupStore, err = handler.BackupStore(req.BackupStore)
+	case req.RestoreStore != nil:
+		// The whole database is replaced, no other request may run concurrently
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+
+		response.RestoreStore, err = handler.RestoreStore(req.RestoreStore)
+	case req.ExportChain != nil:
+		// Exports lock the handler per chunk so long exports do not block writers
+		response.ExportChain, err = handler.ExportChain(req.ExportChain)
+	case req.CompressBlocks != nil:
+		// Compression locks the handler per chunk so it does not block writers for long
+		response.CompressBlocks, err = handler.CompressBlocks(req.CompressBlocks)
+	case req.GetErrorJournal != nil:
+		// The journal guards itself
+		response.GetErrorJournal, err = handler.GetErrorJournal(req.GetErrorJournal)
+	case req.GetRecord != nil:
+		// Record requests serve the remote backend of another block store, they are ordered against
+		// other requests like block store requests
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetRecord, err = handler.GetRecord(req.GetRecord)
+	case req.PutRecord != nil:
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+
+		response.PutRecord, err = handler.PutRecord(req.PutRecord)
+	case req.PutRecords != nil:
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+
+		response.PutRecords, err = handler.PutRecords(req.PutRecords)
+	case req.DeleteRecord != nil:
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+
+		response.DeleteRecord, err = handler.DeleteRecord(req.DeleteRecord)
+	case req.GetWAL != nil:
+		// The log guards itself
+		response.GetWAL, err = handler.GetWAL(req.GetWAL)
+	case req.PromoteStandby != nil:
+		// Promotion waits for the write being applied, which takes the handler lock
+		response.PromoteStandby, err = handler.PromoteStandby(req.PromoteStandby)
+	default:
+		err = &UnknownReqError{}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// authorizeAdmin returns an UnauthorizedError unless the named request is allowlisted or secret matches
+// the admin secret. Without a configured secret only allowlisted requests are served.
+func (handler *RequestHandler) authorizeAdmin(name string, secret string) error {
+	for _, allowed := range handler.AdminAllowlist {
+		if allowed == name {
+			return nil
+		}
+	}
+
+	if len(handler.AdminSecret) > 0 && subtle.ConstantTimeCompare([]byte(secret), []byte(handler.AdminSecret)) == 1 {
+		return nil
+	}
+
+	return &UnauthorizedError{Request: name}
+}
+
+// AdminRequestNames returns the JSON names of the AdminRequest requests
+func AdminRequestNames() []string {
+	return requestNames(reflect.TypeOf(AdminRequest{}))
+}
+
+// RedactExtendedRequest returns the serialized extended request with any admin secret removed, so it
+// can be logged or captured. Data which is not a valid request is returned unchanged.
+func RedactExtendedRequest(data []byte) []byte {
+	req := &ExtendedRequest{}
+	if json.Unmarshal(data, req) != nil || req.Admin == nil || len(req.Admin.Secret) == 0 {
+		return data
+	}
+
+	req.Admin.Secret = "<redacted>"
+	redacted, err := json.Marshal(req)
+	if err != nil {
+		return data
+	}
+
+	return redacted
+}
+
+// setFieldName returns the JSON name of the first non-nil pointer field of a request envelope
+func setFieldName(req interface{}) string {
+	v := reflect.ValueOf(req).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Ptr && !v.Field(i).IsNil() {
+			return strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		}
+	}
+
+	return ""
+}
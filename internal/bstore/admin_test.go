@@ -0,0 +1,60 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestAdminAuthorization(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), ExportDir: t.TempDir()}
+	buildLinearChain(t, &handler, 5)
+
+	export := func(secret string) *ExtendedResponse {
+		return handler.HandleExtendedRequest(&ExtendedRequest{
+			Admin: &AdminRequest{Secret: secret, ExportChain: &ExportChainRequest{Format: ExportCSV, StartHeight: 1}},
+		})
+	}
+
+	// Without a secret or allowlist every admin request is rejected
+	resp := export("")
+	if resp.Error == nil || resp.Error.Code != ErrorCodeUnauthorized || resp.Error.Details["request"] != "export_chain" {
+		t.Errorf("expected unauthorized error, got %+v", resp.Error)
+	}
+
+	handler.AdminSecret = "secret"
+	if resp = export("wrong"); resp.Error == nil || resp.Error.Code != ErrorCodeUnauthorized {
+		t.Errorf("expected unauthorized error for wrong secret, got %+v", resp.Error)
+	}
+	if resp = export("secret"); resp.Error != nil {
+		t.Errorf("unexpected error with secret %s", resp.Error.Message)
+	}
+
+	// Allowlisted requests do not need the secret, others still do
+	handler.AdminAllowlist = []string{"export_chain"}
+	if resp = export(""); resp.Error != nil {
+		t.Errorf("unexpected error for allowlisted request %s", resp.Error.Message)
+	}
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{CompactStore: &CompactStoreRequest{}}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeUnauthorized {
+		t.Errorf("expected unauthorized error, got %+v", resp.Error)
+	}
+
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret"}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeInvalidRequest {
+		t.Errorf("expected invalid request error, got %+v", resp.Error)
+	}
+}
+
+func TestRedactExtendedRequest(t *testing.T) {
+	data, _ := json.Marshal(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", CompactStore: &CompactStoreRequest{}}})
+	redacted := RedactExtendedRequest(data)
+	if bytes.Contains(redacted, []byte(`:"secret"`)) || !bytes.Contains(redacted, []byte("compact_store")) {
+		t.Errorf("secret not redacted: %s", string(redacted))
+	}
+
+	data = []byte(`{"get_status":{}}`)
+	if !bytes.Equal(RedactExtendedRequest(data), data) {
+		t.Error("expected request without secret to be unchanged")
+	}
+}
@@ -0,0 +1,144 @@
+package bstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AMQPOptions are the credentials and TLS options of a connection to an AMQP server, applied to its
+// URL so they need not be written into the URL given in the configuration
+type AMQPOptions struct {
+	// Username and Password replace the credentials of the URL if Username is set
+	Username string
+	Password string
+
+	// CAFile is a PEM file of the CAs the server certificate is verified with, the system CAs are used
+	// if it is empty. CertFile and KeyFile are the PEM client certificate and key, for servers
+	// requiring mutual TLS. ServerName is the name the server certificate is verified against, the
+	// host of the URL if it is empty. They require an amqps URL.
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// ReadAMQPCredentials reads a credentials file holding a single 'username:password' line
+func ReadAMQPCredentials(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	credentials := strings.TrimSpace(string(data))
+	i := strings.Index(credentials, ":")
+	if i <= 0 {
+		return "", "", errors.New("credentials must have the form 'username:password'")
+	}
+
+	return credentials[:i], credentials[i+1:], nil
+}
+
+// URL returns amqpURL with the credentials and TLS options applied. The TLS files are loaded to check
+// they are valid, as the AMQP client only reads them when it connects and retries a failed connection
+// indefinitely.
+func (o *AMQPOptions) URL(amqpURL string) (string, error) {
+	u, err := url.Parse(amqpURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid AMQP URL, %s", RedactAMQPURLs(err.Error()))
+	}
+	if u.Scheme != "amqp" && u.Scheme != "amqps" {
+		return "", fmt.Errorf("AMQP URL scheme must be amqp or amqps (was %v)", u.Scheme)
+	}
+
+	if len(o.Username) > 0 {
+		u.User = url.UserPassword(o.Username, o.Password)
+	}
+
+	tlsOptions := len(o.CAFile) > 0 || len(o.CertFile) > 0 || len(o.KeyFile) > 0 || len(o.ServerName) > 0
+	if !tlsOptions {
+		return u.String(), nil
+	}
+	if u.Scheme != "amqps" {
+		return "", errors.New("TLS options require an amqps URL")
+	}
+	if (len(o.CertFile) > 0) != (len(o.KeyFile) > 0) {
+		return "", errors.New("a client certificate requires both a certificate and a key file")
+	}
+
+	// The query parameters are those read by the AMQP client
+	query := u.Query()
+	if len(o.CAFile) > 0 {
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read CA file, %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return "", fmt.Errorf("CA file %v contains no PEM certificate", o.CAFile)
+		}
+		query.Set("cacertfile", o.CAFile)
+	}
+	if len(o.CertFile) > 0 {
+		if _, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile); err != nil {
+			return "", fmt.Errorf("could not load client certificate, %w", err)
+		}
+		query.Set("certfile", o.CertFile)
+		query.Set("keyfile", o.KeyFile)
+	}
+	if len(o.ServerName) > 0 {
+		query.Set("server_name_indication", o.ServerName)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+var amqpPasswordPattern = regexp.MustCompile(`(amqps?://[^:@/\s]*:)[^@/\s]*@`)
+
+// RedactAMQPURLs replaces the password of the AMQP URLs in s
+func RedactAMQPURLs(s string) string {
+	return amqpPasswordPattern.ReplaceAllString(s, "${1}xxxxx@")
+}
+
+// redactingCore is a log core redacting the passwords of AMQP URLs from the messages it writes
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{c.Core.With(fields)}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = RedactAMQPURLs(entry.Message)
+	return c.Core.Write(entry, fields)
+}
+
+// RedactAMQPLogs wraps the global logger so the passwords of AMQP URLs are redacted from its messages,
+// including those of the AMQP client, which logs the URL it connects to. It must be called again
+// after the logger is initialized.
+func RedactAMQPLogs() {
+	logger := zap.L()
+	if _, ok := logger.Core().(*redactingCore); ok {
+		return
+	}
+
+	zap.ReplaceGlobals(logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{core}
+	})))
+}
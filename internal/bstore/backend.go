@@ -1,5 +1,24 @@
 package bstore
 
+import (
+	"errors"
+	"io"
+)
+
+// ErrStopIteration is returned by the function given to Iterate to stop the iteration early, Iterate
+// then returns nil
+var ErrStopIteration = errors.New("stop iteration")
+
+// ErrTxnConflict is returned by the Commit of a transaction when a key it read was written after the
+// transaction began, the transaction may be retried
+var ErrTxnConflict = errors.New("transaction conflict")
+
+// KeyValue is a key and the value to store in it
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
 // BlockStoreBackend interface defines an abstract key-value store
 type BlockStoreBackend interface {
 	/**
@@ -7,6 +26,11 @@ type BlockStoreBackend interface {
 	 */
 	Put(key []byte, value []byte) error
 
+	/**
+	 * Store the given values atomically, either all of them are stored or none is.
+	 */
+	PutBatch(pairs []*KeyValue) error
+
 	/**
 	 * Deletes the value at the given key.
 	 */
@@ -19,6 +43,267 @@ type BlockStoreBackend interface {
 	 */
 	Get(key []byte) ([]byte, error)
 
+	/**
+	 * Report whether a value is stored in the given key. Backends which can check a key without
+	 * reading its value, such as Badger, do so.
+	 */
+	Has(key []byte) (bool, error)
+
+	/**
+	 * Call fn with every key starting with prefix and its value, in ascending key order.
+	 *
+	 * The iteration stops at the first error returned by fn, which is returned, unless it is
+	 * ErrStopIteration. fn may keep the key and value but must not write to the backend.
+	 */
+	Iterate(prefix []byte, fn func(key []byte, value []byte) error) error
+
+	/**
+	 * Take a read-only view of the database pinned to the current point in time.
+	 *
+	 * Writes made after the snapshot is taken are not seen through it. The snapshot must be
+	 * released, and should be released promptly as it holds resources of the database. Backends
+	 * which cannot pin a point in time, such as a remote block store, return a view of their
+	 * current state.
+	 */
+	Snapshot() (BackendSnapshot, error)
+
+	/**
+	 * Make the writes stored so far durable, so they survive a crash of the process or the machine.
+	 *
+	 * Backends which already make every write durable, or store nothing locally, return nil.
+	 */
+	Flush() error
+
+	/**
+	 * Reclaim the space of deleted and overwritten values, reporting the size of the database before
+	 * and after. Badger garbage collects its value log, rewriting files with at least discardRatio of
+	 * stale data, and flattens its LSM tree. Backends with nothing to reclaim return unchanged sizes.
+	 */
+	Compact(discardRatio float64) (*CompactionResult, error)
+
+	/**
+	 * Write a consistent backup of the entries newer than sinceVersion to w, returning the version to
+	 * pass as sinceVersion to back up the entries written since. A sinceVersion of 0 backs up
+	 * everything. Backends without a backup format of their own write a backup stream of their records,
+	 * and only support full backups.
+	 */
+	Backup(w io.Writer, sinceVersion uint64) (uint64, error)
+
+	/**
+	 * Replace the contents of the database with a backup written by Backup of the same kind of backend.
+	 */
+	Load(r io.Reader) error
+
+	/**
+	 * Begin a read-write transaction. Reads through it see its own writes, which are stored
+	 * atomically on Commit.
+	 *
+	 * Commit returns ErrTxnConflict if a key read by the transaction was written after it began.
+	 * Backends which cannot detect conflicts collect the writes in a batch, their transactions read
+	 * the current state of the database and the caller must not write concurrently.
+	 */
+	Begin() (BackendTxn, error)
+
 	// Resets the entire database
 	Reset() error
 }
+
+// BackendSnapshot is a read-only view of a backend pinned to the point in time it was taken, so reads
+// of several keys see either all or none of a write
+type BackendSnapshot interface {
+	// Get returns the value of key when the snapshot was taken, an empty value if it was not found
+	Get(key []byte) ([]byte, error)
+
+	// Iterate calls fn with the keys starting with prefix and their values when the snapshot was
+	// taken, like BlockStoreBackend.Iterate
+	Iterate(prefix []byte, fn func(key []byte, value []byte) error) error
+
+	// Release releases the resources held by the snapshot, it must not be used afterwards
+	Release()
+}
+
+// BackendTxn is a read-write transaction of a backend. It must be committed or rolled back, and must
+// not be used by several goroutines at once.
+type BackendTxn interface {
+	// Get returns the value of key, an empty value if it was not found
+	Get(key []byte) ([]byte, error)
+
+	// Has reports whether a value is stored in key, like BlockStoreBackend.Has
+	Has(key []byte) (bool, error)
+
+	// Iterate calls fn with the keys starting with prefix and their values, like
+	// BlockStoreBackend.Iterate
+	Iterate(prefix []byte, fn func(key []byte, value []byte) error) error
+
+	// Put stores the value in key when the transaction is committed
+	Put(key []byte, value []byte) error
+
+	// Delete removes key when the transaction is committed
+	Delete(key []byte) error
+
+	// Commit stores the writes of the transaction atomically, the transaction must not be used
+	// afterwards
+	Commit() error
+
+	// Rollback discards the writes of the transaction, the transaction must not be used afterwards
+	Rollback()
+}
+
+// liveSnapshot reads the current state of a backend which cannot pin a point in time, such as a
+// remote block store
+type liveSnapshot struct {
+	backend BlockStoreBackend
+}
+
+func (snapshot *liveSnapshot) Get(key []byte) ([]byte, error) {
+	return snapshot.backend.Get(key)
+}
+
+func (snapshot *liveSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return snapshot.backend.Iterate(prefix, fn)
+}
+
+func (snapshot *liveSnapshot) Release() {
+}
+
+// valueSnapshot decodes the values read from the snapshot of a wrapped backend, such as the
+// encrypted values of an EncryptedBackend
+type valueSnapshot struct {
+	snapshot BackendSnapshot
+	decode   func(key []byte, value []byte) ([]byte, error)
+}
+
+func (snapshot *valueSnapshot) Get(key []byte) ([]byte, error) {
+	value, err := snapshot.snapshot.Get(key)
+	if err != nil || len(value) == 0 {
+		return value, err
+	}
+
+	return snapshot.decode(key, value)
+}
+
+func (snapshot *valueSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return snapshot.snapshot.Iterate(prefix, func(key []byte, value []byte) error {
+		value, err := snapshot.decode(key, value)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+func (snapshot *valueSnapshot) Release() {
+	snapshot.snapshot.Release()
+}
+
+// batchTxn collects the writes of a transaction of a backend which cannot detect conflicts in a
+// BatchBackend. Like those of a batch, its deletes return an error.
+type batchTxn struct {
+	*BatchBackend
+}
+
+// newBatchTxn begins a transaction of backend batching its writes
+func newBatchTxn(backend BlockStoreBackend) *batchTxn {
+	return &batchTxn{BatchBackend: NewBatchBackend(backend)}
+}
+
+func (txn *batchTxn) Rollback() {
+}
+
+// valueTxn encodes the values written through the transaction of a wrapped backend, such as the
+// values encrypted by an EncryptedBackend, and decodes those read through it
+type valueTxn struct {
+	txn    BackendTxn
+	encode func(key []byte, value []byte) ([]byte, error)
+	decode func(key []byte, value []byte) ([]byte, error)
+}
+
+func (txn *valueTxn) Get(key []byte) ([]byte, error) {
+	value, err := txn.txn.Get(key)
+	if err != nil || len(value) == 0 {
+		return value, err
+	}
+
+	return txn.decode(key, value)
+}
+
+func (txn *valueTxn) Has(key []byte) (bool, error) {
+	return txn.txn.Has(key)
+}
+
+func (txn *valueTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return txn.txn.Iterate(prefix, func(key []byte, value []byte) error {
+		if len(value) > 0 {
+			var err error
+			if value, err = txn.decode(key, value); err != nil {
+				return err
+			}
+		}
+		return fn(key, value)
+	})
+}
+
+func (txn *valueTxn) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	encoded, err := txn.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	return txn.txn.Put(key, encoded)
+}
+
+func (txn *valueTxn) Delete(key []byte) error {
+	return txn.txn.Delete(key)
+}
+
+func (txn *valueTxn) Commit() error {
+	return txn.txn.Commit()
+}
+
+func (txn *valueTxn) Rollback() {
+	txn.txn.Rollback()
+}
+
+// checkPairs returns an error if a pair of a batch has a nil key or value
+func checkPairs(pairs []*KeyValue) error {
+	for _, pair := range pairs {
+		if pair.Key == nil {
+			return errors.New("cannot put a nil key")
+		}
+		if pair.Value == nil {
+			return errors.New("cannot put a nil value")
+		}
+	}
+
+	return nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// stopIteration returns the error of an iteration, which is nil if it was stopped with
+// ErrStopIteration
+func stopIteration(err error) error {
+	if err == ErrStopIteration {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,634 @@
+package bstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/options"
+	badger4 "github.com/dgraph-io/badger/v4"
+	options4 "github.com/dgraph-io/badger/v4/options"
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// sqliteFile, boltFile and mapSnapshotFile are the names of the SQLite, bbolt and map backend
+// database files in their directories
+const (
+	sqliteFile      = "block_store.db"
+	boltFile        = "block_store.db"
+	mapSnapshotFile = "block_store.gob"
+
+	// DefaultMapSnapshotInterval is the default interval between snapshots of the memory backend
+	DefaultMapSnapshotInterval = time.Minute
+
+	// DefaultEncryptedIndexCacheSize is the index cache size of an encrypted Badger database, unless
+	// set by the memory budget or the index-cache-size option
+	DefaultEncryptedIndexCacheSize = 64 << 20
+)
+
+// ClosableBackend is a database backend which must be closed on shutdown
+type ClosableBackend interface {
+	BlockStoreBackend
+	Close()
+}
+
+// BackendConfig is the configuration a registered backend is opened with
+type BackendConfig struct {
+	// Dir is the directory of a local backend, it exists when the backend is opened
+	Dir string
+
+	// Budget sizes the database caches, nil if memory is not limited
+	Budget *MemoryBudget
+
+	// ReadOnly opens the database without writing to it
+	ReadOnly bool
+
+	// Options are the backend specific options, such as those of its block in the YAML config
+	Options map[string]interface{}
+}
+
+// StringOption returns the string option key, or defaultValue if it is not set
+func (config *BackendConfig) StringOption(key string, defaultValue string) (string, error) {
+	value, ok := config.Options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	option, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("option '%s' must be a string (was %v)", key, value)
+	}
+
+	return option, nil
+}
+
+// IntOption returns the integer option key, or defaultValue if it is not set
+func (config *BackendConfig) IntOption(key string, defaultValue int) (int, error) {
+	value, ok := config.Options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	switch option := value.(type) {
+	case int:
+		return option, nil
+	case int64:
+		return int(option), nil
+	case uint64:
+		return int(option), nil
+	case string:
+		if parsed, err := strconv.Atoi(option); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return 0, fmt.Errorf("option '%s' must be an integer (was %v)", key, value)
+}
+
+// FloatOption returns the number option key, or defaultValue if it is not set
+func (config *BackendConfig) FloatOption(key string, defaultValue float64) (float64, error) {
+	value, ok := config.Options[key]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	switch option := value.(type) {
+	case float64:
+		return option, nil
+	case int:
+		return float64(option), nil
+	case int64:
+		return float64(option), nil
+	case uint64:
+		return float64(option), nil
+	case string:
+		if parsed, err := strconv.ParseFloat(option, 64); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return 0, fmt.Errorf("option '%s' must be a number (was %v)", key, value)
+}
+
+// Dirs returns the directories of a local backend, Dir and the value log directory of Badger if set
+func (config *BackendConfig) Dirs() []string {
+	dirs := []string{config.Dir}
+	if valueDir, err := config.StringOption("value-dir", ""); err == nil && len(valueDir) > 0 {
+		dirs = append(dirs, valueDir)
+	}
+
+	return dirs
+}
+
+// cacheSize returns the cache size of backends with a single cache, 0 for their default
+func (config *BackendConfig) cacheSize() int64 {
+	if config.Budget == nil {
+		return 0
+	}
+
+	return config.Budget.BlockCacheSize + config.Budget.IndexCacheSize
+}
+
+// badgerTuning holds the Badger options set in the backend options, zero values keep the defaults
+type badgerTuning struct {
+	memTableSize   int64
+	blockCacheSize int64
+	compression    string
+	numCompactors  int
+	valueThreshold int64
+	indexCacheSize int64
+	encryptionKey  []byte
+	valueDir       string
+}
+
+// badgerCompressions are the values of the compression option
+var badgerCompressions = []string{"none", "snappy", "zstd"}
+
+func isBadgerCompression(compression string) bool {
+	for _, name := range badgerCompressions {
+		if name == compression {
+			return true
+		}
+	}
+
+	return false
+}
+
+// badgerTuning reads the Badger options, the sizes are given in MiB and the value threshold in bytes
+func (config *BackendConfig) badgerTuning() (*badgerTuning, error) {
+	tuning := &badgerTuning{}
+
+	memTableSize, err := config.IntOption("memtable-size", 0)
+	if err != nil {
+		return nil, err
+	}
+	if memTableSize < 0 {
+		return nil, fmt.Errorf("option 'memtable-size' must not be negative (was %v)", memTableSize)
+	}
+	tuning.memTableSize = int64(memTableSize) << 20
+
+	blockCacheSize, err := config.IntOption("block-cache-size", 0)
+	if err != nil {
+		return nil, err
+	}
+	if blockCacheSize < 0 {
+		return nil, fmt.Errorf("option 'block-cache-size' must not be negative (was %v)", blockCacheSize)
+	}
+	tuning.blockCacheSize = int64(blockCacheSize) << 20
+
+	if tuning.compression, err = config.StringOption("compression", ""); err != nil {
+		return nil, err
+	}
+	if len(tuning.compression) > 0 && !isBadgerCompression(tuning.compression) {
+		return nil, fmt.Errorf("option 'compression' must be one of %s (was %v)", strings.Join(badgerCompressions, ", "), tuning.compression)
+	}
+
+	// Badger needs at least two compactors, one of which is dedicated to level 0
+	if tuning.numCompactors, err = config.IntOption("num-compactors", 0); err != nil {
+		return nil, err
+	}
+	if tuning.numCompactors < 0 || tuning.numCompactors == 1 {
+		return nil, fmt.Errorf("option 'num-compactors' must be at least 2 (was %v)", tuning.numCompactors)
+	}
+
+	valueThreshold, err := config.IntOption("value-threshold", 0)
+	if err != nil {
+		return nil, err
+	}
+	if valueThreshold < 0 {
+		return nil, fmt.Errorf("option 'value-threshold' must not be negative (was %v)", valueThreshold)
+	}
+	tuning.valueThreshold = int64(valueThreshold)
+
+	indexCacheSize, err := config.IntOption("index-cache-size", 0)
+	if err != nil {
+		return nil, err
+	}
+	if indexCacheSize < 0 {
+		return nil, fmt.Errorf("option 'index-cache-size' must not be negative (was %v)", indexCacheSize)
+	}
+	tuning.indexCacheSize = int64(indexCacheSize) << 20
+
+	if tuning.encryptionKey, err = config.badgerEncryptionKey(); err != nil {
+		return nil, err
+	}
+
+	if tuning.valueDir, err = config.StringOption("value-dir", ""); err != nil {
+		return nil, err
+	}
+
+	return tuning, nil
+}
+
+// badgerEncryptionKey returns the key of the file in the encryption-key-file option, nil if it is
+// not set
+func (config *BackendConfig) badgerEncryptionKey() ([]byte, error) {
+	keyFile, err := config.StringOption("encryption-key-file", "")
+	if err != nil || len(keyFile) == 0 {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("option 'encryption-key-file' must be a readable file, %w", err)
+	}
+
+	return ParseEncryptionKey(string(data))
+}
+
+// badgerOptions returns the Badger options of a database in dir. The options set in the backend
+// options take precedence over the sizes of the memory budget.
+func (config *BackendConfig) badgerOptions(dir string) (badger.Options, error) {
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = KoinosBadgerLogger{}
+	opts.ReadOnly = config.ReadOnly
+	if config.Budget != nil {
+		opts = config.Budget.Apply(opts)
+	}
+
+	tuning, err := config.badgerTuning()
+	if err != nil {
+		return opts, err
+	}
+
+	if tuning.memTableSize > 0 {
+		opts = opts.WithMemTableSize(tuning.memTableSize)
+	}
+	if tuning.blockCacheSize > 0 {
+		opts = opts.WithBlockCacheSize(tuning.blockCacheSize)
+	}
+	switch tuning.compression {
+	case "none":
+		opts = opts.WithCompression(options.None)
+	case "snappy":
+		opts = opts.WithCompression(options.Snappy)
+	case "zstd":
+		opts = opts.WithCompression(options.ZSTD)
+	}
+	if tuning.numCompactors > 0 {
+		opts = opts.WithNumCompactors(tuning.numCompactors)
+	}
+	if tuning.valueThreshold > 0 {
+		opts = opts.WithValueThreshold(tuning.valueThreshold)
+	}
+	if tuning.indexCacheSize > 0 {
+		opts = opts.WithIndexCacheSize(tuning.indexCacheSize)
+	}
+
+	// Backends with several databases choose their own directories
+	if len(dir) > 0 && len(tuning.valueDir) > 0 {
+		opts = opts.WithValueDir(tuning.valueDir)
+	}
+
+	// Badger decrypts the table indexes on every read unless they are cached
+	if tuning.encryptionKey != nil {
+		opts = opts.WithEncryptionKey(tuning.encryptionKey)
+		if opts.IndexCacheSize == 0 {
+			opts = opts.WithIndexCacheSize(DefaultEncryptedIndexCacheSize)
+		}
+	}
+
+	return opts, nil
+}
+
+// badger4Options returns the Badger v4 options of a database in dir, tuned like those of badgerOptions
+func (config *BackendConfig) badger4Options(dir string) (badger4.Options, error) {
+	opts := badger4.DefaultOptions(dir)
+	opts.Logger = KoinosBadgerLogger{}
+	opts.ReadOnly = config.ReadOnly
+	if config.Budget != nil {
+		opts = opts.
+			WithBlockCacheSize(config.Budget.BlockCacheSize).
+			WithIndexCacheSize(config.Budget.IndexCacheSize).
+			WithMemTableSize(config.Budget.MemTableSize).
+			WithNumMemtables(config.Budget.NumMemtables)
+	}
+
+	tuning, err := config.badgerTuning()
+	if err != nil {
+		return opts, err
+	}
+
+	if tuning.memTableSize > 0 {
+		opts = opts.WithMemTableSize(tuning.memTableSize)
+	}
+	if tuning.blockCacheSize > 0 {
+		opts = opts.WithBlockCacheSize(tuning.blockCacheSize)
+	}
+	switch tuning.compression {
+	case "none":
+		opts = opts.WithCompression(options4.None)
+	case "snappy":
+		opts = opts.WithCompression(options4.Snappy)
+	case "zstd":
+		opts = opts.WithCompression(options4.ZSTD)
+	}
+	if tuning.numCompactors > 0 {
+		opts = opts.WithNumCompactors(tuning.numCompactors)
+	}
+	if tuning.valueThreshold > 0 {
+		opts = opts.WithValueThreshold(tuning.valueThreshold)
+	}
+	if tuning.indexCacheSize > 0 {
+		opts = opts.WithIndexCacheSize(tuning.indexCacheSize)
+	}
+
+	// Backends with several databases choose their own directories
+	if len(dir) > 0 && len(tuning.valueDir) > 0 {
+		opts = opts.WithValueDir(tuning.valueDir)
+	}
+
+	// Badger decrypts the table indexes on every read unless they are cached
+	if tuning.encryptionKey != nil {
+		opts = opts.WithEncryptionKey(tuning.encryptionKey)
+		if opts.IndexCacheSize == 0 {
+			opts = opts.WithIndexCacheSize(DefaultEncryptedIndexCacheSize)
+		}
+	}
+
+	return opts, nil
+}
+
+// BackendRegistration describes a backend which can be selected by name
+type BackendRegistration struct {
+	// Local is set if the backend stores its database in BackendConfig.Dir
+	Local bool
+
+	// Open opens the backend
+	Open func(config *BackendConfig) (ClosableBackend, error)
+}
+
+var (
+	backendRegistryLock sync.RWMutex
+	backendRegistry     = make(map[string]*BackendRegistration)
+)
+
+// RegisterBackend makes a backend selectable by name, it panics if the name is already registered
+func RegisterBackend(name string, registration *BackendRegistration) {
+	backendRegistryLock.Lock()
+	defer backendRegistryLock.Unlock()
+
+	if _, ok := backendRegistry[name]; ok {
+		panic(fmt.Sprintf("backend %s is already registered", name))
+	}
+	backendRegistry[name] = registration
+}
+
+// LookupBackend returns the registration of a backend, false if no backend is registered under name
+func LookupBackend(name string) (*BackendRegistration, bool) {
+	backendRegistryLock.RLock()
+	defer backendRegistryLock.RUnlock()
+
+	registration, ok := backendRegistry[name]
+	return registration, ok
+}
+
+// BackendNames returns the names of the registered backends in alphabetical order
+func BackendNames() []string {
+	backendRegistryLock.RLock()
+	defer backendRegistryLock.RUnlock()
+
+	names := make([]string, 0, len(backendRegistry))
+	for name := range backendRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// OpenBackend opens the backend registered under name
+func OpenBackend(name string, config *BackendConfig) (ClosableBackend, error) {
+	registration, ok := LookupBackend(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %s, must be one of %s", name, strings.Join(BackendNames(), ", "))
+	}
+
+	return registration.Open(config)
+}
+
+// closable returns backend as a ClosableBackend, or the error if opening it failed. Constructors return
+// a typed nil pointer on error, which must not be returned as a non-nil interface.
+func closable(backend ClosableBackend, err error) (ClosableBackend, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func init() {
+	RegisterBackend("badger", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		intervalOption, err := config.StringOption("gc-interval", DefaultValueLogGCInterval.String())
+		if err != nil {
+			return nil, err
+		}
+		interval, err := time.ParseDuration(intervalOption)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("option 'gc-interval' must be a non-negative duration (was %v)", intervalOption)
+		}
+
+		discardRatio, err := config.FloatOption("gc-discard-ratio", defaultDiscardRatio)
+		if err != nil {
+			return nil, err
+		}
+		if discardRatio <= 0 || discardRatio >= 1 {
+			return nil, fmt.Errorf("option 'gc-discard-ratio' must be between 0 and 1 (was %v)", discardRatio)
+		}
+
+		opts, err := config.badgerOptions(config.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		backend, err := NewBadgerBackend(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// A read-only database has no stale data to reclaim
+		if interval > 0 && !config.ReadOnly {
+			backend.StartValueLogGC(interval, discardRatio)
+		}
+
+		return backend, nil
+	}})
+
+	RegisterBackend("badger4", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		// A Badger v3 database, by default the one of the badger backend, is migrated on first start
+		source, err := config.StringOption("migrate-from", filepath.Join(filepath.Dir(config.Dir), "db"))
+		if err != nil {
+			return nil, err
+		}
+
+		opts, err := config.badger4Options(config.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(source) > 0 && !isBadgerDir(config.Dir) && isBadgerDir(source) {
+			if config.ReadOnly {
+				return nil, fmt.Errorf("the Badger v3 database %s has not been migrated yet", source)
+			}
+			if opts.ValueDir != opts.Dir {
+				return nil, errors.New("option 'value-dir' cannot be set until the Badger v3 database is migrated")
+			}
+
+			log.Infof("Migrating Badger v3 database %s to %s", source, config.Dir)
+			records, err := MigrateBadgerV3(source, config.Dir, opts.WithDir("").WithValueDir(""))
+			if err != nil {
+				return nil, fmt.Errorf("could not migrate Badger v3 database, %w", err)
+			}
+			log.Infof("Migrated %d record(s), the Badger v3 database in %s is no longer used", records, source)
+		}
+
+		return closable(NewBadger4Backend(opts))
+	}})
+
+	RegisterBackend("rocksdb", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		// RocksDB keeps its index and filter blocks in the block cache
+		return closable(NewRocksDBBackend(config.Dir, config.cacheSize()))
+	}})
+
+	RegisterBackend("sqlite", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		return closable(NewSQLiteBackend(filepath.Join(config.Dir, sqliteFile), config.cacheSize()))
+	}})
+
+	RegisterBackend("postgres", &BackendRegistration{Open: func(config *BackendConfig) (ClosableBackend, error) {
+		url, err := config.StringOption("url", "")
+		if err != nil {
+			return nil, err
+		}
+		if len(url) == 0 {
+			return nil, errors.New("option 'url' is required")
+		}
+
+		table, err := config.StringOption("table", DefaultPostgresTable)
+		if err != nil {
+			return nil, err
+		}
+
+		// The URL may contain credentials, it is not logged
+		log.Infof("Opening database table %s", table)
+		return closable(NewPostgresBackend(url, table))
+	}})
+
+	RegisterBackend("bolt", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		return closable(NewBoltBackend(filepath.Join(config.Dir, boltFile)))
+	}})
+
+	RegisterBackend("pebble", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		return closable(NewPebbleBackend(config.Dir, config.cacheSize()))
+	}})
+
+	RegisterBackend("hybrid", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		fileThreshold, err := config.IntOption("file-threshold", DefaultHybridFileThreshold>>10)
+		if err != nil {
+			return nil, err
+		}
+		if fileThreshold <= 0 {
+			return nil, fmt.Errorf("option 'file-threshold' must be greater than 0 (was %v)", fileThreshold)
+		}
+
+		opts, err := config.badgerOptions("")
+		if err != nil {
+			return nil, err
+		}
+
+		return closable(NewHybridBackend(config.Dir, fileThreshold<<10, opts))
+	}})
+
+	RegisterBackend("segment", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		segmentSize, err := config.IntOption("segment-size", DefaultSegmentSize>>20)
+		if err != nil {
+			return nil, err
+		}
+		if segmentSize <= 0 {
+			return nil, fmt.Errorf("option 'segment-size' must be greater than 0 (was %v)", segmentSize)
+		}
+
+		opts, err := config.badgerOptions("")
+		if err != nil {
+			return nil, err
+		}
+
+		return closable(NewSegmentBackend(config.Dir, int64(segmentSize)<<20, opts))
+	}})
+
+	RegisterBackend("sharded", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		shardSize, err := config.IntOption("shard-size", DefaultShardSize)
+		if err != nil {
+			return nil, err
+		}
+		if shardSize <= 0 {
+			return nil, fmt.Errorf("option 'shard-size' must be greater than 0 (was %v)", shardSize)
+		}
+
+		opts, err := config.badgerOptions("")
+		if err != nil {
+			return nil, err
+		}
+
+		return closable(NewShardedBackend(config.Dir, uint64(shardSize), opts))
+	}})
+
+	RegisterBackend("remote", &BackendRegistration{Open: func(config *BackendConfig) (ClosableBackend, error) {
+		amqpURL, err := config.StringOption("amqp", "")
+		if err != nil {
+			return nil, err
+		}
+		if len(amqpURL) == 0 {
+			return nil, errors.New("option 'amqp' is required")
+		}
+
+		secretFile, err := config.StringOption("secret-file", "")
+		if err != nil {
+			return nil, err
+		}
+
+		var secret string
+		if len(secretFile) > 0 {
+			data, err := os.ReadFile(secretFile)
+			if err != nil {
+				return nil, fmt.Errorf("option 'secret-file' must be a readable file, %w", err)
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+
+		log.Info("Connecting to the remote block store")
+		return DialRemoteBackend(amqpURL, secret), nil
+	}})
+
+	RegisterBackend("memory", &BackendRegistration{Local: true, Open: func(config *BackendConfig) (ClosableBackend, error) {
+		intervalOption, err := config.StringOption("snapshot-interval", DefaultMapSnapshotInterval.String())
+		if err != nil {
+			return nil, err
+		}
+		interval, err := time.ParseDuration(intervalOption)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("option 'snapshot-interval' must be a non-negative duration (was %v)", intervalOption)
+		}
+
+		maxSize, err := config.IntOption("max-size", 0)
+		if err != nil {
+			return nil, err
+		}
+		if maxSize < 0 {
+			return nil, fmt.Errorf("option 'max-size' must not be negative (was %v)", maxSize)
+		}
+
+		return closable(OpenMapBackend(filepath.Join(config.Dir, mapSnapshotFile), interval, int64(maxSize)<<20))
+	}})
+
+	RegisterBackend("null", &BackendRegistration{Open: func(config *BackendConfig) (ClosableBackend, error) {
+		log.Warn("Using the null backend, blocks are not stored")
+		return NewNullBackend(), nil
+	}})
+}
@@ -0,0 +1,166 @@
+package bstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/options"
+	options4 "github.com/dgraph-io/badger/v4/options"
+)
+
+func TestBackendRegistry(t *testing.T) {
+	names := strings.Join(BackendNames(), ",")
+	if names != "badger,badger4,bolt,hybrid,memory,null,pebble,postgres,remote,rocksdb,segment,sharded,sqlite" {
+		t.Errorf("unexpected registered backends %s", names)
+	}
+
+	if _, err := OpenBackend("tape", &BackendConfig{}); err == nil || !strings.Contains(err.Error(), "badger, badger4") {
+		t.Errorf("expected an error listing the registered backends, got %v", err)
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// Options come from the YAML config, numbers may be given as strings
+	b, err := OpenBackend("segment", &BackendConfig{Dir: dir, Options: map[string]interface{}{"segment-size": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segment := b.(*SegmentBackend); segment.SegmentSize != 1<<20 {
+		t.Errorf("expected 1 MiB segments, got %d", segment.SegmentSize)
+	}
+	backendTest(t, b)
+	b.Close()
+
+	if _, err = OpenBackend("sharded", &BackendConfig{Dir: dir, Options: map[string]interface{}{"shard-size": 0}}); err == nil {
+		t.Error("expected an error opening shards of size 0")
+	}
+	if _, err = OpenBackend("sharded", &BackendConfig{Dir: dir, Options: map[string]interface{}{"shard-size": true}}); err == nil {
+		t.Error("expected an error for a shard size which is not a number")
+	}
+	if _, err = OpenBackend("badger", &BackendConfig{Dir: dir, Options: map[string]interface{}{"gc-discard-ratio": 1.5}}); err == nil {
+		t.Error("expected an error for a discard ratio above 1")
+	}
+	if _, err = OpenBackend("badger", &BackendConfig{Dir: dir, Options: map[string]interface{}{"gc-interval": "often"}}); err == nil {
+		t.Error("expected an error for an invalid garbage collection interval")
+	}
+
+	// The background value log garbage collection is stopped by Close
+	b, err = OpenBackend("badger", &BackendConfig{Dir: filepath.Join(dir, "badger"), Options: map[string]interface{}{"gc-interval": "1ms", "gc-discard-ratio": "0.7"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.(*BadgerBackend).gcStop == nil {
+		t.Error("expected the value log garbage collection to be started")
+	}
+	buildLinearChain(t, &RequestHandler{Backend: b}, 10)
+	time.Sleep(10 * time.Millisecond)
+	b.Close()
+
+	if _, err = OpenBackend("postgres", &BackendConfig{}); err == nil || !strings.Contains(err.Error(), "'url'") {
+		t.Errorf("expected an error for the missing url, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a backend twice to panic")
+		}
+	}()
+	RegisterBackend("null", &BackendRegistration{})
+}
+
+func TestBadgerTuning(t *testing.T) {
+	// The tuning options take precedence over the memory budget
+	config := &BackendConfig{
+		Budget: NewMemoryBudget(256 << 20),
+		Options: map[string]interface{}{
+			"memtable-size":    16,
+			"block-cache-size": "32",
+			"compression":      "zstd",
+			"num-compactors":   2,
+			"value-threshold":  4096,
+		},
+	}
+	opts, err := config.badgerOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MemTableSize != 16<<20 || opts.BlockCacheSize != 32<<20 || opts.Compression != options.ZSTD || opts.NumCompactors != 2 || opts.ValueThreshold != 4096 {
+		t.Errorf("expected the tuning options to be applied, got %+v", opts)
+	}
+	if opts.IndexCacheSize != config.Budget.IndexCacheSize {
+		t.Errorf("expected the index cache size of the budget, got %v", opts.IndexCacheSize)
+	}
+
+	opts4, err := config.badger4Options("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts4.MemTableSize != 16<<20 || opts4.Compression != options4.ZSTD || opts4.ValueThreshold != 4096 {
+		t.Errorf("expected the tuning options to be applied to Badger v4, got %+v", opts4)
+	}
+
+	// Options which are not set keep the defaults
+	opts, err = (&BackendConfig{}).badgerOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := badger.DefaultOptions("")
+	if opts.MemTableSize != defaults.MemTableSize || opts.Compression != defaults.Compression || opts.ValueThreshold != defaults.ValueThreshold {
+		t.Errorf("expected the default options, got %+v", opts)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"memtable-size": -1},
+		{"block-cache-size": "large"},
+		{"compression": "lz4"},
+		{"num-compactors": 1},
+		{"value-threshold": -1},
+	} {
+		if _, err = OpenBackend("badger", &BackendConfig{Dir: t.TempDir(), Options: invalid}); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestBadgerValueDir(t *testing.T) {
+	dir := t.TempDir()
+	config := &BackendConfig{
+		Dir:     filepath.Join(dir, "db"),
+		Options: map[string]interface{}{"value-dir": filepath.Join(dir, "vlog"), "gc-interval": "0"},
+	}
+	if dirs := config.Dirs(); len(dirs) != 2 || dirs[1] != filepath.Join(dir, "vlog") {
+		t.Errorf("expected the value log directory in the backend directories, got %v", dirs)
+	}
+
+	b, err := OpenBackend("badger", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := buildLinearChain(t, &RequestHandler{Backend: b}, 5)
+	b.Close()
+
+	// The value log is kept apart from the LSM tree
+	if logs, _ := filepath.Glob(filepath.Join(dir, "vlog", "*.vlog")); len(logs) == 0 {
+		t.Error("expected the value log files in the value log directory")
+	}
+	if logs, _ := filepath.Glob(filepath.Join(dir, "db", "*.vlog")); len(logs) > 0 {
+		t.Errorf("expected no value log files in the LSM directory, got %v", logs)
+	}
+
+	b, err = OpenBackend("badger", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if value, err := b.Get(blockRecordKey(bt.ByNum[104].GetId())); err != nil || len(value) == 0 {
+		t.Errorf("expected the block to be read after reopening, got %v", err)
+	}
+}
@@ -2,7 +2,13 @@ package bstore
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func backendTest(t *testing.T, b BlockStoreBackend) {
@@ -35,6 +41,12 @@ func backendTest(t *testing.T, b BlockStoreBackend) {
 	if !bytes.Equal(v, []byte("second")) {
 		t.Errorf("error: slice not equivalent")
 	}
+	if has, err := b.Has([]byte("test")); err != nil || !has {
+		t.Errorf("expected the key to be present, got %v, %v", has, err)
+	}
+	if has, err := b.Has([]byte("notfound")); err != nil || has {
+		t.Errorf("expected the key to be missing, got %v, %v", has, err)
+	}
 	if err := b.Put([]byte("test2"), nil); err == nil {
 		t.Error("putting a nil value should give an error")
 	}
@@ -60,6 +72,9 @@ func backendTest(t *testing.T, b BlockStoreBackend) {
 	if len(v) != 0 {
 		t.Errorf("expected empty slice")
 	}
+	if has, err := b.Has([]byte("test")); err != nil || has {
+		t.Errorf("expected the deleted key to be missing, got %v, %v", has, err)
+	}
 	e = b.Delete(nil)
 	if e == nil {
 		t.Error("expected error nil key")
@@ -69,6 +84,166 @@ func backendTest(t *testing.T, b BlockStoreBackend) {
 		t.Error("expected error empty key")
 	}
 
+	// Test batches, the last value of a key written twice is kept
+	e = b.PutBatch([]*KeyValue{
+		{Key: []byte("batch1"), Value: []byte("one")},
+		{Key: []byte("batch2"), Value: []byte("two")},
+		{Key: []byte("batch1"), Value: []byte("three")},
+	})
+	if e != nil {
+		t.Error(e)
+	}
+	v, e = b.Get([]byte("batch1"))
+	if e != nil || !bytes.Equal(v, []byte("three")) {
+		t.Errorf("expected the last value of the batch, got %s, %v", v, e)
+	}
+	v, e = b.Get([]byte("batch2"))
+	if e != nil || !bytes.Equal(v, []byte("two")) {
+		t.Errorf("expected the value of the batch, got %s, %v", v, e)
+	}
+
+	// A batch with an invalid pair stores none of its values
+	e = b.PutBatch([]*KeyValue{{Key: []byte("batch3"), Value: []byte("three")}, {Key: []byte("batch4"), Value: nil}})
+	if e == nil {
+		t.Error("putting a nil value in a batch should give an error")
+	}
+	v, e = b.Get([]byte("batch3"))
+	if e != nil || len(v) != 0 {
+		t.Errorf("expected no value from the failed batch, got %s, %v", v, e)
+	}
+
+	// Test iteration, keys are visited in ascending order
+	for _, key := range []string{"iter/b", "iter/a", "iter/c", "iterate"} {
+		if e = b.Put([]byte(key), []byte(key+"-value")); e != nil {
+			t.Error(e)
+		}
+	}
+	var keys []string
+	e = b.Iterate([]byte("iter/"), func(key []byte, value []byte) error {
+		if !bytes.Equal(value, append(append([]byte{}, key...), "-value"...)) {
+			t.Errorf("unexpected value %s of key %s", value, key)
+		}
+		keys = append(keys, string(key))
+		return nil
+	})
+	if e != nil || fmt.Sprint(keys) != "[iter/a iter/b iter/c]" {
+		t.Errorf("expected the keys with the prefix in order, got %v, %v", keys, e)
+	}
+
+	keys = nil
+	e = b.Iterate([]byte("iter/"), func(key []byte, value []byte) error {
+		keys = append(keys, string(key))
+		return ErrStopIteration
+	})
+	if e != nil || len(keys) != 1 {
+		t.Errorf("expected the iteration to stop after the first key, got %v, %v", keys, e)
+	}
+
+	stop := errors.New("stop")
+	if e = b.Iterate([]byte("iter/"), func(key []byte, value []byte) error { return stop }); e != stop {
+		t.Errorf("expected the error of fn, got %v", e)
+	}
+
+	// Test snapshots, writes after the snapshot is taken are not seen through it
+	if e = b.Put([]byte("snap/a"), []byte("before")); e != nil {
+		t.Error(e)
+	}
+	snapshot, e := b.Snapshot()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = b.PutBatch([]*KeyValue{{Key: []byte("snap/a"), Value: []byte("after")}, {Key: []byte("snap/b"), Value: []byte("after")}}); e != nil {
+		t.Error(e)
+	}
+	if v, e = snapshot.Get([]byte("snap/a")); e != nil || !bytes.Equal(v, []byte("before")) {
+		t.Errorf("expected the value when the snapshot was taken, got %s, %v", v, e)
+	}
+	if v, e = snapshot.Get([]byte("snap/b")); e != nil || v == nil || len(v) != 0 {
+		t.Errorf("expected no value for a key written after the snapshot, got %s, %v", v, e)
+	}
+	keys = nil
+	e = snapshot.Iterate([]byte("snap/"), func(key []byte, value []byte) error {
+		keys = append(keys, string(key)+"="+string(value))
+		return nil
+	})
+	if e != nil || fmt.Sprint(keys) != "[snap/a=before]" {
+		t.Errorf("expected the keys when the snapshot was taken, got %v, %v", keys, e)
+	}
+	snapshot.Release()
+	if v, e = b.Get([]byte("snap/a")); e != nil || !bytes.Equal(v, []byte("after")) {
+		t.Errorf("expected the value written after the snapshot, got %s, %v", v, e)
+	}
+
+	// Flushing keeps the stored values
+	if e = b.Flush(); e != nil {
+		t.Error(e)
+	}
+	if v, e = b.Get([]byte("snap/a")); e != nil || !bytes.Equal(v, []byte("after")) {
+		t.Errorf("expected the value after the flush, got %s, %v", v, e)
+	}
+
+	// Compacting keeps the stored values
+	if _, e = b.Compact(defaultDiscardRatio); e != nil {
+		t.Error(e)
+	}
+	if v, e = b.Get([]byte("snap/a")); e != nil || !bytes.Equal(v, []byte("after")) {
+		t.Errorf("expected the value after the compaction, got %s, %v", v, e)
+	}
+
+	// Writes of a transaction are seen through it, and stored once committed
+	txn, e := b.Begin()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = txn.Put([]byte("txn/a"), []byte("1")); e != nil {
+		t.Error(e)
+	}
+	if v, e = txn.Get([]byte("txn/a")); e != nil || !bytes.Equal(v, []byte("1")) {
+		t.Errorf("expected the value written in the transaction, got %s, %v", v, e)
+	}
+	if has, err := txn.Has([]byte("txn/a")); err != nil || !has {
+		t.Errorf("expected the key written in the transaction to be present, got %v, %v", has, err)
+	}
+	if v, e = b.Get([]byte("txn/a")); e != nil || len(v) != 0 {
+		t.Errorf("expected no value before the commit, got %s, %v", v, e)
+	}
+	if e = txn.Commit(); e != nil {
+		t.Error(e)
+	}
+	if v, e = b.Get([]byte("txn/a")); e != nil || !bytes.Equal(v, []byte("1")) {
+		t.Errorf("expected the committed value, got %s, %v", v, e)
+	}
+
+	txn, e = b.Begin()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e = txn.Put([]byte("txn/a"), []byte("2")); e != nil {
+		t.Error(e)
+	}
+	txn.Rollback()
+	if v, e = b.Get([]byte("txn/a")); e != nil || !bytes.Equal(v, []byte("1")) {
+		t.Errorf("expected the value before the rolled back transaction, got %s, %v", v, e)
+	}
+
+	// Loading a backup replaces everything written since
+	var backup bytes.Buffer
+	if _, e = b.Backup(&backup, 0); e != nil {
+		t.Fatal(e)
+	}
+	if e = b.Put([]byte("backup/after"), []byte("1")); e != nil {
+		t.Error(e)
+	}
+	if e = b.Load(&backup); e != nil {
+		t.Fatal(e)
+	}
+	if v, e = b.Get([]byte("txn/a")); e != nil || !bytes.Equal(v, []byte("1")) {
+		t.Errorf("expected the backed up value, got %s, %v", v, e)
+	}
+	if v, e = b.Get([]byte("backup/after")); e != nil || len(v) != 0 {
+		t.Errorf("expected no value written after the backup, got %s, %v", v, e)
+	}
+
 	// Test reset
 
 	// First put new value into database
@@ -113,3 +288,178 @@ func TestMapBackendBasic(t *testing.T) {
 
 	backendTest(t, b)
 }
+
+func TestMapBackendConcurrent(t *testing.T) {
+	b := NewMapBackend()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			key := []byte(fmt.Sprintf("key-%d", i))
+			value := []byte("value")
+			for j := 0; j < 100; j++ {
+				if err := b.Put(key, value); err != nil {
+					t.Error(err)
+				}
+
+				// Reusing the buffer must not change the stored value
+				value[0] = 'V'
+				if v, err := b.Get(key); err != nil || !bytes.Equal(v, []byte("value")) && !bytes.Equal(v, []byte("Value")) {
+					t.Errorf("unexpected value %s, %v", v, err)
+				}
+				value[0] = 'v'
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if b.Size() != 8*int64(len("key-0")+len("value")) {
+		t.Errorf("unexpected size %d", b.Size())
+	}
+}
+
+func TestBoundedMapBackend(t *testing.T) {
+	b := NewBoundedMapBackend(10)
+
+	if err := b.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put([]byte("k2"), []byte("value")); err == nil {
+		t.Error("expected an error growing beyond the maximum size")
+	} else if _, ok := err.(*MapBackendFull); !ok {
+		t.Errorf("expected MapBackendFull, got %v", err)
+	}
+
+	// Overwriting a value only counts the difference
+	if err := b.Put([]byte("key"), []byte("val")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put([]byte("k2"), []byte("va")); err != nil {
+		t.Fatal(err)
+	}
+	if health, _ := b.Health(); health.Writable {
+		t.Error("expected a full backend not to be writable")
+	}
+
+	if err := b.Delete([]byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != 4 {
+		t.Errorf("expected 4 bytes after the delete, got %d", b.Size())
+	}
+}
+
+func TestPersistentMapBackend(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "block_store.gob")
+
+	b, err := OpenMapBackend(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendTest(t, b)
+	if err = b.Put([]byte("kept"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flush writes the snapshot without waiting for Close
+	if err = b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := OpenMapBackend(path, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if v, _ := restored.Get([]byte("kept")); !bytes.Equal(v, []byte("value")) {
+		t.Errorf("expected the flushed value in the snapshot, got %v", v)
+	}
+	b.Close()
+
+	b, err = OpenMapBackend(path, 10*time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.Get([]byte("kept")); !bytes.Equal(v, []byte("value")) {
+		t.Errorf("expected the value to be loaded from the snapshot, got %v", v)
+	}
+	if b.Size() != int64(len("kept")+len("value")) {
+		t.Errorf("unexpected size %d after loading", b.Size())
+	}
+
+	// Changes are snapshotted periodically, without closing the backend
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Put([]byte("periodic"), bytes.Repeat([]byte{1}, 100)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if current, err := os.Stat(path); err == nil && current.Size() > info.Size() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if current, _ := os.Stat(path); current.Size() <= info.Size() {
+		t.Error("expected the change to be snapshotted")
+	}
+	b.Close()
+	b.Close()
+
+	if err = os.WriteFile(path, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenMapBackend(path, 0, 0); err == nil {
+		t.Error("expected an error loading a corrupted snapshot")
+	}
+}
+
+func TestBackendIterateChain(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+		bt := buildLinearChain(t, &handler, 10)
+
+		// Block records and metadata are visited together in key order
+		var previous []byte
+		blocks := 0
+		err := b.Iterate(nil, func(key []byte, value []byte) error {
+			if previous != nil && bytes.Compare(previous, key) >= 0 {
+				t.Errorf("backend %d: key %x visited after %x", bType, key, previous)
+			}
+			previous = key
+
+			if _, ok := blockRecordHeight(key, value); ok {
+				blocks++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocks != 10 {
+			t.Errorf("backend %d: expected 10 block records, got %d", bType, blocks)
+		}
+
+		heights := 0
+		err = b.Iterate([]byte{heightIndexPrefix}, func(key []byte, value []byte) error {
+			heights++
+			return nil
+		})
+		if err != nil || heights != 10 {
+			t.Errorf("backend %d: expected 10 height index records, got %d, %v", bType, heights, err)
+		}
+
+		value, err := b.Get(blockRecordKey(bt.ByNum[105].GetId()))
+		if err != nil || len(value) == 0 {
+			t.Errorf("backend %d: expected the block record to be unchanged, %v", bType, err)
+		}
+
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,133 @@
+package bstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// streamLoadBatchSize is the number of records stored by each batch of a load
+	streamLoadBatchSize = 1000
+
+	// streamMaxRecordSize bounds the size of a key or value read from a backup stream
+	streamMaxRecordSize = 1 << 30
+)
+
+// streamBackupMagic starts every backup stream. Backends without a backup format of their own back
+// up to a stream of the records of a snapshot, each key and value prefixed with its length, ending
+// with an empty key.
+var streamBackupMagic = []byte("KBSB\x01")
+
+var errStreamIncremental = errors.New("backend only supports full backups")
+
+// streamBackup writes the records of a snapshot of backend to w as a backup stream. Incremental
+// backups are not supported, the returned version is always 0.
+func streamBackup(backend BlockStoreBackend, w io.Writer, sinceVersion uint64) (uint64, error) {
+	if sinceVersion > 0 {
+		return 0, errStreamIncremental
+	}
+
+	snapshot, err := backend.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snapshot.Release()
+
+	writer := bufio.NewWriter(w)
+	if _, err = writer.Write(streamBackupMagic); err != nil {
+		return 0, err
+	}
+
+	err = snapshot.Iterate(nil, func(key []byte, value []byte) error {
+		if err := writeStreamBytes(writer, key); err != nil {
+			return err
+		}
+		return writeStreamBytes(writer, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = writeStreamBytes(writer, nil); err != nil {
+		return 0, err
+	}
+
+	return 0, writer.Flush()
+}
+
+// streamLoad replaces the contents of backend with the records of a backup stream read from r. The
+// header is checked before anything is dropped, a stream cut short fails once its records are stored.
+func streamLoad(backend BlockStoreBackend, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(streamBackupMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, streamBackupMagic) {
+		return errors.New("not a backup stream")
+	}
+
+	if err := backend.Reset(); err != nil {
+		return err
+	}
+
+	batch := make([]*KeyValue, 0, streamLoadBatchSize)
+	for {
+		key, err := readStreamBytes(reader)
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			break
+		}
+
+		value, err := readStreamBytes(reader)
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, &KeyValue{Key: key, Value: value})
+		if len(batch) == streamLoadBatchSize {
+			if err = backend.PutBatch(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return backend.PutBatch(batch)
+}
+
+func writeStreamBytes(w *bufio.Writer, data []byte) error {
+	var length [binary.MaxVarintLen64]byte
+	if _, err := w.Write(length[:binary.PutUvarint(length[:], uint64(len(data)))]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+func readStreamBytes(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	if length > streamMaxRecordSize {
+		return nil, fmt.Errorf("backup stream record of %d bytes exceeds the maximum size", length)
+	}
+
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,43 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBackupStream(t *testing.T) {
+	backend := NewMapBackend()
+	handler := RequestHandler{Backend: backend}
+	buildLinearChain(t, &handler, 10)
+
+	var backup bytes.Buffer
+	if _, err := backend.Backup(&backup, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Backup(&bytes.Buffer{}, 1); err != errStreamIncremental {
+		t.Errorf("expected incremental backups to be unsupported, got %v", err)
+	}
+
+	// Anything but a backup stream is rejected before the database is dropped
+	if err := backend.Load(bytes.NewReader([]byte("not a backup"))); err == nil {
+		t.Error("expected an invalid backup to be rejected")
+	}
+	if _, err := handler.ValidateHighestBlock(); err != nil {
+		t.Errorf("expected the database to be kept, got %v", err)
+	}
+
+	// A stream cut short fails
+	if err := backend.Load(bytes.NewReader(backup.Bytes()[:backup.Len()-1])); err == nil {
+		t.Error("expected a truncated backup to fail")
+	}
+
+	// The backup of one kind of backend loads into another of the same kind
+	restored := NewMapBackend()
+	if err := restored.Load(&backup); err != nil {
+		t.Fatal(err)
+	}
+	handler.Backend = restored
+	if highest, err := handler.ValidateHighestBlock(); err != nil || highest.GetHeight() != 10 {
+		t.Errorf("expected the restored chain, got %v, %v", highest, err)
+	}
+}
@@ -0,0 +1,501 @@
+package bstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	badger3 "github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v4"
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+const (
+	// badgerManifestFile exists in every Badger database directory
+	badgerManifestFile = "MANIFEST"
+
+	// migrationProgressInterval is the number of records between migration progress logs
+	migrationProgressInterval = 100000
+)
+
+// Badger4Backend stores records in a Badger v4 database. Badger v4 cannot open the directories of
+// Badger v3, existing databases are converted with MigrateBadgerV3.
+type Badger4Backend struct {
+	DB *badger.DB
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+}
+
+// NewBadger4Backend opens a Badger v4 database
+func NewBadger4Backend(opts badger.Options) (*Badger4Backend, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Badger4Backend{DB: db}, nil
+}
+
+// Close cleans backend resources
+func (backend *Badger4Backend) Close() {
+	backend.DB.Close()
+}
+
+// Reset resets the database
+func (backend *Badger4Backend) Reset() error {
+	return backend.DB.DropAll()
+}
+
+// Put backend setter
+func (backend *Badger4Backend) Put(key, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	}))
+}
+
+// PutWithTTL stores the value in the given key, Badger removes it once ttl has passed
+func (backend *Badger4Backend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, value).WithTTL(ttl))
+	}))
+}
+
+// PutBatch stores the values in a single transaction
+func (backend *Badger4Backend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
+		for _, pair := range pairs {
+			if err := txn.Set(pair.Key, pair.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// Delete an item from the database
+func (backend *Badger4Backend) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("cannot remove a nil key")
+	}
+
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	}))
+}
+
+// Iterate calls fn with the keys starting with prefix and their values, read from a single transaction
+func (backend *Badger4Backend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(backend.DB.View(func(txn *badger.Txn) error {
+		return badger4Iterate(txn, prefix, fn)
+	}))
+}
+
+// Snapshot takes a snapshot of the database with a read-only transaction, it keeps the versions of
+// the records it reads from being garbage collected until released
+func (backend *Badger4Backend) Snapshot() (BackendSnapshot, error) {
+	return &badger4Snapshot{txn: backend.DB.NewTransaction(false)}, nil
+}
+
+// badger4Snapshot reads from a read-only transaction
+type badger4Snapshot struct {
+	txn *badger.Txn
+}
+
+func (snapshot *badger4Snapshot) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	return badger4Get(snapshot.txn, key)
+}
+
+func (snapshot *badger4Snapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(badger4Iterate(snapshot.txn, prefix, fn))
+}
+
+func (snapshot *badger4Snapshot) Release() {
+	snapshot.txn.Discard()
+}
+
+// badger4Get reads the value of key within txn, an empty value if it is not found
+func badger4Get(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return make([]byte, 0), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+// badger4Iterate calls fn with the keys starting with prefix and their values within txn
+func badger4Iterate(txn *badger.Txn, prefix []byte, fn func(key []byte, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush syncs the value log and the memtable to disk. Badger only syncs writes itself if SyncWrites
+// is set.
+func (backend *Badger4Backend) Flush() error {
+	return backend.DB.Sync()
+}
+
+// Begin begins a Badger read-write transaction, which detects conflicts on commit
+func (backend *Badger4Backend) Begin() (BackendTxn, error) {
+	return &badger4Txn{backend: backend, txn: backend.DB.NewTransaction(true)}, nil
+}
+
+// badger4Txn is a Badger read-write transaction
+type badger4Txn struct {
+	backend *Badger4Backend
+	txn     *badger.Txn
+}
+
+func (txn *badger4Txn) Get(key []byte) ([]byte, error) {
+	return badger4Get(txn.txn, key)
+}
+
+func (txn *badger4Txn) Has(key []byte) (bool, error) {
+	return badger4Has(txn.txn, key)
+}
+
+func (txn *badger4Txn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(badger4Iterate(txn.txn, prefix, fn))
+}
+
+// Put copies the key and value, Badger keeps them until the transaction is committed
+func (txn *badger4Txn) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return txn.txn.Set(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (txn *badger4Txn) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("cannot remove a nil key")
+	}
+
+	return txn.txn.Delete(append([]byte{}, key...))
+}
+
+func (txn *badger4Txn) Commit() error {
+	err := txn.txn.Commit()
+	if err == badger.ErrConflict {
+		return ErrTxnConflict
+	}
+
+	return txn.backend.recordWrite(err)
+}
+
+func (txn *badger4Txn) Rollback() {
+	txn.txn.Discard()
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *Badger4Backend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// Get backend getter
+func (backend *Badger4Backend) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	var value []byte
+	err := backend.DB.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = badger4Get(txn, key)
+		return err
+	})
+
+	return value, err
+}
+
+// Has reports whether key is stored, without reading its value from the value log
+func (backend *Badger4Backend) Has(key []byte) (bool, error) {
+	if key == nil {
+		return false, errors.New("cannot check a nil key")
+	}
+
+	var found bool
+	err := backend.DB.View(func(txn *badger.Txn) error {
+		var err error
+		found, err = badger4Has(txn, key)
+		return err
+	})
+
+	return found, err
+}
+
+// badger4Has reports whether key is stored within txn
+func badger4Has(txn *badger.Txn, key []byte) (bool, error) {
+	_, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Backup writes a consistent backup of all entries newer than sinceVersion to w, returning the version
+// to use as sinceVersion for a subsequent incremental backup
+func (backend *Badger4Backend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.DB.Backup(w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup read from r
+func (backend *Badger4Backend) Load(r io.Reader) error {
+	if err := backend.DB.DropAll(); err != nil {
+		return err
+	}
+
+	return backend.recordWrite(backend.DB.Load(r, restoreMaxPendingWrites))
+}
+
+// Compact runs value log garbage collection until no more files can be rewritten and then flattens the LSM tree
+func (backend *Badger4Backend) Compact(discardRatio float64) (*CompactionResult, error) {
+	result := &CompactionResult{}
+
+	var err error
+	result.LSMSizeBefore, result.ValueLogSizeBefore, err = backend.diskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = backend.DB.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		result.ValueLogFilesRewritten++
+	}
+
+	if err = backend.DB.Flatten(1); err != nil {
+		return nil, err
+	}
+
+	result.LSMSizeAfter, result.ValueLogSizeAfter, err = backend.diskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return result, nil
+}
+
+func (backend *Badger4Backend) diskSize() (lsm int64, vlog int64, err error) {
+	opts := backend.DB.Opts()
+	if opts.InMemory {
+		lsm, vlog = backend.DB.Size()
+		return lsm, vlog, nil
+	}
+
+	return badgerDiskSize(opts.Dir, opts.ValueDir)
+}
+
+// Stats reports the approximate number of keys in the LSM tables, records still in the memtables are
+// not counted, and the size of the database and of its levels
+func (backend *Badger4Backend) Stats() (*BackendStats, error) {
+	if backend.DB.IsClosed() {
+		return nil, errors.New("database is closed")
+	}
+
+	lsm, vlog, err := backend.diskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys uint64
+	for _, table := range backend.DB.Tables() {
+		keys += uint64(table.KeyCount)
+	}
+
+	stats := &BackendStats{Keys: &keys, DiskSize: lsm + vlog, LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}
+	for _, level := range backend.DB.Levels() {
+		stats.Levels = append(stats.Levels, &LevelStats{Level: level.Level, Tables: level.NumTables, Size: level.Size, Score: level.Score})
+	}
+
+	return stats, nil
+}
+
+// Health reports whether the database accepts writes, when it was last written, how many LSM levels
+// are due for compaction and the free space on the database volume
+func (backend *Badger4Backend) Health() (*BackendHealth, error) {
+	opts := backend.DB.Opts()
+	health := &BackendHealth{
+		Writable: !backend.DB.IsClosed() && !opts.ReadOnly && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	if !backend.DB.IsClosed() {
+		for _, level := range backend.DB.Levels() {
+			if level.Score >= 1 {
+				health.PendingCompactions++
+			}
+		}
+	}
+
+	if !opts.InMemory {
+		free, err := diskFree(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		health.DiskFree = &free
+	}
+
+	return health, nil
+}
+
+// isBadgerDir reports whether dir holds a Badger database
+func isBadgerDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, badgerManifestFile))
+	return err == nil
+}
+
+// MigrateBadgerV3 copies every record of the Badger v3 database in srcDir into a new Badger v4
+// database in dstDir, returning the number of records copied. The database is built in a temporary
+// directory next to dstDir and only moved in place once complete, so an interrupted migration is
+// restarted from scratch. dstDir must not exist or be empty, srcDir is left untouched.
+func MigrateBadgerV3(srcDir string, dstDir string, opts badger.Options) (uint64, error) {
+	if entries, err := os.ReadDir(dstDir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("migration target %s is not empty", dstDir)
+	}
+
+	srcOpts := badger3.DefaultOptions(srcDir)
+	srcOpts.Logger = KoinosBadgerLogger{}
+	srcOpts.ReadOnly = true
+	src, err := badger3.Open(srcOpts)
+	if err != nil {
+		return 0, fmt.Errorf("could not open Badger v3 database %s, %w", srcDir, err)
+	}
+	defer src.Close()
+
+	tmpDir := dstDir + ".migrating"
+	if err = os.RemoveAll(tmpDir); err != nil {
+		return 0, err
+	}
+
+	opts.Dir = tmpDir
+	opts.ValueDir = tmpDir
+	opts.ReadOnly = false
+	dst, err := badger.Open(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	records, err := copyBadgerV3Records(src, dst)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// The target may exist as the empty directory the block store creates for each backend
+	if err = os.Remove(dstDir); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err = os.Rename(tmpDir, dstDir); err != nil {
+		return 0, err
+	}
+
+	return records, nil
+}
+
+func copyBadgerV3Records(src *badger3.DB, dst *badger.DB) (uint64, error) {
+	batch := dst.NewWriteBatch()
+	defer batch.Cancel()
+
+	var records uint64
+	err := src.View(func(txn *badger3.Txn) error {
+		it := txn.NewIterator(badger3.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err = batch.Set(item.KeyCopy(nil), value); err != nil {
+				return err
+			}
+
+			records++
+			if records%migrationProgressInterval == 0 {
+				log.Infof("Migrated %d record(s)", records)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return records, batch.Flush()
+}
@@ -0,0 +1,113 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	badger3 "github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	Badger4BackendType = 9
+)
+
+func init() {
+	backendTypes = append(backendTypes, Badger4BackendType)
+	taggedBackends[Badger4BackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewBadger4Backend(badger.DefaultOptions(dirname).WithLogger(nil))
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestBadger4BackendBasic(t *testing.T) {
+	b := NewBackend(Badger4BackendType)
+
+	backendTest(t, b)
+
+	CloseBackend(b)
+}
+
+func TestMigrateBadgerV3(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	srcDir := filepath.Join(dir, "db")
+	src, err := NewBadgerBackend(badger3.DefaultOptions(srcDir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := [][]byte{[]byte("alpha"), []byte("beta"), []byte("gamma")}
+	for i, key := range keys {
+		if err = src.Put(key, bytes.Repeat([]byte{byte(i)}, 100*(i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+
+	// The block store creates the directory of the backend before opening it
+	dstDir := filepath.Join(dir, "badger4")
+	if err = os.MkdirAll(dstDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &BackendConfig{Dir: dstDir}
+	dst, err := OpenBackend("badger4", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range keys {
+		value, err := dst.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, bytes.Repeat([]byte{byte(i)}, 100*(i+1))) {
+			t.Errorf("Record %s was not migrated", key)
+		}
+	}
+
+	// Records written after the migration survive a restart, which must not migrate again
+	if err = dst.Put([]byte("delta"), []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	dst.Close()
+
+	dst, err = OpenBackend("badger4", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	value, err := dst.Get([]byte("delta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, []byte{1, 2, 3}) {
+		t.Error("Migration was repeated on restart")
+	}
+
+	if _, err = os.Stat(dstDir + ".migrating"); !os.IsNotExist(err) {
+		t.Error("Temporary migration directory was not removed")
+	}
+	if !isBadgerDir(srcDir) {
+		t.Error("Badger v3 database was removed")
+	}
+
+	if _, err = MigrateBadgerV3(srcDir, dstDir, badger.DefaultOptions("").WithLogger(nil)); err == nil {
+		t.Error("Expected migration into a non-empty directory to fail")
+	}
+}
@@ -1,29 +1,157 @@
 package bstore
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/dgraph-io/ristretto/z"
+	log "github.com/koinos/koinos-log-golang/v2"
 	"go.uber.org/zap"
 )
 
+const (
+	restoreMaxPendingWrites = 256
+
+	// bulkLoadBufferSize is the size of the buffers of records written by a bulk load
+	bulkLoadBufferSize = 16 << 20
+
+	// DefaultValueLogGCInterval is the default interval of the background value log garbage collection
+	DefaultValueLogGCInterval = 10 * time.Minute
+)
+
 // BadgerBackend Badger backend implementation
 type BadgerBackend struct {
 	DB *badger.DB
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+
+	// gcStop stops the background value log garbage collection, nil if it was not started
+	gcStop chan struct{}
+	gcWG   sync.WaitGroup
 }
 
-// NewBadgerBackend BadgerBackend constructor
+// NewBadgerBackend BadgerBackend constructor. With opts.ReadOnly the database is opened without
+// writing to it, for inspection or serving queries from read-only media. If the directory lock cannot
+// be taken, because another process owns the database or the media does not support locks, the
+// database is opened without it.
 func NewBadgerBackend(opts badger.Options) (*BadgerBackend, error) {
 	badgerDB, err := badger.Open(opts)
+	if err != nil && opts.ReadOnly && !opts.BypassLockGuard && isBadgerLockError(err) {
+		log.Warnf("Could not lock database %s, opening it read-only without the lock, %s", opts.Dir, err)
+		badgerDB, err = badger.Open(opts.WithBypassLockGuard(true))
+	}
+
+	// Badger cannot replay the writes of its memtables without writing to them. Its errors are wrapped
+	// without unwrapping support, so they are matched by message.
+	if err != nil && opts.ReadOnly && strings.Contains(err.Error(), badger.ErrTruncateNeeded.Error()) {
+		err = fmt.Errorf("database %s has unflushed writes, it is open in another process or was not closed cleanly, %w", opts.Dir, err)
+	}
+
 	return &BadgerBackend{DB: badgerDB}, err
 }
 
-// Close cleans backend resources
+func isBadgerLockError(err error) bool {
+	return strings.Contains(err.Error(), "Cannot acquire directory lock")
+}
+
+// Close cleans backend resources, once the background value log garbage collection has stopped
 func (backend *BadgerBackend) Close() {
+	if backend.gcStop != nil {
+		close(backend.gcStop)
+		backend.gcWG.Wait()
+		backend.gcStop = nil
+	}
+
 	backend.DB.Close()
 }
 
+// StartValueLogGC garbage collects the value log every interval in the background until Close,
+// rewriting the value log files with at least discardRatio of stale data. Badger never reclaims the
+// space of overwritten and deleted values on its own.
+func (backend *BadgerBackend) StartValueLogGC(interval time.Duration, discardRatio float64) {
+	backend.gcStop = make(chan struct{})
+	backend.gcWG.Add(1)
+	go func() {
+		defer backend.gcWG.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				backend.scheduledValueLogGC(discardRatio)
+			case <-backend.gcStop:
+				return
+			}
+		}
+	}()
+}
+
+// scheduledValueLogGC runs a value log garbage collection of the background schedule, logging the
+// reclaimed space
+func (backend *BadgerBackend) scheduledValueLogGC(discardRatio float64) {
+	_, before, err := backend.diskSize()
+	if err != nil {
+		log.Warnf("Value log garbage collection failed, %s", err.Error())
+		return
+	}
+
+	rewritten, err := backend.valueLogGC(discardRatio)
+	if err == badger.ErrRejected {
+		// A compaction is collecting the value log already
+		log.Debug("Value log garbage collection skipped, another one is running")
+		return
+	} else if err != nil {
+		log.Warnf("Value log garbage collection failed, %s", err.Error())
+		return
+	}
+
+	if rewritten == 0 {
+		log.Debug("Value log garbage collection found no file to rewrite")
+		return
+	}
+
+	_, after, err := backend.diskSize()
+	if err != nil {
+		log.Warnf("Value log garbage collection failed, %s", err.Error())
+		return
+	}
+
+	log.Infof("Value log garbage collection rewrote %v file(s), reclaimed %v byte(s)", rewritten, before-after)
+}
+
+// valueLogGC rewrites value log files until none has discardRatio of stale data, returning the number
+// of files rewritten
+func (backend *BadgerBackend) valueLogGC(discardRatio float64) (int, error) {
+	rewritten := 0
+	for {
+		err := backend.DB.RunValueLogGC(discardRatio)
+		if err == badger.ErrNoRewrite {
+			return rewritten, nil
+		} else if err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+}
+
 // Reset resets the database
 func (backend *BadgerBackend) Reset() error {
 	return backend.DB.DropAll()
@@ -38,9 +166,40 @@ func (backend *BadgerBackend) Put(key, value []byte) error {
 		return errors.New("cannot put a nil value")
 	}
 
-	return backend.DB.Update(func(txn *badger.Txn) error {
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
 		return txn.Set(key, value)
-	})
+	}))
+}
+
+// PutWithTTL stores the value in the given key, Badger removes it once ttl has passed
+func (backend *BadgerBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(key, value).WithTTL(ttl))
+	}))
+}
+
+// PutBatch stores the values in a single transaction. A badger.WriteBatch is not used as it may
+// commit a large batch in several transactions.
+func (backend *BadgerBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
+		for _, pair := range pairs {
+			if err := txn.Set(pair.Key, pair.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
 }
 
 // Delete an item from the database
@@ -49,35 +208,423 @@ func (backend *BadgerBackend) Delete(key []byte) error {
 		return errors.New("cannot remove a nil key")
 	}
 
-	return backend.DB.Update(func(txn *badger.Txn) error {
+	return backend.recordWrite(backend.DB.Update(func(txn *badger.Txn) error {
 		return txn.Delete(key)
-	})
+	}))
+}
+
+// Iterate calls fn with the keys starting with prefix and their values, read from a single transaction
+func (backend *BadgerBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(backend.DB.View(func(txn *badger.Txn) error {
+		return badgerIterate(txn, prefix, fn)
+	}))
+}
+
+// Snapshot takes a snapshot of the database with a read-only transaction, it keeps the versions of
+// the records it reads from being garbage collected until released
+func (backend *BadgerBackend) Snapshot() (BackendSnapshot, error) {
+	return &badgerSnapshot{txn: backend.DB.NewTransaction(false)}, nil
+}
+
+// badgerSnapshot reads from a read-only transaction
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (snapshot *badgerSnapshot) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	return badgerGet(snapshot.txn, key)
+}
+
+func (snapshot *badgerSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(badgerIterate(snapshot.txn, prefix, fn))
+}
+
+func (snapshot *badgerSnapshot) Release() {
+	snapshot.txn.Discard()
+}
+
+// badgerGet reads the value of key within txn, an empty value if it is not found
+func badgerGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return make([]byte, 0), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+// badgerIterate calls fn with the keys starting with prefix and their values within txn
+func badgerIterate(txn *badger.Txn, prefix []byte, fn func(key []byte, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush syncs the value log and the memtable to disk. Badger only syncs writes itself if SyncWrites
+// is set.
+func (backend *BadgerBackend) Flush() error {
+	return backend.DB.Sync()
+}
+
+// Begin begins a Badger read-write transaction, which detects conflicts on commit
+func (backend *BadgerBackend) Begin() (BackendTxn, error) {
+	return &badgerTxn{backend: backend, txn: backend.DB.NewTransaction(true)}, nil
+}
+
+// badgerTxn is a Badger read-write transaction
+type badgerTxn struct {
+	backend *BadgerBackend
+	txn     *badger.Txn
+}
+
+func (txn *badgerTxn) Get(key []byte) ([]byte, error) {
+	return badgerGet(txn.txn, key)
+}
+
+func (txn *badgerTxn) Has(key []byte) (bool, error) {
+	return badgerHas(txn.txn, key)
+}
+
+func (txn *badgerTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(badgerIterate(txn.txn, prefix, fn))
+}
+
+// Put copies the key and value, Badger keeps them until the transaction is committed
+func (txn *badgerTxn) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return txn.txn.Set(append([]byte{}, key...), append([]byte{}, value...))
+}
+
+func (txn *badgerTxn) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("cannot remove a nil key")
+	}
+
+	return txn.txn.Delete(append([]byte{}, key...))
+}
+
+func (txn *badgerTxn) Commit() error {
+	err := txn.txn.Commit()
+	if err == badger.ErrConflict {
+		return ErrTxnConflict
+	}
+
+	return txn.backend.recordWrite(err)
+}
+
+func (txn *badgerTxn) Rollback() {
+	txn.txn.Discard()
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *BadgerBackend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
 }
 
 // Get backend getter
 func (backend *BadgerBackend) Get(key []byte) ([]byte, error) {
-	var value []byte = nil
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	var value []byte
+	err := backend.DB.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = badgerGet(txn, key)
+		return err
+	})
+
+	return value, err
+}
 
+// Has reports whether key is stored, without reading its value from the value log
+func (backend *BadgerBackend) Has(key []byte) (bool, error) {
 	if key == nil {
-		return value, errors.New("cannot get a nil key")
+		return false, errors.New("cannot check a nil key")
 	}
 
+	var found bool
 	err := backend.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(key)
-		if err == badger.ErrKeyNotFound {
-			value = make([]byte, 0)
+		var err error
+		found, err = badgerHas(txn, key)
+		return err
+	})
+
+	return found, err
+}
+
+// badgerHas reports whether key is stored within txn
+func badgerHas(txn *badger.Txn, key []byte) (bool, error) {
+	_, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Backup writes a consistent backup of all entries newer than sinceVersion to w, returning the version
+// to use as sinceVersion for a subsequent incremental backup
+func (backend *BadgerBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.DB.Backup(w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup read from r
+func (backend *BadgerBackend) Load(r io.Reader) error {
+	if err := backend.DB.DropAll(); err != nil {
+		return err
+	}
+
+	return backend.recordWrite(backend.DB.Load(r, restoreMaxPendingWrites))
+}
+
+// BulkLoad replaces the contents of the database with the records of source, writing the tables
+// directly with a stream writer instead of through transactions. The database must not be written to
+// until the load completes. A load which fails leaves part of the records, it must be run again.
+func (backend *BadgerBackend) BulkLoad(source RecordSource) error {
+	writer := backend.DB.NewStreamWriter()
+	if err := writer.Prepare(); err != nil {
+		return err
+	}
+
+	buf := z.NewBuffer(bulkLoadBufferSize, "BadgerBackend.BulkLoad")
+	defer func() { _ = buf.Release() }()
+
+	// The stream writer builds the tables in the order it receives the keys, it cannot sort them
+	var last []byte
+	err := source(func(key []byte, value []byte) error {
+		if last != nil && bytes.Compare(key, last) <= 0 {
+			return fmt.Errorf("bulk load key 0x%x is not after 0x%x", key, last)
+		}
+		last = append(last[:0], key...)
+
+		badger.KVToBuffer(&pb.KV{Key: key, Value: value, Version: 1}, buf)
+		if buf.LenNoPadding() < bulkLoadBufferSize {
 			return nil
-		} else if err != nil {
-			return err
 		}
-		err = item.Value(func(val []byte) error {
-			value = append([]byte{}, val...)
+
+		err := writer.Write(buf)
+		buf.Reset()
+		return err
+	})
+	if err == nil {
+		err = writer.Write(buf)
+	}
+	if err != nil {
+		writer.Cancel()
+		return backend.recordWrite(err)
+	}
+
+	return backend.recordWrite(writer.Flush())
+}
+
+// CompactionResult reports the on-disk size of the database before and after a compaction
+type CompactionResult struct {
+	LSMSizeBefore      int64
+	ValueLogSizeBefore int64
+	LSMSizeAfter       int64
+	ValueLogSizeAfter  int64
+
+	ValueLogFilesRewritten int
+}
+
+// Compact runs value log garbage collection until no more files can be rewritten and then flattens the LSM tree
+func (backend *BadgerBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	result := &CompactionResult{}
+
+	var err error
+	result.LSMSizeBefore, result.ValueLogSizeBefore, err = backend.diskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	result.ValueLogFilesRewritten, err = backend.valueLogGC(discardRatio)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = backend.DB.Flatten(1); err != nil {
+		return nil, err
+	}
+
+	result.LSMSizeAfter, result.ValueLogSizeAfter, err = backend.diskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return result, nil
+}
+
+// diskSize sums the size of the LSM and value log files on disk. Unlike DB.Size(), which is only
+// refreshed periodically, this reflects the effect of a compaction immediately.
+func (backend *BadgerBackend) diskSize() (lsm int64, vlog int64, err error) {
+	opts := backend.DB.Opts()
+	if opts.InMemory {
+		lsm, vlog = backend.DB.Size()
+		return lsm, vlog, nil
+	}
+
+	return badgerDiskSize(opts.Dir, opts.ValueDir)
+}
+
+// badgerDiskSize sums the size of the LSM and value log files of a Badger database on disk
+func badgerDiskSize(dir string, valueDir string) (lsm int64, vlog int64, err error) {
+	sizes := make(map[string]int64)
+	dirs := []string{dir}
+	if valueDir != dir {
+		dirs = append(dirs, valueDir)
+	}
+
+	for _, dir := range dirs {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				sizes[filepath.Ext(path)] += info.Size()
+			}
 			return nil
 		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return sizes[".sst"], sizes[".vlog"], nil
+}
+
+// Stats reports the approximate number of keys in the LSM tables, records still in the memtables are
+// not counted, and the size of the database and of its levels
+func (backend *BadgerBackend) Stats() (*BackendStats, error) {
+	if backend.DB.IsClosed() {
+		return nil, errors.New("database is closed")
+	}
+
+	lsm, vlog, err := backend.diskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys uint64
+	for _, table := range backend.DB.Tables() {
+		keys += uint64(table.KeyCount)
+	}
+
+	stats := &BackendStats{Keys: &keys, DiskSize: lsm + vlog, LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}
+	for _, level := range backend.DB.Levels() {
+		stats.Levels = append(stats.Levels, &LevelStats{Level: level.Level, Tables: level.NumTables, Size: level.Size, Score: level.Score})
+	}
+
+	return stats, nil
+}
+
+// Health reports whether the database accepts writes, when it was last written, how many LSM levels
+// are due for compaction and the free space on the database volume
+func (backend *BadgerBackend) Health() (*BackendHealth, error) {
+	opts := backend.DB.Opts()
+	health := &BackendHealth{
+		Writable: !backend.DB.IsClosed() && !opts.ReadOnly && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	if !backend.DB.IsClosed() {
+		for _, level := range backend.DB.Levels() {
+			if level.Score >= 1 {
+				health.PendingCompactions++
+			}
+		}
+	}
+
+	if !opts.InMemory {
+		free, err := diskFree(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		health.DiskFree = &free
+	}
+
+	return health, nil
+}
+
+// RotateBadgerEncryptionKey replaces the key of the encryption-key-file option of the Badger database
+// of config with newKey. Badger encrypts its tables and value log with data keys, which it stores
+// encrypted with that key, so only the data keys are re-encrypted. The database must not be open. A
+// database which was not encrypted stores new data encrypted, the existing tables and value log files
+// are encrypted as they are rewritten by compactions and garbage collection.
+func RotateBadgerEncryptionKey(config *BackendConfig, newKey []byte) error {
+	if len(newKey) == 0 {
+		return errors.New("the new encryption key is empty")
+	}
+	if !isBadgerDir(config.Dir) {
+		return fmt.Errorf("%s does not contain a Badger database", config.Dir)
+	}
+
+	// Opening the database checks the current key and that no other process has it open
+	opts, err := config.badgerOptions(config.Dir)
+	if err != nil {
 		return err
-	})
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return err
+	}
+	if err = db.Close(); err != nil {
+		return err
+	}
 
-	return value, err
+	registryOpts := badger.KeyRegistryOptions{
+		Dir:                           config.Dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 opts.EncryptionKey,
+		EncryptionKeyRotationDuration: opts.EncryptionKeyRotationDuration,
+	}
+	registry, err := badger.OpenKeyRegistry(registryOpts)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = registry.Close() }()
+
+	registryOpts.EncryptionKey = newKey
+	return badger.WriteKeyRegistry(registry, registryOpts)
 }
 
 // KoinosBadgerLogger implements the badger.Logger interface in roder to pass badger logs the the koinos logger
@@ -0,0 +1,107 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+func TestRotateBadgerEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	oldKeyFile := filepath.Join(dir, "old.key")
+	newKeyFile := filepath.Join(dir, "new.key")
+	if err := os.WriteFile(oldKeyFile, []byte("000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newKeyFile, []byte("0x0f0e0d0c0b0a09080706050403020100"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dbDir := filepath.Join(dir, "db")
+	config := &BackendConfig{Dir: dbDir, Options: map[string]interface{}{"encryption-key-file": oldKeyFile, "gc-interval": "0"}}
+	b, err := OpenBackend("badger", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := buildLinearChain(t, &RequestHandler{Backend: b}, 5)
+	expected, err := b.Get(blockRecordKey(bt.ByNum[103].GetId()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := b.(*BadgerBackend).DB.Opts(); opts.IndexCacheSize != DefaultEncryptedIndexCacheSize {
+		t.Errorf("expected the default index cache size of an encrypted database, got %v", opts.IndexCacheSize)
+	}
+	b.Close()
+
+	newKey, _ := ParseEncryptionKey("0f0e0d0c0b0a09080706050403020100")
+	if err = RotateBadgerEncryptionKey(&BackendConfig{Dir: dbDir}, newKey); err == nil {
+		t.Error("expected an error rotating without the current key")
+	}
+	if err = RotateBadgerEncryptionKey(config, newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database no longer opens with the old key
+	if b, err = OpenBackend("badger", config); err == nil {
+		b.Close()
+		t.Fatal("expected an error opening the database with the old key")
+	}
+
+	config.Options["encryption-key-file"] = newKeyFile
+	b, err = OpenBackend("badger", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if value, err := b.Get(blockRecordKey(bt.ByNum[103].GetId())); err != nil || !bytes.Equal(value, expected) {
+		t.Errorf("expected the block record stored before the rotation, got %v", err)
+	}
+
+	if err = RotateBadgerEncryptionKey(&BackendConfig{Dir: dir}, newKey); err == nil {
+		t.Error("expected an error for a directory without a database")
+	}
+}
+
+func TestBadgerBackendReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewBadgerBackend(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := buildLinearChain(t, &RequestHandler{Backend: writer}, 5)
+
+	// The writer holds the lock and has writes in its memtable, which cannot be read without it
+	_, err = NewBadgerBackend(badger.DefaultOptions(dir).WithLogger(nil).WithReadOnly(true))
+	if err == nil || !strings.Contains(err.Error(), "unflushed writes") {
+		t.Errorf("expected an error for a database open for writing, got %v", err)
+	}
+	writer.Close()
+
+	reader, err := NewBadgerBackend(badger.DefaultOptions(dir).WithLogger(nil).WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if value, err := reader.Get(blockRecordKey(bt.ByNum[103].GetId())); err != nil || len(value) == 0 {
+		t.Errorf("expected the block to be read, got %v", err)
+	}
+	if err = reader.Put([]byte{0x12, 0x01}, []byte("value")); err == nil {
+		t.Error("expected an error writing to a read-only database")
+	}
+	if health, err := reader.Health(); err != nil || health.Writable {
+		t.Errorf("expected a read-only database not to be writable, got %+v, %v", health, err)
+	}
+
+	// A second reader shares the lock
+	second, err := NewBadgerBackend(badger.DefaultOptions(dir).WithLogger(nil).WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+}
@@ -0,0 +1,177 @@
+package bstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+)
+
+// BatchBackend collects the writes of a single logical operation, such as adding a block and updating
+// its indexes, and stores them in the wrapped backend atomically on Commit. Reads through it see the
+// collected writes.
+//
+// Like MemoBackend it is meant to live for a single request. Only puts can be batched.
+type BatchBackend struct {
+	Backend BlockStoreBackend
+
+	pairs   []*KeyValue
+	indices map[string]int
+}
+
+// NewBatchBackend creates a BatchBackend writing to backend
+func NewBatchBackend(backend BlockStoreBackend) *BatchBackend {
+	return &BatchBackend{Backend: backend, indices: make(map[string]int)}
+}
+
+// Reset returns an error, a reset cannot be batched
+func (backend *BatchBackend) Reset() error {
+	return errors.New("cannot reset the database within a batch")
+}
+
+// Put adds the value to the batch
+func (backend *BatchBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	if i, ok := backend.indices[string(key)]; ok {
+		backend.pairs[i].Value = value
+		return nil
+	}
+
+	backend.indices[string(key)] = len(backend.pairs)
+	backend.pairs = append(backend.pairs, &KeyValue{Key: key, Value: value})
+	return nil
+}
+
+// PutBatch adds the values to the batch
+func (backend *BatchBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		if err := backend.Put(pair.Key, pair.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete returns an error, deletes cannot be batched
+func (backend *BatchBackend) Delete(key []byte) error {
+	return errors.New("cannot remove a key within a batch")
+}
+
+// Get fetches the value from the batch, or from the wrapped database if the batch did not write it
+func (backend *BatchBackend) Get(key []byte) ([]byte, error) {
+	if i, ok := backend.indices[string(key)]; ok {
+		return backend.pairs[i].Value, nil
+	}
+
+	return backend.Backend.Get(key)
+}
+
+// Has reports whether the batch or the wrapped database stores key
+func (backend *BatchBackend) Has(key []byte) (bool, error) {
+	if _, ok := backend.indices[string(key)]; ok {
+		return true, nil
+	}
+
+	return backend.Backend.Has(key)
+}
+
+// Iterate calls fn with the keys starting with prefix and their values, the values of the batch
+// replacing those of the wrapped database
+func (backend *BatchBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	pending := make([]*KeyValue, 0)
+	for _, pair := range backend.pairs {
+		if bytes.HasPrefix(pair.Key, prefix) {
+			pending = append(pending, pair)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return bytes.Compare(pending[i].Key, pending[j].Key) < 0 })
+
+	// The wrapped backend returns nil when fn stops the iteration, the pending values must not follow
+	stopped := false
+	err := backend.Backend.Iterate(prefix, func(key []byte, value []byte) error {
+		for len(pending) > 0 && bytes.Compare(pending[0].Key, key) <= 0 {
+			replaced := bytes.Equal(pending[0].Key, key)
+			if err := fn(pending[0].Key, pending[0].Value); err != nil {
+				stopped = true
+				return err
+			}
+			pending = pending[1:]
+			if replaced {
+				return nil
+			}
+		}
+
+		if err := fn(key, value); err != nil {
+			stopped = true
+			return err
+		}
+		return nil
+	})
+	if err != nil || stopped {
+		return err
+	}
+
+	for _, pair := range pending {
+		if err = fn(pair.Key, pair.Value); err != nil {
+			return stopIteration(err)
+		}
+	}
+
+	return nil
+}
+
+// Snapshot returns an error, the values of a batch cannot be pinned before they are committed
+func (backend *BatchBackend) Snapshot() (BackendSnapshot, error) {
+	return nil, errors.New("cannot snapshot the database within a batch")
+}
+
+// Flush flushes the wrapped database, the batch is only flushed once committed
+func (backend *BatchBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Compact compacts the wrapped database, the batch is not stored until committed
+func (backend *BatchBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped database, the batch is not backed up until committed
+func (backend *BatchBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load returns an error, a restore cannot be part of a batch
+func (backend *BatchBackend) Load(r io.Reader) error {
+	return errors.New("cannot restore the database within a batch")
+}
+
+// Begin begins a transaction batching its writes into the batch on commit
+func (backend *BatchBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Commit stores the collected values in the wrapped database atomically and empties the batch
+func (backend *BatchBackend) Commit() error {
+	if len(backend.pairs) == 0 {
+		return nil
+	}
+
+	if err := backend.Backend.PutBatch(backend.pairs); err != nil {
+		return err
+	}
+
+	backend.pairs = nil
+	backend.indices = make(map[string]int)
+	return nil
+}
@@ -0,0 +1,103 @@
+package bstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+// failingBatchBackend fails every batch while failing is set
+type failingBatchBackend struct {
+	BlockStoreBackend
+	failing bool
+}
+
+func (backend *failingBatchBackend) PutBatch(pairs []*KeyValue) error {
+	if backend.failing {
+		return errors.New("batch failed")
+	}
+	return backend.BlockStoreBackend.PutBatch(pairs)
+}
+
+func (backend *failingBatchBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+func TestBatchBackend(t *testing.T) {
+	inner := NewMapBackend()
+	if err := inner.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewBatchBackend(inner)
+	if err := batch.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes are visible through the batch, but not in the wrapped database until committed
+	if value, _ := batch.Get([]byte("a")); !bytes.Equal(value, []byte("3")) {
+		t.Errorf("expected the batched value, got %v", value)
+	}
+	if value, _ := inner.Get([]byte("b")); len(value) != 0 {
+		t.Errorf("expected no value before the commit, got %v", value)
+	}
+	if err := batch.Delete([]byte("a")); err == nil {
+		t.Error("expected an error removing a key within a batch")
+	}
+
+	var values []string
+	err := batch.Iterate(nil, func(key []byte, value []byte) error {
+		values = append(values, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil || fmt.Sprint(values) != "[a=3 b=2]" {
+		t.Errorf("expected the batched values to replace the stored ones, got %v, %v", values, err)
+	}
+
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := inner.Get([]byte("a")); !bytes.Equal(value, []byte("3")) {
+		t.Errorf("expected the committed value, got %v", value)
+	}
+	if value, _ := inner.Get([]byte("b")); !bytes.Equal(value, []byte("2")) {
+		t.Errorf("expected the committed value, got %v", value)
+	}
+}
+
+func TestAddBlockAtomic(t *testing.T) {
+	backend := &failingBatchBackend{BlockStoreBackend: NewMapBackend()}
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 1)
+
+	block := bt.ByNum[101]
+	if err := handler.Backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed write stores neither the block nor its index updates
+	backend.failing = true
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err == nil {
+		t.Fatal("expected an error adding the block")
+	}
+
+	for _, key := range [][]byte{block.GetId(), heightIndexKey(1), blockMetadataKey(block.GetId()), {highestBlockKey}} {
+		if value, err := backend.Get(key); err != nil || len(value) != 0 {
+			t.Errorf("expected no record %x after the failed write, got %v", key, err)
+		}
+	}
+
+	backend.failing = false
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := getHeightIndex(backend, 1); err != nil || len(ids) != 1 || !bytes.Equal(ids[0], block.GetId()) {
+		t.Errorf("expected the block in the height index, got %v, %v", ids, err)
+	}
+}
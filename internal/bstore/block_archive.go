@@ -0,0 +1,191 @@
+package bstore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+// blockArchiveMagic starts every block archive. A block archive holds the canonical blocks of a range
+// of heights in ascending order, each a serialized block item with its receipt prefixed with its
+// length, ending with an empty item. Unlike a backup, it does not depend on the backend or keyspace
+// of the database it was exported from.
+var blockArchiveMagic = []byte("KBSA\x01")
+
+// ExportBlocksResult describes a written block archive
+type ExportBlocksResult struct {
+	StartHeight uint64 `json:"start_height"`
+	EndHeight   uint64 `json:"end_height"`
+	Blocks      uint64 `json:"blocks"`
+}
+
+// ImportBlocksResult describes an imported block archive
+type ImportBlocksResult struct {
+	StartHeight uint64 `json:"start_height"`
+	EndHeight   uint64 `json:"end_height"`
+
+	// Blocks is the number of blocks added, Existing the number already stored
+	Blocks   uint64 `json:"blocks"`
+	Existing uint64 `json:"existing"`
+
+	HeadHeight uint64   `json:"head_height"`
+	HeadID     HexBytes `json:"head_id"`
+}
+
+// ExportBlocks writes the canonical blocks of the chain of the highest block between startHeight and
+// endHeight to w as a block archive. A startHeight of 0 exports from the first stored block, an
+// endHeight of 0 up to the head. The chain is read in chunks, so writers are only blocked for the
+// duration of a chunk.
+func (handler *RequestHandler) ExportBlocks(w io.Writer, startHeight uint64, endHeight uint64) (*ExportBlocksResult, error) {
+	headID, startHeight, endHeight, err := handler.exportRange(&ExportChainRequest{StartHeight: startHeight, EndHeight: endHeight})
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(w)
+	if _, err = writer.Write(blockArchiveMagic); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Exporting canonical blocks from height %d to %d", startHeight, endHeight)
+
+	result := &ExportBlocksResult{StartHeight: startHeight, EndHeight: endHeight}
+	for chunkStart := startHeight; chunkStart <= endHeight; chunkStart += exportChunkSize {
+		chunkEnd := chunkStart + exportChunkSize - 1
+		if chunkEnd > endHeight {
+			chunkEnd = endHeight
+		}
+
+		records, err := handler.canonicalRecords(headID, chunkStart, chunkEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			item, err := proto.Marshal(&block_store.BlockItem{
+				BlockId:     record.GetBlockId(),
+				BlockHeight: record.GetBlockHeight(),
+				Block:       record.GetBlock(),
+				Receipt:     record.GetReceipt(),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if err = writeStreamBytes(writer, item); err != nil {
+				return nil, err
+			}
+			result.Blocks++
+		}
+	}
+
+	if err = writeStreamBytes(writer, nil); err != nil {
+		return nil, err
+	}
+
+	return result, writer.Flush()
+}
+
+// ImportBlocks adds the blocks of a block archive read from r through AddBlock, which advances the
+// highest block as they are added, so an interrupted import can be run again. Each block must link
+// to the one before it; the first must be at height 1, the checkpoint block, or follow a stored
+// block. Blocks already stored are checked and skipped.
+func (handler *RequestHandler) ImportBlocks(r io.Reader) (*ImportBlocksResult, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(blockArchiveMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, blockArchiveMagic) {
+		return nil, errors.New("not a block archive")
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportBlocksResult{}
+	var previous *block_store.BlockItem
+	for {
+		data, err := readStreamBytes(reader)
+		if err != nil {
+			return result, err
+		}
+		if len(data) == 0 {
+			break
+		}
+
+		item := &block_store.BlockItem{}
+		if err = proto.Unmarshal(data, item); err != nil {
+			return result, err
+		}
+
+		block := item.GetBlock()
+		if block.GetHeader() == nil || !bytes.Equal(block.GetId(), item.GetBlockId()) || block.GetHeader().GetHeight() != item.GetBlockHeight() {
+			return result, fmt.Errorf("block 0x%x at height %d does not match its archive entry", item.GetBlockId(), item.GetBlockHeight())
+		}
+
+		if previous != nil {
+			if item.GetBlockHeight() != previous.GetBlockHeight()+1 || !bytes.Equal(block.GetHeader().GetPrevious(), previous.GetBlockId()) {
+				return result, fmt.Errorf("block 0x%x at height %d does not follow block 0x%x", item.GetBlockId(), item.GetBlockHeight(), previous.GetBlockId())
+			}
+		} else {
+			if err = handler.checkArchiveStart(block, checkpoint); err != nil {
+				return result, err
+			}
+			result.StartHeight = item.GetBlockHeight()
+		}
+
+		existing, err := handler.Backend.Has(blockRecordKey(item.GetBlockId()))
+		if err != nil {
+			return result, err
+		}
+		if existing {
+			result.Existing++
+		} else {
+			if _, err = handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block, ReceiptToAdd: item.GetReceipt()}); err != nil {
+				return result, fmt.Errorf("could not add block 0x%x at height %d, %w", item.GetBlockId(), item.GetBlockHeight(), err)
+			}
+			result.Blocks++
+		}
+
+		result.EndHeight = item.GetBlockHeight()
+		previous = item
+	}
+
+	highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+	if err != nil {
+		return result, err
+	}
+	result.HeadHeight = highest.GetTopology().GetHeight()
+	result.HeadID = highest.GetTopology().GetId()
+
+	if result.Blocks > 0 {
+		log.Infof("Imported %d block(s) from height %d to %d", result.Blocks, result.StartHeight, result.EndHeight)
+	}
+
+	return result, nil
+}
+
+// checkArchiveStart returns an error unless the first block of an archive can be added, because it
+// starts the chain, is the checkpoint block or follows a stored block
+func (handler *RequestHandler) checkArchiveStart(block *protocol.Block, checkpoint *Checkpoint) error {
+	if block.GetHeader().GetHeight() == 1 {
+		return nil
+	}
+	if checkpoint != nil && bytes.Equal(block.GetId(), checkpoint.BlockID) {
+		return nil
+	}
+
+	height, err := getBlockHeight(handler.Backend, block.GetHeader().GetPrevious())
+	if err != nil || height != block.GetHeader().GetHeight()-1 {
+		return fmt.Errorf("the previous block 0x%x of the first block at height %d is not stored", block.GetHeader().GetPrevious(), block.GetHeader().GetHeight())
+	}
+
+	return nil
+}
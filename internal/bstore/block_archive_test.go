@@ -0,0 +1,117 @@
+package bstore
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExportBlocks(t *testing.T) {
+	b := NewBackend(MapBackendType)
+	handler := RequestHandler{Backend: b}
+	bt := buildLinearChain(t, &handler, 1200)
+
+	var archive bytes.Buffer
+	result, err := handler.ExportBlocks(&archive, 5, 1100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StartHeight != 5 || result.EndHeight != 1100 || result.Blocks != 1096 {
+		t.Errorf("unexpected export %+v", result)
+	}
+
+	if !bytes.HasPrefix(archive.Bytes(), blockArchiveMagic) {
+		t.Fatal("expected the block archive magic")
+	}
+	reader := bufio.NewReader(bytes.NewReader(archive.Bytes()[len(blockArchiveMagic):]))
+
+	// Blocks are in ascending height order across chunks
+	for height := uint64(5); height <= 1100; height++ {
+		data, err := readStreamBytes(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item := &block_store.BlockItem{}
+		if err = proto.Unmarshal(data, item); err != nil {
+			t.Fatal(err)
+		}
+		if item.GetBlockHeight() != height || !bytes.Equal(item.GetBlockId(), bt.ByNum[100+height].GetId()) || item.GetBlock() == nil {
+			t.Fatalf("unexpected item at height %d: %v", height, item)
+		}
+	}
+	if data, err := readStreamBytes(reader); err != nil || len(data) != 0 {
+		t.Errorf("expected the archive to end, got %x, %v", data, err)
+	}
+
+	// The range defaults to the whole chain and is clamped to the head
+	if result, err = handler.ExportBlocks(&bytes.Buffer{}, 0, 1300); err != nil || result.StartHeight != 1 || result.EndHeight != 1200 || result.Blocks != 1200 {
+		t.Errorf("expected the whole chain, got %+v, %v", result, err)
+	}
+	if _, err = handler.ExportBlocks(&bytes.Buffer{}, 1300, 0); err == nil {
+		t.Error("expected an error for a range above the head")
+	}
+
+	CloseBackend(b)
+}
+
+func TestImportBlocks(t *testing.T) {
+	source := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &source, 50)
+
+	export := func(startHeight uint64, endHeight uint64) []byte {
+		var archive bytes.Buffer
+		if _, err := source.ExportBlocks(&archive, startHeight, endHeight); err != nil {
+			t.Fatal(err)
+		}
+		return archive.Bytes()
+	}
+
+	handler := RequestHandler{Backend: NewMapBackend()}
+	result, err := handler.ImportBlocks(bytes.NewReader(export(1, 30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 30 || result.Existing != 0 || result.StartHeight != 1 || result.EndHeight != 30 || result.HeadHeight != 30 {
+		t.Errorf("unexpected import %+v", result)
+	}
+
+	// An archive may overlap the stored blocks, and continue from them
+	if result, err = handler.ImportBlocks(bytes.NewReader(export(21, 50))); err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 20 || result.Existing != 10 || result.HeadHeight != 50 || !bytes.Equal(result.HeadID, bt.ByNum[150].GetId()) {
+		t.Errorf("unexpected import %+v", result)
+	}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: result.HeadID, StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid || resp.VerifyChainLinks.BlocksChecked != 50 {
+		t.Errorf("expected a valid chain after the import, got %+v", resp)
+	}
+
+	// The first block must follow a stored block
+	if _, err = (&RequestHandler{Backend: NewMapBackend()}).ImportBlocks(bytes.NewReader(export(40, 50))); err == nil {
+		t.Error("expected an error for an archive which does not follow a stored block")
+	}
+
+	// Every block must follow the one before it
+	var archive bytes.Buffer
+	writer := bufio.NewWriter(&archive)
+	writer.Write(blockArchiveMagic)
+	for _, height := range []uint64{1, 2, 4} {
+		block := bt.ByNum[100+height]
+		item, _ := proto.Marshal(&block_store.BlockItem{BlockId: block.GetId(), BlockHeight: height, Block: block})
+		writeStreamBytes(writer, item)
+	}
+	writeStreamBytes(writer, nil)
+	writer.Flush()
+	if _, err = (&RequestHandler{Backend: NewMapBackend()}).ImportBlocks(&archive); err == nil {
+		t.Error("expected an error for a gap in the archive")
+	}
+
+	if _, err = handler.ImportBlocks(bytes.NewReader([]byte("not an archive"))); err == nil {
+		t.Error("expected an error for a file which is not a block archive")
+	}
+}
@@ -0,0 +1,112 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxBlockMetadataRequest = 1000
+)
+
+// BlockMetadata describes a stored block without its contents
+type BlockMetadata struct {
+	BlockID          HexBytes `json:"block_id"`
+	BlockHeight      uint64   `json:"block_height"`
+	Size             uint64   `json:"size"`
+	TransactionCount uint64   `json:"transaction_count"`
+}
+
+// GetBlockMetadataRequest asks for the metadata of blocks by ID
+type GetBlockMetadataRequest struct {
+	BlockIDs []HexBytes `json:"block_ids"`
+}
+
+// GetBlockMetadataResponse contains metadata for each requested block, in request order. Blocks
+// which are not stored are omitted.
+type GetBlockMetadataResponse struct {
+	Items []*BlockMetadata `json:"items"`
+}
+
+func blockMetadataKey(blockID []byte) []byte {
+	return append([]byte{blockMetadataPrefix}, blockID...)
+}
+
+func newBlockMetadata(block *protocol.Block, height uint64) *BlockMetadata {
+	return &BlockMetadata{
+		BlockID:          block.GetId(),
+		BlockHeight:      height,
+		Size:             uint64(proto.Size(block)),
+		TransactionCount: uint64(len(block.GetTransactions())),
+	}
+}
+
+// putBlockMetadata stores the metadata of a block so it can be served without loading the block record
+func putBlockMetadata(backend BlockStoreBackend, metadata *BlockMetadata) error {
+	var value []byte
+	value = protowire.AppendVarint(value, metadata.BlockHeight)
+	value = protowire.AppendVarint(value, metadata.Size)
+	value = protowire.AppendVarint(value, metadata.TransactionCount)
+
+	return backend.Put(blockMetadataKey(metadata.BlockID), value)
+}
+
+// getBlockMetadata returns the metadata of a block. Blocks added before metadata was recorded have it
+// computed from the stored record.
+func (handler *RequestHandler) getBlockMetadata(blockID []byte) (*BlockMetadata, error) {
+	value, err := handler.Backend.Get(blockMetadataKey(blockID))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) == 0 {
+		record, err := handler.getRecord(blockID)
+		if err != nil {
+			return nil, err
+		}
+
+		return newBlockMetadata(record.GetBlock(), record.GetBlockHeight()), nil
+	}
+
+	fields := make([]uint64, 3)
+	for i := range fields {
+		v, n := protowire.ConsumeVarint(value)
+		if n < 0 {
+			return nil, errors.New("block metadata record corrupted")
+		}
+		fields[i] = v
+		value = value[n:]
+	}
+
+	return &BlockMetadata{BlockID: blockID, BlockHeight: fields[0], Size: fields[1], TransactionCount: fields[2]}, nil
+}
+
+// GetBlockMetadata returns the serialized size and transaction count of blocks without their contents
+func (handler *RequestHandler) GetBlockMetadata(ctx context.Context, req *GetBlockMetadataRequest) (*GetBlockMetadataResponse, error) {
+	if len(req.BlockIDs) > maxBlockMetadataRequest {
+		return nil, errors.New("requested too many blocks")
+	}
+
+	resp := &GetBlockMetadataResponse{Items: make([]*BlockMetadata, 0, len(req.BlockIDs))}
+	for _, id := range req.BlockIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		metadata, err := handler.getBlockMetadata(id)
+		if _, ok := err.(*BlockNotPresent); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Items = append(resp.Items, metadata)
+	}
+
+	return resp, nil
+}
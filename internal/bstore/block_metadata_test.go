@@ -0,0 +1,53 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGetBlockMetadata(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		blocks := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, 2, 0)
+		blocks[1].Transactions = []*protocol.Transaction{{Id: []byte{0x01}}, {Id: []byte{0x02}}, {Id: []byte{0x03}}}
+		for _, block := range blocks {
+			if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Blocks added before metadata was recorded have it computed from the record
+		if err := b.Delete(blockMetadataKey(blocks[0].GetId())); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetBlockMetadata: &GetBlockMetadataRequest{
+			BlockIDs: []HexBytes{blocks[1].GetId(), GetNonExistentBlockID(1), blocks[0].GetId()},
+		}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+
+		items := resp.GetBlockMetadata.Items
+		if len(items) != 2 {
+			t.Fatalf("expected 2 items, got %d", len(items))
+		}
+
+		for i, block := range []*protocol.Block{blocks[1], blocks[0]} {
+			if !bytes.Equal(items[i].BlockID, block.GetId()) || items[i].BlockHeight != block.GetHeader().GetHeight() {
+				t.Errorf("unexpected block in item %d", i)
+			}
+			if items[i].Size != uint64(proto.Size(block)) || items[i].TransactionCount != uint64(len(block.GetTransactions())) {
+				t.Errorf("unexpected metadata in item %d: %+v", i, items[i])
+			}
+		}
+
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,162 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	continuationDigestLen = 8
+
+	maxHasBlocksRequest = 10000
+)
+
+// GetBlocksByIDPagedRequest asks for blocks by ID. Unlike GetBlocksById, any number of IDs may be
+// requested. At most the configured maximum blocks by ID are returned per response, the rest are requested by repeating the
+// request with the returned ContinuationToken.
+type GetBlocksByIDPagedRequest struct {
+	BlockIDs      []HexBytes `json:"block_ids"`
+	ReturnBlock   bool       `json:"return_block"`
+	ReturnReceipt bool       `json:"return_receipt"`
+
+	// ContinuationToken is the token returned by the previous page, empty for the first page
+	ContinuationToken HexBytes `json:"continuation_token,omitempty"`
+}
+
+// GetBlocksByIDPagedResponse contains the blocks for BlockIDs[Offset:Offset+len(BlockItems)], in
+// request order. Blocks which are not stored are null. Pages which would exceed the maximum message
+// size are cut short, the ContinuationToken resumes after the last returned block.
+type GetBlocksByIDPagedResponse struct {
+	BlockItems []*BlockItem `json:"block_items"`
+	Offset     uint64       `json:"offset"`
+
+	// ContinuationToken is set if there are more blocks to request
+	ContinuationToken HexBytes `json:"continuation_token,omitempty"`
+
+	digest []byte
+}
+
+// HasBlocksRequest asks which of the given blocks are stored
+type HasBlocksRequest struct {
+	BlockIDs []HexBytes `json:"block_ids"`
+}
+
+// HasBlocksResponse reports for each requested block, in request order, whether it is stored
+type HasBlocksResponse struct {
+	Present []bool `json:"present"`
+}
+
+// GetBlocksByIDPaged returns a page of blocks by block ID
+func (handler *RequestHandler) GetBlocksByIDPaged(ctx context.Context, req *GetBlocksByIDPagedRequest) (*GetBlocksByIDPagedResponse, error) {
+	if len(req.BlockIDs) == 0 {
+		return nil, &InvalidRequestError{Reason: "expected field 'block_ids' was empty"}
+	}
+
+	digest := blockIDsDigest(req.BlockIDs)
+
+	var offset uint64
+	if len(req.ContinuationToken) > 0 {
+		var err error
+		if offset, err = parseContinuationToken(req.ContinuationToken, digest, len(req.BlockIDs)); err != nil {
+			return nil, err
+		}
+	}
+
+	end := offset + handler.maxBlocksByID()
+	if end > uint64(len(req.BlockIDs)) {
+		end = uint64(len(req.BlockIDs))
+	}
+
+	ids := make([][]byte, 0, end-offset)
+	for _, id := range req.BlockIDs[offset:end] {
+		ids = append(ids, id)
+	}
+
+	blocks, err := handler.GetBlocksByID(ctx, &block_store.GetBlocksByIdRequest{
+		BlockIds:      ids,
+		ReturnBlock:   req.ReturnBlock,
+		ReturnReceipt: req.ReturnReceipt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &GetBlocksByIDPagedResponse{BlockItems: make([]*BlockItem, len(ids)), Offset: offset, digest: digest}
+	for i, item := range blocks.GetBlockItems() {
+		// GetBlocksById returns an empty item for blocks which are not stored
+		if item == nil || len(item.GetBlockId()) == 0 {
+			continue
+		}
+
+		if resp.BlockItems[i], err = newBlockItem(item); err != nil {
+			return nil, err
+		}
+	}
+
+	if end < uint64(len(req.BlockIDs)) {
+		resp.ContinuationToken = makeContinuationToken(end, digest)
+	}
+
+	return resp, nil
+}
+
+// blockIDsDigest identifies a list of block IDs so a continuation token cannot be used with a different list
+func blockIDsDigest(ids []HexBytes) []byte {
+	h := sha256.New()
+	for _, id := range ids {
+		h.Write(protowire.AppendBytes(nil, id))
+	}
+
+	return h.Sum(nil)[:continuationDigestLen]
+}
+
+func makeContinuationToken(offset uint64, digest []byte) HexBytes {
+	token := protowire.AppendVarint(nil, offset)
+	return append(token, digest...)
+}
+
+func parseContinuationToken(token HexBytes, digest []byte, count int) (uint64, error) {
+	offset, n := protowire.ConsumeVarint(token)
+	if n < 0 || !bytes.Equal(token[n:], digest) {
+		return 0, &InvalidRequestError{Reason: "continuation token does not match the requested block IDs"}
+	}
+
+	if offset >= uint64(count) {
+		return 0, &InvalidRequestError{Reason: fmt.Sprintf("continuation token offset %d is out of range", offset)}
+	}
+
+	return offset, nil
+}
+
+// HasBlocks reports which of the requested blocks are stored, without reading the blocks
+func (handler *RequestHandler) HasBlocks(ctx context.Context, req *HasBlocksRequest) (*HasBlocksResponse, error) {
+	if len(req.BlockIDs) == 0 {
+		return nil, &InvalidRequestError{Reason: "expected field 'block_ids' was empty"}
+	}
+	if len(req.BlockIDs) > maxHasBlocksRequest {
+		return nil, &InvalidRequestError{Reason: fmt.Sprintf("requested more than %d blocks", maxHasBlocksRequest)}
+	}
+
+	resp := &HasBlocksResponse{Present: make([]bool, len(req.BlockIDs))}
+	for i, id := range req.BlockIDs {
+		if len(id) == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		present, err := handler.Backend.Has(blockRecordKey(id))
+		if err != nil {
+			return nil, err
+		}
+		resp.Present[i] = present
+	}
+
+	return resp, nil
+}
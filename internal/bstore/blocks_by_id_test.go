@@ -0,0 +1,93 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestGetBlocksByIDPaged(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 1500)
+
+	// Request every block twice, with a missing block in between
+	ids := make([]HexBytes, 0, 3001)
+	for i := uint64(1); i <= 1500; i++ {
+		ids = append(ids, bt.ByNum[100+i].GetId(), bt.ByNum[100+i].GetId())
+	}
+	ids = append(ids[:1000], append([]HexBytes{GetNonExistentBlockID(1)}, ids[1000:]...)...)
+
+	req := &GetBlocksByIDPagedRequest{BlockIDs: ids}
+	var items []*BlockItem
+	pages := 0
+	for {
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetBlocksByIDPaged: req})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+
+		page := resp.GetBlocksByIDPaged
+		if page.Offset != uint64(len(items)) {
+			t.Fatalf("expected offset %d, got %d", len(items), page.Offset)
+		}
+
+		items = append(items, page.BlockItems...)
+		pages++
+
+		if len(page.ContinuationToken) == 0 {
+			break
+		}
+		req.ContinuationToken = page.ContinuationToken
+	}
+
+	if pages != 4 || len(items) != len(ids) {
+		t.Fatalf("expected %d blocks in 4 pages, got %d in %d", len(ids), len(items), pages)
+	}
+	for i, item := range items {
+		if i == 1000 {
+			if item != nil {
+				t.Error("expected missing block to be null")
+			}
+			continue
+		}
+		if item == nil || !bytes.Equal(item.BlockID, ids[i]) {
+			t.Fatalf("unexpected block at index %d", i)
+		}
+	}
+
+	// A token is only valid for the list of IDs it was issued for
+	first, err := handler.GetBlocksByIDPaged(context.Background(), &GetBlocksByIDPagedRequest{BlockIDs: ids})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = handler.GetBlocksByIDPaged(context.Background(), &GetBlocksByIDPagedRequest{BlockIDs: ids[1:], ContinuationToken: first.ContinuationToken})
+	if ErrorCodeOf(err) != ErrorCodeInvalidRequest {
+		t.Errorf("expected invalid request for mismatched token, got %v", err)
+	}
+}
+
+func TestHasBlocks(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 5)
+
+	ids := []HexBytes{bt.ByNum[101].GetId(), GetNonExistentBlockID(1), bt.ByNum[105].GetId(), nil}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{HasBlocks: &HasBlocksRequest{BlockIDs: ids}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+
+	expected := []bool{true, false, true, false}
+	if len(resp.HasBlocks.Present) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(resp.HasBlocks.Present))
+	}
+	for i := range expected {
+		if resp.HasBlocks.Present[i] != expected[i] {
+			t.Errorf("expected block %d present %v, got %v", i, expected[i], resp.HasBlocks.Present[i])
+		}
+	}
+
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{HasBlocks: &HasBlocksRequest{}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeInvalidRequest {
+		t.Errorf("expected an invalid request error, got %+v", resp.Error)
+	}
+}
@@ -0,0 +1,473 @@
+package bstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltOpenTimeout = 5 * time.Second
+
+	// boltInitialMmapSize is mapped up front, a write only waits for the open snapshots if it grows
+	// the file beyond the mapping
+	boltInitialMmapSize = 1 << 30
+
+	// boltCompactTxSize bounds the size of the transactions copying the database during a compaction
+	boltCompactTxSize = 64 << 20
+)
+
+var (
+	boltBucket = []byte("records")
+
+	errBoltClosed = errors.New("bolt database is closed")
+)
+
+// BoltBackend bbolt backend implementation. Records are stored in the records bucket of a single
+// B+tree file, which keeps memory usage predictable and can be backed up by copying the file.
+type BoltBackend struct {
+	Path string
+
+	// lock guards db against a restore replacing the database file, db is nil once closed
+	lock sync.RWMutex
+	db   *bolt.DB
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+}
+
+// NewBoltBackend BoltBackend constructor. The database file at path is created if missing.
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltBackend{Path: path, db: db}, nil
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	// The timeout fails the open instead of blocking while another process holds the file lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout, InitialMmapSize: boltInitialMmapSize})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Close cleans backend resources
+func (backend *BoltBackend) Close() {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.db != nil {
+		_ = backend.db.Close()
+		backend.db = nil
+	}
+}
+
+// Reset resets the database
+func (backend *BoltBackend) Reset() error {
+	return backend.update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(boltBucket)
+		return err
+	})
+}
+
+// Put backend setter
+func (backend *BoltBackend) Put(key, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+// PutBatch stores the values in a single transaction
+func (backend *BoltBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	return backend.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, pair := range pairs {
+			if err := bucket.Put(pair.Key, pair.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete an item from the database
+func (backend *BoltBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	return backend.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+// Iterate calls fn with copies of the keys starting with prefix and their values, read from a single
+// transaction
+func (backend *BoltBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return errBoltClosed
+	}
+
+	return stopIteration(backend.db.View(func(tx *bolt.Tx) error {
+		return boltIterate(tx, prefix, fn)
+	}))
+}
+
+// Snapshot takes a snapshot of the database with a read-only transaction. It keeps the pages it reads
+// from being reused until released, and a write growing the database file beyond its initial mapping
+// waits for it.
+func (backend *BoltBackend) Snapshot() (BackendSnapshot, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errBoltClosed
+	}
+
+	tx, err := backend.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltSnapshot{tx: tx}, nil
+}
+
+// boltSnapshot reads from a read-only transaction
+type boltSnapshot struct {
+	tx *bolt.Tx
+}
+
+func (snapshot *boltSnapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	// Values are only valid within the transaction
+	return append(make([]byte, 0), snapshot.tx.Bucket(boltBucket).Get(key)...), nil
+}
+
+func (snapshot *boltSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(boltIterate(snapshot.tx, prefix, fn))
+}
+
+func (snapshot *boltSnapshot) Release() {
+	_ = snapshot.tx.Rollback()
+}
+
+// boltIterate calls fn with the keys starting with prefix and their values within tx
+func boltIterate(tx *bolt.Tx, prefix []byte, fn func(key []byte, value []byte) error) error {
+	cursor := tx.Bucket(boltBucket).Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if err := fn(append([]byte{}, key...), append([]byte{}, value...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush syncs the database file. Bolt already syncs every committed write.
+func (backend *BoltBackend) Flush() error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return errBoltClosed
+	}
+
+	return backend.db.Sync()
+}
+
+// Compact copies the database into a new file and replaces the database file with it. bbolt never
+// shrinks its file, the copy only holds the pages in use. Reads and writes are blocked until the copy
+// completes. Bolt has no value log, the file sizes are reported as the LSM sizes.
+func (backend *BoltBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.db == nil {
+		return nil, errBoltClosed
+	}
+
+	result := &CompactionResult{}
+	info, err := os.Stat(backend.Path)
+	if err != nil {
+		return nil, err
+	}
+	result.LSMSizeBefore = info.Size()
+
+	compactPath := backend.Path + ".compact"
+	_ = os.Remove(compactPath)
+	defer func() { _ = os.Remove(compactPath) }()
+
+	compacted, err := bolt.Open(compactPath, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = bolt.Compact(compacted, backend.db, boltCompactTxSize)
+	if closeErr := compacted.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = backend.db.Close(); err != nil {
+		return nil, err
+	}
+	backend.db = nil
+
+	if err = os.Rename(compactPath, backend.Path); err != nil {
+		// Keep serving the previous database
+		backend.db, _ = openBolt(backend.Path)
+		return nil, err
+	}
+
+	if backend.db, err = openBolt(backend.Path); err != nil {
+		return nil, err
+	}
+
+	if info, err = os.Stat(backend.Path); err != nil {
+		return nil, err
+	}
+	result.LSMSizeAfter = info.Size()
+
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return result, nil
+}
+
+// Begin begins a transaction batching its writes. A Bolt write transaction would detect no
+// conflicts either, as it excludes other writers, but would block them while the caller reads.
+func (backend *BoltBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Get backend getter
+func (backend *BoltBackend) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errBoltClosed
+	}
+
+	// Values are only valid within the transaction, and a missing key returns an empty value like the
+	// other backends
+	value := make([]byte, 0)
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		value = append(value, tx.Bucket(boltBucket).Get(key)...)
+		return nil
+	})
+
+	return value, err
+}
+
+// Has reports whether key is stored, without copying its value
+func (backend *BoltBackend) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("cannot check an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return false, errBoltClosed
+	}
+
+	found := false
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(boltBucket).Get(key) != nil
+		return nil
+	})
+
+	return found, err
+}
+
+func (backend *BoltBackend) update(fn func(*bolt.Tx) error) error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return backend.recordWrite(errBoltClosed)
+	}
+
+	return backend.recordWrite(backend.db.Update(fn))
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *BoltBackend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// Backup writes a consistent copy of the database file to w. Incremental backups are not supported,
+// the returned version is the ID of the transaction the copy was taken in.
+func (backend *BoltBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	if sinceVersion > 0 {
+		return 0, errors.New("bolt backend only supports full backups")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return 0, errBoltClosed
+	}
+
+	var version uint64
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		version = uint64(tx.ID())
+		_, err := tx.WriteTo(w)
+		return err
+	})
+
+	return version, err
+}
+
+// Load replaces the database with a copy written by Backup. The copy is verified before the
+// database file is replaced.
+func (backend *BoltBackend) Load(r io.Reader) error {
+	restorePath := backend.Path + ".restore"
+	file, err := os.OpenFile(restorePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(restorePath) }()
+
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	restored, err := openBolt(restorePath)
+	if err != nil {
+		return err
+	}
+	if err = restored.Close(); err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.db == nil {
+		return errBoltClosed
+	}
+
+	if err = backend.db.Close(); err != nil {
+		return err
+	}
+	backend.db = nil
+
+	if err = os.Rename(restorePath, backend.Path); err != nil {
+		// Keep serving the previous database
+		backend.db, _ = openBolt(backend.Path)
+		return err
+	}
+
+	backend.db, err = openBolt(backend.Path)
+	return backend.recordWrite(err)
+}
+
+// Health reports whether the database accepts writes, when it was last written and the free space on
+// the database volume. bbolt has no background compactions.
+func (backend *BoltBackend) Health() (*BackendHealth, error) {
+	backend.lock.RLock()
+	writable := backend.db != nil && !backend.db.IsReadOnly()
+	backend.lock.RUnlock()
+
+	health := &BackendHealth{
+		Writable: writable && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	free, err := diskFree(filepath.Dir(backend.Path))
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
+
+// Stats reports the size of the database file, counting the keys would read every page
+func (backend *BoltBackend) Stats() (*BackendStats, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errBoltClosed
+	}
+
+	stats := &BackendStats{LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		stats.DiskSize = tx.Size()
+		return nil
+	})
+
+	return stats, err
+}
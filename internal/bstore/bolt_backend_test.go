@@ -0,0 +1,113 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	BoltBackendType = 6
+)
+
+func init() {
+	backendTypes = append(backendTypes, BoltBackendType)
+	taggedBackends[BoltBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewBoltBackend(filepath.Join(dirname, "block_store.db"))
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestBoltBackendBasic(t *testing.T) {
+	b := NewBackend(BoltBackendType)
+
+	backendTest(t, b)
+
+	CloseBackend(b)
+}
+
+func TestBoltBackend(t *testing.T) {
+	backend := NewBackend(BoltBackendType).(*BoltBackend)
+	defer CloseBackend(backend)
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 10)
+
+	var backup bytes.Buffer
+	if _, err := backend.Backup(&backup, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Backup(&bytes.Buffer{}, 1); err == nil {
+		t.Error("expected incremental backups to be unsupported")
+	}
+
+	// A restore replaces everything written since the backup
+	if err := backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Load(bytes.NewReader([]byte("not a bolt database"))); err == nil {
+		t.Error("expected an invalid backup to be rejected")
+	}
+	if err := backend.Load(&backup); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain after restore, got %+v", resp)
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.Writable || health.LastWrite == nil || health.DiskFreeBytes == nil {
+		t.Errorf("unexpected health %+v, %v", health, err)
+	}
+
+	backend.Close()
+	if _, err = backend.Get([]byte{highestBlockKey}); err == nil {
+		t.Error("expected reads to fail once closed")
+	}
+	if backendHealth, err := backend.Health(); err != nil || backendHealth.Writable {
+		t.Errorf("expected a closed database not to be writable, got %+v, %v", backendHealth, err)
+	}
+}
+
+func TestBoltBackendCompact(t *testing.T) {
+	backend := NewBackend(BoltBackendType).(*BoltBackend)
+	defer CloseBackend(backend)
+
+	value := bytes.Repeat([]byte{0x01}, 4096)
+	for i := 0; i < 1000; i++ {
+		if err := backend.Put([]byte{0x10, byte(i >> 8), byte(i)}, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < 1000; i++ {
+		if err := backend.Delete([]byte{0x10, byte(i >> 8), byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := backend.Compact(defaultDiscardRatio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.LSMSizeAfter >= result.LSMSizeBefore {
+		t.Errorf("expected the file to shrink, from %d to %d bytes", result.LSMSizeBefore, result.LSMSizeAfter)
+	}
+	if v, err := backend.Get([]byte{0x10, 0x00, 0x00}); err != nil || !bytes.Equal(v, value) {
+		t.Errorf("expected the value kept by the compaction, got %v", err)
+	}
+
+	stats, err := backend.Stats()
+	if err != nil || stats.LastCompaction == nil {
+		t.Errorf("expected the time of the compaction, got %+v, %v", stats, err)
+	}
+}
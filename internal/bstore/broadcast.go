@@ -0,0 +1,24 @@
+package bstore
+
+// BlockAdded is published after a block has been persisted
+type BlockAdded struct {
+	BlockID    HexBytes `json:"block_id"`
+	Height     uint64   `json:"height"`
+	PreviousID HexBytes `json:"previous_id"`
+
+	// New is false if the block was already stored
+	New bool `json:"new"`
+
+	// ChainID is the configured chain ID, omitted if none is configured
+	ChainID HexBytes `json:"chain_id,omitempty"`
+}
+
+// Ready is published once the block store has opened its database and can accept writes
+type Ready struct {
+	HeadBlockID HexBytes `json:"head_block_id"`
+	HeadHeight  uint64   `json:"head_height"`
+	PreviousID  HexBytes `json:"previous_id"`
+
+	// ChainID is the configured chain ID, omitted if none is configured
+	ChainID HexBytes `json:"chain_id,omitempty"`
+}
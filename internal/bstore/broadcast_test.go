@@ -0,0 +1,43 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestOnBlockAdded(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+
+		var added []*BlockAdded
+		handler := RequestHandler{Backend: b, ChainID: []byte{0x01, 0x02}, OnBlockAdded: func(a *BlockAdded) { added = append(added, a) }}
+
+		blocks := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, 2, 0)
+		for _, block := range []*protocol.Block{blocks[0], blocks[1], blocks[1]} {
+			if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A block which fails to be added is not announced
+		_, _ = handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: &protocol.Block{Id: []byte{0x01}}})
+
+		if len(added) != 3 {
+			t.Fatalf("expected 3 block added notifications, got %d", len(added))
+		}
+		if !added[0].New || !added[1].New || added[2].New {
+			t.Error("expected re-added block to be reported as not new")
+		}
+		if !bytes.Equal(added[1].BlockID, blocks[1].GetId()) || !bytes.Equal(added[1].PreviousID, blocks[0].GetId()) || added[1].Height != 2 {
+			t.Errorf("unexpected block added notification %+v", added[1])
+		}
+		if !bytes.Equal(added[0].ChainID, handler.ChainID) {
+			t.Errorf("expected chain ID %x, got %x", handler.ChainID, added[0].ChainID)
+		}
+
+		CloseBackend(b)
+	}
+}
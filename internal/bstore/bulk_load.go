@@ -0,0 +1,56 @@
+package bstore
+
+// bulkLoadBatchSize is the number of records stored by each batch of a bulk load into a backend
+// without a bulk load path of its own
+const bulkLoadBatchSize = 1000
+
+// RecordSource calls fn with each record to load, in ascending key order, stopping at the first
+// error fn returns. The key and value are only valid during the call.
+type RecordSource func(fn func(key []byte, value []byte) error) error
+
+// bulkLoadBackend is implemented by backends which can be filled faster than by writing records
+type bulkLoadBackend interface {
+	BulkLoad(source RecordSource) error
+}
+
+// BulkLoad replaces the contents of backend with the records of source, such as the records of a
+// snapshot of another backend. Backends without a faster path are reset and written in batches.
+func BulkLoad(backend BlockStoreBackend, source RecordSource) error {
+	if loader, ok := backend.(bulkLoadBackend); ok {
+		return loader.BulkLoad(source)
+	}
+
+	if err := backend.Reset(); err != nil {
+		return err
+	}
+
+	batch := make([]*KeyValue, 0, bulkLoadBatchSize)
+	err := source(func(key []byte, value []byte) error {
+		batch = append(batch, &KeyValue{Key: append([]byte{}, key...), Value: append([]byte{}, value...)})
+		if len(batch) < bulkLoadBatchSize {
+			return nil
+		}
+
+		err := backend.PutBatch(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+
+	return backend.PutBatch(batch)
+}
+
+// mapRecordSource returns a source of the records of source with their values replaced by encode
+func mapRecordSource(source RecordSource, encode func(key []byte, value []byte) ([]byte, error)) RecordSource {
+	return func(fn func(key []byte, value []byte) error) error {
+		return source(func(key []byte, value []byte) error {
+			value, err := encode(key, value)
+			if err != nil {
+				return err
+			}
+			return fn(key, value)
+		})
+	}
+}
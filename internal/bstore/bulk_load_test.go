@@ -0,0 +1,84 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestBulkLoad(t *testing.T) {
+	source := NewMapBackend()
+	buildLinearChain(t, &RequestHandler{Backend: source}, 20)
+
+	snapshot, err := source.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Release()
+	records := func(fn func(key []byte, value []byte) error) error {
+		return snapshot.Iterate(nil, fn)
+	}
+
+	badgerBackend := NewBackend(BadgerBackendType)
+	defer CloseBackend(badgerBackend)
+	compressed, err := NewCompressedBackend(NewBackend(BadgerBackendType), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseBackend(compressed.Backend)
+
+	for name, backend := range map[string]BlockStoreBackend{
+		"map":      NewMapBackend(),
+		"badger":   badgerBackend,
+		"checksum": NewChecksumBackend(compressed, true),
+	} {
+		// The load replaces the existing records
+		if err = backend.Put([]byte{0x12, 0x01}, []byte("stale")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = BulkLoad(backend, records); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if value, _ := backend.Get([]byte{0x12, 0x01}); len(value) > 0 {
+			t.Errorf("%s: expected the existing records to be dropped", name)
+		}
+
+		count := 0
+		err = snapshot.Iterate(nil, func(key []byte, value []byte) error {
+			count++
+			if loaded, err := backend.Get(key); err != nil || !bytes.Equal(loaded, value) {
+				t.Errorf("%s: expected the value of key %x to be loaded, got %v", name, key, err)
+			}
+			return nil
+		})
+		if err != nil || count == 0 {
+			t.Fatalf("%s: expected records to compare, got %d, %v", name, count, err)
+		}
+
+		handler := RequestHandler{Backend: backend}
+		highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+		if err != nil || highest.GetTopology().GetHeight() != 20 {
+			t.Errorf("%s: expected the loaded highest block, got %v", name, err)
+		}
+	}
+
+	// Badger writes its tables in key order, so records out of order are rejected
+	unordered := func(fn func(key []byte, value []byte) error) error {
+		if err := fn([]byte{0x12, 0x02}, []byte("b")); err != nil {
+			return err
+		}
+		return fn([]byte{0x12, 0x01}, []byte("a"))
+	}
+	if err = BulkLoad(badgerBackend, unordered); err == nil {
+		t.Error("expected an error for records out of key order")
+	}
+
+	// Keys outside the namespaces are rejected
+	namespaced := NewNamespaceBackend(NewMapBackend(), NamespaceBlocks)
+	if err = BulkLoad(namespaced, records); ErrorCodeOf(err) != ErrorCodeInvalidRequest {
+		t.Errorf("expected a key outside the namespaces to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package bstore
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	key   string
+	value []byte
+}
+
+// CacheBackend wraps a backend with an LRU cache of the values read and written through it, bounded
+// by the total size in bytes of the cached keys and values. Unlike MemoBackend, it is safe for
+// concurrent use and meant to live as long as the wrapped backend. Writes made directly to the
+// wrapped backend are not seen.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend.
+type CacheBackend struct {
+	Backend BlockStoreBackend
+
+	lock     sync.Mutex
+	capacity int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List
+	stats    CacheStats
+
+	// writes counts the writes through the cache, a value read on a miss is only cached if no write
+	// happened during the read, as it may be stale
+	writes uint64
+}
+
+// NewCacheBackend creates a CacheBackend over backend holding up to capacity bytes
+func NewCacheBackend(backend BlockStoreBackend, capacity int64) *CacheBackend {
+	return &CacheBackend{
+		Backend:  backend,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Capacity returns the maximum size of the cache in bytes
+func (backend *CacheBackend) Capacity() int64 {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return backend.capacity
+}
+
+// Size returns the size of the cached keys and values in bytes
+func (backend *CacheBackend) Size() int64 {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return backend.size
+}
+
+// SetCapacity resizes the cache, evicting the least recently used values if it shrinks
+func (backend *CacheBackend) SetCapacity(capacity int64) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.capacity = capacity
+	backend.evict()
+}
+
+// TakeStats returns the cache statistics since the previous call and starts counting anew
+func (backend *CacheBackend) TakeStats() CacheStats {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	stats := backend.stats
+	backend.stats = CacheStats{}
+	return stats
+}
+
+// Reset clears the cache and resets the wrapped database
+func (backend *CacheBackend) Reset() error {
+	backend.clear()
+	return backend.Backend.Reset()
+}
+
+// Put adds the requested value to the wrapped database and the cache
+func (backend *CacheBackend) Put(key []byte, value []byte) error {
+	backend.remove(key)
+	if err := backend.Backend.Put(key, value); err != nil {
+		return err
+	}
+
+	backend.add(key, value)
+	return nil
+}
+
+// PutWithTTL stores the value in the wrapped database with a TTL. The value is not cached, it would
+// outlive the record once the wrapped database removes it.
+func (backend *CacheBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	backend.remove(key)
+	return putWithTTL(backend.Backend, key, value, ttl)
+}
+
+// PutBatch adds the requested values to the wrapped database and the cache
+func (backend *CacheBackend) PutBatch(pairs []*KeyValue) error {
+	for _, pair := range pairs {
+		backend.remove(pair.Key)
+	}
+	if err := backend.Backend.PutBatch(pairs); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		backend.add(pair.Key, pair.Value)
+	}
+	return nil
+}
+
+// Delete removes an item from the wrapped database and the cache
+func (backend *CacheBackend) Delete(key []byte) error {
+	backend.remove(key)
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the cache, reading the wrapped database on a miss
+func (backend *CacheBackend) Get(key []byte) ([]byte, error) {
+	backend.lock.Lock()
+	if element, ok := backend.entries[string(key)]; ok {
+		backend.order.MoveToFront(element)
+		backend.stats.Hits++
+		value := element.Value.(*cacheEntry).value
+		backend.lock.Unlock()
+		return value, nil
+	}
+	writes := backend.writes
+	backend.lock.Unlock()
+
+	start := time.Now()
+	value, err := backend.Backend.Get(key)
+	elapsed := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.stats.Misses++
+	backend.stats.MissLatency += elapsed
+
+	// Missing keys are cached as well, metadata which was never written, such as the checkpoint, is
+	// read by most requests
+	if backend.writes == writes {
+		backend.insert(key, value)
+	}
+
+	return value, nil
+}
+
+// Has reports whether key is stored, from the cache if it holds the key. A miss is not cached, as
+// the value is not read.
+func (backend *CacheBackend) Has(key []byte) (bool, error) {
+	backend.lock.Lock()
+	if element, ok := backend.entries[string(key)]; ok {
+		backend.order.MoveToFront(element)
+		backend.stats.Hits++
+		found := len(element.Value.(*cacheEntry).value) > 0
+		backend.lock.Unlock()
+		return found, nil
+	}
+	backend.lock.Unlock()
+
+	return backend.Backend.Has(key)
+}
+
+// Iterate iterates over the wrapped database, which holds every write made through the cache. The
+// values are not cached.
+func (backend *CacheBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, fn)
+}
+
+// Snapshot takes a snapshot of the wrapped database, which is read without the cache
+func (backend *CacheBackend) Snapshot() (BackendSnapshot, error) {
+	return backend.Backend.Snapshot()
+}
+
+// Flush flushes the wrapped database, the cache only holds values which are already stored
+func (backend *CacheBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database. Reads through it bypass the cache, the values it
+// writes are cached once it is committed.
+func (backend *CacheBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheTxn{BackendTxn: txn, backend: backend, writes: make(map[string][]byte)}, nil
+}
+
+// cacheTxn updates the cache with the writes of a transaction of the wrapped database when it is
+// committed
+type cacheTxn struct {
+	BackendTxn
+	backend *CacheBackend
+
+	// writes holds the values written by the transaction, nil for deleted keys
+	writes map[string][]byte
+}
+
+func (txn *cacheTxn) Put(key []byte, value []byte) error {
+	if err := txn.BackendTxn.Put(key, value); err != nil {
+		return err
+	}
+
+	txn.writes[string(key)] = value
+	return nil
+}
+
+func (txn *cacheTxn) Delete(key []byte) error {
+	if err := txn.BackendTxn.Delete(key); err != nil {
+		return err
+	}
+
+	txn.writes[string(key)] = nil
+	return nil
+}
+
+func (txn *cacheTxn) Commit() error {
+	for key := range txn.writes {
+		txn.backend.remove([]byte(key))
+	}
+	if err := txn.BackendTxn.Commit(); err != nil {
+		return err
+	}
+
+	for key, value := range txn.writes {
+		if value != nil {
+			txn.backend.add([]byte(key), value)
+		}
+	}
+	return nil
+}
+
+func (backend *CacheBackend) add(key []byte, value []byte) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.writes++
+	backend.insert(key, value)
+}
+
+// insert caches a value, the caller must hold the lock
+func (backend *CacheBackend) insert(key []byte, value []byte) {
+	if element, ok := backend.entries[string(key)]; ok {
+		backend.removeElement(element)
+	}
+
+	entry := &cacheEntry{key: string(key), value: value}
+	backend.entries[entry.key] = backend.order.PushFront(entry)
+	backend.size += entrySize(entry)
+	backend.evict()
+}
+
+func (backend *CacheBackend) remove(key []byte) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.writes++
+	if element, ok := backend.entries[string(key)]; ok {
+		backend.removeElement(element)
+	}
+}
+
+func (backend *CacheBackend) clear() {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.writes++
+	backend.entries = make(map[string]*list.Element)
+	backend.order.Init()
+	backend.size = 0
+}
+
+// evict removes the least recently used values until the cache fits its capacity. The caller must
+// hold the lock.
+func (backend *CacheBackend) evict() {
+	for backend.size > backend.capacity && backend.order.Len() > 0 {
+		backend.removeElement(backend.order.Back())
+		backend.stats.Evictions++
+	}
+}
+
+func (backend *CacheBackend) removeElement(element *list.Element) {
+	entry := backend.order.Remove(element).(*cacheEntry)
+	delete(backend.entries, entry.key)
+	backend.size -= entrySize(entry)
+}
+
+func entrySize(entry *cacheEntry) int64 {
+	return int64(len(entry.key) + len(entry.value))
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *CacheBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *CacheBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend
+func (backend *CacheBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend and clears the cache
+func (backend *CacheBackend) Load(r io.Reader) error {
+	err := backend.Backend.Load(r)
+	backend.clear()
+	return err
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *CacheBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *CacheBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
+
+// Recompress recompresses a value of the wrapped backend. The cached value does not change.
+func (backend *CacheBackend) Recompress(key []byte) (bool, error) {
+	inner, ok := backend.Backend.(recompressBackend)
+	if !ok {
+		return false, errors.New("backend does not support compression")
+	}
+
+	return inner.Recompress(key)
+}
@@ -0,0 +1,136 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+// slowBackend delays every read, like a backend reading from disk
+type slowBackend struct {
+	BlockStoreBackend
+	delay time.Duration
+}
+
+func (backend *slowBackend) Get(key []byte) ([]byte, error) {
+	time.Sleep(backend.delay)
+	return backend.BlockStoreBackend.Get(key)
+}
+
+func TestCacheBackend(t *testing.T) {
+	inner := NewMapBackend()
+	cache := NewCacheBackend(inner, 10)
+
+	if err := cache.Put([]byte{1}, []byte{1, 1, 1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put([]byte{2}, []byte{2, 2, 2, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Size() != 10 {
+		t.Errorf("expected cache size 10, got %d", cache.Size())
+	}
+
+	// Reading key 1 makes key 2 the least recently used, so it is evicted by key 3
+	if value, err := cache.Get([]byte{1}); err != nil || !bytes.Equal(value, []byte{1, 1, 1, 1}) {
+		t.Fatalf("unexpected value %v, %v", value, err)
+	}
+	if err := cache.Put([]byte{3}, []byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.entries[string([]byte{2})]; ok {
+		t.Error("expected least recently used key to be evicted")
+	}
+
+	// Evicted values are read from the wrapped backend
+	if value, err := cache.Get([]byte{2}); err != nil || !bytes.Equal(value, []byte{2, 2, 2, 2}) {
+		t.Fatalf("unexpected value %v, %v", value, err)
+	}
+	if value, err := cache.Get([]byte{4}); err != nil || value == nil || len(value) != 0 {
+		t.Fatalf("expected empty value for missing key, got %v, %v", value, err)
+	}
+
+	stats := cache.TakeStats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Evictions != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats = cache.TakeStats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("expected stats to be reset, got %+v", stats)
+	}
+
+	if err := cache.Delete([]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := cache.Get([]byte{2}); len(value) != 0 {
+		t.Error("expected deleted key to be missing")
+	}
+
+	cache.SetCapacity(0)
+	if cache.Size() != 0 {
+		t.Errorf("expected shrinking to evict all values, size is %d", cache.Size())
+	}
+
+	if err := cache.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := inner.Get([]byte{1}); len(value) != 0 {
+		t.Error("expected reset to reset the wrapped backend")
+	}
+
+	// The request handler works on top of the cache
+	cache.SetCapacity(1 << 20)
+	handler := RequestHandler{Backend: cache}
+	bt := buildLinearChain(t, &handler, 10)
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 1}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.Writable {
+		t.Errorf("unexpected health %+v, %v", health, err)
+	}
+	if _, err = handler.CompactStore(&CompactStoreRequest{}); err != nil {
+		t.Errorf("expected compaction to be forwarded, got %v", err)
+	}
+}
+
+func TestCacheBackendForkTraffic(t *testing.T) {
+	inner := &countingBackend{BlockStoreBackend: NewMapBackend(), reads: make(map[string]int)}
+	handler := RequestHandler{Backend: NewCacheBackend(inner, 1<<20)}
+
+	main := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, 64, 0)
+	forkA := makeForkFrom(main[39], 8, 1)
+	forkB := makeForkFrom(main[39], 8, 2)
+	for _, chain := range [][]*protocol.Block{main, forkA, forkB} {
+		for _, block := range chain {
+			if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Requests from competing heads share their ancestors, after the first round every record is cached
+	for round := 0; round < 2; round++ {
+		inner.reads = make(map[string]int)
+		for _, head := range []*protocol.Block{main[47], forkA[7], forkB[7]} {
+			_, err := handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{
+				HeadBlockId:         head.GetId(),
+				AncestorStartHeight: 1,
+				NumBlocks:           48,
+				ReturnBlock:         true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if round > 0 && len(inner.reads) > 0 {
+			t.Errorf("expected ancestors to be served from the cache, read %d keys", len(inner.reads))
+		}
+	}
+}
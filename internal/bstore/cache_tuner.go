@@ -0,0 +1,165 @@
+package bstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+const (
+	// DefaultCacheTuneInterval is the default interval between cache size adjustments
+	DefaultCacheTuneInterval = time.Minute
+
+	// DefaultSlowReadLatency is the default backend read latency above which a read is assumed to
+	// have gone to disk
+	DefaultSlowReadLatency = 500 * time.Microsecond
+
+	cacheGrowFactor   = 1.25
+	cacheShrinkFactor = 0.8
+
+	// minCacheTuneReads is the number of reads in an interval below which the cache is not resized
+	minCacheTuneReads = 100
+)
+
+// TunableCache is a cache bounded in bytes which CacheTuner resizes
+type TunableCache interface {
+	Capacity() int64
+	SetCapacity(capacity int64)
+	Size() int64
+
+	// TakeStats returns the statistics counted since the previous call and resets them
+	TakeStats() CacheStats
+}
+
+// CacheStats are the cache hits and misses counted since the previous call to TakeStats
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// MissLatency is the total time spent reading the wrapped backend on misses
+	MissLatency time.Duration
+}
+
+// CacheTuner periodically resizes a cache between MinSize and MaxSize from its hit rate and the
+// latency of the backend reads on misses. The cache grows while it evicts values and misses are slow,
+// and shrinks while misses are fast enough that the backend is serving them from its own caches.
+type CacheTuner struct {
+	Cache    TunableCache
+	MinSize  int64
+	MaxSize  int64
+	Interval time.Duration
+
+	// SlowReadLatency is the average miss latency above which the cache grows, 0 uses
+	// DefaultSlowReadLatency. The cache shrinks below half of it.
+	SlowReadLatency time.Duration
+
+	lock        sync.Mutex
+	hitRate     float64
+	missLatency time.Duration
+}
+
+// CacheTunerStatus is the status reported by a CacheTuner
+type CacheTunerStatus struct {
+	Capacity int64 `json:"capacity"`
+	Size     int64 `json:"size"`
+
+	// HitRate is the hit rate of the last tuning interval
+	HitRate float64 `json:"hit_rate"`
+
+	// MissLatencyMicros is the average latency of the backend reads on misses in the last tuning
+	// interval in microseconds
+	MissLatencyMicros int64 `json:"miss_latency_us"`
+}
+
+// Start tunes the cache every Interval until ctx is done
+func (t *CacheTuner) Start(ctx context.Context) {
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultCacheTuneInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.Tune()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Tune resizes the cache from the statistics since the previous call, returning its new capacity
+func (t *CacheTuner) Tune() int64 {
+	stats := t.Cache.TakeStats()
+	capacity := t.Cache.Capacity()
+
+	slow := t.SlowReadLatency
+	if slow <= 0 {
+		slow = DefaultSlowReadLatency
+	}
+
+	var hitRate float64
+	var missLatency time.Duration
+	if reads := stats.Hits + stats.Misses; reads > 0 {
+		hitRate = float64(stats.Hits) / float64(reads)
+	}
+	if stats.Misses > 0 {
+		missLatency = stats.MissLatency / time.Duration(stats.Misses)
+	}
+
+	target := capacity
+	if stats.Hits+stats.Misses >= minCacheTuneReads {
+		switch {
+		case stats.Evictions > 0 && missLatency >= slow:
+			target = int64(float64(capacity) * cacheGrowFactor)
+		case stats.Misses > 0 && missLatency < slow/2:
+			target = int64(float64(capacity) * cacheShrinkFactor)
+		}
+	}
+
+	if target > t.MaxSize {
+		target = t.MaxSize
+	}
+	if target < t.MinSize {
+		target = t.MinSize
+	}
+
+	if target != capacity {
+		log.Debugf("Resizing cache from %d to %d KiB, hit rate %.2f, miss latency %s", capacity>>10, target>>10, hitRate, missLatency)
+		t.Cache.SetCapacity(target)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.hitRate = hitRate
+	t.missLatency = missLatency
+
+	return target
+}
+
+// StatusName implements StatusReporter
+func (t *CacheTuner) StatusName() string {
+	return "cache"
+}
+
+// Status implements StatusReporter
+func (t *CacheTuner) Status() interface{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return &CacheTunerStatus{
+		Capacity:          t.Cache.Capacity(),
+		Size:              t.Cache.Size(),
+		HitRate:           t.hitRate,
+		MissLatencyMicros: t.missLatency.Microseconds(),
+	}
+}
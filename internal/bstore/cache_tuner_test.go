@@ -0,0 +1,74 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// tunedCache is a TunableCache which reports the statistics set by the test
+type tunedCache struct {
+	capacity int64
+	stats    CacheStats
+}
+
+func (c *tunedCache) Capacity() int64 {
+	return c.capacity
+}
+
+func (c *tunedCache) SetCapacity(capacity int64) {
+	c.capacity = capacity
+}
+
+func (c *tunedCache) Size() int64 {
+	return c.capacity
+}
+
+func (c *tunedCache) TakeStats() CacheStats {
+	stats := c.stats
+	c.stats = CacheStats{}
+	return stats
+}
+
+func TestCacheTuner(t *testing.T) {
+	cache := &tunedCache{capacity: 1000}
+	tuner := &CacheTuner{Cache: cache, MinSize: 1000, MaxSize: 1500}
+
+	slowMisses := CacheStats{Hits: 50, Misses: 150, Evictions: 100, MissLatency: 150 * time.Millisecond}
+	fastMisses := CacheStats{Hits: 50, Misses: 150, MissLatency: 150 * time.Microsecond}
+
+	// Slow misses and evictions grow the cache, up to MaxSize
+	cache.stats = slowMisses
+	if capacity := tuner.Tune(); capacity != 1250 {
+		t.Errorf("expected cache to grow to 1250, got %d", capacity)
+	}
+	cache.stats = slowMisses
+	if capacity := tuner.Tune(); capacity != 1500 {
+		t.Errorf("expected cache to grow to 1500, got %d", capacity)
+	}
+
+	// Too few reads leave the cache alone
+	cache.stats = CacheStats{Misses: 10, Evictions: 10, MissLatency: 10 * time.Millisecond}
+	if capacity := tuner.Tune(); capacity != 1500 {
+		t.Errorf("expected cache to stay at 1500, got %d", capacity)
+	}
+
+	// Fast misses shrink the cache, down to MinSize
+	cache.stats = fastMisses
+	if capacity := tuner.Tune(); capacity != 1200 {
+		t.Errorf("expected cache to shrink to 1200, got %d", capacity)
+	}
+	cache.stats = fastMisses
+	if capacity := tuner.Tune(); capacity != 1000 {
+		t.Errorf("expected cache to shrink to 1000, got %d", capacity)
+	}
+
+	data, err := json.Marshal(tuner.Status())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"capacity":1000`)) || !bytes.Contains(data, []byte(`"hit_rate":0.25`)) || !bytes.Contains(data, []byte(`"miss_latency_us":1`)) {
+		t.Errorf("unexpected status %s", string(data))
+	}
+}
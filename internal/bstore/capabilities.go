@@ -0,0 +1,109 @@
+package bstore
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	// DefaultMaxMessageSize is the default maximum size of a response message in bytes
+	DefaultMaxMessageSize = 536870912
+
+	// DefaultMaxBlocksByHeight is the default maximum number of blocks per request by height
+	DefaultMaxBlocksByHeight = 1000
+
+	// DefaultMaxBlocksByID is the default maximum number of blocks per request by ID
+	DefaultMaxBlocksByID = 1000
+)
+
+// GetCapabilitiesRequest asks the block store which extended requests and limits it supports
+type GetCapabilitiesRequest struct {
+}
+
+// GetCapabilitiesResponse describes the capabilities of the block store
+type GetCapabilitiesResponse struct {
+	// MaxMessageSize is the maximum size of a response message in bytes. Larger responses are
+	// replaced with an error.
+	MaxMessageSize int `json:"max_message_size"`
+
+	// MaxBlocksByHeight and MaxBlocksByID are the maximum number of blocks per request. Larger requests
+	// fail with the limit_exceeded error code.
+	MaxBlocksByHeight uint64 `json:"max_blocks_by_height"`
+	MaxBlocksByID     uint64 `json:"max_blocks_by_id"`
+
+	// RateLimit is the number of requests per second served to each client, 0 if they are not
+	// limited, and RateLimitBurst the number served at once. Further requests fail with the
+	// rate_limited error code.
+	RateLimit      float64 `json:"rate_limit"`
+	RateLimitBurst int     `json:"rate_limit_burst"`
+
+	// RequestTimeoutMS is the time in milliseconds after which a query fails with the timeout error
+	// code, 0 if queries are not bounded
+	RequestTimeoutMS int64 `json:"request_timeout_ms"`
+
+	SchemaVersion string `json:"schema_version"`
+
+	// ReadOnly is set if the block store refuses to add blocks
+	ReadOnly bool `json:"read_only"`
+
+	// ExtendedRequests are the request names supported on the extended RPC
+	ExtendedRequests []string `json:"extended_requests"`
+
+	// AdminRequests are the request names supported within the admin extended request
+	AdminRequests []string `json:"admin_requests"`
+}
+
+// GetCapabilities returns the capabilities of the block store
+func (handler *RequestHandler) GetCapabilities(req *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error) {
+	rate, burst := handler.rateLimit()
+	return &GetCapabilitiesResponse{
+		MaxMessageSize:    handler.MaxResponseSize(),
+		MaxBlocksByHeight: handler.maxBlocksByHeight(),
+		MaxBlocksByID:     handler.maxBlocksByID(),
+		RateLimit:         rate,
+		RateLimitBurst:    burst,
+		RequestTimeoutMS:  handler.settings().RequestTimeout.Milliseconds(),
+		SchemaVersion:     SchemaVersion,
+		ReadOnly:          handler.ReadOnly,
+		ExtendedRequests:  requestNames(reflect.TypeOf(ExtendedRequest{})),
+		AdminRequests:     AdminRequestNames(),
+	}, nil
+}
+
+// MaxResponseSize returns the maximum size of a response message in bytes, larger responses must be
+// replaced with a MessageTooLargeError
+func (handler *RequestHandler) MaxResponseSize() int {
+	if max := handler.settings().MaxMessageSize; max > 0 {
+		return max
+	}
+
+	return DefaultMaxMessageSize
+}
+
+func (handler *RequestHandler) maxBlocksByHeight() uint64 {
+	if max := handler.settings().MaxBlocksByHeight; max > 0 {
+		return max
+	}
+
+	return DefaultMaxBlocksByHeight
+}
+
+func (handler *RequestHandler) maxBlocksByID() uint64 {
+	if max := handler.settings().MaxBlocksByID; max > 0 {
+		return max
+	}
+
+	return DefaultMaxBlocksByID
+}
+
+// requestNames returns the JSON names of the request fields of a request envelope
+func requestNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Ptr {
+			names = append(names, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+		}
+	}
+
+	return names
+}
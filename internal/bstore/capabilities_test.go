@@ -0,0 +1,78 @@
+package bstore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestGetCapabilities(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetCapabilities: &GetCapabilitiesRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.GetCapabilities.MaxMessageSize != DefaultMaxMessageSize {
+		t.Errorf("expected default max message size, got %d", resp.GetCapabilities.MaxMessageSize)
+	}
+
+	found := false
+	for _, name := range resp.GetCapabilities.ExtendedRequests {
+		found = found || name == "verify_chain_links"
+	}
+	if !found || len(resp.GetCapabilities.ExtendedRequests) != reflect.TypeOf(ExtendedRequest{}).NumField() {
+		t.Errorf("unexpected extended requests %v", resp.GetCapabilities.ExtendedRequests)
+	}
+
+	handler.MaxMessageSize = 1024 * 1024
+	caps, _ := handler.GetCapabilities(&GetCapabilitiesRequest{})
+	if caps.MaxMessageSize != 1024*1024 {
+		t.Errorf("expected configured max message size, got %d", caps.MaxMessageSize)
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), MaxBlocksByHeight: 10, MaxBlocksByID: 2}
+	bt := buildLinearChain(t, &handler, 20)
+	head := bt.ByNum[120].GetId()
+
+	resp := handler.HandleRequest(&block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+			GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{HeadBlockId: head, AncestorStartHeight: 1, NumBlocks: 11},
+		},
+	})
+	errval, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error)
+	if !ok {
+		t.Fatal("expected error response")
+	}
+	details := ErrorStatusDetails(errval.Error)
+	if ErrorCodeOfStatus(errval.Error) != ErrorCodeLimitExceeded || details["limit"] != float64(10) || details["requested"] != float64(11) {
+		t.Errorf("unexpected error details %v", details)
+	}
+
+	if _, err := handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{HeadBlockId: head, AncestorStartHeight: 1, NumBlocks: 10}); err != nil {
+		t.Error(err)
+	}
+
+	ids := [][]byte{head, head, head}
+	if _, err := handler.GetBlocksByID(context.Background(), &block_store.GetBlocksByIdRequest{BlockIds: ids}); ErrorCodeOf(err) != ErrorCodeLimitExceeded {
+		t.Errorf("expected limit exceeded, got %v", err)
+	}
+
+	// Paged requests use the limit as the page size
+	paged, err := handler.GetBlocksByIDPaged(context.Background(), &GetBlocksByIDPagedRequest{BlockIDs: []HexBytes{head, head, head}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paged.BlockItems) != 2 || len(paged.ContinuationToken) == 0 {
+		t.Errorf("expected a page of 2 blocks, got %d", len(paged.BlockItems))
+	}
+
+	caps, _ := handler.GetCapabilities(&GetCapabilitiesRequest{})
+	if caps.MaxBlocksByHeight != 10 || caps.MaxBlocksByID != 2 {
+		t.Errorf("unexpected limits %+v", caps)
+	}
+}
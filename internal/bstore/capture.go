@@ -0,0 +1,238 @@
+package bstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	captureFilePrefix = "capture-"
+	captureFileSuffix = ".jsonl"
+
+	maxCaptureRecordSize = 1024 * 1024 * 1024
+)
+
+// CaptureKind identifies the kind of a captured message
+type CaptureKind string
+
+// Capture kinds
+const (
+	CaptureRequest   CaptureKind = "request"
+	CaptureResponse  CaptureKind = "response"
+	CaptureBroadcast CaptureKind = "broadcast"
+)
+
+// CaptureRecord is a single captured message. A response has the same Seq as its request.
+type CaptureRecord struct {
+	Seq     uint64      `json:"seq"`
+	Time    time.Time   `json:"time"`
+	Source  string      `json:"source"`
+	Kind    CaptureKind `json:"kind"`
+	Payload []byte      `json:"payload"`
+}
+
+// PayloadCapture records raw messages to rotating JSON lines files in a directory. Requests are written
+// before they are handled, so a request which crashes the service is still captured.
+type PayloadCapture struct {
+	Dir string
+
+	// MaxFileSize is the size at which a new capture file is started
+	MaxFileSize int64
+
+	// MaxFiles is the number of capture files kept, 0 keeps all files
+	MaxFiles int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+	seq  uint64
+}
+
+// NewPayloadCapture creates a payload capture writing to dir, creating it if necessary
+func NewPayloadCapture(dir string, maxFileSize int64, maxFiles int) (*PayloadCapture, error) {
+	if maxFileSize <= 0 {
+		return nil, fmt.Errorf("capture file size must be greater than 0")
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &PayloadCapture{Dir: dir, MaxFileSize: maxFileSize, MaxFiles: maxFiles}, nil
+}
+
+// CaptureRequest records a request received on the RPC source, returning the sequence number with
+// which its response should be captured
+func (c *PayloadCapture) CaptureRequest(source string, data []byte) (uint64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.seq++
+	return c.seq, c.write(&CaptureRecord{Seq: c.seq, Source: source, Kind: CaptureRequest, Payload: data})
+}
+
+// CaptureResponse records the response to the request with the given sequence number
+func (c *PayloadCapture) CaptureResponse(seq uint64, source string, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.write(&CaptureRecord{Seq: seq, Source: source, Kind: CaptureResponse, Payload: data})
+}
+
+// CaptureBroadcast records a broadcast received on the topic
+func (c *PayloadCapture) CaptureBroadcast(topic string, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.seq++
+	return c.write(&CaptureRecord{Seq: c.seq, Source: topic, Kind: CaptureBroadcast, Payload: data})
+}
+
+// Close closes the current capture file
+func (c *PayloadCapture) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+func (c *PayloadCapture) write(record *CaptureRecord) error {
+	record.Time = time.Now().UTC()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if c.file == nil || c.size+int64(len(line)) > c.MaxFileSize {
+		if err = c.rotate(); err != nil {
+			return err
+		}
+	}
+
+	// Records are written unbuffered so they survive a crash of the service
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+func (c *PayloadCapture) rotate() error {
+	if c.file != nil {
+		if err := c.file.Close(); err != nil {
+			return err
+		}
+		c.file = nil
+	}
+
+	name := fmt.Sprintf("%s%s-%d%s", captureFilePrefix, time.Now().UTC().Format("20060102T150405Z"), c.seq, captureFileSuffix)
+	file, err := os.OpenFile(filepath.Join(c.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	c.file = file
+	c.size = 0
+
+	if c.MaxFiles <= 0 {
+		return nil
+	}
+
+	files, err := CaptureFiles(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	for len(files) > c.MaxFiles {
+		if err = os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+
+	return nil
+}
+
+// CaptureFiles returns the capture files in dir, oldest first
+func CaptureFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), captureFilePrefix) && strings.HasSuffix(entry.Name(), captureFileSuffix) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	// The sequence number suffix orders files created within the same second
+	sort.Slice(files, func(i, j int) bool {
+		ti, si := captureFileOrder(files[i])
+		tj, sj := captureFileOrder(files[j])
+		if ti != tj {
+			return ti < tj
+		}
+		return si < sj
+	})
+
+	return files, nil
+}
+
+func captureFileOrder(path string) (string, uint64) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), captureFilePrefix), captureFileSuffix)
+
+	var seq uint64
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return name, 0
+	}
+	_, _ = fmt.Sscanf(name[i+1:], "%d", &seq)
+	return name[:i], seq
+}
+
+// ReadCaptureFile calls fn for each record in a capture file, in the order they were written
+func ReadCaptureFile(path string, fn func(*CaptureRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxCaptureRecordSize)
+
+	var malformed error
+	for scanner.Scan() {
+		if malformed != nil {
+			return malformed
+		}
+
+		record := &CaptureRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), record); err != nil {
+			malformed = fmt.Errorf("malformed capture record in %s, %w", path, err)
+			continue
+		}
+
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+
+	// A crash may leave a partially written last line, which is skipped
+	return scanner.Err()
+}
@@ -0,0 +1,99 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestPayloadCapture(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := NewPayloadCapture(dir, 1024, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seq, err := capture.CaptureRequest("block_store", []byte{0x01, 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = capture.CaptureBroadcast("koinos.block.accept", []byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if err = capture.CaptureResponse(seq, "block_store", []byte{0x04}); err != nil {
+		t.Fatal(err)
+	}
+	if err = capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := CaptureFiles(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected a single capture file, got %v", files)
+	}
+
+	var records []*CaptureRecord
+	err = ReadCaptureFile(files[0], func(record *CaptureRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	if records[0].Kind != CaptureRequest || records[2].Kind != CaptureResponse || records[0].Seq != records[2].Seq {
+		t.Error("expected response to share the sequence number of its request")
+	}
+	if records[1].Kind != CaptureBroadcast || records[1].Seq == records[0].Seq {
+		t.Error("expected broadcast with its own sequence number")
+	}
+	if !bytes.Equal(records[0].Payload, []byte{0x01, 0x02}) || records[0].Source != "block_store" {
+		t.Error("unexpected captured request")
+	}
+
+	// A partially written last line is skipped
+	f, _ := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString(`{"seq":4,"kin`)
+	f.Close()
+
+	count := 0
+	if err = ReadCaptureFile(files[0], func(*CaptureRecord) error { count++; return nil }); err != nil || count != 3 {
+		t.Errorf("expected truncated record to be skipped, got %d records, %v", count, err)
+	}
+}
+
+func TestPayloadCaptureRotation(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := NewPayloadCapture(dir, 256, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err = capture.CaptureRequest("block_store", bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = capture.Close()
+
+	files, err := CaptureFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 capture files to be kept, found %d", len(files))
+	}
+
+	// The newest records are kept
+	var last *CaptureRecord
+	_ = ReadCaptureFile(files[len(files)-1], func(record *CaptureRecord) error {
+		last = record
+		return nil
+	})
+	if last == nil || last.Seq != 20 {
+		t.Error("expected the last capture file to contain the newest record")
+	}
+}
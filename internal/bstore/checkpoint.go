@@ -0,0 +1,298 @@
+package bstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+)
+
+// Checkpoint is a trusted block from which an empty store can be bootstrapped without the preceding history
+type Checkpoint struct {
+	BlockID HexBytes `json:"block_id"`
+	Height  uint64   `json:"height"`
+
+	// Header is the serialized block header
+	Header HexBytes `json:"header"`
+}
+
+// ParseCheckpoint parses and validates a JSON encoded checkpoint
+func ParseCheckpoint(data []byte) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+
+	if err := checkpoint.Validate(); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// Validate checks that the header is consistent with the checkpoint's block ID and height
+func (c *Checkpoint) Validate() error {
+	if c.Height == 0 {
+		return errors.New("checkpoint height must be greater than 0")
+	}
+
+	header := &protocol.BlockHeader{}
+	if err := proto.Unmarshal(c.Header, header); err != nil {
+		return fmt.Errorf("could not deserialize checkpoint header, %w", err)
+	}
+
+	if header.GetHeight() != c.Height {
+		return fmt.Errorf("checkpoint header has height %d, expected %d", header.GetHeight(), c.Height)
+	}
+
+	decoded, err := multihash.Decode(c.BlockID)
+	if err != nil {
+		return fmt.Errorf("checkpoint block ID is not a valid multihash, %w", err)
+	}
+
+	if decoded.Code != multihash.SHA2_256 {
+		return errors.New("checkpoint block ID must be a sha2-256 multihash")
+	}
+
+	digest := sha256.Sum256(c.Header)
+	if !bytes.Equal(decoded.Digest, digest[:]) {
+		return errors.New("checkpoint header does not match block ID")
+	}
+
+	return nil
+}
+
+// Topology returns the block topology of the checkpoint
+func (c *Checkpoint) Topology() (*koinos.BlockTopology, error) {
+	header := &protocol.BlockHeader{}
+	if err := proto.Unmarshal(c.Header, header); err != nil {
+		return nil, err
+	}
+
+	return &koinos.BlockTopology{Id: c.BlockID, Height: c.Height, Previous: header.GetPrevious()}, nil
+}
+
+// Digest returns the hash signed by a checkpoint signature
+func (c *Checkpoint) Digest() []byte {
+	height := make([]byte, 8)
+	binary.BigEndian.PutUint64(height, c.Height)
+
+	hash := sha256.New()
+	hash.Write(c.BlockID)
+	hash.Write(height)
+	hash.Write(c.Header)
+	return hash.Sum(nil)
+}
+
+// SignedCheckpoint is a checkpoint signed by the node which published it
+type SignedCheckpoint struct {
+	Checkpoint
+
+	// Signature is a compact, recoverable secp256k1 signature of the checkpoint digest
+	Signature HexBytes `json:"signature"`
+}
+
+// SignCheckpoint signs the checkpoint with the given private key
+func SignCheckpoint(checkpoint *Checkpoint, privateKey []byte) (*SignedCheckpoint, error) {
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), privateKey)
+
+	signature, err := btcec.SignCompact(btcec.S256(), key, checkpoint.Digest(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedCheckpoint{Checkpoint: *checkpoint, Signature: signature}, nil
+}
+
+// ParseSignedCheckpoint parses and validates a JSON encoded signed checkpoint
+func ParseSignedCheckpoint(data []byte) (*SignedCheckpoint, error) {
+	checkpoint := &SignedCheckpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+
+	if err := checkpoint.Validate(); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// Signer recovers the address of the key which signed the checkpoint
+func (s *SignedCheckpoint) Signer() ([]byte, error) {
+	if len(s.Signature) == 0 {
+		return nil, errors.New("checkpoint is not signed")
+	}
+
+	pubKey, _, err := btcec.RecoverCompact(btcec.S256(), s.Signature, s.Digest())
+	if err != nil {
+		return nil, fmt.Errorf("could not recover checkpoint signer, %w", err)
+	}
+
+	address, err := btcutil.NewAddressPubKey(pubKey.SerializeCompressed(), &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return base58.Decode(address.EncodeAddress()), nil
+}
+
+// VerifySigner checks that the checkpoint was signed by the given base58 encoded address
+func (s *SignedCheckpoint) VerifySigner(address string) error {
+	signer, err := s.Signer()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(signer, base58.Decode(address)) {
+		return fmt.Errorf("checkpoint was signed by %s, expected %s", base58.Encode(signer), address)
+	}
+
+	return nil
+}
+
+// CheckpointAt creates a checkpoint of a stored block
+func (handler *RequestHandler) CheckpointAt(blockID []byte) (*Checkpoint, error) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+
+	record, err := handler.getRecord(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := proto.Marshal(record.GetBlock().GetHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{BlockID: record.GetBlockId(), Height: record.GetBlockHeight(), Header: header}
+	if err = checkpoint.Validate(); err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+func (handler *RequestHandler) getCheckpoint() (*Checkpoint, error) {
+	value, err := handler.Backend.Get([]byte{checkpointKey})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	checkpoint := &Checkpoint{}
+	if err = json.Unmarshal(value, checkpoint); err != nil {
+		return nil, errors.New("stored checkpoint is corrupted")
+	}
+
+	return checkpoint, nil
+}
+
+// BootstrapFromCheckpoint initializes an empty store from a trusted checkpoint. Blocks may then be added
+// on top of the checkpoint, while requests for blocks below it return BelowCheckpoint.
+//
+// Bootstrapping again from the same checkpoint is a no-op.
+func (handler *RequestHandler) BootstrapFromCheckpoint(checkpoint *Checkpoint) error {
+	if err := checkpoint.Validate(); err != nil {
+		return err
+	}
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	existing, err := handler.getCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		if bytes.Equal(existing.BlockID, checkpoint.BlockID) {
+			return nil
+		}
+		return errors.New("store was bootstrapped from a different checkpoint")
+	}
+
+	highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+	if err == nil && highest.GetTopology().GetHeight() > 0 {
+		return errors.New("cannot bootstrap a store which already contains blocks")
+	}
+
+	topology, err := checkpoint.Topology()
+	if err != nil {
+		return err
+	}
+
+	header := &protocol.BlockHeader{}
+	if err = proto.Unmarshal(checkpoint.Header, header); err != nil {
+		return err
+	}
+
+	record := block_store.BlockRecord{
+		BlockId:          checkpoint.BlockID,
+		BlockHeight:      checkpoint.Height,
+		Block:            &protocol.Block{Id: checkpoint.BlockID, Header: header},
+		PreviousBlockIds: checkpointPreviousBlockIds(checkpoint.Height, header.GetPrevious()),
+	}
+
+	recordBytes, err := proto.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	checkpointBytes, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	batch := NewBatchBackend(handler.Backend)
+	if err = batch.Put(blockRecordKey(record.GetBlockId()), recordBytes); err != nil {
+		return err
+	}
+
+	if err = batch.Put([]byte{checkpointKey}, checkpointBytes); err != nil {
+		return err
+	}
+
+	advanced, err := updateHighestBlock(batch, topology)
+	if err != nil {
+		return err
+	}
+
+	if err = batch.Commit(); err != nil {
+		return err
+	}
+
+	if advanced {
+		handler.markHeadAdvanced()
+	}
+	return nil
+}
+
+// checkpointPreviousBlockIds returns the skip links of the checkpoint block. Only the link to the
+// previous block is known, the others are left empty.
+func checkpointPreviousBlockIds(height uint64, previous []byte) [][]byte {
+	numLinks := len(getPreviousHeights(height))
+	links := make([][]byte, numLinks)
+	links[0] = previous
+	for i := 1; i < numLinks; i++ {
+		links[i] = []byte{}
+	}
+
+	return links
+}
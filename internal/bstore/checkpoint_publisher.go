@@ -0,0 +1,164 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+)
+
+const (
+	latestCheckpointName = "checkpoint-latest.json"
+	checkpointPutTimeout = 30 * time.Second
+)
+
+// CheckpointPublisher writes signed checkpoints of irreversible blocks every Interval blocks to a
+// directory and/or uploads them to a URL
+type CheckpointPublisher struct {
+	Handler  *RequestHandler
+	Interval uint64
+
+	// PrivateKey signs the published checkpoints
+	PrivateKey []byte
+
+	// Dir, if set, is the directory checkpoints are written to
+	Dir string
+
+	// URL, if set, is the base URL checkpoints are uploaded to with HTTP PUT
+	URL string
+
+	lock       sync.Mutex
+	lastHeight uint64
+	lastError  string
+}
+
+// CheckpointPublisherStatus is the status reported by a CheckpointPublisher
+type CheckpointPublisherStatus struct {
+	Interval uint64 `json:"interval"`
+
+	// LastHeight is the height of the last checkpoint published successfully
+	LastHeight uint64 `json:"last_height"`
+
+	// LastError is the error of the last publication, if it failed
+	LastError string `json:"last_error,omitempty"`
+}
+
+// HandleIrreversible publishes a checkpoint if the irreversible block falls on the publication interval
+func (p *CheckpointPublisher) HandleIrreversible(topology *koinos.BlockTopology) error {
+	if p.Interval == 0 || topology.GetHeight() == 0 || topology.GetHeight()%p.Interval != 0 {
+		return nil
+	}
+
+	err := p.publish(topology)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil {
+		p.lastError = err.Error()
+	} else {
+		p.lastHeight = topology.GetHeight()
+		p.lastError = ""
+	}
+
+	return err
+}
+
+// StatusName implements StatusReporter
+func (p *CheckpointPublisher) StatusName() string {
+	return "checkpoint_publisher"
+}
+
+// Status implements StatusReporter
+func (p *CheckpointPublisher) Status() interface{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return &CheckpointPublisherStatus{Interval: p.Interval, LastHeight: p.lastHeight, LastError: p.lastError}
+}
+
+func (p *CheckpointPublisher) publish(topology *koinos.BlockTopology) error {
+	checkpoint, err := p.Handler.CheckpointAt(topology.GetId())
+	if err != nil {
+		return err
+	}
+
+	signed, err := SignCheckpoint(checkpoint, p.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	name := fmt.Sprintf("checkpoint-%d.json", checkpoint.Height)
+
+	if len(p.Dir) > 0 {
+		if err = writeFileAtomic(filepath.Join(p.Dir, name), data); err != nil {
+			return err
+		}
+		if err = writeFileAtomic(filepath.Join(p.Dir, latestCheckpointName), data); err != nil {
+			return err
+		}
+	}
+
+	if len(p.URL) > 0 {
+		if err = putCheckpoint(strings.TrimSuffix(p.URL, "/")+"/"+name, data); err != nil {
+			return err
+		}
+		if err = putCheckpoint(strings.TrimSuffix(p.URL, "/")+"/"+latestCheckpointName, data); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Published checkpoint - Height: %d, ID: 0x%s", checkpoint.Height, hex.EncodeToString(checkpoint.BlockID))
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func putCheckpoint(url string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: checkpointPutTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("checkpoint upload to %s failed with status %s", url, resp.Status)
+	}
+
+	return nil
+}
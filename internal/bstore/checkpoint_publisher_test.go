@@ -0,0 +1,140 @@
+package bstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func newCheckpointKey(t *testing.T) ([]byte, string) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := btcutil.NewAddressPubKey(key.PubKey().SerializeCompressed(), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.Serialize(), address.EncodeAddress()
+}
+
+func TestSignedCheckpoint(t *testing.T) {
+	key, address := newCheckpointKey(t)
+	_, otherAddress := newCheckpointKey(t)
+
+	blocks := makeChainFrom(GetNonExistentBlockID(99), 500, 1)
+	signed, err := SignCheckpoint(makeCheckpoint(blocks[0]), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(signed)
+	parsed, err := ParseSignedCheckpoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = parsed.VerifySigner(address); err != nil {
+		t.Error(err)
+	}
+
+	if err = parsed.VerifySigner(otherAddress); err == nil {
+		t.Error("expected signature from a different key to be rejected")
+	}
+
+	// Signed checkpoints remain readable as plain checkpoints
+	if _, err = ParseCheckpoint(data); err != nil {
+		t.Error(err)
+	}
+
+	parsed.Height++
+	if err = parsed.VerifySigner(address); err == nil {
+		t.Error("expected tampered checkpoint to be rejected")
+	}
+}
+
+func TestCheckpointPublisher(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+		key, address := newCheckpointKey(t)
+		dir := t.TempDir()
+
+		blocks := makeChainFrom(GetNonExistentBlockID(99), 1000, 11)
+		if err := handler.BootstrapFromCheckpoint(makeCheckpoint(blocks[0])); err != nil {
+			t.Fatal(err)
+		}
+		for _, block := range blocks[1:] {
+			if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		publisher := CheckpointPublisher{Handler: &handler, Interval: 5, PrivateKey: key, Dir: dir}
+
+		for _, block := range blocks {
+			topology := &koinos.BlockTopology{Id: block.GetId(), Height: block.GetHeader().GetHeight(), Previous: block.GetHeader().GetPrevious()}
+			if err := handler.UpdateIrreversibleBlock(topology); err != nil {
+				t.Fatal(err)
+			}
+			if err := publisher.HandleIrreversible(topology); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 1000, 1005, 1010 and latest
+		if len(entries) != 4 {
+			t.Errorf("expected 4 checkpoint files, found %d", len(entries))
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, latestCheckpointName))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkpoint, err := ParseSignedCheckpoint(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint.Height != 1010 {
+			t.Errorf("expected latest checkpoint at height 1010, was %d", checkpoint.Height)
+		}
+		if err = checkpoint.VerifySigner(address); err != nil {
+			t.Error(err)
+		}
+
+		status := publisher.Status().(*CheckpointPublisherStatus)
+		if status.LastHeight != 1010 || len(status.LastError) > 0 {
+			t.Errorf("unexpected publisher status %+v", status)
+		}
+
+		// A published checkpoint bootstraps a fresh store
+		fresh := RequestHandler{Backend: NewBackend(bType)}
+		if err = fresh.BootstrapFromCheckpoint(&checkpoint.Checkpoint); err != nil {
+			t.Error(err)
+		}
+
+		// The irreversible block never moves backwards
+		_ = handler.UpdateIrreversibleBlock(&koinos.BlockTopology{Id: blocks[0].GetId(), Height: 1000})
+		irreversible, err := handler.getIrreversibleBlock()
+		if err != nil || irreversible.GetHeight() != 1010 {
+			t.Error("expected irreversible block to remain at height 1010")
+		}
+
+		CloseBackend(fresh.Backend)
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,136 @@
+package bstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+)
+
+// makeChainFrom creates count blocks with valid sha2-256 IDs on top of the given previous block
+func makeChainFrom(previous []byte, height uint64, count int) []*protocol.Block {
+	blocks := make([]*protocol.Block, count)
+	for i := 0; i < count; i++ {
+		header := &protocol.BlockHeader{Previous: previous, Height: height + uint64(i), Timestamp: height + uint64(i)}
+		headerBytes, _ := proto.Marshal(header)
+		digest := sha256.Sum256(headerBytes)
+		id, _ := multihash.Encode(digest[:], multihash.SHA2_256)
+
+		blocks[i] = &protocol.Block{Id: id, Header: header}
+		previous = id
+	}
+
+	return blocks
+}
+
+func makeCheckpoint(block *protocol.Block) *Checkpoint {
+	headerBytes, _ := proto.Marshal(block.GetHeader())
+	return &Checkpoint{BlockID: block.GetId(), Height: block.GetHeader().GetHeight(), Header: headerBytes}
+}
+
+func TestCheckpointBootstrap(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		blocks := makeChainFrom(GetNonExistentBlockID(99), 1000, 40)
+		checkpoint := makeCheckpoint(blocks[0])
+
+		data, _ := json.Marshal(checkpoint)
+		parsed, err := ParseCheckpoint(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = handler.BootstrapFromCheckpoint(parsed); err != nil {
+			t.Fatal(err)
+		}
+
+		// Bootstrapping from the same checkpoint is idempotent
+		if err = handler.BootstrapFromCheckpoint(parsed); err != nil {
+			t.Error(err)
+		}
+
+		highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+		if err != nil || highest.GetTopology().GetHeight() != 1000 {
+			t.Fatal("expected highest block at checkpoint height")
+		}
+
+		for _, block := range blocks[1:] {
+			if _, err = handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		head := blocks[len(blocks)-1].GetId()
+		resp, err := handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{
+			HeadBlockId:         head,
+			AncestorStartHeight: 1000,
+			NumBlocks:           40,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.GetBlockItems()) != 40 || resp.GetBlockItems()[0].GetBlockHeight() != 1000 {
+			t.Error("unexpected blocks above checkpoint")
+		}
+
+		_, err = handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{
+			HeadBlockId:         head,
+			AncestorStartHeight: 999,
+			NumBlocks:           1,
+		})
+		if _, ok := err.(*BelowCheckpoint); !ok {
+			t.Errorf("expected BelowCheckpoint, got %v", err)
+		}
+
+		verify, err := handler.VerifyChainLinks(context.Background(), &VerifyChainLinksRequest{HeadBlockID: head, StartHeight: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !verify.Valid {
+			t.Errorf("unexpected inconsistency %+v", verify.Inconsistency)
+		}
+
+		// Blocks below the checkpoint are rejected
+		older := makeChainFrom(GetNonExistentBlockID(98), 999, 1)
+		if _, err = handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: older[0]}); err == nil {
+			t.Error("expected error adding block below checkpoint")
+		}
+
+		// A different checkpoint is rejected
+		if err = handler.BootstrapFromCheckpoint(makeCheckpoint(blocks[1])); err == nil {
+			t.Error("expected error bootstrapping from a different checkpoint")
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestCheckpointValidation(t *testing.T) {
+	blocks := makeChainFrom(GetEmptyBlockID(), 5, 2)
+
+	checkpoint := makeCheckpoint(blocks[0])
+	checkpoint.Height = 6
+	if err := checkpoint.Validate(); err == nil {
+		t.Error("expected error for height mismatch")
+	}
+
+	checkpoint = makeCheckpoint(blocks[0])
+	checkpoint.BlockID = blocks[1].GetId()
+	if err := checkpoint.Validate(); err == nil {
+		t.Error("expected error for ID mismatch")
+	}
+
+	// A store with blocks cannot be bootstrapped
+	handler := RequestHandler{Backend: NewMapBackend()}
+	buildLinearChain(t, &handler, 3)
+	if err := handler.BootstrapFromCheckpoint(makeCheckpoint(blocks[0])); err == nil {
+		t.Error("expected error bootstrapping non-empty store")
+	}
+}
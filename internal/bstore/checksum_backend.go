@@ -0,0 +1,243 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// checksumMagic starts every checksummed value, followed by the CRC-32C of the key and value. The
+// first byte is zero like that of a compressed value, which raw block records never start with.
+var checksumMagic = []byte{0x00, 0xc5, 0x3c}
+
+const checksumHeaderSize = 3 + crc32.Size
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumMismatch is returned when a stored value does not match its checksum, the value was
+// corrupted on disk or written to the wrong key
+type ChecksumMismatch struct {
+	Key      []byte
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumMismatch) Error() string {
+	return fmt.Sprintf("value of key 0x%s is corrupted, checksum 0x%08x does not match 0x%08x", hex.EncodeToString(e.Key), e.Actual, e.Expected)
+}
+
+// Code returns the error code
+func (e *ChecksumMismatch) Code() ErrorCode {
+	return ErrorCodeCorruption
+}
+
+// Details returns the corrupted key and the checksums
+func (e *ChecksumMismatch) Details() map[string]interface{} {
+	return map[string]interface{}{
+		"key":      hex.EncodeToString(e.Key),
+		"expected": e.Expected,
+		"actual":   e.Actual,
+	}
+}
+
+// ChecksumBackend stores a CRC-32C of each key and value alongside the value and verifies it when the
+// value is read, returning ChecksumMismatch instead of a corrupted value. Values without a checksum
+// are returned as is, so a database written without checksums remains readable.
+//
+// Verification does not depend on Checksum, so checksums can be disabled without losing access to the
+// values written while they were enabled.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend.
+type ChecksumBackend struct {
+	Backend BlockStoreBackend
+
+	// Checksum is set if new values are stored with a checksum
+	Checksum bool
+}
+
+// NewChecksumBackend creates a ChecksumBackend over backend
+func NewChecksumBackend(backend BlockStoreBackend, checksum bool) *ChecksumBackend {
+	return &ChecksumBackend{Backend: backend, Checksum: checksum}
+}
+
+func valueChecksum(key []byte, value []byte) uint32 {
+	return crc32.Update(crc32.Checksum(key, castagnoli), castagnoli, value)
+}
+
+// seal returns the value to store, prefixed with its checksum if enabled
+func (backend *ChecksumBackend) seal(key []byte, value []byte) []byte {
+	if !backend.Checksum {
+		return value
+	}
+
+	sealed := make([]byte, checksumHeaderSize, checksumHeaderSize+len(value))
+	copy(sealed, checksumMagic)
+	binary.BigEndian.PutUint32(sealed[len(checksumMagic):], valueChecksum(key, value))
+	return append(sealed, value...)
+}
+
+// verifyChecksum returns the value stored with its checksum, or the value as is if it has none
+func verifyChecksum(key []byte, value []byte) ([]byte, error) {
+	if len(value) < checksumHeaderSize || !bytes.Equal(value[:len(checksumMagic)], checksumMagic) {
+		return value, nil
+	}
+
+	expected := binary.BigEndian.Uint32(value[len(checksumMagic):])
+	value = value[checksumHeaderSize:]
+	if actual := valueChecksum(key, value); actual != expected {
+		return nil, &ChecksumMismatch{Key: append([]byte{}, key...), Expected: expected, Actual: actual}
+	}
+
+	return value, nil
+}
+
+// Reset resets the wrapped database
+func (backend *ChecksumBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put stores the value with its checksum in the wrapped database
+func (backend *ChecksumBackend) Put(key []byte, value []byte) error {
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.Backend.Put(key, backend.seal(key, value))
+}
+
+// PutWithTTL stores the value with its checksum in the wrapped database with a TTL
+func (backend *ChecksumBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return putWithTTL(backend.Backend, key, backend.seal(key, value), ttl)
+}
+
+// PutBatch stores the values with their checksums in the wrapped database
+func (backend *ChecksumBackend) PutBatch(pairs []*KeyValue) error {
+	sealed := make([]*KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		if pair.Value == nil {
+			return errors.New("cannot put a nil value")
+		}
+		sealed = append(sealed, &KeyValue{Key: pair.Key, Value: backend.seal(pair.Key, pair.Value)})
+	}
+
+	return backend.Backend.PutBatch(sealed)
+}
+
+// Delete removes an item from the wrapped database
+func (backend *ChecksumBackend) Delete(key []byte) error {
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the wrapped database, verifying its checksum
+func (backend *ChecksumBackend) Get(key []byte) ([]byte, error) {
+	value, err := backend.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyChecksum(key, value)
+}
+
+// Has reports whether the wrapped database stores key, the value is not verified
+func (backend *ChecksumBackend) Has(key []byte) (bool, error) {
+	return backend.Backend.Has(key)
+}
+
+// Iterate calls fn with the keys of the wrapped database starting with prefix and their values,
+// verifying their checksums
+func (backend *ChecksumBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, func(key []byte, value []byte) error {
+		value, err := verifyChecksum(key, value)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// Snapshot takes a snapshot of the wrapped database, verifying the values read through it
+func (backend *ChecksumBackend) Snapshot() (BackendSnapshot, error) {
+	snapshot, err := backend.Backend.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueSnapshot{snapshot: snapshot, decode: verifyChecksum}, nil
+}
+
+// Flush flushes the wrapped database
+func (backend *ChecksumBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database, storing the values written through it with
+// their checksums and verifying those read
+func (backend *ChecksumBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueTxn{
+		txn: txn,
+		encode: func(key []byte, value []byte) ([]byte, error) {
+			return backend.seal(key, value), nil
+		},
+		decode: verifyChecksum,
+	}, nil
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *ChecksumBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *ChecksumBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend, values are backed up with their checksums
+func (backend *ChecksumBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend
+func (backend *ChecksumBackend) Load(r io.Reader) error {
+	return backend.Backend.Load(r)
+}
+
+// BulkLoad replaces the contents of the wrapped database with the records of source, storing the
+// values with their checksums if enabled
+func (backend *ChecksumBackend) BulkLoad(source RecordSource) error {
+	return BulkLoad(backend.Backend, mapRecordSource(source, func(key []byte, value []byte) ([]byte, error) {
+		return backend.seal(key, value), nil
+	}))
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *ChecksumBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *ChecksumBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
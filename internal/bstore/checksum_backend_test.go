@@ -0,0 +1,72 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestChecksumBackendBasic(t *testing.T) {
+	backendTest(t, NewChecksumBackend(NewMapBackend(), true))
+}
+
+func TestChecksumBackend(t *testing.T) {
+	inner := NewMapBackend()
+
+	// Values are stored as is before checksums are enabled
+	legacy := NewChecksumBackend(inner, false)
+	if err := legacy.Put([]byte("legacy"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewChecksumBackend(inner, true)
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 5)
+
+	if value, err := backend.Get([]byte("legacy")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("expected the value stored without a checksum, got %s, %v", value, err)
+	}
+
+	raw, _ := inner.Get(blockRecordKey(bt.ByNum[103].GetId()))
+	if !bytes.HasPrefix(raw, checksumMagic) {
+		t.Fatalf("expected a checksummed block record, got %x", raw[:checksumHeaderSize])
+	}
+
+	// Values written with checksums remain readable once they are disabled
+	if value, err := legacy.Get(blockRecordKey(bt.ByNum[103].GetId())); err != nil || !bytes.Equal(value, raw[checksumHeaderSize:]) {
+		t.Errorf("expected the verified value, got %v", err)
+	}
+
+	// A flipped bit is reported as corruption of the key, by every way of reading it
+	corrupted := append([]byte{}, raw...)
+	corrupted[len(corrupted)/2] ^= 0x01
+	if err := inner.Put(blockRecordKey(bt.ByNum[103].GetId()), corrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := backend.Get(blockRecordKey(bt.ByNum[103].GetId()))
+	var mismatch *ChecksumMismatch
+	if !errors.As(err, &mismatch) || !bytes.Equal(mismatch.Key, blockRecordKey(bt.ByNum[103].GetId())) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	if err = backend.Iterate(nil, func(key []byte, value []byte) error { return nil }); !errors.As(err, &mismatch) {
+		t.Errorf("expected a checksum mismatch iterating, got %v", err)
+	}
+
+	_, err = handler.GetBlocksByID(context.Background(), &block_store.GetBlocksByIdRequest{BlockIds: [][]byte{bt.ByNum[103].GetId()}, ReturnBlock: true})
+	if ErrorCodeOf(err) != ErrorCodeCorruption {
+		t.Errorf("expected a corruption error, got %v", err)
+	}
+
+	// A value moved to another key does not match its checksum
+	if err = inner.Put(blockRecordKey(bt.ByNum[104].GetId()), raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backend.Get(blockRecordKey(bt.ByNum[104].GetId())); !errors.As(err, &mismatch) {
+		t.Errorf("expected a checksum mismatch for a misplaced value, got %v", err)
+	}
+}
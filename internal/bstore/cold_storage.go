@@ -0,0 +1,157 @@
+package bstore
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+)
+
+// coldStorageSaveInterval is the number of heights offloaded between progress saves
+const coldStorageSaveInterval = 100
+
+// ColdStorageOffloader moves the records of irreversible blocks more than Depth blocks below the
+// irreversible block to the object store of a TieredBackend. All blocks at an offloaded height are
+// moved, including blocks on forks. Blocks added before the height index existed are not moved.
+type ColdStorageOffloader struct {
+	Handler *RequestHandler
+	Backend *TieredBackend
+
+	// Depth is the number of irreversible blocks kept in the local database
+	Depth uint64
+
+	running int32
+
+	lock      sync.Mutex
+	lastError string
+}
+
+// ColdStorageStatus is the status reported by a ColdStorageOffloader
+type ColdStorageStatus struct {
+	// OffloadedHeight is the height below which blocks have been moved to cold storage
+	OffloadedHeight uint64 `json:"offloaded_height"`
+
+	// LastError is the error of the last offload, if it failed
+	LastError string `json:"last_error,omitempty"`
+}
+
+// HandleIrreversible offloads the blocks which fell Depth blocks below the new irreversible block. If
+// an offload is already running it returns immediately, the next irreversible block continues it.
+func (o *ColdStorageOffloader) HandleIrreversible(topology *koinos.BlockTopology) error {
+	if topology.GetHeight() <= o.Depth {
+		return nil
+	}
+
+	if !atomic.CompareAndSwapInt32(&o.running, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&o.running, 0)
+
+	err := o.offload(topology.GetHeight() - o.Depth)
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err != nil {
+		o.lastError = err.Error()
+	} else {
+		o.lastError = ""
+	}
+
+	return err
+}
+
+func (o *ColdStorageOffloader) offload(belowHeight uint64) error {
+	height, err := o.offloadedHeight()
+	if err != nil {
+		return err
+	}
+
+	start := height
+	var moved int
+	for ; height < belowHeight; height++ {
+		o.Handler.lock.RLock()
+		ids, err := getHeightIndex(o.Handler.Backend, height)
+		o.Handler.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			ok, err := o.Backend.Offload(blockRecordKey(id))
+			if err != nil {
+				// Keep the progress made so far
+				_ = o.saveOffloadedHeight(height)
+				return err
+			}
+			if ok {
+				moved++
+			}
+		}
+
+		if (height+1-start)%coldStorageSaveInterval == 0 {
+			if err = o.saveOffloadedHeight(height + 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if height == start {
+		return nil
+	}
+
+	if err = o.saveOffloadedHeight(height); err != nil {
+		return err
+	}
+
+	log.Infof("Moved %d block(s) below height %d to cold storage", moved, height)
+	return nil
+}
+
+// offloadedHeight returns the height below which blocks have been offloaded, 1 if none have
+func (o *ColdStorageOffloader) offloadedHeight() (uint64, error) {
+	o.Handler.lock.RLock()
+	defer o.Handler.lock.RUnlock()
+
+	value, err := o.Handler.Backend.Get([]byte{coldStorageHeightKey})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(value) != 8 {
+		return 1, nil
+	}
+
+	return binary.BigEndian.Uint64(value), nil
+}
+
+func (o *ColdStorageOffloader) saveOffloadedHeight(height uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, height)
+
+	o.Handler.lock.Lock()
+	defer o.Handler.lock.Unlock()
+
+	return o.Handler.Backend.Put([]byte{coldStorageHeightKey}, value)
+}
+
+// StatusName implements StatusReporter
+func (o *ColdStorageOffloader) StatusName() string {
+	return "cold_storage"
+}
+
+// Status implements StatusReporter. It is called with the handler lock held.
+func (o *ColdStorageOffloader) Status() interface{} {
+	status := &ColdStorageStatus{OffloadedHeight: 1}
+	if value, err := o.Handler.Backend.Get([]byte{coldStorageHeightKey}); err == nil && len(value) == 8 {
+		status.OffloadedHeight = binary.BigEndian.Uint64(value)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	status.LastError = o.lastError
+	return status
+}
@@ -0,0 +1,123 @@
+package bstore
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// IndexReport describes whether a secondary index covers the head block
+type IndexReport struct {
+	Name string `json:"name"`
+
+	// Present is set if the head block is indexed. Blocks added before an index existed are not
+	// indexed, so a missing index only limits the requests served from it.
+	Present bool `json:"present"`
+}
+
+// CompatibilityReport describes an existing database and whether this binary can serve it
+type CompatibilityReport struct {
+	SchemaVersion string `json:"schema_version"`
+
+	// FormatVersion is the format version recorded in the database, 0 if it predates the record
+	FormatVersion uint64 `json:"format_version"`
+
+	Empty            bool      `json:"empty"`
+	Head             *Topology `json:"head,omitempty"`
+	Irreversible     *Topology `json:"irreversible,omitempty"`
+	CheckpointHeight uint64    `json:"checkpoint_height,omitempty"`
+
+	Indexes []*IndexReport `json:"indexes"`
+
+	// Migrations are the data migrations this binary would run on the database when it starts, or
+	// which the migrate command runs
+	Migrations []string `json:"migrations"`
+
+	// Compatible is set if this binary can serve the database, otherwise Problems explains why not
+	Compatible bool     `json:"compatible"`
+	Problems   []string `json:"problems,omitempty"`
+}
+
+// CheckCompatibility inspects the database without writing to it and reports whether this binary can
+// serve it. A database which cannot be read at all is reported as an error.
+func (handler *RequestHandler) CheckCompatibility() (*CompatibilityReport, error) {
+	report := &CompatibilityReport{SchemaVersion: SchemaVersion, Indexes: []*IndexReport{}, Migrations: []string{}}
+
+	problem := func(format string, args ...interface{}) {
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	var err error
+	if report.Head, err = handler.getTopologyAtKey(highestBlockKey); err != nil {
+		problem("highest block record cannot be read, %s", err)
+	}
+
+	if report.Irreversible, err = handler.getTopologyAtKey(irreversibleKey); err != nil {
+		problem("irreversible block record cannot be read, %s", err)
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		problem("%s", err)
+	} else if checkpoint != nil {
+		report.CheckpointHeight = checkpoint.Height
+	}
+
+	version, pending, err := pendingMigrations(handler.Backend)
+	report.FormatVersion = version
+	if err != nil {
+		problem("%s", err)
+	}
+	migrate := false
+	for _, m := range pending {
+		report.Migrations = append(report.Migrations, m.Name)
+		migrate = migrate || m.Name == KeyspaceMigrationName
+	}
+
+	if report.Head == nil {
+		report.Empty = len(report.Problems) == 0 && len(pending) == 0
+		report.Compatible = len(report.Problems) == 0
+		return report, nil
+	}
+
+	// The records of a database yet to be migrated are read under their v1 keys
+	recordKey := blockRecordKey(report.Head.ID)
+	if migrate {
+		recordKey = report.Head.ID
+	}
+	record, err := handler.getRecordAtKey(recordKey, report.Head.ID)
+	if err != nil {
+		problem("head block record cannot be read, %s", err)
+	} else if HasUnknownFields(record.ProtoReflect()) {
+		problem("head block record contains fields unknown to schema %s, it was written by a newer block store", SchemaVersion)
+	}
+
+	ids, err := getHeightIndex(handler.Backend, report.Head.Height)
+	if err != nil {
+		problem("%s", err)
+	}
+	heightIndex := &IndexReport{Name: "height"}
+	for _, id := range ids {
+		if bytes.Equal(id, report.Head.ID) {
+			heightIndex.Present = true
+		}
+	}
+
+	metadata, err := handler.Backend.Get(blockMetadataKey(report.Head.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	_, payerIndexed, err := payerIndexLowestHeight(handler.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Indexes = append(report.Indexes,
+		heightIndex,
+		&IndexReport{Name: "block_metadata", Present: len(metadata) > 0},
+		&IndexReport{Name: PayerIndexName, Present: payerIndexed},
+	)
+
+	report.Compatible = len(report.Problems) == 0
+	return report, nil
+}
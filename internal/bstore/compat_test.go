@@ -0,0 +1,59 @@
+package bstore
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		report, err := handler.CheckCompatibility()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Empty || !report.Compatible {
+			t.Errorf("expected an empty compatible store, got %+v", report)
+		}
+
+		bt := buildLinearChain(t, &handler, 10)
+
+		report, err = handler.CheckCompatibility()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Empty || !report.Compatible || report.Head.Height != 10 {
+			t.Errorf("expected a compatible store at height 10, got %+v", report)
+		}
+		for _, index := range report.Indexes {
+			if index.Present != (index.Name != PayerIndexName) {
+				t.Errorf("unexpected index report %+v", index)
+			}
+		}
+
+		// A record written by a newer block store has fields this binary does not know
+		head := blockRecordKey(bt.ByNum[110].GetId())
+		value, err := b.Get(head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value = protowire.AppendTag(value, 99, protowire.VarintType)
+		value = protowire.AppendVarint(value, 1)
+		if err = b.Put(head, value); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err = handler.CheckCompatibility()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Compatible || len(report.Problems) != 1 {
+			t.Errorf("expected an incompatible store, got %+v", report)
+		}
+
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,347 @@
+package bstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+const (
+	// compressionMinSize is the smallest value which is compressed, smaller values such as metadata
+	// records gain little and are stored as is
+	compressionMinSize = 128
+
+	// compressionMaxSize bounds the decompressed size of a value
+	compressionMaxSize = 1 << 30
+
+	compressedPrefix = 0x00
+)
+
+// zstdMagic starts every zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+type recompressBackend interface {
+	Recompress(key []byte) (bool, error)
+}
+
+// CompressedBackend compresses values with zstd on Put and decompresses them on Get. Compressed values
+// start with a zero byte followed by the zstd frame, values without this header are returned as is,
+// so a database written without compression remains readable and can be migrated with Recompress.
+//
+// Decompression does not depend on Compress, so compression can be disabled without losing access to
+// the values written while it was enabled.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend.
+type CompressedBackend struct {
+	Backend BlockStoreBackend
+
+	// Compress is set if new values are compressed
+	Compress bool
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewCompressedBackend creates a CompressedBackend over backend
+func NewCompressedBackend(backend BlockStoreBackend, compress bool) (*CompressedBackend, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(compressionMaxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompressedBackend{Backend: backend, Compress: compress, encoder: encoder, decoder: decoder}, nil
+}
+
+func isCompressed(value []byte) bool {
+	return len(value) > len(zstdMagic) && value[0] == compressedPrefix && bytes.Equal(value[1:1+len(zstdMagic)], zstdMagic)
+}
+
+// compress returns the value to store, which is only compressed if that makes it smaller
+func (backend *CompressedBackend) compress(value []byte) []byte {
+	if !backend.Compress || len(value) < compressionMinSize {
+		return value
+	}
+
+	compressed := backend.encoder.EncodeAll(value, []byte{compressedPrefix})
+	if len(compressed) >= len(value) {
+		return value
+	}
+
+	return compressed
+}
+
+// Reset resets the wrapped database
+func (backend *CompressedBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put compresses the value and stores it in the wrapped database
+func (backend *CompressedBackend) Put(key []byte, value []byte) error {
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.Backend.Put(key, backend.compress(value))
+}
+
+// PutWithTTL compresses the value and stores it in the wrapped database with a TTL
+func (backend *CompressedBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return putWithTTL(backend.Backend, key, backend.compress(value), ttl)
+}
+
+// PutBatch compresses the values and stores them in the wrapped database
+func (backend *CompressedBackend) PutBatch(pairs []*KeyValue) error {
+	compressed := make([]*KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		if pair.Value == nil {
+			return errors.New("cannot put a nil value")
+		}
+		compressed = append(compressed, &KeyValue{Key: pair.Key, Value: backend.compress(pair.Value)})
+	}
+
+	return backend.Backend.PutBatch(compressed)
+}
+
+// Delete removes an item from the wrapped database
+func (backend *CompressedBackend) Delete(key []byte) error {
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the wrapped database, decompressing it if needed
+func (backend *CompressedBackend) Get(key []byte) ([]byte, error) {
+	value, err := backend.Backend.Get(key)
+	if err != nil || !isCompressed(value) {
+		return value, err
+	}
+
+	return backend.decoder.DecodeAll(value[1:], nil)
+}
+
+// Has reports whether the wrapped database stores key
+func (backend *CompressedBackend) Has(key []byte) (bool, error) {
+	return backend.Backend.Has(key)
+}
+
+// Iterate calls fn with the keys of the wrapped database starting with prefix and their values,
+// decompressing them if needed
+func (backend *CompressedBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, func(key []byte, value []byte) error {
+		if isCompressed(value) {
+			var err error
+			if value, err = backend.decoder.DecodeAll(value[1:], nil); err != nil {
+				return err
+			}
+		}
+		return fn(key, value)
+	})
+}
+
+// Snapshot takes a snapshot of the wrapped database, decompressing the values read through it
+func (backend *CompressedBackend) Snapshot() (BackendSnapshot, error) {
+	snapshot, err := backend.Backend.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueSnapshot{snapshot: snapshot, decode: func(key []byte, value []byte) ([]byte, error) {
+		if !isCompressed(value) {
+			return value, nil
+		}
+		return backend.decoder.DecodeAll(value[1:], nil)
+	}}, nil
+}
+
+// Flush flushes the wrapped database
+func (backend *CompressedBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database, compressing the values written through it and
+// decompressing those read
+func (backend *CompressedBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueTxn{
+		txn: txn,
+		encode: func(key []byte, value []byte) ([]byte, error) {
+			return backend.compress(value), nil
+		},
+		decode: func(key []byte, value []byte) ([]byte, error) {
+			if !isCompressed(value) {
+				return value, nil
+			}
+			return backend.decoder.DecodeAll(value[1:], nil)
+		},
+	}, nil
+}
+
+// Recompress stores the value of key compressed if it is stored uncompressed, returning true if it was
+// rewritten
+func (backend *CompressedBackend) Recompress(key []byte) (bool, error) {
+	if !backend.Compress {
+		return false, errors.New("compression is disabled")
+	}
+
+	value, err := backend.Backend.Get(key)
+	if err != nil || isCompressed(value) {
+		return false, err
+	}
+
+	compressed := backend.compress(value)
+	if len(compressed) == len(value) {
+		return false, nil
+	}
+
+	return true, backend.Backend.Put(key, compressed)
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *CompressedBackend) Close() {
+	backend.decoder.Close()
+	_ = backend.encoder.Close()
+
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *CompressedBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend, values are backed up as stored
+func (backend *CompressedBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend
+func (backend *CompressedBackend) Load(r io.Reader) error {
+	return backend.Backend.Load(r)
+}
+
+// BulkLoad replaces the contents of the wrapped database with the records of source, compressing
+// the values if enabled
+func (backend *CompressedBackend) BulkLoad(source RecordSource) error {
+	return BulkLoad(backend.Backend, mapRecordSource(source, func(key []byte, value []byte) ([]byte, error) {
+		return backend.compress(value), nil
+	}))
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *CompressedBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *CompressedBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
+
+// CompressBlocksRequest asks the block store to compress the block records of the canonical chain which
+// were stored before compression was enabled
+type CompressBlocksRequest struct {
+}
+
+// CompressBlocksResponse reports the block records checked and rewritten compressed
+type CompressBlocksResponse struct {
+	BlocksChecked    uint64 `json:"blocks_checked"`
+	BlocksCompressed uint64 `json:"blocks_compressed"`
+}
+
+// CompressBlocks rewrites the uncompressed block records of the chain ending at the highest block, from
+// the head down. The chain is rewritten in chunks, so writers are only blocked for the duration of a
+// chunk. Blocks on forks are not rewritten.
+func (handler *RequestHandler) CompressBlocks(req *CompressBlocksRequest) (*CompressBlocksResponse, error) {
+	if _, ok := handler.Backend.(recompressBackend); !ok {
+		return nil, errors.New("backend does not support compression")
+	}
+
+	if !atomic.CompareAndSwapInt32(&handler.compressing, 0, 1) {
+		return nil, errors.New("compression already in progress")
+	}
+	defer atomic.StoreInt32(&handler.compressing, 0)
+
+	handler.lock.RLock()
+	highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+	handler.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Compressing block records below height %d", highest.GetTopology().GetHeight())
+
+	resp := &CompressBlocksResponse{}
+	blockID := highest.GetTopology().GetId()
+	for len(blockID) > 0 && err == nil {
+		blockID, err = handler.compressChunk(blockID, resp)
+	}
+	if err != nil {
+		log.Warnf("Compression failed, %s", err.Error())
+		return nil, err
+	}
+
+	log.Infof("Compressed %v of %v block record(s)", resp.BlocksCompressed, resp.BlocksChecked)
+	return resp, nil
+}
+
+// compressChunk rewrites up to exportChunkSize block records starting at blockID, returning the ID of
+// the next block to rewrite, or nil once the first stored block has been rewritten
+func (handler *RequestHandler) compressChunk(blockID []byte, resp *CompressBlocksResponse) ([]byte, error) {
+	// Records are read and rewritten, no block may be added in between
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	backend := handler.Backend.(recompressBackend)
+	for i := 0; i < exportChunkSize; i++ {
+		record, err := handler.getRecord(blockID)
+		if _, ok := err.(*BlockNotPresent); ok {
+			// The chain starts at a checkpoint, or at the genesis block which is not stored
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		compressed, err := backend.Recompress(blockRecordKey(blockID))
+		if err != nil {
+			return nil, err
+		}
+
+		resp.BlocksChecked++
+		if compressed {
+			resp.BlocksCompressed++
+		}
+
+		if record.GetBlockHeight() <= 1 {
+			return nil, nil
+		}
+		blockID = record.GetBlock().GetHeader().GetPrevious()
+	}
+
+	return blockID, nil
+}
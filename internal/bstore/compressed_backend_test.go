@@ -0,0 +1,83 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressedBackendBasic(t *testing.T) {
+	b, err := NewCompressedBackend(NewMapBackend(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendTest(t, b)
+
+	b.Close()
+}
+
+func TestCompressedBackend(t *testing.T) {
+	inner := NewMapBackend()
+
+	// Blocks are stored uncompressed before compression is enabled
+	legacy, err := NewCompressedBackend(inner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := RequestHandler{Backend: legacy}
+	bt := buildLinearChain(t, &handler, 10)
+
+	large := bytes.Repeat([]byte("koinos"), 100)
+	if err = legacy.Put([]byte("large"), large); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := inner.Get([]byte("large")); isCompressed(raw) {
+		t.Error("expected values not to be compressed while compression is disabled")
+	}
+
+	backend, err := NewCompressedBackend(inner, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Backend = backend
+
+	if err = backend.Put([]byte("small"), []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := inner.Get([]byte("small")); !bytes.Equal(raw, []byte{0x01}) {
+		t.Errorf("expected small values to be stored as is, got %x", raw)
+	}
+
+	compressed, err := backend.Recompress([]byte("large"))
+	if err != nil || !compressed {
+		t.Fatalf("expected the large value to be compressed, got %v, %v", compressed, err)
+	}
+	raw, _ := inner.Get([]byte("large"))
+	if !isCompressed(raw) || len(raw) >= len(large) {
+		t.Errorf("expected a compressed value smaller than %d bytes, got %d bytes", len(large), len(raw))
+	}
+	if value, err := backend.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("expected the decompressed value, got %v", err)
+	}
+
+	resp, err := handler.CompressBlocks(&CompressBlocksRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.BlocksChecked != 10 {
+		t.Errorf("expected 10 blocks checked, got %d", resp.BlocksChecked)
+	}
+
+	// Values written with compression remain readable once it is disabled
+	handler.Backend = legacy
+	verify := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 1}})
+	if verify.Error != nil || !verify.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain, got %+v", verify)
+	}
+	if value, err := legacy.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("expected the decompressed value, got %v", err)
+	}
+	if _, err = legacy.Recompress([]byte("large")); err == nil {
+		t.Error("expected recompression to fail while compression is disabled")
+	}
+}
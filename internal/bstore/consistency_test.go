@@ -0,0 +1,106 @@
+package bstore
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+// TestReadYourWrites checks that once AddBlock returns, reads issued concurrently by other workers
+// observe the block
+func TestReadYourWrites(t *testing.T) {
+	const (
+		numBlocks  = 300
+		numReaders = 8
+	)
+
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		blocks := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, numBlocks, 0)
+		added := make(chan *protocol.Block)
+		errs := make(chan error, numReaders)
+
+		var wg sync.WaitGroup
+		for i := 0; i < numReaders; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for block := range added {
+					if err := checkBlockVisible(&handler, block); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+
+		// Unrelated readers keep the handler lock contended
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					handler.HandleRequest(&block_store.BlockStoreRequest{
+						Request: &block_store.BlockStoreRequest_GetHighestBlock{GetHighestBlock: &block_store.GetHighestBlockRequest{}},
+					})
+				}
+			}
+		}()
+
+		for _, block := range blocks {
+			resp := handler.HandleRequest(&block_store.BlockStoreRequest{
+				Request: &block_store.BlockStoreRequest_AddBlock{AddBlock: &block_store.AddBlockRequest{BlockToAdd: block}},
+			})
+			if _, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error); ok {
+				t.Fatal("unexpected error adding block")
+			}
+			added <- block
+		}
+
+		close(added)
+		wg.Wait()
+		close(done)
+		<-stopped
+		close(errs)
+
+		for err := range errs {
+			t.Error(err)
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func checkBlockVisible(handler *RequestHandler, block *protocol.Block) error {
+	resp := handler.HandleRequest(&block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_GetBlocksById{GetBlocksById: &block_store.GetBlocksByIdRequest{
+			BlockIds:    [][]byte{block.GetId()},
+			ReturnBlock: true,
+		}},
+	})
+
+	items := resp.GetGetBlocksById().GetBlockItems()
+	if len(items) != 1 || !bytes.Equal(items[0].GetBlock().GetId(), block.GetId()) {
+		return fmt.Errorf("block at height %d not visible after AddBlock returned", block.GetHeader().GetHeight())
+	}
+
+	resp = handler.HandleRequest(&block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_GetHighestBlock{GetHighestBlock: &block_store.GetHighestBlockRequest{}},
+	})
+
+	if height := resp.GetGetHighestBlock().GetTopology().GetHeight(); height < block.GetHeader().GetHeight() {
+		return fmt.Errorf("highest block %d is below added block %d", height, block.GetHeader().GetHeight())
+	}
+
+	return nil
+}
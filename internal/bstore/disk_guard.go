@@ -0,0 +1,272 @@
+package bstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// DefaultDiskCheckInterval is the default interval between checks of the free space of a DiskGuardBackend
+const DefaultDiskCheckInterval = 10 * time.Second
+
+// DiskFullError is returned for writes while the free space on the database volume is below the
+// threshold of a DiskGuardBackend
+type DiskFullError struct {
+	Dir     string
+	Free    uint64
+	MinFree uint64
+}
+
+func (e *DiskFullError) Error() string {
+	return fmt.Sprintf("disk full, %d bytes free on the volume of %s is below the minimum of %d", e.Free, e.Dir, e.MinFree)
+}
+
+// Code returns the error code
+func (e *DiskFullError) Code() ErrorCode {
+	return ErrorCodeDiskFull
+}
+
+// Details returns the free space and the threshold
+func (e *DiskFullError) Details() map[string]interface{} {
+	return map[string]interface{}{
+		"free_bytes":     e.Free,
+		"min_free_bytes": e.MinFree,
+	}
+}
+
+// DiskGuardBackend refuses writes with DiskFullError while the free space on the volume of one of Dirs
+// is below MinFree, so the database is not left corrupted by running out of space in the middle of a
+// write. The free space is checked at most every CheckInterval, when writing or reporting health,
+// and writes resume once enough space is freed.
+//
+// Deletes and resets are allowed, as they are how space is freed. Compaction, backup and health
+// requests are forwarded to the wrapped backend.
+type DiskGuardBackend struct {
+	Backend BlockStoreBackend
+
+	Dirs          []string
+	MinFree       uint64
+	CheckInterval time.Duration
+
+	// free returns the free space of a directory, diskFree outside of tests
+	free func(dir string) (uint64, error)
+
+	lock      sync.Mutex
+	lastCheck time.Time
+	lastFree  uint64
+	lastDir   string
+	full      bool
+}
+
+// NewDiskGuardBackend creates a DiskGuardBackend over backend, refusing writes while less than
+// minFree bytes are free on the volume of one of dirs
+func NewDiskGuardBackend(backend BlockStoreBackend, dirs []string, minFree uint64, checkInterval time.Duration) *DiskGuardBackend {
+	return &DiskGuardBackend{
+		Backend:       backend,
+		Dirs:          dirs,
+		MinFree:       minFree,
+		CheckInterval: checkInterval,
+		free:          diskFree,
+	}
+}
+
+// refresh checks the free space if the last check is older than the check interval, keeping the
+// lowest free space of the volumes. The lock must be held.
+func (backend *DiskGuardBackend) refresh() error {
+	if !backend.lastCheck.IsZero() && time.Since(backend.lastCheck) < backend.CheckInterval {
+		return nil
+	}
+
+	var lowest uint64
+	var lowestDir string
+	for i, dir := range backend.Dirs {
+		free, err := backend.free(dir)
+		if err != nil {
+			return err
+		}
+		if i == 0 || free < lowest {
+			lowest, lowestDir = free, dir
+		}
+	}
+	backend.lastCheck = time.Now()
+	backend.lastFree, backend.lastDir = lowest, lowestDir
+
+	full := lowest < backend.MinFree
+	if full && !backend.full {
+		log.Errorf("Only %d MiB free on the volume of %s, refusing writes until %d MiB are free", lowest>>20, lowestDir, backend.MinFree>>20)
+	} else if !full && backend.full {
+		log.Infof("%d MiB free on the volume of %s, accepting writes again", lowest>>20, lowestDir)
+	}
+	backend.full = full
+
+	return nil
+}
+
+// check returns DiskFullError if the volume is full
+func (backend *DiskGuardBackend) check() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if err := backend.refresh(); err != nil {
+		return err
+	}
+	if backend.full {
+		return &DiskFullError{Dir: backend.lastDir, Free: backend.lastFree, MinFree: backend.MinFree}
+	}
+
+	return nil
+}
+
+// Reset resets the wrapped database
+func (backend *DiskGuardBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put stores the value in the wrapped database unless the volume is full
+func (backend *DiskGuardBackend) Put(key []byte, value []byte) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+
+	return backend.Backend.Put(key, value)
+}
+
+// PutWithTTL stores the value in the wrapped database with a TTL unless the volume is full
+func (backend *DiskGuardBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+
+	return putWithTTL(backend.Backend, key, value, ttl)
+}
+
+// PutBatch stores the values in the wrapped database unless the volume is full
+func (backend *DiskGuardBackend) PutBatch(pairs []*KeyValue) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+
+	return backend.Backend.PutBatch(pairs)
+}
+
+// Delete removes an item from the wrapped database, even if the volume is full
+func (backend *DiskGuardBackend) Delete(key []byte) error {
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the wrapped database
+func (backend *DiskGuardBackend) Get(key []byte) ([]byte, error) {
+	return backend.Backend.Get(key)
+}
+
+// Has reports whether the wrapped database stores key
+func (backend *DiskGuardBackend) Has(key []byte) (bool, error) {
+	return backend.Backend.Has(key)
+}
+
+// Iterate iterates over the wrapped database
+func (backend *DiskGuardBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, fn)
+}
+
+// Snapshot takes a snapshot of the wrapped database
+func (backend *DiskGuardBackend) Snapshot() (BackendSnapshot, error) {
+	return backend.Backend.Snapshot()
+}
+
+// Flush flushes the wrapped database
+func (backend *DiskGuardBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database, whose puts are refused while the volume is full
+func (backend *DiskGuardBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueTxn{
+		txn: txn,
+		encode: func(key []byte, value []byte) ([]byte, error) {
+			return value, backend.check()
+		},
+		decode: func(key []byte, value []byte) ([]byte, error) {
+			return value, nil
+		},
+	}, nil
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *DiskGuardBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend, even if the volume is full
+func (backend *DiskGuardBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend
+func (backend *DiskGuardBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend unless the volume is full
+func (backend *DiskGuardBackend) Load(r io.Reader) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+
+	return backend.Backend.Load(r)
+}
+
+// BulkLoad replaces the contents of the wrapped database with the records of source unless the
+// volume is full
+func (backend *DiskGuardBackend) BulkLoad(source RecordSource) error {
+	if err := backend.check(); err != nil {
+		return err
+	}
+
+	return BulkLoad(backend.Backend, source)
+}
+
+// Health reports the health of the wrapped backend, which is not writable while the volume is full
+func (backend *DiskGuardBackend) Health() (*BackendHealth, error) {
+	health := &BackendHealth{Writable: true}
+	if inner, ok := backend.Backend.(healthBackend); ok {
+		var err error
+		if health, err = inner.Health(); err != nil {
+			return nil, err
+		}
+	}
+
+	err := backend.check()
+	var diskFull *DiskFullError
+	if errors.As(err, &diskFull) {
+		health.Writable = false
+		health.DiskFull = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	if health.DiskFree == nil {
+		backend.lock.Lock()
+		free := backend.lastFree
+		backend.lock.Unlock()
+		health.DiskFree = &free
+	}
+
+	return health, nil
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *DiskGuardBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
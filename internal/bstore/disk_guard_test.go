@@ -0,0 +1,75 @@
+package bstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestDiskGuardBackendBasic(t *testing.T) {
+	backendTest(t, NewDiskGuardBackend(NewMapBackend(), []string{t.TempDir()}, 1, 0))
+}
+
+func TestDiskGuardBackend(t *testing.T) {
+	// The value log volume fills up, the index volume keeps plenty of space
+	free := uint64(100 << 20)
+	backend := NewDiskGuardBackend(NewMapBackend(), []string{"db", "vlog"}, 64<<20, 0)
+	backend.free = func(dir string) (uint64, error) {
+		if dir == "db" {
+			return 1 << 30, nil
+		}
+		return free, nil
+	}
+
+	handler := RequestHandler{Backend: backend}
+	bt := ToBlockTree(NewMockBlockTree([][]uint64{{0, 101, 102, 103}}))
+	for _, num := range bt.Numbers[:len(bt.Numbers)-1] {
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[num]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Below the minimum, blocks are refused with a typed error
+	free = 10 << 20
+	_, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[103]})
+	var diskFull *DiskFullError
+	if !errors.As(err, &diskFull) || ErrorCodeOf(err) != ErrorCodeDiskFull || diskFull.Free != free || diskFull.Dir != "vlog" {
+		t.Fatalf("expected a disk full error, got %v", err)
+	}
+	if present, _ := backend.Has(blockRecordKey(bt.ByNum[103].GetId())); present {
+		t.Error("expected the refused block not to be stored")
+	}
+	if err = backend.PutBatch([]*KeyValue{{Key: []byte{0x12, 0x01}, Value: []byte("value")}}); ErrorCodeOf(err) != ErrorCodeDiskFull {
+		t.Errorf("expected a batch to be refused, got %v", err)
+	}
+
+	// Deletes free space, so they are allowed
+	if err = backend.Delete(blockRecordKey(bt.ByNum[102].GetId())); err != nil {
+		t.Errorf("expected a delete to be allowed, got %v", err)
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || health.Writable || !health.DiskFull || health.DiskFreeBytes == nil || *health.DiskFreeBytes != free {
+		t.Errorf("expected the health to report the full disk, got %+v, %v", health, err)
+	}
+
+	metrics, err := handler.CollectMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range metrics {
+		if metric.Name == "block_store_disk_full" && metric.Value != 1 {
+			t.Errorf("expected the disk full metric to be set, got %v", metric.Value)
+		}
+	}
+
+	// Writes resume once space is freed
+	free = 65 << 20
+	if _, err = handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[103]}); err != nil {
+		t.Errorf("expected writes to resume, got %v", err)
+	}
+	if health, _ = handler.GetHealth(&GetHealthRequest{}); !health.Writable || health.DiskFull {
+		t.Errorf("expected the disk to no longer be full, got %+v", health)
+	}
+}
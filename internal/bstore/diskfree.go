@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package bstore
+
+import "syscall"
+
+// diskFree returns the bytes available to unprivileged users on the volume containing dir
+func diskFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,147 @@
+package bstore
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	blockAcceptedBlockField protowire.Number = 1
+	blockIDField            protowire.Number = 1
+)
+
+type seenBlock struct {
+	id   string
+	time time.Time
+}
+
+// DuplicateFilter remembers the IDs of recently ingested blocks for a fixed window of time
+type DuplicateFilter struct {
+	window time.Duration
+
+	lock  sync.Mutex
+	seen  map[string]time.Time
+	queue []seenBlock
+}
+
+// NewDuplicateFilter creates a duplicate filter remembering block IDs for the given window
+func NewDuplicateFilter(window time.Duration) *DuplicateFilter {
+	return &DuplicateFilter{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// CheckAndAdd records the block ID, returning true if it was already seen within the window
+func (f *DuplicateFilter) CheckAndAdd(blockID []byte) bool {
+	return f.checkAndAddAt(blockID, time.Now())
+}
+
+func (f *DuplicateFilter) checkAndAddAt(blockID []byte, now time.Time) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.expire(now)
+
+	id := string(blockID)
+	if _, ok := f.seen[id]; ok {
+		return true
+	}
+
+	f.seen[id] = now
+	f.queue = append(f.queue, seenBlock{id: id, time: now})
+	return false
+}
+
+// Remove forgets the block ID so it may be ingested again
+func (f *DuplicateFilter) Remove(blockID []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// The stale queue entry is harmless, expire skips IDs that have been re-added since
+	delete(f.seen, string(blockID))
+}
+
+// Len returns the number of block IDs currently remembered
+func (f *DuplicateFilter) Len() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return len(f.seen)
+}
+
+func (f *DuplicateFilter) expire(now time.Time) {
+	i := 0
+	for ; i < len(f.queue); i++ {
+		if now.Sub(f.queue[i].time) < f.window {
+			break
+		}
+		if t, ok := f.seen[f.queue[i].id]; ok && t.Equal(f.queue[i].time) {
+			delete(f.seen, f.queue[i].id)
+		}
+	}
+
+	if i > 0 {
+		f.queue = append(f.queue[:0], f.queue[i:]...)
+	}
+}
+
+// PeekBlockAcceptedID extracts the block ID from a serialized koinos.block.accept broadcast without
+// deserializing the rest of the block
+func PeekBlockAcceptedID(data []byte) ([]byte, error) {
+	block, err := findBytesField(data, blockAcceptedBlockField)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := findBytesField(block, blockIDField)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(id) == 0 {
+		return nil, errors.New("broadcast block id is empty")
+	}
+
+	return id, nil
+}
+
+func findBytesField(data []byte, field protowire.Number) ([]byte, error) {
+	var result []byte
+	found := false
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if num == field && typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return nil, protowire.ParseError(m)
+			}
+			// Protobuf semantics dictate the last occurrence of a field wins
+			result = v
+			found = true
+			data = data[m:]
+			continue
+		}
+
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		data = data[m:]
+	}
+
+	if !found {
+		return nil, errors.New("field not present in message")
+	}
+
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/broadcast"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDuplicateFilter(t *testing.T) {
+	filter := NewDuplicateFilter(10 * time.Second)
+	start := time.Now()
+
+	if filter.checkAndAddAt([]byte("a"), start) {
+		t.Error("first occurrence reported as duplicate")
+	}
+	if !filter.checkAndAddAt([]byte("a"), start.Add(time.Second)) {
+		t.Error("second occurrence not reported as duplicate")
+	}
+	if filter.checkAndAddAt([]byte("b"), start.Add(2*time.Second)) {
+		t.Error("distinct ID reported as duplicate")
+	}
+
+	// "a" expires, "b" does not
+	if filter.checkAndAddAt([]byte("a"), start.Add(11*time.Second)) {
+		t.Error("expired ID reported as duplicate")
+	}
+	if !filter.checkAndAddAt([]byte("b"), start.Add(11*time.Second)) {
+		t.Error("unexpired ID not reported as duplicate")
+	}
+
+	filter.Remove([]byte("b"))
+	if filter.checkAndAddAt([]byte("b"), start.Add(12*time.Second)) {
+		t.Error("removed ID reported as duplicate")
+	}
+
+	// The re-added "b" must survive expiry of its original entry
+	if !filter.checkAndAddAt([]byte("b"), start.Add(13*time.Second)) {
+		t.Error("re-added ID expired with its original entry")
+	}
+
+	if filter.Len() != 2 {
+		t.Errorf("expected 2 remembered IDs, got %d", filter.Len())
+	}
+}
+
+func TestPeekBlockAcceptedID(t *testing.T) {
+	id := GetNonExistentBlockID(1)
+	msg := broadcast.BlockAccepted{
+		Block: &protocol.Block{
+			Id:        id,
+			Header:    &protocol.BlockHeader{Height: 10, Previous: GetEmptyBlockID()},
+			Signature: []byte{1, 2, 3},
+		},
+		Live: true,
+	}
+
+	data, err := proto.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peeked, err := PeekBlockAcceptedID(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(peeked, id) {
+		t.Error("unexpected block ID")
+	}
+
+	if _, err = PeekBlockAcceptedID([]byte{0xff, 0xff}); err == nil {
+		t.Error("expected error for malformed message")
+	}
+
+	data, _ = proto.Marshal(&broadcast.BlockAccepted{Live: true})
+	if _, err = PeekBlockAcceptedID(data); err == nil {
+		t.Error("expected error for missing block")
+	}
+}
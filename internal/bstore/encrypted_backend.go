@@ -0,0 +1,248 @@
+package bstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// EncryptedBackend encrypts values with AES-GCM before storing them in the wrapped backend. Each value
+// is stored as a random nonce followed by the sealed value, authenticated together with its key so
+// values cannot be swapped between keys. Keys are stored as is, they are needed to look values up.
+//
+// Values stored before encryption was enabled cannot be read, it must be enabled on an empty database.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend, backups
+// contain the encrypted values.
+type EncryptedBackend struct {
+	Backend BlockStoreBackend
+
+	aead cipher.AEAD
+}
+
+// NewEncryptedBackend creates an EncryptedBackend over backend. The key must be 16, 24 or 32 bytes long
+// to select AES-128, AES-192 or AES-256.
+func NewEncryptedBackend(backend BlockStoreBackend, key []byte) (*EncryptedBackend, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedBackend{Backend: backend, aead: aead}, nil
+}
+
+// ParseEncryptionKey decodes a hex encoded AES key, surrounding whitespace is ignored
+func ParseEncryptionKey(encoded string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(encoded), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not hex encoded, %w", err)
+	}
+
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes long (was %d)", len(key))
+	}
+
+	return key, nil
+}
+
+// Reset resets the wrapped database
+func (backend *EncryptedBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put encrypts the value and stores it in the wrapped database
+func (backend *EncryptedBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	sealed, err := backend.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return backend.Backend.Put(key, sealed)
+}
+
+// PutWithTTL encrypts the value and stores it in the wrapped database with a TTL
+func (backend *EncryptedBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	sealed, err := backend.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return putWithTTL(backend.Backend, key, sealed, ttl)
+}
+
+// PutBatch encrypts the values and stores them in the wrapped database
+func (backend *EncryptedBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	sealed := make([]*KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		value, err := backend.seal(pair.Key, pair.Value)
+		if err != nil {
+			return err
+		}
+		sealed = append(sealed, &KeyValue{Key: pair.Key, Value: value})
+	}
+
+	return backend.Backend.PutBatch(sealed)
+}
+
+// seal encrypts a value with a random nonce, authenticating its key
+func (backend *EncryptedBackend) seal(key []byte, value []byte) ([]byte, error) {
+	nonce := make([]byte, backend.aead.NonceSize(), backend.aead.NonceSize()+len(value)+backend.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return backend.aead.Seal(nonce, nonce, value, key), nil
+}
+
+// Delete removes an item from the wrapped database
+func (backend *EncryptedBackend) Delete(key []byte) error {
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the wrapped database and decrypts it
+func (backend *EncryptedBackend) Get(key []byte) ([]byte, error) {
+	value, err := backend.Backend.Get(key)
+	if err != nil || len(value) == 0 {
+		return value, err
+	}
+
+	return backend.open(key, value)
+}
+
+// Has reports whether the wrapped database stores key
+func (backend *EncryptedBackend) Has(key []byte) (bool, error) {
+	return backend.Backend.Has(key)
+}
+
+// Iterate calls fn with the keys of the wrapped database starting with prefix and their decrypted
+// values
+func (backend *EncryptedBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, func(key []byte, value []byte) error {
+		if len(value) > 0 {
+			var err error
+			if value, err = backend.open(key, value); err != nil {
+				return err
+			}
+		}
+		return fn(key, value)
+	})
+}
+
+// Snapshot takes a snapshot of the wrapped database, decrypting the values read through it
+func (backend *EncryptedBackend) Snapshot() (BackendSnapshot, error) {
+	snapshot, err := backend.Backend.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueSnapshot{snapshot: snapshot, decode: backend.open}, nil
+}
+
+// Flush flushes the wrapped database
+func (backend *EncryptedBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database, encrypting the values written through it and
+// decrypting those read
+func (backend *EncryptedBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueTxn{txn: txn, encode: backend.seal, decode: backend.open}, nil
+}
+
+// open decrypts a value, authenticating its key
+func (backend *EncryptedBackend) open(key []byte, value []byte) ([]byte, error) {
+	if len(value) < backend.aead.NonceSize()+backend.aead.Overhead() {
+		return nil, errors.New("encrypted value is truncated")
+	}
+
+	nonce := value[:backend.aead.NonceSize()]
+	plaintext, err := backend.aead.Open(nil, nonce, value[len(nonce):], key)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt value, the database may have been written with another key, %w", err)
+	}
+
+	// Open returns nil for an empty value, the other backends return an empty slice
+	if plaintext == nil {
+		plaintext = make([]byte, 0)
+	}
+
+	return plaintext, nil
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *EncryptedBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *EncryptedBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend, values remain encrypted in the backup
+func (backend *EncryptedBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend
+func (backend *EncryptedBackend) Load(r io.Reader) error {
+	return backend.Backend.Load(r)
+}
+
+// BulkLoad replaces the contents of the wrapped database with the records of source, encrypting the
+// values
+func (backend *EncryptedBackend) BulkLoad(source RecordSource) error {
+	return BulkLoad(backend.Backend, mapRecordSource(source, backend.seal))
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *EncryptedBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *EncryptedBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
@@ -0,0 +1,72 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestEncryptedBackendBasic(t *testing.T) {
+	b, err := NewEncryptedBackend(NewMapBackend(), testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendTest(t, b)
+}
+
+func TestEncryptedBackend(t *testing.T) {
+	inner := NewMapBackend()
+	backend, err := NewEncryptedBackend(inner, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 10)
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain, got %+v", resp)
+	}
+
+	value := []byte("koinos block record")
+	if err = backend.Put([]byte("key"), value); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := inner.Get([]byte("key"))
+	if bytes.Contains(raw, value) {
+		t.Error("expected the value to be stored encrypted")
+	}
+
+	// Values are bound to their key
+	if err = inner.Put([]byte("other"), raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backend.Get([]byte("other")); err == nil {
+		t.Error("expected a value moved to another key not to decrypt")
+	}
+
+	wrongKey, err := NewEncryptedBackend(inner, bytes.Repeat([]byte{0x24}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wrongKey.Get([]byte("key")); err == nil {
+		t.Error("expected a value not to decrypt with another key")
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	key, err := ParseEncryptionKey(" 0x000102030405060708090a0b0c0d0e0f\n")
+	if err != nil || len(key) != 16 {
+		t.Errorf("expected a 16 byte key, got %x, %v", key, err)
+	}
+
+	if _, err = ParseEncryptionKey("0001"); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+	if _, err = ParseEncryptionKey("not hex"); err == nil {
+		t.Error("expected a key which is not hex encoded to be rejected")
+	}
+}
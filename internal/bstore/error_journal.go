@@ -0,0 +1,379 @@
+package bstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+const (
+	// ErrorJournalFile is the name of the error journal in the block store directory
+	ErrorJournalFile = "error_journal.jsonl"
+
+	// DefaultErrorSampleInterval is the minimum time between journal entries for the same request and error code
+	DefaultErrorSampleInterval = time.Minute
+
+	maxErrorParamsSize = 256
+)
+
+// ErrorJournalEntry is a sampled error returned by the request handler
+type ErrorJournalEntry struct {
+	Time    time.Time `json:"time"`
+	Request string    `json:"request"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+
+	// Params summarizes the parameters of the failed request
+	Params string `json:"params,omitempty"`
+
+	// Count is the number of errors with the same request and code since the previous entry for them,
+	// including this one
+	Count uint64 `json:"count"`
+}
+
+// ErrorCount is the number of errors with a request and code since the journal was opened
+type ErrorCount struct {
+	Request string    `json:"request"`
+	Code    ErrorCode `json:"code"`
+	Count   uint64    `json:"count"`
+}
+
+type errorKey struct {
+	request string
+	code    ErrorCode
+}
+
+type errorSample struct {
+	last       time.Time
+	suppressed uint64
+	total      uint64
+}
+
+// ErrorJournal records errors returned by the request handler to a bounded JSON lines file, so errors
+// which do not reach the operator logs can be reviewed later. Each request and error code is journaled
+// at most once per SampleInterval, the errors in between are counted in the next entry.
+type ErrorJournal struct {
+	Path string
+
+	// MaxEntries is the number of entries kept
+	MaxEntries int
+
+	SampleInterval time.Duration
+
+	lock    sync.Mutex
+	entries []*ErrorJournalEntry
+	samples map[errorKey]*errorSample
+	file    *os.File
+	lines   int
+	closed  bool
+}
+
+// NewErrorJournal opens the error journal at path, loading the entries it already contains
+func NewErrorJournal(path string, maxEntries int, sampleInterval time.Duration) (*ErrorJournal, error) {
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("error journal size must be greater than 0")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	journal := &ErrorJournal{
+		Path:           path,
+		MaxEntries:     maxEntries,
+		SampleInterval: sampleInterval,
+		samples:        make(map[errorKey]*errorSample),
+	}
+
+	if err := journal.load(); err != nil {
+		return nil, err
+	}
+
+	// Start from a compacted file so it never holds more than twice MaxEntries lines
+	if err := journal.rewrite(); err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// Record journals an error returned for the named request, unless an error with the same request and
+// code was journaled within the sample interval
+func (j *ErrorJournal) Record(request string, params string, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	now := time.Now().UTC()
+	key := errorKey{request: request, code: ErrorCodeOf(err)}
+
+	sample, ok := j.samples[key]
+	if !ok {
+		sample = &errorSample{}
+		j.samples[key] = sample
+	}
+	sample.total++
+
+	if !sample.last.IsZero() && now.Sub(sample.last) < j.SampleInterval {
+		sample.suppressed++
+		return
+	}
+
+	if len(params) > maxErrorParamsSize {
+		params = params[:maxErrorParamsSize] + "..."
+	}
+
+	entry := &ErrorJournalEntry{
+		Time:    now,
+		Request: request,
+		Code:    key.code,
+		Message: err.Error(),
+		Params:  params,
+		Count:   sample.suppressed + 1,
+	}
+	sample.last = now
+	sample.suppressed = 0
+
+	j.entries = append(j.entries, entry)
+	if len(j.entries) > j.MaxEntries {
+		j.entries = j.entries[len(j.entries)-j.MaxEntries:]
+	}
+
+	if werr := j.write(entry); werr != nil {
+		log.Warnf("Unable to write error journal: %s", werr)
+	}
+}
+
+// Entries returns the journaled entries for the request, or for all requests if request is empty,
+// oldest first. At most limit entries are returned, the newest are kept.
+func (j *ErrorJournal) Entries(request string, limit int) []*ErrorJournalEntry {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	entries := make([]*ErrorJournalEntry, 0)
+	for _, entry := range j.entries {
+		if len(request) == 0 || entry.Request == request {
+			entries = append(entries, entry)
+		}
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	return entries
+}
+
+// Counts returns the number of errors for each request and code since the journal was opened
+func (j *ErrorJournal) Counts() []*ErrorCount {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	counts := make([]*ErrorCount, 0, len(j.samples))
+	for key, sample := range j.samples {
+		counts = append(counts, &ErrorCount{Request: key.request, Code: key.code, Count: sample.total})
+	}
+
+	sort.Slice(counts, func(a, b int) bool {
+		if counts[a].Request != counts[b].Request {
+			return counts[a].Request < counts[b].Request
+		}
+		return counts[a].Code < counts[b].Code
+	})
+
+	return counts
+}
+
+// Close closes the journal file
+func (j *ErrorJournal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.closed = true
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *ErrorJournal) load() error {
+	file, err := os.Open(j.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// A line torn by a crash is skipped
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := &ErrorJournalEntry{}
+		if json.Unmarshal(scanner.Bytes(), entry) != nil {
+			continue
+		}
+
+		j.entries = append(j.entries, entry)
+		if len(j.entries) > j.MaxEntries {
+			j.entries = j.entries[1:]
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (j *ErrorJournal) write(entry *ErrorJournalEntry) error {
+	if j.closed {
+		return fmt.Errorf("error journal is closed")
+	}
+
+	// The file is also rewritten to recover from a failed rewrite, which leaves it closed
+	if j.file == nil || j.lines >= 2*j.MaxEntries {
+		return j.rewrite()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err = j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	j.lines++
+	return nil
+}
+
+// rewrite replaces the journal file with the entries in memory
+func (j *ErrorJournal) rewrite() error {
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		j.file = nil
+	}
+
+	tmpPath := j.Path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range j.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if _, err = writer.Write(append(line, '\n')); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+
+	if err = writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, j.Path); err != nil {
+		return err
+	}
+
+	j.file, err = os.OpenFile(j.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	j.lines = len(j.entries)
+	return nil
+}
+
+// GetErrorJournalRequest asks for the journaled errors of the named request, or of all requests if
+// Request is empty. Limit, if set, returns only the newest entries.
+type GetErrorJournalRequest struct {
+	Request string `json:"request,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// GetErrorJournalResponse contains the journaled errors, oldest first, and the error counts since the
+// block store started
+type GetErrorJournalResponse struct {
+	Entries []*ErrorJournalEntry `json:"entries"`
+	Counts  []*ErrorCount        `json:"counts"`
+}
+
+// GetErrorJournal returns the entries of the error journal
+func (handler *RequestHandler) GetErrorJournal(req *GetErrorJournalRequest) (*GetErrorJournalResponse, error) {
+	if handler.ErrorJournal == nil {
+		return nil, &InvalidRequestError{Reason: "error journal is disabled"}
+	}
+
+	return &GetErrorJournalResponse{
+		Entries: handler.ErrorJournal.Entries(req.Request, req.Limit),
+		Counts:  handler.ErrorJournal.Counts(),
+	}, nil
+}
+
+// blockStoreRequestName returns the name of the request set in a block store request
+func blockStoreRequestName(req *block_store.BlockStoreRequest) string {
+	oneof := req.ProtoReflect().Descriptor().Oneofs().ByName("request")
+	if field := req.ProtoReflect().WhichOneof(oneof); field != nil {
+		return string(field.Name())
+	}
+
+	return "none"
+}
+
+// extendedRequestName returns the name of the request set in an extended request, admin requests are
+// prefixed with "admin."
+func extendedRequestName(req *ExtendedRequest) string {
+	if req == nil {
+		return "none"
+	}
+
+	if req.Admin != nil {
+		return "admin." + setFieldName(req.Admin)
+	}
+
+	if name := setFieldName(req); len(name) > 0 {
+		return name
+	}
+
+	return "none"
+}
+
+// summarizeExtendedRequest returns the serialized request without its admin secret
+func summarizeExtendedRequest(req *ExtendedRequest) string {
+	if req == nil {
+		return ""
+	}
+
+	summary := *req
+	if req.Admin != nil {
+		admin := *req.Admin
+		admin.Secret = ""
+		summary.Admin = &admin
+	}
+
+	data, err := json.Marshal(&summary)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
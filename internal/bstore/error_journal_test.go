@@ -0,0 +1,128 @@
+package bstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestErrorJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ErrorJournalFile)
+	journal, err := NewErrorJournal(path, 3, DefaultErrorSampleInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Repeated errors within the sample interval are only counted
+	for i := 0; i < 5; i++ {
+		journal.Record("get_blocks_by_id", "", &BlockNotPresent{})
+	}
+	entries := journal.Entries("", 0)
+	if len(entries) != 1 || entries[0].Count != 1 || entries[0].Code != ErrorCodeBlockNotPresent {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	// The suppressed errors are counted in the next entry
+	journal.SampleInterval = 0
+	journal.Record("get_blocks_by_id", "", &BlockNotPresent{})
+	entries = journal.Entries("get_blocks_by_id", 0)
+	if len(entries) != 2 || entries[1].Count != 5 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	journal.Record("add_block", strings.Repeat("x", 1000), errors.New("disk full"))
+	journal.Record("add_block", "", errors.New("disk full"))
+	if entries = journal.Entries("", 0); len(entries) != 3 || entries[0].Request != "get_blocks_by_id" {
+		t.Fatalf("expected the oldest entries to be dropped, got %+v", entries)
+	}
+	if len(entries[1].Params) > maxErrorParamsSize+3 {
+		t.Errorf("expected params to be truncated, got %d bytes", len(entries[1].Params))
+	}
+	if entries = journal.Entries("", 1); len(entries) != 1 || len(entries[0].Params) != 0 {
+		t.Errorf("expected only the newest entry, got %+v", entries)
+	}
+
+	counts := journal.Counts()
+	if len(counts) != 2 || counts[0].Request != "add_block" || counts[0].Count != 2 || counts[1].Count != 6 {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+
+	// Enough entries to trigger a rewrite of the file
+	for i := 0; i < 10; i++ {
+		journal.Record("get_highest_block", "", &InternalError{})
+	}
+	if err = journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 6 {
+		t.Errorf("expected journal file to be bounded, got %d lines", lines)
+	}
+
+	// A torn line is skipped when the journal is reopened
+	if err = os.WriteFile(path, append(data, []byte(`{"time":`)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journal, err = NewErrorJournal(path, 3, DefaultErrorSampleInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	entries = journal.Entries("", 0)
+	if len(entries) != 3 || entries[2].Request != "get_highest_block" || entries[2].Code != ErrorCodeInternal {
+		t.Errorf("unexpected entries after reopening %+v", entries)
+	}
+	if len(journal.Counts()) != 0 {
+		t.Error("expected counts to start over")
+	}
+}
+
+func TestGetErrorJournal(t *testing.T) {
+	journal, err := NewErrorJournal(filepath.Join(t.TempDir(), ErrorJournalFile), 10, DefaultErrorSampleInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	handler := RequestHandler{Backend: NewMapBackend(), AdminSecret: "secret"}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", GetErrorJournal: &GetErrorJournalRequest{}}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeInvalidRequest {
+		t.Error("expected error with the journal disabled")
+	}
+
+	handler.ErrorJournal = journal
+	handler.HandleRequest(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksById{
+		GetBlocksById: &block_store.GetBlocksByIdRequest{BlockIds: make([][]byte, 2000)},
+	}})
+	handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "wrong", CompactStore: &CompactStoreRequest{}}})
+
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", GetErrorJournal: &GetErrorJournalRequest{}}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+
+	entries := resp.Admin.GetErrorJournal.Entries
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Request != "get_blocks_by_id" || entries[0].Code != ErrorCodeLimitExceeded || !strings.Contains(entries[0].Params, "IDs: 2000") {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+	if entries[1].Request != "admin.compact_store" || entries[1].Code != ErrorCodeUnauthorized || strings.Contains(entries[1].Params, "wrong") {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", GetErrorJournal: &GetErrorJournalRequest{Request: "admin.compact_store"}}})
+	if len(resp.Admin.GetErrorJournal.Entries) != 1 || len(resp.Admin.GetErrorJournal.Counts) != 2 {
+		t.Errorf("unexpected filtered journal %+v", resp.Admin.GetErrorJournal)
+	}
+}
@@ -0,0 +1,212 @@
+package bstore
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ErrorCode identifies the kind of error returned to a caller. Codes are stable across versions, unlike the
+// error message, and are what callers should match on.
+type ErrorCode string
+
+// Error codes returned in error responses
+const (
+	ErrorCodeUnspecified      ErrorCode = "unspecified"
+	ErrorCodeInvalidRequest   ErrorCode = "invalid_request"
+	ErrorCodeMalformedRequest ErrorCode = "malformed_request"
+	ErrorCodeUnknownRequest   ErrorCode = "unknown_request"
+	ErrorCodeReservedRequest  ErrorCode = "reserved_request"
+	ErrorCodeNotImplemented   ErrorCode = "not_implemented"
+	ErrorCodeInternal         ErrorCode = "internal"
+	ErrorCodeBlockNotPresent  ErrorCode = "block_not_present"
+	ErrorCodeDeserialize      ErrorCode = "deserialize"
+	ErrorCodeUnexpectedHeight ErrorCode = "unexpected_height"
+	ErrorCodeHeightMismatch   ErrorCode = "height_mismatch"
+	ErrorCodeBeforeGenesis    ErrorCode = "before_genesis"
+	ErrorCodeBelowCheckpoint  ErrorCode = "below_checkpoint"
+	ErrorCodeSchema           ErrorCode = "incompatible_schema"
+	ErrorCodePruneReferenced  ErrorCode = "prune_referenced"
+	ErrorCodePruneReversible  ErrorCode = "prune_reversible"
+	ErrorCodeMessageTooLarge  ErrorCode = "message_too_large"
+	ErrorCodeUnauthorized     ErrorCode = "unauthorized"
+	ErrorCodeLimitExceeded    ErrorCode = "limit_exceeded"
+	ErrorCodeUnknownFields    ErrorCode = "unknown_fields"
+	ErrorCodeCorruption       ErrorCode = "corruption"
+	ErrorCodeDiskFull         ErrorCode = "disk_full"
+	ErrorCodeReadOnly         ErrorCode = "read_only"
+	ErrorCodeRateLimited      ErrorCode = "rate_limited"
+	ErrorCodeTimeout          ErrorCode = "timeout"
+)
+
+// codedError is implemented by errors which map to an ErrorCode
+type codedError interface {
+	error
+	Code() ErrorCode
+}
+
+// detailedError is implemented by errors which carry detail fields, such as the offending block ID
+type detailedError interface {
+	error
+	Details() map[string]interface{}
+}
+
+// InvalidRequestError is an error type for requests which are well formed but cannot be served as given
+type InvalidRequestError struct {
+	Reason string
+}
+
+func (e *InvalidRequestError) Error() string {
+	return e.Reason
+}
+
+// Code returns the error code
+func (e *InvalidRequestError) Code() ErrorCode {
+	return ErrorCodeInvalidRequest
+}
+
+// MalformedRequestError is an error type for requests which could not be parsed
+type MalformedRequestError struct {
+	Err error
+}
+
+func (e *MalformedRequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Code returns the error code
+func (e *MalformedRequestError) Code() ErrorCode {
+	return ErrorCodeMalformedRequest
+}
+
+func (e *MalformedRequestError) Unwrap() error {
+	return e.Err
+}
+
+// MessageTooLargeError is an error type for responses which exceed the maximum MQ message size
+type MessageTooLargeError struct {
+	Size           int
+	MaxMessageSize int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return "Response would exceed maximum MQ message size"
+}
+
+// Code returns the error code
+func (e *MessageTooLargeError) Code() ErrorCode {
+	return ErrorCodeMessageTooLarge
+}
+
+// Details returns the response size and the limit it exceeded
+func (e *MessageTooLargeError) Details() map[string]interface{} {
+	return map[string]interface{}{
+		"size":             e.Size,
+		"max_message_size": e.MaxMessageSize,
+	}
+}
+
+// LimitExceededError is an error type for requests which ask for more items than the configured limit
+type LimitExceededError struct {
+	Limit     uint64
+	Requested uint64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("cannot request more than %v blocks", e.Limit)
+}
+
+// Code returns the error code
+func (e *LimitExceededError) Code() ErrorCode {
+	return ErrorCodeLimitExceeded
+}
+
+// Details returns the limit and the requested amount
+func (e *LimitExceededError) Details() map[string]interface{} {
+	return map[string]interface{}{"limit": e.Limit, "requested": e.Requested}
+}
+
+// ErrorCodeOf returns the error code for err, or ErrorCodeUnspecified if err does not carry one
+func ErrorCodeOf(err error) ErrorCode {
+	var ce codedError
+	if errors.As(err, &ce) {
+		return ce.Code()
+	}
+
+	return ErrorCodeUnspecified
+}
+
+// ErrorDetails returns the detail fields of err, or nil if it has none
+func ErrorDetails(err error) map[string]interface{} {
+	var de detailedError
+	if errors.As(err, &de) {
+		return de.Details()
+	}
+
+	return nil
+}
+
+// NewErrorStatus converts err to an rpc.ErrorStatus.
+//
+// The message is kept for compatibility. The error code and detail fields are attached as a single
+// google.protobuf.Struct in Details, with the code under the "code" key.
+func NewErrorStatus(err error) *rpc.ErrorStatus {
+	status := &rpc.ErrorStatus{Message: err.Error()}
+
+	fields := map[string]interface{}{"code": string(ErrorCodeOf(err))}
+	for k, v := range ErrorDetails(err) {
+		fields[k] = v
+	}
+
+	// Detail values are limited to what structpb can represent, fall back to the message alone otherwise
+	detail, serr := structpb.NewStruct(fields)
+	if serr != nil {
+		log.Warnf("Could not attach error details: %s", serr.Error())
+		return status
+	}
+
+	packed, serr := anypb.New(detail)
+	if serr != nil {
+		log.Warnf("Could not attach error details: %s", serr.Error())
+		return status
+	}
+
+	status.Details = append(status.Details, packed)
+	return status
+}
+
+// NewExtendedError converts err to an ExtendedError
+func NewExtendedError(err error) *ExtendedError {
+	return &ExtendedError{
+		Message: err.Error(),
+		Code:    ErrorCodeOf(err),
+		Details: ErrorDetails(err),
+	}
+}
+
+// ErrorCodeOfStatus returns the error code attached to an rpc.ErrorStatus by NewErrorStatus, or
+// ErrorCodeUnspecified if there is none
+func ErrorCodeOfStatus(status *rpc.ErrorStatus) ErrorCode {
+	fields := ErrorStatusDetails(status)
+	if code, ok := fields["code"].(string); ok {
+		return ErrorCode(code)
+	}
+
+	return ErrorCodeUnspecified
+}
+
+// ErrorStatusDetails returns the fields attached to an rpc.ErrorStatus by NewErrorStatus, including the code
+func ErrorStatusDetails(status *rpc.ErrorStatus) map[string]interface{} {
+	for _, packed := range status.GetDetails() {
+		detail := &structpb.Struct{}
+		if packed.MessageIs(detail) && packed.UnmarshalTo(detail) == nil {
+			return detail.AsMap()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package bstore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestErrorStatusCodes(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		blocks := makeChainFrom(GetNonExistentBlockID(99), 1000, 2)
+		if err := handler.BootstrapFromCheckpoint(makeCheckpoint(blocks[0])); err != nil {
+			t.Fatal(err)
+		}
+
+		// Missing blocks report the offending block ID
+		missing := GetNonExistentBlockID(5)
+		resp := handler.HandleRequest(&block_store.BlockStoreRequest{
+			Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+				GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{HeadBlockId: missing, AncestorStartHeight: 1000, NumBlocks: 1},
+			},
+		})
+		errval, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error)
+		if !ok {
+			t.Fatal("expected error response")
+		}
+		details := ErrorStatusDetails(errval.Error)
+		if ErrorCode(details["code"].(string)) != ErrorCodeBlockNotPresent {
+			t.Errorf("unexpected error code %v", details["code"])
+		}
+		if details["block_id"] != "0x"+hex.EncodeToString(missing) {
+			t.Errorf("unexpected block ID %v", details["block_id"])
+		}
+
+		// Requests below the checkpoint report the checkpoint height
+		resp = handler.HandleRequest(&block_store.BlockStoreRequest{
+			Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+				GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{HeadBlockId: blocks[0].GetId(), AncestorStartHeight: 999, NumBlocks: 1},
+			},
+		})
+		errval, ok = resp.GetResponse().(*block_store.BlockStoreResponse_Error)
+		if !ok {
+			t.Fatal("expected error response")
+		}
+		details = ErrorStatusDetails(errval.Error)
+		if ErrorCodeOfStatus(errval.Error) != ErrorCodeBelowCheckpoint {
+			t.Errorf("unexpected error code %v", details["code"])
+		}
+		if details["checkpoint_height"] != float64(1000) {
+			t.Errorf("unexpected checkpoint height %v", details["checkpoint_height"])
+		}
+
+		// A nil request is an invalid request
+		resp = handler.HandleRequest(&block_store.BlockStoreRequest{})
+		errval, ok = resp.GetResponse().(*block_store.BlockStoreResponse_Error)
+		if !ok {
+			t.Fatal("expected error response")
+		}
+		if code := ErrorCodeOfStatus(errval.Error); code != ErrorCodeInvalidRequest {
+			t.Errorf("unexpected error code %s", code)
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestExtendedErrorCodes(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{
+		GetTopologyAtHeightRange: &GetTopologyAtHeightRangeRequest{HeadBlockID: GetNonExistentBlockID(7), AncestorStartHeight: 1, NumBlocks: 1},
+	})
+	if resp.Error == nil {
+		t.Fatal("expected error response")
+	}
+	if resp.Error.Code != ErrorCodeBlockNotPresent {
+		t.Errorf("unexpected error code %s", resp.Error.Code)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := ExtendedResponse{}
+	if err = json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Error.Code != ErrorCodeBlockNotPresent || parsed.Error.Details["block_id"] != "0x"+hex.EncodeToString(GetNonExistentBlockID(7)) {
+		t.Errorf("unexpected error %s", string(data))
+	}
+
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeInvalidRequest {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
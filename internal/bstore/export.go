@@ -0,0 +1,329 @@
+package bstore
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcutil/base58"
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	exportChunkSize       = 1000
+	exportParquetParallel = 1
+)
+
+// ExportFormat is the file format of a chain export
+type ExportFormat string
+
+// Export formats
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// ChainExportRow is a row of a canonical chain export
+type ChainExportRow struct {
+	Height               int64  `parquet:"name=height, type=INT64"`
+	ID                   string `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Timestamp            int64  `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Producer             string `parquet:"name=producer, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TransactionCount     int64  `parquet:"name=transaction_count, type=INT64"`
+	Size                 int64  `parquet:"name=size, type=INT64"`
+	DiskStorageUsed      int64  `parquet:"name=disk_storage_used, type=INT64"`
+	NetworkBandwidthUsed int64  `parquet:"name=network_bandwidth_used, type=INT64"`
+	ComputeBandwidthUsed int64  `parquet:"name=compute_bandwidth_used, type=INT64"`
+	EventCount           int64  `parquet:"name=event_count, type=INT64"`
+}
+
+var chainExportColumns = []string{
+	"height",
+	"id",
+	"timestamp",
+	"producer",
+	"transaction_count",
+	"size",
+	"disk_storage_used",
+	"network_bandwidth_used",
+	"compute_bandwidth_used",
+	"event_count",
+}
+
+func (r *ChainExportRow) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(r.Height, 10),
+		r.ID,
+		strconv.FormatInt(r.Timestamp, 10),
+		r.Producer,
+		strconv.FormatInt(r.TransactionCount, 10),
+		strconv.FormatInt(r.Size, 10),
+		strconv.FormatInt(r.DiskStorageUsed, 10),
+		strconv.FormatInt(r.NetworkBandwidthUsed, 10),
+		strconv.FormatInt(r.ComputeBandwidthUsed, 10),
+		strconv.FormatInt(r.EventCount, 10),
+	}
+}
+
+func newChainExportRow(record *block_store.BlockRecord) *ChainExportRow {
+	block := record.GetBlock()
+	receipt := record.GetReceipt()
+
+	return &ChainExportRow{
+		Height:               int64(record.GetBlockHeight()),
+		ID:                   "0x" + hex.EncodeToString(record.GetBlockId()),
+		Timestamp:            int64(block.GetHeader().GetTimestamp()),
+		Producer:             base58.Encode(block.GetHeader().GetSigner()),
+		TransactionCount:     int64(len(block.GetTransactions())),
+		Size:                 int64(proto.Size(block)),
+		DiskStorageUsed:      int64(receipt.GetDiskStorageUsed()),
+		NetworkBandwidthUsed: int64(receipt.GetNetworkBandwidthUsed()),
+		ComputeBandwidthUsed: int64(receipt.GetComputeBandwidthUsed()),
+		EventCount:           int64(len(receipt.GetEvents())),
+	}
+}
+
+type chainRowWriter interface {
+	Write(row *ChainExportRow) error
+	Close() error
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func newCSVRowWriter(w io.Writer) (*csvRowWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(chainExportColumns); err != nil {
+		return nil, err
+	}
+
+	return &csvRowWriter{w: cw}, nil
+}
+
+func (w *csvRowWriter) Write(row *ChainExportRow) error {
+	return w.w.Write(row.csvRecord())
+}
+
+func (w *csvRowWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type parquetRowWriter struct {
+	w *writer.ParquetWriter
+}
+
+func newParquetRowWriter(w io.Writer) (*parquetRowWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(ChainExportRow), exportParquetParallel)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetRowWriter{w: pw}, nil
+}
+
+func (w *parquetRowWriter) Write(row *ChainExportRow) error {
+	return w.w.Write(row)
+}
+
+func (w *parquetRowWriter) Close() error {
+	return w.w.WriteStop()
+}
+
+// ExportChainRequest asks the block store to export the canonical chain ending at HeadBlockID to a
+// file in the configured export directory. An empty HeadBlockID exports the chain of the highest
+// block, an EndHeight of 0 exports up to the head.
+type ExportChainRequest struct {
+	Format      ExportFormat `json:"format"`
+	HeadBlockID HexBytes     `json:"head_block_id,omitempty"`
+	StartHeight uint64       `json:"start_height,omitempty"`
+	EndHeight   uint64       `json:"end_height,omitempty"`
+}
+
+// ExportChainResponse describes a completed export
+type ExportChainResponse struct {
+	Path        string `json:"path"`
+	StartHeight uint64 `json:"start_height"`
+	EndHeight   uint64 `json:"end_height"`
+	Rows        uint64 `json:"rows"`
+}
+
+// ExportChain writes one row per canonical block to a CSV or Parquet file. The chain is read in
+// chunks, so writers are only blocked for the duration of a chunk.
+func (handler *RequestHandler) ExportChain(req *ExportChainRequest) (*ExportChainResponse, error) {
+	if req.Format != ExportCSV && req.Format != ExportParquet {
+		return nil, fmt.Errorf("unknown export format '%s'", req.Format)
+	}
+
+	if len(handler.ExportDir) == 0 {
+		return nil, errors.New("export directory is not configured")
+	}
+
+	if !atomic.CompareAndSwapInt32(&handler.exporting, 0, 1) {
+		return nil, errors.New("export already in progress")
+	}
+	defer atomic.StoreInt32(&handler.exporting, 0)
+
+	headID, startHeight, endHeight, err := handler.exportRange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(handler.ExportDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	tmpFile, err := os.CreateTemp(handler.ExportDir, "chain-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// Once renamed into place, there is no temporary file left to remove
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	var rows chainRowWriter
+	if req.Format == ExportCSV {
+		rows, err = newCSVRowWriter(tmpFile)
+	} else {
+		rows, err = newParquetRowWriter(tmpFile)
+	}
+	if err != nil {
+		_ = tmpFile.Close()
+		return nil, err
+	}
+
+	log.Infof("Exporting canonical chain from height %d to %d as %s", startHeight, endHeight, req.Format)
+
+	resp := &ExportChainResponse{StartHeight: startHeight, EndHeight: endHeight}
+	for chunkStart := startHeight; chunkStart <= endHeight && err == nil; chunkStart += exportChunkSize {
+		chunkEnd := chunkStart + exportChunkSize - 1
+		if chunkEnd > endHeight {
+			chunkEnd = endHeight
+		}
+
+		var chunk []*ChainExportRow
+		chunk, err = handler.exportChunk(headID, chunkStart, chunkEnd)
+		for i := 0; i < len(chunk) && err == nil; i++ {
+			err = rows.Write(chunk[i])
+			resp.Rows++
+		}
+	}
+
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Warnf("Export failed, %s", err.Error())
+		return nil, err
+	}
+
+	resp.Path = filepath.Join(handler.ExportDir, fmt.Sprintf("chain-%d-%d.%s", startHeight, endHeight, req.Format))
+	if err = os.Rename(tmpFile.Name(), resp.Path); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Exported %v block(s) to %s", resp.Rows, resp.Path)
+	return resp, nil
+}
+
+// exportRange resolves the head and height range of an export
+func (handler *RequestHandler) exportRange(req *ExportChainRequest) ([]byte, uint64, uint64, error) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+
+	headID := []byte(req.HeadBlockID)
+	if len(headID) == 0 {
+		highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		headID = highest.GetTopology().GetId()
+	}
+
+	headHeight, err := getBlockHeight(handler.Backend, headID)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	startHeight := req.StartHeight
+	if startHeight == 0 {
+		startHeight = 1
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if checkpoint != nil && startHeight < checkpoint.Height {
+		if req.StartHeight != 0 {
+			return nil, 0, 0, &BelowCheckpoint{checkpoint.Height}
+		}
+		startHeight = checkpoint.Height
+	}
+
+	endHeight := req.EndHeight
+	if endHeight == 0 || endHeight > headHeight {
+		endHeight = headHeight
+	}
+
+	if startHeight > endHeight {
+		return nil, 0, 0, &BlockHeightMismatch{}
+	}
+
+	return headID, startHeight, endHeight, nil
+}
+
+// exportChunk returns the rows of the canonical blocks between startHeight and endHeight, ascending
+func (handler *RequestHandler) exportChunk(headID []byte, startHeight uint64, endHeight uint64) ([]*ChainExportRow, error) {
+	records, err := handler.canonicalRecords(headID, startHeight, endHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*ChainExportRow, len(records))
+	for i, record := range records {
+		rows[i] = newChainExportRow(record)
+	}
+
+	return rows, nil
+}
+
+// canonicalRecords returns the records of the blocks of the chain ending at headID between
+// startHeight and endHeight, ascending
+func (handler *RequestHandler) canonicalRecords(headID []byte, startHeight uint64, endHeight uint64) ([]*block_store.BlockRecord, error) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+
+	blockID, err := getAncestorIDAtHeight(context.Background(), handler.Backend, headID, endHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*block_store.BlockRecord, endHeight-startHeight+1)
+	for i := len(records) - 1; i >= 0; i-- {
+		record, err := handler.getRecord(blockID)
+		if err != nil {
+			return nil, err
+		}
+
+		records[i] = record
+		blockID = record.GetBlock().GetHeader().GetPrevious()
+	}
+
+	return records, nil
+}
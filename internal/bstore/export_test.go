@@ -0,0 +1,93 @@
+package bstore
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestExportChainCSV(t *testing.T) {
+	b := NewBackend(MapBackendType)
+	handler := RequestHandler{Backend: b, ExportDir: t.TempDir(), AdminAllowlist: []string{"export_chain"}}
+	bt := buildLinearChain(t, &handler, 2500)
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{ExportChain: &ExportChainRequest{Format: ExportCSV, StartHeight: 10}}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.Admin.ExportChain.Rows != 2491 || resp.Admin.ExportChain.EndHeight != 2500 {
+		t.Errorf("unexpected export %+v", resp.Admin.ExportChain)
+	}
+
+	f, err := os.Open(resp.Admin.ExportChain.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2492 || records[0][0] != "height" {
+		t.Fatalf("expected header and 2491 rows, got %d records", len(records))
+	}
+
+	// Rows are in ascending height order across chunks
+	for i, record := range records[1:] {
+		height := uint64(10 + i)
+		if record[0] != strconv.FormatUint(height, 10) || record[1] != "0x"+hex.EncodeToString(bt.ByNum[100+height].GetId()) {
+			t.Fatalf("unexpected row %d: %v", i, record)
+		}
+	}
+
+	if _, err = handler.ExportChain(&ExportChainRequest{Format: "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	CloseBackend(b)
+}
+
+func TestExportChainParquet(t *testing.T) {
+	b := NewBackend(MapBackendType)
+	handler := RequestHandler{Backend: b, ExportDir: t.TempDir()}
+	bt := buildLinearChain(t, &handler, 20)
+
+	resp, err := handler.ExportChain(&ExportChainRequest{Format: ExportParquet, EndHeight: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := local.NewLocalFileReader(resp.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pr, err := reader.NewParquetReader(file, new(ChainExportRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	if pr.GetNumRows() != 15 {
+		t.Fatalf("expected 15 rows, got %d", pr.GetNumRows())
+	}
+
+	rows := make([]ChainExportRow, 15)
+	if err = pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+
+	last := rows[14]
+	if last.Height != 15 || last.ID != "0x"+hex.EncodeToString(bt.ByNum[115].GetId()) || last.Timestamp != 15 {
+		t.Errorf("unexpected last row %+v", last)
+	}
+
+	CloseBackend(b)
+}
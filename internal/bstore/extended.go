@@ -0,0 +1,227 @@
+package bstore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// HexBytes is a byte slice which is represented in JSON as a 0x prefixed hex string
+type HexBytes []byte
+
+// MarshalJSON implements json.Marshaler
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal("0x" + hex.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+// ExtendedRequest is the envelope for block store requests that are not part of the koinos-proto
+// block_store RPC definitions. It is served as JSON over the block_store_ext RPC.
+//
+// Exactly one request field must be set.
+type ExtendedRequest struct {
+	VerifyChainLinks         *VerifyChainLinksRequest         `json:"verify_chain_links,omitempty"`
+	GetTopologyAtHeightRange *GetTopologyAtHeightRangeRequest `json:"get_topology_at_height_range,omitempty"`
+	GetOrphanedBlocks        *GetOrphanedBlocksRequest        `json:"get_orphaned_blocks,omitempty"`
+	GetBlockMetadata         *GetBlockMetadataRequest         `json:"get_block_metadata,omitempty"`
+	GetRecentBlocks          *GetRecentBlocksRequest          `json:"get_recent_blocks,omitempty"`
+	GetBlocksByIDPaged       *GetBlocksByIDPagedRequest       `json:"get_blocks_by_id_paged,omitempty"`
+	GetHead                  *GetHeadRequest                  `json:"get_head,omitempty"`
+	GetPayerTransactions     *GetPayerTransactionsRequest     `json:"get_payer_transactions,omitempty"`
+	HasBlocks                *HasBlocksRequest                `json:"has_blocks,omitempty"`
+
+	Admin *AdminRequest `json:"admin,omitempty"`
+
+	GetMessageSchemaStats *GetMessageSchemaStatsRequest `json:"get_message_schema_stats,omitempty"`
+	GetCapabilities       *GetCapabilitiesRequest       `json:"get_capabilities,omitempty"`
+	GetStatus             *GetStatusRequest             `json:"get_status,omitempty"`
+	GetHealth             *GetHealthRequest             `json:"get_health,omitempty"`
+	GetStoreInfo          *GetStoreInfoRequest          `json:"get_store_info,omitempty"`
+}
+
+// ExtendedResponse is the envelope for the result of an ExtendedRequest.
+//
+// Either Error or the field matching the request is set. ChainID is set on every response if the block
+// store has a configured chain ID.
+type ExtendedResponse struct {
+	ChainID HexBytes `json:"chain_id,omitempty"`
+
+	Error *ExtendedError `json:"error,omitempty"`
+
+	VerifyChainLinks         *VerifyChainLinksResponse         `json:"verify_chain_links,omitempty"`
+	GetTopologyAtHeightRange *GetTopologyAtHeightRangeResponse `json:"get_topology_at_height_range,omitempty"`
+	GetOrphanedBlocks        *GetOrphanedBlocksResponse        `json:"get_orphaned_blocks,omitempty"`
+	GetBlockMetadata         *GetBlockMetadataResponse         `json:"get_block_metadata,omitempty"`
+	GetRecentBlocks          *GetRecentBlocksResponse          `json:"get_recent_blocks,omitempty"`
+	GetBlocksByIDPaged       *GetBlocksByIDPagedResponse       `json:"get_blocks_by_id_paged,omitempty"`
+	GetHead                  *GetHeadResponse                  `json:"get_head,omitempty"`
+	GetPayerTransactions     *GetPayerTransactionsResponse     `json:"get_payer_transactions,omitempty"`
+	HasBlocks                *HasBlocksResponse                `json:"has_blocks,omitempty"`
+
+	Admin *AdminResponse `json:"admin,omitempty"`
+
+	GetMessageSchemaStats *GetMessageSchemaStatsResponse `json:"get_message_schema_stats,omitempty"`
+	GetCapabilities       *GetCapabilitiesResponse       `json:"get_capabilities,omitempty"`
+	GetStatus             *GetStatusResponse             `json:"get_status,omitempty"`
+	GetHealth             *GetHealthResponse             `json:"get_health,omitempty"`
+	GetStoreInfo          *GetStoreInfoResponse          `json:"get_store_info,omitempty"`
+}
+
+// ExtendedError is the error returned in an ExtendedResponse
+type ExtendedError struct {
+	Message string                 `json:"message"`
+	Code    ErrorCode              `json:"code"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// HandleExtendedRequest handles and routes extended blockstore requests
+func (handler *RequestHandler) HandleExtendedRequest(req *ExtendedRequest) *ExtendedResponse {
+	return handler.HandleExtendedRequestFrom("", req)
+}
+
+// HandleExtendedRequestFrom handles an extended blockstore request of client, the reply-to queue of
+// the RPC request, whose requests are rate limited together with its blockstore requests
+func (handler *RequestHandler) HandleExtendedRequestFrom(client string, req *ExtendedRequest) *ExtendedResponse {
+	start := time.Now()
+	response := ExtendedResponse{}
+	var err error
+
+	if req == nil || countSetFields(req) == 0 {
+		err = &InvalidRequestError{Reason: "expected request was nil"}
+	} else if countSetFields(req) > 1 {
+		err = &InvalidRequestError{Reason: "only one request may be set"}
+	} else if limited := handler.checkExtendedRateLimit(client, req); limited != nil {
+		err = limited
+	} else {
+		// Admin requests may change the database, and health checks must not wait for a query
+		bounded := req.Admin == nil && req.GetHealth == nil
+
+		err = handler.withTimeout(extendedRequestName(req), bounded, func(ctx context.Context) error {
+			result, err := handler.dispatchExtendedRequest(ctx, req)
+			if err == nil {
+				response = *result
+			}
+			return err
+		})
+	}
+
+	handler.Metrics.recordRequest(extendedRequestName(req), time.Since(start), err)
+
+	if err != nil {
+		if handler.ErrorJournal != nil {
+			handler.ErrorJournal.Record(extendedRequestName(req), summarizeExtendedRequest(req), err)
+		}
+
+		return &ExtendedResponse{ChainID: handler.ChainID, Error: NewExtendedError(err)}
+	}
+
+	response.ChainID = handler.ChainID
+	handler.truncateExtendedResponse(&response)
+	return &response
+}
+
+// dispatchExtendedRequest serves a request which has a single field set, taking the lock it needs.
+// Queries stop once ctx is done.
+func (handler *RequestHandler) dispatchExtendedRequest(ctx context.Context, req *ExtendedRequest) (*ExtendedResponse, error) {
+	response := ExtendedResponse{}
+	var err error
+
+	switch {
+	case req.VerifyChainLinks != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.VerifyChainLinks, err = handler.VerifyChainLinks(ctx, req.VerifyChainLinks)
+	case req.GetTopologyAtHeightRange != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetTopologyAtHeightRange, err = handler.GetTopologyAtHeightRange(ctx, req.GetTopologyAtHeightRange)
+	case req.GetOrphanedBlocks != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetOrphanedBlocks, err = handler.GetOrphanedBlocks(ctx, req.GetOrphanedBlocks)
+	case req.GetBlockMetadata != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetBlockMetadata, err = handler.GetBlockMetadata(ctx, req.GetBlockMetadata)
+	case req.GetRecentBlocks != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetRecentBlocks, err = handler.GetRecentBlocks(ctx, req.GetRecentBlocks)
+	case req.GetBlocksByIDPaged != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetBlocksByIDPaged, err = handler.GetBlocksByIDPaged(ctx, req.GetBlocksByIDPaged)
+	case req.GetHead != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetHead, err = handler.GetHead(req.GetHead)
+	case req.GetPayerTransactions != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetPayerTransactions, err = handler.GetPayerTransactions(ctx, req.GetPayerTransactions)
+	case req.HasBlocks != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.HasBlocks, err = handler.HasBlocks(ctx, req.HasBlocks)
+	case req.Admin != nil:
+		// Admin requests take the locks they need
+		response.Admin, err = handler.HandleAdminRequest(req.Admin)
+	case req.GetMessageSchemaStats != nil:
+		response.GetMessageSchemaStats, err = handler.GetMessageSchemaStats(req.GetMessageSchemaStats)
+	case req.GetCapabilities != nil:
+		response.GetCapabilities, err = handler.GetCapabilities(req.GetCapabilities)
+	case req.GetStatus != nil:
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		response.GetStatus, err = handler.GetStatus(req.GetStatus)
+	case req.GetHealth != nil:
+		// Health is not blocked by long running writes such as a restore
+		response.GetHealth, err = handler.GetHealth(req.GetHealth)
+	case req.GetStoreInfo != nil:
+		response.GetStoreInfo, err = handler.GetStoreInfo(req.GetStoreInfo)
+	default:
+		err = &UnknownReqError{}
+	}
+
+	return &response, err
+}
+
+func countSetFields(req interface{}) int {
+	count := 0
+	v := reflect.ValueOf(req).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Ptr && !v.Field(i).IsNil() {
+			count++
+		}
+	}
+
+	return count
+}
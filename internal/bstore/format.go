@@ -0,0 +1,156 @@
+package bstore
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// The format version recorded in the database identifies the layout of its records. Each change to
+// the layout increments FormatVersion and registers a migration upgrading older databases in place.
+// A database without a recorded version predates the record and has version 0 or 1.
+
+// FormatVersion is the version of the database format this binary reads and writes
+const FormatVersion = 2
+
+// formatMigration upgrades the records of a database to a format version
+type formatMigration struct {
+	Version uint64
+	Name    string
+
+	// Needed returns true if the database holds records the migration rewrites. Databases without
+	// such records, new ones included, only have their version recorded.
+	Needed func(backend BlockStoreBackend) (bool, error)
+
+	// Run rewrites the records, returning the number of records rewritten. It must resume where it
+	// stopped when run again after an interruption.
+	Run func(handler *RequestHandler) (uint64, error)
+}
+
+// formatMigrations are the migrations of the database format in ascending version order
+var formatMigrations = []*formatMigration{
+	{Version: 2, Name: KeyspaceMigrationName, Needed: hasLegacyBlockRecords, Run: (*RequestHandler).migrateKeyspace},
+}
+
+// FormatVersionError is returned for a database written with a newer format than this binary reads
+type FormatVersionError struct {
+	Version uint64
+}
+
+func (e *FormatVersionError) Error() string {
+	return fmt.Sprintf("database format version %d is newer than the supported version %d", e.Version, FormatVersion)
+}
+
+// Code returns the error code
+func (e *FormatVersionError) Code() ErrorCode {
+	return ErrorCodeSchema
+}
+
+// Details returns the format version of the database and of this binary
+func (e *FormatVersionError) Details() map[string]interface{} {
+	return map[string]interface{}{"version": e.Version, "supported_version": FormatVersion}
+}
+
+// MigrationResult describes a migration run on the database
+type MigrationResult struct {
+	Name    string `json:"name"`
+	Version uint64 `json:"version"`
+	Records uint64 `json:"records"`
+}
+
+// getFormatVersion returns the format version recorded in the database, 0 if none is recorded
+func getFormatVersion(backend BlockStoreBackend) (uint64, error) {
+	value, err := backend.Get([]byte{formatVersionKey})
+	if err != nil || len(value) == 0 {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("format version record corrupted")
+	}
+
+	return binary.BigEndian.Uint64(value), nil
+}
+
+func putFormatVersion(backend BlockStoreBackend, version uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, version)
+	return backend.Put([]byte{formatVersionKey}, value)
+}
+
+// pendingMigrations returns the format version of the database and the migrations it needs, or a
+// FormatVersionError if it was written with a newer format
+func pendingMigrations(backend BlockStoreBackend) (uint64, []*formatMigration, error) {
+	version, err := getFormatVersion(backend)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > FormatVersion {
+		return version, nil, &FormatVersionError{Version: version}
+	}
+
+	var pending []*formatMigration
+	for _, m := range formatMigrations {
+		if version >= m.Version {
+			continue
+		}
+
+		needed, err := m.Needed(backend)
+		if err != nil {
+			return version, nil, err
+		}
+		if needed {
+			pending = append(pending, m)
+		}
+	}
+
+	return version, pending, nil
+}
+
+// PendingMigrations returns the names of the migrations Migrate would run on the database, or a
+// FormatVersionError if it was written with a newer format. It does not write to the database.
+func (handler *RequestHandler) PendingMigrations() ([]string, error) {
+	_, pending, err := pendingMigrations(handler.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, m := range pending {
+		names = append(names, m.Name)
+	}
+
+	return names, nil
+}
+
+// Migrate upgrades the database to the current format version in place, running the migrations it
+// needs in version order and recording the version reached after each, then records FormatVersion.
+// A database written with a newer format is refused with a FormatVersionError. It is meant to run
+// before requests are served.
+func (handler *RequestHandler) Migrate() ([]*MigrationResult, error) {
+	version, pending, err := pendingMigrations(handler.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*MigrationResult{}
+	for _, m := range pending {
+		log.Infof("Migrating the database to format version %d (%s)", m.Version, m.Name)
+		records, err := m.Run(handler)
+		if err != nil {
+			return results, fmt.Errorf("migration %s failed, %w", m.Name, err)
+		}
+		if err = putFormatVersion(handler.Backend, m.Version); err != nil {
+			return results, err
+		}
+		results = append(results, &MigrationResult{Name: m.Name, Version: m.Version, Records: records})
+	}
+
+	if version != FormatVersion {
+		if err = putFormatVersion(handler.Backend, FormatVersion); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,67 @@
+package bstore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	b := NewMapBackend()
+	handler := RequestHandler{Backend: b}
+
+	// A new database has nothing to migrate and only has its version recorded
+	pending, err := handler.PendingMigrations()
+	if err != nil || len(pending) != 0 {
+		t.Errorf("expected no pending migrations, got %v, %v", pending, err)
+	}
+	results, err := handler.Migrate()
+	if err != nil || len(results) != 0 {
+		t.Errorf("expected no migrations, got %+v, %v", results, err)
+	}
+	if version, _ := getFormatVersion(b); version != FormatVersion {
+		t.Errorf("expected format version %d, got %d", FormatVersion, version)
+	}
+
+	// A migration is not run on a database recorded at its version
+	ids := buildSHA256Chain(t, &handler, 5)
+	value, err := b.Get(blockRecordKey(ids[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Put(ids[0], value); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = handler.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Errorf("expected no pending migrations at the current version, got %v, %v", pending, err)
+	}
+
+	if err = putFormatVersion(b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = handler.PendingMigrations(); err != nil || len(pending) != 1 || pending[0] != KeyspaceMigrationName {
+		t.Errorf("expected the keyspace migration, got %v, %v", pending, err)
+	}
+	if results, err = handler.Migrate(); err != nil || len(results) != 1 || results[0].Version != 2 {
+		t.Errorf("expected the keyspace migration to run, got %+v, %v", results, err)
+	}
+
+	// A database written by a newer block store is refused
+	if err = putFormatVersion(b, FormatVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	var versionErr *FormatVersionError
+	if _, err = handler.Migrate(); !errors.As(err, &versionErr) || versionErr.Version != FormatVersion+1 {
+		t.Errorf("expected a format version error, got %v", err)
+	}
+	if _, err = handler.PendingMigrations(); !errors.As(err, &versionErr) {
+		t.Errorf("expected a format version error, got %v", err)
+	}
+
+	report, err := handler.CheckCompatibility()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Compatible || report.FormatVersion != FormatVersion+1 {
+		t.Errorf("expected an incompatible store at format version %d, got %+v", FormatVersion+1, report)
+	}
+}
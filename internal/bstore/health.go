@@ -0,0 +1,75 @@
+package bstore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type healthBackend interface {
+	Health() (*BackendHealth, error)
+}
+
+// BackendHealth reports the state of a backend's storage
+type BackendHealth struct {
+	Writable bool
+
+	// LastWrite is the time of the last successful write since the backend was opened, nil if none
+	LastWrite *time.Time
+
+	// PendingCompactions is the number of LSM levels which are due for compaction
+	PendingCompactions int
+
+	// DiskFree is the free space in bytes on the database volume, nil for in-memory backends
+	DiskFree *uint64
+
+	// DiskFull is set while writes are refused because the free space is below the minimum
+	DiskFull bool
+}
+
+// GetHealthRequest asks whether the block store is able to serve and store blocks
+type GetHealthRequest struct {
+}
+
+// GetHealthResponse reports the health of the block store backend. Fields the backend cannot report
+// are null, a backend which does not report its health is assumed to be writable.
+type GetHealthResponse struct {
+	Writable           bool       `json:"writable"`
+	LastWrite          *time.Time `json:"last_write"`
+	PendingCompactions int        `json:"pending_compactions"`
+	DiskFreeBytes      *uint64    `json:"disk_free_bytes"`
+	DiskFull           bool       `json:"disk_full"`
+
+	// Compacting is set while a compact_store admin request is running
+	Compacting bool `json:"compacting"`
+
+	// HeadStale is set if the highest block has not advanced for longer than the stale head duration
+	HeadStale bool `json:"head_stale"`
+}
+
+// GetHealth returns the health of the block store backend. It does not read the database, so it is
+// cheap enough to be polled as a liveness and readiness probe.
+func (handler *RequestHandler) GetHealth(req *GetHealthRequest) (*GetHealthResponse, error) {
+	resp := &GetHealthResponse{
+		Writable:   true,
+		Compacting: atomic.LoadInt32(&handler.compacting) != 0,
+	}
+	_, _, resp.HeadStale = handler.headStaleness()
+
+	backend, ok := handler.Backend.(healthBackend)
+	if !ok {
+		return resp, nil
+	}
+
+	health, err := backend.Health()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Writable = health.Writable
+	resp.LastWrite = health.LastWrite
+	resp.PendingCompactions = health.PendingCompactions
+	resp.DiskFreeBytes = health.DiskFree
+	resp.DiskFull = health.DiskFull
+
+	return resp, nil
+}
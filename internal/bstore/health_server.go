@@ -0,0 +1,210 @@
+package bstore
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+const (
+	// DefaultAMQPCheckInterval is the default interval between checks of the AMQP connection
+	DefaultAMQPCheckInterval = 10 * time.Second
+
+	amqpCheckTimeout = 5 * time.Second
+)
+
+// HealthReport is served by the health endpoints
+type HealthReport struct {
+	// OK is set if the check of the endpoint passed, otherwise Problems explains why not
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems,omitempty"`
+
+	// Started is set once the block store serves requests, after the database is opened and migrated
+	// and the AMQP connections are established
+	Started bool `json:"started"`
+
+	// AMQPConnected is set if the last request sent through the AMQP server was answered
+	AMQPConnected bool       `json:"amqp_connected"`
+	AMQPCheckedAt *time.Time `json:"amqp_checked_at"`
+
+	Writable       bool      `json:"writable"`
+	DiskFull       bool      `json:"disk_full"`
+	HeadStale      bool      `json:"head_stale"`
+	HeadAdvancedAt time.Time `json:"head_advanced_at"`
+}
+
+// HealthServer serves the health of the block store over HTTP, for probes which cannot send requests
+// over AMQP. /healthz reports whether the process is alive, it fails only if the database cannot
+// serve writes for another reason than a full disk, which a restart may fix. /readyz reports whether
+// the block store is serving: it fails until the block store has started, and while the AMQP server
+// cannot be reached, the database does not accept writes or the head is stale.
+//
+// The AMQP connection is checked every CheckInterval by sending a get_health request through the
+// AMQP server to the block store, so the check covers the connections of both the client and the
+// request handler. The endpoints report the result of the last check, they do not wait on AMQP.
+type HealthServer struct {
+	Handler *RequestHandler
+	Client  RPCClient
+
+	// CheckInterval is the interval between checks of the AMQP connection, 0 uses
+	// DefaultAMQPCheckInterval
+	CheckInterval time.Duration
+
+	started int32
+
+	mu            sync.Mutex
+	amqpConnected bool
+	amqpCheckedAt *time.Time
+}
+
+// Listen binds addr and serves the health endpoints on it for the lifetime of the process. The
+// address is bound before returning, so a bad address is reported at startup.
+func (s *HealthServer) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Serving health checks at http://%s/healthz and /readyz", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, s); err != nil {
+			log.Warnf("Health server stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// Start marks the block store as started and checks the AMQP connection every CheckInterval until
+// ctx is done
+func (s *HealthServer) Start(ctx context.Context) {
+	atomic.StoreInt32(&s.started, 1)
+
+	interval := s.CheckInterval
+	if interval <= 0 {
+		interval = DefaultAMQPCheckInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.checkAMQP(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkAMQP sends a get_health request through the AMQP server and records whether it was answered
+func (s *HealthServer) checkAMQP(ctx context.Context) {
+	connected := false
+	if s.Client != nil {
+		err := pingAMQP(ctx, s.Client, amqpCheckTimeout)
+		if err != nil {
+			log.Debugf("AMQP health check failed: %s", err)
+		}
+		connected = err == nil
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	if s.amqpConnected && !connected {
+		log.Warn("AMQP health check failed, reporting not ready")
+	}
+	s.amqpConnected = connected
+	s.amqpCheckedAt = &now
+	s.mu.Unlock()
+}
+
+// Check returns the liveness report if ready is false, the readiness report otherwise
+func (s *HealthServer) Check(ready bool) *HealthReport {
+	report := &HealthReport{Started: atomic.LoadInt32(&s.started) != 0}
+
+	s.mu.Lock()
+	report.AMQPConnected = s.amqpConnected
+	report.AMQPCheckedAt = s.amqpCheckedAt
+	s.mu.Unlock()
+
+	report.HeadAdvancedAt, _, report.HeadStale = s.Handler.headStaleness()
+
+	health, err := s.Handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		report.Problems = append(report.Problems, "database health cannot be read, "+err.Error())
+	} else {
+		report.Writable = health.Writable
+		report.DiskFull = health.DiskFull
+		if !health.Writable && !health.DiskFull {
+			report.Problems = append(report.Problems, "database does not accept writes")
+		}
+	}
+
+	if ready {
+		if !report.Started {
+			report.Problems = append(report.Problems, "block store is starting")
+		} else if !report.AMQPConnected {
+			report.Problems = append(report.Problems, "AMQP server cannot be reached")
+		}
+		if report.DiskFull {
+			report.Problems = append(report.Problems, "disk is full")
+		}
+		if report.HeadStale {
+			report.Problems = append(report.Problems, "head is stale")
+		}
+	}
+
+	report.OK = len(report.Problems) == 0
+	return report
+}
+
+// ServeHTTP serves /healthz and /readyz, with status 200 if the check passed and 503 otherwise
+func (s *HealthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var report *HealthReport
+	switch r.URL.Path {
+	case "/healthz":
+		report = s.Check(false)
+	case "/readyz":
+		report = s.Check(true)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(data)
+}
+
+// pingAMQP sends a get_health request through the AMQP server to the block store and waits up to
+// timeout for the response
+func pingAMQP(ctx context.Context, client RPCClient, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := json.Marshal(&ExtendedRequest{GetHealth: &GetHealthRequest{}})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.RPC(ctx, remoteContentType, RemoteRPC, req)
+	return err
+}
@@ -0,0 +1,76 @@
+package bstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	koinosmq "github.com/koinos/koinos-mq-golang"
+)
+
+// unreachableClient fails every RPC request, like a client without a connection to the AMQP server
+type unreachableClient struct{}
+
+func (c *unreachableClient) RPC(ctx context.Context, contentType koinosmq.ContentType, rpcService string, args []byte) ([]byte, error) {
+	return nil, errors.New("connection closed")
+}
+
+func getHealthReport(t *testing.T, server *HealthServer, path string) (int, *HealthReport) {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	report := &HealthReport{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), report); err != nil {
+		t.Fatalf("could not parse %s response %q, %v", path, recorder.Body.String(), err)
+	}
+
+	return recorder.Code, report
+}
+
+func TestHealthServer(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	buildLinearChain(t, &handler, 5)
+	server := &HealthServer{Handler: &handler, Client: &loopbackClient{handler: &handler}}
+
+	// A starting block store is alive but not ready
+	if code, report := getHealthReport(t, server, "/healthz"); code != http.StatusOK || !report.OK {
+		t.Errorf("expected a live block store, got %d %+v", code, report)
+	}
+	if code, report := getHealthReport(t, server, "/readyz"); code != http.StatusServiceUnavailable || report.Started {
+		t.Errorf("expected a starting block store not to be ready, got %d %+v", code, report)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.started = 1
+	server.checkAMQP(ctx)
+
+	code, report := getHealthReport(t, server, "/readyz")
+	if code != http.StatusOK || !report.OK || !report.AMQPConnected || report.AMQPCheckedAt == nil || !report.Writable {
+		t.Errorf("expected a ready block store, got %d %+v", code, report)
+	}
+
+	// An unreachable AMQP server and a stale head fail readiness, not liveness
+	server.Client = &unreachableClient{}
+	server.checkAMQP(ctx)
+	handler.StaleHeadAfter = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	code, report = getHealthReport(t, server, "/readyz")
+	if code != http.StatusServiceUnavailable || report.AMQPConnected || !report.HeadStale || len(report.Problems) != 2 {
+		t.Errorf("expected a block store which is not ready, got %d %+v", code, report)
+	}
+	if code, report = getHealthReport(t, server, "/healthz"); code != http.StatusOK || !report.OK {
+		t.Errorf("expected a live block store, got %d %+v", code, report)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected other paths not to be found, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,51 @@
+package bstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetHealth(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetHealth: &GetHealthRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if !resp.GetHealth.Writable || resp.GetHealth.LastWrite != nil || resp.GetHealth.DiskFreeBytes != nil {
+		t.Errorf("unexpected health of map backend %+v", resp.GetHealth)
+	}
+
+	b := NewBackend(BadgerBackendType)
+	handler = RequestHandler{Backend: b}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Writable || health.LastWrite != nil {
+		t.Errorf("unexpected health of new database %+v", health)
+	}
+	if health.DiskFreeBytes == nil || *health.DiskFreeBytes == 0 {
+		t.Error("expected free disk space to be reported")
+	}
+
+	before := time.Now()
+	buildLinearChain(t, &handler, 5)
+
+	health, err = handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.LastWrite == nil || health.LastWrite.Before(before) {
+		t.Errorf("expected last write after %v, got %v", before, health.LastWrite)
+	}
+
+	CloseBackend(b)
+	health, err = handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Writable {
+		t.Error("expected closed database not to be writable")
+	}
+}
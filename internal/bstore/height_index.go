@@ -0,0 +1,77 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The height index maps each height to the IDs of all blocks stored at that height, including blocks
+// on forks. Blocks added before the index existed are not indexed.
+
+func heightIndexKey(height uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = heightIndexPrefix
+	binary.BigEndian.PutUint64(key[1:], height)
+	return key
+}
+
+// getHeightIndex returns the IDs of the blocks stored at the given height
+func getHeightIndex(backend BlockStoreBackend, height uint64) ([][]byte, error) {
+	value, err := backend.Get(heightIndexKey(height))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHeightIndex(value)
+}
+
+// decodeHeightIndex decodes the block IDs of a height index record
+func decodeHeightIndex(value []byte) ([][]byte, error) {
+	var ids [][]byte
+	for len(value) > 0 {
+		id, n := protowire.ConsumeBytes(value)
+		if n < 0 {
+			return nil, errors.New("height index record corrupted")
+		}
+		ids = append(ids, id)
+		value = value[n:]
+	}
+
+	return ids, nil
+}
+
+// addToHeightIndex records a block ID at the given height. Adding an indexed ID again is a no-op.
+func addToHeightIndex(backend BlockStoreBackend, height uint64, blockID []byte) error {
+	ids, err := getHeightIndex(backend, height)
+	if err != nil {
+		return err
+	}
+
+	var value []byte
+	for _, id := range ids {
+		if bytes.Equal(id, blockID) {
+			return nil
+		}
+		value = protowire.AppendBytes(value, id)
+	}
+	value = protowire.AppendBytes(value, blockID)
+
+	return backend.Put(heightIndexKey(height), value)
+}
+
+// putHeightIndex replaces the block IDs recorded at the given height, removing the record if there are none
+func putHeightIndex(backend BlockStoreBackend, height uint64, ids [][]byte) error {
+	if len(ids) == 0 {
+		return backend.Delete(heightIndexKey(height))
+	}
+
+	var value []byte
+	for _, id := range ids {
+		value = protowire.AppendBytes(value, id)
+	}
+
+	return backend.Put(heightIndexKey(height), value)
+}
@@ -0,0 +1,399 @@
+package bstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	// DefaultHybridFileThreshold is the size from which block records are written to their own file
+	DefaultHybridFileThreshold = 64 << 10
+
+	hybridMetaDir   = "meta"
+	hybridBlocksDir = "blocks"
+
+	hybridInline = 0x00
+	hybridFile   = 0x01
+)
+
+// HybridBackend keeps metadata and small records in a Badger database and writes large block records
+// to individual files, named after the SHA-256 hash of their content. Multi-megabyte blocks are then
+// written once instead of being rewritten by LSM compactions, and as block files never change, the
+// blocks directory can be backed up with rsync.
+//
+// Block files are verified against their name on read. A block record only matches its own key, so
+// a block file is never shared between keys and is removed when its record is deleted or
+// overwritten.
+type HybridBackend struct {
+	Meta *BadgerBackend
+	Dir  string
+
+	// FileThreshold is the size from which block records are written to their own file
+	FileThreshold int
+
+	// lock orders the file writes and removals with their meta records
+	lock sync.Mutex
+}
+
+// NewHybridBackend opens the meta database and block files in dir, creating them if missing. A
+// fileThreshold of 0 uses DefaultHybridFileThreshold.
+func NewHybridBackend(dir string, fileThreshold int, opts badger.Options) (*HybridBackend, error) {
+	if fileThreshold <= 0 {
+		fileThreshold = DefaultHybridFileThreshold
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, hybridBlocksDir), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	opts.Dir = filepath.Join(dir, hybridMetaDir)
+	opts.ValueDir = opts.Dir
+	meta, err := NewBadgerBackend(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HybridBackend{Meta: meta, Dir: dir, FileThreshold: fileThreshold}, nil
+}
+
+// blockPath returns the path of the block file with the given hash, files are spread over 256
+// directories by the first byte of their hash
+func (backend *HybridBackend) blockPath(hash []byte) string {
+	name := hex.EncodeToString(hash)
+	return filepath.Join(backend.Dir, hybridBlocksDir, name[:2], name)
+}
+
+// Close cleans backend resources
+func (backend *HybridBackend) Close() {
+	backend.Meta.Close()
+}
+
+// Reset removes all block files and resets the meta database
+func (backend *HybridBackend) Reset() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if err := backend.Meta.Reset(); err != nil {
+		return err
+	}
+
+	blocksDir := filepath.Join(backend.Dir, hybridBlocksDir)
+	if err := os.RemoveAll(blocksDir); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(blocksDir, os.ModePerm)
+}
+
+// Put writes large block records to a block file and records its hash in the meta database. Other
+// values are stored in the meta database.
+func (backend *HybridBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	previous, err := backend.fileHash(key)
+	if err != nil {
+		return err
+	}
+
+	entry, err := backend.entry(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err = backend.Meta.Put(key, entry); err != nil {
+		return err
+	}
+
+	return backend.removeReplaced(previous, entry)
+}
+
+// PutBatch writes the block files of the values first, then stores their meta records in a single
+// transaction. A failed batch may leave unreferenced block files behind.
+func (backend *HybridBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	// The block files replaced by the batch, including those of keys written more than once
+	replaced := make(map[string][][]byte)
+	entries := make(map[string][]byte)
+	metaPairs := make([]*KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		if previous, ok := entries[string(pair.Key)]; ok {
+			if previous[0] == hybridFile {
+				replaced[string(pair.Key)] = append(replaced[string(pair.Key)], previous[1:])
+			}
+		} else {
+			previous, err := backend.fileHash(pair.Key)
+			if err != nil {
+				return err
+			}
+			replaced[string(pair.Key)] = [][]byte{previous}
+		}
+
+		entry, err := backend.entry(pair.Key, pair.Value)
+		if err != nil {
+			return err
+		}
+		entries[string(pair.Key)] = entry
+		metaPairs = append(metaPairs, &KeyValue{Key: pair.Key, Value: entry})
+	}
+
+	if err := backend.Meta.PutBatch(metaPairs); err != nil {
+		return err
+	}
+
+	for key, hashes := range replaced {
+		for _, hash := range hashes {
+			if err := backend.removeReplaced(hash, entries[key]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// entry returns the meta record of a value, writing large block records to their block file
+func (backend *HybridBackend) entry(key []byte, value []byte) ([]byte, error) {
+	if _, ok := blockRecordHeight(key, value); !ok || len(value) < backend.FileThreshold {
+		return append([]byte{hybridInline}, value...), nil
+	}
+
+	hash := sha256.Sum256(value)
+	if err := backend.writeBlockFile(hash[:], value); err != nil {
+		return nil, err
+	}
+
+	return append([]byte{hybridFile}, hash[:]...), nil
+}
+
+// writeBlockFile writes a block file through a temporary file, so a crash never leaves a partial file
+// under its hash
+func (backend *HybridBackend) writeBlockFile(hash []byte, value []byte) error {
+	path := backend.blockPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(value)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// fileHash returns the hash of the block file of key, nil if its value is not stored in a file
+func (backend *HybridBackend) fileHash(key []byte) ([]byte, error) {
+	entry, err := backend.Meta.Get(key)
+	if err != nil || len(entry) == 0 || entry[0] != hybridFile {
+		return nil, err
+	}
+
+	if len(entry) != 1+sha256.Size {
+		return nil, errors.New("hybrid meta record corrupted")
+	}
+
+	return entry[1:], nil
+}
+
+// removeReplaced removes the block file with the previous hash unless the new entry still refers to it
+func (backend *HybridBackend) removeReplaced(previous []byte, entry []byte) error {
+	if previous == nil || (entry != nil && entry[0] == hybridFile && string(entry[1:]) == string(previous)) {
+		return nil
+	}
+
+	if err := os.Remove(backend.blockPath(previous)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes an item from the meta database, along with its block file
+func (backend *HybridBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	previous, err := backend.fileHash(key)
+	if err != nil {
+		return err
+	}
+
+	if err = backend.Meta.Delete(key); err != nil {
+		return err
+	}
+
+	return backend.removeReplaced(previous, nil)
+}
+
+// Get reads a value from the meta database, or from its block file
+func (backend *HybridBackend) Get(key []byte) ([]byte, error) {
+	entry, err := backend.Meta.Get(key)
+	if err != nil || len(entry) == 0 {
+		return entry, err
+	}
+
+	return backend.value(entry)
+}
+
+// Has reports whether key is stored, from the meta database without reading block files
+func (backend *HybridBackend) Has(key []byte) (bool, error) {
+	return backend.Meta.Has(key)
+}
+
+// Iterate calls fn with the keys of the meta database starting with prefix and their values
+func (backend *HybridBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Meta.Iterate(prefix, func(key []byte, entry []byte) error {
+		value, err := backend.value(entry)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// Snapshot takes a snapshot of the meta database. Block files are read when needed, a record replaced
+// or removed after the snapshot was taken may no longer be found.
+func (backend *HybridBackend) Snapshot() (BackendSnapshot, error) {
+	meta, err := backend.Meta.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueSnapshot{snapshot: meta, decode: func(key []byte, entry []byte) ([]byte, error) {
+		return backend.value(entry)
+	}}, nil
+}
+
+// value returns the value of a meta record, reading it from its block file if needed
+func (backend *HybridBackend) value(entry []byte) ([]byte, error) {
+	if len(entry) == 0 {
+		return nil, errors.New("hybrid meta record corrupted")
+	}
+
+	switch entry[0] {
+	case hybridInline:
+		return entry[1:], nil
+	case hybridFile:
+		if len(entry) != 1+sha256.Size {
+			return nil, errors.New("hybrid meta record corrupted")
+		}
+		return backend.readBlockFile(entry[1:])
+	default:
+		return nil, errors.New("hybrid meta record corrupted")
+	}
+}
+
+// readBlockFile reads a block file and verifies it against its hash
+func (backend *HybridBackend) readBlockFile(hash []byte) ([]byte, error) {
+	path := backend.blockPath(hash)
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read block file %s, %w", path, err)
+	}
+
+	if sum := sha256.Sum256(value); string(sum[:]) != string(hash) {
+		return nil, fmt.Errorf("checksum mismatch in block file %s", path)
+	}
+
+	return value, nil
+}
+
+// Flush flushes the meta database, block files are synced as they are written
+func (backend *HybridBackend) Flush() error {
+	return backend.Meta.Flush()
+}
+
+// Begin begins a transaction batching its writes, the meta transaction cannot cover the block
+// files
+func (backend *HybridBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Compact compacts the meta database. Block files are never rewritten.
+func (backend *HybridBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Meta.Compact(discardRatio)
+}
+
+// Backup writes a backup stream of the records, read from the block files and the meta database, of a snapshot to w, incremental backups are not supported
+func (backend *HybridBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *HybridBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// Health reports the health of the meta database, and the free space on the block file volume
+func (backend *HybridBackend) Health() (*BackendHealth, error) {
+	health, err := backend.Meta.Health()
+	if err != nil {
+		return nil, err
+	}
+
+	free, err := diskFree(backend.Dir)
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
+
+// Stats reports the keys and LSM levels of the meta database, and the size of the directory holding
+// it and the block files
+func (backend *HybridBackend) Stats() (*BackendStats, error) {
+	stats, err := backend.Meta.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.DiskSize, err = dirSize(backend.Dir); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,125 @@
+package bstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	HybridBackendType = 10
+)
+
+func init() {
+	backendTypes = append(backendTypes, HybridBackendType)
+	taggedBackends[HybridBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		// Every block record is written to a file
+		backend, err := NewHybridBackend(dirname, 1, badger.DefaultOptions("").WithLogger(nil))
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestHybridBackendBasic(t *testing.T) {
+	b := NewBackend(HybridBackendType)
+
+	backendTest(t, b)
+
+	CloseBackend(b)
+}
+
+func blockFiles(t *testing.T, backend *HybridBackend) []string {
+	files, err := filepath.Glob(filepath.Join(backend.Dir, hybridBlocksDir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestHybridBackend(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	opts := badger.DefaultOptions("").WithLogger(nil)
+	backend, err := NewHybridBackend(dir, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 20)
+
+	// Each block record has its own file, metadata is kept in the meta database
+	if files := blockFiles(t, backend); len(files) != 20 {
+		t.Errorf("expected 20 block files, got %d", len(files))
+	}
+
+	// Values which are not block records stay in the meta database
+	if err = backend.Put([]byte{0xf0, 1}, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if files := blockFiles(t, backend); len(files) != 20 {
+		t.Errorf("expected 20 block files, got %d", len(files))
+	}
+
+	backend.Close()
+	backend, err = NewHybridBackend(dir, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	handler = RequestHandler{Backend: backend}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[120].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain after reopening, got %+v", resp)
+	}
+
+	// Deleting a block record removes its file
+	blockKey := blockRecordKey(bt.ByNum[120].GetId())
+	hash, err := backend.fileHash(blockKey)
+	if err != nil || hash == nil {
+		t.Fatalf("expected block record in a file, %v", err)
+	}
+	if err = backend.Delete(blockKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(backend.blockPath(hash)); !os.IsNotExist(err) {
+		t.Error("expected block file to be removed with its record")
+	}
+
+	// Corrupted block files are detected
+	blockKey = blockRecordKey(bt.ByNum[119].GetId())
+	if hash, err = backend.fileHash(blockKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(backend.blockPath(hash), []byte{0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backend.Get(blockKey); err == nil {
+		t.Error("expected a checksum error reading a corrupted block file")
+	}
+
+	if err = backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if files := blockFiles(t, backend); len(files) != 0 {
+		t.Errorf("expected no block files after reset, got %d", len(files))
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.Writable || health.DiskFreeBytes == nil {
+		t.Errorf("unexpected health %+v, %v", health, err)
+	}
+}
@@ -0,0 +1,112 @@
+package bstore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+// IngestFilter decides what AddBlock persists. Filters are applied in order, each one to the result
+// of the previous one.
+type IngestFilter interface {
+	// Name identifies the filter in metrics
+	Name() string
+
+	// Filter returns the request to persist, which may be a modified copy, and false if the block
+	// must not be persisted at all. The request must not be modified in place.
+	Filter(req *block_store.AddBlockRequest) (*block_store.AddBlockRequest, bool)
+}
+
+// DropReceiptsFilter stores blocks without their receipts
+type DropReceiptsFilter struct {
+}
+
+// Name implements IngestFilter
+func (f *DropReceiptsFilter) Name() string {
+	return "drop_receipts"
+}
+
+// Filter implements IngestFilter
+func (f *DropReceiptsFilter) Filter(req *block_store.AddBlockRequest) (*block_store.AddBlockRequest, bool) {
+	if req.GetReceiptToAdd() == nil {
+		return req, true
+	}
+
+	filtered := proto.Clone(req).(*block_store.AddBlockRequest)
+	filtered.ReceiptToAdd = nil
+	return filtered, true
+}
+
+// ProducerFilter only stores blocks signed by one of the known producers, such as the producers of a
+// private testnet
+type ProducerFilter struct {
+	producers [][]byte
+}
+
+// NewProducerFilter creates a ProducerFilter from base58 encoded producer addresses
+func NewProducerFilter(addresses []string) (*ProducerFilter, error) {
+	f := &ProducerFilter{}
+	for _, address := range addresses {
+		producer := base58.Decode(address)
+		if len(producer) == 0 {
+			return nil, fmt.Errorf("invalid producer address %s", address)
+		}
+		f.producers = append(f.producers, producer)
+	}
+
+	return f, nil
+}
+
+// Name implements IngestFilter
+func (f *ProducerFilter) Name() string {
+	return "producer"
+}
+
+// Filter implements IngestFilter
+func (f *ProducerFilter) Filter(req *block_store.AddBlockRequest) (*block_store.AddBlockRequest, bool) {
+	signer := req.GetBlockToAdd().GetHeader().GetSigner()
+	for _, producer := range f.producers {
+		if bytes.Equal(signer, producer) {
+			return req, true
+		}
+	}
+
+	return req, false
+}
+
+// MaxHeightFilter does not store blocks above a height, to freeze an archive at that height
+type MaxHeightFilter struct {
+	MaxHeight uint64
+}
+
+// Name implements IngestFilter
+func (f *MaxHeightFilter) Name() string {
+	return "max_height"
+}
+
+// Filter implements IngestFilter
+func (f *MaxHeightFilter) Filter(req *block_store.AddBlockRequest) (*block_store.AddBlockRequest, bool) {
+	return req, req.GetBlockToAdd().GetHeader().GetHeight() <= f.MaxHeight
+}
+
+// applyIngestFilters runs the handler's ingest filters, counting the blocks each one changed or
+// dropped. It returns false if the block must not be persisted.
+func (handler *RequestHandler) applyIngestFilters(req *block_store.AddBlockRequest) (*block_store.AddBlockRequest, bool) {
+	for _, filter := range handler.IngestFilters {
+		filtered, ok := filter.Filter(req)
+		if !ok {
+			handler.Metrics.recordFiltered(filter.Name())
+			return nil, false
+		}
+
+		if filtered != req {
+			handler.Metrics.recordFiltered(filter.Name())
+			req = filtered
+		}
+	}
+
+	return req, true
+}
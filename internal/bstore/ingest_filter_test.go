@@ -0,0 +1,96 @@
+package bstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestIngestFilters(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b, Metrics: NewMetrics()}
+
+		producer := []byte{0x00, 0x01, 0x02}
+		producerFilter, err := NewProducerFilter([]string{base58.Encode(producer)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler.IngestFilters = []IngestFilter{producerFilter, &MaxHeightFilter{MaxHeight: 3}, &DropReceiptsFilter{}}
+
+		blocks := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, 5, 0)
+		for _, block := range blocks {
+			block.Header.Signer = producer
+		}
+
+		stranger := makeForkFrom(blocks[0], 1, 1)[0]
+		stranger.Header.Signer = []byte{0x03}
+
+		for _, block := range append(blocks, stranger) {
+			req := &block_store.AddBlockRequest{BlockToAdd: block, ReceiptToAdd: &protocol.BlockReceipt{Id: block.GetId()}}
+			if _, err := handler.AddBlock(req); err != nil {
+				t.Fatal(err)
+			}
+			if req.GetReceiptToAdd() == nil {
+				t.Fatal("filter modified the request in place")
+			}
+		}
+
+		resp, err := handler.GetBlocksByID(context.Background(), &block_store.GetBlocksByIdRequest{
+			BlockIds:      [][]byte{blocks[2].GetId(), blocks[3].GetId(), stranger.GetId()},
+			ReturnBlock:   true,
+			ReturnReceipt: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.BlockItems[0].GetBlock() == nil {
+			t.Error("expected block at height 3 to be stored")
+		} else if resp.BlockItems[0].GetReceipt() != nil {
+			t.Error("expected the receipt to be dropped")
+		}
+
+		if len(resp.BlockItems[1].GetBlockId()) > 0 {
+			t.Error("expected block above the maximum height to be dropped")
+		}
+
+		if len(resp.BlockItems[2].GetBlockId()) > 0 {
+			t.Error("expected block from an unknown producer to be dropped")
+		}
+
+		highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if highest.GetTopology().GetHeight() != 3 {
+			t.Errorf("expected highest block at height 3, got %d", highest.GetTopology().GetHeight())
+		}
+
+		expected := map[string]float64{"producer": 1, "max_height": 2, "drop_receipts": 3}
+		for _, metric := range handler.Metrics.samples() {
+			if metric.Name != "block_store_ingest_filtered_total" {
+				continue
+			}
+			if metric.Value != expected[metric.Labels["filter"]] {
+				t.Errorf("expected %v blocks filtered by %s, got %v", expected[metric.Labels["filter"]], metric.Labels["filter"], metric.Value)
+			}
+			delete(expected, metric.Labels["filter"])
+		}
+		if len(expected) > 0 {
+			t.Errorf("missing filter metrics %v", expected)
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestNewProducerFilter(t *testing.T) {
+	if _, err := NewProducerFilter([]string{"0OIl"}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
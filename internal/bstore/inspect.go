@@ -0,0 +1,135 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Parts of a block record which can be inspected
+const (
+	InspectRecord   = "record"
+	InspectBlock    = "block"
+	InspectHeader   = "header"
+	InspectTopology = "topology"
+	InspectReceipt  = "receipt"
+)
+
+// InspectBlockRecord returns a part of the record of a stored block as a value which serializes to
+// JSON, for debugging. The block is selected by blockID or, if it is empty, by its height on the
+// chain of the highest block. The record part includes the skip links the block store keeps
+// alongside the block.
+func (handler *RequestHandler) InspectBlockRecord(blockID []byte, height uint64, part string) (interface{}, error) {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+
+	if len(blockID) == 0 {
+		if height == 0 {
+			return nil, &InvalidRequestError{Reason: "a block ID or height must be given"}
+		}
+
+		checkpoint, err := handler.getCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil && height < checkpoint.Height {
+			return nil, &BelowCheckpoint{checkpoint.Height}
+		}
+
+		highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+		if err != nil {
+			return nil, err
+		}
+		if height > highest.GetTopology().GetHeight() {
+			return nil, fmt.Errorf("height %d is above the highest block at height %d", height, highest.GetTopology().GetHeight())
+		}
+
+		if blockID, err = getAncestorIDAtHeight(context.Background(), handler.Backend, highest.GetTopology().GetId(), height); err != nil {
+			return nil, err
+		}
+	}
+
+	record, err := handler.getRecord(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch part {
+	case InspectRecord, "":
+		return messageToJSON(record.ProtoReflect()), nil
+	case InspectBlock:
+		return messageToJSON(record.GetBlock().ProtoReflect()), nil
+	case InspectHeader:
+		return messageToJSON(record.GetBlock().GetHeader().ProtoReflect()), nil
+	case InspectTopology:
+		return &Topology{ID: record.GetBlockId(), Height: record.GetBlockHeight(), Previous: record.GetBlock().GetHeader().GetPrevious()}, nil
+	case InspectReceipt:
+		if record.GetReceipt() == nil {
+			return nil, errors.New("the block is stored without its receipt")
+		}
+		return messageToJSON(record.GetReceipt().ProtoReflect()), nil
+	default:
+		return nil, &InvalidRequestError{Reason: fmt.Sprintf("unknown part '%s'", part)}
+	}
+}
+
+// messageToJSON converts the populated fields of a message to a map which serializes to JSON under
+// the field names of the proto definitions, with bytes as 0x prefixed hex strings like the rest of
+// the block store's JSON. Fields unknown to this binary's schema are kept as their serialized bytes.
+func messageToJSON(m protoreflect.Message) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if !m.IsValid() {
+		return fields
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields[string(fd.Name())] = fieldToJSON(fd, v)
+		return true
+	})
+
+	if unknown := m.GetUnknown(); len(unknown) > 0 {
+		fields["unknown_fields"] = HexBytes(unknown)
+	}
+
+	return fields
+}
+
+func fieldToJSON(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		values := make([]interface{}, list.Len())
+		for i := range values {
+			values[i] = singularToJSON(fd, list.Get(i))
+		}
+		return values
+	case fd.IsMap():
+		values := make(map[string]interface{})
+		v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			values[key.String()] = singularToJSON(fd.MapValue(), value)
+			return true
+		})
+		return values
+	default:
+		return singularToJSON(fd, v)
+	}
+}
+
+func singularToJSON(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageToJSON(v.Message())
+	case protoreflect.BytesKind:
+		return HexBytes(v.Bytes())
+	case protoreflect.EnumKind:
+		if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+			return string(value.Name())
+		}
+		return v.Enum()
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,71 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestInspectBlockRecord(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 10)
+
+	block := bt.ByNum[107]
+	receipt := &protocol.BlockReceipt{Id: block.GetId(), Height: 7, DiskStorageUsed: 42}
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block, ReceiptToAdd: receipt}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A block is found by its height on the chain of the highest block, or by its ID
+	byHeight, err := handler.InspectBlockRecord(nil, 7, InspectRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID, err := handler.InspectBlockRecord(block.GetId(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := json.Marshal(byHeight)
+	second, _ := json.Marshal(byID)
+	if !bytes.Equal(first, second) {
+		t.Errorf("expected the same record, got %s and %s", first, second)
+	}
+
+	// Bytes are hex encoded like the rest of the block store's JSON
+	for _, expected := range []string{`"block_id":"0x` + hex.EncodeToString(block.GetId()), `"block_height":7`, `"previous_block_ids":[`, `"disk_storage_used":42`} {
+		if !strings.Contains(string(first), expected) {
+			t.Errorf("expected %s in %s", expected, first)
+		}
+	}
+
+	topology, err := handler.InspectBlockRecord(block.GetId(), 0, InspectTopology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topology.(*Topology).Height != 7 || !bytes.Equal(topology.(*Topology).Previous, block.GetHeader().GetPrevious()) {
+		t.Errorf("unexpected topology %+v", topology)
+	}
+
+	header, err := handler.InspectBlockRecord(nil, 7, InspectHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.(map[string]interface{})["height"] != uint64(7) {
+		t.Errorf("unexpected header %v", header)
+	}
+
+	if _, err = handler.InspectBlockRecord(nil, 3, InspectReceipt); err == nil {
+		t.Error("expected an error for a block stored without its receipt")
+	}
+	if _, err = handler.InspectBlockRecord(nil, 11, InspectBlock); err == nil {
+		t.Error("expected an error for a height above the highest block")
+	}
+	if _, err = handler.InspectBlockRecord(nil, 7, "transactions"); err == nil {
+		t.Error("expected an error for an unknown part")
+	}
+}
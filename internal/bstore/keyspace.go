@@ -0,0 +1,124 @@
+package bstore
+
+import (
+	"bytes"
+	"fmt"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+// Block records of the v1 keyspace are keyed by their serialized block ID. The v2 keyspace keys them
+// by a type byte followed by the digest of their ID, which shortens every key of the LSM tree and
+// keeps the block records together, so they can be iterated apart from the other records.
+
+const (
+	// KeyspaceMigrationName is reported by the compatibility check for databases whose block records
+	// are yet to be moved to the v2 keyspace
+	KeyspaceMigrationName = "keyspace_v2"
+
+	keyspaceMigrationBatchSize = 1000
+
+	// Block IDs are sha2-256 multihashes, the code 0x12 followed by the digest length 0x20
+	sha256MultihashCode = 0x12
+	sha256DigestSize    = 0x20
+)
+
+// blockRecordKey returns the key of the block record of blockID. The key of a sha2-256 block ID is
+// its digest, other block IDs keep their whole multihash.
+func blockRecordKey(blockID []byte) []byte {
+	if len(blockID) == 2+sha256DigestSize && blockID[0] == sha256MultihashCode && blockID[1] == sha256DigestSize {
+		return append([]byte{blockRecordPrefix}, blockID[2:]...)
+	}
+
+	return append([]byte{blockRecordMultihashPrefix}, blockID...)
+}
+
+// legacyBlockRecordKeys calls fn with up to limit keys and values of the v1 block records
+func legacyBlockRecordKeys(backend BlockStoreBackend, limit int, fn func(key []byte, value []byte) error) error {
+	n := 0
+	for _, b := range byteRange(legacyBlockKeyFirstByte, 0xff) {
+		err := backend.Iterate([]byte{b}, func(key []byte, value []byte) error {
+			if n == limit {
+				return ErrStopIteration
+			}
+			n++
+			return fn(key, value)
+		})
+		if err != nil || n == limit {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasLegacyBlockRecords returns true if the database holds block records of the v1 keyspace
+func hasLegacyBlockRecords(backend BlockStoreBackend) (bool, error) {
+	found := false
+	err := legacyBlockRecordKeys(backend, 1, func(key []byte, value []byte) error {
+		found = true
+		return nil
+	})
+
+	return found, err
+}
+
+// migrateKeyspace moves the block records of the v1 keyspace to their v2 keys, returning the number
+// of records moved. Each batch of records is written under its v2 keys before its v1 keys are
+// removed, so an interrupted migration resumes where it stopped when run again. Records under v1
+// keys which are not block records are left in place.
+func (handler *RequestHandler) migrateKeyspace() (uint64, error) {
+	var moved uint64
+	var err error
+	var skip [][]byte
+	for {
+		var legacyKeys [][]byte
+		var pairs []*KeyValue
+		limit := keyspaceMigrationBatchSize + len(skip)
+		seen := 0
+		err = legacyBlockRecordKeys(handler.Backend, limit, func(key []byte, value []byte) error {
+			seen++
+			for _, skipped := range skip {
+				if bytes.Equal(key, skipped) {
+					return nil
+				}
+			}
+
+			record := &block_store.BlockRecord{}
+			if err := proto.Unmarshal(value, record); err != nil || !bytes.Equal(record.GetBlockId(), key) {
+				log.Warnf("Leaving record 0x%x in place, it is not a block record", key)
+				skip = append(skip, append([]byte{}, key...))
+				return nil
+			}
+
+			legacyKeys = append(legacyKeys, append([]byte{}, key...))
+			pairs = append(pairs, &KeyValue{Key: blockRecordKey(key), Value: append([]byte{}, value...)})
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+
+		// A record left under both keys by an interruption is moved again
+		if len(pairs) > 0 {
+			if err = handler.Backend.PutBatch(pairs); err != nil {
+				return moved, fmt.Errorf("could not move block records, %w", err)
+			}
+		}
+		for _, key := range legacyKeys {
+			if err = handler.Backend.Delete(key); err != nil {
+				return moved, err
+			}
+		}
+		moved += uint64(len(pairs))
+
+		// The last batch ends before the limit
+		if seen < limit {
+			break
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,110 @@
+package bstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+)
+
+// buildSHA256Chain adds a linear chain of blocks with sha2-256 block IDs, like those of a real node
+func buildSHA256Chain(t *testing.T, handler *RequestHandler, length uint64) [][]byte {
+	previous := GetEmptyBlockID()
+	var ids [][]byte
+	for height := uint64(1); height <= length; height++ {
+		block := &protocol.Block{Header: &protocol.BlockHeader{Previous: previous, Height: height, Timestamp: height}}
+		header, _ := proto.Marshal(block.Header)
+		digest := sha256.Sum256(header)
+		block.Id, _ = multihash.EncodeName(digest[:], "sha2-256")
+
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, block.Id)
+		previous = block.Id
+	}
+
+	return ids
+}
+
+func TestBlockRecordKey(t *testing.T) {
+	digest := sha256.Sum256([]byte("block"))
+	id, _ := multihash.EncodeName(digest[:], "sha2-256")
+	if key := blockRecordKey(id); len(key) != 33 || key[0] != blockRecordPrefix || !bytes.Equal(key[1:], digest[:]) {
+		t.Errorf("expected the prefixed digest of a sha2-256 block ID, got %x", key)
+	}
+
+	id, _ = multihash.EncodeName(digest[:20], "sha1")
+	if key := blockRecordKey(id); key[0] != blockRecordMultihashPrefix || !bytes.Equal(key[1:], id) {
+		t.Errorf("expected the prefixed multihash of a sha1 block ID, got %x", key)
+	}
+
+	if !NamespaceBlocks.Contains(blockRecordKey(id)) {
+		t.Error("expected block record keys in the blocks namespace")
+	}
+}
+
+func TestMigrateKeyspace(t *testing.T) {
+	b := NewMapBackend()
+	handler := RequestHandler{Backend: b}
+	ids := buildSHA256Chain(t, &handler, 20)
+
+	// A v1 database keys its block records by block ID, without a format version
+	for _, id := range ids {
+		value, err := b.Get(blockRecordKey(id))
+		if err != nil || len(value) == 0 {
+			t.Fatalf("expected the block record under its v2 key, %v", err)
+		}
+		if err = b.Put(id, value); err != nil {
+			t.Fatal(err)
+		}
+		if err = b.Delete(blockRecordKey(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stray := append([]byte{sha256MultihashCode}, []byte("stray")...)
+	if err := b.Put(stray, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := handler.CheckCompatibility()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Compatible || len(report.Migrations) != 1 || report.Migrations[0] != KeyspaceMigrationName {
+		t.Errorf("expected a compatible store needing the keyspace migration, got %+v", report)
+	}
+
+	results, err := handler.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != KeyspaceMigrationName || results[0].Records != 20 {
+		t.Errorf("expected 20 moved block records, got %+v", results)
+	}
+	if version, _ := getFormatVersion(b); version != FormatVersion {
+		t.Errorf("expected format version %d, got %d", FormatVersion, version)
+	}
+	if present, _ := b.Has(ids[0]); present {
+		t.Error("expected the v1 key to be removed")
+	}
+	if value, _ := b.Get(stray); !bytes.Equal(value, []byte{1, 2, 3}) {
+		t.Error("expected the record which is not a block record to be left in place")
+	}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: ids[19], StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid || resp.VerifyChainLinks.BlocksChecked != 20 {
+		t.Errorf("expected a valid chain after the migration, got %+v", resp)
+	}
+
+	if results, err = handler.Migrate(); err != nil || len(results) != 0 {
+		t.Errorf("expected a migrated database to be left alone, got %+v, %v", results, err)
+	}
+	if report, err = handler.CheckCompatibility(); err != nil || len(report.Migrations) != 0 {
+		t.Errorf("expected no migration, got %+v, %v", report, err)
+	}
+}
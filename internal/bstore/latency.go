@@ -0,0 +1,79 @@
+package bstore
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// maxLatencySamples is the number of latencies kept per request type between two summaries
+const maxLatencySamples = 1024
+
+// LatencySummary is the number of requests of a type served since the previous summary and the
+// percentiles of their latencies
+type LatencySummary struct {
+	Request string
+	Count   uint64
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+}
+
+// latencySample is a uniform random sample of the latencies of a request type, so busy request types
+// are summarized in bounded memory
+type latencySample struct {
+	count     uint64
+	latencies []time.Duration
+}
+
+// add adds a latency to the sample, replacing a random one once the sample is full
+func (s *latencySample) add(latency time.Duration) {
+	s.count++
+	if len(s.latencies) < maxLatencySamples {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+
+	if i := rand.Int63n(int64(s.count)); i < maxLatencySamples {
+		s.latencies[i] = latency
+	}
+}
+
+// percentile returns the latency below which a fraction p of the sorted latencies are, by nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+// TakeLatencySummaries returns the summaries of the request types served since the previous call,
+// ordered by request name, and starts new ones. It returns nil on nil Metrics.
+func (m *Metrics) TakeLatencySummaries() []*LatencySummary {
+	if m == nil {
+		return nil
+	}
+
+	m.lock.Lock()
+	samples := m.latencies
+	m.latencies = make(map[string]*latencySample)
+	m.lock.Unlock()
+
+	summaries := make([]*LatencySummary, 0, len(samples))
+	for name, sample := range samples {
+		sort.Slice(sample.latencies, func(i, j int) bool { return sample.latencies[i] < sample.latencies[j] })
+		summaries = append(summaries, &LatencySummary{
+			Request: name,
+			Count:   sample.count,
+			P50:     percentile(sample.latencies, 0.50),
+			P95:     percentile(sample.latencies, 0.95),
+			P99:     percentile(sample.latencies, 0.99),
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Request < summaries[j].Request })
+
+	return summaries
+}
@@ -0,0 +1,59 @@
+package bstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestLatencySummaries(t *testing.T) {
+	metrics := NewMetrics()
+	for i := 1; i <= 100; i++ {
+		metrics.recordRequest("get_head", time.Duration(i)*time.Millisecond, nil)
+	}
+
+	summaries := metrics.TakeLatencySummaries()
+	if len(summaries) != 1 {
+		t.Fatalf("expected 1 summary, got %d", len(summaries))
+	}
+	summary := summaries[0]
+	if summary.Request != "get_head" || summary.Count != 100 || summary.P50 != 50*time.Millisecond || summary.P95 != 95*time.Millisecond || summary.P99 != 99*time.Millisecond {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	// Summaries start over once taken
+	if summaries = metrics.TakeLatencySummaries(); len(summaries) != 0 {
+		t.Errorf("expected no summaries, got %d", len(summaries))
+	}
+
+	// The sample is bounded, the count is not
+	for i := 0; i < 2*maxLatencySamples; i++ {
+		metrics.recordRequest("get_head", time.Millisecond, nil)
+	}
+	if metrics.latencies["get_head"].count != 2*maxLatencySamples || len(metrics.latencies["get_head"].latencies) != maxLatencySamples {
+		t.Errorf("unexpected sample of %d latencies", len(metrics.latencies["get_head"].latencies))
+	}
+
+	var nilMetrics *Metrics
+	if nilMetrics.TakeLatencySummaries() != nil {
+		t.Error("expected no summaries without metrics")
+	}
+}
+
+func TestRequestLatencySummaries(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), Metrics: NewMetrics()}
+	buildLinearChain(t, &handler, 3)
+	handler.Metrics.TakeLatencySummaries()
+
+	handler.HandleRequest(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetHighestBlock{
+		GetHighestBlock: &block_store.GetHighestBlockRequest{},
+	}})
+	handler.HandleExtendedRequest(&ExtendedRequest{GetHead: &GetHeadRequest{}})
+	handler.HandleExtendedRequest(&ExtendedRequest{GetHead: &GetHeadRequest{}})
+
+	summaries := handler.Metrics.TakeLatencySummaries()
+	if len(summaries) != 2 || summaries[0].Request != "get_head" || summaries[0].Count != 2 || summaries[1].Request != "get_highest_block" || summaries[1].Count != 1 {
+		t.Errorf("unexpected summaries %+v", summaries)
+	}
+}
@@ -0,0 +1,315 @@
+package bstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+const (
+	defaultDiscardRatio = 0.5
+)
+
+// CompactStoreRequest asks the block store to garbage collect its value log and flatten its LSM tree.
+// A DiscardRatio of 0 uses the default of 0.5.
+type CompactStoreRequest struct {
+	DiscardRatio float64 `json:"discard_ratio,omitempty"`
+}
+
+// CompactStoreResponse reports the space reclaimed by a compaction
+type CompactStoreResponse struct {
+	LSMSizeBefore      int64 `json:"lsm_size_before"`
+	ValueLogSizeBefore int64 `json:"value_log_size_before"`
+	LSMSizeAfter       int64 `json:"lsm_size_after"`
+	ValueLogSizeAfter  int64 `json:"value_log_size_after"`
+	ReclaimedBytes     int64 `json:"reclaimed_bytes"`
+
+	ValueLogFilesRewritten int `json:"value_log_files_rewritten"`
+}
+
+// CompactStore compacts the backend, reporting the reclaimed space
+func (handler *RequestHandler) CompactStore(req *CompactStoreRequest) (*CompactStoreResponse, error) {
+	discardRatio := req.DiscardRatio
+	if discardRatio == 0 {
+		discardRatio = defaultDiscardRatio
+	}
+
+	if discardRatio <= 0 || discardRatio >= 1 {
+		return nil, errors.New("discard_ratio must be between 0 and 1")
+	}
+
+	if !atomic.CompareAndSwapInt32(&handler.compacting, 0, 1) {
+		return nil, errors.New("compaction already in progress")
+	}
+	defer atomic.StoreInt32(&handler.compacting, 0)
+
+	log.Info("Compacting database")
+	result, err := handler.Backend.Compact(discardRatio)
+	if err != nil {
+		log.Warnf("Compaction failed, %s", err.Error())
+		return nil, err
+	}
+
+	resp := &CompactStoreResponse{
+		LSMSizeBefore:          result.LSMSizeBefore,
+		ValueLogSizeBefore:     result.ValueLogSizeBefore,
+		LSMSizeAfter:           result.LSMSizeAfter,
+		ValueLogSizeAfter:      result.ValueLogSizeAfter,
+		ReclaimedBytes:         (result.LSMSizeBefore + result.ValueLogSizeBefore) - (result.LSMSizeAfter + result.ValueLogSizeAfter),
+		ValueLogFilesRewritten: result.ValueLogFilesRewritten,
+	}
+
+	log.Infof("Compaction reclaimed %v byte(s), rewrote %v value log file(s)", resp.ReclaimedBytes, resp.ValueLogFilesRewritten)
+	return resp, nil
+}
+
+// BackupStoreRequest asks the block store to write a backup to its configured backup directory.
+// A SinceVersion of 0 creates a full backup, otherwise only entries newer than SinceVersion are included.
+type BackupStoreRequest struct {
+	SinceVersion uint64 `json:"since_version,omitempty"`
+}
+
+// BackupManifest describes a completed backup
+type BackupManifest struct {
+	Path         string    `json:"path"`
+	SinceVersion uint64    `json:"since_version"`
+	Version      uint64    `json:"version"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// BackupStoreResponse contains the manifest of the completed backup
+type BackupStoreResponse struct {
+	Manifest *BackupManifest `json:"manifest"`
+}
+
+// BackupStore streams a consistent backup of the backend to the configured backup directory.
+// The backup is written alongside a JSON manifest with the same name.
+func (handler *RequestHandler) BackupStore(req *BackupStoreRequest) (*BackupStoreResponse, error) {
+	if len(handler.BackupDir) == 0 {
+		return nil, errors.New("backup directory is not configured")
+	}
+
+	if !atomic.CompareAndSwapInt32(&handler.backingUp, 0, 1) {
+		return nil, errors.New("backup already in progress")
+	}
+	defer atomic.StoreInt32(&handler.backingUp, 0)
+
+	if err := os.MkdirAll(handler.BackupDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// The backup reads what the database has stored, which may still be buffered in memory
+	if err := handler.Backend.Flush(); err != nil {
+		return nil, fmt.Errorf("could not flush the database before the backup, %w", err)
+	}
+
+	createdAt := time.Now().UTC()
+	tmpFile, err := os.CreateTemp(handler.BackupDir, "backup-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// Once renamed into place, there is no temporary file left to remove
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	log.Infof("Backing up database since version %v", req.SinceVersion)
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	version, err := handler.Backend.Backup(io.MultiWriter(tmpFile, hash, counter), req.SinceVersion)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Warnf("Backup failed, %s", err.Error())
+		return nil, err
+	}
+
+	name := fmt.Sprintf("backup-%s-%d-%d", createdAt.Format("20060102T150405Z"), req.SinceVersion, version)
+	manifest := &BackupManifest{
+		Path:         filepath.Join(handler.BackupDir, name+".bak"),
+		SinceVersion: req.SinceVersion,
+		Version:      version,
+		Size:         counter.n,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:    createdAt,
+	}
+
+	if err = os.Rename(tmpFile.Name(), manifest.Path); err != nil {
+		return nil, err
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.WriteFile(filepath.Join(handler.BackupDir, name+".json"), manifestBytes, 0644); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Backup of %v byte(s) written to %s", manifest.Size, manifest.Path)
+	return &BackupStoreResponse{Manifest: manifest}, nil
+}
+
+// RestoreStoreRequest asks the block store to replace its database with a backup. A relative Path is
+// resolved against the configured backup directory.
+type RestoreStoreRequest struct {
+	Path string `json:"path"`
+}
+
+// RestoreStoreResponse reports the highest block of the restored database
+type RestoreStoreResponse struct {
+	HighestBlockID     HexBytes `json:"highest_block_id"`
+	HighestBlockHeight uint64   `json:"highest_block_height"`
+}
+
+// RestoreStore replaces the database with the contents of a backup file and validates the restored
+// highest block. If the backup has a manifest, its size and checksum are verified before anything is
+// dropped. The caller must hold the handler's write lock.
+func (handler *RequestHandler) RestoreStore(req *RestoreStoreRequest) (*RestoreStoreResponse, error) {
+	if !handler.AllowRestore {
+		return nil, errors.New("restore is disabled by configuration")
+	}
+
+	if len(req.Path) == 0 {
+		return nil, errors.New("expected field 'path' was empty")
+	}
+
+	path := req.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(handler.BackupDir, path)
+	}
+
+	// A restore must not interleave with a running compaction or backup
+	if !atomic.CompareAndSwapInt32(&handler.backingUp, 0, 1) {
+		return nil, errors.New("backup in progress")
+	}
+	defer atomic.StoreInt32(&handler.backingUp, 0)
+
+	if !atomic.CompareAndSwapInt32(&handler.compacting, 0, 1) {
+		return nil, errors.New("compaction in progress")
+	}
+	defer atomic.StoreInt32(&handler.compacting, 0)
+
+	if err := verifyBackupManifest(path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	log.Infof("Restoring database from %s", path)
+	if err = handler.Backend.Load(f); err != nil {
+		log.Warnf("Restore failed, %s", err.Error())
+		return nil, err
+	}
+
+	// Backups taken by older releases are upgraded to the current format
+	if _, err = handler.Migrate(); err != nil {
+		return nil, fmt.Errorf("could not migrate the restored database, %w", err)
+	}
+
+	if err = handler.Backend.Flush(); err != nil {
+		return nil, fmt.Errorf("could not flush the restored database, %w", err)
+	}
+
+	highest, err := handler.ValidateHighestBlock()
+	if err != nil {
+		log.Warnf("Restored database failed validation, %s", err.Error())
+		return nil, fmt.Errorf("restored database failed validation, %w", err)
+	}
+
+	log.Infof("Database restored - Height: %d, ID: 0x%s", highest.GetHeight(), hex.EncodeToString(highest.GetId()))
+	return &RestoreStoreResponse{HighestBlockID: highest.GetId(), HighestBlockHeight: highest.GetHeight()}, nil
+}
+
+// verifyBackupManifest checks a backup against the manifest written alongside it, if there is one
+func verifyBackupManifest(path string) error {
+	manifestBytes, err := os.ReadFile(strings.TrimSuffix(path, filepath.Ext(path)) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	manifest := &BackupManifest{}
+	if err = json.Unmarshal(manifestBytes, manifest); err != nil {
+		return fmt.Errorf("could not parse backup manifest, %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+
+	if size != manifest.Size {
+		return fmt.Errorf("backup size %d does not match manifest size %d", size, manifest.Size)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return errors.New("backup checksum does not match manifest")
+	}
+
+	return nil
+}
+
+// ValidateHighestBlock checks that the highest block record refers to a stored block and returns it
+func (handler *RequestHandler) ValidateHighestBlock() (*koinos.BlockTopology, error) {
+	highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	topology := highest.GetTopology()
+	if topology.GetHeight() == 0 {
+		return topology, nil
+	}
+
+	record, err := handler.getRecord(topology.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	if record.GetBlockHeight() != topology.GetHeight() {
+		return nil, fmt.Errorf("highest block has height %d, expected %d", record.GetBlockHeight(), topology.GetHeight())
+	}
+
+	return topology, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,176 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+)
+
+func TestCompactStore(t *testing.T) {
+	b := NewBackend(BadgerBackendType)
+	handler := RequestHandler{Backend: b, AdminAllowlist: []string{"compact_store"}}
+	buildLinearChain(t, &handler, 50)
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{CompactStore: &CompactStoreRequest{}}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.Admin.CompactStore.LSMSizeAfter+resp.Admin.CompactStore.ValueLogSizeAfter <= 0 {
+		t.Error("expected non-zero database size after compaction")
+	}
+
+	if _, err := handler.CompactStore(&CompactStoreRequest{DiscardRatio: 1.5}); err == nil {
+		t.Error("expected error for invalid discard ratio")
+	}
+
+	CloseBackend(b)
+
+	// Every backend compacts, those with nothing to reclaim report unchanged sizes
+	handler = RequestHandler{Backend: NewMapBackend(), AdminAllowlist: []string{"compact_store"}}
+	compacted, err := handler.CompactStore(&CompactStoreRequest{})
+	if err != nil || compacted.ReclaimedBytes != 0 {
+		t.Errorf("expected nothing reclaimed from a map backend, got %+v, %v", compacted, err)
+	}
+
+	// A remote backend compacts the database of the remote block store
+	remote := NewRemoteBackend(&loopbackClient{handler: &handler}, "")
+	if _, err = remote.Compact(defaultDiscardRatio); err != nil {
+		t.Errorf("expected the remote block store to compact, got %v", err)
+	}
+	if _, err = remote.Compact(1.5); err == nil {
+		t.Error("expected the remote block store to reject an invalid discard ratio")
+	}
+}
+
+func TestBackupStore(t *testing.T) {
+	b := NewBackend(BadgerBackendType)
+	backupDir, err := os.MkdirTemp(os.TempDir(), "bstore-backup-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := RequestHandler{Backend: b, BackupDir: backupDir, AdminSecret: "secret"}
+	bt := buildLinearChain(t, &handler, 10)
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", BackupStore: &BackupStoreRequest{}}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+
+	manifest := resp.Admin.BackupStore.Manifest
+	info, err := os.Stat(manifest.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != manifest.Size || manifest.Size == 0 {
+		t.Errorf("unexpected backup size %d, manifest reports %d", info.Size(), manifest.Size)
+	}
+	if _, err = os.Stat(strings.TrimSuffix(manifest.Path, ".bak") + ".json"); err != nil {
+		t.Error("expected manifest file", err)
+	}
+
+	// The backup restores into a fresh database
+	restored := NewBackend(BadgerBackendType).(*BadgerBackend)
+	f, err := os.Open(manifest.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = restored.DB.Load(f, 16); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	value, err := restored.Get(blockRecordKey(bt.ByNum[110].GetId()))
+	if err != nil || len(value) == 0 {
+		t.Error("expected block in restored database")
+	}
+	CloseBackend(restored)
+
+	// An incremental backup with no new writes contains no entries
+	incremental, err := handler.BackupStore(&BackupStoreRequest{SinceVersion: manifest.Version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incremental.Manifest.Size >= manifest.Size {
+		t.Error("expected incremental backup to be smaller than full backup")
+	}
+
+	CloseBackend(b)
+
+	handler = RequestHandler{Backend: NewBackend(BadgerBackendType)}
+	if _, err = handler.BackupStore(&BackupStoreRequest{}); err == nil {
+		t.Error("expected error without backup directory")
+	}
+	CloseBackend(handler.Backend)
+}
+
+func TestRestoreStore(t *testing.T) {
+	backupDir := t.TempDir()
+	source := RequestHandler{Backend: NewBackend(BadgerBackendType), BackupDir: backupDir}
+	bt := buildLinearChain(t, &source, 10)
+
+	backup, err := source.BackupStore(&BackupStoreRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CloseBackend(source.Backend)
+
+	b := NewBackend(BadgerBackendType)
+	handler := RequestHandler{Backend: b, BackupDir: backupDir, AdminSecret: "secret"}
+	buildLinearChain(t, &handler, 3)
+
+	req := &ExtendedRequest{Admin: &AdminRequest{Secret: "secret", RestoreStore: &RestoreStoreRequest{Path: filepath.Base(backup.Manifest.Path)}}}
+	if resp := handler.HandleExtendedRequest(req); resp.Error == nil {
+		t.Error("expected restore to be disabled by default")
+	}
+
+	handler.AllowRestore = true
+	resp := handler.HandleExtendedRequest(req)
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.Admin.RestoreStore.HighestBlockHeight != 10 || !bytes.Equal(resp.Admin.RestoreStore.HighestBlockID, bt.ByNum[110].GetId()) {
+		t.Errorf("unexpected highest block after restore at height %d", resp.Admin.RestoreStore.HighestBlockHeight)
+	}
+
+	// A backup which does not match its manifest is rejected before the database is touched
+	data, _ := os.ReadFile(backup.Manifest.Path)
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(backup.Manifest.Path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = handler.RestoreStore(req.Admin.RestoreStore); err == nil {
+		t.Error("expected error for corrupted backup")
+	}
+
+	if _, err = handler.RestoreStore(&RestoreStoreRequest{Path: "missing.bak"}); err == nil {
+		t.Error("expected error for missing backup")
+	}
+
+	highest, err := handler.ValidateHighestBlock()
+	if err != nil || highest.GetHeight() != 10 {
+		t.Error("expected database to be unchanged by failed restores")
+	}
+
+	CloseBackend(b)
+}
+
+func TestValidateHighestBlock(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	buildLinearChain(t, &handler, 5)
+
+	if _, err := handler.ValidateHighestBlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A highest block record pointing at a missing block is invalid
+	if err := handler.UpdateHighestBlock(&koinos.BlockTopology{Id: GetNonExistentBlockID(1), Height: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.ValidateHighestBlock(); err == nil {
+		t.Error("expected error for missing highest block")
+	}
+}
@@ -1,22 +1,242 @@
 package bstore
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
 )
 
-// MapBackend implements a key-value store backed by a simple map
+// MapBackend implements a key-value store backed by a simple map. It is safe for concurrent use,
+// values are copied in and out so callers may reuse their buffers.
+//
+// A map backend opened with OpenMapBackend is persisted to a snapshot file, so it can serve devnets
+// and CI environments. Writes since the last snapshot are lost if the process crashes.
 type MapBackend struct {
 	storage map[string][]byte
+	lock    sync.RWMutex
+
+	// snapshots counts the unreleased snapshots sharing storage, it is copied before the next write
+	// if there are any. generation changes whenever storage is replaced.
+	snapshots  int
+	generation uint64
+
+	// size is the total size of the stored keys and values, maxSize bounds it if positive
+	size    int64
+	maxSize int64
+
+	// version counts the writes, snapshotVersion is the version of the last snapshot
+	version         uint64
+	snapshotVersion uint64
+
+	// path is the snapshot file, empty if the backend is not persisted
+	path         string
+	snapshotLock sync.Mutex
+	stop         chan struct{}
+	stopOnce     sync.Once
+	wg           sync.WaitGroup
+}
+
+// MapBackendFull is returned when a write would grow a bounded MapBackend beyond its maximum size
+type MapBackendFull struct {
+	MaxSize int64
+}
+
+func (e *MapBackendFull) Error() string {
+	return fmt.Sprintf("map backend is full (%d bytes)", e.MaxSize)
 }
 
 // NewMapBackend creates and returns a reference to a map backend instance
 func NewMapBackend() *MapBackend {
-	return &MapBackend{make(map[string][]byte)}
+	return &MapBackend{storage: make(map[string][]byte)}
+}
+
+// NewBoundedMapBackend creates a map backend holding at most maxSize bytes of keys and values, writes
+// beyond it fail with MapBackendFull
+func NewBoundedMapBackend(maxSize int64) *MapBackend {
+	return &MapBackend{storage: make(map[string][]byte), maxSize: maxSize}
+}
+
+// OpenMapBackend opens a map backend persisted to the snapshot file at path, loading the snapshot if
+// it exists. The backend is snapshotted every interval, if it changed, and when closed; an interval
+// of 0 only snapshots on Close. A positive maxSize bounds the backend like NewBoundedMapBackend.
+func OpenMapBackend(path string, interval time.Duration, maxSize int64) (*MapBackend, error) {
+	backend := &MapBackend{storage: make(map[string][]byte), maxSize: maxSize, path: path, stop: make(chan struct{})}
+
+	file, err := os.Open(path)
+	if err == nil {
+		err = gob.NewDecoder(bufio.NewReader(file)).Decode(&backend.storage)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not load snapshot %s, %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for key, value := range backend.storage {
+		backend.size += int64(len(key) + len(value))
+	}
+
+	if interval > 0 {
+		backend.wg.Add(1)
+		go func() {
+			defer backend.wg.Done()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if err := backend.SaveSnapshot(); err != nil {
+						log.Warnf("Unable to snapshot the map backend: %s", err)
+					}
+				case <-backend.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	return backend, nil
+}
+
+// SaveSnapshot writes the stored keys and values to the snapshot file if they changed since the last
+// snapshot. The file is replaced atomically, so a crash leaves the previous snapshot intact.
+func (backend *MapBackend) SaveSnapshot() error {
+	if len(backend.path) == 0 {
+		return errors.New("map backend is not persisted")
+	}
+
+	backend.snapshotLock.Lock()
+	defer backend.snapshotLock.Unlock()
+
+	// Stored values are never modified, a shallow copy is enough to encode them without blocking writes
+	backend.lock.RLock()
+	version := backend.version
+	if version == backend.snapshotVersion {
+		backend.lock.RUnlock()
+		return nil
+	}
+	storage := make(map[string][]byte, len(backend.storage))
+	for key, value := range backend.storage {
+		storage[key] = value
+	}
+	backend.lock.RUnlock()
+
+	tmpPath := backend.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	if err = gob.NewEncoder(writer).Encode(storage); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, backend.path); err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	backend.snapshotVersion = version
+	backend.lock.Unlock()
+
+	return nil
+}
+
+// Close stops the periodic snapshots and takes a last snapshot of a persisted map backend
+func (backend *MapBackend) Close() {
+	if len(backend.path) == 0 {
+		return
+	}
+
+	backend.stopOnce.Do(func() { close(backend.stop) })
+	backend.wg.Wait()
+
+	if err := backend.SaveSnapshot(); err != nil {
+		log.Errorf("Unable to snapshot the map backend: %s", err)
+	}
+}
+
+// Flush writes a snapshot of a persisted map backend, like the periodic snapshots. It does nothing if
+// the backend is not persisted.
+func (backend *MapBackend) Flush() error {
+	if len(backend.path) == 0 {
+		return nil
+	}
+
+	return backend.SaveSnapshot()
+}
+
+// Compact reports the size of the snapshot file of a persisted map backend as the LSM sizes. There is
+// nothing to reclaim, deleted values are dropped from memory right away and every snapshot is
+// written in full.
+func (backend *MapBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	stats, err := backend.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompactionResult{LSMSizeBefore: stats.DiskSize, LSMSizeAfter: stats.DiskSize}, nil
+}
+
+// Backup writes a backup stream of the records of a snapshot to w, incremental backups are not supported
+func (backend *MapBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *MapBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// Begin begins a transaction batching its writes, the map backend cannot detect conflicts
+func (backend *MapBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Size returns the total size of the stored keys and values in bytes
+func (backend *MapBackend) Size() int64 {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	return backend.size
 }
 
 // Reset resets the database
 func (backend *MapBackend) Reset() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
 	backend.storage = make(map[string][]byte)
+	backend.snapshots = 0
+	backend.generation++
+	backend.size = 0
+	backend.version++
 	return nil
 }
 
@@ -31,7 +251,61 @@ func (backend *MapBackend) Put(key []byte, value []byte) error {
 		return errors.New("cannot put a nil value")
 	}
 
-	backend.storage[string(key)] = value
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	size := backend.size + int64(len(key)+len(value))
+	if old, ok := backend.storage[string(key)]; ok {
+		size -= int64(len(key) + len(old))
+	}
+	if backend.maxSize > 0 && size > backend.maxSize {
+		return &MapBackendFull{MaxSize: backend.maxSize}
+	}
+
+	backend.own()
+	backend.storage[string(key)] = append(make([]byte, 0, len(value)), value...)
+	backend.size = size
+	backend.version++
+	return nil
+}
+
+// PutBatch adds the requested values to the database, a bounded backend stores none of them if they
+// do not all fit
+func (backend *MapBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if len(pair.Key) == 0 {
+			return errors.New("cannot put an empty key")
+		}
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	// A key may be written more than once, the size follows the last value
+	size := backend.size
+	lengths := make(map[string]int, len(pairs))
+	for _, pair := range pairs {
+		if length, ok := lengths[string(pair.Key)]; ok {
+			size -= int64(len(pair.Key) + length)
+		} else if old, ok := backend.storage[string(pair.Key)]; ok {
+			size -= int64(len(pair.Key) + len(old))
+		}
+		size += int64(len(pair.Key) + len(pair.Value))
+		lengths[string(pair.Key)] = len(pair.Value)
+	}
+	if backend.maxSize > 0 && size > backend.maxSize {
+		return &MapBackendFull{MaxSize: backend.maxSize}
+	}
+
+	backend.own()
+	for _, pair := range pairs {
+		backend.storage[string(pair.Key)] = append(make([]byte, 0, len(pair.Value)), pair.Value...)
+	}
+	backend.size = size
+	backend.version++
 	return nil
 }
 
@@ -43,11 +317,107 @@ func (backend *MapBackend) Delete(key []byte) error {
 		return errors.New("cannot remove an empty key")
 	}
 
-	delete(backend.storage, string(key))
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if old, ok := backend.storage[string(key)]; ok {
+		backend.own()
+		backend.size -= int64(len(key) + len(old))
+		delete(backend.storage, string(key))
+		backend.version++
+	}
 
 	return nil
 }
 
+// Iterate calls fn with copies of the keys starting with prefix and their values, as they were when
+// the iteration started
+func (backend *MapBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	backend.lock.RLock()
+	pairs := mapPairs(backend.storage, prefix)
+	backend.lock.RUnlock()
+
+	for _, pair := range pairs {
+		if err := fn(pair.Key, pair.Value); err != nil {
+			return stopIteration(err)
+		}
+	}
+
+	return nil
+}
+
+// Snapshot shares the stored values with a snapshot, the first write while it is held copies the map.
+// Stored values are never modified, so the copy is shallow.
+func (backend *MapBackend) Snapshot() (BackendSnapshot, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.snapshots++
+	return &mapSnapshot{backend: backend, storage: backend.storage, generation: backend.generation}, nil
+}
+
+// own copies the map before it is written if it is shared with a snapshot, the lock must be held
+func (backend *MapBackend) own() {
+	if backend.snapshots == 0 {
+		return
+	}
+
+	storage := make(map[string][]byte, len(backend.storage))
+	for key, value := range backend.storage {
+		storage[key] = value
+	}
+	backend.storage = storage
+	backend.snapshots = 0
+	backend.generation++
+}
+
+// mapSnapshot reads a map which is no longer written
+type mapSnapshot struct {
+	backend    *MapBackend
+	storage    map[string][]byte
+	generation uint64
+}
+
+func (snapshot *mapSnapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	return append(make([]byte, 0), snapshot.storage[string(key)]...), nil
+}
+
+func (snapshot *mapSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	for _, pair := range mapPairs(snapshot.storage, prefix) {
+		if err := fn(pair.Key, pair.Value); err != nil {
+			return stopIteration(err)
+		}
+	}
+
+	return nil
+}
+
+func (snapshot *mapSnapshot) Release() {
+	snapshot.backend.lock.Lock()
+	defer snapshot.backend.lock.Unlock()
+
+	if snapshot.generation == snapshot.backend.generation {
+		snapshot.backend.snapshots--
+	}
+}
+
+// mapPairs returns copies of the keys of storage starting with prefix and their values, sorted by key
+func mapPairs(storage map[string][]byte, prefix []byte) []*KeyValue {
+	pairs := make([]*KeyValue, 0)
+	for key, value := range storage {
+		if strings.HasPrefix(key, string(prefix)) {
+			pairs = append(pairs, &KeyValue{Key: []byte(key), Value: append(make([]byte, 0, len(value)), value...)})
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].Key, pairs[j].Key) < 0 })
+	return pairs
+}
+
 // Get fetches the requested value from the database
 func (backend *MapBackend) Get(key []byte) ([]byte, error) {
 	if key == nil {
@@ -56,10 +426,63 @@ func (backend *MapBackend) Get(key []byte) ([]byte, error) {
 		return nil, errors.New("cannot get an empty key")
 	}
 
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
 	val, ok := backend.storage[string(key)]
 	if ok {
-		return val, nil
+		return append(make([]byte, 0, len(val)), val...), nil
 	}
 
 	return make([]byte, 0), nil
 }
+
+// Has reports whether key is stored
+func (backend *MapBackend) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("cannot check an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	_, ok := backend.storage[string(key)]
+	return ok, nil
+}
+
+// Health reports a bounded map backend as not writable once it is full, and the free space on the
+// snapshot volume of a persisted map backend
+func (backend *MapBackend) Health() (*BackendHealth, error) {
+	backend.lock.RLock()
+	health := &BackendHealth{Writable: backend.maxSize <= 0 || backend.size < backend.maxSize}
+	backend.lock.RUnlock()
+
+	if len(backend.path) > 0 {
+		free, err := diskFree(filepath.Dir(backend.path))
+		if err != nil {
+			return nil, err
+		}
+		health.DiskFree = &free
+	}
+
+	return health, nil
+}
+
+// Stats reports the number of keys, and the size of the snapshot file of a persisted map backend
+func (backend *MapBackend) Stats() (*BackendStats, error) {
+	backend.lock.RLock()
+	keys := uint64(len(backend.storage))
+	backend.lock.RUnlock()
+
+	stats := &BackendStats{Keys: &keys}
+	if len(backend.path) > 0 {
+		info, err := os.Stat(backend.path)
+		if err == nil {
+			stats.DiskSize = info.Size()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,117 @@
+package bstore
+
+import "io"
+
+// MemoBackend wraps a backend and memoizes the values read through it, so traversals which visit the
+// same block record more than once only read it from the backend once.
+//
+// It is meant to live for a single request. Writes through it update the memoized value, writes
+// made directly to the wrapped backend are not seen.
+type MemoBackend struct {
+	Backend BlockStoreBackend
+
+	values map[string][]byte
+}
+
+// NewMemoBackend creates a MemoBackend reading from backend
+func NewMemoBackend(backend BlockStoreBackend) *MemoBackend {
+	return &MemoBackend{Backend: backend, values: make(map[string][]byte)}
+}
+
+// Reset resets the wrapped database
+func (backend *MemoBackend) Reset() error {
+	backend.values = make(map[string][]byte)
+	return backend.Backend.Reset()
+}
+
+// Put adds the requested value to the wrapped database
+func (backend *MemoBackend) Put(key []byte, value []byte) error {
+	delete(backend.values, string(key))
+	if err := backend.Backend.Put(key, value); err != nil {
+		return err
+	}
+
+	backend.values[string(key)] = value
+	return nil
+}
+
+// PutBatch adds the requested values to the wrapped database
+func (backend *MemoBackend) PutBatch(pairs []*KeyValue) error {
+	for _, pair := range pairs {
+		delete(backend.values, string(pair.Key))
+	}
+	if err := backend.Backend.PutBatch(pairs); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		backend.values[string(pair.Key)] = pair.Value
+	}
+	return nil
+}
+
+// Delete removes an item from the wrapped database
+func (backend *MemoBackend) Delete(key []byte) error {
+	delete(backend.values, string(key))
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value, reading the wrapped database only the first time a key is requested
+func (backend *MemoBackend) Get(key []byte) ([]byte, error) {
+	if value, ok := backend.values[string(key)]; ok {
+		return value, nil
+	}
+
+	value, err := backend.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	backend.values[string(key)] = value
+	return value, nil
+}
+
+// Has reports whether key is stored, from the memoized value if it was read
+func (backend *MemoBackend) Has(key []byte) (bool, error) {
+	if value, ok := backend.values[string(key)]; ok {
+		return len(value) > 0, nil
+	}
+
+	return backend.Backend.Has(key)
+}
+
+// Iterate iterates over the wrapped database, the values are not memoized
+func (backend *MemoBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, fn)
+}
+
+// Snapshot takes a snapshot of the wrapped database, values are not memoized through it
+func (backend *MemoBackend) Snapshot() (BackendSnapshot, error) {
+	return backend.Backend.Snapshot()
+}
+
+// Flush flushes the wrapped database
+func (backend *MemoBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Compact compacts the wrapped database, the memoized values are unchanged by it
+func (backend *MemoBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped database
+func (backend *MemoBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped database and forgets the memoized values
+func (backend *MemoBackend) Load(r io.Reader) error {
+	backend.values = make(map[string][]byte)
+	return backend.Backend.Load(r)
+}
+
+// Begin begins a transaction batching its writes through the memo
+func (backend *MemoBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
@@ -0,0 +1,76 @@
+package bstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+// countingBackend counts the reads of each key
+type countingBackend struct {
+	BlockStoreBackend
+	reads map[string]int
+}
+
+func (backend *countingBackend) Get(key []byte) ([]byte, error) {
+	backend.reads[string(key)]++
+	return backend.BlockStoreBackend.Get(key)
+}
+
+func (backend *countingBackend) maxReads() int {
+	max := 0
+	for _, n := range backend.reads {
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func TestMemoizedTraversal(t *testing.T) {
+	backend := &countingBackend{BlockStoreBackend: NewMapBackend(), reads: make(map[string]int)}
+	handler := RequestHandler{Backend: backend}
+
+	// Build all but the last block, then count the reads adding it at a skip list anchor height
+	chain := []uint64{0}
+	for i := uint64(1); i <= 1024; i++ {
+		chain = append(chain, 100+i)
+	}
+	bt := ToBlockTree(NewMockBlockTree([][]uint64{chain}))
+	for i := uint64(1); i < 1024; i++ {
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[100+i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend.reads = make(map[string]int)
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[1124]}); err != nil {
+		t.Fatal(err)
+	}
+	if backend.maxReads() > 1 {
+		t.Errorf("expected each key to be read at most once adding a block, read %d times", backend.maxReads())
+	}
+
+	backend.reads = make(map[string]int)
+	_, err := handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{
+		HeadBlockId:         bt.ByNum[1124].GetId(),
+		AncestorStartHeight: 500,
+		NumBlocks:           100,
+		ReturnBlock:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.maxReads() > 1 {
+		t.Errorf("expected each key to be read at most once getting blocks, read %d times", backend.maxReads())
+	}
+
+	// Writes through the memo backend are visible to later reads
+	memo := NewMemoBackend(NewMapBackend())
+	_, _ = memo.Get([]byte{1})
+	_ = memo.Put([]byte{1}, []byte{2})
+	if value, _ := memo.Get([]byte{1}); len(value) != 1 || value[0] != 2 {
+		t.Errorf("expected written value, got %v", value)
+	}
+}
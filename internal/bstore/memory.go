@@ -0,0 +1,74 @@
+package bstore
+
+import (
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	// Shares of the memory limit given to Badger, the rest is left to the Go heap for request handling
+	blockCacheShare = 0.25
+	indexCacheShare = 0.10
+	memTableShare   = 0.15
+
+	minMemTableSize   = 8 << 20
+	maxMemTableSize   = 64 << 20
+	minNumMemtables   = 2
+	maxNumMemtables   = 5
+	minBlockCacheSize = 16 << 20
+)
+
+// MemoryBudget is the amount of memory given to each of Badger's caches and memtables when the
+// process runs under a memory limit
+type MemoryBudget struct {
+	BlockCacheSize int64
+	IndexCacheSize int64
+	MemTableSize   int64
+	NumMemtables   int
+}
+
+// NewMemoryBudget sizes Badger's caches and memtables from a memory limit in bytes. Memtables are
+// never larger than Badger's defaults, so a large limit only grows the caches.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	budget := &MemoryBudget{
+		BlockCacheSize: int64(float64(limit) * blockCacheShare),
+		IndexCacheSize: int64(float64(limit) * indexCacheShare),
+	}
+
+	if budget.BlockCacheSize < minBlockCacheSize {
+		budget.BlockCacheSize = minBlockCacheSize
+	}
+
+	memTables := int64(float64(limit) * memTableShare)
+
+	budget.NumMemtables = maxNumMemtables
+	budget.MemTableSize = memTables / int64(budget.NumMemtables)
+	if budget.MemTableSize > maxMemTableSize {
+		budget.MemTableSize = maxMemTableSize
+	}
+
+	// Prefer fewer memtables over memtables so small they are flushed constantly
+	for budget.MemTableSize < minMemTableSize && budget.NumMemtables > minNumMemtables {
+		budget.NumMemtables--
+		budget.MemTableSize = memTables / int64(budget.NumMemtables)
+	}
+
+	if budget.MemTableSize < minMemTableSize {
+		budget.MemTableSize = minMemTableSize
+	}
+
+	return budget
+}
+
+// Apply returns opts with the cache and memtable sizes set from the budget
+func (b *MemoryBudget) Apply(opts badger.Options) badger.Options {
+	return opts.
+		WithBlockCacheSize(b.BlockCacheSize).
+		WithIndexCacheSize(b.IndexCacheSize).
+		WithMemTableSize(b.MemTableSize).
+		WithNumMemtables(b.NumMemtables)
+}
+
+// Total returns the memory the budget gives to Badger
+func (b *MemoryBudget) Total() int64 {
+	return b.BlockCacheSize + b.IndexCacheSize + b.MemTableSize*int64(b.NumMemtables)
+}
@@ -0,0 +1,40 @@
+package bstore
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	// Memtables are capped at Badger's defaults, the caches grow with the limit
+	budget := NewMemoryBudget(8 << 30)
+	if budget.MemTableSize != maxMemTableSize || budget.NumMemtables != maxNumMemtables {
+		t.Errorf("unexpected memtables %+v", budget)
+	}
+	if budget.BlockCacheSize != 2<<30 {
+		t.Errorf("unexpected block cache size %d", budget.BlockCacheSize)
+	}
+
+	// Small limits trade memtable count for memtable size
+	budget = NewMemoryBudget(256 << 20)
+	if budget.MemTableSize < minMemTableSize || budget.NumMemtables >= maxNumMemtables {
+		t.Errorf("unexpected memtables %+v", budget)
+	}
+	if budget.Total() > 256<<20/2 {
+		t.Errorf("budget %d exceeds half of the limit", budget.Total())
+	}
+
+	// Tiny limits still get a usable configuration
+	budget = NewMemoryBudget(1 << 20)
+	if budget.MemTableSize != minMemTableSize || budget.NumMemtables != minNumMemtables || budget.BlockCacheSize != minBlockCacheSize {
+		t.Errorf("unexpected budget %+v", budget)
+	}
+
+	budget = NewMemoryBudget(1 << 30)
+	opts := budget.Apply(badger.DefaultOptions(""))
+	if opts.BlockCacheSize != budget.BlockCacheSize || opts.IndexCacheSize != budget.IndexCacheSize ||
+		opts.MemTableSize != budget.MemTableSize || opts.NumMemtables != budget.NumMemtables {
+		t.Errorf("budget %+v not applied", budget)
+	}
+}
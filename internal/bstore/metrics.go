@@ -0,0 +1,353 @@
+package bstore
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric is a sample of a block store metric. Counters are cumulative since the block store started.
+type Metric struct {
+	Name    string
+	Labels  map[string]string
+	Value   float64
+	Counter bool
+}
+
+// Bucket upper bounds of the backend latency and value size histograms
+var (
+	backendLatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+	backendSizeBuckets    = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+)
+
+type requestMetrics struct {
+	requests uint64
+	errors   uint64
+}
+
+// histogram counts observations into buckets by upper bound, observations above the last bound are
+// only counted in the total
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.count++
+	h.sum += value
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+// samples returns the cumulative bucket counters, the sum and the count of the histogram in the
+// Prometheus histogram layout
+func (h *histogram) samples(name string, labels map[string]string) []*Metric {
+	withLabel := func(key string, value string) map[string]string {
+		merged := map[string]string{key: value}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		return merged
+	}
+
+	metrics := make([]*Metric, 0, len(h.bounds)+3)
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		metrics = append(metrics, &Metric{
+			Name:    name + "_bucket",
+			Labels:  withLabel("le", strconv.FormatFloat(bound, 'g', -1, 64)),
+			Value:   float64(cumulative),
+			Counter: true,
+		})
+	}
+	metrics = append(metrics,
+		&Metric{Name: name + "_bucket", Labels: withLabel("le", "+Inf"), Value: float64(h.count), Counter: true},
+		&Metric{Name: name + "_sum", Labels: labels, Value: h.sum, Counter: true},
+		&Metric{Name: name + "_count", Labels: labels, Value: float64(h.count), Counter: true},
+	)
+
+	return metrics
+}
+
+// backendMetrics are the metrics of one backend operation
+type backendMetrics struct {
+	requestMetrics
+
+	latency *histogram
+	size    *histogram
+}
+
+// Metrics counts the requests served by the request handler, the blocks changed or dropped by ingest
+// filters and the backend operations, and samples the latencies of the requests
+type Metrics struct {
+	lock      sync.Mutex
+	requests  map[string]*requestMetrics
+	filtered  map[string]uint64
+	backend   map[string]*backendMetrics
+	latencies map[string]*latencySample
+}
+
+// NewMetrics returns empty request metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[string]*requestMetrics),
+		filtered:  make(map[string]uint64),
+		backend:   make(map[string]*backendMetrics),
+		latencies: make(map[string]*latencySample),
+	}
+}
+
+// recordRequest counts a request by name, and whether it failed, and samples its latency. It does
+// nothing on nil Metrics.
+func (m *Metrics) recordRequest(request string, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counts, ok := m.requests[request]
+	if !ok {
+		counts = &requestMetrics{}
+		m.requests[request] = counts
+	}
+
+	counts.requests++
+	if err != nil {
+		counts.errors++
+	}
+
+	sample, ok := m.latencies[request]
+	if !ok {
+		sample = &latencySample{}
+		m.latencies[request] = sample
+	}
+	sample.add(latency)
+}
+
+// recordFiltered counts a block changed or dropped by an ingest filter. It does nothing on nil Metrics.
+func (m *Metrics) recordFiltered(filter string) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.filtered[filter]++
+}
+
+// recordBackendOperation records the latency of a backend operation, whether it failed and, if it
+// succeeded and size is not negative, the size of the value read or written. It does nothing on nil
+// Metrics.
+func (m *Metrics) recordBackendOperation(operation string, latency time.Duration, size int, err error) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	op, ok := m.backend[operation]
+	if !ok {
+		op = &backendMetrics{latency: newHistogram(backendLatencyBuckets), size: newHistogram(backendSizeBuckets)}
+		m.backend[operation] = op
+	}
+
+	op.requests++
+	op.latency.observe(latency.Seconds())
+	if err != nil {
+		op.errors++
+		return
+	}
+
+	if size >= 0 {
+		op.size.observe(float64(size))
+	}
+}
+
+// samples returns the request and error counters, ordered by request name, the ingest filter
+// counters, ordered by filter name, and the backend operation metrics, ordered by operation
+func (m *Metrics) samples() []*Metric {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0, len(m.requests))
+	for name := range m.requests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]*Metric, 0, 2*len(names))
+	for _, name := range names {
+		metrics = append(metrics, &Metric{
+			Name:    "block_store_requests_total",
+			Labels:  map[string]string{"request": name},
+			Value:   float64(m.requests[name].requests),
+			Counter: true,
+		})
+	}
+	for _, name := range names {
+		metrics = append(metrics, &Metric{
+			Name:    "block_store_request_errors_total",
+			Labels:  map[string]string{"request": name},
+			Value:   float64(m.requests[name].errors),
+			Counter: true,
+		})
+	}
+
+	filters := make([]string, 0, len(m.filtered))
+	for name := range m.filtered {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+
+	for _, name := range filters {
+		metrics = append(metrics, &Metric{
+			Name:    "block_store_ingest_filtered_total",
+			Labels:  map[string]string{"filter": name},
+			Value:   float64(m.filtered[name]),
+			Counter: true,
+		})
+	}
+
+	return append(metrics, m.backendSamples()...)
+}
+
+// backendSamples returns the backend operation metrics, the caller must hold the lock. Value sizes
+// are only reported for operations which read or write values.
+func (m *Metrics) backendSamples() []*Metric {
+	operations := make([]string, 0, len(m.backend))
+	for name := range m.backend {
+		operations = append(operations, name)
+	}
+	sort.Strings(operations)
+
+	var metrics []*Metric
+	for _, name := range operations {
+		metrics = append(metrics, &Metric{
+			Name:    "block_store_backend_operations_total",
+			Labels:  map[string]string{"operation": name},
+			Value:   float64(m.backend[name].requests),
+			Counter: true,
+		})
+	}
+	for _, name := range operations {
+		metrics = append(metrics, &Metric{
+			Name:    "block_store_backend_errors_total",
+			Labels:  map[string]string{"operation": name},
+			Value:   float64(m.backend[name].errors),
+			Counter: true,
+		})
+	}
+
+	// Samples of the same name must be adjacent, so each histogram part is listed for all operations
+	var latency, size [][]*Metric
+	for _, name := range operations {
+		labels := map[string]string{"operation": name}
+		latency = append(latency, m.backend[name].latency.samples("block_store_backend_latency_seconds", labels))
+		if m.backend[name].size.count > 0 {
+			size = append(size, m.backend[name].size.samples("block_store_backend_value_bytes", labels))
+		}
+	}
+
+	return append(append(metrics, interleaveHistograms(latency)...), interleaveHistograms(size)...)
+}
+
+// interleaveHistograms orders the samples of histograms with different labels so the buckets, sums
+// and counts are each adjacent
+func interleaveHistograms(histograms [][]*Metric) []*Metric {
+	var metrics []*Metric
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		for _, samples := range histograms {
+			for _, sample := range samples {
+				if strings.HasSuffix(sample.Name, suffix) {
+					metrics = append(metrics, sample)
+				}
+			}
+		}
+	}
+
+	return metrics
+}
+
+// CollectMetrics returns the request counters, if the handler has Metrics, and gauges of the chain
+// held by the store, of the backend health and of the backend statistics
+func (handler *RequestHandler) CollectMetrics() ([]*Metric, error) {
+	var metrics []*Metric
+	if handler.Metrics != nil {
+		metrics = handler.Metrics.samples()
+	}
+
+	handler.lock.RLock()
+	head, err := handler.getTopologyAtKey(highestBlockKey)
+	var irreversible *Topology
+	if err == nil {
+		irreversible, err = handler.getTopologyAtKey(irreversibleKey)
+	}
+	handler.lock.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if head != nil {
+		metrics = append(metrics, &Metric{Name: "block_store_head_height", Value: float64(head.Height)})
+	}
+	if irreversible != nil {
+		metrics = append(metrics, &Metric{Name: "block_store_irreversible_height", Value: float64(irreversible.Height)})
+	}
+
+	_, age, stale := handler.headStaleness()
+	staleValue := 0.0
+	if stale {
+		staleValue = 1
+	}
+	metrics = append(metrics,
+		&Metric{Name: "block_store_head_unchanged_seconds", Value: age.Seconds()},
+		&Metric{Name: "block_store_head_stale", Value: staleValue},
+	)
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	writable := 0.0
+	if health.Writable {
+		writable = 1
+	}
+	diskFull := 0.0
+	if health.DiskFull {
+		diskFull = 1
+	}
+	metrics = append(metrics,
+		&Metric{Name: "block_store_writable", Value: writable},
+		&Metric{Name: "block_store_disk_full", Value: diskFull},
+		&Metric{Name: "block_store_pending_compactions", Value: float64(health.PendingCompactions)},
+	)
+	if health.DiskFreeBytes != nil {
+		metrics = append(metrics, &Metric{Name: "block_store_disk_free_bytes", Value: float64(*health.DiskFreeBytes)})
+	}
+
+	stats, err := handler.statsMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(metrics, stats...), nil
+}
@@ -0,0 +1,204 @@
+package bstore
+
+import (
+	"io"
+	"time"
+)
+
+// Backend operation names reported by MetricsBackend
+const (
+	backendOperationGet    = "get"
+	backendOperationHas    = "has"
+	backendOperationPut    = "put"
+	backendOperationBatch  = "put_batch"
+	backendOperationDelete = "delete"
+)
+
+// MetricsBackend records the latency, the errors and the value sizes of the Get, Has, Put, PutBatch
+// and Delete calls to the wrapped backend in Metrics, showing how much of the request latency is spent in storage.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend.
+type MetricsBackend struct {
+	Backend BlockStoreBackend
+	Metrics *Metrics
+}
+
+// NewMetricsBackend creates a MetricsBackend over backend recording into metrics
+func NewMetricsBackend(backend BlockStoreBackend, metrics *Metrics) *MetricsBackend {
+	return &MetricsBackend{Backend: backend, Metrics: metrics}
+}
+
+// Reset resets the wrapped database
+func (backend *MetricsBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put stores the value in the wrapped database
+func (backend *MetricsBackend) Put(key []byte, value []byte) error {
+	start := time.Now()
+	err := backend.Backend.Put(key, value)
+	backend.Metrics.recordBackendOperation(backendOperationPut, time.Since(start), len(value), err)
+
+	return err
+}
+
+// PutWithTTL stores the value in the wrapped database with a TTL, recording it as a put
+func (backend *MetricsBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := putWithTTL(backend.Backend, key, value, ttl)
+	backend.Metrics.recordBackendOperation(backendOperationPut, time.Since(start), len(value), err)
+
+	return err
+}
+
+// PutBatch stores the values in the wrapped database, the size recorded is the total of the values
+func (backend *MetricsBackend) PutBatch(pairs []*KeyValue) error {
+	size := 0
+	for _, pair := range pairs {
+		size += len(pair.Value)
+	}
+
+	start := time.Now()
+	err := backend.Backend.PutBatch(pairs)
+	backend.Metrics.recordBackendOperation(backendOperationBatch, time.Since(start), size, err)
+
+	return err
+}
+
+// Delete removes an item from the wrapped database
+func (backend *MetricsBackend) Delete(key []byte) error {
+	start := time.Now()
+	err := backend.Backend.Delete(key)
+	backend.Metrics.recordBackendOperation(backendOperationDelete, time.Since(start), -1, err)
+
+	return err
+}
+
+// Get fetches the requested value from the wrapped database
+func (backend *MetricsBackend) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := backend.Backend.Get(key)
+	backend.Metrics.recordBackendOperation(backendOperationGet, time.Since(start), len(value), err)
+
+	return value, err
+}
+
+// Has reports whether the wrapped database stores key
+func (backend *MetricsBackend) Has(key []byte) (bool, error) {
+	start := time.Now()
+	found, err := backend.Backend.Has(key)
+	backend.Metrics.recordBackendOperation(backendOperationHas, time.Since(start), -1, err)
+
+	return found, err
+}
+
+// Iterate iterates over the wrapped database, it is not recorded
+func (backend *MetricsBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, fn)
+}
+
+// Snapshot takes a snapshot of the wrapped database
+func (backend *MetricsBackend) Snapshot() (BackendSnapshot, error) {
+	return backend.Backend.Snapshot()
+}
+
+// Flush flushes the wrapped database
+func (backend *MetricsBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database. Its reads are recorded like Get and its commit
+// like PutBatch, the writes before the commit are not recorded.
+func (backend *MetricsBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsTxn{BackendTxn: txn, metrics: backend.Metrics}, nil
+}
+
+// metricsTxn records the reads and the commit of a transaction of the wrapped database
+type metricsTxn struct {
+	BackendTxn
+	metrics *Metrics
+
+	// size is the total size of the values written
+	size int
+}
+
+func (txn *metricsTxn) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := txn.BackendTxn.Get(key)
+	txn.metrics.recordBackendOperation(backendOperationGet, time.Since(start), len(value), err)
+
+	return value, err
+}
+
+func (txn *metricsTxn) Has(key []byte) (bool, error) {
+	start := time.Now()
+	found, err := txn.BackendTxn.Has(key)
+	txn.metrics.recordBackendOperation(backendOperationHas, time.Since(start), -1, err)
+
+	return found, err
+}
+
+func (txn *metricsTxn) Put(key []byte, value []byte) error {
+	if err := txn.BackendTxn.Put(key, value); err != nil {
+		return err
+	}
+
+	txn.size += len(value)
+	return nil
+}
+
+func (txn *metricsTxn) Commit() error {
+	start := time.Now()
+	err := txn.BackendTxn.Commit()
+	txn.metrics.recordBackendOperation(backendOperationBatch, time.Since(start), txn.size, err)
+
+	return err
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *MetricsBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *MetricsBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend
+func (backend *MetricsBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend
+func (backend *MetricsBackend) Load(r io.Reader) error {
+	return backend.Backend.Load(r)
+}
+
+// BulkLoad replaces the contents of the wrapped database with the records of source
+func (backend *MetricsBackend) BulkLoad(source RecordSource) error {
+	return BulkLoad(backend.Backend, source)
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *MetricsBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *MetricsBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
@@ -0,0 +1,59 @@
+package bstore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsBackendBasic(t *testing.T) {
+	backendTest(t, NewMetricsBackend(NewMapBackend(), NewMetrics()))
+}
+
+func TestMetricsBackend(t *testing.T) {
+	metrics := NewMetrics()
+	backend := NewMetricsBackend(NewMapBackend(), metrics)
+
+	if err := backend.Put([]byte("small"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Put([]byte("large"), bytes.Repeat([]byte{1}, 2000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Get([]byte("large")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Get(nil); err == nil {
+		t.Error("expected an error getting an empty key")
+	}
+	if err := backend.Delete([]byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(formatPrometheus(metrics.samples()))
+	for _, line := range []string{
+		`block_store_backend_operations_total{operation="get"} 2` + "\n",
+		`block_store_backend_operations_total{operation="put"} 2` + "\n",
+		`block_store_backend_errors_total{operation="get"} 1` + "\n",
+		`block_store_backend_errors_total{operation="put"} 0` + "\n",
+		`block_store_backend_latency_seconds_bucket{le="+Inf",operation="delete"} 1` + "\n",
+		`block_store_backend_latency_seconds_count{operation="get"} 2` + "\n",
+		`block_store_backend_value_bytes_bucket{le="64",operation="put"} 1` + "\n",
+		`block_store_backend_value_bytes_bucket{le="4096",operation="put"} 2` + "\n",
+		`block_store_backend_value_bytes_sum{operation="put"} 2005` + "\n",
+		`block_store_backend_value_bytes_count{operation="get"} 1` + "\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in metrics:\n%s", line, body)
+		}
+	}
+
+	if strings.Contains(body, `block_store_backend_value_bytes_count{operation="delete"}`) {
+		t.Error("expected no value sizes for deletes")
+	}
+
+	// Each metric name is announced once, so its samples must be adjacent
+	if n := strings.Count(body, "# TYPE block_store_backend_latency_seconds_bucket "); n != 1 {
+		t.Errorf("expected one latency bucket TYPE line, got %d", n)
+	}
+}
@@ -0,0 +1,222 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+const (
+	// DefaultMetricsPushInterval is the default interval between metrics pushes
+	DefaultMetricsPushInterval = 15 * time.Second
+
+	// DefaultMetricsJob is the default Pushgateway job name
+	DefaultMetricsJob = "block_store"
+
+	metricsPushTimeout = 10 * time.Second
+
+	// statsDPacketSize keeps StatsD datagrams below the common Ethernet MTU
+	statsDPacketSize = 1432
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsPusher pushes the block store metrics every Interval to a Prometheus Pushgateway and/or a
+// StatsD endpoint, for nodes which cannot be scraped
+type MetricsPusher struct {
+	Handler  *RequestHandler
+	Interval time.Duration
+
+	// PushgatewayURL, if set, is the base URL of the Pushgateway. Metrics are pushed to the Job group,
+	// and the Instance group if set.
+	PushgatewayURL string
+	Job            string
+	Instance       string
+
+	// StatsDAddress, if set, is the host:port metrics are sent to over UDP. Metric names are prefixed
+	// with StatsDPrefix and followed by their label values, separated by dots.
+	StatsDAddress string
+	StatsDPrefix  string
+
+	// lastCounters holds the counter values last sent to StatsD, which expects deltas
+	lastCounters map[string]float64
+}
+
+// Start pushes the metrics every Interval until ctx is done
+func (p *MetricsPusher) Start(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultMetricsPushInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.Push(); err != nil {
+					log.Warnf("Unable to push metrics: %s", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Push collects the metrics and pushes them to the configured endpoints
+func (p *MetricsPusher) Push() error {
+	metrics, err := p.Handler.CollectMetrics()
+	if err != nil {
+		return err
+	}
+
+	if len(p.PushgatewayURL) > 0 {
+		if err = p.pushPrometheus(metrics); err != nil {
+			return err
+		}
+	}
+
+	if len(p.StatsDAddress) > 0 {
+		if err = p.pushStatsD(metrics); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *MetricsPusher) pushPrometheus(metrics []*Metric) error {
+	job := p.Job
+	if len(job) == 0 {
+		job = DefaultMetricsJob
+	}
+
+	pushURL := strings.TrimSuffix(p.PushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	if len(p.Instance) > 0 {
+		pushURL += "/instance/" + url.PathEscape(p.Instance)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, pushURL, bytes.NewReader(formatPrometheus(metrics)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := http.Client{Timeout: metricsPushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("metrics push to %s failed with status %s", pushURL, resp.Status)
+	}
+
+	return nil
+}
+
+// formatPrometheus renders metrics in the Prometheus text format. Metrics of the same name must be adjacent.
+func formatPrometheus(metrics []*Metric) []byte {
+	var buf bytes.Buffer
+	name := ""
+
+	for _, metric := range metrics {
+		if metric.Name != name {
+			name = metric.Name
+			metricType := "gauge"
+			if metric.Counter {
+				metricType = "counter"
+			}
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", name, metricType)
+		}
+
+		buf.WriteString(name)
+		if len(metric.Labels) > 0 {
+			labels := make([]string, 0, len(metric.Labels))
+			for _, key := range sortedLabelKeys(metric.Labels) {
+				labels = append(labels, fmt.Sprintf(`%s="%s"`, key, labelValueEscaper.Replace(metric.Labels[key])))
+			}
+			buf.WriteString("{" + strings.Join(labels, ",") + "}")
+		}
+		buf.WriteString(" " + strconv.FormatFloat(metric.Value, 'g', -1, 64) + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+func (p *MetricsPusher) pushStatsD(metrics []*Metric) error {
+	conn, err := net.Dial("udp", p.StatsDAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if p.lastCounters == nil {
+		p.lastCounters = make(map[string]float64)
+	}
+
+	var packet bytes.Buffer
+	for _, metric := range metrics {
+		name := p.StatsDPrefix + metric.Name
+		for _, key := range sortedLabelKeys(metric.Labels) {
+			name += "." + metric.Labels[key]
+		}
+
+		var line string
+		if metric.Counter {
+			delta := metric.Value - p.lastCounters[name]
+			if delta < 0 {
+				delta = metric.Value
+			}
+			p.lastCounters[name] = metric.Value
+			if delta == 0 {
+				continue
+			}
+			line = name + ":" + strconv.FormatFloat(delta, 'f', -1, 64) + "|c"
+		} else {
+			line = name + ":" + strconv.FormatFloat(metric.Value, 'f', -1, 64) + "|g"
+		}
+
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsDPacketSize {
+			if _, err = conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		if _, err = conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,91 @@
+package bstore
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestMetricsPusher(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), Metrics: NewMetrics()}
+	buildLinearChain(t, &handler, 3)
+
+	handler.HandleRequest(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetHighestBlock{
+		GetHighestBlock: &block_store.GetHighestBlockRequest{},
+	}})
+	handler.HandleExtendedRequest(&ExtendedRequest{})
+
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+	}))
+	defer server.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	pusher := &MetricsPusher{
+		Handler:        &handler,
+		PushgatewayURL: server.URL + "/",
+		Instance:       "node 1",
+		StatsDAddress:  conn.LocalAddr().String(),
+		StatsDPrefix:   "koinos.",
+	}
+	if err = pusher.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/metrics/job/block_store/instance/node 1" {
+		t.Errorf("unexpected push path %s", path)
+	}
+	for _, line := range []string{
+		"# TYPE block_store_requests_total counter\n",
+		`block_store_requests_total{request="get_highest_block"} 1` + "\n",
+		`block_store_request_errors_total{request="none"} 1` + "\n",
+		"# TYPE block_store_head_height gauge\nblock_store_head_height 3\n",
+		"block_store_writable 1\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in pushed metrics:\n%s", line, body)
+		}
+	}
+
+	readStatsD := func() string {
+		buf := make([]byte, statsDPacketSize)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	packet := readStatsD()
+	for _, line := range []string{"koinos.block_store_requests_total.add_block:3|c", "koinos.block_store_head_height:3|g"} {
+		if !strings.Contains(packet, line) {
+			t.Errorf("expected %q in StatsD packet:\n%s", line, packet)
+		}
+	}
+
+	// StatsD counters are sent as the change since the previous push
+	handler.HandleExtendedRequest(&ExtendedRequest{})
+	pusher.PushgatewayURL = ""
+	if err = pusher.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	packet = readStatsD()
+	if !strings.Contains(packet, "koinos.block_store_requests_total.none:1|c") || strings.Contains(packet, "add_block") {
+		t.Errorf("expected only changed counters in StatsD packet:\n%s", packet)
+	}
+}
@@ -0,0 +1,407 @@
+package bstore
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// legacyBlockKeyFirstByte is the lowest first byte of a block ID. Block records of the v1 keyspace are
+// keyed by their ID, a multihash whose code of a cryptographic hash starts at sha1 (0x11), the bytes
+// below are left to the block store's own records.
+const legacyBlockKeyFirstByte = 0x10
+
+// Namespace is the part of the keyspace owned by one subsystem, the keys starting with one of its
+// bytes. The namespaces of the block store are fixed, so no two subsystems can write the same keys.
+type Namespace struct {
+	Name string
+
+	// firstBytes are the first bytes of the keys of the namespace, in ascending order
+	firstBytes []byte
+}
+
+var (
+	namespaceLock   sync.Mutex
+	namespaceOwners [256]*Namespace
+	namespaceList   []*Namespace
+)
+
+// Namespaces of the records of the block store
+var (
+	// NamespaceInternal holds the records backends keep alongside those of the block store, such as
+	// the shard locations of a ShardedBackend
+	NamespaceInternal = mustRegisterNamespace("internal", 0x00)
+
+	// NamespaceMetadata holds the single records describing the whole database, such as the highest block
+	NamespaceMetadata = mustRegisterNamespace("metadata", highestBlockKey, schemaCountsKey, checkpointKey, irreversibleKey, coldStorageHeightKey, payerIndexLowestKey, walPositionKey, formatVersionKey)
+
+	// NamespaceIndexes holds the indexes of the blocks, keyed by height, block ID or payer
+	NamespaceIndexes = mustRegisterNamespace("indexes", heightIndexPrefix, blockMetadataPrefix, payerIndexPrefix)
+
+	// NamespaceBlocks holds the block records, keyed by block ID, including those of the v1 keyspace
+	// which are yet to be migrated
+	NamespaceBlocks = mustRegisterNamespace("blocks", append([]byte{blockRecordPrefix, blockRecordMultihashPrefix}, byteRange(legacyBlockKeyFirstByte, 0xff)...)...)
+)
+
+// RegisterNamespace reserves the keys starting with firstBytes for a new subsystem. It fails if one of
+// the bytes is already owned by another namespace.
+func RegisterNamespace(name string, firstBytes ...byte) (*Namespace, error) {
+	if len(firstBytes) == 0 {
+		return nil, errors.New("a namespace needs at least one first byte")
+	}
+
+	namespaceLock.Lock()
+	defer namespaceLock.Unlock()
+
+	for _, b := range firstBytes {
+		if owner := namespaceOwners[b]; owner != nil {
+			return nil, fmt.Errorf("keys starting with 0x%02x already belong to namespace %s", b, owner.Name)
+		}
+	}
+
+	namespace := &Namespace{Name: name, firstBytes: append([]byte{}, firstBytes...)}
+	sort.Slice(namespace.firstBytes, func(i, j int) bool { return namespace.firstBytes[i] < namespace.firstBytes[j] })
+	for _, b := range namespace.firstBytes {
+		namespaceOwners[b] = namespace
+	}
+	namespaceList = append(namespaceList, namespace)
+
+	return namespace, nil
+}
+
+func mustRegisterNamespace(name string, firstBytes ...byte) *Namespace {
+	namespace, err := RegisterNamespace(name, firstBytes...)
+	if err != nil {
+		panic(err)
+	}
+
+	return namespace
+}
+
+// Namespaces returns the registered namespaces, in the order they were registered
+func Namespaces() []*Namespace {
+	namespaceLock.Lock()
+	defer namespaceLock.Unlock()
+
+	return append([]*Namespace{}, namespaceList...)
+}
+
+// Contains returns true if key belongs to the namespace
+func (namespace *Namespace) Contains(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	i := sort.Search(len(namespace.firstBytes), func(i int) bool { return namespace.firstBytes[i] >= key[0] })
+	return i < len(namespace.firstBytes) && namespace.firstBytes[i] == key[0]
+}
+
+func byteRange(first byte, last byte) []byte {
+	bytes := make([]byte, 0, int(last)-int(first)+1)
+	for b := int(first); b <= int(last); b++ {
+		bytes = append(bytes, byte(b))
+	}
+
+	return bytes
+}
+
+// KeyOutsideNamespace is returned by a NamespaceBackend for a key which belongs to none of its
+// namespaces
+type KeyOutsideNamespace struct {
+	Key []byte
+}
+
+func (e *KeyOutsideNamespace) Error() string {
+	return fmt.Sprintf("key 0x%s is outside the namespaces of the backend", hex.EncodeToString(e.Key))
+}
+
+// Code returns the error code
+func (e *KeyOutsideNamespace) Code() ErrorCode {
+	return ErrorCodeInvalidRequest
+}
+
+// Details returns the offending key
+func (e *KeyOutsideNamespace) Details() map[string]interface{} {
+	return map[string]interface{}{"key": hex.EncodeToString(e.Key)}
+}
+
+// NamespaceBackend restricts the keys read and written through it to a set of namespaces, so a
+// subsystem cannot overwrite the records of another. Iteration only returns keys of the namespaces.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend.
+type NamespaceBackend struct {
+	Backend BlockStoreBackend
+
+	// firstBytes are the first bytes of the keys of the namespaces, in ascending order
+	firstBytes []byte
+	owned      [256]bool
+}
+
+// NewNamespaceBackend creates a NamespaceBackend over backend allowing the keys of namespaces
+func NewNamespaceBackend(backend BlockStoreBackend, namespaces ...*Namespace) *NamespaceBackend {
+	ns := &NamespaceBackend{Backend: backend}
+	for _, namespace := range namespaces {
+		for _, b := range namespace.firstBytes {
+			ns.owned[b] = true
+		}
+	}
+	for b := 0; b < len(ns.owned); b++ {
+		if ns.owned[b] {
+			ns.firstBytes = append(ns.firstBytes, byte(b))
+		}
+	}
+
+	return ns
+}
+
+// check returns KeyOutsideNamespace if key belongs to none of the namespaces
+func (backend *NamespaceBackend) check(key []byte) error {
+	if len(key) == 0 || !backend.owned[key[0]] {
+		return &KeyOutsideNamespace{Key: key}
+	}
+
+	return nil
+}
+
+// iterate calls fn with the keys of the namespaces starting with prefix, read with iterate. Without
+// a prefix every first byte of the namespaces is iterated in turn.
+func (backend *NamespaceBackend) iterate(iterate func(prefix []byte, fn func(key []byte, value []byte) error) error, prefix []byte, fn func(key []byte, value []byte) error) error {
+	if len(prefix) > 0 {
+		if err := backend.check(prefix); err != nil {
+			return err
+		}
+		return iterate(prefix, fn)
+	}
+
+	// The wrapped backend returns nil when fn stops the iteration, the next prefixes must not follow
+	stopped := false
+	for _, b := range backend.firstBytes {
+		err := iterate([]byte{b}, func(key []byte, value []byte) error {
+			if err := fn(key, value); err != nil {
+				stopped = true
+				return err
+			}
+			return nil
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset resets the wrapped database, including the keys outside the namespaces
+func (backend *NamespaceBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put stores the value in the wrapped database if key belongs to the namespaces
+func (backend *NamespaceBackend) Put(key []byte, value []byte) error {
+	if err := backend.check(key); err != nil {
+		return err
+	}
+
+	return backend.Backend.Put(key, value)
+}
+
+// PutWithTTL stores the value in the wrapped database with a TTL if key belongs to the namespaces
+func (backend *NamespaceBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if err := backend.check(key); err != nil {
+		return err
+	}
+
+	return putWithTTL(backend.Backend, key, value, ttl)
+}
+
+// PutBatch stores the values in the wrapped database if all their keys belong to the namespaces
+func (backend *NamespaceBackend) PutBatch(pairs []*KeyValue) error {
+	for _, pair := range pairs {
+		if err := backend.check(pair.Key); err != nil {
+			return err
+		}
+	}
+
+	return backend.Backend.PutBatch(pairs)
+}
+
+// Delete removes an item from the wrapped database if key belongs to the namespaces
+func (backend *NamespaceBackend) Delete(key []byte) error {
+	if err := backend.check(key); err != nil {
+		return err
+	}
+
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the wrapped database if key belongs to the namespaces
+func (backend *NamespaceBackend) Get(key []byte) ([]byte, error) {
+	if err := backend.check(key); err != nil {
+		return nil, err
+	}
+
+	return backend.Backend.Get(key)
+}
+
+// Has reports whether the wrapped database stores key if it belongs to the namespaces
+func (backend *NamespaceBackend) Has(key []byte) (bool, error) {
+	if err := backend.check(key); err != nil {
+		return false, err
+	}
+
+	return backend.Backend.Has(key)
+}
+
+// Iterate calls fn with the keys of the namespaces starting with prefix and their values
+func (backend *NamespaceBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.iterate(backend.Backend.Iterate, prefix, fn)
+}
+
+// Snapshot takes a snapshot of the wrapped database, restricted to the namespaces
+func (backend *NamespaceBackend) Snapshot() (BackendSnapshot, error) {
+	snapshot, err := backend.Backend.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &namespaceSnapshot{BackendSnapshot: snapshot, backend: backend}, nil
+}
+
+// Flush flushes the wrapped database
+func (backend *NamespaceBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction of the wrapped database, restricted to the namespaces
+func (backend *NamespaceBackend) Begin() (BackendTxn, error) {
+	txn, err := backend.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &namespaceTxn{BackendTxn: txn, backend: backend}, nil
+}
+
+// namespaceSnapshot restricts the reads of a snapshot to the namespaces of a NamespaceBackend
+type namespaceSnapshot struct {
+	BackendSnapshot
+	backend *NamespaceBackend
+}
+
+func (snapshot *namespaceSnapshot) Get(key []byte) ([]byte, error) {
+	if err := snapshot.backend.check(key); err != nil {
+		return nil, err
+	}
+
+	return snapshot.BackendSnapshot.Get(key)
+}
+
+func (snapshot *namespaceSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return snapshot.backend.iterate(snapshot.BackendSnapshot.Iterate, prefix, fn)
+}
+
+// namespaceTxn restricts a transaction to the namespaces of a NamespaceBackend
+type namespaceTxn struct {
+	BackendTxn
+	backend *NamespaceBackend
+}
+
+func (txn *namespaceTxn) Get(key []byte) ([]byte, error) {
+	if err := txn.backend.check(key); err != nil {
+		return nil, err
+	}
+
+	return txn.BackendTxn.Get(key)
+}
+
+func (txn *namespaceTxn) Has(key []byte) (bool, error) {
+	if err := txn.backend.check(key); err != nil {
+		return false, err
+	}
+
+	return txn.BackendTxn.Has(key)
+}
+
+func (txn *namespaceTxn) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return txn.backend.iterate(txn.BackendTxn.Iterate, prefix, fn)
+}
+
+func (txn *namespaceTxn) Put(key []byte, value []byte) error {
+	if err := txn.backend.check(key); err != nil {
+		return err
+	}
+
+	return txn.BackendTxn.Put(key, value)
+}
+
+func (txn *namespaceTxn) Delete(key []byte) error {
+	if err := txn.backend.check(key); err != nil {
+		return err
+	}
+
+	return txn.BackendTxn.Delete(key)
+}
+
+// Close closes the wrapped backend, if it needs closing
+func (backend *NamespaceBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *NamespaceBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend, including the keys outside the namespaces
+func (backend *NamespaceBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend
+func (backend *NamespaceBackend) Load(r io.Reader) error {
+	return backend.Backend.Load(r)
+}
+
+// BulkLoad replaces the contents of the wrapped database with the records of source, failing at the
+// first key outside the namespaces
+func (backend *NamespaceBackend) BulkLoad(source RecordSource) error {
+	return BulkLoad(backend.Backend, mapRecordSource(source, func(key []byte, value []byte) ([]byte, error) {
+		return value, backend.check(key)
+	}))
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *NamespaceBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *NamespaceBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
+
+// Recompress recompresses the value of key in the wrapped backend if key belongs to the namespaces
+func (backend *NamespaceBackend) Recompress(key []byte) (bool, error) {
+	if err := backend.check(key); err != nil {
+		return false, err
+	}
+
+	inner, ok := backend.Backend.(recompressBackend)
+	if !ok {
+		return false, errors.New("backend does not support compression")
+	}
+
+	return inner.Recompress(key)
+}
@@ -0,0 +1,74 @@
+package bstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNamespaceBackend(t *testing.T) {
+	inner := NewMapBackend()
+	for _, key := range [][]byte{{highestBlockKey}, heightIndexKey(1), {0x12, 0x20, 0x01}, {0x00, 0x01}} {
+		if err := inner.Put(key, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend := NewNamespaceBackend(inner, NamespaceMetadata, NamespaceBlocks)
+
+	if value, err := backend.Get([]byte{highestBlockKey}); err != nil || string(value) != "v" {
+		t.Errorf("expected the metadata record, got %v, %v", value, err)
+	}
+	if err := backend.Put([]byte{0x12, 0x20, 0x02}, []byte("v")); err != nil {
+		t.Error(err)
+	}
+
+	// Keys of other namespaces are neither read nor written
+	var outside *KeyOutsideNamespace
+	if _, err := backend.Get(heightIndexKey(1)); !errors.As(err, &outside) {
+		t.Errorf("expected an error reading an index, got %v", err)
+	}
+	if err := backend.Put([]byte{0x00, 0x02}, []byte("v")); !errors.As(err, &outside) {
+		t.Errorf("expected an error writing an internal record, got %v", err)
+	}
+	if err := backend.PutBatch([]*KeyValue{{Key: []byte{0x12, 0x20, 0x03}, Value: []byte("v")}, {Key: []byte{0x0f}, Value: []byte("v")}}); err == nil {
+		t.Error("expected an error writing a batch with a key of no namespace")
+	}
+	if value, _ := inner.Get([]byte{0x12, 0x20, 0x03}); len(value) != 0 {
+		t.Errorf("expected no value of the rejected batch, got %v", value)
+	}
+
+	var keys []string
+	err := backend.Iterate(nil, func(key []byte, value []byte) error {
+		keys = append(keys, fmt.Sprintf("%x", key))
+		return nil
+	})
+	if err != nil || fmt.Sprint(keys) != "[01 122001 122002]" {
+		t.Errorf("expected the keys of the namespaces, got %v, %v", keys, err)
+	}
+
+	keys = nil
+	err = backend.Iterate(nil, func(key []byte, value []byte) error {
+		keys = append(keys, fmt.Sprintf("%x", key))
+		return ErrStopIteration
+	})
+	if err != nil || len(keys) != 1 {
+		t.Errorf("expected the iteration to stop at the first key, got %v, %v", keys, err)
+	}
+
+	txn, err := backend.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Put(heightIndexKey(2), []byte("v")); !errors.As(err, &outside) {
+		t.Errorf("expected an error writing an index in a transaction, got %v", err)
+	}
+	txn.Rollback()
+
+	if _, err = RegisterNamespace("conflicting", highestBlockKey); err == nil {
+		t.Error("expected an error registering a namespace owning the highest block key")
+	}
+	if !NamespaceIndexes.Contains(payerIndexKey([]byte("payer"))) || NamespaceIndexes.Contains([]byte{highestBlockKey}) {
+		t.Error("expected the payer index in the index namespace")
+	}
+}
@@ -0,0 +1,152 @@
+package bstore
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NullBackend discards every write and answers reads with canned values, so the throughput of the
+// message queue and request handler can be measured without storage costs. Keys without a canned
+// value read as missing.
+//
+// Blocks are not kept, so adding a block whose ancestors must be looked up, at an even height, fails
+// with a block not present error. Ingestion benchmarks should add blocks at height 1.
+type NullBackend struct {
+	lock   sync.RWMutex
+	canned map[string][]byte
+}
+
+// NewNullBackend creates a NullBackend without canned values
+func NewNullBackend() *NullBackend {
+	return &NullBackend{canned: make(map[string][]byte)}
+}
+
+// SetCanned sets the value returned when key is read, a nil value removes it
+func (backend *NullBackend) SetCanned(key []byte, value []byte) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if value == nil {
+		delete(backend.canned, string(key))
+		return
+	}
+	backend.canned[string(key)] = append([]byte{}, value...)
+}
+
+// Reset does nothing, canned values are kept
+func (backend *NullBackend) Reset() error {
+	return nil
+}
+
+// Put discards the value
+func (backend *NullBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return nil
+}
+
+// PutBatch discards the values
+func (backend *NullBackend) PutBatch(pairs []*KeyValue) error {
+	return checkPairs(pairs)
+}
+
+// Delete does nothing
+func (backend *NullBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	return nil
+}
+
+// Get returns the canned value of key, or an empty value if it has none
+func (backend *NullBackend) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	return append(make([]byte, 0), backend.canned[string(key)]...), nil
+}
+
+// Has reports whether key has a canned value
+func (backend *NullBackend) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("cannot check an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	return len(backend.canned[string(key)]) > 0, nil
+}
+
+// Iterate calls fn with the canned values of the keys starting with prefix
+func (backend *NullBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	backend.lock.RLock()
+	keys := make([]string, 0)
+	for key := range backend.canned {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	backend.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := backend.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if err = fn([]byte(key), value); err != nil {
+			return stopIteration(err)
+		}
+	}
+
+	return nil
+}
+
+// Snapshot returns a view of the canned values, which is not pinned as they are never written
+// concurrently with requests
+func (backend *NullBackend) Snapshot() (BackendSnapshot, error) {
+	return &liveSnapshot{backend: backend}, nil
+}
+
+// Flush does nothing, the null backend stores nothing
+func (backend *NullBackend) Flush() error {
+	return nil
+}
+
+// Compact does nothing, nothing is stored
+func (backend *NullBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return &CompactionResult{}, nil
+}
+
+// Backup writes a backup stream of the canned values to w
+func (backend *NullBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load reads a backup stream from r and discards its values
+func (backend *NullBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// Begin begins a transaction batching its writes, which are discarded on commit
+func (backend *NullBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Close does nothing, the null backend holds no resources
+func (backend *NullBackend) Close() {
+}
@@ -0,0 +1,52 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestNullBackend(t *testing.T) {
+	b := NewNullBackend()
+
+	if err := b.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.Get([]byte("key")); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected writes to be discarded, got %v, %v", value, err)
+	}
+
+	b.SetCanned([]byte("key"), []byte("canned"))
+	if value, _ := b.Get([]byte("key")); !bytes.Equal(value, []byte("canned")) {
+		t.Errorf("expected the canned value, got %v", value)
+	}
+	if err := b.Delete([]byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := b.Get([]byte("key")); !bytes.Equal(value, []byte("canned")) {
+		t.Error("expected the canned value to survive a delete")
+	}
+
+	b.SetCanned([]byte("key"), nil)
+	if value, _ := b.Get([]byte("key")); len(value) != 0 {
+		t.Error("expected the canned value to be removed")
+	}
+
+	if err := b.Put(nil, []byte("value")); err == nil {
+		t.Error("expected an error putting a nil key")
+	}
+	if _, err := b.Get(nil); err == nil {
+		t.Error("expected an error getting an empty key")
+	}
+
+	// Blocks which do not need their ancestors are ingested through the full request path
+	handler := RequestHandler{Backend: b}
+	bt := ToBlockTree(NewMockBlockTree([][]uint64{{0, 101, 102}}))
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[101]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[102]}); err == nil {
+		t.Error("expected an error adding a block whose parent was discarded")
+	}
+}
@@ -0,0 +1,185 @@
+package bstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3RequestTimeout = 60 * time.Second
+	s3DateFormat     = "20060102T150405Z"
+)
+
+// ErrObjectNotFound is returned by ObjectStore.Get for a missing object
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore stores named objects, such as the objects of an S3 bucket
+type ObjectStore interface {
+	Put(name string, data []byte) error
+
+	// Get returns ErrObjectNotFound if the object does not exist
+	Get(name string) ([]byte, error)
+
+	// Delete removes an object, deleting a missing object is not an error
+	Delete(name string) error
+}
+
+// S3ObjectStore stores objects in a bucket of an S3 compatible object storage service, using path
+// style URLs and AWS signature version 4
+type S3ObjectStore struct {
+	// Endpoint is the base URL of the service, such as https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	Bucket   string
+	Region   string
+
+	AccessKey string
+	SecretKey string
+
+	Client *http.Client
+}
+
+// NewS3ObjectStore creates an S3ObjectStore for the bucket at endpoint
+func NewS3ObjectStore(endpoint, bucket, region, accessKey, secretKey string) *S3ObjectStore {
+	return &S3ObjectStore{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: s3RequestTimeout},
+	}
+}
+
+// Put uploads an object
+func (s *S3ObjectStore) Put(name string, data []byte) error {
+	resp, err := s.do(http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.statusError(http.MethodPut, name, resp)
+	}
+
+	return nil
+}
+
+// Get downloads an object
+func (s *S3ObjectStore) Get(name string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.statusError(http.MethodGet, name, resp)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes an object
+func (s *S3ObjectStore) Delete(name string) error {
+	resp, err := s.do(http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.statusError(http.MethodDelete, name, resp)
+	}
+
+	return nil
+}
+
+func (s *S3ObjectStore) do(method, name string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.Endpoint+"/"+s.Bucket+"/"+name, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	signS3Request(req, hex.EncodeToString(sum[:]), s.AccessKey, s.SecretKey, s.Region, time.Now())
+
+	return s.Client.Do(req)
+}
+
+func (s *S3ObjectStore) statusError(method, name string, resp *http.Response) error {
+	// S3 explains errors in a short XML document
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s of object %s in bucket %s failed with status %s: %s", method, name, s.Bucket, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// signS3Request signs a request with AWS signature version 4. The host and all headers already set
+// on the request are signed.
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format(s3DateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
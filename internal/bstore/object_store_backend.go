@@ -0,0 +1,128 @@
+package bstore
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// ObjectStoreBackend stores each record as an object named after its hex encoded key, so an object
+// store can hold a copy of the database, such as a replication secondary
+type ObjectStoreBackend struct {
+	Store ObjectStore
+
+	// Prefix is prepended to the object names, so several block stores can share a bucket
+	Prefix string
+}
+
+// NewObjectStoreBackend creates an ObjectStoreBackend over store
+func NewObjectStoreBackend(store ObjectStore, prefix string) *ObjectStoreBackend {
+	return &ObjectStoreBackend{Store: store, Prefix: prefix}
+}
+
+func (backend *ObjectStoreBackend) objectName(key []byte) string {
+	return backend.Prefix + hex.EncodeToString(key)
+}
+
+// Reset is not supported, object stores cannot be listed so their objects cannot be removed
+func (backend *ObjectStoreBackend) Reset() error {
+	return errors.New("object store backend cannot be reset")
+}
+
+// Put uploads the value
+func (backend *ObjectStoreBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	return backend.Store.Put(backend.objectName(key), value)
+}
+
+// PutBatch uploads the values one at a time. Object stores have no transactions, a failed batch may
+// leave some of its values uploaded.
+func (backend *ObjectStoreBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		if err := backend.Store.Put(backend.objectName(pair.Key), pair.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the object of key
+func (backend *ObjectStoreBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	return backend.Store.Delete(backend.objectName(key))
+}
+
+// Get downloads the value of key
+func (backend *ObjectStoreBackend) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	value, err := backend.Store.Get(backend.objectName(key))
+	if errors.Is(err, ErrObjectNotFound) {
+		return make([]byte, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = make([]byte, 0)
+	}
+
+	return value, nil
+}
+
+// Has reports whether the object of key exists, the object store interface reads it to find out
+func (backend *ObjectStoreBackend) Has(key []byte) (bool, error) {
+	value, err := backend.Get(key)
+	return len(value) > 0, err
+}
+
+// Iterate is not supported, object stores cannot be listed
+func (backend *ObjectStoreBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return errors.New("object store backend cannot be iterated")
+}
+
+// Snapshot returns a view of the current objects, an object store cannot pin a point in time
+func (backend *ObjectStoreBackend) Snapshot() (BackendSnapshot, error) {
+	return &liveSnapshot{backend: backend}, nil
+}
+
+// Flush does nothing, an object is durable once it is stored
+func (backend *ObjectStoreBackend) Flush() error {
+	return nil
+}
+
+// Compact does nothing, deleted objects are removed from the bucket right away
+func (backend *ObjectStoreBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return &CompactionResult{}, nil
+}
+
+// Backup returns an error, the objects of a bucket cannot be listed
+func (backend *ObjectStoreBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return 0, errors.New("object store backend cannot be backed up")
+}
+
+// Load returns an error, the object store cannot be reset
+func (backend *ObjectStoreBackend) Load(r io.Reader) error {
+	return errors.New("object store backend cannot be restored")
+}
+
+// Begin begins a transaction batching its writes
+func (backend *ObjectStoreBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
@@ -0,0 +1,151 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultOrphanedBlocksLimit = 100
+	maxOrphanedBlocksLimit     = 1000
+	maxOrphanScanHeights       = 10000
+)
+
+// GetOrphanedBlocksRequest asks for blocks below the irreversible block which are not part of the
+// canonical chain, starting at StartHeight. A Limit of 0 returns up to 100 blocks.
+type GetOrphanedBlocksRequest struct {
+	StartHeight uint64 `json:"start_height,omitempty"`
+	Limit       uint32 `json:"limit,omitempty"`
+}
+
+// GetOrphanedBlocksResponse contains a page of orphaned blocks
+type GetOrphanedBlocksResponse struct {
+	Blocks []*OrphanedBlock `json:"blocks"`
+
+	// IrreversibleHeight is the height of the irreversible block the canonical chain was resolved from
+	IrreversibleHeight uint64 `json:"irreversible_height"`
+
+	// NextHeight is the StartHeight of the next page, or 0 if there are no more heights to scan
+	NextHeight uint64 `json:"next_height,omitempty"`
+}
+
+// OrphanedBlock is a stored block which is not part of the canonical chain
+type OrphanedBlock struct {
+	BlockID     HexBytes `json:"block_id"`
+	BlockHeight uint64   `json:"block_height"`
+	PreviousID  HexBytes `json:"previous_id"`
+
+	// Size is the size of the stored block record in bytes
+	Size uint64 `json:"size"`
+}
+
+// GetOrphanedBlocks returns stored blocks below the irreversible height which are not ancestors of the
+// irreversible block. Only blocks recorded in the height index are considered.
+func (handler *RequestHandler) GetOrphanedBlocks(ctx context.Context, req *GetOrphanedBlocksRequest) (*GetOrphanedBlocksResponse, error) {
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultOrphanedBlocksLimit
+	}
+	if limit > maxOrphanedBlocksLimit {
+		limit = maxOrphanedBlocksLimit
+	}
+
+	irreversible, err := handler.getIrreversibleBlock()
+	if err != nil {
+		return nil, err
+	}
+	if irreversible == nil {
+		return nil, errors.New("irreversible block is not known")
+	}
+
+	startHeight := req.StartHeight
+	if startHeight == 0 {
+		startHeight = 1
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil && startHeight < checkpoint.Height {
+		startHeight = checkpoint.Height
+	}
+
+	resp := &GetOrphanedBlocksResponse{Blocks: []*OrphanedBlock{}, IrreversibleHeight: irreversible.GetHeight()}
+
+	endHeight := irreversible.GetHeight()
+	if endHeight-startHeight > maxOrphanScanHeights {
+		endHeight = startHeight + maxOrphanScanHeights
+	}
+
+	for height := startHeight; height < endHeight; height++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ids, err := getHeightIndex(handler.Backend, height)
+		if err != nil {
+			return nil, err
+		}
+
+		// Heights with a single block have no fork data, skip resolving the canonical block
+		if len(ids) < 2 {
+			continue
+		}
+
+		canonicalID, err := getAncestorIDAtHeight(ctx, handler.Backend, irreversible.GetId(), height)
+		if err != nil {
+			return nil, err
+		}
+
+		orphans := make([]*OrphanedBlock, 0, len(ids)-1)
+		for _, id := range ids {
+			if bytes.Equal(id, canonicalID) {
+				continue
+			}
+
+			orphan, err := handler.getOrphanedBlock(id)
+			if err != nil {
+				return nil, err
+			}
+			if orphan != nil {
+				orphans = append(orphans, orphan)
+			}
+		}
+
+		// Pages always end on a height boundary, so a height is never split across pages
+		if len(resp.Blocks) > 0 && len(resp.Blocks)+len(orphans) > limit {
+			resp.NextHeight = height
+			return resp, nil
+		}
+
+		resp.Blocks = append(resp.Blocks, orphans...)
+	}
+
+	if endHeight < irreversible.GetHeight() {
+		resp.NextHeight = endHeight
+	}
+
+	return resp, nil
+}
+
+// getOrphanedBlock loads an indexed block, returning nil if it is no longer stored
+func (handler *RequestHandler) getOrphanedBlock(blockID []byte) (*OrphanedBlock, error) {
+	record, err := handler.getRecord(blockID)
+	if _, ok := err.(*BlockNotPresent); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrphanedBlock{
+		BlockID:     record.GetBlockId(),
+		BlockHeight: record.GetBlockHeight(),
+		PreviousID:  record.GetBlock().GetHeader().GetPrevious(),
+		Size:        uint64(proto.Size(record)),
+	}, nil
+}
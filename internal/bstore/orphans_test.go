@@ -0,0 +1,91 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+)
+
+// makeForkFrom creates count blocks on top of previous which differ from any other chain by their timestamps
+func makeForkFrom(previous *protocol.Block, count int, fork uint64) []*protocol.Block {
+	blocks := make([]*protocol.Block, count)
+	previousID := previous.GetId()
+	for i := 0; i < count; i++ {
+		height := previous.GetHeader().GetHeight() + uint64(i) + 1
+		header := &protocol.BlockHeader{Previous: previousID, Height: height, Timestamp: fork*1000 + height}
+		headerBytes, _ := proto.Marshal(header)
+		digest := sha256.Sum256(headerBytes)
+		id, _ := multihash.Encode(digest[:], multihash.SHA2_256)
+
+		blocks[i] = &protocol.Block{Id: id, Header: header}
+		previousID = id
+	}
+
+	return blocks
+}
+
+func TestGetOrphanedBlocks(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		main := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, 20, 0)
+		forkA := makeForkFrom(main[4], 3, 1)  // heights 6-8
+		forkB := makeForkFrom(main[9], 1, 2)  // height 11
+		forkC := makeForkFrom(main[15], 1, 3) // height 17, above the irreversible block
+
+		for _, chain := range [][]*protocol.Block{main, forkA, forkB, forkC} {
+			for _, block := range chain {
+				if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if _, err := handler.GetOrphanedBlocks(context.Background(), &GetOrphanedBlocksRequest{}); err == nil {
+			t.Error("expected error without an irreversible block")
+		}
+
+		lib := main[14]
+		if err := handler.UpdateIrreversibleBlock(&koinos.BlockTopology{Id: lib.GetId(), Height: lib.GetHeader().GetHeight()}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetOrphanedBlocks: &GetOrphanedBlocksRequest{Limit: 2}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+
+		page := resp.GetOrphanedBlocks
+		if len(page.Blocks) != 2 || page.NextHeight != 8 || page.IrreversibleHeight != 15 {
+			t.Fatalf("unexpected first page %+v", page)
+		}
+		if !bytes.Equal(page.Blocks[0].BlockID, forkA[0].GetId()) || !bytes.Equal(page.Blocks[1].BlockID, forkA[1].GetId()) {
+			t.Error("unexpected blocks on first page")
+		}
+		if page.Blocks[0].Size == 0 {
+			t.Error("expected orphaned block size")
+		}
+
+		page, err := handler.GetOrphanedBlocks(context.Background(), &GetOrphanedBlocksRequest{StartHeight: page.NextHeight, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Blocks) != 2 || page.NextHeight != 0 {
+			t.Fatalf("unexpected second page %+v", page)
+		}
+		if !bytes.Equal(page.Blocks[0].BlockID, forkA[2].GetId()) || !bytes.Equal(page.Blocks[1].BlockID, forkB[0].GetId()) {
+			t.Error("unexpected blocks on second page")
+		}
+
+		CloseBackend(b)
+	}
+}
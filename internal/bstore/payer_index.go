@@ -0,0 +1,342 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/chain"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// The payer index maps each transaction payer to the transactions it paid for, ordered by nonce. A
+// payer's transactions are kept in buckets of payerIndexBucketSize nonces, so adding a transaction
+// only rewrites the bucket of its nonce. Transactions in blocks on forks are indexed as well, and
+// blocks added before the index existed are not indexed.
+
+const (
+	payerIndexBucketSize = 256
+
+	maxPayerTransactionsRequest = 1000
+
+	// PayerIndexName is the name of the payer index in prune plans
+	PayerIndexName = "payer_nonce"
+)
+
+// PayerTransaction is a transaction in the payer index
+type PayerTransaction struct {
+	TransactionID HexBytes `json:"transaction_id"`
+	Nonce         uint64   `json:"nonce"`
+	BlockID       HexBytes `json:"block_id"`
+	BlockHeight   uint64   `json:"block_height"`
+}
+
+// GetPayerTransactionsRequest asks for the transactions paid for by Payer in blocks from StartHeight
+// to EndHeight, in nonce order. Only blocks on the chain ending at HeadBlockID, or at the highest
+// block if it is not set, are included.
+type GetPayerTransactionsRequest struct {
+	Payer       HexBytes `json:"payer"`
+	HeadBlockID HexBytes `json:"head_block_id,omitempty"`
+
+	// StartHeight and EndHeight bound the block heights, an EndHeight of 0 is the head height
+	StartHeight uint64 `json:"start_height"`
+	EndHeight   uint64 `json:"end_height"`
+
+	// StartNonce is the lowest nonce returned, to resume after the last nonce of a previous response
+	StartNonce uint64 `json:"start_nonce"`
+
+	Limit uint32 `json:"limit"`
+}
+
+// GetPayerTransactionsResponse contains up to Limit transactions in ascending nonce order
+type GetPayerTransactionsResponse struct {
+	Transactions []*PayerTransaction `json:"transactions"`
+
+	// More is set if there are transactions with higher nonces in the height range
+	More bool `json:"more"`
+}
+
+func payerIndexKey(payer []byte) []byte {
+	key := []byte{payerIndexPrefix}
+	key = protowire.AppendBytes(key, payer)
+	return key
+}
+
+func payerIndexBucketKey(payer []byte, bucket uint64) []byte {
+	key := payerIndexKey(payer)
+	suffix := make([]byte, 8)
+	binary.BigEndian.PutUint64(suffix, bucket)
+	return append(key, suffix...)
+}
+
+// transactionNonce decodes the nonce of a transaction, which is a serialized chain.ValueType
+func transactionNonce(transaction *protocol.Transaction) (uint64, error) {
+	value := &chain.ValueType{}
+	if err := proto.Unmarshal(transaction.GetHeader().GetNonce(), value); err != nil {
+		return 0, err
+	}
+
+	return value.GetUint64Value(), nil
+}
+
+func encodePayerTransactions(transactions []*PayerTransaction) []byte {
+	var value []byte
+	for _, transaction := range transactions {
+		value = protowire.AppendVarint(value, transaction.Nonce)
+		value = protowire.AppendVarint(value, transaction.BlockHeight)
+		value = protowire.AppendBytes(value, transaction.BlockID)
+		value = protowire.AppendBytes(value, transaction.TransactionID)
+	}
+
+	return value
+}
+
+func decodePayerTransactions(value []byte) ([]*PayerTransaction, error) {
+	var transactions []*PayerTransaction
+	for len(value) > 0 {
+		transaction := &PayerTransaction{}
+
+		var n int
+		if transaction.Nonce, n = protowire.ConsumeVarint(value); n < 0 {
+			return nil, errors.New("payer index record corrupted")
+		}
+		value = value[n:]
+
+		if transaction.BlockHeight, n = protowire.ConsumeVarint(value); n < 0 {
+			return nil, errors.New("payer index record corrupted")
+		}
+		value = value[n:]
+
+		if transaction.BlockID, n = protowire.ConsumeBytes(value); n < 0 {
+			return nil, errors.New("payer index record corrupted")
+		}
+		value = value[n:]
+
+		if transaction.TransactionID, n = protowire.ConsumeBytes(value); n < 0 {
+			return nil, errors.New("payer index record corrupted")
+		}
+		value = value[n:]
+
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, nil
+}
+
+// getPayerBuckets returns the number of nonce buckets of a payer
+func getPayerBuckets(backend BlockStoreBackend, payer []byte) (uint64, error) {
+	value, err := backend.Get(payerIndexKey(payer))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	buckets, n := protowire.ConsumeVarint(value)
+	if n < 0 {
+		return 0, errors.New("payer index record corrupted")
+	}
+
+	return buckets, nil
+}
+
+func getPayerBucket(backend BlockStoreBackend, payer []byte, bucket uint64) ([]*PayerTransaction, error) {
+	value, err := backend.Get(payerIndexBucketKey(payer, bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePayerTransactions(value)
+}
+
+// addToPayerIndex records the transactions of a block by payer. Indexing a block again is a no-op.
+// Transactions without a payer or with a nonce which cannot be decoded are not indexed.
+func addToPayerIndex(backend BlockStoreBackend, block *protocol.Block, height uint64) error {
+	indexed := false
+	for _, transaction := range block.GetTransactions() {
+		payer := transaction.GetHeader().GetPayer()
+		if len(payer) == 0 {
+			continue
+		}
+
+		nonce, err := transactionNonce(transaction)
+		if err != nil {
+			continue
+		}
+
+		entry := &PayerTransaction{TransactionID: transaction.GetId(), Nonce: nonce, BlockID: block.GetId(), BlockHeight: height}
+		if err = addPayerTransaction(backend, payer, entry); err != nil {
+			return err
+		}
+		indexed = true
+	}
+
+	if !indexed {
+		return nil
+	}
+
+	lowest, ok, err := payerIndexLowestHeight(backend)
+	if err != nil {
+		return err
+	}
+
+	if ok && lowest <= height {
+		return nil
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, height)
+	return backend.Put([]byte{payerIndexLowestKey}, value)
+}
+
+func addPayerTransaction(backend BlockStoreBackend, payer []byte, entry *PayerTransaction) error {
+	bucket := entry.Nonce / payerIndexBucketSize
+	transactions, err := getPayerBucket(backend, payer, bucket)
+	if err != nil {
+		return err
+	}
+
+	for _, transaction := range transactions {
+		if bytes.Equal(transaction.TransactionID, entry.TransactionID) && bytes.Equal(transaction.BlockID, entry.BlockID) {
+			return nil
+		}
+	}
+
+	transactions = append(transactions, entry)
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if transactions[i].Nonce != transactions[j].Nonce {
+			return transactions[i].Nonce < transactions[j].Nonce
+		}
+		return transactions[i].BlockHeight < transactions[j].BlockHeight
+	})
+
+	if err = backend.Put(payerIndexBucketKey(payer, bucket), encodePayerTransactions(transactions)); err != nil {
+		return err
+	}
+
+	buckets, err := getPayerBuckets(backend, payer)
+	if err != nil {
+		return err
+	}
+
+	if bucket < buckets {
+		return nil
+	}
+
+	return backend.Put(payerIndexKey(payer), protowire.AppendVarint(nil, bucket+1))
+}
+
+// payerIndexLowestHeight returns the lowest block height with an indexed transaction
+func payerIndexLowestHeight(backend BlockStoreBackend) (uint64, bool, error) {
+	value, err := backend.Get([]byte{payerIndexLowestKey})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(value) != 8 {
+		return 0, false, nil
+	}
+
+	return binary.BigEndian.Uint64(value), true, nil
+}
+
+// GetPayerTransactions returns the transactions paid for by an account in nonce order
+func (handler *RequestHandler) GetPayerTransactions(ctx context.Context, req *GetPayerTransactionsRequest) (*GetPayerTransactionsResponse, error) {
+	if len(req.Payer) == 0 {
+		return nil, &InvalidRequestError{Reason: "payer must be set"}
+	}
+
+	if req.Limit > maxPayerTransactionsRequest {
+		return nil, &LimitExceededError{Limit: maxPayerTransactionsRequest, Requested: uint64(req.Limit)}
+	}
+
+	resp := &GetPayerTransactionsResponse{Transactions: []*PayerTransaction{}}
+
+	headID := []byte(req.HeadBlockID)
+	if len(headID) == 0 {
+		head, err := handler.getTopologyAtKey(highestBlockKey)
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			return resp, nil
+		}
+		headID = head.ID
+	}
+
+	backend := NewMemoBackend(handler.Backend)
+	headHeight, err := getBlockHeight(backend, headID)
+	if err != nil {
+		return nil, err
+	}
+
+	endHeight := req.EndHeight
+	if endHeight == 0 || endHeight > headHeight {
+		endHeight = headHeight
+	}
+
+	buckets, err := getPayerBuckets(backend, req.Payer)
+	if err != nil {
+		return nil, err
+	}
+
+	for bucket := req.StartNonce / payerIndexBucketSize; bucket < buckets; bucket++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		transactions, err := getPayerBucket(backend, req.Payer, bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, transaction := range transactions {
+			if transaction.Nonce < req.StartNonce || transaction.BlockHeight < req.StartHeight || transaction.BlockHeight > endHeight {
+				continue
+			}
+
+			ancestorID, err := getAncestorIDAtHeight(ctx, backend, headID, transaction.BlockHeight)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(ancestorID, transaction.BlockID) {
+				continue
+			}
+
+			if uint32(len(resp.Transactions)) >= req.Limit {
+				resp.More = true
+				return resp, nil
+			}
+
+			resp.Transactions = append(resp.Transactions, transaction)
+		}
+	}
+
+	return resp, nil
+}
+
+// payerIndex reports the heights referenced by the payer index to CheckPruneSafety
+type payerIndex struct {
+	handler *RequestHandler
+}
+
+// PayerIndex returns the payer index as a DependentIndex
+func (handler *RequestHandler) PayerIndex() DependentIndex {
+	return &payerIndex{handler: handler}
+}
+
+// Name implements DependentIndex
+func (index *payerIndex) Name() string {
+	return PayerIndexName
+}
+
+// LowestReferencedHeight implements DependentIndex
+func (index *payerIndex) LowestReferencedHeight() (uint64, bool, error) {
+	return payerIndexLowestHeight(index.handler.Backend)
+}
@@ -0,0 +1,117 @@
+package bstore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/chain"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func makePayerTransaction(payer string, nonce uint64) *protocol.Transaction {
+	nonceBytes, _ := proto.Marshal(&chain.ValueType{Kind: &chain.ValueType_Uint64Value{Uint64Value: nonce}})
+	return &protocol.Transaction{
+		Id:     []byte(fmt.Sprintf("%s-%d", payer, nonce)),
+		Header: &protocol.TransactionHeader{Payer: []byte(payer), Nonce: nonceBytes},
+	}
+}
+
+func payerNonces(transactions []*PayerTransaction) []uint64 {
+	nonces := make([]uint64, len(transactions))
+	for i, transaction := range transactions {
+		nonces[i] = transaction.Nonce
+	}
+	return nonces
+}
+
+func TestGetPayerTransactions(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		main := makeForkFrom(&protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{}}, 5, 0)
+		main[0].Transactions = []*protocol.Transaction{makePayerTransaction("alice", 1)}
+		main[1].Transactions = []*protocol.Transaction{makePayerTransaction("alice", 3), makePayerTransaction("alice", 2)}
+		main[2].Transactions = []*protocol.Transaction{makePayerTransaction("bob", 1), makePayerTransaction("alice", 4)}
+		main[4].Transactions = []*protocol.Transaction{makePayerTransaction("alice", 300)}
+
+		fork := makeForkFrom(main[0], 1, 1)
+		fork[0].Transactions = []*protocol.Transaction{makePayerTransaction("alice", 2)}
+
+		for _, block := range append(main, fork...) {
+			if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Adding a block again does not index its transactions twice
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: main[1]}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			req    *GetPayerTransactionsRequest
+			nonces []uint64
+			more   bool
+		}{
+			{&GetPayerTransactionsRequest{Payer: []byte("alice"), Limit: 10}, []uint64{1, 2, 3, 4, 300}, false},
+			{&GetPayerTransactionsRequest{Payer: []byte("alice"), StartHeight: 2, EndHeight: 3, Limit: 10}, []uint64{2, 3, 4}, false},
+			{&GetPayerTransactionsRequest{Payer: []byte("alice"), Limit: 2}, []uint64{1, 2}, true},
+			{&GetPayerTransactionsRequest{Payer: []byte("alice"), StartNonce: 3, Limit: 2}, []uint64{3, 4}, true},
+			{&GetPayerTransactionsRequest{Payer: []byte("alice"), HeadBlockID: fork[0].GetId(), Limit: 10}, []uint64{1, 2}, false},
+			{&GetPayerTransactionsRequest{Payer: []byte("bob"), Limit: 10}, []uint64{1}, false},
+			{&GetPayerTransactionsRequest{Payer: []byte("carol"), Limit: 10}, []uint64{}, false},
+		}
+
+		for i, test := range tests {
+			resp := handler.HandleExtendedRequest(&ExtendedRequest{GetPayerTransactions: test.req})
+			if resp.Error != nil {
+				t.Fatalf("test %d: %s", i, resp.Error.Message)
+			}
+
+			nonces := payerNonces(resp.GetPayerTransactions.Transactions)
+			if len(nonces) != len(test.nonces) || resp.GetPayerTransactions.More != test.more {
+				t.Errorf("test %d: expected nonces %v (more %v), got %v (more %v)", i, test.nonces, test.more, nonces, resp.GetPayerTransactions.More)
+				continue
+			}
+			for j := range nonces {
+				if nonces[j] != test.nonces[j] {
+					t.Errorf("test %d: expected nonces %v, got %v", i, test.nonces, nonces)
+					break
+				}
+			}
+		}
+
+		// The fork transaction is reported with its own block
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetPayerTransactions: &GetPayerTransactionsRequest{Payer: []byte("alice"), HeadBlockID: fork[0].GetId(), StartNonce: 2, Limit: 1}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+		if !bytes.Equal(resp.GetPayerTransactions.Transactions[0].BlockID, fork[0].GetId()) {
+			t.Errorf("expected the fork block, got %v", resp.GetPayerTransactions.Transactions[0].BlockID)
+		}
+
+		resp = handler.HandleExtendedRequest(&ExtendedRequest{GetPayerTransactions: &GetPayerTransactionsRequest{}})
+		if resp.Error == nil || resp.Error.Code != ErrorCodeInvalidRequest {
+			t.Errorf("expected an invalid request error without a payer, got %+v", resp.Error)
+		}
+
+		resp = handler.HandleExtendedRequest(&ExtendedRequest{GetPayerTransactions: &GetPayerTransactionsRequest{Payer: []byte("alice"), Limit: maxPayerTransactionsRequest + 1}})
+		if resp.Error == nil || resp.Error.Code != ErrorCodeLimitExceeded {
+			t.Errorf("expected a limit exceeded error, got %+v", resp.Error)
+		}
+
+		height, ok, err := handler.PayerIndex().LowestReferencedHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || height != 1 {
+			t.Errorf("expected lowest referenced height 1, got %d (%v)", height, ok)
+		}
+
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,67 @@
+package bstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+const (
+	payloadDigestPrefixSize = 32
+)
+
+// PayloadLogMode determines how raw message payloads are written to the debug log
+type PayloadLogMode string
+
+// Payload log modes
+const (
+	// PayloadLogFull logs the entire payload as hex
+	PayloadLogFull PayloadLogMode = "full"
+
+	// PayloadLogDigest logs the payload size, a sha256 digest and a truncated prefix
+	PayloadLogDigest PayloadLogMode = "digest"
+)
+
+// ParsePayloadLogMode parses a payload log mode option value
+func ParsePayloadLogMode(s string) (PayloadLogMode, error) {
+	switch m := PayloadLogMode(s); m {
+	case PayloadLogFull, PayloadLogDigest:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown payload log mode '%s'", s)
+	}
+}
+
+// FormatPayload formats a raw payload for the debug log according to the mode
+func FormatPayload(mode PayloadLogMode, data []byte) string {
+	if mode == PayloadLogFull || len(data) <= payloadDigestPrefixSize {
+		return "0x" + hex.EncodeToString(data)
+	}
+
+	digest := sha256.Sum256(data)
+	return fmt.Sprintf("%d bytes, sha256 %s, 0x%s...", len(data), hex.EncodeToString(digest[:]), hex.EncodeToString(data[:payloadDigestPrefixSize]))
+}
+
+// SummarizeRequest returns a short, human readable description of a block store request
+func SummarizeRequest(req *block_store.BlockStoreRequest) string {
+	switch v := req.GetRequest().(type) {
+	case *block_store.BlockStoreRequest_AddBlock:
+		block := v.AddBlock.GetBlockToAdd()
+		return fmt.Sprintf("add_block - Height: %d, ID: 0x%s", block.GetHeader().GetHeight(), hex.EncodeToString(block.GetId()))
+	case *block_store.BlockStoreRequest_GetBlocksById:
+		return fmt.Sprintf("get_blocks_by_id - IDs: %d", len(v.GetBlocksById.GetBlockIds()))
+	case *block_store.BlockStoreRequest_GetBlocksByHeight:
+		r := v.GetBlocksByHeight
+		return fmt.Sprintf("get_blocks_by_height - Head: 0x%s, Start: %d, Count: %d", hex.EncodeToString(r.GetHeadBlockId()), r.GetAncestorStartHeight(), r.GetNumBlocks())
+	case nil:
+		return "empty request"
+	default:
+		oneof := req.ProtoReflect().Descriptor().Oneofs().ByName("request")
+		if field := req.ProtoReflect().WhichOneof(oneof); field != nil {
+			return string(field.Name())
+		}
+		return "unknown request"
+	}
+}
@@ -0,0 +1,53 @@
+package bstore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestFormatPayload(t *testing.T) {
+	if _, err := ParsePayloadLogMode("compressed"); err == nil {
+		t.Error("expected error for unknown payload log mode")
+	}
+
+	data := bytes.Repeat([]byte{0xab}, 4096)
+
+	full := FormatPayload(PayloadLogFull, data)
+	if len(full) != 2+2*len(data) {
+		t.Errorf("expected full hex payload, got %d characters", len(full))
+	}
+
+	digest := FormatPayload(PayloadLogDigest, data)
+	if !strings.HasPrefix(digest, "4096 bytes, sha256 ") || len(digest) > 200 {
+		t.Errorf("unexpected digest payload '%s'", digest)
+	}
+
+	// Short payloads are logged in full regardless of mode
+	if FormatPayload(PayloadLogDigest, []byte{0x01, 0x02}) != "0x0102" {
+		t.Error("expected short payload to be logged in full")
+	}
+}
+
+func TestSummarizeRequest(t *testing.T) {
+	req := &block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_AddBlock{
+			AddBlock: &block_store.AddBlockRequest{
+				BlockToAdd: &protocol.Block{Id: []byte{0x12, 0x34}, Header: &protocol.BlockHeader{Height: 42}},
+			},
+		},
+	}
+	if summary := SummarizeRequest(req); summary != "add_block - Height: 42, ID: 0x1234" {
+		t.Errorf("unexpected summary '%s'", summary)
+	}
+
+	req = &block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_GetHighestBlock{GetHighestBlock: &block_store.GetHighestBlockRequest{}},
+	}
+	if summary := SummarizeRequest(req); summary != "get_highest_block" {
+		t.Errorf("unexpected summary '%s'", summary)
+	}
+}
@@ -0,0 +1,439 @@
+package bstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"go.uber.org/zap"
+)
+
+const pebbleDefaultCacheSize = 64 * 1024 * 1024
+
+var errPebbleClosed = errors.New("pebble database is closed")
+
+// PebbleBackend Pebble backend implementation
+type PebbleBackend struct {
+	Dir string
+
+	// lock guards db against being closed during a request, db is nil once closed. Pebble panics on
+	// use of a closed database.
+	lock sync.RWMutex
+	db   *pebble.DB
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+}
+
+// NewPebbleBackend PebbleBackend constructor. A cacheSize of 0 uses a 64 MiB block cache.
+func NewPebbleBackend(dir string, cacheSize int64) (*PebbleBackend, error) {
+	if cacheSize <= 0 {
+		cacheSize = pebbleDefaultCacheSize
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// The database holds its own reference to the cache
+	cache := pebble.NewCache(cacheSize)
+	defer cache.Unref()
+
+	db, err := pebble.Open(dir, &pebble.Options{Cache: cache, Logger: koinosPebbleLogger{}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PebbleBackend{Dir: dir, db: db}, nil
+}
+
+// Close cleans backend resources
+func (backend *PebbleBackend) Close() {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.db != nil {
+		_ = backend.db.Close()
+		backend.db = nil
+	}
+}
+
+// Reset resets the database
+func (backend *PebbleBackend) Reset() error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return backend.recordWrite(errPebbleClosed)
+	}
+
+	// Delete everything up to just past the last key, in a single range tombstone
+	iter, err := backend.db.NewIter(nil)
+	if err != nil {
+		return err
+	}
+	var end []byte
+	if iter.Last() {
+		end = append(append([]byte{}, iter.Key()...), 0x00)
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+
+	if end == nil {
+		return nil
+	}
+
+	return backend.recordWrite(backend.db.DeleteRange([]byte{}, end, pebble.Sync))
+}
+
+// Put backend setter
+func (backend *PebbleBackend) Put(key, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return backend.recordWrite(errPebbleClosed)
+	}
+
+	return backend.recordWrite(backend.db.Set(key, value, pebble.Sync))
+}
+
+// PutBatch stores the values in a single batch
+func (backend *PebbleBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return backend.recordWrite(errPebbleClosed)
+	}
+
+	batch := backend.db.NewBatch()
+	defer func() { _ = batch.Close() }()
+
+	for _, pair := range pairs {
+		if err := batch.Set(pair.Key, pair.Value, nil); err != nil {
+			return err
+		}
+	}
+
+	return backend.recordWrite(batch.Commit(pebble.Sync))
+}
+
+// Delete an item from the database
+func (backend *PebbleBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return backend.recordWrite(errPebbleClosed)
+	}
+
+	return backend.recordWrite(backend.db.Delete(key, pebble.Sync))
+}
+
+// Get backend getter
+func (backend *PebbleBackend) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errPebbleClosed
+	}
+
+	return pebbleGet(backend.db, key)
+}
+
+// Has reports whether key is stored, without copying its value
+func (backend *PebbleBackend) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("cannot check an empty key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return false, errPebbleClosed
+	}
+
+	_, closer, err := backend.db.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, closer.Close()
+}
+
+// Iterate calls fn with copies of the keys starting with prefix and their values, read from an
+// implicit snapshot
+func (backend *PebbleBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return errPebbleClosed
+	}
+
+	return pebbleIterate(backend.db, prefix, fn)
+}
+
+// Snapshot takes a Pebble snapshot of the database
+func (backend *PebbleBackend) Snapshot() (BackendSnapshot, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errPebbleClosed
+	}
+
+	return &pebbleSnapshot{backend: backend, snapshot: backend.db.NewSnapshot()}, nil
+}
+
+// pebbleSnapshot reads from a Pebble snapshot, which cannot be used once the database is closed
+type pebbleSnapshot struct {
+	backend  *PebbleBackend
+	snapshot *pebble.Snapshot
+}
+
+func (snapshot *pebbleSnapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	snapshot.backend.lock.RLock()
+	defer snapshot.backend.lock.RUnlock()
+
+	if snapshot.backend.db == nil {
+		return nil, errPebbleClosed
+	}
+
+	return pebbleGet(snapshot.snapshot, key)
+}
+
+func (snapshot *pebbleSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	snapshot.backend.lock.RLock()
+	defer snapshot.backend.lock.RUnlock()
+
+	if snapshot.backend.db == nil {
+		return errPebbleClosed
+	}
+
+	return pebbleIterate(snapshot.snapshot, prefix, fn)
+}
+
+func (snapshot *pebbleSnapshot) Release() {
+	snapshot.backend.lock.RLock()
+	defer snapshot.backend.lock.RUnlock()
+
+	if snapshot.backend.db != nil {
+		_ = snapshot.snapshot.Close()
+	}
+}
+
+// pebbleGet reads the value of key from reader, an empty value if it is not found
+func pebbleGet(reader pebble.Reader, key []byte) ([]byte, error) {
+	// A missing key returns an empty value like the other backends
+	value, closer, err := reader.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return make([]byte, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = closer.Close() }()
+
+	// The value is only valid until the closer is closed
+	return append(make([]byte, 0, len(value)), value...), nil
+}
+
+// pebbleIterate calls fn with the keys of reader starting with prefix and their values
+func pebbleIterate(reader pebble.Reader, prefix []byte, fn func(key []byte, value []byte) error) error {
+	iter, err := reader.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return err
+	}
+
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if err = fn(append([]byte{}, iter.Key()...), append([]byte{}, iter.Value()...)); err != nil {
+			break
+		}
+	}
+	if closeErr := iter.Close(); err == nil {
+		err = closeErr
+	}
+
+	return stopIteration(err)
+}
+
+// Flush writes the memtable to sstables. Writes are already synced to the write ahead log, flushing
+// saves replaying it when the database is next opened.
+func (backend *PebbleBackend) Flush() error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return errPebbleClosed
+	}
+
+	return backend.db.Flush()
+}
+
+// Begin begins a transaction batching its writes, Pebble cannot detect conflicts
+func (backend *PebbleBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *PebbleBackend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// Compact compacts the full key range. Pebble has no value log, only the LSM sizes are reported.
+func (backend *PebbleBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errPebbleClosed
+	}
+
+	iter, err := backend.db.NewIter(nil)
+	if err != nil {
+		return nil, err
+	}
+	var start, end []byte
+	if iter.First() {
+		start = append([]byte{}, iter.Key()...)
+	}
+	if iter.Last() {
+		end = append(append([]byte{}, iter.Key()...), 0x00)
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+
+	result := &CompactionResult{LSMSizeBefore: int64(backend.db.Metrics().DiskSpaceUsage())}
+	if end != nil {
+		if err = backend.db.Compact(start, end, true); err != nil {
+			return nil, err
+		}
+	}
+	result.LSMSizeAfter = int64(backend.db.Metrics().DiskSpaceUsage())
+
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return result, nil
+}
+
+// Backup writes a backup stream of the records of a snapshot to w, incremental backups are not supported
+func (backend *PebbleBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *PebbleBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// Health reports whether the database accepts writes, when it was last written, whether compactions
+// are pending and the free space on the database volume
+func (backend *PebbleBackend) Health() (*BackendHealth, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	health := &BackendHealth{
+		Writable: backend.db != nil && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	if backend.db != nil {
+		metrics := backend.db.Metrics()
+		health.PendingCompactions = int(metrics.Compact.NumInProgress)
+		if health.PendingCompactions == 0 && metrics.Compact.EstimatedDebt > 0 {
+			health.PendingCompactions = 1
+		}
+	}
+
+	free, err := diskFree(backend.Dir)
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
+
+// Stats reports the size of the database and of its levels, Pebble does not count its keys
+func (backend *PebbleBackend) Stats() (*BackendStats, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if backend.db == nil {
+		return nil, errPebbleClosed
+	}
+
+	metrics := backend.db.Metrics()
+	stats := &BackendStats{DiskSize: int64(metrics.DiskSpaceUsage()), LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}
+	for level, levelMetrics := range metrics.Levels {
+		stats.Levels = append(stats.Levels, &LevelStats{Level: level, Tables: int(levelMetrics.NumFiles), Size: levelMetrics.Size, Score: levelMetrics.Score})
+	}
+
+	return stats, nil
+}
+
+// koinosPebbleLogger implements the pebble.Logger interface in order to pass pebble logs to the koinos
+// logger
+type koinosPebbleLogger struct {
+}
+
+// Infof implements formatted info message handling for pebble
+func (koinosPebbleLogger) Infof(msg string, args ...interface{}) {
+	zap.S().Infof(strings.TrimSpace(msg), args...)
+}
+
+// Fatalf implements formatted fatal message handling for pebble
+func (koinosPebbleLogger) Fatalf(msg string, args ...interface{}) {
+	zap.S().Fatalf(strings.TrimSpace(msg), args...)
+}
@@ -0,0 +1,80 @@
+package bstore
+
+import (
+	"os"
+	"testing"
+)
+
+const (
+	PebbleBackendType = 7
+)
+
+func init() {
+	backendTypes = append(backendTypes, PebbleBackendType)
+	taggedBackends[PebbleBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewPebbleBackend(dirname, 0)
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestPebbleBackendBasic(t *testing.T) {
+	b := NewBackend(PebbleBackendType)
+
+	backendTest(t, b)
+
+	CloseBackend(b)
+}
+
+func TestPebbleBackend(t *testing.T) {
+	backend := NewBackend(PebbleBackendType).(*PebbleBackend)
+	defer CloseBackend(backend)
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 10)
+
+	if _, err := backend.Compact(0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is reopened from the same directory
+	backend.Close()
+	reopened, err := NewPebbleBackend(backend.Dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	handler.Backend = reopened
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain after reopening, got %+v", resp)
+	}
+
+	if err = reopened.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	value, err := reopened.Get([]byte{highestBlockKey})
+	if err != nil || len(value) != 0 {
+		t.Errorf("expected an empty database after reset, got %v, %v", value, err)
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.Writable || health.LastWrite == nil || health.DiskFreeBytes == nil {
+		t.Errorf("unexpected health %+v, %v", health, err)
+	}
+
+	backend.Close()
+	if _, err = backend.Get([]byte{highestBlockKey}); err == nil {
+		t.Error("expected reads to fail once closed")
+	}
+	if backendHealth, err := backend.Health(); err != nil || backendHealth.Writable {
+		t.Errorf("expected a closed database not to be writable, got %+v, %v", backendHealth, err)
+	}
+}
@@ -0,0 +1,382 @@
+package bstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultPostgresTable is the default table records are stored in
+const DefaultPostgresTable = "block_store"
+
+const postgresPingTimeout = 2 * time.Second
+
+var postgresTableRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// PostgresBackend PostgreSQL backend implementation. Records are stored in a table with key and
+// value columns, so several block stores can share a database by using different tables.
+//
+// Replication, backups and failover are left to the PostgreSQL tooling.
+type PostgresBackend struct {
+	DB    *sql.DB
+	Table string
+
+	putQuery     string
+	getQuery     string
+	hasQuery     string
+	deleteQuery  string
+	iterateQuery string
+	rangeQuery   string
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+	closed      int32
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+}
+
+// NewPostgresBackend PostgresBackend constructor. The table is created in the database at url if
+// missing. An empty table uses DefaultPostgresTable.
+func NewPostgresBackend(url string, table string) (*PostgresBackend, error) {
+	if len(table) == 0 {
+		table = DefaultPostgresTable
+	}
+
+	if !postgresTableRegexp.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q, expected lower case letters, digits and underscores", table)
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	quoted := pq.QuoteIdentifier(table)
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS " + quoted + " (key BYTEA PRIMARY KEY, value BYTEA NOT NULL)"); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &PostgresBackend{
+		DB:           db,
+		Table:        table,
+		putQuery:     "INSERT INTO " + quoted + " (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value",
+		getQuery:     "SELECT value FROM " + quoted + " WHERE key = $1",
+		hasQuery:     "SELECT EXISTS (SELECT 1 FROM " + quoted + " WHERE key = $1)",
+		deleteQuery:  "DELETE FROM " + quoted + " WHERE key = $1",
+		iterateQuery: "SELECT key, value FROM " + quoted + " WHERE key >= $1 ORDER BY key",
+		rangeQuery:   "SELECT key, value FROM " + quoted + " WHERE key >= $1 AND key < $2 ORDER BY key",
+	}, nil
+}
+
+// Close cleans backend resources
+func (backend *PostgresBackend) Close() {
+	if !atomic.CompareAndSwapInt32(&backend.closed, 0, 1) {
+		return
+	}
+
+	_ = backend.DB.Close()
+}
+
+// Reset resets the database
+func (backend *PostgresBackend) Reset() error {
+	_, err := backend.DB.Exec("TRUNCATE " + pq.QuoteIdentifier(backend.Table))
+	return backend.recordWrite(err)
+}
+
+// Put backend setter
+func (backend *PostgresBackend) Put(key, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	_, err := backend.DB.Exec(backend.putQuery, key, value)
+	return backend.recordWrite(err)
+}
+
+// PutBatch stores the values in a single transaction
+func (backend *PostgresBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	tx, err := backend.DB.Begin()
+	if err != nil {
+		return backend.recordWrite(err)
+	}
+
+	for _, pair := range pairs {
+		if _, err = tx.Exec(backend.putQuery, pair.Key, pair.Value); err != nil {
+			_ = tx.Rollback()
+			return backend.recordWrite(err)
+		}
+	}
+
+	return backend.recordWrite(tx.Commit())
+}
+
+// Delete an item from the database
+func (backend *PostgresBackend) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("cannot remove a nil key")
+	}
+
+	_, err := backend.DB.Exec(backend.deleteQuery, key)
+	return backend.recordWrite(err)
+}
+
+// Get backend getter
+func (backend *PostgresBackend) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	return queryValue(backend.DB, backend.getQuery, key)
+}
+
+// Has reports whether key is stored, without transferring its value
+func (backend *PostgresBackend) Has(key []byte) (bool, error) {
+	if key == nil {
+		return false, errors.New("cannot check a nil key")
+	}
+
+	return queryExists(backend.DB, backend.hasQuery, key)
+}
+
+// Iterate calls fn with the keys starting with prefix and their values, in the byte order of the keys
+func (backend *PostgresBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return queryPrefix(backend.DB, backend.iterateQuery, backend.rangeQuery, prefix, fn)
+}
+
+// Snapshot takes a snapshot of the table with a read-only repeatable read transaction
+func (backend *PostgresBackend) Snapshot() (BackendSnapshot, error) {
+	tx, err := backend.DB.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot of the transaction is taken by its first query
+	var one int
+	if err = tx.QueryRow("SELECT 1").Scan(&one); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	return &sqlSnapshot{tx: tx, getQuery: backend.getQuery, iterateQuery: backend.iterateQuery, rangeQuery: backend.rangeQuery}, nil
+}
+
+// sqlQuerier is implemented by *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlSnapshot reads from a read-only transaction. It and the helpers below are shared with the SQLite
+// backend.
+type sqlSnapshot struct {
+	tx           *sql.Tx
+	getQuery     string
+	iterateQuery string
+	rangeQuery   string
+}
+
+func (snapshot *sqlSnapshot) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	return queryValue(snapshot.tx, snapshot.getQuery, key)
+}
+
+func (snapshot *sqlSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return queryPrefix(snapshot.tx, snapshot.iterateQuery, snapshot.rangeQuery, prefix, fn)
+}
+
+func (snapshot *sqlSnapshot) Release() {
+	_ = snapshot.tx.Rollback()
+}
+
+// queryValue reads the value of key with getQuery, an empty value if it is missing
+func queryValue(db sqlQuerier, getQuery string, key []byte) ([]byte, error) {
+	var value []byte
+	err := db.QueryRow(getQuery, key).Scan(&value)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Match the other backends, which return an empty value for a missing key
+	if value == nil {
+		value = make([]byte, 0)
+	}
+
+	return value, nil
+}
+
+// queryExists reports whether hasQuery finds key
+func queryExists(db sqlQuerier, hasQuery string, key []byte) (bool, error) {
+	var found bool
+	if err := db.QueryRow(hasQuery, key).Scan(&found); err != nil {
+		return false, err
+	}
+
+	return found, nil
+}
+
+// queryPrefix calls fn with the keys starting with prefix and their values, read with rangeQuery, or
+// with iterateQuery if no key follows the prefix
+func queryPrefix(db sqlQuerier, iterateQuery string, rangeQuery string, prefix []byte, fn func(key []byte, value []byte) error) error {
+	// A nil prefix would be bound as NULL, which matches no key
+	if prefix == nil {
+		prefix = make([]byte, 0)
+	}
+
+	var rows *sql.Rows
+	var err error
+	if end := prefixEnd(prefix); end != nil {
+		rows, err = db.Query(rangeQuery, prefix, end)
+	} else {
+		rows, err = db.Query(iterateQuery, prefix)
+	}
+	if err != nil {
+		return err
+	}
+
+	return iterateRows(rows, fn)
+}
+
+// iterateRows calls fn with the key and value of each row and closes the rows
+func iterateRows(rows *sql.Rows, fn func(key []byte, value []byte) error) error {
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if value == nil {
+			value = make([]byte, 0)
+		}
+		if err := fn(key, value); err != nil {
+			return stopIteration(err)
+		}
+	}
+
+	return rows.Err()
+}
+
+// Flush does nothing, PostgreSQL commits are durable once they return
+func (backend *PostgresBackend) Flush() error {
+	return nil
+}
+
+// Begin begins a transaction batching its writes. Unlike a snapshot, a serializable transaction
+// would hold a connection until the caller commits.
+func (backend *PostgresBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *PostgresBackend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// Compact vacuums the table. A plain VACUUM does not lock out readers, it makes dead rows reusable
+// rather than returning their space to the operating system. The table sizes, including indexes and
+// TOAST data, are reported as the LSM sizes.
+func (backend *PostgresBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	before, err := backend.tableSize()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = backend.DB.Exec("VACUUM ANALYZE " + pq.QuoteIdentifier(backend.Table)); err != nil {
+		return nil, err
+	}
+
+	after, err := backend.tableSize()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return &CompactionResult{LSMSizeBefore: before, LSMSizeAfter: after}, nil
+}
+
+// Backup writes a backup stream of the records of a snapshot to w, incremental backups are not supported
+func (backend *PostgresBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *PostgresBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+func (backend *PostgresBackend) tableSize() (int64, error) {
+	var size int64
+	err := backend.DB.QueryRow("SELECT pg_total_relation_size($1::regclass)", pq.QuoteIdentifier(backend.Table)).Scan(&size)
+	return size, err
+}
+
+// Stats reports the size of the table with its indexes, and the number of keys estimated by the
+// planner statistics, which is unknown until the table is first analyzed
+func (backend *PostgresBackend) Stats() (*BackendStats, error) {
+	size, err := backend.tableSize()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &BackendStats{DiskSize: size, LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}
+
+	var estimate float64
+	err = backend.DB.QueryRow("SELECT reltuples FROM pg_class WHERE oid = $1::regclass", pq.QuoteIdentifier(backend.Table)).Scan(&estimate)
+	if err != nil {
+		return nil, err
+	}
+	if estimate >= 0 {
+		keys := uint64(estimate)
+		stats.Keys = &keys
+	}
+
+	return stats, nil
+}
+
+// Health reports whether the database is reachable and accepts writes, and when it was last written.
+// The database volume is not local, so no free space is reported.
+func (backend *PostgresBackend) Health() (*BackendHealth, error) {
+	health := &BackendHealth{
+		Writable: atomic.LoadInt32(&backend.closed) == 0 && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if health.Writable {
+		ctx, cancel := context.WithTimeout(context.Background(), postgresPingTimeout)
+		defer cancel()
+		health.Writable = backend.DB.PingContext(ctx) == nil
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	return health, nil
+}
@@ -0,0 +1,79 @@
+package bstore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	PostgresBackendType = 4
+
+	// postgresEnv is the URL of a PostgreSQL database the backend tests also run against, they are
+	// skipped if it is not set
+	postgresEnv = "KOINOS_POSTGRES"
+)
+
+func init() {
+	url := os.Getenv(postgresEnv)
+	if len(url) == 0 {
+		return
+	}
+
+	backendTypes = append(backendTypes, PostgresBackendType)
+	taggedBackends[PostgresBackendType] = func() BlockStoreBackend {
+		// Each backend gets its own table, so tests do not see each other's records
+		backend, err := NewPostgresBackend(url, fmt.Sprintf("bstore_test_%d", time.Now().UnixNano()))
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestPostgresBackend(t *testing.T) {
+	if _, err := NewPostgresBackend("postgres://localhost/koinos", "Block-Store"); err == nil {
+		t.Error("expected invalid table name to be rejected")
+	}
+
+	if len(os.Getenv(postgresEnv)) == 0 {
+		t.Skipf("%s is not set", postgresEnv)
+	}
+
+	backend := NewBackend(PostgresBackendType).(*PostgresBackend)
+	defer CloseBackend(backend)
+	defer func() {
+		_, _ = backend.DB.Exec("DROP TABLE " + backend.Table)
+	}()
+
+	if value, err := backend.Get([]byte{1}); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected empty value for missing key, got %v, %v", value, err)
+	}
+
+	handler := RequestHandler{Backend: backend}
+	buildLinearChain(t, &handler, 10)
+
+	compacted, err := handler.CompactStore(&CompactStoreRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.LSMSizeAfter <= 0 {
+		t.Error("expected non-zero table size after compaction")
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Writable || health.LastWrite == nil || health.DiskFreeBytes != nil {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	if err = backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := backend.Get([]byte{highestBlockKey}); len(value) != 0 {
+		t.Error("expected reset to remove all keys")
+	}
+}
@@ -0,0 +1,409 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// pruneChunkSize is the number of heights of the canonical chain resolved at once by a prune
+const pruneChunkSize = 1000
+
+// DependentIndex is a secondary index which references blocks by height. Pruning blocks an index
+// still references would leave it pointing at deleted blocks.
+type DependentIndex interface {
+	// Name identifies the index in prune plans and errors
+	Name() string
+
+	// LowestReferencedHeight returns the lowest block height the index references, and false if the
+	// index is empty
+	LowestReferencedHeight() (uint64, bool, error)
+}
+
+// PrunePlan describes blocks to be pruned
+type PrunePlan struct {
+	// BelowHeight is the height below which canonical blocks are removed
+	BelowHeight uint64
+
+	// Indexes are the names of dependent indexes which are pruned along with the blocks
+	Indexes []string
+
+	// Force skips the safety checks
+	Force bool
+}
+
+// PruneReferencedError is returned when a dependent index references blocks a prune would remove
+type PruneReferencedError struct {
+	Index  string
+	Height uint64
+}
+
+func (e *PruneReferencedError) Error() string {
+	return fmt.Sprintf("Index '%s' references block height %d, prune it as well or use --force", e.Index, e.Height)
+}
+
+// Code returns the error code
+func (e *PruneReferencedError) Code() ErrorCode {
+	return ErrorCodePruneReferenced
+}
+
+// Details returns the referencing index and the height it references
+func (e *PruneReferencedError) Details() map[string]interface{} {
+	return map[string]interface{}{"index": e.Index, "height": e.Height}
+}
+
+// PruneReversibleError is returned when a prune would remove blocks which are not yet irreversible
+type PruneReversibleError struct {
+	IrreversibleHeight uint64
+}
+
+func (e *PruneReversibleError) Error() string {
+	return fmt.Sprintf("Cannot prune above irreversible height %d, use --force to override", e.IrreversibleHeight)
+}
+
+// Code returns the error code
+func (e *PruneReversibleError) Code() ErrorCode {
+	return ErrorCodePruneReversible
+}
+
+// Details returns the irreversible height
+func (e *PruneReversibleError) Details() map[string]interface{} {
+	return map[string]interface{}{"irreversible_height": e.IrreversibleHeight}
+}
+
+// CheckPruneSafety verifies that the plan only removes irreversible blocks and that no dependent index
+// which is not pruned along with the blocks still references them
+func (handler *RequestHandler) CheckPruneSafety(plan *PrunePlan) error {
+	if plan.Force {
+		return nil
+	}
+
+	irreversible, err := handler.getIrreversibleBlock()
+	if err != nil {
+		return err
+	}
+
+	var irreversibleHeight uint64
+	if irreversible != nil {
+		irreversibleHeight = irreversible.GetHeight()
+	}
+
+	if plan.BelowHeight > irreversibleHeight {
+		return &PruneReversibleError{IrreversibleHeight: irreversibleHeight}
+	}
+
+	pruned := make(map[string]bool)
+	for _, name := range plan.Indexes {
+		pruned[name] = true
+	}
+
+	for _, index := range handler.DependentIndexes {
+		if pruned[index.Name()] {
+			continue
+		}
+
+		height, ok, err := index.LowestReferencedHeight()
+		if err != nil {
+			return fmt.Errorf("could not check index '%s', %w", index.Name(), err)
+		}
+
+		if ok && height < plan.BelowHeight {
+			return &PruneReferencedError{Index: index.Name(), Height: height}
+		}
+	}
+
+	return nil
+}
+
+// PruneResult reports the blocks removed by a prune
+type PruneResult struct {
+	// CheckpointHeight is the height of the checkpoint the history below was removed up to, 0 if the
+	// history was kept
+	CheckpointHeight uint64 `json:"checkpoint_height"`
+
+	HistoryBlocks uint64 `json:"history_blocks"`
+	ForkBlocks    uint64 `json:"fork_blocks"`
+
+	// PayerTransactions is the number of entries removed from the payer index
+	PayerTransactions uint64 `json:"payer_transactions"`
+}
+
+// Prune removes the blocks of forks which can no longer become canonical, those which do not descend
+// from the irreversible block, and the canonical blocks below plan.BelowHeight. The canonical block at
+// plan.BelowHeight becomes the checkpoint of the store, as if it had been bootstrapped from it. Pruning
+// deletes records one by one, so it is meant to run while no other request is served; an interrupted
+// prune completes when run again.
+func (handler *RequestHandler) Prune(plan *PrunePlan) (*PruneResult, error) {
+	if err := handler.CheckPruneSafety(plan); err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{}
+	head, err := handler.getTopologyAtKey(highestBlockKey)
+	if err != nil || head == nil {
+		return result, err
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	lowest := uint64(1)
+	if checkpoint != nil {
+		lowest = checkpoint.Height
+	}
+
+	if plan.BelowHeight > lowest {
+		if plan.BelowHeight > head.Height {
+			return nil, fmt.Errorf("cannot prune below height %d, above the highest block at height %d", plan.BelowHeight, head.Height)
+		}
+
+		anchorID, err := getAncestorIDAtHeight(context.Background(), handler.Backend, head.ID, plan.BelowHeight)
+		if err != nil {
+			return nil, err
+		}
+
+		// The checkpoint is moved first, so the blocks below it are never read again
+		checkpoint, err = handler.CheckpointAt(anchorID)
+		if err != nil {
+			return nil, fmt.Errorf("could not create a checkpoint at height %d, %w", plan.BelowHeight, err)
+		}
+		checkpointBytes, err := json.Marshal(checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if err = handler.Backend.Put([]byte{checkpointKey}, checkpointBytes); err != nil {
+			return nil, err
+		}
+		result.CheckpointHeight = checkpoint.Height
+
+		if result.HistoryBlocks, err = handler.pruneHistory(anchorID, lowest, plan.BelowHeight); err != nil {
+			return result, err
+		}
+		lowest = plan.BelowHeight
+	}
+
+	irreversible, err := handler.getIrreversibleBlock()
+	if err != nil {
+		return result, err
+	}
+	if irreversible != nil && irreversible.GetHeight() >= lowest {
+		if result.ForkBlocks, err = handler.pruneForks(irreversible.GetId(), irreversible.GetHeight(), lowest, head.Height, checkpoint); err != nil {
+			return result, err
+		}
+	}
+
+	for _, name := range plan.Indexes {
+		if name == PayerIndexName {
+			if result.PayerTransactions, err = prunePayerIndex(handler.Backend, lowest); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	log.Infof("Pruned %d block(s) of history and %d block(s) of forks", result.HistoryBlocks, result.ForkBlocks)
+	return result, nil
+}
+
+// pruneHistory removes every block from height startHeight up to endHeight, the height of anchorID.
+// Blocks are found in the height index and, for those added before it existed, by walking the
+// canonical chain. Heights are removed in ascending order, so a chain walk which fails on a block
+// removed by an interrupted prune only finds blocks which were already removed.
+func (handler *RequestHandler) pruneHistory(anchorID []byte, startHeight uint64, endHeight uint64) (uint64, error) {
+	var pruned uint64
+	for chunkStart := startHeight; chunkStart < endHeight; chunkStart += pruneChunkSize {
+		chunkEnd := chunkStart + pruneChunkSize - 1
+		if chunkEnd >= endHeight {
+			chunkEnd = endHeight - 1
+		}
+
+		canonical := make(map[uint64][]byte)
+		id, err := getAncestorIDAtHeight(context.Background(), handler.Backend, anchorID, chunkEnd)
+		for height := chunkEnd; err == nil; height-- {
+			canonical[height] = id
+			if height == chunkStart {
+				break
+			}
+
+			var record *block_store.BlockRecord
+			if record, err = handler.getRecord(id); err == nil {
+				id = record.GetBlock().GetHeader().GetPrevious()
+			}
+		}
+		if _, ok := err.(*BlockNotPresent); err != nil && !ok {
+			return pruned, err
+		}
+
+		for height := chunkStart; height <= chunkEnd; height++ {
+			ids, err := getHeightIndex(handler.Backend, height)
+			if err != nil {
+				return pruned, err
+			}
+			if id, ok := canonical[height]; ok {
+				ids = append(ids, id)
+			}
+
+			for _, id := range ids {
+				removed, err := removeBlock(handler.Backend, id)
+				if err != nil {
+					return pruned, err
+				}
+				if removed {
+					pruned++
+				}
+			}
+
+			if err = putHeightIndex(handler.Backend, height, nil); err != nil {
+				return pruned, err
+			}
+		}
+	}
+
+	return pruned, nil
+}
+
+// pruneForks removes the indexed blocks from startHeight up to the irreversible height which are not
+// ancestors of the irreversible block, then those above it up to headHeight whose previous block is
+// no longer stored. Heights are pruned in ascending order, so the descendants of a removed block are
+// removed as well.
+func (handler *RequestHandler) pruneForks(irreversibleID []byte, irreversibleHeight uint64, startHeight uint64, headHeight uint64, checkpoint *Checkpoint) (uint64, error) {
+	var pruned uint64
+	for height := startHeight; height <= headHeight; height++ {
+		ids, err := getHeightIndex(handler.Backend, height)
+		if err != nil {
+			return pruned, err
+		}
+
+		// Heights with a single block have no fork data, skip resolving the canonical block
+		var canonicalID []byte
+		if height <= irreversibleHeight {
+			if len(ids) < 2 {
+				continue
+			}
+			if canonicalID, err = getAncestorIDAtHeight(context.Background(), handler.Backend, irreversibleID, height); err != nil {
+				return pruned, err
+			}
+		}
+
+		var kept [][]byte
+		for _, id := range ids {
+			live := true
+			if canonicalID != nil {
+				live = bytes.Equal(id, canonicalID)
+			} else if checkpoint == nil || !bytes.Equal(id, checkpoint.BlockID) {
+				record, err := handler.getRecord(id)
+				if _, ok := err.(*BlockNotPresent); ok {
+					live = false
+				} else if err != nil {
+					return pruned, err
+				} else if live, err = handler.Backend.Has(blockRecordKey(record.GetBlock().GetHeader().GetPrevious())); err != nil {
+					return pruned, err
+				}
+			}
+
+			if live {
+				kept = append(kept, id)
+				continue
+			}
+
+			removed, err := removeBlock(handler.Backend, id)
+			if err != nil {
+				return pruned, err
+			}
+			if removed {
+				pruned++
+			}
+		}
+
+		if len(kept) < len(ids) {
+			if err = putHeightIndex(handler.Backend, height, kept); err != nil {
+				return pruned, err
+			}
+		}
+	}
+
+	return pruned, nil
+}
+
+// removeBlock deletes the record and metadata of a block, returning true if it was stored
+func removeBlock(backend BlockStoreBackend, blockID []byte) (bool, error) {
+	present, err := backend.Has(blockRecordKey(blockID))
+	if err != nil || !present {
+		return false, err
+	}
+
+	if err = backend.Delete(blockRecordKey(blockID)); err != nil {
+		return false, err
+	}
+
+	return true, backend.Delete(blockMetadataKey(blockID))
+}
+
+// prunePayerIndex removes the payer index entries of blocks below belowHeight or which are no longer
+// stored, and records the new lowest indexed height
+func prunePayerIndex(backend BlockStoreBackend, belowHeight uint64) (uint64, error) {
+	var updates []*KeyValue
+	var pruned uint64
+	var lowest uint64
+	err := backend.Iterate([]byte{payerIndexPrefix}, func(key []byte, value []byte) error {
+		// Bucket keys are the payer key followed by the bucket number, the payer key itself holds the
+		// number of buckets
+		_, n := protowire.ConsumeBytes(key[1:])
+		if n < 0 || len(key) != 1+n+8 {
+			return nil
+		}
+
+		transactions, err := decodePayerTransactions(value)
+		if err != nil {
+			return err
+		}
+
+		var kept []*PayerTransaction
+		for _, transaction := range transactions {
+			present := transaction.BlockHeight >= belowHeight
+			if present {
+				if present, err = backend.Has(blockRecordKey(transaction.BlockID)); err != nil {
+					return err
+				}
+			}
+			if !present {
+				pruned++
+				continue
+			}
+
+			kept = append(kept, transaction)
+			if lowest == 0 || transaction.BlockHeight < lowest {
+				lowest = transaction.BlockHeight
+			}
+		}
+
+		if len(kept) < len(transactions) {
+			updates = append(updates, &KeyValue{Key: append([]byte{}, key...), Value: encodePayerTransactions(kept)})
+		}
+		return nil
+	})
+	if err != nil {
+		return pruned, err
+	}
+
+	for _, update := range updates {
+		if err = backend.Put(update.Key, update.Value); err != nil {
+			return pruned, err
+		}
+	}
+
+	if lowest == 0 {
+		return pruned, backend.Delete([]byte{payerIndexLowestKey})
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, lowest)
+	return pruned, backend.Put([]byte{payerIndexLowestKey}, value)
+}
@@ -0,0 +1,161 @@
+package bstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/proto"
+)
+
+type testDependentIndex struct {
+	name   string
+	lowest uint64
+	empty  bool
+}
+
+func (i *testDependentIndex) Name() string {
+	return i.name
+}
+
+func (i *testDependentIndex) LowestReferencedHeight() (uint64, bool, error) {
+	return i.lowest, !i.empty, nil
+}
+
+func TestCheckPruneSafety(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 20)
+
+	// Nothing is irreversible yet
+	if err := handler.CheckPruneSafety(&PrunePlan{BelowHeight: 5}); err == nil {
+		t.Error("expected error pruning reversible blocks")
+	} else if _, ok := err.(*PruneReversibleError); !ok {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	lib := bt.ByNum[115]
+	if err := handler.UpdateIrreversibleBlock(&koinos.BlockTopology{Id: lib.GetId(), Height: 15}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.DependentIndexes = []DependentIndex{
+		&testDependentIndex{name: "transactions", lowest: 8},
+		&testDependentIndex{name: "events", empty: true},
+	}
+
+	if err := handler.CheckPruneSafety(&PrunePlan{BelowHeight: 8}); err != nil {
+		t.Error(err)
+	}
+
+	err := handler.CheckPruneSafety(&PrunePlan{BelowHeight: 10})
+	if referenced, ok := err.(*PruneReferencedError); !ok || referenced.Index != "transactions" || referenced.Height != 8 {
+		t.Errorf("expected referenced index error, got %v", err)
+	}
+
+	if err = handler.CheckPruneSafety(&PrunePlan{BelowHeight: 10, Indexes: []string{"transactions"}}); err != nil {
+		t.Error(err)
+	}
+
+	if err = handler.CheckPruneSafety(&PrunePlan{BelowHeight: 16}); err == nil {
+		t.Error("expected error pruning above the irreversible block")
+	}
+
+	if err = handler.CheckPruneSafety(&PrunePlan{BelowHeight: 18, Force: true}); err != nil {
+		t.Error(err)
+	}
+}
+
+// addSHA256Block adds a block with a sha2-256 block ID, which a checkpoint can be created from
+func addSHA256Block(t *testing.T, handler *RequestHandler, previous []byte, height uint64, timestamp uint64, transactions ...*protocol.Transaction) []byte {
+	block := &protocol.Block{Header: &protocol.BlockHeader{Previous: previous, Height: height, Timestamp: timestamp}, Transactions: transactions}
+	header, _ := proto.Marshal(block.Header)
+	digest := sha256.Sum256(header)
+	block.Id, _ = multihash.EncodeName(digest[:], "sha2-256")
+
+	if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+		t.Fatal(err)
+	}
+
+	return block.Id
+}
+
+func TestPrune(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	handler.DependentIndexes = []DependentIndex{handler.PayerIndex()}
+
+	ids := [][]byte{GetEmptyBlockID()}
+	for height := uint64(1); height <= 30; height++ {
+		var transactions []*protocol.Transaction
+		switch height {
+		case 2:
+			transactions = append(transactions, makePayerTransaction("alice", 1))
+		case 7:
+			transactions = append(transactions, makePayerTransaction("alice", 2))
+		}
+		ids = append(ids, addSHA256Block(t, &handler, ids[height-1], height, height, transactions...))
+	}
+
+	// A fork below the irreversible block, and one above it which may still become canonical
+	fork := addSHA256Block(t, &handler, ids[9], 10, 1000, makePayerTransaction("alice", 3))
+	forkChild := addSHA256Block(t, &handler, fork, 11, 1000)
+	forkAbove := addSHA256Block(t, &handler, ids[27], 28, 1000)
+
+	if err := handler.UpdateIrreversibleBlock(&koinos.BlockTopology{Id: ids[25], Height: 25, Previous: ids[24]}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payer index references the history
+	plan := &PrunePlan{BelowHeight: 5}
+	if _, err := handler.Prune(plan); err == nil {
+		t.Error("expected an error pruning blocks the payer index references")
+	}
+
+	plan.Indexes = []string{PayerIndexName}
+	result, err := handler.Prune(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CheckpointHeight != 5 || result.HistoryBlocks != 4 || result.ForkBlocks != 2 || result.PayerTransactions != 2 {
+		t.Errorf("unexpected prune %+v", result)
+	}
+
+	for _, id := range [][]byte{ids[1], ids[4], fork, forkChild} {
+		if present, _ := handler.Backend.Has(blockRecordKey(id)); present {
+			t.Errorf("expected block 0x%x to be removed", id)
+		}
+	}
+	for _, id := range [][]byte{ids[5], ids[30], forkAbove} {
+		if present, _ := handler.Backend.Has(blockRecordKey(id)); !present {
+			t.Errorf("expected block 0x%x to be kept", id)
+		}
+	}
+	if indexed, _ := getHeightIndex(handler.Backend, 10); len(indexed) != 1 {
+		t.Errorf("expected the fork to be removed from the height index, got %d block(s)", len(indexed))
+	}
+	if lowest, ok, _ := payerIndexLowestHeight(handler.Backend); !ok || lowest != 7 {
+		t.Errorf("expected the payer index to start at height 7, got %d", lowest)
+	}
+
+	// The store reads like one bootstrapped from the new checkpoint
+	if _, err = handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{HeadBlockId: ids[30], AncestorStartHeight: 3, NumBlocks: 5}); err == nil {
+		t.Error("expected an error reading below the checkpoint")
+	}
+	resp, err := handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{HeadBlockId: ids[30], AncestorStartHeight: 5, NumBlocks: 26})
+	if err != nil || len(resp.GetBlockItems()) != 26 {
+		t.Errorf("expected the blocks from the checkpoint, got %v", err)
+	}
+	addSHA256Block(t, &handler, ids[30], 31, 31)
+
+	// Pruning again removes nothing
+	if result, err = handler.Prune(plan); err != nil || result.HistoryBlocks != 0 || result.ForkBlocks != 0 || result.PayerTransactions != 0 {
+		t.Errorf("expected nothing to prune, got %+v, %v", result, err)
+	}
+
+	if _, err = handler.Prune(&PrunePlan{BelowHeight: 26, Indexes: []string{PayerIndexName}}); err == nil {
+		t.Error("expected an error pruning above the irreversible block")
+	}
+}
@@ -0,0 +1,128 @@
+package bstore
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxRateLimitClients is the number of clients tracked above which idle clients are forgotten
+const maxRateLimitClients = 10000
+
+// RateLimitedError is returned for a request from a client which exceeded its request rate
+type RateLimitedError struct {
+	Client     string
+	Limit      float64
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("Client '%s' exceeded %g requests per second, retry after %s", e.Client, e.Limit, e.RetryAfter)
+}
+
+// Code returns the error code
+func (e *RateLimitedError) Code() ErrorCode {
+	return ErrorCodeRateLimited
+}
+
+// Details returns the client, its limit and the time after which a request is served again
+func (e *RateLimitedError) Details() map[string]interface{} {
+	return map[string]interface{}{"client": e.Client, "limit": e.Limit, "retry_after_ms": e.RetryAfter.Milliseconds()}
+}
+
+// rateBucket is the token bucket of a client
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter tracks the request rate of each client with a token bucket, which holds up to burst
+// requests and is refilled at rate requests per second
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+// take takes a request from the bucket of client, returning the time until one is available if
+// the bucket is empty
+func (l *rateLimiter) take(client string, rate float64, burst int, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*rateBucket)
+	}
+
+	capacity := float64(burst)
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitClients {
+			l.forgetIdle(rate, capacity, now)
+		}
+		bucket = &rateBucket{tokens: capacity, updated: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / rate * float64(time.Second)), false
+	}
+
+	bucket.tokens--
+	return 0, true
+}
+
+// forgetIdle removes the buckets which have refilled, as a new bucket is the same
+func (l *rateLimiter) forgetIdle(rate float64, capacity float64, now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rate >= capacity {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// checkRateLimit takes a request of client from its bucket, returning a RateLimitedError if it
+// exceeded the rate limit. Requests are not limited if no limit is set, nor requests of the block
+// store itself, which have no client.
+func (handler *RequestHandler) checkRateLimit(client string) error {
+	rate, burst := handler.rateLimit()
+	if rate <= 0 || len(client) == 0 {
+		return nil
+	}
+
+	retryAfter, ok := handler.rateLimits.take(client, rate, burst, time.Now())
+	if !ok {
+		return &RateLimitedError{Client: client, Limit: rate, RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// rateLimit returns the requests per second served to each client, 0 if they are not limited, and
+// the number served at once, which defaults to one second of requests
+func (handler *RequestHandler) rateLimit() (float64, int) {
+	settings := handler.settings()
+	if settings.RateLimit <= 0 {
+		return 0, 0
+	}
+
+	burst := settings.RateLimitBurst
+	if burst <= 0 {
+		burst = int(math.Ceil(settings.RateLimit))
+	}
+
+	return settings.RateLimit, burst
+}
+
+// checkExtendedRateLimit checks the rate limit of the client of an extended request. Admin requests,
+// which are authorized on their own, and health requests, which probes must not be refused, are not
+// limited.
+func (handler *RequestHandler) checkExtendedRateLimit(client string, req *ExtendedRequest) error {
+	if req.Admin != nil || req.GetHealth != nil {
+		return nil
+	}
+
+	return handler.checkRateLimit(client)
+}
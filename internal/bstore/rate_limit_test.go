@@ -0,0 +1,90 @@
+package bstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := rateLimiter{}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, ok := limiter.take("indexer", 2, 3, now); !ok {
+			t.Fatalf("expected request %d of the burst to be served", i)
+		}
+	}
+	retryAfter, ok := limiter.take("indexer", 2, 3, now)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("expected request to be refused for 500ms, got %v %v", ok, retryAfter)
+	}
+
+	// Other clients have their own bucket, which refills at the rate
+	if _, ok = limiter.take("chain", 2, 3, now); !ok {
+		t.Error("expected request of another client to be served")
+	}
+	if _, ok = limiter.take("indexer", 2, 3, now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected request to be served once the bucket refilled")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), RateLimit: 1, RateLimitBurst: 2}
+	getHead := &block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetHighestBlock{GetHighestBlock: &block_store.GetHighestBlockRequest{}}}
+
+	// Both RPCs take from the bucket of the reply-to queue
+	if resp := handler.HandleRequestFrom("amq.gen-indexer", getHead); responseErrorCode(resp) == ErrorCodeRateLimited {
+		t.Fatal("expected block store request to be served")
+	}
+	if resp := handler.HandleExtendedRequestFrom("amq.gen-indexer", &ExtendedRequest{GetHead: &GetHeadRequest{}}); resp.Error != nil && resp.Error.Code == ErrorCodeRateLimited {
+		t.Fatal("expected extended request to be served")
+	}
+	resp := handler.HandleExtendedRequestFrom("amq.gen-indexer", &ExtendedRequest{GetHead: &GetHeadRequest{}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeRateLimited || resp.Error.Details["client"] != "amq.gen-indexer" {
+		t.Errorf("expected rate_limited error, got %+v", resp.Error)
+	}
+	if code := responseErrorCode(handler.HandleRequestFrom("amq.gen-indexer", getHead)); code != ErrorCodeRateLimited {
+		t.Errorf("expected block store request to be limited, got %v", code)
+	}
+
+	// Other clients, and the block store itself, are served meanwhile. Health and admin requests are
+	// not limited.
+	if code := responseErrorCode(handler.HandleRequestFrom("amq.gen-chain", getHead)); code == ErrorCodeRateLimited {
+		t.Error("expected request of another client to be served")
+	}
+	for i := 0; i < 3; i++ {
+		if code := responseErrorCode(handler.HandleRequest(getHead)); code == ErrorCodeRateLimited {
+			t.Error("expected request of the block store to be served")
+		}
+	}
+	if resp = handler.HandleExtendedRequestFrom("amq.gen-indexer", &ExtendedRequest{GetHealth: &GetHealthRequest{}}); resp.Error != nil {
+		t.Errorf("expected health request to be served, got %+v", resp.Error)
+	}
+	if resp = handler.HandleExtendedRequestFrom("amq.gen-indexer", &ExtendedRequest{Admin: &AdminRequest{CompactStore: &CompactStoreRequest{}}}); resp.Error != nil && resp.Error.Code == ErrorCodeRateLimited {
+		t.Error("expected admin request not to be limited")
+	}
+
+	caps, err := handler.GetCapabilities(&GetCapabilitiesRequest{})
+	if err != nil || caps.RateLimit != 1 || caps.RateLimitBurst != 2 {
+		t.Errorf("unexpected capabilities %+v, %v", caps, err)
+	}
+
+	// A reloaded limit of 0 disables limiting
+	settings := handler.CurrentSettings()
+	settings.RateLimit = 0
+	handler.ApplySettings(settings)
+	if resp = handler.HandleExtendedRequestFrom("amq.gen-indexer", &ExtendedRequest{GetHead: &GetHeadRequest{}}); resp.Error != nil && resp.Error.Code == ErrorCodeRateLimited {
+		t.Error("expected requests not to be limited without a limit")
+	}
+}
+
+// responseErrorCode returns the error code of a block store response, or the empty code if it is not
+// an error
+func responseErrorCode(resp *block_store.BlockStoreResponse) ErrorCode {
+	if errval, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error); ok {
+		return ErrorCodeOfStatus(errval.Error)
+	}
+	return ""
+}
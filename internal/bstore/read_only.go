@@ -0,0 +1,33 @@
+package bstore
+
+import "fmt"
+
+// readOnlyAdminRequests are the admin requests which change the blocks of the database, they are
+// refused by a read-only block store. Compaction, backups and exports leave the blocks as they are.
+var readOnlyAdminRequests = map[string]bool{
+	"restore_store":   true,
+	"compress_blocks": true,
+	"put_record":      true,
+	"put_records":     true,
+	"delete_record":   true,
+	"promote_standby": true,
+}
+
+// ReadOnlyError is returned for requests which would write to a read-only block store
+type ReadOnlyError struct {
+	Request string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("Request '%s' is refused, the block store is read-only", e.Request)
+}
+
+// Code returns the error code
+func (e *ReadOnlyError) Code() ErrorCode {
+	return ErrorCodeReadOnly
+}
+
+// Details returns the name of the refused request
+func (e *ReadOnlyError) Details() map[string]interface{} {
+	return map[string]interface{}{"request": e.Request}
+}
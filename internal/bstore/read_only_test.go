@@ -0,0 +1,50 @@
+package bstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestReadOnly(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), AdminSecret: "secret"}
+	ids := buildSHA256Chain(t, &handler, 5)
+	handler.ReadOnly = true
+
+	var readOnlyErr *ReadOnlyError
+	_, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: &protocol.Block{Id: ids[4], Header: &protocol.BlockHeader{Height: 6, Previous: ids[4]}}})
+	if !errors.As(err, &readOnlyErr) || ErrorCodeOf(err) != ErrorCodeReadOnly || readOnlyErr.Request != "add_block" {
+		t.Errorf("expected a read-only error, got %v", err)
+	}
+
+	// Queries are served
+	highest, err := handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+	if err != nil || highest.GetTopology().GetHeight() != 5 {
+		t.Errorf("expected the highest block at height 5, got %v, %v", highest, err)
+	}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetCapabilities: &GetCapabilitiesRequest{}})
+	if resp.Error != nil || !resp.GetCapabilities.ReadOnly {
+		t.Errorf("expected read-only capabilities, got %+v", resp)
+	}
+
+	// Admin requests changing the blocks are refused, reads are not
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", PutRecord: &PutRecordRequest{Key: []byte{0x12}, Value: []byte{1}}}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeReadOnly || resp.Error.Details["request"] != "put_record" {
+		t.Errorf("expected a read-only error, got %+v", resp.Error)
+	}
+	if present, _ := handler.Backend.Has([]byte{0x12}); present {
+		t.Error("expected the record not to be written")
+	}
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", GetRecord: &GetRecordRequest{Key: []byte{highestBlockKey}}}})
+	if resp.Error != nil {
+		t.Errorf("unexpected error %s", resp.Error.Message)
+	}
+
+	// The authorization is checked first, so unauthorized callers do not learn the mode
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{DeleteRecord: &DeleteRecordRequest{Key: []byte{0x12}}}})
+	if resp.Error == nil || resp.Error.Code != ErrorCodeUnauthorized {
+		t.Errorf("expected an unauthorized error, got %+v", resp.Error)
+	}
+}
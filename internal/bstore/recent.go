@@ -0,0 +1,124 @@
+package bstore
+
+import (
+	"context"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+// GetRecentBlocksRequest asks for the last NumBlocks blocks on the chain ending at the highest block
+type GetRecentBlocksRequest struct {
+	NumBlocks     uint32 `json:"num_blocks"`
+	ReturnBlock   bool   `json:"return_block"`
+	ReturnReceipt bool   `json:"return_receipt"`
+}
+
+// BlockItem is a block returned by an extended request. Block and Receipt are the serialized
+// protocol.Block and protocol.BlockReceipt, and are only set when requested.
+type BlockItem struct {
+	BlockID     HexBytes `json:"block_id"`
+	BlockHeight uint64   `json:"block_height"`
+	Block       HexBytes `json:"block,omitempty"`
+	Receipt     HexBytes `json:"receipt,omitempty"`
+}
+
+func newBlockItem(item *block_store.BlockItem) (*BlockItem, error) {
+	block := &BlockItem{BlockID: item.GetBlockId(), BlockHeight: item.GetBlockHeight()}
+
+	var err error
+	if item.GetBlock() != nil {
+		if block.Block, err = proto.Marshal(item.GetBlock()); err != nil {
+			return nil, err
+		}
+	}
+
+	if item.GetReceipt() != nil {
+		if block.Receipt, err = proto.Marshal(item.GetReceipt()); err != nil {
+			return nil, err
+		}
+	}
+
+	return block, nil
+}
+
+// GetRecentBlocksResponse contains the highest block and the blocks leading up to it in ascending
+// height order. Head is nil if the store is empty.
+type GetRecentBlocksResponse struct {
+	Head   *Topology    `json:"head"`
+	Blocks []*BlockItem `json:"blocks"`
+
+	// Truncated is set if the oldest blocks were dropped to fit the maximum message size
+	Truncated bool `json:"truncated"`
+}
+
+// GetRecentBlocks returns the most recent blocks on the chain ending at the highest block. Fewer blocks
+// are returned if the chain is shorter or the store was bootstrapped from a checkpoint.
+func (handler *RequestHandler) GetRecentBlocks(ctx context.Context, req *GetRecentBlocksRequest) (*GetRecentBlocksResponse, error) {
+	if uint64(req.NumBlocks) > handler.maxBlocksByHeight() {
+		return nil, &LimitExceededError{Limit: handler.maxBlocksByHeight(), Requested: uint64(req.NumBlocks)}
+	}
+
+	resp := &GetRecentBlocksResponse{Blocks: []*BlockItem{}}
+
+	recordBytes, err := handler.Backend.Get([]byte{highestBlockKey})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recordBytes) == 0 {
+		return resp, nil
+	}
+
+	highest := &koinos.BlockTopology{}
+	if err = proto.Unmarshal(recordBytes, highest); err != nil {
+		return nil, &DeserializeError{}
+	}
+
+	resp.Head = &Topology{ID: highest.GetId(), Height: highest.GetHeight(), Previous: highest.GetPrevious()}
+
+	if req.NumBlocks == 0 || highest.GetHeight() == 0 {
+		return resp, nil
+	}
+
+	startHeight := uint64(1)
+	if highest.GetHeight() > uint64(req.NumBlocks) {
+		startHeight = highest.GetHeight() - uint64(req.NumBlocks) + 1
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil && startHeight < checkpoint.Height {
+		startHeight = checkpoint.Height
+	}
+
+	blocks, err := handler.GetBlocksByHeight(ctx, &block_store.GetBlocksByHeightRequest{
+		HeadBlockId:         highest.GetId(),
+		AncestorStartHeight: startHeight,
+		NumBlocks:           uint32(highest.GetHeight() - startHeight + 1),
+		ReturnBlock:         req.ReturnBlock,
+		ReturnReceipt:       req.ReturnReceipt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range blocks.GetBlockItems() {
+		if item == nil {
+			continue
+		}
+
+		block, err := newBlockItem(item)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Blocks = append(resp.Blocks, block)
+	}
+
+	return resp, nil
+}
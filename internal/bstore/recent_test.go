@@ -0,0 +1,97 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGetRecentBlocks(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		// An empty store has no recent blocks
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetRecentBlocks: &GetRecentBlocksRequest{NumBlocks: 5}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+		if resp.GetRecentBlocks.Head != nil || len(resp.GetRecentBlocks.Blocks) != 0 {
+			t.Errorf("expected no blocks, got %+v", resp.GetRecentBlocks)
+		}
+
+		bt := buildLinearChain(t, &handler, 20)
+		head := bt.ByNum[120]
+
+		resp = handler.HandleExtendedRequest(&ExtendedRequest{GetRecentBlocks: &GetRecentBlocksRequest{NumBlocks: 5, ReturnBlock: true}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+
+		recent := resp.GetRecentBlocks
+		if recent.Head == nil || !bytes.Equal(recent.Head.ID, head.GetId()) || recent.Head.Height != 20 {
+			t.Fatalf("unexpected head %+v", recent.Head)
+		}
+		if len(recent.Blocks) != 5 {
+			t.Fatalf("expected 5 blocks, got %d", len(recent.Blocks))
+		}
+		for i, block := range recent.Blocks {
+			if block.BlockHeight != uint64(16+i) || !bytes.Equal(block.BlockID, bt.ByNum[116+uint64(i)].GetId()) {
+				t.Errorf("unexpected block %d at index %d", block.BlockHeight, i)
+			}
+			if block.Receipt != nil {
+				t.Error("unexpected receipt")
+			}
+		}
+
+		last := &protocol.Block{}
+		if err := proto.Unmarshal(recent.Blocks[4].Block, last); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(last, head) {
+			t.Error("returned block does not match the head block")
+		}
+
+		// The request is truncated at genesis
+		recent, err := handler.GetRecentBlocks(context.Background(), &GetRecentBlocksRequest{NumBlocks: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recent.Blocks) != 20 || recent.Blocks[0].BlockHeight != 1 || recent.Blocks[0].Block != nil {
+			t.Errorf("expected 20 blocks from genesis without contents, got %d", len(recent.Blocks))
+		}
+
+		if _, err = handler.GetRecentBlocks(context.Background(), &GetRecentBlocksRequest{NumBlocks: DefaultMaxBlocksByHeight + 1}); ErrorCodeOf(err) != ErrorCodeLimitExceeded {
+			t.Errorf("expected limit exceeded, got %v", err)
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestGetRecentBlocksCheckpoint(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+
+	blocks := makeChainFrom(GetNonExistentBlockID(99), 1000, 3)
+	if err := handler.BootstrapFromCheckpoint(makeCheckpoint(blocks[0])); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks[1:] {
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Blocks below the checkpoint are not stored, the request is truncated at the checkpoint
+	recent, err := handler.GetRecentBlocks(context.Background(), &GetRecentBlocksRequest{NumBlocks: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent.Blocks) != 3 || recent.Blocks[0].BlockHeight != 1000 || recent.Head.Height != 1002 {
+		t.Errorf("unexpected recent blocks %+v", recent)
+	}
+}
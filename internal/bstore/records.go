@@ -0,0 +1,112 @@
+package bstore
+
+// GetRecordRequest reads the raw value of a database key. A missing key returns an empty value.
+type GetRecordRequest struct {
+	Key HexBytes `json:"key"`
+}
+
+// GetRecordResponse is the raw value of the requested key
+type GetRecordResponse struct {
+	Value HexBytes `json:"value"`
+}
+
+// PutRecordRequest writes the raw value of a database key
+type PutRecordRequest struct {
+	Key   HexBytes `json:"key"`
+	Value HexBytes `json:"value"`
+}
+
+// PutRecordResponse is the result of a PutRecordRequest
+type PutRecordResponse struct {
+}
+
+// RecordValue is a raw key and value of the database
+type RecordValue struct {
+	Key   HexBytes `json:"key"`
+	Value HexBytes `json:"value"`
+}
+
+// PutRecordsRequest writes the raw values of several database keys atomically
+type PutRecordsRequest struct {
+	Records []*RecordValue `json:"records"`
+}
+
+// PutRecordsResponse is the result of a PutRecordsRequest
+type PutRecordsResponse struct {
+}
+
+// DeleteRecordRequest removes a database key
+type DeleteRecordRequest struct {
+	Key HexBytes `json:"key"`
+}
+
+// DeleteRecordResponse is the result of a DeleteRecordRequest
+type DeleteRecordResponse struct {
+}
+
+// GetRecord reads a raw database record, bypassing the block store logic
+func (handler *RequestHandler) GetRecord(req *GetRecordRequest) (*GetRecordResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, &InvalidRequestError{Reason: "key is required"}
+	}
+
+	value, err := handler.Backend.Get(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetRecordResponse{Value: value}, nil
+}
+
+// PutRecord writes a raw database record, bypassing the block store logic
+func (handler *RequestHandler) PutRecord(req *PutRecordRequest) (*PutRecordResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, &InvalidRequestError{Reason: "key is required"}
+	}
+
+	value := req.Value
+	if value == nil {
+		value = make([]byte, 0)
+	}
+
+	if err := handler.Backend.Put(req.Key, value); err != nil {
+		return nil, err
+	}
+
+	return &PutRecordResponse{}, nil
+}
+
+// PutRecords writes raw database records in a single batch, bypassing the block store logic
+func (handler *RequestHandler) PutRecords(req *PutRecordsRequest) (*PutRecordsResponse, error) {
+	pairs := make([]*KeyValue, 0, len(req.Records))
+	for _, record := range req.Records {
+		if len(record.Key) == 0 {
+			return nil, &InvalidRequestError{Reason: "key is required"}
+		}
+
+		value := record.Value
+		if value == nil {
+			value = make([]byte, 0)
+		}
+		pairs = append(pairs, &KeyValue{Key: record.Key, Value: value})
+	}
+
+	if err := handler.Backend.PutBatch(pairs); err != nil {
+		return nil, err
+	}
+
+	return &PutRecordsResponse{}, nil
+}
+
+// DeleteRecord removes a raw database record, bypassing the block store logic
+func (handler *RequestHandler) DeleteRecord(req *DeleteRecordRequest) (*DeleteRecordResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, &InvalidRequestError{Reason: "key is required"}
+	}
+
+	if err := handler.Backend.Delete(req.Key); err != nil {
+		return nil, err
+	}
+
+	return &DeleteRecordResponse{}, nil
+}
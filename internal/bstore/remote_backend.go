@@ -0,0 +1,288 @@
+package bstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	koinosmq "github.com/koinos/koinos-mq-golang"
+)
+
+const (
+	// RemoteRPC is the RPC service the remote backend sends its requests to
+	RemoteRPC = "block_store_ext"
+
+	// DefaultRemoteTimeout bounds each request to the remote block store
+	DefaultRemoteTimeout = 30 * time.Second
+
+	remoteContentType = "application/json"
+)
+
+// RPCClient sends an RPC request and returns its response, it is implemented by the koinos-mq client
+type RPCClient interface {
+	RPC(ctx context.Context, contentType koinosmq.ContentType, rpcService string, args []byte) ([]byte, error)
+}
+
+// RemoteError is an error returned by the remote block store
+type RemoteError struct {
+	Err *ExtendedError
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote block store error, %s", e.Err.Message)
+}
+
+// RemoteBackend forwards reads and writes to the database of another block store, using the
+// get_record, put_record, put_records and delete_record admin requests. This lets a thin block store
+// delegate its storage to a central archive node.
+//
+// Health and statistics requests are forwarded to the remote block store. The remote database cannot
+// be reset or iterated.
+type RemoteBackend struct {
+	Client RPCClient
+
+	// Secret is the admin secret of the remote block store, it is not needed if the record requests
+	// are on its admin allowlist
+	Secret string
+
+	// Timeout bounds each request, 0 uses DefaultRemoteTimeout
+	Timeout time.Duration
+
+	// cancel stops the client started by DialRemoteBackend
+	cancel context.CancelFunc
+}
+
+// NewRemoteBackend creates a RemoteBackend sending requests with client
+func NewRemoteBackend(client RPCClient, secret string) *RemoteBackend {
+	return &RemoteBackend{Client: client, Secret: secret, Timeout: DefaultRemoteTimeout}
+}
+
+// DialRemoteBackend creates a RemoteBackend for the block store served on the AMQP server at amqpURL,
+// waiting until the connection is established
+func DialRemoteBackend(amqpURL string, secret string) *RemoteBackend {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	client := koinosmq.NewClient(amqpURL, koinosmq.ExponentialBackoff)
+	<-client.Start(ctx)
+
+	backend := NewRemoteBackend(client, secret)
+	backend.cancel = cancel
+	return backend
+}
+
+// Close stops the client started by DialRemoteBackend
+func (backend *RemoteBackend) Close() {
+	if backend.cancel != nil {
+		backend.cancel()
+	}
+}
+
+// Reset is not supported, the remote database must be reset on the remote block store
+func (backend *RemoteBackend) Reset() error {
+	return errors.New("remote backend cannot be reset")
+}
+
+// Put stores the value in the remote database
+func (backend *RemoteBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	_, err := backend.admin(&AdminRequest{PutRecord: &PutRecordRequest{Key: key, Value: value}})
+	return err
+}
+
+// PutBatch stores the values in the remote database in a single request, the remote block store
+// writes them atomically
+func (backend *RemoteBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	records := make([]*RecordValue, 0, len(pairs))
+	for _, pair := range pairs {
+		records = append(records, &RecordValue{Key: pair.Key, Value: pair.Value})
+	}
+
+	_, err := backend.admin(&AdminRequest{PutRecords: &PutRecordsRequest{Records: records}})
+	return err
+}
+
+// Delete removes an item from the remote database
+func (backend *RemoteBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	_, err := backend.admin(&AdminRequest{DeleteRecord: &DeleteRecordRequest{Key: key}})
+	return err
+}
+
+// Get fetches the requested value from the remote database
+func (backend *RemoteBackend) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	resp, err := backend.admin(&AdminRequest{GetRecord: &GetRecordRequest{Key: key}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetRecord == nil {
+		return nil, errors.New("remote block store returned an unexpected response")
+	}
+
+	// A missing key returns an empty value like the other backends
+	if resp.GetRecord.Value == nil {
+		return make([]byte, 0), nil
+	}
+
+	return resp.GetRecord.Value, nil
+}
+
+// Has reports whether the remote database stores key, reading its value like Get
+func (backend *RemoteBackend) Has(key []byte) (bool, error) {
+	value, err := backend.Get(key)
+	return len(value) > 0, err
+}
+
+// Health reports the health of the remote block store
+func (backend *RemoteBackend) Health() (*BackendHealth, error) {
+	resp, err := backend.do(&ExtendedRequest{GetHealth: &GetHealthRequest{}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetHealth == nil {
+		return nil, errors.New("remote block store returned an unexpected response")
+	}
+
+	return &BackendHealth{
+		Writable:           resp.GetHealth.Writable,
+		LastWrite:          resp.GetHealth.LastWrite,
+		PendingCompactions: resp.GetHealth.PendingCompactions,
+		DiskFree:           resp.GetHealth.DiskFreeBytes,
+		DiskFull:           resp.GetHealth.DiskFull,
+	}, nil
+}
+
+// Stats reports the statistics of the remote block store
+func (backend *RemoteBackend) Stats() (*BackendStats, error) {
+	resp, err := backend.do(&ExtendedRequest{GetStoreInfo: &GetStoreInfoRequest{}})
+	if remoteErr, ok := err.(*RemoteError); ok && remoteErr.Err.Message == errStatsUnsupported.Error() {
+		return nil, errStatsUnsupported
+	} else if err != nil {
+		return nil, err
+	}
+	if resp.GetStoreInfo == nil {
+		return nil, errors.New("remote block store returned an unexpected response")
+	}
+
+	return &BackendStats{
+		Keys:           resp.GetStoreInfo.Keys,
+		DiskSize:       resp.GetStoreInfo.DiskSizeBytes,
+		Levels:         resp.GetStoreInfo.Levels,
+		LastCompaction: resp.GetStoreInfo.LastCompaction,
+	}, nil
+}
+
+// Iterate is not supported, the remote database cannot be listed
+func (backend *RemoteBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return errors.New("remote backend cannot be iterated")
+}
+
+// Snapshot returns a view of the current state of the remote database, which cannot be pinned by a
+// client. Each request to the remote block store is still consistent.
+func (backend *RemoteBackend) Snapshot() (BackendSnapshot, error) {
+	return &liveSnapshot{backend: backend}, nil
+}
+
+// Flush does nothing, the remote block store makes its own writes durable
+func (backend *RemoteBackend) Flush() error {
+	return nil
+}
+
+// Compact asks the remote block store to compact its database. The request may time out before a
+// long compaction completes, which the remote block store finishes regardless.
+func (backend *RemoteBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	resp, err := backend.admin(&AdminRequest{CompactStore: &CompactStoreRequest{DiscardRatio: discardRatio}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.CompactStore == nil {
+		return nil, errors.New("remote block store returned an unexpected response")
+	}
+
+	return &CompactionResult{
+		LSMSizeBefore:          resp.CompactStore.LSMSizeBefore,
+		ValueLogSizeBefore:     resp.CompactStore.ValueLogSizeBefore,
+		LSMSizeAfter:           resp.CompactStore.LSMSizeAfter,
+		ValueLogSizeAfter:      resp.CompactStore.ValueLogSizeAfter,
+		ValueLogFilesRewritten: resp.CompactStore.ValueLogFilesRewritten,
+	}, nil
+}
+
+// Backup returns an error, back up the remote block store instead
+func (backend *RemoteBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return 0, errors.New("remote backend cannot be backed up")
+}
+
+// Load returns an error, restore the remote block store instead
+func (backend *RemoteBackend) Load(r io.Reader) error {
+	return errors.New("remote backend cannot be restored")
+}
+
+// Begin begins a transaction batching its writes, they are sent to the remote block store in a
+// single request on commit. Conflicts with other clients are not detected.
+func (backend *RemoteBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+func (backend *RemoteBackend) admin(req *AdminRequest) (*AdminResponse, error) {
+	req.Secret = backend.Secret
+
+	resp, err := backend.do(&ExtendedRequest{Admin: req})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Admin == nil {
+		return nil, errors.New("remote block store returned an unexpected response")
+	}
+
+	return resp.Admin, nil
+}
+
+func (backend *RemoteBackend) do(req *ExtendedRequest) (*ExtendedResponse, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := backend.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRemoteTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	data, err = backend.Client.RPC(ctx, remoteContentType, RemoteRPC, data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ExtendedResponse{}
+	if err = json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, &RemoteError{Err: resp.Error}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,83 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	koinosmq "github.com/koinos/koinos-mq-golang"
+)
+
+// loopbackClient serves RPC requests with a handler, in place of a remote block store
+type loopbackClient struct {
+	handler *RequestHandler
+}
+
+func (c *loopbackClient) RPC(ctx context.Context, contentType koinosmq.ContentType, rpcService string, args []byte) ([]byte, error) {
+	if rpcService != RemoteRPC {
+		return nil, errors.New("unexpected rpc service")
+	}
+
+	req, err := DecodeExtendedRequest(args, true)
+	if err != nil {
+		return json.Marshal(&ExtendedResponse{Error: NewExtendedError(err)})
+	}
+
+	return json.Marshal(c.handler.HandleExtendedRequest(req))
+}
+
+func TestRemoteBackend(t *testing.T) {
+	remote := &RequestHandler{Backend: NewMapBackend(), AdminSecret: "secret"}
+	backend := NewRemoteBackend(&loopbackClient{handler: remote}, "secret")
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 10)
+
+	// Blocks are stored in the remote database
+	local, err := remote.Backend.Get([]byte{highestBlockKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarded, err := backend.Get([]byte{highestBlockKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) == 0 || !bytes.Equal(local, forwarded) {
+		t.Errorf("expected the highest block to be stored remotely, got %x", local)
+	}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain, got %+v", resp)
+	}
+
+	value, err := backend.Get([]byte("missing"))
+	if err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected an empty value for a missing key, got %v, %v", value, err)
+	}
+
+	if err = backend.Put([]byte("key"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = backend.Delete([]byte("key")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = backend.Reset(); err == nil {
+		t.Error("expected the remote database not to be reset")
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.Writable {
+		t.Errorf("unexpected health %+v, %v", health, err)
+	}
+
+	backend.Secret = "wrong"
+	_, err = backend.Get([]byte{highestBlockKey})
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Err.Code != ErrorCodeUnauthorized {
+		t.Errorf("expected an unauthorized remote error, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package bstore
+
+import (
+	"bytes"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+// repairBatchSize is the number of derived records removed at once by a repair
+const repairBatchSize = 1000
+
+// RepairResult reports the derived data rebuilt by a repair
+type RepairResult struct {
+	Blocks uint64 `json:"blocks"`
+
+	// RelinkedBlocks is the number of blocks whose skip links were wrong, UnlinkedBlocks the number
+	// whose skip links could not be computed because an ancestor is not stored
+	RelinkedBlocks uint64 `json:"relinked_blocks"`
+	UnlinkedBlocks uint64 `json:"unlinked_blocks"`
+
+	HeadHeight uint64   `json:"head_height"`
+	HeadID     HexBytes `json:"head_id"`
+}
+
+// Repair rebuilds the data the block store derives from its block records: the height, block
+// metadata and payer indexes, the skip links of each block record, and the highest block record,
+// which is set to the highest stored block. It is meant to run while no other request is served;
+// an interrupted repair completes when run again.
+func (handler *RequestHandler) Repair() (*RepairResult, error) {
+	for _, prefix := range []byte{heightIndexPrefix, blockMetadataPrefix, payerIndexPrefix, payerIndexLowestKey} {
+		if err := deletePrefix(handler.Backend, []byte{prefix}); err != nil {
+			return nil, err
+		}
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	// The indexes are rebuilt first, the skip links are then computed in ascending height order from
+	// the height index, so the ancestors of a block are repaired before it
+	result := &RepairResult{}
+	var lowestHeight, highestHeight uint64
+	var highestID []byte
+	for _, prefix := range []byte{blockRecordPrefix, blockRecordMultihashPrefix} {
+		err = handler.Backend.Iterate([]byte{prefix}, func(key []byte, value []byte) error {
+			record := &block_store.BlockRecord{}
+			if err := proto.Unmarshal(value, record); err != nil {
+				return err
+			}
+
+			block := record.GetBlock()
+			height := record.GetBlockHeight()
+			if err := addToHeightIndex(handler.Backend, height, record.GetBlockId()); err != nil {
+				return err
+			}
+			if err := putBlockMetadata(handler.Backend, newBlockMetadata(block, height)); err != nil {
+				return err
+			}
+			if err := addToPayerIndex(handler.Backend, block, height); err != nil {
+				return err
+			}
+
+			if result.Blocks == 0 || height < lowestHeight {
+				lowestHeight = height
+			}
+			if height > highestHeight {
+				highestHeight = height
+				highestID = record.GetBlockId()
+			}
+			result.Blocks++
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	if result.Blocks == 0 {
+		return result, nil
+	}
+
+	for height := lowestHeight; height <= highestHeight; height++ {
+		ids, err := getHeightIndex(handler.Backend, height)
+		if err != nil {
+			return result, err
+		}
+
+		for _, id := range ids {
+			relinked, err := handler.repairSkipLinks(id, checkpoint)
+			if _, ok := err.(*BlockNotPresent); ok {
+				log.Warnf("Leaving the skip links of block 0x%x at height %d, an ancestor is not stored", id, height)
+				result.UnlinkedBlocks++
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+			if relinked {
+				result.RelinkedBlocks++
+			}
+		}
+	}
+
+	// A head at the highest height is kept, so a repair does not switch between forks
+	head, err := handler.getTopologyAtKey(highestBlockKey)
+	if err != nil {
+		return result, err
+	}
+	if head != nil && head.Height == highestHeight {
+		if present, err := handler.Backend.Has(blockRecordKey(head.ID)); err != nil {
+			return result, err
+		} else if present {
+			highestID = head.ID
+		}
+	}
+
+	record, err := handler.getRecord(highestID)
+	if err != nil {
+		return result, err
+	}
+	topology, err := proto.Marshal(&koinos.BlockTopology{Id: highestID, Height: highestHeight, Previous: record.GetBlock().GetHeader().GetPrevious()})
+	if err != nil {
+		return result, err
+	}
+	if err = handler.Backend.Put([]byte{highestBlockKey}, topology); err != nil {
+		return result, err
+	}
+	result.HeadHeight = highestHeight
+	result.HeadID = highestID
+
+	log.Infof("Repaired %d block(s), relinked %d", result.Blocks, result.RelinkedBlocks)
+	return result, nil
+}
+
+// repairSkipLinks recomputes the skip links of a block record from its ancestors, rewriting the
+// record if they differ, and returns true if they did
+func (handler *RequestHandler) repairSkipLinks(blockID []byte, checkpoint *Checkpoint) (bool, error) {
+	record, err := handler.getRecord(blockID)
+	if err != nil {
+		return false, err
+	}
+
+	var links [][]byte
+	if checkpoint != nil && bytes.Equal(blockID, checkpoint.BlockID) {
+		links = checkpointPreviousBlockIds(record.GetBlockHeight(), record.GetBlock().GetHeader().GetPrevious())
+	} else if links, err = previousBlockIds(handler.Backend, record.GetBlock(), checkpoint); err != nil {
+		return false, err
+	}
+
+	if len(links) == len(record.GetPreviousBlockIds()) {
+		same := true
+		for i, link := range links {
+			same = same && bytes.Equal(link, record.GetPreviousBlockIds()[i])
+		}
+		if same {
+			return false, nil
+		}
+	}
+
+	record.PreviousBlockIds = links
+	value, err := proto.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	return true, handler.Backend.Put(blockRecordKey(blockID), value)
+}
+
+// deletePrefix removes the records whose keys start with prefix, a batch at a time
+func deletePrefix(backend BlockStoreBackend, prefix []byte) error {
+	for {
+		var keys [][]byte
+		err := backend.Iterate(prefix, func(key []byte, value []byte) error {
+			keys = append(keys, append([]byte{}, key...))
+			if len(keys) == repairBatchSize {
+				return ErrStopIteration
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err = backend.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < repairBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRepair(t *testing.T) {
+	b := NewMapBackend()
+	handler := RequestHandler{Backend: b}
+	bt := buildLinearChain(t, &handler, 40)
+	head := bt.ByNum[140]
+
+	// A fork whose first block was lost cannot be linked beyond the link to its previous block
+	fork := makeForkFrom(bt.ByNum[120], 3, 1)
+	for _, block := range fork {
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Delete(blockRecordKey(fork[0].GetId())); err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the skip links of a block, and lose indexes and the highest block
+	record, err := handler.getRecord(bt.ByNum[132].GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.PreviousBlockIds = record.PreviousBlockIds[:1]
+	value, _ := proto.Marshal(record)
+	if err = b.Put(blockRecordKey(record.GetBlockId()), value); err != nil {
+		t.Fatal(err)
+	}
+	for height := uint64(10); height < 20; height++ {
+		if err = b.Delete(heightIndexKey(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Delete(blockMetadataKey(bt.ByNum[105].GetId())); err != nil {
+		t.Fatal(err)
+	}
+	if err = handler.UpdateHighestBlock(&koinos.BlockTopology{Id: []byte("missing"), Height: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := handler.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 42 || result.RelinkedBlocks != 1 || result.UnlinkedBlocks != 1 || result.HeadHeight != 40 || !bytes.Equal(result.HeadID, head.GetId()) {
+		t.Errorf("unexpected repair %+v", result)
+	}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: head.GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid || resp.VerifyChainLinks.BlocksChecked != 40 {
+		t.Errorf("expected a valid chain after the repair, got %+v", resp)
+	}
+	if ids, _ := getHeightIndex(b, 15); len(ids) != 1 || !bytes.Equal(ids[0], bt.ByNum[115].GetId()) {
+		t.Errorf("expected the height index to be rebuilt, got %x", ids)
+	}
+	if metadata, err := handler.getBlockMetadata(bt.ByNum[105].GetId()); err != nil || metadata.BlockHeight != 5 {
+		t.Errorf("expected the block metadata to be rebuilt, got %+v, %v", metadata, err)
+	}
+	if ids, _ := getHeightIndex(b, 21); len(ids) != 1 {
+		t.Errorf("expected the lost fork block to be removed from the height index, got %d block(s)", len(ids))
+	}
+
+	// A repaired store needs no repair
+	if result, err = handler.Repair(); err != nil || result.RelinkedBlocks != 0 {
+		t.Errorf("expected nothing to relink, got %+v, %v", result, err)
+	}
+}
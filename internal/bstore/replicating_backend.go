@@ -0,0 +1,438 @@
+package bstore
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+const (
+	// DefaultReconcileInterval is the default interval at which failed secondary writes are retried
+	DefaultReconcileInterval = time.Minute
+
+	// replicationQueueSize bounds the writes queued for each secondary, writes which do not fit are
+	// left to reconciliation
+	replicationQueueSize = 10000
+)
+
+// replicationOp is a write queued for a secondary, a nil value is a delete
+type replicationOp struct {
+	key   []byte
+	value []byte
+}
+
+// replica is a secondary backend and the state of its replication
+type replica struct {
+	backend BlockStoreBackend
+	queue   chan *replicationOp
+	flush   chan chan struct{}
+
+	lock       sync.Mutex
+	failed     map[string]struct{}
+	replicated uint64
+	lastError  string
+}
+
+// ReplicatingBackend writes to a primary backend synchronously and replicates every write to one or
+// more secondary backends asynchronously, keeping warm copies of the database on other disks or in
+// object storage. Reads are only served by the primary.
+//
+// Writes to each secondary are applied in order by a dedicated goroutine. Keys whose write failed, or
+// did not fit in the queue, are reconciled every reconcile interval by copying their current value
+// from the primary. Keys waiting for reconciliation are kept in memory, they are lost if the block
+// store stops before they are reconciled.
+//
+// Compaction, backup, restore and health requests are served by the primary. A restore is not
+// replicated.
+type ReplicatingBackend struct {
+	Primary BlockStoreBackend
+
+	// lock orders the primary writes with their queueing, so secondaries apply them in the same order
+	lock     sync.Mutex
+	closed   bool
+	replicas []*replica
+	wg       sync.WaitGroup
+}
+
+// ReplicaStatus is the replication state of a secondary
+type ReplicaStatus struct {
+	// Replicated counts the writes applied to the secondary
+	Replicated uint64 `json:"replicated"`
+
+	// Queued is the number of writes waiting to be applied
+	Queued int `json:"queued"`
+
+	// Failed is the number of keys waiting for reconciliation
+	Failed int `json:"failed"`
+
+	// LastError is the last secondary write error, empty if none
+	LastError string `json:"last_error,omitempty"`
+}
+
+// NewReplicatingBackend creates a ReplicatingBackend replicating primary to secondaries and starts
+// the replication goroutines, which run until it is closed. A reconcileInterval of 0 uses
+// DefaultReconcileInterval.
+func NewReplicatingBackend(primary BlockStoreBackend, secondaries []BlockStoreBackend, reconcileInterval time.Duration) *ReplicatingBackend {
+	if reconcileInterval <= 0 {
+		reconcileInterval = DefaultReconcileInterval
+	}
+
+	backend := &ReplicatingBackend{Primary: primary}
+	for _, secondary := range secondaries {
+		r := &replica{
+			backend: secondary,
+			queue:   make(chan *replicationOp, replicationQueueSize),
+			flush:   make(chan chan struct{}),
+			failed:  make(map[string]struct{}),
+		}
+		backend.replicas = append(backend.replicas, r)
+
+		backend.wg.Add(1)
+		go backend.replicate(r, reconcileInterval)
+	}
+
+	return backend
+}
+
+// replicate applies the queued writes to a secondary and periodically reconciles its failed keys,
+// until the queue is closed
+func (backend *ReplicatingBackend) replicate(r *replica, reconcileInterval time.Duration) {
+	defer backend.wg.Done()
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case op, ok := <-r.queue:
+			if !ok {
+				backend.reconcile(r)
+				return
+			}
+			r.apply(op)
+		case done := <-r.flush:
+			for drained := false; !drained; {
+				select {
+				case op := <-r.queue:
+					r.apply(op)
+				default:
+					drained = true
+				}
+			}
+			backend.reconcile(r)
+			close(done)
+		case <-ticker.C:
+			backend.reconcile(r)
+		}
+	}
+}
+
+func (r *replica) apply(op *replicationOp) {
+	var err error
+	if op.value == nil {
+		err = r.backend.Delete(op.key)
+	} else {
+		err = r.backend.Put(op.key, op.value)
+	}
+
+	if err != nil {
+		r.fail(op.key, err)
+		return
+	}
+
+	r.lock.Lock()
+	r.replicated++
+	r.lock.Unlock()
+}
+
+func (r *replica) fail(key []byte, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.failed) == 0 {
+		log.Warnf("Unable to replicate a write, it will be retried: %s", err)
+	}
+
+	r.failed[string(key)] = struct{}{}
+	r.lastError = err.Error()
+}
+
+// reconcile copies the current primary value of every failed key to the secondary
+func (backend *ReplicatingBackend) reconcile(r *replica) {
+	r.lock.Lock()
+	failed := r.failed
+	r.failed = make(map[string]struct{})
+	r.lock.Unlock()
+
+	if len(failed) == 0 {
+		return
+	}
+
+	for key := range failed {
+		value, err := backend.Primary.Get([]byte(key))
+		if err != nil {
+			r.fail([]byte(key), err)
+			continue
+		}
+
+		// The backends read a missing key as an empty value
+		if len(value) == 0 {
+			value = nil
+		}
+		r.apply(&replicationOp{key: []byte(key), value: value})
+	}
+
+	r.lock.Lock()
+	remaining := len(r.failed)
+	r.lock.Unlock()
+
+	if remaining > 0 {
+		log.Warnf("Unable to reconcile %d of %d replicated key(s)", remaining, len(failed))
+	} else {
+		log.Infof("Reconciled %d replicated key(s)", len(failed))
+	}
+}
+
+// enqueue queues a write for every secondary, the caller must hold the lock
+func (backend *ReplicatingBackend) enqueue(key []byte, value []byte) {
+	op := &replicationOp{key: append([]byte{}, key...)}
+	if value != nil {
+		op.value = append([]byte{}, value...)
+	}
+
+	for _, r := range backend.replicas {
+		select {
+		case r.queue <- op:
+		default:
+			r.fail(op.key, errors.New("replication queue is full"))
+		}
+	}
+}
+
+// Flush waits until the writes queued so far have been applied to every secondary and the failed keys
+// have been reconciled once, then flushes the primary and the secondaries. Like a failed write, a
+// secondary which cannot be flushed is only logged.
+func (backend *ReplicatingBackend) Flush() error {
+	backend.drain()
+
+	if err := backend.Primary.Flush(); err != nil {
+		return err
+	}
+
+	for _, r := range backend.replicas {
+		if err := r.backend.Flush(); err != nil {
+			log.Warnf("Unable to flush a replication secondary: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Begin begins a transaction batching its writes, which are stored in the primary and queued for
+// the secondaries on commit
+func (backend *ReplicatingBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// drain waits until the writes queued so far have been applied to every secondary and the failed keys
+// have been reconciled once
+func (backend *ReplicatingBackend) drain() {
+	backend.lock.Lock()
+	if backend.closed {
+		backend.lock.Unlock()
+		return
+	}
+
+	waits := make([]chan struct{}, 0, len(backend.replicas))
+	for _, r := range backend.replicas {
+		done := make(chan struct{})
+		r.flush <- done
+		waits = append(waits, done)
+	}
+	backend.lock.Unlock()
+
+	for _, done := range waits {
+		<-done
+	}
+}
+
+// Reset resets the primary and, once the queued writes are applied, the secondaries. A secondary which
+// cannot be reset, such as an object store, keeps its records until they are overwritten.
+func (backend *ReplicatingBackend) Reset() error {
+	if err := backend.Primary.Reset(); err != nil {
+		return err
+	}
+
+	backend.drain()
+
+	for _, r := range backend.replicas {
+		if err := r.backend.Reset(); err != nil {
+			log.Warnf("Unable to reset a replication secondary, its records are kept: %s", err)
+		}
+
+		r.lock.Lock()
+		r.failed = make(map[string]struct{})
+		r.lock.Unlock()
+	}
+
+	return nil
+}
+
+// Put stores the value in the primary and queues it for the secondaries
+func (backend *ReplicatingBackend) Put(key []byte, value []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.closed {
+		return errors.New("replicating backend is closed")
+	}
+
+	if err := backend.Primary.Put(key, value); err != nil {
+		return err
+	}
+
+	backend.enqueue(key, value)
+	return nil
+}
+
+// PutBatch stores the values in the primary atomically and queues them for the secondaries, which
+// apply them one at a time
+func (backend *ReplicatingBackend) PutBatch(pairs []*KeyValue) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.closed {
+		return errors.New("replicating backend is closed")
+	}
+
+	if err := backend.Primary.PutBatch(pairs); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		backend.enqueue(pair.Key, pair.Value)
+	}
+	return nil
+}
+
+// Delete removes an item from the primary and queues the removal for the secondaries
+func (backend *ReplicatingBackend) Delete(key []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.closed {
+		return errors.New("replicating backend is closed")
+	}
+
+	if err := backend.Primary.Delete(key); err != nil {
+		return err
+	}
+
+	backend.enqueue(key, nil)
+	return nil
+}
+
+// Get fetches the requested value from the primary
+func (backend *ReplicatingBackend) Get(key []byte) ([]byte, error) {
+	return backend.Primary.Get(key)
+}
+
+// Has reports whether the primary stores key
+func (backend *ReplicatingBackend) Has(key []byte) (bool, error) {
+	return backend.Primary.Has(key)
+}
+
+// Iterate iterates over the primary
+func (backend *ReplicatingBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Primary.Iterate(prefix, fn)
+}
+
+// Snapshot takes a snapshot of the primary database
+func (backend *ReplicatingBackend) Snapshot() (BackendSnapshot, error) {
+	return backend.Primary.Snapshot()
+}
+
+// Replicas returns the replication state of each secondary, in the order they were given
+func (backend *ReplicatingBackend) Replicas() []*ReplicaStatus {
+	statuses := make([]*ReplicaStatus, 0, len(backend.replicas))
+	for _, r := range backend.replicas {
+		r.lock.Lock()
+		statuses = append(statuses, &ReplicaStatus{
+			Replicated: r.replicated,
+			Queued:     len(r.queue),
+			Failed:     len(r.failed),
+			LastError:  r.lastError,
+		})
+		r.lock.Unlock()
+	}
+
+	return statuses
+}
+
+// Close applies the queued writes, reconciles the failed keys a last time and closes the primary and
+// the secondaries, if they need closing
+func (backend *ReplicatingBackend) Close() {
+	backend.lock.Lock()
+	if !backend.closed {
+		backend.closed = true
+		for _, r := range backend.replicas {
+			close(r.queue)
+		}
+	}
+	backend.lock.Unlock()
+
+	backend.wg.Wait()
+
+	if closer, ok := backend.Primary.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	for _, r := range backend.replicas {
+		if closer, ok := r.backend.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Compact compacts the primary
+func (backend *ReplicatingBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Primary.Compact(discardRatio)
+}
+
+// Backup backs up the primary
+func (backend *ReplicatingBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Primary.Backup(w, sinceVersion)
+}
+
+// Load restores the primary, the secondaries are not restored
+func (backend *ReplicatingBackend) Load(r io.Reader) error {
+	return backend.Primary.Load(r)
+}
+
+// Health reports the health of the primary. A backend which does not report its health is assumed to
+// be writable.
+func (backend *ReplicatingBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Primary.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the primary database
+func (backend *ReplicatingBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Primary)
+}
+
+// StatusName implements StatusReporter
+func (backend *ReplicatingBackend) StatusName() string {
+	return "replication"
+}
+
+// Status implements StatusReporter
+func (backend *ReplicatingBackend) Status() interface{} {
+	return backend.Replicas()
+}
@@ -0,0 +1,129 @@
+package bstore
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails every write while failing is set
+type flakyBackend struct {
+	BlockStoreBackend
+	failing int32
+}
+
+func (backend *flakyBackend) Put(key []byte, value []byte) error {
+	if atomic.LoadInt32(&backend.failing) != 0 {
+		return errors.New("secondary unavailable")
+	}
+	return backend.BlockStoreBackend.Put(key, value)
+}
+
+func (backend *flakyBackend) Delete(key []byte) error {
+	if atomic.LoadInt32(&backend.failing) != 0 {
+		return errors.New("secondary unavailable")
+	}
+	return backend.BlockStoreBackend.Delete(key)
+}
+
+func TestReplicatingBackendBasic(t *testing.T) {
+	b := NewReplicatingBackend(NewMapBackend(), []BlockStoreBackend{NewMapBackend()}, 0)
+	backendTest(t, b)
+	b.Close()
+}
+
+func TestReplicatingBackend(t *testing.T) {
+	objects := newMapObjectStore()
+	flaky := &flakyBackend{BlockStoreBackend: NewMapBackend()}
+	b := NewReplicatingBackend(NewMapBackend(), []BlockStoreBackend{flaky, NewObjectStoreBackend(objects, "replica/")}, time.Hour)
+	defer b.Close()
+
+	if err := b.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _ := flaky.Get([]byte("a")); !bytes.Equal(value, []byte("1")) {
+		t.Errorf("expected the write to be replicated, got %v", value)
+	}
+	if value, _ := objects.Get("replica/61"); !bytes.Equal(value, []byte("1")) {
+		t.Errorf("expected the write to be replicated to the object store, got %v", value)
+	}
+
+	// Writes are kept in the primary while the secondary fails, and reconciled once it is back
+	atomic.StoreInt32(&flaky.failing, 1)
+	if err := b.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := b.Replicas()
+	if status[0].Failed != 2 || len(status[0].LastError) == 0 {
+		t.Errorf("expected 2 failed keys, got %+v", status[0])
+	}
+	if status[1].Failed != 0 || status[1].Replicated != 4 {
+		t.Errorf("expected the object store to be up to date, got %+v", status[1])
+	}
+	if value, _ := b.Get([]byte("a")); !bytes.Equal(value, []byte("3")) {
+		t.Errorf("expected the primary to be written, got %v", value)
+	}
+
+	atomic.StoreInt32(&flaky.failing, 0)
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _ := flaky.Get([]byte("a")); !bytes.Equal(value, []byte("3")) {
+		t.Errorf("expected the failed write to be reconciled, got %v", value)
+	}
+	if value, _ := flaky.Get([]byte("b")); len(value) != 0 {
+		t.Errorf("expected the failed delete to be reconciled, got %v", value)
+	}
+	if status = b.Replicas(); status[0].Failed != 0 {
+		t.Errorf("expected no failed keys, got %+v", status[0])
+	}
+}
+
+func TestObjectStoreBackend(t *testing.T) {
+	objects := newMapObjectStore()
+	b := NewObjectStoreBackend(objects, "records/")
+
+	if err := b.Put([]byte{0x01, 0xab}, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := objects.Get("records/01ab"); !bytes.Equal(value, []byte("value")) {
+		t.Errorf("expected the record to be stored as an object, got %v", value)
+	}
+	if value, err := b.Get([]byte{0x01, 0xab}); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("expected the stored value, got %v, %v", value, err)
+	}
+
+	if err := b.Delete([]byte{0x01, 0xab}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.Get([]byte{0x01, 0xab}); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected an empty value for a missing key, got %v, %v", value, err)
+	}
+
+	if err := b.Put(nil, []byte("value")); err == nil {
+		t.Error("expected an error putting a nil key")
+	}
+	if _, err := b.Get([]byte{}); err == nil {
+		t.Error("expected an error getting an empty key")
+	}
+	if err := b.Reset(); err == nil {
+		t.Error("expected an error resetting an object store")
+	}
+}
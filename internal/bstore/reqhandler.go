@@ -1,29 +1,137 @@
 package bstore
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/bits"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/koinos/koinos-log-golang/v2"
 	"github.com/koinos/koinos-proto-golang/v2/koinos"
-	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
 	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
 	"google.golang.org/protobuf/proto"
 )
 
 const (
 	highestBlockKey = 0x01
-	maxBlockRequest = 1000
+	schemaCountsKey = 0x02
+	checkpointKey   = 0x03
+	irreversibleKey = 0x04
+
+	heightIndexPrefix   = 0x05
+	blockMetadataPrefix = 0x06
+
+	coldStorageHeightKey = 0x07
+
+	payerIndexPrefix    = 0x08
+	payerIndexLowestKey = 0x09
+
+	walPositionKey = 0x0a
+
+	blockRecordPrefix          = 0x0b
+	blockRecordMultihashPrefix = 0x0c
+	formatVersionKey           = 0x0d
 )
 
 // RequestHandler contains a backend object and handles requests
 type RequestHandler struct {
 	Backend BlockStoreBackend
 
-	lock sync.RWMutex
+	// ChainID, if set, tags block added notifications, statuses and extended responses so consumers of
+	// several block stores can tell their chains apart
+	ChainID []byte
+
+	// SchemaTracker, if set, counts received messages by producer schema version
+	SchemaTracker *SchemaTracker
+
+	// BackupDir is the directory backups are written to
+	BackupDir string
+
+	// ExportDir is the directory chain exports are written to
+	ExportDir string
+
+	// AllowRestore enables the RestoreStore request, which replaces the entire database
+	AllowRestore bool
+
+	// Strict rejects requests containing fields unknown to this block store instead of ignoring them
+	Strict bool
+
+	// AdminSecret, if set, authorizes admin requests which carry it
+	AdminSecret string
+
+	// AdminAllowlist names the admin requests which are served without the admin secret
+	AdminAllowlist []string
+
+	// MaxMessageSize is the maximum response size in bytes, 0 uses DefaultMaxMessageSize. It and the
+	// other fields of Settings are replaced with ApplySettings once requests are served.
+	MaxMessageSize int
+
+	// MaxBlocksByHeight is the maximum number of blocks per request by height, 0 uses DefaultMaxBlocksByHeight
+	MaxBlocksByHeight uint64
+
+	// MaxBlocksByID is the maximum number of blocks per request by ID, 0 uses DefaultMaxBlocksByID
+	MaxBlocksByID uint64
+
+	// StaleHeadAfter, if set, is the time after which a head which has not advanced is reported as stale
+	StaleHeadAfter time.Duration
+
+	// RateLimit, if set, is the number of requests per second served to each client, and
+	// RateLimitBurst the number served at once, 0 for one second of requests
+	RateLimit      float64
+	RateLimitBurst int
+
+	// RequestTimeout, if set, is the time after which a query which has not completed fails with a
+	// RequestTimeoutError. AddBlock, admin and health requests are not bounded.
+	RequestTimeout time.Duration
+
+	// ReadOnly refuses AddBlock and the admin requests which change the blocks of the database, for
+	// query nodes serving a restored snapshot
+	ReadOnly bool
+
+	// IngestFilters decide what AddBlock persists, blocks they drop are acknowledged without being stored
+	IngestFilters []IngestFilter
+
+	// DependentIndexes are checked by CheckPruneSafety before blocks are pruned
+	DependentIndexes []DependentIndex
+
+	// StatusReporters contribute their status to GetStatus
+	StatusReporters []StatusReporter
+
+	// WAL, if set, is the backend recording writes for standbys, it serves their GetWAL requests
+	WAL *WALBackend
+
+	// Standby, if set, follows the write-ahead log of a primary block store until it is promoted
+	Standby *Standby
+
+	// ErrorJournal, if set, records the errors returned to requests
+	ErrorJournal *ErrorJournal
+
+	// Metrics, if set, counts the requests served and the errors returned
+	Metrics *Metrics
+
+	// OnBlockAdded, if set, is called after a block has been persisted by AddBlock. It is called with
+	// the handler lock held and must neither block nor call back into the handler.
+	OnBlockAdded func(*BlockAdded)
+
+	lock        sync.RWMutex
+	compacting  int32
+	backingUp   int32
+	exporting   int32
+	compressing int32
+
+	// headAdvanced is the time the highest block last changed in Unix nanoseconds
+	headAdvanced int64
+
+	// applied holds the *Settings last applied with ApplySettings
+	applied atomic.Value
+
+	rateLimits rateLimiter
 }
 
 // ReservedReqError is an error type that is thrown when a reserved request is passed to the request handler
@@ -34,6 +142,11 @@ func (e *ReservedReqError) Error() string {
 	return "Reserved request is not supported"
 }
 
+// Code returns the error code
+func (e *ReservedReqError) Code() ErrorCode {
+	return ErrorCodeReservedRequest
+}
+
 // UnknownReqError is an error that is thrown when an unknown request is given to the request handler
 type UnknownReqError struct {
 }
@@ -42,6 +155,11 @@ func (e *UnknownReqError) Error() string {
 	return "Unknown request type"
 }
 
+// Code returns the error code
+func (e *UnknownReqError) Code() ErrorCode {
+	return ErrorCodeUnknownRequest
+}
+
 // InternalError is an error type that is thrown when an internal constraint is violated
 type InternalError struct {
 }
@@ -50,6 +168,11 @@ func (e *InternalError) Error() string {
 	return "Internal constraint was violated"
 }
 
+// Code returns the error code
+func (e *InternalError) Code() ErrorCode {
+	return ErrorCodeInternal
+}
+
 // BlockNotPresent is an error type thrown when asking for a block that is not contained in the blockstore
 type BlockNotPresent struct {
 	blockID []byte
@@ -59,6 +182,16 @@ func (e *BlockNotPresent) Error() string {
 	return fmt.Sprintf("Block not present - ID: 0x%v", hex.EncodeToString(e.blockID))
 }
 
+// Code returns the error code
+func (e *BlockNotPresent) Code() ErrorCode {
+	return ErrorCodeBlockNotPresent
+}
+
+// Details returns the ID of the missing block
+func (e *BlockNotPresent) Details() map[string]interface{} {
+	return map[string]interface{}{"block_id": "0x" + hex.EncodeToString(e.blockID)}
+}
+
 // DeserializeError is an error type for errors during deserialization
 type DeserializeError struct {
 }
@@ -67,6 +200,11 @@ func (e *DeserializeError) Error() string {
 	return "Could not deserialize block"
 }
 
+// Code returns the error code
+func (e *DeserializeError) Code() ErrorCode {
+	return ErrorCodeDeserialize
+}
+
 // UnexpectedHeightError is an error type for bad block heights
 type UnexpectedHeightError struct {
 }
@@ -75,6 +213,11 @@ func (e *UnexpectedHeightError) Error() string {
 	return "Unexpected height (corrupt block store?)"
 }
 
+// Code returns the error code
+func (e *UnexpectedHeightError) Code() ErrorCode {
+	return ErrorCodeUnexpectedHeight
+}
+
 // TraverseBeforeGenesisError is an error type when the blockchain attempts to traverse before genesis
 type TraverseBeforeGenesisError struct {
 }
@@ -83,6 +226,11 @@ func (e *TraverseBeforeGenesisError) Error() string {
 	return "Attempt to traverse before genesis"
 }
 
+// Code returns the error code
+func (e *TraverseBeforeGenesisError) Code() ErrorCode {
+	return ErrorCodeBeforeGenesis
+}
+
 // NotImplemented is an error type for unimplemented types
 type NotImplemented struct {
 }
@@ -91,6 +239,11 @@ func (e *NotImplemented) Error() string {
 	return "Unimplemented case"
 }
 
+// Code returns the error code
+func (e *NotImplemented) Code() ErrorCode {
+	return ErrorCodeNotImplemented
+}
+
 // BlockHeightMismatch is an error type thrown when querying ancestor of block B at height H where H >= B.height.
 type BlockHeightMismatch struct {
 }
@@ -99,10 +252,34 @@ func (e *BlockHeightMismatch) Error() string {
 	return "Block height mismatch"
 }
 
-// GetBlocksByID returns blocks by block ID
-func (handler *RequestHandler) GetBlocksByID(req *block_store.GetBlocksByIdRequest) (*block_store.GetBlocksByIdResponse, error) {
-	if len(req.BlockIds) > maxBlockRequest {
-		return nil, fmt.Errorf("cannot request more than %v blocks", maxBlockRequest)
+// Code returns the error code
+func (e *BlockHeightMismatch) Code() ErrorCode {
+	return ErrorCodeHeightMismatch
+}
+
+// BelowCheckpoint is an error type thrown when requesting blocks below the checkpoint the store was bootstrapped from
+type BelowCheckpoint struct {
+	checkpointHeight uint64
+}
+
+func (e *BelowCheckpoint) Error() string {
+	return fmt.Sprintf("Requested block is below checkpoint at height %d", e.checkpointHeight)
+}
+
+// Code returns the error code
+func (e *BelowCheckpoint) Code() ErrorCode {
+	return ErrorCodeBelowCheckpoint
+}
+
+// Details returns the height of the checkpoint
+func (e *BelowCheckpoint) Details() map[string]interface{} {
+	return map[string]interface{}{"checkpoint_height": e.checkpointHeight}
+}
+
+// GetBlocksByID returns blocks by block ID. It stops with the error of ctx once ctx is done.
+func (handler *RequestHandler) GetBlocksByID(ctx context.Context, req *block_store.GetBlocksByIdRequest) (*block_store.GetBlocksByIdResponse, error) {
+	if uint64(len(req.BlockIds)) > handler.maxBlocksByID() {
+		return nil, &LimitExceededError{Limit: handler.maxBlocksByID(), Requested: uint64(len(req.BlockIds))}
 	}
 
 	result := block_store.GetBlocksByIdResponse{}
@@ -117,9 +294,15 @@ func (handler *RequestHandler) GetBlocksByID(req *block_store.GetBlocksByIdReque
 		if req.GetBlockIds()[i] == nil {
 			return nil, errors.New("member of field 'block_id' was nil")
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		bytes, err := handler.Backend.Get(req.GetBlockIds()[i])
-		if err != nil {
+		bytes, err := handler.Backend.Get(blockRecordKey(req.GetBlockIds()[i]))
+		if ErrorCodeOf(err) == ErrorCodeCorruption {
+			// A corrupted block is not reported as missing, it would not be added again
+			return nil, err
+		} else if err != nil {
 			continue
 		}
 
@@ -150,6 +333,8 @@ func (handler *RequestHandler) GetBlocksByID(req *block_store.GetBlocksByIdReque
  * Return empty block if we go past the beginning.
  */
 func (handler *RequestHandler) fillBlocks(
+	ctx context.Context,
+	backend BlockStoreBackend,
 	lastID []byte,
 	numBlocks uint32,
 	returnBlock bool,
@@ -167,7 +352,11 @@ func (handler *RequestHandler) fillBlocks(
 		// k is the index into the array
 		k := numBlocks - i - 1
 
-		recordBytes, err := handler.Backend.Get(lastID)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		recordBytes, err := backend.Get(blockRecordKey(lastID))
 		if err != nil {
 			return nil, err
 		}
@@ -214,10 +403,10 @@ func (handler *RequestHandler) fillBlocks(
 	return blockItems, nil
 }
 
-// GetBlocksByHeight retuns blocks by block height
-func (handler *RequestHandler) GetBlocksByHeight(req *block_store.GetBlocksByHeightRequest) (*block_store.GetBlocksByHeightResponse, error) {
-	if req.GetNumBlocks() > maxBlockRequest {
-		return nil, fmt.Errorf("cannot request more than %v blocks", maxBlockRequest)
+// GetBlocksByHeight retuns blocks by block height. It stops with the error of ctx once ctx is done.
+func (handler *RequestHandler) GetBlocksByHeight(ctx context.Context, req *block_store.GetBlocksByHeightRequest) (*block_store.GetBlocksByHeightResponse, error) {
+	if uint64(req.GetNumBlocks()) > handler.maxBlocksByHeight() {
+		return nil, &LimitExceededError{Limit: handler.maxBlocksByHeight(), Requested: uint64(req.GetNumBlocks())}
 	}
 
 	resp := block_store.GetBlocksByHeightResponse{}
@@ -234,7 +423,26 @@ func (handler *RequestHandler) GetBlocksByHeight(req *block_store.GetBlocksByHei
 		return nil, errors.New("expected field, 'head_block_id' was nil")
 	}
 
-	headBlockHeight, err := getBlockHeight(handler.Backend, req.HeadBlockId)
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil && req.AncestorStartHeight < checkpoint.Height {
+		return nil, &BelowCheckpoint{checkpoint.Height}
+	}
+
+	// The traversal to the end height and the fill read a single point in time, a block added
+	// concurrently is either entirely seen or not at all. They revisit records, read each of them once.
+	snapshot, err := NewSnapshotBackend(handler.Backend)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	backend := NewMemoBackend(snapshot)
+
+	headBlockHeight, err := getBlockHeight(backend, req.HeadBlockId)
 	if err != nil {
 		return nil, err
 	}
@@ -250,14 +458,14 @@ func (handler *RequestHandler) GetBlocksByHeight(req *block_store.GetBlocksByHei
 		numBlocks = uint32(endHeight - uint64(req.AncestorStartHeight) + 1)
 	}
 
-	blockID, err := getAncestorIDAtHeight(handler.Backend, req.HeadBlockId, endHeight)
+	blockID, err := getAncestorIDAtHeight(ctx, backend, req.HeadBlockId, endHeight)
 	if err != nil {
 		if _, ok := err.(*BlockHeightMismatch); !ok {
 			return nil, err
 		}
 	}
 
-	resp.BlockItems, err = handler.fillBlocks(blockID, numBlocks, req.GetReturnBlock(), req.ReturnReceipt)
+	resp.BlockItems, err = handler.fillBlocks(ctx, backend, blockID, numBlocks, req.GetReturnBlock(), req.ReturnReceipt)
 	if err != nil {
 		return nil, err
 	}
@@ -342,7 +550,7 @@ func getPreviousHeightIndex(goal uint64, current uint64) (int, uint64, error) {
  * Fetch a block by ID and then return its height.
  */
 func getBlockHeight(backend BlockStoreBackend, blockID []byte) (uint64, error) {
-	recordBytes, err := backend.Get(blockID)
+	recordBytes, err := backend.Get(blockRecordKey(blockID))
 	if err != nil {
 		return 0, err
 	}
@@ -361,13 +569,19 @@ func getBlockHeight(backend BlockStoreBackend, blockID []byte) (uint64, error) {
 	return record.BlockHeight, nil
 }
 
-func getAncestorIDAtHeight(backend BlockStoreBackend, blockID []byte, height uint64) ([]byte, error) {
+// getAncestorIDAtHeight walks back from blockID to its ancestor at height. It stops with the error of
+// ctx once ctx is done, as the walk on a corrupted database may not end.
+func getAncestorIDAtHeight(ctx context.Context, backend BlockStoreBackend, blockID []byte, height uint64) ([]byte, error) {
 
 	var expectedHeight uint64
 	var hasExpectedHeight bool = false
 
 	for {
-		recordBytes, err := backend.Get(blockID)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		recordBytes, err := backend.Get(blockRecordKey(blockID))
 		if err != nil {
 			return nil, err
 		}
@@ -412,6 +626,9 @@ func getAncestorIDAtHeight(backend BlockStoreBackend, blockID []byte, height uin
 
 // AddBlock adds a block to the block store
 func (handler *RequestHandler) AddBlock(req *block_store.AddBlockRequest) (*block_store.AddBlockResponse, error) {
+	if handler.ReadOnly {
+		return nil, &ReadOnlyError{Request: "add_block"}
+	}
 
 	if req.GetBlockToAdd() == nil {
 		return nil, errors.New("cannot add empty optional block")
@@ -427,6 +644,24 @@ func (handler *RequestHandler) AddBlock(req *block_store.AddBlockRequest) (*bloc
 		return nil, errors.New("block header must not be nil")
 	}
 
+	req, ok := handler.applyIngestFilters(req)
+	if !ok {
+		return &block_store.AddBlockResponse{}, nil
+	}
+	block = req.GetBlockToAdd()
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the checkpoint block itself may be added at or below the checkpoint height
+	if checkpoint != nil && block.GetHeader().GetHeight() <= checkpoint.Height {
+		if block.GetHeader().GetHeight() < checkpoint.Height || !bytes.Equal(block.GetId(), checkpoint.BlockID) {
+			return nil, &BelowCheckpoint{checkpoint.Height}
+		}
+	}
+
 	record := block_store.BlockRecord{}
 
 	record.BlockId = block.GetId()
@@ -435,54 +670,105 @@ func (handler *RequestHandler) AddBlock(req *block_store.AddBlockRequest) (*bloc
 
 	record.Receipt = req.GetReceiptToAdd()
 
-	if block.GetHeader().GetHeight() > 1 {
-		previousHeights := getPreviousHeights(block.GetHeader().GetHeight())
-
-		record.PreviousBlockIds = make([][]byte, len(previousHeights))
-
-		for i := 0; i < len(previousHeights); i++ {
-			h := previousHeights[i]
-			if h >= record.GetBlockHeight() {
-				return nil, &InternalError{}
-			} else if h == uint64(record.BlockHeight)-1 {
-				record.PreviousBlockIds[i] = block.GetHeader().GetPrevious()
-			} else {
-				previousID, err := getAncestorIDAtHeight(handler.Backend, block.GetHeader().GetPrevious(), h)
-				if err != nil {
-					return nil, err
-				}
-				record.PreviousBlockIds[i] = previousID
-			}
+	// The ancestors are read and the block record and its index updates written in a single
+	// transaction, a failure never leaves a block which is missing from the indexes
+	var existing bool
+	var advanced bool
+	err = handler.transact(func(backend BlockStoreBackend) error {
+		var err error
+		existing, err = backend.Has(blockRecordKey(block.GetId()))
+		if err != nil {
+			return err
+		}
+
+		record.PreviousBlockIds, err = previousBlockIds(backend, block, checkpoint)
+		if err != nil {
+			return err
+		}
+
+		vbValue, err := proto.Marshal(&record)
+		if err != nil {
+			return err
+		}
+
+		if err = backend.Put(blockRecordKey(record.GetBlockId()), vbValue); err != nil {
+			return err
 		}
-	} else {
-		record.PreviousBlockIds = make([][]byte, 1)
-		record.PreviousBlockIds[0] = block.Header.Previous
-	}
 
-	vbValue, err := proto.Marshal(&record)
+		if err = addToHeightIndex(backend, record.GetBlockHeight(), record.GetBlockId()); err != nil {
+			return err
+		}
+
+		if err = putBlockMetadata(backend, newBlockMetadata(block, record.GetBlockHeight())); err != nil {
+			return err
+		}
+
+		if err = addToPayerIndex(backend, block, record.GetBlockHeight()); err != nil {
+			return err
+		}
+
+		advanced, err = updateHighestBlock(backend, &koinos.BlockTopology{
+			Id:       block.Id,
+			Height:   block.Header.Height,
+			Previous: block.Header.Previous,
+		})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	err = handler.Backend.Put(record.GetBlockId(), vbValue)
-	if err != nil {
-		return nil, err
+	if advanced {
+		handler.markHeadAdvanced()
 	}
 
-	err = handler.UpdateHighestBlock(&koinos.BlockTopology{
-		Id:       block.Id,
-		Height:   block.Header.Height,
-		Previous: block.Header.Previous,
-	})
-	if err != nil {
-		_ = handler.Backend.Delete(record.GetBlockId())
-		return nil, err
+	if handler.OnBlockAdded != nil {
+		handler.OnBlockAdded(&BlockAdded{
+			BlockID:    block.GetId(),
+			Height:     block.GetHeader().GetHeight(),
+			PreviousID: block.GetHeader().GetPrevious(),
+			New:        !existing,
+			ChainID:    handler.ChainID,
+		})
 	}
 
 	resp := block_store.AddBlockResponse{}
 	return &resp, nil
 }
 
+// previousBlockIds returns the skip links of block, reading its ancestors from backend
+func previousBlockIds(backend BlockStoreBackend, block *protocol.Block, checkpoint *Checkpoint) ([][]byte, error) {
+	height := block.GetHeader().GetHeight()
+	if height <= 1 {
+		return [][]byte{block.GetHeader().GetPrevious()}, nil
+	}
+
+	previousHeights := getPreviousHeights(height)
+
+	// Every ancestor lookup starts at the previous block and the paths overlap, read each record once
+	ancestors := NewMemoBackend(backend)
+
+	previousBlockIds := make([][]byte, len(previousHeights))
+	for i, h := range previousHeights {
+		if h >= height {
+			return nil, &InternalError{}
+		} else if h == height-1 {
+			previousBlockIds[i] = block.GetHeader().GetPrevious()
+		} else if checkpoint != nil && h < checkpoint.Height {
+			// Blocks below the checkpoint are unknown
+			previousBlockIds[i] = []byte{}
+		} else {
+			previousID, err := getAncestorIDAtHeight(context.Background(), ancestors, block.GetHeader().GetPrevious(), h)
+			if err != nil {
+				return nil, err
+			}
+			previousBlockIds[i] = previousID
+		}
+	}
+
+	return previousBlockIds, nil
+}
+
 // GetHighestBlock returns the highest block seen by the block store
 func (handler *RequestHandler) GetHighestBlock(req *block_store.GetHighestBlockRequest) (*block_store.GetHighestBlockResponse, error) {
 	recordBytes, err := handler.Backend.Get([]byte{highestBlockKey})
@@ -508,88 +794,194 @@ func (handler *RequestHandler) GetHighestBlock(req *block_store.GetHighestBlockR
 
 // UpdateHighestBlock Updates the database metadata with the highest blocks ID
 func (handler *RequestHandler) UpdateHighestBlock(topology *koinos.BlockTopology) error {
-	recordBytes, err := handler.Backend.Get([]byte{highestBlockKey})
+	advanced, err := updateHighestBlock(handler.Backend, topology)
+	if err != nil {
+		return err
+	}
+
+	if advanced {
+		handler.markHeadAdvanced()
+	}
+	return nil
+}
+
+// updateHighestBlock records the highest block in backend, returning true if it advanced
+func updateHighestBlock(backend BlockStoreBackend, topology *koinos.BlockTopology) (bool, error) {
+	recordBytes, err := backend.Get([]byte{highestBlockKey})
 	if err == nil && len(recordBytes) > 0 {
 		currentValue := koinos.BlockTopology{}
 		err = proto.Unmarshal(recordBytes, &currentValue)
 		if err != nil {
 			log.Warn("Could not deserialize highest block")
-			return errors.New("Current highest block corrupted")
+			return false, errors.New("Current highest block corrupted")
 		}
 
 		// If our current highest block height is greater, do nothing
 		if currentValue.GetHeight() >= topology.GetHeight() {
-			return nil
+			return false, nil
 		}
 	}
 
 	newValue, err := proto.Marshal(topology)
+	if err != nil {
+		return false, err
+	}
+
+	if err = backend.Put([]byte{highestBlockKey}, newValue); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UpdateIrreversibleBlock records the last irreversible block, ignoring blocks lower than the current one
+func (handler *RequestHandler) UpdateIrreversibleBlock(topology *koinos.BlockTopology) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	current, err := handler.getIrreversibleBlock()
+	if err != nil {
+		return err
+	}
+
+	if current != nil && current.GetHeight() >= topology.GetHeight() {
+		return nil
+	}
+
+	value, err := proto.Marshal(topology)
 	if err != nil {
 		return err
 	}
 
-	return handler.Backend.Put([]byte{highestBlockKey}, newValue)
+	return handler.Backend.Put([]byte{irreversibleKey}, value)
+}
+
+// getIrreversibleBlock returns the last irreversible block, or nil if none has been recorded
+func (handler *RequestHandler) getIrreversibleBlock() (*koinos.BlockTopology, error) {
+	value, err := handler.Backend.Get([]byte{irreversibleKey})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	topology := &koinos.BlockTopology{}
+	if err = proto.Unmarshal(value, topology); err != nil {
+		return nil, errors.New("irreversible block record corrupted")
+	}
+
+	return topology, nil
 }
 
 // HandleRequest handles and routes blockstore requests
+//
+// Requests are ordered by the handler lock and backend writes are committed before AddBlock returns,
+// so once an AddBlock has returned, any subsequent request from any worker observes the block.
 func (handler *RequestHandler) HandleRequest(req *block_store.BlockStoreRequest) *block_store.BlockStoreResponse {
+	return handler.HandleRequestFrom("", req)
+}
+
+// HandleRequestFrom handles a blockstore request of client, the reply-to queue of the RPC request,
+// whose requests are rate limited together. Requests of the block store itself have no client and
+// are not limited.
+func (handler *RequestHandler) HandleRequestFrom(client string, req *block_store.BlockStoreRequest) *block_store.BlockStoreResponse {
+	start := time.Now()
 	response := block_store.BlockStoreResponse{}
 	var err error
 
-	if req.Request != nil {
-		switch v := req.Request.(type) {
-		case *block_store.BlockStoreRequest_GetBlocksById:
-			var result *block_store.GetBlocksByIdResponse
-			handler.lock.RLock()
-			defer handler.lock.RUnlock()
+	if handler.Strict {
+		if fields := UnknownFields(req.ProtoReflect()); len(fields) > 0 {
+			respVal := block_store.BlockStoreResponse_Error{Error: NewErrorStatus(&UnknownFieldsError{Fields: fields})}
+			response.Response = &respVal
+			return &response
+		}
+	}
 
-			result, err = handler.GetBlocksByID(v.GetBlocksById)
-			if err == nil {
-				respVal := block_store.BlockStoreResponse_GetBlocksById{GetBlocksById: result}
-				response.Response = &respVal
-			}
-		case *block_store.BlockStoreRequest_GetBlocksByHeight:
-			var result *block_store.GetBlocksByHeightResponse
-			handler.lock.RLock()
-			defer handler.lock.RUnlock()
+	if req.Request == nil {
+		err = &InvalidRequestError{Reason: "expected request was nil"}
+	} else if limited := handler.checkRateLimit(client); limited != nil {
+		err = limited
+	} else {
+		// A block which was added after its request timed out would be reported as not added
+		_, isAddBlock := req.Request.(*block_store.BlockStoreRequest_AddBlock)
 
-			result, err = handler.GetBlocksByHeight(v.GetBlocksByHeight)
+		err = handler.withTimeout(blockStoreRequestName(req), !isAddBlock, func(ctx context.Context) error {
+			result, err := handler.dispatchRequest(ctx, req)
 			if err == nil {
-				respVal := block_store.BlockStoreResponse_GetBlocksByHeight{GetBlocksByHeight: result}
-				response.Response = &respVal
+				response.Response = result.Response
 			}
-		case *block_store.BlockStoreRequest_AddBlock:
-			var result *block_store.AddBlockResponse
-			handler.lock.Lock()
-			defer handler.lock.Unlock()
+			return err
+		})
+	}
 
-			result, err = handler.AddBlock(v.AddBlock)
-			if err == nil {
-				respVal := block_store.BlockStoreResponse_AddBlock{AddBlock: result}
-				response.Response = &respVal
-			}
-		case *block_store.BlockStoreRequest_GetHighestBlock:
-			var result *block_store.GetHighestBlockResponse
-			handler.lock.RLock()
-			defer handler.lock.RUnlock()
+	handler.Metrics.recordRequest(blockStoreRequestName(req), time.Since(start), err)
 
-			result, err = handler.GetHighestBlock(v.GetHighestBlock)
-			if err == nil {
-				respVal := block_store.BlockStoreResponse_GetHighestBlock{GetHighestBlock: result}
-				response.Response = &respVal
-			}
-		default:
-			err = errors.New("unknown request")
+	if err != nil {
+		if handler.ErrorJournal != nil {
+			handler.ErrorJournal.Record(blockStoreRequestName(req), SummarizeRequest(req), err)
 		}
-	} else {
-		err = errors.New("expected request was nil")
-	}
 
-	if err != nil {
-		result := rpc.ErrorStatus{Message: err.Error()}
-		respVal := block_store.BlockStoreResponse_Error{Error: &result}
+		respVal := block_store.BlockStoreResponse_Error{Error: NewErrorStatus(err)}
 		response.Response = &respVal
 	}
 
 	return &response
 }
+
+// dispatchRequest serves a request which is set, taking the lock it needs. Queries stop once ctx is
+// done.
+func (handler *RequestHandler) dispatchRequest(ctx context.Context, req *block_store.BlockStoreRequest) (*block_store.BlockStoreResponse, error) {
+	response := block_store.BlockStoreResponse{}
+	var err error
+
+	switch v := req.Request.(type) {
+	case *block_store.BlockStoreRequest_Reserved:
+		err = &ReservedReqError{}
+	case *block_store.BlockStoreRequest_GetBlocksById:
+		var result *block_store.GetBlocksByIdResponse
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		result, err = handler.GetBlocksByID(ctx, v.GetBlocksById)
+		if err == nil {
+			respVal := block_store.BlockStoreResponse_GetBlocksById{GetBlocksById: result}
+			response.Response = &respVal
+		}
+	case *block_store.BlockStoreRequest_GetBlocksByHeight:
+		var result *block_store.GetBlocksByHeightResponse
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		result, err = handler.GetBlocksByHeight(ctx, v.GetBlocksByHeight)
+		if err == nil {
+			respVal := block_store.BlockStoreResponse_GetBlocksByHeight{GetBlocksByHeight: result}
+			response.Response = &respVal
+		}
+	case *block_store.BlockStoreRequest_AddBlock:
+		var result *block_store.AddBlockResponse
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+
+		result, err = handler.AddBlock(v.AddBlock)
+		if err == nil {
+			respVal := block_store.BlockStoreResponse_AddBlock{AddBlock: result}
+			response.Response = &respVal
+		}
+	case *block_store.BlockStoreRequest_GetHighestBlock:
+		var result *block_store.GetHighestBlockResponse
+		handler.lock.RLock()
+		defer handler.lock.RUnlock()
+
+		result, err = handler.GetHighestBlock(v.GetHighestBlock)
+		if err == nil {
+			respVal := block_store.BlockStoreResponse_GetHighestBlock{GetHighestBlock: result}
+			response.Response = &respVal
+		}
+	default:
+		err = &UnknownReqError{}
+	}
+
+	return &response, err
+}
@@ -2,6 +2,7 @@ package bstore
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
@@ -23,7 +24,11 @@ const (
 	BadgerBackendType = 1
 )
 
-var backendTypes = [...]int{MapBackendType, BadgerBackendType}
+// backendTypes are the backends every backend test runs against, indexed by backend type. Backends
+// which need a build tag register themselves in taggedBackends.
+var backendTypes = []int{MapBackendType, BadgerBackendType}
+
+var taggedBackends = make(map[int]func() BlockStoreBackend)
 
 func NewBackend(backendType int) BlockStoreBackend {
 	var backend BlockStoreBackend
@@ -38,7 +43,11 @@ func NewBackend(backendType int) BlockStoreBackend {
 		opts := badger.DefaultOptions(dirname)
 		backend, _ = NewBadgerBackend(opts)
 	default:
-		panic("unknown backend type")
+		newBackend, ok := taggedBackends[backendType]
+		if !ok {
+			panic("unknown backend type")
+		}
+		backend = newBackend()
 	}
 	return backend
 }
@@ -49,6 +58,8 @@ func CloseBackend(b interface{}) {
 		break
 	case *BadgerBackend:
 		t.Close()
+	case interface{ Close() }:
+		t.Close()
 	default:
 		panic("unknown backend type")
 	}
@@ -160,6 +171,9 @@ func BuildTestTree(t *testing.T, handler *RequestHandler, bt *BlockTree) {
 			if string(errval.Error.Message) != blockNotPresent.Error() {
 				t.Error("Unexpected error text")
 			}
+			if code := ErrorCodeOfStatus(errval.Error); code != ErrorCodeBlockNotPresent {
+				t.Errorf("Unexpected error code %s", code)
+			}
 		}
 	}
 }
@@ -341,6 +355,9 @@ func addBlocksTestImpl(t *testing.T, backendType int, addZeroBlock bool) {
 			if errval.Error.Message != "Block height mismatch" {
 				t.Error("Unexpected error text")
 			}
+			if code := ErrorCodeOfStatus(errval.Error); code != ErrorCodeHeightMismatch {
+				t.Errorf("Unexpected error code %s", code)
+			}
 		}
 	}
 
@@ -407,7 +424,7 @@ func addBlocksTestImpl(t *testing.T, backendType int, addZeroBlock bool) {
 		ReturnBlock:         true,
 		ReturnReceipt:       false,
 	}
-	_, err := handler.GetBlocksByHeight(byHeightReq)
+	_, err := handler.GetBlocksByHeight(context.Background(), byHeightReq)
 	if err == nil {
 		t.Errorf("Excepted error for AncestorStartHeight == 0")
 	}
@@ -416,7 +433,7 @@ func addBlocksTestImpl(t *testing.T, backendType int, addZeroBlock bool) {
 }
 
 func TestAddBlocks(t *testing.T) {
-	for backendType := range backendTypes {
+	for _, backendType := range backendTypes {
 		addBlocksTestImpl(t, backendType, false)
 		addBlocksTestImpl(t, backendType, true)
 	}
@@ -613,7 +630,7 @@ func (backend *TxnLongBackend) Get(key []byte) ([]byte, error) {
 }
 
 func TestGetHighestBlock(t *testing.T) {
-	for bType := range backendTypes {
+	for _, bType := range backendTypes {
 		blockID, _ := multihash.EncodeName([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A}, "sha2-256")
 		previousID, _ := multihash.EncodeName([]byte{0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10, 0x11, 0x12, 0x13, 0x14}, "sha2-256")
 		height := uint64(2)
@@ -0,0 +1,223 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/chain"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ChainRPC is the RPC service of the chain, which reports the head the block store resyncs to
+	ChainRPC = "chain"
+
+	// BlockStoreRPC is the RPC service of the block stores the blocks are requested from
+	BlockStoreRPC = "block_store"
+
+	// DefaultResyncBatchSize is the default number of blocks requested at once by a resync
+	DefaultResyncBatchSize = 100
+
+	// DefaultResyncRetryDelay is the default delay before a failed block request is retried
+	DefaultResyncRetryDelay = time.Second
+
+	resyncAttempts    = 5
+	protoContentType  = "application/octet-stream"
+	resyncLogInterval = 1000
+)
+
+// ResyncResult reports the blocks added by a resync
+type ResyncResult struct {
+	Blocks     uint64   `json:"blocks"`
+	HeadHeight uint64   `json:"head_height"`
+	HeadID     HexBytes `json:"head_id"`
+}
+
+// Resync catches an empty or lagging block store up with the chain. It reads the head of the chain
+// with get_head_info and requests the blocks above the highest stored block up to that head with
+// get_blocks_by_height, which is answered by the other block stores of the deployment sharing the
+// AMQP server. It repeats until no block is missing, as the chain advances while blocks are added.
+type Resync struct {
+	Handler *RequestHandler
+	Client  RPCClient
+
+	// BatchSize is the number of blocks requested at once, 0 uses DefaultResyncBatchSize. It is
+	// lowered to the number of blocks returned when fewer are, so a limit of the block stores
+	// answering is only hit once.
+	BatchSize uint64
+
+	// RetryDelay is the delay before a failed block request is retried, 0 uses
+	// DefaultResyncRetryDelay
+	RetryDelay time.Duration
+}
+
+// Run adds the missing blocks until the highest stored block reaches the head of the chain, or ctx
+// is done
+func (r *Resync) Run(ctx context.Context) (*ResyncResult, error) {
+	if r.BatchSize == 0 {
+		r.BatchSize = DefaultResyncBatchSize
+	}
+
+	result := &ResyncResult{}
+	for {
+		head, err := r.chainHead(ctx)
+		if err != nil {
+			return result, fmt.Errorf("could not read the head of the chain, %w", err)
+		}
+
+		// An empty database has no highest block
+		var height uint64
+		r.Handler.lock.RLock()
+		highest, err := r.Handler.GetHighestBlock(&block_store.GetHighestBlockRequest{})
+		r.Handler.lock.RUnlock()
+		if err == nil {
+			height = highest.GetTopology().GetHeight()
+			result.HeadHeight = height
+			result.HeadID = highest.GetTopology().GetId()
+		} else if _, ok := err.(*UnexpectedHeightError); !ok {
+			return result, err
+		}
+
+		if height >= head.GetHeight() {
+			return result, nil
+		}
+		log.Infof("Resyncing blocks %d to %d from the block stores of the deployment", height+1, head.GetHeight())
+
+		for height < head.GetHeight() {
+			numBlocks := head.GetHeight() - height
+			if numBlocks > r.BatchSize {
+				numBlocks = r.BatchSize
+			}
+
+			items, err := r.fetchBlocks(ctx, head.GetId(), height+1, numBlocks)
+			if err != nil {
+				return result, fmt.Errorf("could not fetch blocks from height %d, %w", height+1, err)
+			}
+			if uint64(len(items)) < numBlocks && numBlocks == r.BatchSize {
+				r.BatchSize = uint64(len(items))
+			}
+
+			for _, item := range items {
+				if err = r.addBlock(item); err != nil {
+					return result, fmt.Errorf("could not add block at height %d, %w", item.GetBlockHeight(), err)
+				}
+
+				height = item.GetBlockHeight()
+				result.Blocks++
+				result.HeadHeight = height
+				result.HeadID = item.GetBlockId()
+				if height%resyncLogInterval == 0 {
+					log.Infof("Resync block progress - Height: %d, ID: 0x%x", height, item.GetBlockId())
+				}
+			}
+		}
+	}
+}
+
+// addBlock adds a block under the handler lock, as blocks are added from broadcasts meanwhile
+func (r *Resync) addBlock(item *block_store.BlockItem) error {
+	r.Handler.lock.Lock()
+	defer r.Handler.lock.Unlock()
+
+	_, err := r.Handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: item.GetBlock(), ReceiptToAdd: item.GetReceipt()})
+	return err
+}
+
+// chainHead returns the head of the chain
+func (r *Resync) chainHead(ctx context.Context) (*koinos.BlockTopology, error) {
+	req := &chain.ChainRequest{Request: &chain.ChainRequest_GetHeadInfo{GetHeadInfo: &chain.GetHeadInfoRequest{}}}
+	resp := &chain.ChainResponse{}
+	if err := r.call(ctx, ChainRPC, req, resp); err != nil {
+		return nil, err
+	}
+
+	switch response := resp.GetResponse().(type) {
+	case *chain.ChainResponse_GetHeadInfo:
+		if response.GetHeadInfo.GetHeadTopology() == nil {
+			return nil, errors.New("chain reported no head")
+		}
+		return response.GetHeadInfo.GetHeadTopology(), nil
+	case *chain.ChainResponse_Error:
+		return nil, errors.New(response.Error.GetMessage())
+	default:
+		return nil, errors.New("unexpected chain response")
+	}
+}
+
+// fetchBlocks requests the blocks from startHeight on the chain of head, in ascending height order.
+// A failed request is retried with half as many blocks, as it may have exceeded a limit of the block
+// store answering it, or have been answered by a block store which does not have the blocks yet.
+func (r *Resync) fetchBlocks(ctx context.Context, head []byte, startHeight uint64, numBlocks uint64) ([]*block_store.BlockItem, error) {
+	retryDelay := r.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = DefaultResyncRetryDelay
+	}
+
+	var err error
+	for attempt := 0; attempt < resyncAttempts; attempt++ {
+		if attempt > 0 {
+			log.Debugf("Retrying block request from height %d, %s", startHeight, err)
+			if numBlocks > 1 {
+				numBlocks /= 2
+			}
+
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		req := &block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+			GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{
+				HeadBlockId:         head,
+				AncestorStartHeight: startHeight,
+				NumBlocks:           uint32(numBlocks),
+				ReturnBlock:         true,
+				ReturnReceipt:       true,
+			},
+		}}
+		resp := &block_store.BlockStoreResponse{}
+		if err = r.call(ctx, BlockStoreRPC, req, resp); err != nil {
+			continue
+		}
+
+		switch response := resp.GetResponse().(type) {
+		case *block_store.BlockStoreResponse_GetBlocksByHeight:
+			items := response.GetBlocksByHeight.GetBlockItems()
+			if len(items) > 0 && items[0].GetBlockHeight() == startHeight {
+				return items, nil
+			}
+			err = errors.New("block store returned no blocks")
+		case *block_store.BlockStoreResponse_Error:
+			err = errors.New(response.Error.GetMessage())
+		default:
+			err = errors.New("unexpected block store response")
+		}
+	}
+
+	return nil, err
+}
+
+// call sends a protobuf request to an RPC service and decodes its response
+func (r *Resync) call(ctx context.Context, service string, req proto.Message, resp proto.Message) error {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultRemoteTimeout)
+	defer cancel()
+
+	data, err = r.Client.RPC(ctx, protoContentType, service, data)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(data, resp)
+}
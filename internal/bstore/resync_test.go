@@ -0,0 +1,201 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	koinosmq "github.com/koinos/koinos-mq-golang"
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/chain"
+	"google.golang.org/protobuf/proto"
+)
+
+// deploymentClient serves the chain head and the blocks of another block store
+type deploymentClient struct {
+	head  *koinos.BlockTopology
+	store *RequestHandler
+}
+
+func (c *deploymentClient) RPC(ctx context.Context, contentType koinosmq.ContentType, rpcService string, args []byte) ([]byte, error) {
+	switch rpcService {
+	case ChainRPC:
+		return proto.Marshal(&chain.ChainResponse{Response: &chain.ChainResponse_GetHeadInfo{
+			GetHeadInfo: &chain.GetHeadInfoResponse{HeadTopology: c.head},
+		}})
+	case BlockStoreRPC:
+		req := &block_store.BlockStoreRequest{}
+		if err := proto.Unmarshal(args, req); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(c.store.HandleRequest(req))
+	default:
+		return nil, errors.New("unexpected rpc service")
+	}
+}
+
+func TestResync(t *testing.T) {
+	// The other block store serves fewer blocks at once than requested
+	store := &RequestHandler{Backend: NewMapBackend(), MaxBlocksByHeight: 8}
+	bt := buildLinearChain(t, store, 30)
+
+	handler := &RequestHandler{Backend: NewMapBackend()}
+
+	head := func(num uint64) *koinos.BlockTopology {
+		block := bt.ByNum[num]
+		return &koinos.BlockTopology{Id: block.GetId(), Height: block.GetHeader().GetHeight(), Previous: block.GetHeader().GetPrevious()}
+	}
+	client := &deploymentClient{head: head(120), store: store}
+	resync := &Resync{Handler: handler, Client: client, BatchSize: 10, RetryDelay: time.Millisecond}
+
+	result, err := resync.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 20 || result.HeadHeight != 20 || !bytes.Equal(result.HeadID, bt.ByNum[120].GetId()) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if resync.BatchSize != 5 {
+		t.Errorf("expected the batch size to be lowered to 5, got %d", resync.BatchSize)
+	}
+
+	// Only the blocks added by the chain since are requested
+	client.head = head(130)
+	result, err = resync.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 10 || result.HeadHeight != 30 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	verified, err := handler.VerifyChainLinks(context.Background(), &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[130].GetId(), StartHeight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Valid || verified.BlocksChecked != 30 {
+		t.Errorf("expected valid chain of 30 blocks, got %+v", verified)
+	}
+
+	result, err = resync.Run(context.Background())
+	if err != nil || result.Blocks != 0 {
+		t.Errorf("expected an up to date block store to add no blocks, got %+v, %v", result, err)
+	}
+
+	// Blocks no other block store has are reported
+	client.store = &RequestHandler{Backend: NewMapBackend()}
+	client.head = &koinos.BlockTopology{Id: GetNonExistentBlockID(31), Height: 31}
+	if _, err = resync.Run(context.Background()); err == nil {
+		t.Error("expected missing blocks to be reported")
+	}
+}
+
+// fetchingClient signals the first block request to the other block stores
+type fetchingClient struct {
+	RPCClient
+	once     sync.Once
+	fetching chan struct{}
+}
+
+func (c *fetchingClient) RPC(ctx context.Context, contentType koinosmq.ContentType, rpcService string, args []byte) ([]byte, error) {
+	if rpcService == BlockStoreRPC {
+		c.once.Do(func() { close(c.fetching) })
+	}
+	return c.RPCClient.RPC(ctx, contentType, rpcService, args)
+}
+
+func TestResyncWithIngestion(t *testing.T) {
+	for _, bType := range backendTypes {
+		// The other block store has the chain, the broadcasts carry the blocks of a shorter fork from
+		// genesis, so both write the height index records of the same heights
+		chain, fork := []uint64{0}, []uint64{0}
+		for i := uint64(1); i <= 50; i++ {
+			chain = append(chain, 100+i)
+		}
+		for i := uint64(1); i <= 30; i++ {
+			fork = append(fork, 200+i)
+		}
+		bt := ToBlockTree(NewMockBlockTree([][]uint64{chain, fork}))
+
+		// Block IDs only hash the header, the fork blocks get other timestamps to differ from the chain
+		previous := GetEmptyBlockID()
+		for i := uint64(201); i <= 230; i++ {
+			block := bt.ByNum[i]
+			block.Header.Previous = previous
+			block.Header.Timestamp++
+			block.Id = ComputeBlockID(block)
+			previous = block.Id
+		}
+
+		store := &RequestHandler{Backend: NewMapBackend()}
+		for i := uint64(101); i <= 150; i++ {
+			if _, err := store.AddBlock(&block_store.AddBlockRequest{BlockToAdd: bt.ByNum[i]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b := NewBackend(bType)
+		handler := &RequestHandler{Backend: b}
+		head := bt.ByNum[150]
+		client := &fetchingClient{
+			RPCClient: &deploymentClient{head: &koinos.BlockTopology{Id: head.GetId(), Height: 50, Previous: head.GetHeader().GetPrevious()}, store: store},
+			fetching:  make(chan struct{}),
+		}
+		resync := &Resync{Handler: handler, Client: client, BatchSize: 5, RetryDelay: time.Millisecond}
+
+		// The broadcasts start once the resync read the highest block, which they would raise
+		ingested := make(chan error, 1)
+		go func() {
+			<-client.fetching
+			for i := uint64(201); i <= 230; i++ {
+				resp := handler.HandleRequest(&block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_AddBlock{
+					AddBlock: &block_store.AddBlockRequest{BlockToAdd: bt.ByNum[i]},
+				}})
+				if errval, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error); ok {
+					ingested <- errors.New(errval.Error.GetMessage())
+					return
+				}
+			}
+			ingested <- nil
+		}()
+
+		result, err := resync.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = <-ingested; err != nil {
+			t.Fatal(err)
+		}
+		if result.Blocks != 50 {
+			t.Errorf("backend %d: expected 50 resynced blocks, got %d", bType, result.Blocks)
+		}
+
+		// No update of the height index was lost to the other writer
+		for height := uint64(1); height <= 50; height++ {
+			ids, err := getHeightIndex(b, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := 1
+			if height <= 30 {
+				expected = 2
+			}
+			if len(ids) != expected {
+				t.Fatalf("backend %d: expected %d blocks indexed at height %d, got %d", bType, expected, height, len(ids))
+			}
+		}
+
+		for _, tip := range []uint64{150, 230} {
+			verified, err := handler.VerifyChainLinks(context.Background(), &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[tip].GetId(), StartHeight: 1})
+			if err != nil || !verified.Valid {
+				t.Errorf("backend %d: expected valid chain ending at block %d, got %+v, %v", bType, tip, verified, err)
+			}
+		}
+
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,415 @@
+//go:build rocksdb
+// +build rocksdb
+
+package bstore
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/linxGnu/grocksdb"
+)
+
+const (
+	// RocksDBSupported is set if the block store was built with the rocksdb tag
+	RocksDBSupported = true
+
+	rocksDBDefaultBlockCacheSize = 64 * 1024 * 1024
+)
+
+// RocksDBBackend RocksDB backend implementation
+type RocksDBBackend struct {
+	DB  *grocksdb.DB
+	Dir string
+
+	opts  *grocksdb.Options
+	bbto  *grocksdb.BlockBasedTableOptions
+	cache *grocksdb.Cache
+	ro    *grocksdb.ReadOptions
+	wo    *grocksdb.WriteOptions
+
+	// lock guards DB against being swapped by Reset during a request
+	lock sync.RWMutex
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+	closed      int32
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+}
+
+// NewRocksDBBackend RocksDBBackend constructor. A blockCacheSize of 0 uses a 64 MiB block cache.
+func NewRocksDBBackend(dir string, blockCacheSize int64) (*RocksDBBackend, error) {
+	if blockCacheSize <= 0 {
+		blockCacheSize = rocksDBDefaultBlockCacheSize
+	}
+
+	backend := &RocksDBBackend{
+		Dir:   dir,
+		opts:  grocksdb.NewDefaultOptions(),
+		bbto:  grocksdb.NewDefaultBlockBasedTableOptions(),
+		cache: grocksdb.NewLRUCache(uint64(blockCacheSize)),
+		ro:    grocksdb.NewDefaultReadOptions(),
+		wo:    grocksdb.NewDefaultWriteOptions(),
+	}
+
+	backend.bbto.SetBlockCache(backend.cache)
+	backend.opts.SetBlockBasedTableFactory(backend.bbto)
+	backend.opts.SetCreateIfMissing(true)
+
+	db, err := grocksdb.OpenDb(backend.opts, dir)
+	if err != nil {
+		backend.destroyOptions()
+		return nil, err
+	}
+
+	backend.DB = db
+	return backend, nil
+}
+
+// Close cleans backend resources
+func (backend *RocksDBBackend) Close() {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&backend.closed, 0, 1) {
+		return
+	}
+
+	backend.DB.Close()
+	backend.destroyOptions()
+}
+
+func (backend *RocksDBBackend) destroyOptions() {
+	backend.ro.Destroy()
+	backend.wo.Destroy()
+	backend.opts.Destroy()
+	backend.bbto.Destroy()
+	backend.cache.Destroy()
+}
+
+// Reset resets the database
+func (backend *RocksDBBackend) Reset() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if atomic.LoadInt32(&backend.closed) != 0 {
+		return errors.New("database is closed")
+	}
+
+	backend.DB.Close()
+	if err := grocksdb.DestroyDb(backend.Dir, backend.opts); err != nil {
+		atomic.StoreInt32(&backend.closed, 1)
+		return err
+	}
+
+	db, err := grocksdb.OpenDb(backend.opts, backend.Dir)
+	if err != nil {
+		atomic.StoreInt32(&backend.closed, 1)
+		return err
+	}
+
+	backend.DB = db
+	return nil
+}
+
+// Put backend setter
+func (backend *RocksDBBackend) Put(key, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	return backend.recordWrite(backend.DB.Put(backend.wo, key, value))
+}
+
+// PutBatch stores the values in a single write batch
+func (backend *RocksDBBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	batch := grocksdb.NewWriteBatch()
+	defer batch.Destroy()
+
+	for _, pair := range pairs {
+		batch.Put(pair.Key, pair.Value)
+	}
+
+	return backend.recordWrite(backend.DB.Write(backend.wo, batch))
+}
+
+// Delete an item from the database
+func (backend *RocksDBBackend) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("cannot remove a nil key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	return backend.recordWrite(backend.DB.Delete(backend.wo, key))
+}
+
+// Get backend getter
+func (backend *RocksDBBackend) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	value, err := backend.DB.GetBytes(backend.ro, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Match the other backends, which return an empty value for a missing key
+	if value == nil {
+		value = make([]byte, 0)
+	}
+
+	return value, nil
+}
+
+// Has reports whether key is stored, without copying its value
+func (backend *RocksDBBackend) Has(key []byte) (bool, error) {
+	if key == nil {
+		return false, errors.New("cannot check a nil key")
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	value, err := backend.DB.Get(backend.ro, key)
+	if err != nil {
+		return false, err
+	}
+	defer value.Free()
+
+	return value.Exists(), nil
+}
+
+// Iterate calls fn with copies of the keys starting with prefix and their values, read from an
+// implicit snapshot
+func (backend *RocksDBBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	return rocksDBIterate(backend.DB, backend.ro, prefix, fn)
+}
+
+// Snapshot takes a RocksDB snapshot of the database, which cannot be used once the database is reset
+// or closed
+func (backend *RocksDBBackend) Snapshot() (BackendSnapshot, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if atomic.LoadInt32(&backend.closed) != 0 {
+		return nil, errors.New("database is closed")
+	}
+
+	snapshot := &rocksDBSnapshot{backend: backend, db: backend.DB, snapshot: backend.DB.NewSnapshot(), ro: grocksdb.NewDefaultReadOptions()}
+	snapshot.ro.SetSnapshot(snapshot.snapshot)
+	return snapshot, nil
+}
+
+// rocksDBSnapshot reads with read options pinned to a RocksDB snapshot
+type rocksDBSnapshot struct {
+	backend  *RocksDBBackend
+	db       *grocksdb.DB
+	snapshot *grocksdb.Snapshot
+	ro       *grocksdb.ReadOptions
+}
+
+// valid returns true while the database of the snapshot is open, the lock of the backend must be held
+func (snapshot *rocksDBSnapshot) valid() bool {
+	return snapshot.backend.DB == snapshot.db && atomic.LoadInt32(&snapshot.backend.closed) == 0
+}
+
+func (snapshot *rocksDBSnapshot) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	snapshot.backend.lock.RLock()
+	defer snapshot.backend.lock.RUnlock()
+
+	if !snapshot.valid() {
+		return nil, errors.New("database was reset or closed after the snapshot")
+	}
+
+	value, err := snapshot.db.GetBytes(snapshot.ro, key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = make([]byte, 0)
+	}
+
+	return value, nil
+}
+
+func (snapshot *rocksDBSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	snapshot.backend.lock.RLock()
+	defer snapshot.backend.lock.RUnlock()
+
+	if !snapshot.valid() {
+		return errors.New("database was reset or closed after the snapshot")
+	}
+
+	return rocksDBIterate(snapshot.db, snapshot.ro, prefix, fn)
+}
+
+func (snapshot *rocksDBSnapshot) Release() {
+	snapshot.backend.lock.RLock()
+	defer snapshot.backend.lock.RUnlock()
+
+	if snapshot.valid() {
+		snapshot.db.ReleaseSnapshot(snapshot.snapshot)
+	}
+	snapshot.ro.Destroy()
+}
+
+// rocksDBIterate calls fn with the keys of db starting with prefix and their values, read with ro
+func rocksDBIterate(db *grocksdb.DB, ro *grocksdb.ReadOptions, prefix []byte, fn func(key []byte, value []byte) error) error {
+	iter := db.NewIterator(ro)
+	defer iter.Close()
+
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		key := iter.Key()
+		value := iter.Value()
+		err := fn(append([]byte{}, key.Data()...), append([]byte{}, value.Data()...))
+		key.Free()
+		value.Free()
+		if err != nil {
+			return stopIteration(err)
+		}
+	}
+
+	return iter.Err()
+}
+
+// Flush writes the memtables to SST files and waits for the flush to complete
+func (backend *RocksDBBackend) Flush() error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if atomic.LoadInt32(&backend.closed) != 0 {
+		return errors.New("database is closed")
+	}
+
+	fo := grocksdb.NewDefaultFlushOptions()
+	defer fo.Destroy()
+	fo.SetWait(true)
+
+	return backend.DB.Flush(fo)
+}
+
+// Begin begins a transaction batching its writes, the database is not opened as a
+// TransactionDB and cannot detect conflicts
+func (backend *RocksDBBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *RocksDBBackend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// Compact compacts the full key range. RocksDB has no value log, only the LSM sizes are reported.
+func (backend *RocksDBBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	result := &CompactionResult{LSMSizeBefore: backend.sstSize()}
+	backend.DB.CompactRange(grocksdb.Range{})
+	result.LSMSizeAfter = backend.sstSize()
+
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return result, nil
+}
+
+// Backup writes a backup stream of the records of a snapshot to w, incremental backups are not supported
+func (backend *RocksDBBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *RocksDBBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+func (backend *RocksDBBackend) sstSize() int64 {
+	size, _ := backend.DB.GetIntProperty("rocksdb.total-sst-files-size")
+	return int64(size)
+}
+
+// Stats reports the number of keys estimated by RocksDB and the size of the SST files
+func (backend *RocksDBBackend) Stats() (*BackendStats, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	if atomic.LoadInt32(&backend.closed) != 0 {
+		return nil, errors.New("database is closed")
+	}
+
+	stats := &BackendStats{DiskSize: backend.sstSize(), LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}
+	if keys, ok := backend.DB.GetIntProperty("rocksdb.estimate-num-keys"); ok {
+		stats.Keys = &keys
+	}
+
+	return stats, nil
+}
+
+// Health reports whether the database accepts writes, when it was last written, whether compactions
+// are pending and the free space on the database volume
+func (backend *RocksDBBackend) Health() (*BackendHealth, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	closed := atomic.LoadInt32(&backend.closed) != 0
+	health := &BackendHealth{
+		Writable: !closed && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	if !closed {
+		if pending, ok := backend.DB.GetIntProperty("rocksdb.compaction-pending"); ok {
+			health.PendingCompactions = int(pending)
+		}
+	}
+
+	free, err := diskFree(backend.Dir)
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
@@ -0,0 +1,92 @@
+//go:build !rocksdb
+// +build !rocksdb
+
+package bstore
+
+import (
+	"errors"
+	"io"
+)
+
+// RocksDBSupported is set if the block store was built with the rocksdb tag
+const RocksDBSupported = false
+
+var errRocksDBUnsupported = errors.New("block store was built without RocksDB support, rebuild with -tags rocksdb")
+
+// RocksDBBackend is unavailable without the rocksdb build tag, NewRocksDBBackend always fails
+type RocksDBBackend struct {
+}
+
+// NewRocksDBBackend returns an error, the block store was built without the rocksdb tag
+func NewRocksDBBackend(dir string, blockCacheSize int64) (*RocksDBBackend, error) {
+	return nil, errRocksDBUnsupported
+}
+
+// Close does nothing
+func (backend *RocksDBBackend) Close() {
+}
+
+// Reset returns an error
+func (backend *RocksDBBackend) Reset() error {
+	return errRocksDBUnsupported
+}
+
+// Put returns an error
+func (backend *RocksDBBackend) Put(key, value []byte) error {
+	return errRocksDBUnsupported
+}
+
+// PutBatch returns an error
+func (backend *RocksDBBackend) PutBatch(pairs []*KeyValue) error {
+	return errRocksDBUnsupported
+}
+
+// Delete returns an error
+func (backend *RocksDBBackend) Delete(key []byte) error {
+	return errRocksDBUnsupported
+}
+
+// Get returns an error
+func (backend *RocksDBBackend) Get(key []byte) ([]byte, error) {
+	return nil, errRocksDBUnsupported
+}
+
+// Has returns an error
+func (backend *RocksDBBackend) Has(key []byte) (bool, error) {
+	return false, errRocksDBUnsupported
+}
+
+// Iterate returns an error
+func (backend *RocksDBBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return errRocksDBUnsupported
+}
+
+// Snapshot returns an error
+func (backend *RocksDBBackend) Snapshot() (BackendSnapshot, error) {
+	return nil, errRocksDBUnsupported
+}
+
+// Flush returns an error
+func (backend *RocksDBBackend) Flush() error {
+	return errRocksDBUnsupported
+}
+
+// Compact returns an error
+func (backend *RocksDBBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return nil, errRocksDBUnsupported
+}
+
+// Backup returns an error
+func (backend *RocksDBBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return 0, errRocksDBUnsupported
+}
+
+// Load returns an error
+func (backend *RocksDBBackend) Load(r io.Reader) error {
+	return errRocksDBUnsupported
+}
+
+// Begin returns an error
+func (backend *RocksDBBackend) Begin() (BackendTxn, error) {
+	return nil, errRocksDBUnsupported
+}
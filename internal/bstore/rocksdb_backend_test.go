@@ -0,0 +1,66 @@
+//go:build rocksdb
+// +build rocksdb
+
+package bstore
+
+import (
+	"os"
+	"testing"
+)
+
+const (
+	RocksDBBackendType = 2
+)
+
+func init() {
+	backendTypes = append(backendTypes, RocksDBBackendType)
+	taggedBackends[RocksDBBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewRocksDBBackend(dirname, 0)
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestRocksDBBackend(t *testing.T) {
+	backend := NewBackend(RocksDBBackendType).(*RocksDBBackend)
+	defer CloseBackend(backend)
+
+	if value, err := backend.Get([]byte{1}); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected empty value for missing key, got %v, %v", value, err)
+	}
+
+	handler := RequestHandler{Backend: backend}
+	buildLinearChain(t, &handler, 10)
+
+	compacted, err := handler.CompactStore(&CompactStoreRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.LSMSizeAfter <= 0 {
+		t.Error("expected non-zero database size after compaction")
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Writable || health.LastWrite == nil || health.DiskFreeBytes == nil {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	if err = backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := backend.Get([]byte{highestBlockKey}); len(value) != 0 {
+		t.Error("expected reset to remove all keys")
+	}
+	if err = backend.Put([]byte{1}, []byte{2}); err != nil {
+		t.Errorf("expected database to be writable after reset, %s", err)
+	}
+}
@@ -0,0 +1,351 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	rpcExchangeName = "koinos.rpc"
+	rpcQueuePrefix  = "koinos.rpc."
+
+	rpcConnectTimeout      = time.Second
+	rpcReconnectMinDelay   = time.Second
+	rpcReconnectMaxDelay   = 25 * time.Second
+	rpcReconnectDelayRetry = 2 * time.Second
+	rpcReplyTimeout        = 5 * time.Second
+)
+
+// RPCRequest is a request received on an RPC queue of the AMQP server
+type RPCRequest struct {
+	// Type is the RPC the request was sent to
+	Type string
+	Data []byte
+
+	// ReplyTo is the queue the response is sent to. The koinos-mq clients have the AMQP server name
+	// an exclusive queue for their connection, which tells the clients apart.
+	ReplyTo       string
+	CorrelationID string
+}
+
+// rpcServerDelivery is a request received on the queue of an RPC, with the generation of the channel
+// it was received on
+type rpcServerDelivery struct {
+	rpcType    string
+	delivery   amqp.Delivery
+	generation uint64
+}
+
+// rpcChannel is the part of an AMQP channel used by RPCServer, it is replaced in tests
+type rpcChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// connChannel is a channel on a connection of its own, closing it closes the connection
+type connChannel struct {
+	*amqp.Channel
+	conn *amqp.Connection
+}
+
+func (c *connChannel) Close() error {
+	return c.conn.Close()
+}
+
+// dialRPCChannel connects to the AMQP server at addr and opens a channel
+func dialRPCChannel(addr string) (rpcChannel, error) {
+	conn, err := amqp.DialConfig(addr, amqp.Config{Dial: amqp.DefaultDial(rpcConnectTimeout)})
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &connChannel{Channel: channel, conn: conn}, nil
+}
+
+// RPCServerFunc serves an RPC request, returning the response
+type RPCServerFunc func(req *RPCRequest) ([]byte, error)
+
+// RPCServer serves the RPC requests of the AMQP server like the koinos-mq request handler, which
+// only passes the message body to its handlers. RPCServer passes the reply-to queue and correlation
+// ID of the request as well, so the requests of a client can be told apart from those of others.
+//
+// Unlike the koinos-mq handler, requests are acknowledged once their response is sent, and the AMQP
+// server hands each RPC queue consumer at most as many unacknowledged requests as there are jobs. A
+// request whose response cannot be sent, or which was received on a connection since lost, is left
+// to the AMQP server to deliver again, to this or another block store.
+type RPCServer struct {
+	Address string
+
+	handlers     map[string]RPCServerFunc
+	numConsumers uint
+	deliveries   chan *rpcServerDelivery
+
+	mu         sync.Mutex
+	channel    rpcChannel
+	generation uint64
+
+	// dial opens a channel to the AMQP server, it is replaced in tests
+	dial func(addr string) (rpcChannel, error)
+}
+
+// NewRPCServer returns an RPCServer which serves the requests of the AMQP server at addr with
+// consumers jobs
+func NewRPCServer(addr string, consumers uint) *RPCServer {
+	return &RPCServer{
+		Address:      addr,
+		handlers:     make(map[string]RPCServerFunc),
+		numConsumers: consumers,
+		deliveries:   make(chan *rpcServerDelivery, consumers),
+		dial:         dialRPCChannel,
+	}
+}
+
+// SetRPCHandler sets the handler of an RPC, before the server is started
+func (s *RPCServer) SetRPCHandler(rpcType string, handler RPCServerFunc) {
+	s.handlers[rpcType] = handler
+}
+
+// Start connects to the AMQP server and serves the requests until ctx is done, reconnecting when the
+// connection is lost. The returned channel is closed once the server is first connected.
+func (s *RPCServer) Start(ctx context.Context) <-chan struct{} {
+	connected := make(chan struct{})
+
+	for i := uint(0); i < s.numConsumers; i++ {
+		go s.serveLoop(ctx)
+	}
+	go s.connectLoop(ctx, connected)
+
+	return connected
+}
+
+func (s *RPCServer) connectLoop(ctx context.Context, connected chan struct{}) {
+	for {
+		log.Infof("Connecting RPC server to AMQP server %v", s.Address)
+
+		var channel rpcChannel
+		var closed chan *amqp.Error
+		for retry := 0; ; retry++ {
+			var err error
+			channel, closed, err = s.connect(ctx)
+			if err == nil {
+				break
+			}
+			log.Warnf("Unable to connect RPC server to AMQP server, %s", err)
+
+			delay := rpcReconnectMinDelay + rpcReconnectDelayRetry*time.Duration(retry)
+			if delay > rpcReconnectMaxDelay {
+				delay = rpcReconnectMaxDelay
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+		log.Infof("RPC server connected")
+
+		if connected != nil {
+			close(connected)
+			connected = nil
+		}
+
+		select {
+		case <-closed:
+		case <-ctx.Done():
+		}
+
+		s.mu.Lock()
+		s.channel = nil
+		s.mu.Unlock()
+		_ = channel.Close()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// connect opens a channel and starts consuming the queues of the RPC handlers
+func (s *RPCServer) connect(ctx context.Context) (rpcChannel, chan *amqp.Error, error) {
+	channel, err := s.dial(s.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	err = channel.Qos(int(s.numConsumers), 0, false)
+	if err == nil {
+		err = channel.ExchangeDeclare(rpcExchangeName, "direct", true, false, false, false, nil)
+	}
+
+	consumers := make(map[string]<-chan amqp.Delivery)
+	for rpcType := range s.handlers {
+		if err != nil {
+			break
+		}
+		consumers[rpcType], err = consumeRPCQueue(channel, rpcType)
+	}
+	if err != nil {
+		_ = channel.Close()
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	s.channel = channel
+	s.generation++
+	generation := s.generation
+	s.mu.Unlock()
+
+	for rpcType, consumer := range consumers {
+		go s.consumeLoop(ctx, generation, rpcType, consumer)
+	}
+
+	return channel, closed, nil
+}
+
+// consumeRPCQueue declares the queue of an RPC, which the block stores of the deployment compete
+// for, and consumes it. The requests are acknowledged by serve.
+func consumeRPCQueue(channel rpcChannel, rpcType string) (<-chan amqp.Delivery, error) {
+	queue, err := channel.QueueDeclare(rpcQueuePrefix+rpcType, true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = channel.QueueBind(queue.Name, queue.Name, rpcExchangeName, false, nil); err != nil {
+		return nil, err
+	}
+
+	return channel.Consume(queue.Name, "", false, false, false, false, nil)
+}
+
+func (s *RPCServer) consumeLoop(ctx context.Context, generation uint64, rpcType string, consumer <-chan amqp.Delivery) {
+	for {
+		select {
+		case delivery, ok := <-consumer:
+			if !ok {
+				return
+			}
+
+			select {
+			case s.deliveries <- &rpcServerDelivery{rpcType: rpcType, delivery: delivery, generation: generation}:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *RPCServer) serveLoop(ctx context.Context) {
+	for {
+		select {
+		case d := <-s.deliveries:
+			s.serve(ctx, d)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serve handles a request, sends its response and acknowledges it. Requests which cannot be answered,
+// as they have no reply-to queue or their handler fails, are acknowledged and dropped.
+func (s *RPCServer) serve(ctx context.Context, d *rpcServerDelivery) {
+	delivery := &d.delivery
+
+	// The AMQP server delivers the requests of a lost channel again, they cannot be acknowledged
+	if !s.isCurrent(d.generation) {
+		log.Debugf("Skipped RPC request '%v' received before the AMQP connection was lost", d.rpcType)
+		return
+	}
+
+	handler, ok := s.handlers[d.rpcType]
+	if !ok {
+		log.Errorf("Could not find handler for RPC '%v'", d.rpcType)
+		acknowledge(delivery)
+		return
+	}
+
+	if len(delivery.ReplyTo) == 0 {
+		log.Debugf("Dropped RPC request '%v' without a reply-to queue", d.rpcType)
+		acknowledge(delivery)
+		return
+	}
+
+	output, err := handler(&RPCRequest{Type: d.rpcType, Data: delivery.Body, ReplyTo: delivery.ReplyTo, CorrelationID: delivery.CorrelationId})
+	if err != nil {
+		log.Errorf("Error in RPC handler, %v", err)
+		acknowledge(delivery)
+		return
+	}
+
+	replyCtx, cancel := context.WithTimeout(ctx, rpcReplyTimeout)
+	err = s.publishResponse(replyCtx, d.generation, delivery, output)
+	cancel()
+	if err != nil {
+		log.Warnf("Unable to send RPC response to '%v', requeueing request, %s", delivery.ReplyTo, err)
+		if err = delivery.Nack(false, true); err != nil {
+			log.Debugf("Unable to requeue RPC request, %s", err)
+		}
+		return
+	}
+
+	acknowledge(delivery)
+}
+
+// acknowledge acknowledges a request, which the AMQP server then removes from its queue
+func acknowledge(delivery *amqp.Delivery) {
+	if err := delivery.Ack(false); err != nil {
+		log.Debugf("Unable to acknowledge RPC request, %s", err)
+	}
+}
+
+// isCurrent returns whether the channel of generation is still open
+func (s *RPCServer) isCurrent(generation uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.channel != nil && s.generation == generation
+}
+
+// publishResponse sends the response to a request to its reply-to queue, on the channel the request
+// was received on
+func (s *RPCServer) publishResponse(ctx context.Context, generation uint64, delivery *amqp.Delivery, output []byte) error {
+	s.mu.Lock()
+	channel := s.channel
+	if s.generation != generation {
+		channel = nil
+	}
+	s.mu.Unlock()
+
+	if channel == nil {
+		return errors.New("AMQP connection was lost")
+	}
+
+	return channel.PublishWithContext(ctx, rpcExchangeName, delivery.ReplyTo, false, false, amqp.Publishing{
+		DeliveryMode:  amqp.Transient,
+		Timestamp:     time.Now(),
+		ContentType:   delivery.ContentType,
+		CorrelationId: delivery.CorrelationId,
+		Body:          output,
+	})
+}
@@ -0,0 +1,272 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeRPCChannel is an rpcChannel which serves the requests given by the test and records the
+// responses published
+type fakeRPCChannel struct {
+	mu         sync.Mutex
+	qos        int
+	consumer   chan amqp.Delivery
+	notify     chan *amqp.Error
+	closed     bool
+	publishErr error
+
+	published chan amqp.Publishing
+}
+
+func newFakeRPCChannel() *fakeRPCChannel {
+	return &fakeRPCChannel{consumer: make(chan amqp.Delivery, 10), published: make(chan amqp.Publishing, 10)}
+}
+
+func (c *fakeRPCChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.qos = prefetchCount
+	return nil
+}
+
+func (c *fakeRPCChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (c *fakeRPCChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeRPCChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (c *fakeRPCChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if queue != rpcQueuePrefix+"block_store" || autoAck {
+		return nil, fmt.Errorf("unexpected consume of %v with autoAck %v", queue, autoAck)
+	}
+	return c.consumer, nil
+}
+
+func (c *fakeRPCChannel) NotifyClose(notify chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = notify
+	return notify
+}
+
+func (c *fakeRPCChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	if key != "amq.gen-indexer" {
+		return fmt.Errorf("unexpected reply-to queue %v", key)
+	}
+	c.published <- msg
+	return nil
+}
+
+func (c *fakeRPCChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.consumer)
+	}
+	return nil
+}
+
+// lose closes the channel as the AMQP server does when the connection is lost
+func (c *fakeRPCChannel) lose() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify <- amqp.ErrClosed
+}
+
+// fakeAcknowledger records the acknowledgements of the requests
+type fakeAcknowledger struct {
+	results chan string
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.results <- fmt.Sprintf("ack %d", tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.results <- fmt.Sprintf("nack %d requeue=%v", tag, requeue)
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.results <- fmt.Sprintf("reject %d requeue=%v", tag, requeue)
+	return nil
+}
+
+func receive[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	var v T
+	return v
+}
+
+func TestRPCServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	channel := newFakeRPCChannel()
+	server := NewRPCServer("", 1)
+	server.dial = func(addr string) (rpcChannel, error) {
+		return channel, nil
+	}
+
+	received := make(chan *RPCRequest, 10)
+	server.SetRPCHandler("block_store", func(req *RPCRequest) ([]byte, error) {
+		received <- req
+		if string(req.Data) == "fail" {
+			return nil, errors.New("failed")
+		}
+		return []byte("response"), nil
+	})
+	<-server.Start(ctx)
+
+	if channel.qos != 1 {
+		t.Errorf("expected prefetch of 1 request, got %d", channel.qos)
+	}
+
+	acks := &fakeAcknowledger{results: make(chan string, 10)}
+	deliver := func(tag uint64, body string, replyTo string) {
+		channel.consumer <- amqp.Delivery{Acknowledger: acks, DeliveryTag: tag, Body: []byte(body), ReplyTo: replyTo, CorrelationId: fmt.Sprint(tag)}
+	}
+
+	// The handler is given the queue the response is sent to, which tells the clients apart, and the
+	// request is acknowledged once the response is sent
+	deliver(1, "request", "amq.gen-indexer")
+	req := receive(t, received)
+	if req.ReplyTo != "amq.gen-indexer" || req.CorrelationID != "1" || string(req.Data) != "request" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if msg := receive(t, channel.published); msg.CorrelationId != "1" || string(msg.Body) != "response" {
+		t.Errorf("unexpected response %+v", msg)
+	}
+	if result := receive(t, acks.results); result != "ack 1" {
+		t.Errorf("expected request to be acknowledged, got %v", result)
+	}
+
+	// A request without a reply-to queue cannot be answered, nor counted against its client
+	deliver(2, "request", "")
+	if result := receive(t, acks.results); result != "ack 2" {
+		t.Errorf("expected request to be dropped, got %v", result)
+	}
+
+	// A failed request is dropped
+	deliver(3, "fail", "amq.gen-indexer")
+	receive(t, received)
+	if result := receive(t, acks.results); result != "ack 3" {
+		t.Errorf("expected failed request to be dropped, got %v", result)
+	}
+
+	// A request whose response cannot be sent is requeued
+	channel.mu.Lock()
+	channel.publishErr = errors.New("publish failed")
+	channel.mu.Unlock()
+	deliver(4, "request", "amq.gen-indexer")
+	receive(t, received)
+	if result := receive(t, acks.results); result != "nack 4 requeue=true" {
+		t.Errorf("expected request to be requeued, got %v", result)
+	}
+
+	if len(received) != 0 || len(channel.published) != 0 {
+		t.Errorf("expected 4 requests and 1 response, got %d more requests and %d more responses", len(received), len(channel.published))
+	}
+}
+
+func TestRPCServerReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first connection attempt fails, then the connection is lost once
+	first, second := newFakeRPCChannel(), newFakeRPCChannel()
+	dials := make(chan rpcChannel, 3)
+	dials <- nil
+	dials <- first
+	dials <- second
+
+	server := NewRPCServer("", 1)
+	server.dial = func(addr string) (rpcChannel, error) {
+		channel := <-dials
+		if channel == nil {
+			return nil, errors.New("connection refused")
+		}
+		return channel, nil
+	}
+
+	received := make(chan string, 10)
+	release := make(chan struct{})
+	server.SetRPCHandler("block_store", func(req *RPCRequest) ([]byte, error) {
+		received <- string(req.Data)
+		if string(req.Data) == "slow" {
+			<-release
+		}
+		return []byte("response"), nil
+	})
+	<-server.Start(ctx)
+
+	acks := &fakeAcknowledger{results: make(chan string, 10)}
+	deliver := func(channel *fakeRPCChannel, tag uint64, body string) {
+		channel.consumer <- amqp.Delivery{Acknowledger: acks, DeliveryTag: tag, Body: []byte(body), ReplyTo: "amq.gen-indexer"}
+	}
+
+	// The connection is lost while a request is served and another is waiting
+	deliver(first, 1, "slow")
+	if body := receive(t, received); body != "slow" {
+		t.Fatalf("expected slow request, got %v", body)
+	}
+	deliver(first, 2, "waiting")
+	first.lose()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.isCurrent(2) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out reconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+
+	// The response of the request served during the reconnect cannot be sent on the new channel
+	if result := receive(t, acks.results); result != "nack 1 requeue=true" {
+		t.Errorf("expected request to be requeued, got %v", result)
+	}
+
+	// The requests of the new channel are served, while the waiting request is left to be delivered
+	// again by the AMQP server
+	deliver(second, 3, "request")
+	if body := receive(t, received); body != "request" {
+		t.Errorf("expected request of the new channel, got %v", body)
+	}
+	receive(t, second.published)
+	if result := receive(t, acks.results); result != "ack 3" {
+		t.Errorf("expected request to be acknowledged, got %v", result)
+	}
+
+	if len(received) != 0 || len(acks.results) != 0 || len(first.published) != 0 {
+		t.Errorf("expected the waiting request to be skipped")
+	}
+	if !first.closed || second.closed || second.qos != 1 {
+		t.Errorf("expected lost channel to be closed and new channel to be set up")
+	}
+}
@@ -0,0 +1,209 @@
+package bstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	protoModulePath = "github.com/koinos/koinos-proto-golang/v2"
+
+	// NewerSchemaVersion is recorded for messages containing fields unknown to this binary's schema
+	NewerSchemaVersion = "newer"
+)
+
+// ProducerPolicy determines how messages from producers with a newer schema are treated
+type ProducerPolicy string
+
+// Producer policies
+const (
+	ProducerPolicyAccept ProducerPolicy = "accept"
+	ProducerPolicyWarn   ProducerPolicy = "warn"
+	ProducerPolicyReject ProducerPolicy = "reject"
+)
+
+// ParseProducerPolicy parses a producer policy option value
+func ParseProducerPolicy(s string) (ProducerPolicy, error) {
+	switch p := ProducerPolicy(s); p {
+	case ProducerPolicyAccept, ProducerPolicyWarn, ProducerPolicyReject:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown producer policy '%s'", s)
+	}
+}
+
+// SchemaVersion is the version of the koinos-proto module this binary was built with
+var SchemaVersion = protoModuleVersion()
+
+func protoModuleVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == protoModulePath {
+				return dep.Version
+			}
+		}
+	}
+
+	return "unknown"
+}
+
+// IncompatibleSchemaError is returned for messages produced with a newer, incompatible schema
+type IncompatibleSchemaError struct {
+}
+
+func (e *IncompatibleSchemaError) Error() string {
+	return fmt.Sprintf("Message contains fields unknown to schema %s", SchemaVersion)
+}
+
+// Code returns the error code
+func (e *IncompatibleSchemaError) Code() ErrorCode {
+	return ErrorCodeSchema
+}
+
+// Details returns the schema version of this binary
+func (e *IncompatibleSchemaError) Details() map[string]interface{} {
+	return map[string]interface{}{"schema_version": SchemaVersion}
+}
+
+// HasUnknownFields reports whether the message, or any message nested within it, contains fields
+// which are not part of this binary's schema
+func HasUnknownFields(m protoreflect.Message) bool {
+	if len(m.GetUnknown()) > 0 {
+		return true
+	}
+
+	found := false
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind {
+			return true
+		}
+
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len() && !found; i++ {
+				found = HasUnknownFields(list.Get(i).Message())
+			}
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.MessageKind {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					found = HasUnknownFields(mv.Message())
+					return !found
+				})
+			}
+		default:
+			found = HasUnknownFields(v.Message())
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// SchemaTracker counts incoming messages by source and producer schema version
+type SchemaTracker struct {
+	lock   sync.Mutex
+	counts map[string]map[string]uint64
+}
+
+// NewSchemaTracker creates an empty schema tracker
+func NewSchemaTracker() *SchemaTracker {
+	return &SchemaTracker{counts: make(map[string]map[string]uint64)}
+}
+
+// Record counts a message received from source and returns the schema version it was attributed to
+func (t *SchemaTracker) Record(source string, msg proto.Message) string {
+	version := SchemaVersion
+	if HasUnknownFields(msg.ProtoReflect()) {
+		version = NewerSchemaVersion
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.counts[source]; !ok {
+		t.counts[source] = make(map[string]uint64)
+	}
+	t.counts[source][version]++
+
+	return version
+}
+
+// Counts returns a copy of the message counts by source and schema version
+func (t *SchemaTracker) Counts() map[string]map[string]uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	counts := make(map[string]map[string]uint64)
+	for source, versions := range t.counts {
+		counts[source] = make(map[string]uint64)
+		for version, count := range versions {
+			counts[source][version] = count
+		}
+	}
+
+	return counts
+}
+
+// Save persists the message counts to the backend
+func (t *SchemaTracker) Save(backend BlockStoreBackend) error {
+	value, err := json.Marshal(t.Counts())
+	if err != nil {
+		return err
+	}
+
+	return backend.Put([]byte{schemaCountsKey}, value)
+}
+
+// Load restores message counts previously persisted to the backend
+func (t *SchemaTracker) Load(backend BlockStoreBackend) error {
+	value, err := backend.Get([]byte{schemaCountsKey})
+	if err != nil {
+		return err
+	}
+
+	if len(value) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]map[string]uint64)
+	if err = json.Unmarshal(value, &counts); err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.counts = counts
+	return nil
+}
+
+// GetMessageSchemaStatsRequest asks for counts of received messages by producer schema version
+type GetMessageSchemaStatsRequest struct {
+}
+
+// GetMessageSchemaStatsResponse contains received message counts by source and producer schema version
+type GetMessageSchemaStatsResponse struct {
+	SchemaVersion string                       `json:"schema_version"`
+	Counts        map[string]map[string]uint64 `json:"counts"`
+}
+
+// GetMessageSchemaStats returns counts of received messages by producer schema version
+func (handler *RequestHandler) GetMessageSchemaStats(req *GetMessageSchemaStatsRequest) (*GetMessageSchemaStatsResponse, error) {
+	resp := &GetMessageSchemaStatsResponse{
+		SchemaVersion: SchemaVersion,
+		Counts:        make(map[string]map[string]uint64),
+	}
+
+	if handler.SchemaTracker != nil {
+		resp.Counts = handler.SchemaTracker.Counts()
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,76 @@
+package bstore
+
+import (
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/protocol"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHasUnknownFields(t *testing.T) {
+	block := &protocol.Block{Id: GetEmptyBlockID(), Header: &protocol.BlockHeader{Height: 1}}
+	req := &block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_AddBlock{AddBlock: &block_store.AddBlockRequest{BlockToAdd: block}},
+	}
+
+	if HasUnknownFields(req.ProtoReflect()) {
+		t.Error("unexpected unknown fields")
+	}
+
+	// Simulate a field added to the block header by a newer schema
+	unknown := protowire.AppendTag(nil, 100, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+	block.Header.ProtoReflect().SetUnknown(unknown)
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := &block_store.BlockStoreRequest{}
+	if err = proto.Unmarshal(data, parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if !HasUnknownFields(parsed.ProtoReflect()) {
+		t.Error("expected nested unknown fields to be detected")
+	}
+
+	block.Header.ProtoReflect().SetUnknown(nil)
+
+	tracker := NewSchemaTracker()
+	if v := tracker.Record("rpc", parsed); v != NewerSchemaVersion {
+		t.Errorf("expected %s, got %s", NewerSchemaVersion, v)
+	}
+	if v := tracker.Record("rpc", req); v != SchemaVersion {
+		t.Errorf("expected %s, got %s", SchemaVersion, v)
+	}
+	tracker.Record("rpc", req)
+
+	b := NewMapBackend()
+	if err = tracker.Save(b); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewSchemaTracker()
+	if err = loaded.Load(b); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RequestHandler{Backend: b, SchemaTracker: loaded}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetMessageSchemaStats: &GetMessageSchemaStatsRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+
+	counts := resp.GetMessageSchemaStats.Counts["rpc"]
+	if counts[NewerSchemaVersion] != 1 || counts[SchemaVersion] != 2 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	if _, err = ParseProducerPolicy("ignore"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
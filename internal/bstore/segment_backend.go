@@ -0,0 +1,439 @@
+package bstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// DefaultSegmentSize is the size at which a new segment file is started
+	DefaultSegmentSize = 128 << 20
+
+	// segmentMinValueSize is the smallest value written to a segment, smaller values such as metadata
+	// and index records are kept in the index
+	segmentMinValueSize = 256
+
+	segmentIndexDir     = "index"
+	segmentFilePattern  = "blk%05d.dat"
+	segmentHeaderLength = 8
+
+	segmentInline  = 0x00
+	segmentPointer = 0x01
+)
+
+// SegmentBackend appends large values, such as block records, to sequential segment files and keeps
+// their locations in a Badger index, along with the small values which are stored inline. Block
+// records are written once and rarely deleted, so appending them avoids the write amplification of
+// an LSM tree. Overwritten and deleted values are not reclaimed from the segments.
+//
+// Each value in a segment is preceded by its length and CRC-32 checksum, which are verified on read.
+type SegmentBackend struct {
+	Index *BadgerBackend
+	Dir   string
+
+	// SegmentSize is the size at which a new segment file is started
+	SegmentSize int64
+
+	lock     sync.RWMutex
+	files    map[uint64]*os.File
+	current  uint64
+	size     int64
+	appended *os.File
+}
+
+// NewSegmentBackend opens the segments in dir and their index, creating them if missing. A
+// segmentSize of 0 uses DefaultSegmentSize.
+func NewSegmentBackend(dir string, segmentSize int64, opts badger.Options) (*SegmentBackend, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	opts.Dir = filepath.Join(dir, segmentIndexDir)
+	opts.ValueDir = opts.Dir
+	index, err := NewBadgerBackend(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &SegmentBackend{Index: index, Dir: dir, SegmentSize: segmentSize, files: make(map[uint64]*os.File)}
+	if err = backend.openLastSegment(); err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func (backend *SegmentBackend) segmentPath(segment uint64) string {
+	return filepath.Join(backend.Dir, fmt.Sprintf(segmentFilePattern, segment))
+}
+
+// segments returns the numbers of the segment files in ascending order
+func (backend *SegmentBackend) segments() ([]uint64, error) {
+	matches, err := filepath.Glob(filepath.Join(backend.Dir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, match := range matches {
+		var segment uint64
+		if _, err := fmt.Sscanf(filepath.Base(match), segmentFilePattern, &segment); err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return segments, nil
+}
+
+// openLastSegment opens the segment new values are appended to
+func (backend *SegmentBackend) openLastSegment() error {
+	segments, err := backend.segments()
+	if err != nil {
+		return err
+	}
+
+	backend.current = 0
+	if len(segments) > 0 {
+		backend.current = segments[len(segments)-1]
+	}
+
+	return backend.openSegment(backend.current)
+}
+
+// openSegment opens a segment for appending, the caller must hold the write lock
+func (backend *SegmentBackend) openSegment(segment uint64) error {
+	file, err := os.OpenFile(backend.segmentPath(segment), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	backend.current = segment
+	backend.size = info.Size()
+	backend.appended = file
+	backend.files[segment] = file
+	return nil
+}
+
+// Close cleans backend resources
+func (backend *SegmentBackend) Close() {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.closeFiles()
+	backend.Index.Close()
+}
+
+func (backend *SegmentBackend) closeFiles() {
+	for segment, file := range backend.files {
+		_ = file.Close()
+		delete(backend.files, segment)
+	}
+	backend.appended = nil
+}
+
+// Reset removes all segments and resets the index
+func (backend *SegmentBackend) Reset() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if err := backend.Index.Reset(); err != nil {
+		return err
+	}
+
+	segments, err := backend.segments()
+	if err != nil {
+		return err
+	}
+
+	backend.closeFiles()
+	for _, segment := range segments {
+		if err = os.Remove(backend.segmentPath(segment)); err != nil {
+			return err
+		}
+	}
+
+	return backend.openSegment(0)
+}
+
+// Put appends large values to the current segment and records their location in the index. Small
+// values are stored in the index.
+func (backend *SegmentBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	if len(value) < segmentMinValueSize {
+		return backend.Index.Put(key, append([]byte{segmentInline}, value...))
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	pointer, err := backend.appendValue(value)
+	if err != nil {
+		return err
+	}
+
+	return backend.Index.Put(key, pointer)
+}
+
+// PutBatch appends the large values to the current segment, then records them all in the index in a
+// single transaction
+func (backend *SegmentBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	entries := make([]*KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		entry := append([]byte{segmentInline}, pair.Value...)
+		if len(pair.Value) >= segmentMinValueSize {
+			var err error
+			if entry, err = backend.appendValue(pair.Value); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, &KeyValue{Key: pair.Key, Value: entry})
+	}
+
+	return backend.Index.PutBatch(entries)
+}
+
+// appendValue appends a value to the current segment and returns its pointer. The caller must hold
+// the lock.
+func (backend *SegmentBackend) appendValue(value []byte) ([]byte, error) {
+	if backend.size > 0 && backend.size+segmentHeaderLength+int64(len(value)) > backend.SegmentSize {
+		if err := backend.openSegment(backend.current + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	record := make([]byte, segmentHeaderLength, segmentHeaderLength+len(value))
+	binary.BigEndian.PutUint32(record, uint32(len(value)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(value))
+	record = append(record, value...)
+
+	offset := backend.size
+	if _, err := backend.appended.WriteAt(record, offset); err != nil {
+		return nil, err
+	}
+	backend.size += int64(len(record))
+
+	pointer := []byte{segmentPointer}
+	pointer = protowire.AppendVarint(pointer, backend.current)
+	pointer = protowire.AppendVarint(pointer, uint64(offset))
+
+	return pointer, nil
+}
+
+// Delete removes a value from the index, its segment space is not reclaimed
+func (backend *SegmentBackend) Delete(key []byte) error {
+	return backend.Index.Delete(key)
+}
+
+// Get reads a value from the index, or from its segment
+func (backend *SegmentBackend) Get(key []byte) ([]byte, error) {
+	entry, err := backend.Index.Get(key)
+	if err != nil || len(entry) == 0 {
+		return entry, err
+	}
+
+	return backend.value(entry)
+}
+
+// Has reports whether key is stored, from the index without reading the segments
+func (backend *SegmentBackend) Has(key []byte) (bool, error) {
+	return backend.Index.Has(key)
+}
+
+// Iterate calls fn with the keys of the index starting with prefix and their values
+func (backend *SegmentBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Index.Iterate(prefix, func(key []byte, entry []byte) error {
+		value, err := backend.value(entry)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// Snapshot takes a snapshot of the index, segments are only appended to so the values it points to
+// remain readable until the database is reset
+func (backend *SegmentBackend) Snapshot() (BackendSnapshot, error) {
+	index, err := backend.Index.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueSnapshot{snapshot: index, decode: func(key []byte, entry []byte) ([]byte, error) {
+		return backend.value(entry)
+	}}, nil
+}
+
+// value returns the value of an index record, reading it from its segment if needed
+func (backend *SegmentBackend) value(entry []byte) ([]byte, error) {
+	if len(entry) == 0 {
+		return nil, errors.New("segment index record corrupted")
+	}
+
+	switch entry[0] {
+	case segmentInline:
+		return entry[1:], nil
+	case segmentPointer:
+		segment, n := protowire.ConsumeVarint(entry[1:])
+		if n < 0 {
+			return nil, errors.New("segment index record corrupted")
+		}
+		offset, m := protowire.ConsumeVarint(entry[1+n:])
+		if m < 0 {
+			return nil, errors.New("segment index record corrupted")
+		}
+		return backend.read(segment, int64(offset))
+	default:
+		return nil, errors.New("segment index record corrupted")
+	}
+}
+
+// read reads and verifies the value at offset in a segment
+func (backend *SegmentBackend) read(segment uint64, offset int64) ([]byte, error) {
+	file, err := backend.segmentFile(segment)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, segmentHeaderLength)
+	if _, err = file.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("could not read segment %d at %d, %w", segment, offset, err)
+	}
+
+	value := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err = file.ReadAt(value, offset+segmentHeaderLength); err != nil {
+		return nil, fmt.Errorf("could not read segment %d at %d, %w", segment, offset, err)
+	}
+
+	if crc32.ChecksumIEEE(value) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, fmt.Errorf("checksum mismatch in segment %d at %d", segment, offset)
+	}
+
+	return value, nil
+}
+
+// segmentFile returns an open segment, opening it for reading if needed
+func (backend *SegmentBackend) segmentFile(segment uint64) (*os.File, error) {
+	backend.lock.RLock()
+	file, ok := backend.files[segment]
+	backend.lock.RUnlock()
+	if ok {
+		return file, nil
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if file, ok = backend.files[segment]; ok {
+		return file, nil
+	}
+
+	file, err := os.Open(backend.segmentPath(segment))
+	if err != nil {
+		return nil, err
+	}
+
+	backend.files[segment] = file
+	return file, nil
+}
+
+// Flush syncs the segment files, then the index, so no index entry points past the synced end of a
+// segment
+func (backend *SegmentBackend) Flush() error {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	for segment, file := range backend.files {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("could not sync segment %d, %w", segment, err)
+		}
+	}
+
+	return backend.Index.Flush()
+}
+
+// Begin begins a transaction batching its writes, the index transaction cannot cover the values
+// appended to the segments
+func (backend *SegmentBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Compact compacts the index. Segments are never rewritten.
+func (backend *SegmentBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Index.Compact(discardRatio)
+}
+
+// Backup writes a backup stream of the records, read from the segments and the index, of a snapshot to w, incremental backups are not supported
+func (backend *SegmentBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *SegmentBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// Health reports the health of the index, and the free space on the segment volume
+func (backend *SegmentBackend) Health() (*BackendHealth, error) {
+	health, err := backend.Index.Health()
+	if err != nil {
+		return nil, err
+	}
+
+	free, err := diskFree(backend.Dir)
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
+
+// Stats reports the keys and LSM levels of the index database, and the size of the directory holding
+// it and the segments
+func (backend *SegmentBackend) Stats() (*BackendStats, error) {
+	stats, err := backend.Index.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.DiskSize, err = dirSize(backend.Dir); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,113 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	SegmentBackendType = 5
+)
+
+func init() {
+	backendTypes = append(backendTypes, SegmentBackendType)
+	taggedBackends[SegmentBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewSegmentBackend(dirname, 0, badger.DefaultOptions("").WithLogger(nil))
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestSegmentBackendBasic(t *testing.T) {
+	b := NewBackend(SegmentBackendType)
+
+	backendTest(t, b)
+
+	CloseBackend(b)
+}
+
+func TestSegmentBackend(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions("").WithLogger(nil)
+	backend, err := NewSegmentBackend(dir, 4096, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 20)
+
+	large := bytes.Repeat([]byte{0xab}, 3000)
+	for _, key := range [][]byte{{0xf0, 1}, {0xf0, 2}, {0xf0, 3}} {
+		if err = backend.Put(key, large); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Segments are rotated once they reach the segment size
+	segments, err := backend.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Errorf("expected values to be spread over at least 3 segments, got %v", segments)
+	}
+
+	// The segments and index persist across a reopen
+	backend.Close()
+	backend, err = NewSegmentBackend(dir, 4096, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if value, err := backend.Get([]byte{0xf0, 2}); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("unexpected value after reopening, %v", err)
+	}
+
+	handler = RequestHandler{Backend: backend}
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[120].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain after reopening, got %+v", resp)
+	}
+
+	// Writes after reopening append to the last segment
+	if err = backend.Put([]byte{0xf0, 4}, large); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := backend.Get([]byte{0xf0, 4}); err != nil || !bytes.Equal(value, large) {
+		t.Errorf("unexpected value written after reopening, %v", err)
+	}
+
+	// Corrupted segment data is detected
+	file, err := os.OpenFile(backend.segmentPath(segments[len(segments)-1]), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteAt([]byte{0x00}, segmentHeaderLength+10); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	if _, err = backend.Get([]byte{0xf0, 3}); err == nil {
+		t.Error("expected a checksum error reading a corrupted value")
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.Writable || health.DiskFreeBytes == nil {
+		t.Errorf("unexpected health %+v, %v", health, err)
+	}
+}
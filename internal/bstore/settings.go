@@ -0,0 +1,48 @@
+package bstore
+
+import (
+	"time"
+)
+
+// Settings are the settings of the request handler which can change while it serves requests, such
+// as when the configuration is reloaded. Zero values use the defaults, like the fields they replace.
+type Settings struct {
+	MaxMessageSize    int
+	MaxBlocksByHeight uint64
+	MaxBlocksByID     uint64
+	StaleHeadAfter    time.Duration
+	RateLimit         float64
+	RateLimitBurst    int
+	RequestTimeout    time.Duration
+}
+
+// ApplySettings replaces the settings of the request handler, which were set by its fields until
+// then. Requests in progress keep the settings they started with.
+func (handler *RequestHandler) ApplySettings(settings *Settings) {
+	applied := *settings
+	handler.applied.Store(&applied)
+}
+
+// CurrentSettings returns the settings of the request handler
+func (handler *RequestHandler) CurrentSettings() *Settings {
+	current := *handler.settings()
+	return &current
+}
+
+// settings returns the settings last applied, or those of the fields of the request handler if none
+// were applied. The result must not be modified.
+func (handler *RequestHandler) settings() *Settings {
+	if applied, ok := handler.applied.Load().(*Settings); ok {
+		return applied
+	}
+
+	return &Settings{
+		MaxMessageSize:    handler.MaxMessageSize,
+		MaxBlocksByHeight: handler.MaxBlocksByHeight,
+		MaxBlocksByID:     handler.MaxBlocksByID,
+		StaleHeadAfter:    handler.StaleHeadAfter,
+		RateLimit:         handler.RateLimit,
+		RateLimitBurst:    handler.RateLimitBurst,
+		RequestTimeout:    handler.RequestTimeout,
+	}
+}
@@ -0,0 +1,58 @@
+package bstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestApplySettings(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), MaxBlocksByHeight: 10}
+	bt := buildLinearChain(t, &handler, 5)
+
+	caps, err := handler.GetCapabilities(&GetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.MaxBlocksByHeight != 10 || caps.MaxBlocksByID != DefaultMaxBlocksByID || caps.MaxMessageSize != DefaultMaxMessageSize {
+		t.Errorf("unexpected limits %+v", caps)
+	}
+
+	byHeight := &block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+		GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{HeadBlockId: bt.ByNum[105].GetId(), AncestorStartHeight: 1, NumBlocks: 5, ReturnBlock: true},
+	}}
+	full := proto.Size(handler.HandleRequest(byHeight))
+
+	settings := &Settings{MaxMessageSize: full / 2, MaxBlocksByHeight: 2, MaxBlocksByID: 3, StaleHeadAfter: time.Nanosecond}
+	handler.ApplySettings(settings)
+	settings.MaxBlocksByHeight = 1
+	if current := handler.CurrentSettings(); *current != (Settings{MaxMessageSize: full / 2, MaxBlocksByHeight: 2, MaxBlocksByID: 3, StaleHeadAfter: time.Nanosecond}) {
+		t.Errorf("unexpected settings %+v", current)
+	}
+
+	if caps, err = handler.GetCapabilities(&GetCapabilitiesRequest{}); err != nil || caps.MaxMessageSize != full/2 || caps.MaxBlocksByHeight != 2 || caps.MaxBlocksByID != 3 {
+		t.Errorf("expected the applied limits, got %+v, %v", caps, err)
+	}
+
+	// Responses are bounded by the applied message size
+	handler.ApplySettings(&Settings{MaxMessageSize: full / 4, StaleHeadAfter: time.Nanosecond})
+	if size := handler.MaxResponseSize(); size != full/4 {
+		t.Errorf("expected responses bounded by %d bytes, got %d", full/4, size)
+	}
+
+	time.Sleep(time.Millisecond)
+	if head, err := handler.GetHead(&GetHeadRequest{}); err != nil || !head.Stale {
+		t.Errorf("expected a stale head, got %+v, %v", head, err)
+	}
+
+	// Zero values restore the defaults
+	handler.ApplySettings(&Settings{})
+	if caps, err = handler.GetCapabilities(&GetCapabilitiesRequest{}); err != nil || caps.MaxBlocksByHeight != DefaultMaxBlocksByHeight || caps.MaxMessageSize != DefaultMaxMessageSize {
+		t.Errorf("expected the default limits, got %+v, %v", caps, err)
+	}
+	if head, err := handler.GetHead(&GetHeadRequest{}); err != nil || head.Stale {
+		t.Errorf("expected no stale head, got %+v, %v", head, err)
+	}
+}
@@ -0,0 +1,568 @@
+package bstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// DefaultShardSize is the number of block heights stored in each shard
+	DefaultShardSize = 1000000
+
+	shardMetaDir     = "meta"
+	shardDirPattern  = "shard-%05d"
+	blockRecordIDTag = 1
+	blockRecordHtTag = 2
+)
+
+// shardLocationPrefix starts the meta key holding the shard of a block record. Block IDs are
+// multihashes, which never start with a zero byte.
+var shardLocationPrefix = []byte("\x00shard:")
+
+// ShardedBackend partitions block records into Badger databases by height range, one shard per
+// ShardSize heights, so old shards can be placed on cheaper disks or dropped independently. Other
+// records, along with the shard of each block record, are kept in a meta database.
+//
+// A shard whose directory was removed while the block store was stopped is treated as empty, its
+// blocks are reported as not present.
+type ShardedBackend struct {
+	Meta *BadgerBackend
+	Dir  string
+
+	// ShardSize is the number of block heights stored in each shard
+	ShardSize uint64
+
+	opts badger.Options
+
+	lock   sync.RWMutex
+	shards map[uint64]*BadgerBackend
+}
+
+// NewShardedBackend opens the meta database in dir, creating it if missing. Shards are opened when
+// first used. A shardSize of 0 uses DefaultShardSize.
+func NewShardedBackend(dir string, shardSize uint64, opts badger.Options) (*ShardedBackend, error) {
+	if shardSize == 0 {
+		shardSize = DefaultShardSize
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	metaOpts := opts
+	metaOpts.Dir = filepath.Join(dir, shardMetaDir)
+	metaOpts.ValueDir = metaOpts.Dir
+	meta, err := NewBadgerBackend(metaOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShardedBackend{Meta: meta, Dir: dir, ShardSize: shardSize, opts: opts, shards: make(map[uint64]*BadgerBackend)}, nil
+}
+
+func (backend *ShardedBackend) shardPath(shard uint64) string {
+	return filepath.Join(backend.Dir, fmt.Sprintf(shardDirPattern, shard))
+}
+
+// Shards returns the numbers of the shards on disk in ascending order
+func (backend *ShardedBackend) Shards() ([]uint64, error) {
+	matches, err := filepath.Glob(filepath.Join(backend.Dir, "shard-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var shards []uint64
+	for _, match := range matches {
+		var shard uint64
+		if _, err := fmt.Sscanf(filepath.Base(match), shardDirPattern, &shard); err == nil {
+			shards = append(shards, shard)
+		}
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i] < shards[j] })
+
+	return shards, nil
+}
+
+// shard returns an open shard, opening it if needed. A shard which does not exist is only created if
+// create is set, otherwise nil is returned.
+func (backend *ShardedBackend) shard(shard uint64, create bool) (*BadgerBackend, error) {
+	backend.lock.RLock()
+	db, ok := backend.shards[shard]
+	backend.lock.RUnlock()
+	if ok {
+		return db, nil
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if db, ok = backend.shards[shard]; ok {
+		return db, nil
+	}
+
+	path := backend.shardPath(shard)
+	if _, err := os.Stat(path); os.IsNotExist(err) && !create {
+		return nil, nil
+	}
+
+	opts := backend.opts
+	opts.Dir = path
+	opts.ValueDir = path
+	db, err := NewBadgerBackend(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not open shard %d, %w", shard, err)
+	}
+
+	backend.shards[shard] = db
+	return db, nil
+}
+
+// location returns the shard of a block record read with get from the meta database, false if the
+// key is not a block record
+func (backend *ShardedBackend) location(get func(key []byte) ([]byte, error), key []byte) (uint64, bool, error) {
+	value, err := get(shardLocationKey(key))
+	if err != nil || len(value) == 0 {
+		return 0, false, err
+	}
+
+	shard, n := protowire.ConsumeVarint(value)
+	if n < 0 {
+		return 0, false, errors.New("shard location record corrupted")
+	}
+
+	return shard, true, nil
+}
+
+func shardLocationKey(key []byte) []byte {
+	return append(append([]byte{}, shardLocationPrefix...), key...)
+}
+
+// blockRecordHeight returns the height of a serialized block record stored under key, false if the
+// value is not the block record of key
+func blockRecordHeight(key []byte, value []byte) (uint64, bool) {
+	var height uint64
+	matched := false
+
+	for len(value) > 0 {
+		num, typ, n := protowire.ConsumeTag(value)
+		if n < 0 {
+			return 0, false
+		}
+		value = value[n:]
+
+		switch {
+		case num == blockRecordIDTag && typ == protowire.BytesType:
+			id, m := protowire.ConsumeBytes(value)
+			if m < 0 {
+				return 0, false
+			}
+			matched = bytes.Equal(blockRecordKey(id), key)
+			n = m
+		case num == blockRecordHtTag && typ == protowire.VarintType:
+			height, n = protowire.ConsumeVarint(value)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, value)
+		}
+		if n < 0 {
+			return 0, false
+		}
+		value = value[n:]
+	}
+
+	return height, matched
+}
+
+// Close cleans backend resources
+func (backend *ShardedBackend) Close() {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	for shard, db := range backend.shards {
+		db.Close()
+		delete(backend.shards, shard)
+	}
+	backend.Meta.Close()
+}
+
+// Reset removes all shards and resets the meta database
+func (backend *ShardedBackend) Reset() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if err := backend.Meta.Reset(); err != nil {
+		return err
+	}
+
+	for shard, db := range backend.shards {
+		db.Close()
+		delete(backend.shards, shard)
+	}
+
+	shards, err := backend.Shards()
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if err = os.RemoveAll(backend.shardPath(shard)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Put stores block records in the shard of their height and other records in the meta database
+func (backend *ShardedBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	height, ok := blockRecordHeight(key, value)
+	if !ok {
+		return backend.Meta.Put(key, value)
+	}
+
+	shard := height / backend.ShardSize
+	db, err := backend.shard(shard, true)
+	if err != nil {
+		return err
+	}
+
+	if err = db.Put(key, value); err != nil {
+		return err
+	}
+
+	return backend.Meta.Put(shardLocationKey(key), protowire.AppendVarint(nil, shard))
+}
+
+// PutBatch stores the block records in their shards first, then the other records and the shards of
+// the block records in the meta database in a single transaction. Block records are only found
+// through their shard location, so a failed batch leaves none of its records visible.
+func (backend *ShardedBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	shards := make(map[uint64][]*KeyValue)
+	meta := make([]*KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		height, ok := blockRecordHeight(pair.Key, pair.Value)
+		if !ok {
+			meta = append(meta, pair)
+			continue
+		}
+
+		shard := height / backend.ShardSize
+		shards[shard] = append(shards[shard], pair)
+		meta = append(meta, &KeyValue{Key: shardLocationKey(pair.Key), Value: protowire.AppendVarint(nil, shard)})
+	}
+
+	for shard, records := range shards {
+		db, err := backend.shard(shard, true)
+		if err != nil {
+			return err
+		}
+		if err = db.PutBatch(records); err != nil {
+			return err
+		}
+	}
+
+	return backend.Meta.PutBatch(meta)
+}
+
+// Delete removes an item from its shard or from the meta database
+func (backend *ShardedBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	shard, ok, err := backend.location(backend.Meta.Get, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return backend.Meta.Delete(key)
+	}
+
+	db, err := backend.shard(shard, false)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		if err = db.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return backend.Meta.Delete(shardLocationKey(key))
+}
+
+// Get fetches the requested value from its shard or from the meta database
+func (backend *ShardedBackend) Get(key []byte) ([]byte, error) {
+	return backend.get(backend.Meta.Get, key)
+}
+
+// Has reports whether key is stored, in its shard if it is a block record. Block records of removed
+// shards are not present.
+func (backend *ShardedBackend) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("cannot check an empty key")
+	}
+
+	shard, ok, err := backend.location(backend.Meta.Get, key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return backend.Meta.Has(key)
+	}
+
+	db, err := backend.shard(shard, false)
+	if err != nil || db == nil {
+		return false, err
+	}
+
+	return db.Has(key)
+}
+
+// get fetches a value from its shard, or with get from the meta database
+func (backend *ShardedBackend) get(get func(key []byte) ([]byte, error), key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("cannot get an empty key")
+	}
+
+	shard, ok, err := backend.location(get, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return get(key)
+	}
+
+	db, err := backend.shard(shard, false)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return make([]byte, 0), nil
+	}
+
+	return db.Get(key)
+}
+
+// Iterate calls fn with the keys starting with prefix and their values, merging the records of the
+// meta database with the block records of the shards in key order. Block records of removed shards
+// are skipped.
+func (backend *ShardedBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(backend.Meta.DB.View(func(txn *badger.Txn) error {
+		return backend.iterate(txn, prefix, fn)
+	}))
+}
+
+// Snapshot takes a snapshot of the meta database, which pins the shard locations. Block records are
+// read from their shard, they are not modified once written.
+func (backend *ShardedBackend) Snapshot() (BackendSnapshot, error) {
+	return &shardedSnapshot{backend: backend, meta: &badgerSnapshot{txn: backend.Meta.DB.NewTransaction(false)}}, nil
+}
+
+// shardedSnapshot reads the meta database from a read-only transaction
+type shardedSnapshot struct {
+	backend *ShardedBackend
+	meta    *badgerSnapshot
+}
+
+func (snapshot *shardedSnapshot) Get(key []byte) ([]byte, error) {
+	return snapshot.backend.get(snapshot.meta.Get, key)
+}
+
+func (snapshot *shardedSnapshot) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return stopIteration(snapshot.backend.iterate(snapshot.meta.txn, prefix, fn))
+}
+
+func (snapshot *shardedSnapshot) Release() {
+	snapshot.meta.Release()
+}
+
+// iterate merges the records of the meta database read within txn with the block records of the shards
+func (backend *ShardedBackend) iterate(txn *badger.Txn, prefix []byte, fn func(key []byte, value []byte) error) error {
+	locationPrefix := shardLocationKey(prefix)
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	records := txn.NewIterator(opts)
+	defer records.Close()
+
+	opts.Prefix = locationPrefix
+	locations := txn.NewIterator(opts)
+	defer locations.Close()
+
+	records.Seek(prefix)
+	locations.Seek(locationPrefix)
+	for {
+		// The shard locations are kept among the other records, they are skipped at once
+		if records.ValidForPrefix(prefix) && bytes.HasPrefix(records.Item().Key(), shardLocationPrefix) {
+			records.Seek(prefixEnd(shardLocationPrefix))
+			continue
+		}
+
+		hasRecord := records.ValidForPrefix(prefix)
+		hasBlock := locations.ValidForPrefix(locationPrefix)
+		if !hasRecord && !hasBlock {
+			return nil
+		}
+
+		if hasBlock && hasRecord {
+			order := bytes.Compare(locations.Item().Key()[len(shardLocationPrefix):], records.Item().Key())
+			if order == 0 {
+				// A block record hides a meta record with the same key, as in Get
+				records.Next()
+				continue
+			}
+			hasBlock = order < 0
+		}
+
+		if hasBlock {
+			if err := backend.iterateBlock(locations.Item(), fn); err != nil {
+				return err
+			}
+			locations.Next()
+			continue
+		}
+
+		value, err := records.Item().ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(records.Item().KeyCopy(nil), value); err != nil {
+			return err
+		}
+		records.Next()
+	}
+}
+
+// iterateBlock calls fn with the block record of a shard location, unless its shard was removed
+func (backend *ShardedBackend) iterateBlock(location *badger.Item, fn func(key []byte, value []byte) error) error {
+	key := location.KeyCopy(nil)[len(shardLocationPrefix):]
+	value, err := location.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+
+	shard, n := protowire.ConsumeVarint(value)
+	if n < 0 {
+		return errors.New("shard location record corrupted")
+	}
+
+	db, err := backend.shard(shard, false)
+	if err != nil || db == nil {
+		return err
+	}
+
+	record, err := db.Get(key)
+	if err != nil || len(record) == 0 {
+		return err
+	}
+
+	return fn(key, record)
+}
+
+// Flush flushes every open shard, then the meta database, so no block location refers to a record
+// which is not yet durable
+func (backend *ShardedBackend) Flush() error {
+	backend.lock.RLock()
+	for shard, db := range backend.shards {
+		if err := db.Flush(); err != nil {
+			backend.lock.RUnlock()
+			return fmt.Errorf("could not flush shard %d, %w", shard, err)
+		}
+	}
+	backend.lock.RUnlock()
+
+	return backend.Meta.Flush()
+}
+
+// Begin begins a transaction batching its writes, the transactions of the meta database and the
+// shards cannot be committed atomically
+func (backend *ShardedBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Compact compacts the meta database and every open shard, reporting their combined sizes
+func (backend *ShardedBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	result, err := backend.Meta.Compact(discardRatio)
+	if err != nil {
+		return nil, err
+	}
+
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	for shard, db := range backend.shards {
+		shardResult, err := db.Compact(discardRatio)
+		if err != nil {
+			return nil, fmt.Errorf("could not compact shard %d, %w", shard, err)
+		}
+
+		result.LSMSizeBefore += shardResult.LSMSizeBefore
+		result.LSMSizeAfter += shardResult.LSMSizeAfter
+		result.ValueLogSizeBefore += shardResult.ValueLogSizeBefore
+		result.ValueLogSizeAfter += shardResult.ValueLogSizeAfter
+		result.ValueLogFilesRewritten += shardResult.ValueLogFilesRewritten
+	}
+
+	return result, nil
+}
+
+// Backup writes a backup stream of the records of the meta database and the open shards of a snapshot to w, incremental backups are not supported
+func (backend *ShardedBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *ShardedBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// Health reports the health of the meta database, and the free space on the shard volume
+func (backend *ShardedBackend) Health() (*BackendHealth, error) {
+	health, err := backend.Meta.Health()
+	if err != nil {
+		return nil, err
+	}
+
+	free, err := diskFree(backend.Dir)
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
+
+// Stats reports the keys and LSM levels of the meta database, and the size of the directory holding
+// it and the shards
+func (backend *ShardedBackend) Stats() (*BackendStats, error) {
+	stats, err := backend.Meta.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.DiskSize, err = dirSize(backend.Dir); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,97 @@
+package bstore
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	ShardedBackendType = 8
+)
+
+func init() {
+	backendTypes = append(backendTypes, ShardedBackendType)
+	taggedBackends[ShardedBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewShardedBackend(dirname, 100, badger.DefaultOptions("").WithLogger(nil))
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestShardedBackendBasic(t *testing.T) {
+	b := NewBackend(ShardedBackendType)
+
+	backendTest(t, b)
+
+	CloseBackend(b)
+}
+
+func TestShardedBackend(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	opts := badger.DefaultOptions("").WithLogger(nil)
+	backend, err := NewShardedBackend(dir, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 10)
+
+	shards, err := backend.Shards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 3 || shards[0] != 0 || shards[2] != 2 {
+		t.Errorf("expected shards 0 to 2 for heights 1 to 10, got %v", shards)
+	}
+
+	if _, err = backend.Compact(0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropping a shard only removes the blocks in its height range
+	backend.Close()
+	if err = os.RemoveAll(backend.shardPath(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err = NewShardedBackend(dir, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Backend = backend
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[110].GetId(), StartHeight: 5}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected valid chain above the dropped shard, got %+v", resp)
+	}
+
+	if _, err = handler.getRecord(bt.ByNum[102].GetId()); err == nil {
+		t.Error("expected block in the dropped shard not to be present")
+	}
+	if _, err = os.Stat(backend.shardPath(0)); !os.IsNotExist(err) {
+		t.Error("expected reads not to recreate a dropped shard")
+	}
+
+	if err = backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if shards, err = backend.Shards(); err != nil || len(shards) != 0 {
+		t.Errorf("expected no shards after reset, got %v, %v", shards, err)
+	}
+
+	backend.Close()
+}
@@ -0,0 +1,98 @@
+package bstore
+
+import (
+	"errors"
+	"io"
+)
+
+var errSnapshotReadOnly = errors.New("cannot write to a snapshot")
+
+// SnapshotBackend serves the reads of a snapshot as a read-only backend, so the request helpers can
+// read several records from a single point in time. Writes through it return an error.
+//
+// Like MemoBackend it is meant to live for a single request, Release releases the snapshot.
+type SnapshotBackend struct {
+	View BackendSnapshot
+}
+
+// NewSnapshotBackend takes a snapshot of backend and creates a SnapshotBackend reading from it
+func NewSnapshotBackend(backend BlockStoreBackend) (*SnapshotBackend, error) {
+	view, err := backend.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotBackend{View: view}, nil
+}
+
+// Release releases the snapshot
+func (backend *SnapshotBackend) Release() {
+	backend.View.Release()
+}
+
+// Reset returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) Reset() error {
+	return errSnapshotReadOnly
+}
+
+// Put returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) Put(key []byte, value []byte) error {
+	return errSnapshotReadOnly
+}
+
+// PutBatch returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) PutBatch(pairs []*KeyValue) error {
+	return errSnapshotReadOnly
+}
+
+// Delete returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) Delete(key []byte) error {
+	return errSnapshotReadOnly
+}
+
+// Get fetches the requested value from the snapshot
+func (backend *SnapshotBackend) Get(key []byte) ([]byte, error) {
+	return backend.View.Get(key)
+}
+
+// Has reports whether the snapshot stores key, reading its value as snapshots cannot check a key
+func (backend *SnapshotBackend) Has(key []byte) (bool, error) {
+	value, err := backend.View.Get(key)
+	return len(value) > 0, err
+}
+
+// Iterate iterates over the snapshot
+func (backend *SnapshotBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.View.Iterate(prefix, fn)
+}
+
+// Snapshot returns a view of the snapshot, which is already pinned. Releasing the view does not
+// release the snapshot.
+func (backend *SnapshotBackend) Snapshot() (BackendSnapshot, error) {
+	return &liveSnapshot{backend: backend}, nil
+}
+
+// Flush does nothing, nothing is written through a snapshot
+func (backend *SnapshotBackend) Flush() error {
+	return nil
+}
+
+// Compact returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return nil, errSnapshotReadOnly
+}
+
+// Backup writes a backup stream of the records of the snapshot to w
+func (backend *SnapshotBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) Load(r io.Reader) error {
+	return errSnapshotReadOnly
+}
+
+// Begin returns an error, a snapshot is read-only
+func (backend *SnapshotBackend) Begin() (BackendTxn, error) {
+	return nil, errSnapshotReadOnly
+}
@@ -0,0 +1,282 @@
+//go:build sqlite
+// +build sqlite
+
+package bstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	// Registers the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSupported is set if the block store was built with the sqlite tag
+const SQLiteSupported = true
+
+const (
+	sqliteSchema = `CREATE TABLE IF NOT EXISTS records (key BLOB PRIMARY KEY, value BLOB NOT NULL) WITHOUT ROWID`
+
+	sqliteGetQuery     = "SELECT value FROM records WHERE key = ?"
+	sqliteHasQuery     = "SELECT EXISTS (SELECT 1 FROM records WHERE key = ?)"
+	sqliteIterateQuery = "SELECT key, value FROM records WHERE key >= ? ORDER BY key"
+	sqliteRangeQuery   = "SELECT key, value FROM records WHERE key >= ? AND key < ? ORDER BY key"
+)
+
+// SQLiteBackend SQLite backend implementation. Records are stored in the records table of a single
+// database file, with key and value columns.
+type SQLiteBackend struct {
+	DB   *sql.DB
+	Path string
+
+	// snapshots is a pool of read-only connections for snapshots, which would otherwise hold the
+	// single connection of DB
+	snapshots *sql.DB
+
+	// lastWrite is the time of the last successful write in Unix nanoseconds, writeFailed is set if
+	// the last write failed
+	lastWrite   int64
+	writeFailed int32
+	closed      int32
+	// lastCompaction is the time of the last successful Compact in Unix nanoseconds
+	lastCompaction int64
+}
+
+// NewSQLiteBackend SQLiteBackend constructor. The database file at path is created if missing. A
+// cacheSize of 0 uses the SQLite default page cache size.
+func NewSQLiteBackend(path string, cacheSize int64) (*SQLiteBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_sync=NORMAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection serializes access, so readers never see SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	statements := []string{sqliteSchema}
+	if cacheSize > 0 {
+		// A negative cache size is in KiB rather than pages
+		statements = append(statements, fmt.Sprintf("PRAGMA cache_size = -%d", cacheSize>>10))
+	}
+
+	for _, statement := range statements {
+		if _, err = db.Exec(statement); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	// Readers do not block the writer of a database in WAL mode
+	snapshots, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteBackend{DB: db, Path: path, snapshots: snapshots}, nil
+}
+
+// Close cleans backend resources
+func (backend *SQLiteBackend) Close() {
+	if !atomic.CompareAndSwapInt32(&backend.closed, 0, 1) {
+		return
+	}
+
+	_ = backend.snapshots.Close()
+	_ = backend.DB.Close()
+}
+
+// Reset resets the database
+func (backend *SQLiteBackend) Reset() error {
+	_, err := backend.DB.Exec("DELETE FROM records")
+	return backend.recordWrite(err)
+}
+
+// Put backend setter
+func (backend *SQLiteBackend) Put(key, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	_, err := backend.DB.Exec("INSERT OR REPLACE INTO records (key, value) VALUES (?, ?)", key, value)
+	return backend.recordWrite(err)
+}
+
+// PutBatch stores the values in a single transaction
+func (backend *SQLiteBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	tx, err := backend.DB.Begin()
+	if err != nil {
+		return backend.recordWrite(err)
+	}
+
+	for _, pair := range pairs {
+		if _, err = tx.Exec("INSERT OR REPLACE INTO records (key, value) VALUES (?, ?)", pair.Key, pair.Value); err != nil {
+			_ = tx.Rollback()
+			return backend.recordWrite(err)
+		}
+	}
+
+	return backend.recordWrite(tx.Commit())
+}
+
+// Delete an item from the database
+func (backend *SQLiteBackend) Delete(key []byte) error {
+	if key == nil {
+		return errors.New("cannot remove a nil key")
+	}
+
+	_, err := backend.DB.Exec("DELETE FROM records WHERE key = ?", key)
+	return backend.recordWrite(err)
+}
+
+// Get backend getter
+func (backend *SQLiteBackend) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("cannot get a nil key")
+	}
+
+	return queryValue(backend.DB, sqliteGetQuery, key)
+}
+
+// Has reports whether key is stored, without reading its value
+func (backend *SQLiteBackend) Has(key []byte) (bool, error) {
+	if key == nil {
+		return false, errors.New("cannot check a nil key")
+	}
+
+	return queryExists(backend.DB, sqliteHasQuery, key)
+}
+
+// Iterate calls fn with the keys starting with prefix and their values, in the byte order of the keys
+func (backend *SQLiteBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return queryPrefix(backend.DB, sqliteIterateQuery, sqliteRangeQuery, prefix, fn)
+}
+
+// Snapshot takes a snapshot of the database with a read transaction on a separate connection
+func (backend *SQLiteBackend) Snapshot() (BackendSnapshot, error) {
+	tx, err := backend.snapshots.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// A read transaction starts with its first read of the database
+	var one int
+	if err = tx.QueryRow("SELECT 1 FROM records LIMIT 1").Scan(&one); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	return &sqlSnapshot{tx: tx, getQuery: sqliteGetQuery, iterateQuery: sqliteIterateQuery, rangeQuery: sqliteRangeQuery}, nil
+}
+
+// Flush checkpoints the write ahead log into the database file. With synchronous NORMAL the commits
+// since the last checkpoint may be lost on a power failure, a checkpoint syncs them.
+func (backend *SQLiteBackend) Flush() error {
+	_, err := backend.DB.Exec("PRAGMA wal_checkpoint(FULL)")
+	return err
+}
+
+// Begin begins a transaction batching its writes. An SQLite transaction would hold the only
+// connection for its duration.
+func (backend *SQLiteBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// recordWrite records the outcome of a write for Health, returning err
+func (backend *SQLiteBackend) recordWrite(err error) error {
+	if err != nil {
+		atomic.StoreInt32(&backend.writeFailed, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&backend.writeFailed, 0)
+	atomic.StoreInt64(&backend.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// Compact rebuilds the database file with VACUUM. SQLite has no value log, the file sizes are
+// reported as the LSM sizes.
+func (backend *SQLiteBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	result := &CompactionResult{LSMSizeBefore: backend.fileSize()}
+
+	// Fold the write ahead log into the database file first, so the sizes are comparable
+	if _, err := backend.DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return nil, err
+	}
+	if _, err := backend.DB.Exec("VACUUM"); err != nil {
+		return nil, err
+	}
+	if _, err := backend.DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return nil, err
+	}
+
+	result.LSMSizeAfter = backend.fileSize()
+	atomic.StoreInt64(&backend.lastCompaction, time.Now().UnixNano())
+	return result, nil
+}
+
+// Backup writes a backup stream of the records of a snapshot to w, incremental backups are not supported
+func (backend *SQLiteBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return streamBackup(backend, w, sinceVersion)
+}
+
+// Load replaces the contents of the database with a backup stream read from r
+func (backend *SQLiteBackend) Load(r io.Reader) error {
+	return streamLoad(backend, r)
+}
+
+// fileSize returns the size of the database file and its write ahead log
+func (backend *SQLiteBackend) fileSize() int64 {
+	var size int64
+	for _, path := range []string{backend.Path, backend.Path + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
+
+// Health reports whether the database accepts writes, when it was last written and the free space on
+// the database volume. SQLite has no background compactions.
+func (backend *SQLiteBackend) Health() (*BackendHealth, error) {
+	health := &BackendHealth{
+		Writable: atomic.LoadInt32(&backend.closed) == 0 && atomic.LoadInt32(&backend.writeFailed) == 0,
+	}
+
+	if lastWrite := atomic.LoadInt64(&backend.lastWrite); lastWrite != 0 {
+		t := time.Unix(0, lastWrite).UTC()
+		health.LastWrite = &t
+	}
+
+	free, err := diskFree(filepath.Dir(backend.Path))
+	if err != nil {
+		return nil, err
+	}
+	health.DiskFree = &free
+
+	return health, nil
+}
+
+// Stats reports the size of the database file and its write-ahead log, counting the keys would scan
+// the whole table
+func (backend *SQLiteBackend) Stats() (*BackendStats, error) {
+	return &BackendStats{DiskSize: backend.fileSize(), LastCompaction: unixNanoTime(atomic.LoadInt64(&backend.lastCompaction))}, nil
+}
@@ -0,0 +1,92 @@
+//go:build !sqlite
+// +build !sqlite
+
+package bstore
+
+import (
+	"errors"
+	"io"
+)
+
+// SQLiteSupported is set if the block store was built with the sqlite tag
+const SQLiteSupported = false
+
+var errSQLiteUnsupported = errors.New("block store was built without SQLite support, rebuild with -tags sqlite")
+
+// SQLiteBackend is unavailable without the sqlite build tag, NewSQLiteBackend always fails
+type SQLiteBackend struct {
+}
+
+// NewSQLiteBackend returns an error, the block store was built without the sqlite tag
+func NewSQLiteBackend(path string, cacheSize int64) (*SQLiteBackend, error) {
+	return nil, errSQLiteUnsupported
+}
+
+// Close does nothing
+func (backend *SQLiteBackend) Close() {
+}
+
+// Reset returns an error
+func (backend *SQLiteBackend) Reset() error {
+	return errSQLiteUnsupported
+}
+
+// Put returns an error
+func (backend *SQLiteBackend) Put(key, value []byte) error {
+	return errSQLiteUnsupported
+}
+
+// PutBatch returns an error
+func (backend *SQLiteBackend) PutBatch(pairs []*KeyValue) error {
+	return errSQLiteUnsupported
+}
+
+// Delete returns an error
+func (backend *SQLiteBackend) Delete(key []byte) error {
+	return errSQLiteUnsupported
+}
+
+// Get returns an error
+func (backend *SQLiteBackend) Get(key []byte) ([]byte, error) {
+	return nil, errSQLiteUnsupported
+}
+
+// Has returns an error
+func (backend *SQLiteBackend) Has(key []byte) (bool, error) {
+	return false, errSQLiteUnsupported
+}
+
+// Iterate returns an error
+func (backend *SQLiteBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return errSQLiteUnsupported
+}
+
+// Snapshot returns an error
+func (backend *SQLiteBackend) Snapshot() (BackendSnapshot, error) {
+	return nil, errSQLiteUnsupported
+}
+
+// Flush returns an error
+func (backend *SQLiteBackend) Flush() error {
+	return errSQLiteUnsupported
+}
+
+// Compact returns an error
+func (backend *SQLiteBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return nil, errSQLiteUnsupported
+}
+
+// Backup returns an error
+func (backend *SQLiteBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return 0, errSQLiteUnsupported
+}
+
+// Load returns an error
+func (backend *SQLiteBackend) Load(r io.Reader) error {
+	return errSQLiteUnsupported
+}
+
+// Begin returns an error
+func (backend *SQLiteBackend) Begin() (BackendTxn, error) {
+	return nil, errSQLiteUnsupported
+}
@@ -0,0 +1,102 @@
+//go:build sqlite
+// +build sqlite
+
+package bstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	SQLiteBackendType = 3
+)
+
+func init() {
+	backendTypes = append(backendTypes, SQLiteBackendType)
+	taggedBackends[SQLiteBackendType] = func() BlockStoreBackend {
+		dirname, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+		if err != nil {
+			panic("unable to create temp directory")
+		}
+		backend, err := NewSQLiteBackend(filepath.Join(dirname, "block_store.db"), 0)
+		if err != nil {
+			panic(err)
+		}
+		return backend
+	}
+}
+
+func TestSQLiteBackend(t *testing.T) {
+	backend := NewBackend(SQLiteBackendType).(*SQLiteBackend)
+	defer CloseBackend(backend)
+
+	if value, err := backend.Get([]byte{1}); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected empty value for missing key, got %v, %v", value, err)
+	}
+	if err := backend.Put([]byte{1}, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := backend.Get([]byte{1}); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected empty value, got %v, %v", value, err)
+	}
+
+	// Snapshots read on their own connection, writes are not blocked by them
+	snapshot, err := backend.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = backend.Put([]byte("snapshot"), []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := snapshot.Get([]byte("snapshot")); err != nil || value == nil || len(value) != 0 {
+		t.Errorf("expected the value when the snapshot was taken, got %v, %v", value, err)
+	}
+	snapshot.Release()
+
+	handler := RequestHandler{Backend: backend}
+	buildLinearChain(t, &handler, 10)
+
+	// The records are readable with plain SQL
+	var count int
+	if err := backend.DB.QueryRow("SELECT COUNT(*) FROM records").Scan(&count); err != nil || count == 0 {
+		t.Errorf("expected records in the database, got %d, %v", count, err)
+	}
+
+	compacted, err := handler.CompactStore(&CompactStoreRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.LSMSizeAfter <= 0 {
+		t.Error("expected non-zero database size after compaction")
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Writable || health.LastWrite == nil || health.DiskFreeBytes == nil {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	// The database persists across a reopen
+	if err = backend.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewSQLiteBackend(backend.Path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if value, _ := reopened.Get([]byte{highestBlockKey}); len(value) == 0 {
+		t.Error("expected highest block after reopening")
+	}
+
+	if err = reopened.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := reopened.Get([]byte{highestBlockKey}); len(value) != 0 {
+		t.Error("expected reset to remove all keys")
+	}
+}
@@ -0,0 +1,60 @@
+package bstore
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// GetHeadRequest asks for the highest block and whether it is stale
+type GetHeadRequest struct {
+}
+
+// GetHeadResponse is the highest block, with a warning if it has not advanced for longer than the
+// configured stale head duration, which usually means the node is stalled or not syncing
+type GetHeadResponse struct {
+	// Head is the highest block, nil if the store is empty
+	Head *Topology `json:"head"`
+
+	// HeadAdvancedAt is the time the head last advanced, or the time the block store first checked
+	// it if it has not advanced since the block store started
+	HeadAdvancedAt time.Time `json:"head_advanced_at"`
+
+	Stale   bool   `json:"stale"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// GetHead returns the highest block and whether it is stale
+func (handler *RequestHandler) GetHead(req *GetHeadRequest) (*GetHeadResponse, error) {
+	head, err := handler.getTopologyAtKey(highestBlockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &GetHeadResponse{Head: head}
+	var age time.Duration
+	resp.HeadAdvancedAt, age, resp.Stale = handler.headStaleness()
+	if resp.Stale {
+		resp.Warning = fmt.Sprintf("head has not advanced for %s", age.Round(time.Second))
+	}
+
+	return resp, nil
+}
+
+// markHeadAdvanced records that the highest block changed
+func (handler *RequestHandler) markHeadAdvanced() {
+	atomic.StoreInt64(&handler.headAdvanced, time.Now().UnixNano())
+}
+
+// headStaleness returns when the head last advanced, for how long it has not, and whether that is
+// longer than StaleHeadAfter. Until the head advances, the time of the first check is used.
+func (handler *RequestHandler) headStaleness() (time.Time, time.Duration, bool) {
+	now := time.Now()
+	atomic.CompareAndSwapInt64(&handler.headAdvanced, 0, now.UnixNano())
+
+	advanced := time.Unix(0, atomic.LoadInt64(&handler.headAdvanced)).UTC()
+	age := now.Sub(advanced)
+
+	staleAfter := handler.settings().StaleHeadAfter
+	return advanced, age, staleAfter > 0 && age > staleAfter
+}
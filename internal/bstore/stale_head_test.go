@@ -0,0 +1,56 @@
+package bstore
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestGetHead(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend(), StaleHeadAfter: time.Minute}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetHead: &GetHeadRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.GetHead.Head != nil || resp.GetHead.Stale {
+		t.Errorf("unexpected head of empty store %+v", resp.GetHead)
+	}
+
+	bt := buildLinearChain(t, &handler, 3)
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{GetHead: &GetHeadRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.GetHead.Head == nil || resp.GetHead.Head.Height != 3 || resp.GetHead.Stale || len(resp.GetHead.Warning) != 0 {
+		t.Errorf("unexpected head %+v", resp.GetHead)
+	}
+
+	// Pretend the head last advanced two minutes ago
+	atomic.StoreInt64(&handler.headAdvanced, time.Now().Add(-2*time.Minute).UnixNano())
+
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{GetHead: &GetHeadRequest{}})
+	if !resp.GetHead.Stale || !strings.HasPrefix(resp.GetHead.Warning, "head has not advanced for 2m") {
+		t.Errorf("expected stale head, got %+v", resp.GetHead)
+	}
+
+	health, err := handler.GetHealth(&GetHealthRequest{})
+	if err != nil || !health.HeadStale {
+		t.Errorf("expected stale head in health %+v, %v", health, err)
+	}
+
+	// A fork block at the head height does not advance the head, a higher block does
+	for _, block := range makeForkFrom(bt.ByNum[102], 2, 1) {
+		if _, err = handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp = handler.HandleExtendedRequest(&ExtendedRequest{GetHead: &GetHeadRequest{}})
+		if resp.GetHead.Stale != (block.GetHeader().GetHeight() == 3) {
+			t.Errorf("unexpected staleness at height %d, %+v", block.GetHeader().GetHeight(), resp.GetHead)
+		}
+	}
+}
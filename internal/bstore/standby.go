@@ -0,0 +1,271 @@
+package bstore
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+const (
+	// DefaultStandbyPollInterval is the default interval at which a standby asks its primary for new
+	// writes once it has caught up
+	DefaultStandbyPollInterval = time.Second
+
+	// walBatchSize bounds the keys and values of the write-ahead log entries returned at once
+	walBatchSize = 4 << 20
+)
+
+// GetWALRequest asks for the entries of the write-ahead log after Position, up to about MaxBytes of
+// keys and values. A MaxBytes of 0 uses the default of 4 MiB.
+type GetWALRequest struct {
+	Position uint64 `json:"position"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+}
+
+// GetWALResponse holds the requested entries, and the position of the last entry in the log
+type GetWALResponse struct {
+	Entries  []*WALEntry `json:"entries"`
+	Position uint64      `json:"position"`
+}
+
+// PromoteStandbyRequest asks a standby to stop following its primary and accept writes
+type PromoteStandbyRequest struct {
+}
+
+// PromoteStandbyResponse reports the position of the log the standby was promoted at
+type PromoteStandbyResponse struct {
+	Position uint64 `json:"position"`
+}
+
+// GetWAL returns the entries of the write-ahead log after a position, for a standby to apply
+func (handler *RequestHandler) GetWAL(req *GetWALRequest) (*GetWALResponse, error) {
+	if handler.WAL == nil {
+		return nil, errors.New("write-ahead log is not enabled")
+	}
+
+	// Values are hex encoded, doubling their size in the response
+	maxBytes := req.MaxBytes
+	if maxBytes <= 0 || maxBytes > walBatchSize {
+		maxBytes = walBatchSize
+	}
+	if limit := handler.MaxResponseSize() / 4; maxBytes > limit {
+		maxBytes = limit
+	}
+
+	position := handler.WAL.Position()
+	entries, err := handler.WAL.WAL.Read(req.Position, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		entries = make([]*WALEntry, 0)
+	}
+
+	return &GetWALResponse{Entries: entries, Position: position}, nil
+}
+
+// PromoteStandby promotes a standby block store to a primary
+func (handler *RequestHandler) PromoteStandby(req *PromoteStandbyRequest) (*PromoteStandbyResponse, error) {
+	if handler.Standby == nil {
+		return nil, errors.New("block store is not a standby")
+	}
+
+	position, err := handler.Standby.Promote()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PromoteStandbyResponse{Position: position}, nil
+}
+
+// StandbyStatus is the replication state of a standby
+type StandbyStatus struct {
+	// Position is the sequence number of the last applied write
+	Position uint64 `json:"position"`
+
+	// PrimaryPosition is the sequence number of the last write of the primary, when it was last asked
+	PrimaryPosition uint64 `json:"primary_position"`
+
+	Promoted bool `json:"promoted"`
+
+	// LastError is the last error following the primary, empty if none
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Standby follows the write-ahead log of a primary block store, applying its writes to the local
+// database so it can take over if the primary fails. Blocks received from the message queue are not
+// stored while following, the writes of the primary are applied instead, and other writes are
+// refused. Promote stops following and makes the block store a primary.
+//
+// A standby starts following at the position of its database, so an empty database follows the
+// primary from its first write, and a database restored from a backup of the primary continues from
+// the backup. If the primary no longer holds the entries after that position, the standby must be
+// seeded again from a recent backup.
+type Standby struct {
+	Handler *RequestHandler
+	Primary *RemoteBackend
+
+	// Interval is the interval at which the primary is asked for new writes once caught up
+	Interval time.Duration
+
+	lock            sync.Mutex
+	primaryPosition uint64
+	promoted        bool
+	lastError       string
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewStandby makes the block store of handler, whose backend must be its WAL, a standby of primary
+// and starts following it. An interval of 0 uses DefaultStandbyPollInterval.
+func NewStandby(handler *RequestHandler, primary *RemoteBackend, interval time.Duration) (*Standby, error) {
+	if handler.WAL == nil {
+		return nil, errors.New("a standby requires the write-ahead log")
+	}
+
+	if interval <= 0 {
+		interval = DefaultStandbyPollInterval
+	}
+
+	standby := &Standby{Handler: handler, Primary: primary, Interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
+	handler.WAL.setStandby(true)
+
+	go standby.run()
+	return standby, nil
+}
+
+func (standby *Standby) run() {
+	defer close(standby.done)
+
+	log.Infof("Following the primary block store from position %d", standby.Handler.WAL.Position())
+	for {
+		caughtUp, err := standby.poll()
+
+		standby.lock.Lock()
+		if err != nil && err.Error() != standby.lastError {
+			log.Warnf("Could not follow the primary block store, %s", err.Error())
+		}
+		if err != nil {
+			standby.lastError = err.Error()
+		} else {
+			standby.lastError = ""
+		}
+		standby.lock.Unlock()
+
+		if err == nil && !caughtUp {
+			select {
+			case <-standby.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-standby.stop:
+			return
+		case <-time.After(standby.Interval):
+		}
+	}
+}
+
+// poll applies the next writes of the primary, returning true once it has caught up
+func (standby *Standby) poll() (bool, error) {
+	wal := standby.Handler.WAL
+	resp, err := standby.Primary.admin(&AdminRequest{GetWAL: &GetWALRequest{Position: wal.Position(), MaxBytes: walBatchSize}})
+	if err != nil {
+		return false, err
+	}
+	if resp.GetWAL == nil {
+		return false, errors.New("primary block store returned an unexpected response")
+	}
+
+	if len(resp.GetWAL.Entries) > 0 {
+		// Writes are applied like blocks, readers see either none or all of them
+		standby.Handler.lock.Lock()
+		err = wal.Apply(resp.GetWAL.Entries)
+		standby.Handler.lock.Unlock()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	standby.lock.Lock()
+	standby.primaryPosition = resp.GetWAL.Position
+	standby.lock.Unlock()
+
+	return wal.Position() >= resp.GetWAL.Position, nil
+}
+
+// Promote stops following the primary and accepts writes, returning the position it was promoted at
+func (standby *Standby) Promote() (uint64, error) {
+	if standby.Promoted() {
+		return 0, errors.New("standby is already promoted")
+	}
+
+	// Blocks are only stored once the log accepts writes again
+	standby.Close()
+	standby.Handler.WAL.setStandby(false)
+
+	standby.lock.Lock()
+	standby.promoted = true
+	standby.lock.Unlock()
+
+	position := standby.Handler.WAL.Position()
+	log.Infof("Promoted to primary at position %d", position)
+	return position, nil
+}
+
+// Promoted returns true once the standby has been promoted
+func (standby *Standby) Promoted() bool {
+	standby.lock.Lock()
+	defer standby.lock.Unlock()
+
+	return standby.promoted
+}
+
+// Close stops following the primary
+func (standby *Standby) Close() {
+	standby.stopOnce.Do(func() {
+		close(standby.stop)
+		<-standby.done
+		standby.Primary.Close()
+	})
+}
+
+// Name implements IngestFilter
+func (standby *Standby) Name() string {
+	return "standby"
+}
+
+// Filter implements IngestFilter, blocks are only stored once the standby is promoted
+func (standby *Standby) Filter(req *block_store.AddBlockRequest) (*block_store.AddBlockRequest, bool) {
+	standby.lock.Lock()
+	defer standby.lock.Unlock()
+
+	return req, standby.promoted
+}
+
+// StatusName implements StatusReporter
+func (standby *Standby) StatusName() string {
+	return "standby"
+}
+
+// Status implements StatusReporter
+func (standby *Standby) Status() interface{} {
+	standby.lock.Lock()
+	defer standby.lock.Unlock()
+
+	return &StandbyStatus{
+		Position:        standby.Handler.WAL.Position(),
+		PrimaryPosition: standby.primaryPosition,
+		Promoted:        standby.promoted,
+		LastError:       standby.lastError,
+	}
+}
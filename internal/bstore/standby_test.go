@@ -0,0 +1,153 @@
+package bstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func newWALHandler(t *testing.T, dir string) *RequestHandler {
+	backend, err := NewWALBackend(NewMapBackend(), dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &RequestHandler{Backend: backend, WAL: backend, AdminSecret: "secret"}
+}
+
+func waitForStandby(t *testing.T, standby *Standby, position uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for standby.Handler.WAL.Position() < position {
+		if time.Now().After(deadline) {
+			t.Fatalf("standby did not reach position %d, %+v", position, standby.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStandby(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	primary := newWALHandler(t, filepath.Join(dir, "primary"))
+	defer primary.WAL.Close()
+	bt := buildLinearChain(t, primary, 20)
+
+	handler := newWALHandler(t, filepath.Join(dir, "standby"))
+	defer handler.WAL.Close()
+
+	standby, err := NewStandby(handler, NewRemoteBackend(&loopbackClient{handler: primary}, "secret"), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Close()
+	handler.Standby = standby
+
+	waitForStandby(t, standby, primary.WAL.Position())
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: bt.ByNum[120].GetId(), StartHeight: 1}})
+	if resp.Error != nil || !resp.VerifyChainLinks.Valid {
+		t.Errorf("expected the chain of the primary on the standby, got %+v", resp)
+	}
+
+	// Writes of the primary keep being followed
+	if err = primary.Backend.Put([]byte("key"), []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err = primary.Backend.Delete(blockRecordKey(bt.ByNum[101].GetId())); err != nil {
+		t.Fatal(err)
+	}
+	waitForStandby(t, standby, primary.WAL.Position())
+
+	if value, err := handler.Backend.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte{1, 2, 3}) {
+		t.Errorf("expected the value written on the primary, got %x, %v", value, err)
+	}
+	if value, err := handler.Backend.Get(blockRecordKey(bt.ByNum[101].GetId())); err != nil || len(value) != 0 {
+		t.Errorf("expected the block deleted on the primary to be removed, got %v", err)
+	}
+
+	// The standby refuses other writes and does not store blocks until it is promoted
+	if err = handler.Backend.Put([]byte("local"), []byte{1}); err == nil {
+		t.Error("expected the standby to refuse writes")
+	}
+	if _, ok := standby.Filter(&block_store.AddBlockRequest{}); ok {
+		t.Error("expected the standby to drop blocks")
+	}
+
+	admin := handler.HandleExtendedRequest(&ExtendedRequest{Admin: &AdminRequest{Secret: "secret", PromoteStandby: &PromoteStandbyRequest{}}})
+	if admin.Error != nil {
+		t.Fatal(admin.Error.Message)
+	}
+	if admin.Admin.PromoteStandby.Position != primary.WAL.Position() {
+		t.Errorf("expected promotion at position %d, got %d", primary.WAL.Position(), admin.Admin.PromoteStandby.Position)
+	}
+
+	if err = handler.Backend.Put([]byte("local"), []byte{1}); err != nil {
+		t.Errorf("expected the promoted standby to accept writes, %v", err)
+	}
+	if _, ok := standby.Filter(&block_store.AddBlockRequest{}); !ok {
+		t.Error("expected the promoted standby to store blocks")
+	}
+	if handler.WAL.Position() != primary.WAL.Position()+1 {
+		t.Errorf("expected the log to continue after the position of the primary, got %d", handler.WAL.Position())
+	}
+
+	if _, err = standby.Promote(); err == nil {
+		t.Error("expected an error promoting twice")
+	}
+}
+
+func TestWALBackendPosition(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	inner := NewMapBackend()
+	backend, err := NewWALBackend(inner, dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err = backend.Put([]byte{0xf0, byte(i)}, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend.Close()
+
+	// The position is kept in the database, a copy of it continues the log from there
+	backend, err = NewWALBackend(inner, dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if backend.Position() != 5 {
+		t.Errorf("expected position 5, got %d", backend.Position())
+	}
+
+	handler := RequestHandler{Backend: backend, WAL: backend}
+	resp, err := handler.GetWAL(&GetWALRequest{Position: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Position != 5 || len(resp.Entries) != 3 || resp.Entries[0].Seq != 3 {
+		t.Errorf("unexpected entries %+v", resp)
+	}
+
+	if err = backend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if backend.Position() != 0 {
+		t.Errorf("expected the log to restart on reset, got position %d", backend.Position())
+	}
+}
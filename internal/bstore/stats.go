@@ -0,0 +1,292 @@
+package bstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+var errStatsUnsupported = errors.New("backend does not report statistics")
+
+type statsBackend interface {
+	Stats() (*BackendStats, error)
+}
+
+// BackendStats reports the size of a backend's database
+type BackendStats struct {
+	// Keys is the approximate number of stored keys, nil if the backend cannot count them cheaply
+	Keys *uint64
+
+	// DiskSize is the approximate size of the database on disk in bytes, 0 for in-memory backends
+	DiskSize int64
+
+	// Levels describes the levels of the LSM tree of the database, empty for other backends
+	Levels []*LevelStats
+
+	// LastCompaction is the time of the last compaction since the backend was opened, nil if none
+	LastCompaction *time.Time
+}
+
+// LevelStats describes a level of an LSM tree
+type LevelStats struct {
+	Level  int     `json:"level"`
+	Tables int     `json:"tables"`
+	Size   int64   `json:"size"`
+	Score  float64 `json:"score"`
+}
+
+// GetStoreInfoRequest asks for statistics of the block store database
+type GetStoreInfoRequest struct {
+}
+
+// GetStoreInfoResponse reports statistics of the block store database. Keys is null if the backend
+// cannot count its keys cheaply, LastCompaction is null if the database was not compacted since the
+// block store started.
+type GetStoreInfoResponse struct {
+	Keys           *uint64       `json:"keys"`
+	DiskSizeBytes  int64         `json:"disk_size_bytes"`
+	Levels         []*LevelStats `json:"levels"`
+	LastCompaction *time.Time    `json:"last_compaction"`
+}
+
+// GetStoreInfo returns statistics of the block store database. Like GetHealth it is not blocked by
+// long running writes.
+func (handler *RequestHandler) GetStoreInfo(req *GetStoreInfoRequest) (*GetStoreInfoResponse, error) {
+	stats, err := backendStats(handler.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &GetStoreInfoResponse{
+		Keys:           stats.Keys,
+		DiskSizeBytes:  stats.DiskSize,
+		Levels:         stats.Levels,
+		LastCompaction: stats.LastCompaction,
+	}
+	if resp.Levels == nil {
+		resp.Levels = make([]*LevelStats, 0)
+	}
+
+	return resp, nil
+}
+
+// statsMetrics returns gauges of the backend statistics, none if the backend does not report them
+func (handler *RequestHandler) statsMetrics() ([]*Metric, error) {
+	stats, err := backendStats(handler.Backend)
+	if err == errStatsUnsupported {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	metrics := []*Metric{{Name: "block_store_disk_size_bytes", Value: float64(stats.DiskSize)}}
+	if stats.Keys != nil {
+		metrics = append(metrics, &Metric{Name: "block_store_keys", Value: float64(*stats.Keys)})
+	}
+	if stats.LastCompaction != nil {
+		metrics = append(metrics, &Metric{Name: "block_store_last_compaction_timestamp_seconds", Value: float64(stats.LastCompaction.Unix())})
+	}
+
+	// Samples of the same name must be adjacent
+	for _, level := range stats.Levels {
+		metrics = append(metrics, &Metric{Name: "block_store_lsm_level_tables", Labels: map[string]string{"level": strconv.Itoa(level.Level)}, Value: float64(level.Tables)})
+	}
+	for _, level := range stats.Levels {
+		metrics = append(metrics, &Metric{Name: "block_store_lsm_level_size_bytes", Labels: map[string]string{"level": strconv.Itoa(level.Level)}, Value: float64(level.Size)})
+	}
+
+	return metrics, nil
+}
+
+// unixNanoTime returns the time of a Unix timestamp in nanoseconds, nil if it is 0
+func unixNanoTime(nanos int64) *time.Time {
+	if nanos == 0 {
+		return nil
+	}
+
+	t := time.Unix(0, nanos).UTC()
+	return &t
+}
+
+// backendStats returns the statistics of backend, errStatsUnsupported if it does not report them.
+// Wrapping backends report the statistics of the backend they wrap.
+func backendStats(backend BlockStoreBackend) (*BackendStats, error) {
+	inner, ok := backend.(statsBackend)
+	if !ok {
+		return nil, errStatsUnsupported
+	}
+
+	return inner.Stats()
+}
+
+// dirSize returns the total size of the files in dir and its subdirectories
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+// StoreScan reports the contents of the block store database, found by reading every record
+type StoreScan struct {
+	Blocks       uint64 `json:"blocks"`
+	LowestHeight uint64 `json:"lowest_height"`
+
+	// HighestHeight is the height of the highest stored block, HeadHeight that of the highest block
+	// record
+	HighestHeight      uint64 `json:"highest_height"`
+	HeadHeight         uint64 `json:"head_height"`
+	IrreversibleHeight uint64 `json:"irreversible_height"`
+	CheckpointHeight   uint64 `json:"checkpoint_height,omitempty"`
+
+	// ForkHeights is the number of heights with more than one block, ForkBlocks the number of blocks
+	// beyond the first at those heights, as recorded by the height index
+	ForkHeights uint64 `json:"fork_heights"`
+	ForkBlocks  uint64 `json:"fork_blocks"`
+
+	RecordTypes   []*RecordTypeStats `json:"record_types"`
+	LargestBlocks []*BlockSize       `json:"largest_blocks"`
+
+	// DiskSizeBytes is the size of the database on disk, null if the backend does not report it
+	DiskSizeBytes *int64 `json:"disk_size_bytes"`
+}
+
+// RecordTypeStats reports the records of one type. Bytes is the size of their keys and values as
+// read, before compression and encryption.
+type RecordTypeStats struct {
+	Type    string `json:"type"`
+	Records uint64 `json:"records"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// BlockSize is the size of a stored block record
+type BlockSize struct {
+	BlockID     HexBytes `json:"block_id"`
+	BlockHeight uint64   `json:"block_height"`
+	Size        uint64   `json:"size"`
+}
+
+// recordType names the type of the record stored under key
+func recordType(key []byte) string {
+	switch {
+	case len(key) == 0:
+		return "other"
+	case key[0] == blockRecordPrefix || key[0] == blockRecordMultihashPrefix:
+		return "block_records"
+	case key[0] >= legacyBlockKeyFirstByte:
+		return "legacy_block_records"
+	case key[0] == heightIndexPrefix:
+		return "height_index"
+	case key[0] == blockMetadataPrefix:
+		return "block_metadata"
+	case key[0] == payerIndexPrefix:
+		return "payer_index"
+	case NamespaceMetadata.Contains(key):
+		return "metadata"
+	default:
+		return "other"
+	}
+}
+
+// ScanStore reads every record of the database and reports its contents, with the largest block
+// records. Reading a full node's database takes a while, so it is meant to run offline.
+func (handler *RequestHandler) ScanStore(largest int) (*StoreScan, error) {
+	scan := &StoreScan{RecordTypes: []*RecordTypeStats{}, LargestBlocks: []*BlockSize{}}
+	types := make(map[string]*RecordTypeStats)
+
+	err := handler.Backend.Iterate(nil, func(key []byte, value []byte) error {
+		name := recordType(key)
+		stats, ok := types[name]
+		if !ok {
+			stats = &RecordTypeStats{Type: name}
+			types[name] = stats
+			scan.RecordTypes = append(scan.RecordTypes, stats)
+		}
+		stats.Records++
+		stats.Bytes += uint64(len(key) + len(value))
+
+		switch name {
+		case "block_records", "legacy_block_records":
+			record := &block_store.BlockRecord{}
+			if err := proto.Unmarshal(value, record); err != nil {
+				return err
+			}
+
+			height := record.GetBlockHeight()
+			if scan.Blocks == 0 || height < scan.LowestHeight {
+				scan.LowestHeight = height
+			}
+			if height > scan.HighestHeight {
+				scan.HighestHeight = height
+			}
+			scan.Blocks++
+
+			// The largest blocks are kept in descending size order
+			size := uint64(len(value))
+			if len(scan.LargestBlocks) < largest || (largest > 0 && size > scan.LargestBlocks[len(scan.LargestBlocks)-1].Size) {
+				i := sort.Search(len(scan.LargestBlocks), func(i int) bool { return scan.LargestBlocks[i].Size < size })
+				block := &BlockSize{BlockID: record.GetBlockId(), BlockHeight: height, Size: size}
+				scan.LargestBlocks = append(scan.LargestBlocks[:i], append([]*BlockSize{block}, scan.LargestBlocks[i:]...)...)
+				if len(scan.LargestBlocks) > largest {
+					scan.LargestBlocks = scan.LargestBlocks[:largest]
+				}
+			}
+		case "height_index":
+			ids, err := decodeHeightIndex(value)
+			if err != nil {
+				return err
+			}
+			if len(ids) > 1 {
+				scan.ForkHeights++
+				scan.ForkBlocks += uint64(len(ids) - 1)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(scan.RecordTypes, func(i, j int) bool { return scan.RecordTypes[i].Bytes > scan.RecordTypes[j].Bytes })
+
+	for key, height := range map[byte]*uint64{highestBlockKey: &scan.HeadHeight, irreversibleKey: &scan.IrreversibleHeight} {
+		topology, err := handler.getTopologyAtKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if topology != nil {
+			*height = topology.Height
+		}
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil {
+		scan.CheckpointHeight = checkpoint.Height
+	}
+
+	if stats, err := backendStats(handler.Backend); err == nil {
+		scan.DiskSizeBytes = &stats.DiskSize
+	} else if err != errStatsUnsupported {
+		return nil, err
+	}
+
+	return scan, nil
+}
@@ -0,0 +1,133 @@
+package bstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestGetStoreInfo(t *testing.T) {
+	backend := NewMapBackend()
+	handler := RequestHandler{Backend: backend}
+	buildLinearChain(t, &handler, 5)
+
+	keys := 0
+	if err := backend.Iterate(nil, func(key []byte, value []byte) error {
+		keys++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetStoreInfo: &GetStoreInfoRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if resp.GetStoreInfo.Keys == nil || *resp.GetStoreInfo.Keys != uint64(keys) {
+		t.Errorf("expected %d keys, got %v", keys, resp.GetStoreInfo.Keys)
+	}
+	if resp.GetStoreInfo.DiskSizeBytes != 0 || len(resp.GetStoreInfo.Levels) != 0 || resp.GetStoreInfo.LastCompaction != nil {
+		t.Errorf("unexpected statistics of an in-memory backend %+v", resp.GetStoreInfo)
+	}
+
+	// Wrappers and remote block stores report the statistics of the database they store in
+	remote := NewRemoteBackend(&loopbackClient{handler: &handler}, "")
+	stats, err := NewMetricsBackend(remote, NewMetrics()).Stats()
+	if err != nil || stats.Keys == nil || *stats.Keys != uint64(keys) {
+		t.Errorf("expected the statistics of the remote block store, got %+v, %v", stats, err)
+	}
+
+	b := NewBackend(BadgerBackendType)
+	defer CloseBackend(b)
+	handler = RequestHandler{Backend: b}
+	buildLinearChain(t, &handler, 5)
+
+	before := time.Now()
+	if _, err = handler.CompactStore(&CompactStoreRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := handler.GetStoreInfo(&GetStoreInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Keys == nil || info.DiskSizeBytes <= 0 || len(info.Levels) == 0 {
+		t.Errorf("expected the size and levels of the database, got %+v", info)
+	}
+	if info.LastCompaction == nil || info.LastCompaction.Before(before) {
+		t.Errorf("expected compaction after %v, got %v", before, info.LastCompaction)
+	}
+
+	metrics, err := handler.CollectMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]int)
+	for _, metric := range metrics {
+		names[metric.Name]++
+	}
+	if names["block_store_disk_size_bytes"] != 1 || names["block_store_last_compaction_timestamp_seconds"] != 1 || names["block_store_lsm_level_size_bytes"] != len(info.Levels) {
+		t.Errorf("expected gauges of the statistics, got %v", names)
+	}
+
+	// A backend which does not report statistics has no such gauges
+	handler = RequestHandler{Backend: NewNullBackend()}
+	if _, err = handler.GetStoreInfo(&GetStoreInfoRequest{}); err == nil {
+		t.Error("expected an error from a backend without statistics")
+	}
+	remote = NewRemoteBackend(&loopbackClient{handler: &handler}, "")
+	if _, err = remote.Stats(); err != errStatsUnsupported {
+		t.Errorf("expected the remote backend not to report statistics, got %v", err)
+	}
+	if _, err = handler.CollectMetrics(); err != nil {
+		t.Errorf("expected metrics without statistics, got %v", err)
+	}
+}
+
+func TestScanStore(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 10)
+
+	// A fork of two blocks from height 6
+	for _, block := range makeForkFrom(bt.ByNum[105], 2, 1) {
+		for nonce := uint64(0); nonce < 10; nonce++ {
+			block.Transactions = append(block.Transactions, makePayerTransaction("alice", block.GetHeader().GetHeight()*100+nonce))
+		}
+		if _, err := handler.AddBlock(&block_store.AddBlockRequest{BlockToAdd: block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan, err := handler.ScanStore(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.Blocks != 12 || scan.LowestHeight != 1 || scan.HighestHeight != 10 || scan.HeadHeight != 10 {
+		t.Errorf("unexpected block counts %+v", scan)
+	}
+	if scan.ForkHeights != 2 || scan.ForkBlocks != 2 {
+		t.Errorf("expected 2 fork blocks at 2 heights, got %d at %d", scan.ForkBlocks, scan.ForkHeights)
+	}
+
+	// The fork blocks carry transactions, they are the largest
+	if len(scan.LargestBlocks) != 3 || scan.LargestBlocks[0].Size < scan.LargestBlocks[1].Size || scan.LargestBlocks[1].Size < scan.LargestBlocks[2].Size {
+		t.Fatalf("expected the 3 largest blocks in descending size order, got %d", len(scan.LargestBlocks))
+	}
+	if scan.LargestBlocks[0].BlockHeight < 6 || scan.LargestBlocks[1].BlockHeight < 6 {
+		t.Errorf("expected the fork blocks to be the largest, got heights %d and %d", scan.LargestBlocks[0].BlockHeight, scan.LargestBlocks[1].BlockHeight)
+	}
+
+	types := make(map[string]*RecordTypeStats)
+	for _, stats := range scan.RecordTypes {
+		types[stats.Type] = stats
+	}
+	if types["block_records"] == nil || types["block_records"].Records != 12 || types["height_index"].Records != 10 || types["payer_index"] == nil || types["metadata"] == nil {
+		t.Errorf("unexpected record types %v", types)
+	}
+	for i := 1; i < len(scan.RecordTypes); i++ {
+		if scan.RecordTypes[i].Bytes > scan.RecordTypes[i-1].Bytes {
+			t.Error("expected the record types in descending size order")
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package bstore
+
+import (
+	"sync/atomic"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"google.golang.org/protobuf/proto"
+)
+
+// StatusReporter is implemented by components which contribute to the block store status, such as
+// the checkpoint publisher. The status is reported under StatusName and must be JSON serializable.
+type StatusReporter interface {
+	StatusName() string
+	Status() interface{}
+}
+
+type statusFunc struct {
+	name string
+	fn   func() interface{}
+}
+
+func (s *statusFunc) StatusName() string {
+	return s.name
+}
+
+func (s *statusFunc) Status() interface{} {
+	return s.fn()
+}
+
+// NewStatusReporter returns a StatusReporter which reports the result of fn under name
+func NewStatusReporter(name string, fn func() interface{}) StatusReporter {
+	return &statusFunc{name: name, fn: fn}
+}
+
+// GetStatusRequest asks for the aggregate status of the block store
+type GetStatusRequest struct {
+}
+
+// StoreStatus describes the chain held by the block store
+type StoreStatus struct {
+	// Head is the highest block, nil if the store is empty
+	Head *Topology `json:"head"`
+
+	// Irreversible is the last irreversible block, nil if none has been received
+	Irreversible *Topology `json:"irreversible"`
+
+	// CheckpointHeight is the height of the checkpoint the store was bootstrapped from, 0 if none
+	CheckpointHeight uint64 `json:"checkpoint_height"`
+
+	SchemaVersion string `json:"schema_version"`
+
+	// ChainID is the configured chain ID, omitted if none is configured
+	ChainID HexBytes `json:"chain_id,omitempty"`
+}
+
+// MaintenanceStatus reports which maintenance jobs are running. A restore reports as both a
+// compaction and a backup, as it excludes both.
+type MaintenanceStatus struct {
+	Compacting bool `json:"compacting"`
+	BackingUp  bool `json:"backing_up"`
+	Exporting  bool `json:"exporting"`
+}
+
+// GetStatusResponse is the aggregate status of the block store
+type GetStatusResponse struct {
+	Store       StoreStatus       `json:"store"`
+	Maintenance MaintenanceStatus `json:"maintenance"`
+
+	// Components holds the status of each registered StatusReporter by name
+	Components map[string]interface{} `json:"components"`
+}
+
+// GetStatus returns the aggregate status of the block store and its registered components
+func (handler *RequestHandler) GetStatus(req *GetStatusRequest) (*GetStatusResponse, error) {
+	resp := &GetStatusResponse{
+		Store: StoreStatus{SchemaVersion: SchemaVersion, ChainID: handler.ChainID},
+		Maintenance: MaintenanceStatus{
+			Compacting: atomic.LoadInt32(&handler.compacting) != 0,
+			BackingUp:  atomic.LoadInt32(&handler.backingUp) != 0,
+			Exporting:  atomic.LoadInt32(&handler.exporting) != 0,
+		},
+		Components: make(map[string]interface{}),
+	}
+
+	var err error
+	resp.Store.Head, err = handler.getTopologyAtKey(highestBlockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Store.Irreversible, err = handler.getTopologyAtKey(irreversibleKey)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil {
+		resp.Store.CheckpointHeight = checkpoint.Height
+	}
+
+	for _, reporter := range handler.StatusReporters {
+		resp.Components[reporter.StatusName()] = reporter.Status()
+	}
+
+	return resp, nil
+}
+
+// getTopologyAtKey reads a block topology stored under a metadata key, returning nil if it is not set
+func (handler *RequestHandler) getTopologyAtKey(key byte) (*Topology, error) {
+	value, err := handler.Backend.Get([]byte{key})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	topology := &koinos.BlockTopology{}
+	if err = proto.Unmarshal(value, topology); err != nil {
+		return nil, &DeserializeError{}
+	}
+
+	return &Topology{ID: topology.GetId(), Height: topology.GetHeight(), Previous: topology.GetPrevious()}, nil
+}
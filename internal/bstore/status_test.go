@@ -0,0 +1,100 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+)
+
+func TestGetStatus(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetStatus: &GetStatusRequest{}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+		if resp.GetStatus.Store.Head != nil || resp.GetStatus.Store.Irreversible != nil || len(resp.GetStatus.Components) != 0 {
+			t.Errorf("unexpected status of empty store %+v", resp.GetStatus)
+		}
+
+		bt := buildLinearChain(t, &handler, 10)
+		irreversible := bt.ByNum[105]
+		err := handler.UpdateIrreversibleBlock(&koinos.BlockTopology{Id: irreversible.GetId(), Height: 5, Previous: irreversible.GetHeader().GetPrevious()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler.StatusReporters = append(handler.StatusReporters, NewStatusReporter("test", func() interface{} {
+			return map[string]int{"lag": 3}
+		}))
+		atomic.StoreInt32(&handler.exporting, 1)
+
+		resp = handler.HandleExtendedRequest(&ExtendedRequest{GetStatus: &GetStatusRequest{}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+
+		status := resp.GetStatus
+		if status.Store.Head == nil || status.Store.Head.Height != 10 || !bytes.Equal(status.Store.Head.ID, bt.ByNum[110].GetId()) {
+			t.Errorf("unexpected head %+v", status.Store.Head)
+		}
+		if status.Store.Irreversible == nil || status.Store.Irreversible.Height != 5 {
+			t.Errorf("unexpected irreversible block %+v", status.Store.Irreversible)
+		}
+		if !status.Maintenance.Exporting || status.Maintenance.Compacting || status.Maintenance.BackingUp {
+			t.Errorf("unexpected maintenance status %+v", status.Maintenance)
+		}
+
+		data, err := json.Marshal(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte(`"components":{"test":{"lag":3}}`)) {
+			t.Errorf("unexpected components %s", string(data))
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestChainID(t *testing.T) {
+	chainID := []byte{0x01, 0x02}
+	handler := RequestHandler{Backend: NewMapBackend(), ChainID: chainID}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{GetStatus: &GetStatusRequest{}})
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if !bytes.Equal(resp.ChainID, chainID) || !bytes.Equal(resp.GetStatus.Store.ChainID, chainID) {
+		t.Errorf("expected chain ID %x, got %x and %x", chainID, resp.ChainID, resp.GetStatus.Store.ChainID)
+	}
+
+	// Errors are tagged as well
+	resp = handler.HandleExtendedRequest(&ExtendedRequest{})
+	if resp.Error == nil || !bytes.Equal(resp.ChainID, chainID) {
+		t.Errorf("expected error tagged with chain ID, got %+v", resp)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"chain_id":"0x0102"`)) {
+		t.Errorf("unexpected response %s", string(data))
+	}
+
+	// The chain ID is omitted if not configured
+	handler.ChainID = nil
+	data, err = json.Marshal(handler.HandleExtendedRequest(&ExtendedRequest{GetStatus: &GetStatusRequest{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("chain_id")) {
+		t.Errorf("unexpected chain ID in %s", string(data))
+	}
+}
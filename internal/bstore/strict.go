@@ -0,0 +1,118 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	jsonUnknownFieldPrefix = "json: unknown field "
+)
+
+// UnknownFieldsError is an error type for requests containing fields this block store does not know.
+// It is only returned in strict mode, otherwise unknown fields are ignored.
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("Request contains unknown fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// Code returns the error code
+func (e *UnknownFieldsError) Code() ErrorCode {
+	return ErrorCodeUnknownFields
+}
+
+// Details returns the paths of the unknown fields
+func (e *UnknownFieldsError) Details() map[string]interface{} {
+	fields := make([]interface{}, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = field
+	}
+
+	return map[string]interface{}{"fields": fields}
+}
+
+// UnknownFields returns the paths of the fields in m, and in the messages nested within it, which are
+// not part of this binary's schema. Known fields are named, unknown fields are given by number, for
+// example "get_blocks_by_height.#12".
+func UnknownFields(m protoreflect.Message) []string {
+	return appendUnknownFields(nil, "", m)
+}
+
+func appendUnknownFields(fields []string, prefix string, m protoreflect.Message) []string {
+	unknown := m.GetUnknown()
+	for len(unknown) > 0 {
+		num, _, n := protowire.ConsumeField(unknown)
+		if n < 0 {
+			fields = append(fields, prefix+"#?")
+			break
+		}
+		fields = append(fields, prefix+"#"+strconv.Itoa(int(num)))
+		unknown = unknown[n:]
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind {
+			return true
+		}
+
+		path := prefix + string(fd.Name())
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				fields = appendUnknownFields(fields, fmt.Sprintf("%s[%d].", path, i), list.Get(i).Message())
+			}
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.MessageKind {
+				v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+					fields = appendUnknownFields(fields, fmt.Sprintf("%s[%v].", path, k.Interface()), mv.Message())
+					return true
+				})
+			}
+		default:
+			fields = appendUnknownFields(fields, path+".", v.Message())
+		}
+
+		return true
+	})
+
+	return fields
+}
+
+// DecodeExtendedRequest parses a serialized ExtendedRequest. In strict mode, fields which are not part
+// of the request are rejected with an UnknownFieldsError rather than ignored.
+func DecodeExtendedRequest(data []byte, strict bool) (*ExtendedRequest, error) {
+	req := &ExtendedRequest{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(req); err != nil {
+		if strict && strings.HasPrefix(err.Error(), jsonUnknownFieldPrefix) {
+			field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), jsonUnknownFieldPrefix))
+			return nil, &UnknownFieldsError{Fields: []string{field}}
+		}
+
+		return nil, &MalformedRequestError{Err: err}
+	}
+
+	// Match json.Unmarshal, which rejects anything following the request
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, &MalformedRequestError{Err: errors.New("invalid data after top-level value")}
+	}
+
+	return req, nil
+}
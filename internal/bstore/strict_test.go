@@ -0,0 +1,72 @@
+package bstore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStrictRequests(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 5)
+
+	inner := &block_store.GetBlocksByHeightRequest{HeadBlockId: bt.ByNum[105].GetId(), AncestorStartHeight: 1, NumBlocks: 5}
+	inner.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 12, protowire.VarintType), 1))
+	req := &block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksByHeight{GetBlocksByHeight: inner}}
+
+	// Unknown fields are ignored by default
+	if _, ok := handler.HandleRequest(req).GetResponse().(*block_store.BlockStoreResponse_GetBlocksByHeight); !ok {
+		t.Error("expected unknown fields to be ignored")
+	}
+
+	handler.Strict = true
+	errval, ok := handler.HandleRequest(req).GetResponse().(*block_store.BlockStoreResponse_Error)
+	if !ok {
+		t.Fatal("expected unknown fields to be rejected")
+	}
+	if ErrorCodeOfStatus(errval.Error) != ErrorCodeUnknownFields {
+		t.Errorf("unexpected error %s", errval.Error.Message)
+	}
+	if fields := UnknownFields(req.ProtoReflect()); !reflect.DeepEqual(fields, []string{"get_blocks_by_height.#12"}) {
+		t.Errorf("unexpected unknown fields %v", fields)
+	}
+
+	reserved := &block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_Reserved{Reserved: &rpc.ReservedRpc{}}}
+	errval, ok = handler.HandleRequest(reserved).GetResponse().(*block_store.BlockStoreResponse_Error)
+	if !ok || ErrorCodeOfStatus(errval.Error) != ErrorCodeReservedRequest {
+		t.Error("expected reserved request to be rejected")
+	}
+
+	// Unknown fields survive serialization, as they do when received over the bus
+	data, _ := proto.Marshal(req)
+	parsed := &block_store.BlockStoreRequest{}
+	if err := proto.Unmarshal(data, parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(UnknownFields(parsed.ProtoReflect())) != 1 {
+		t.Error("expected unknown field after round trip")
+	}
+}
+
+func TestDecodeExtendedRequest(t *testing.T) {
+	data := []byte(`{"get_status":{},"get_everything":{}}`)
+
+	req, err := DecodeExtendedRequest(data, false)
+	if err != nil || req.GetStatus == nil {
+		t.Errorf("expected unknown field to be ignored, got %v", err)
+	}
+
+	_, err = DecodeExtendedRequest(data, true)
+	if uerr, ok := err.(*UnknownFieldsError); !ok || !reflect.DeepEqual(uerr.Fields, []string{"get_everything"}) {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+
+	_, err = DecodeExtendedRequest([]byte(`{"get_status":{}} {}`), false)
+	if ErrorCodeOf(err) != ErrorCodeMalformedRequest {
+		t.Errorf("expected trailing data to be rejected, got %v", err)
+	}
+}
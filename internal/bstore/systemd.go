@@ -0,0 +1,117 @@
+package bstore
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// The states sent to systemd
+const (
+	SystemdReady    = "READY=1"
+	SystemdStopping = "STOPPING=1"
+	SystemdWatchdog = "WATCHDOG=1"
+)
+
+// SystemdNotifier sends the state of the block store to systemd over the notification socket of a
+// service of Type=notify
+type SystemdNotifier struct {
+	Socket string
+
+	// WatchdogInterval is the watchdog timeout of the service, 0 if the watchdog is disabled
+	WatchdogInterval time.Duration
+}
+
+// NewSystemdNotifier returns a notifier for the socket systemd passes in NOTIFY_SOCKET, or nil if
+// the block store was not started by systemd
+func NewSystemdNotifier() *SystemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return nil
+	}
+
+	notifier := &SystemdNotifier{Socket: socket}
+
+	// The watchdog applies to this process only if WATCHDOG_PID, when set, names it
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) == 0 || pid == strconv.Itoa(os.Getpid()) {
+		if usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 63); err == nil {
+			notifier.WatchdogInterval = time.Duration(usec) * time.Microsecond
+		}
+	}
+
+	return notifier
+}
+
+// Notify sends state to systemd
+func (n *SystemdNotifier) Notify(state string) error {
+	// A leading @ names a socket in the abstract namespace
+	socket := n.Socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Watchdog keeps the systemd watchdog of the service from expiring while the AMQP consumers serve
+// requests and broadcasts, so systemd restarts a block store whose consumers are wedged. Handled is
+// called by the consumers after each message, and the watchdog is notified every half interval if
+// one was handled since the last notification. An idle block store is checked by sending a
+// get_health request through the AMQP server, which is served by the same consumers.
+type Watchdog struct {
+	Notifier *SystemdNotifier
+	Client   RPCClient
+
+	handled int32
+}
+
+// Handled records that a consumer handled a message
+func (w *Watchdog) Handled() {
+	atomic.StoreInt32(&w.handled, 1)
+}
+
+// Start notifies the watchdog until ctx is done. It does nothing if the watchdog is disabled.
+func (w *Watchdog) Start(ctx context.Context) {
+	if w.Notifier == nil || w.Notifier.WatchdogInterval <= 0 {
+		return
+	}
+
+	interval := w.Notifier.WatchdogInterval / 2
+	log.Infof("Notifying the systemd watchdog every %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			if atomic.SwapInt32(&w.handled, 0) == 0 {
+				if err := pingAMQP(ctx, w.Client, interval); err != nil {
+					log.Warnf("Consumers did not answer, not notifying the systemd watchdog: %s", err)
+					continue
+				}
+			}
+
+			if err := w.Notifier.Notify(SystemdWatchdog); err != nil {
+				log.Warnf("Unable to notify the systemd watchdog: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package bstore
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket listens on a notification socket in a temporary directory
+func listenNotifySocket(t *testing.T) (*net.UnixConn, string) {
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, socket
+}
+
+// readNotification returns the next state sent to the socket, or an empty string if none is sent
+// before the timeout
+func readNotification(t *testing.T, conn *net.UnixConn, timeout time.Duration) string {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	conn, socket := listenNotifySocket(t)
+
+	notifier := &SystemdNotifier{Socket: socket}
+	if err := notifier.Notify(SystemdReady); err != nil {
+		t.Fatal(err)
+	}
+	if state := readNotification(t, conn, time.Second); state != SystemdReady {
+		t.Errorf("expected %v, got %q", SystemdReady, state)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	conn, socket := listenNotifySocket(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Consumers which answer keep the watchdog notified while idle
+	handler := &RequestHandler{Backend: NewMapBackend()}
+	notifier := &SystemdNotifier{Socket: socket, WatchdogInterval: 40 * time.Millisecond}
+	watchdog := &Watchdog{Notifier: notifier, Client: &loopbackClient{handler: handler}}
+	watchdog.Start(ctx)
+	if state := readNotification(t, conn, time.Second); state != SystemdWatchdog {
+		t.Errorf("expected %v, got %q", SystemdWatchdog, state)
+	}
+	cancel()
+
+	// Consumers which do not answer let it expire, unless they handled a message
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	watchdog = &Watchdog{Notifier: notifier, Client: &unreachableClient{}}
+	watchdog.Start(ctx)
+	for readNotification(t, conn, 10*time.Millisecond) != "" {
+		// Drop the notifications of the first watchdog
+	}
+	if state := readNotification(t, conn, 100*time.Millisecond); state != "" {
+		t.Errorf("expected no notification, got %q", state)
+	}
+
+	watchdog.Handled()
+	if state := readNotification(t, conn, time.Second); state != SystemdWatchdog {
+		t.Errorf("expected %v, got %q", SystemdWatchdog, state)
+	}
+}
@@ -0,0 +1,251 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// coldStubPrefix starts the local value of a record moved to cold storage, followed by the object
+// name. Block records are protobuf messages, which never start with a zero byte.
+var coldStubPrefix = []byte("\x00koinos-cold:")
+
+// TieredBackend keeps records in a local backend and moves the records handed to Offload to an
+// object store, leaving a small stub naming the object in their place. Reads of offloaded records
+// are served from the object store transparently.
+//
+// Compaction, backup and health requests are forwarded to the local backend. Backups only contain
+// the stubs of offloaded records, so restoring one requires the object store.
+type TieredBackend struct {
+	Backend BlockStoreBackend
+	Cold    ObjectStore
+
+	// Prefix is prepended to the object names, so several block stores can share a bucket
+	Prefix string
+
+	// lock orders writes against Offload replacing a record with its stub
+	lock sync.Mutex
+}
+
+// NewTieredBackend creates a TieredBackend over a local backend and an object store
+func NewTieredBackend(backend BlockStoreBackend, cold ObjectStore, prefix string) *TieredBackend {
+	return &TieredBackend{Backend: backend, Cold: cold, Prefix: prefix}
+}
+
+// Reset resets the local database. Objects in cold storage are not removed.
+func (backend *TieredBackend) Reset() error {
+	return backend.Backend.Reset()
+}
+
+// Put stores the value in the local database
+func (backend *TieredBackend) Put(key []byte, value []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return backend.Backend.Put(key, value)
+}
+
+// PutWithTTL stores the value in the local database with a TTL
+func (backend *TieredBackend) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return putWithTTL(backend.Backend, key, value, ttl)
+}
+
+// PutBatch stores the values in the local database
+func (backend *TieredBackend) PutBatch(pairs []*KeyValue) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return backend.Backend.PutBatch(pairs)
+}
+
+// Delete removes an item from the local database and its object from cold storage
+func (backend *TieredBackend) Delete(key []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	value, err := backend.Backend.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if name, ok := coldObjectName(value); ok {
+		if err = backend.Cold.Delete(name); err != nil {
+			return err
+		}
+	}
+
+	return backend.Backend.Delete(key)
+}
+
+// Get fetches the requested value from the local database, or from cold storage if it was offloaded
+func (backend *TieredBackend) Get(key []byte) ([]byte, error) {
+	value, err := backend.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.resolve(value)
+}
+
+// Has reports whether the local database stores key, offloaded records are stored as pointers
+func (backend *TieredBackend) Has(key []byte) (bool, error) {
+	return backend.Backend.Has(key)
+}
+
+// Iterate calls fn with the keys of the local database starting with prefix and their values, the
+// offloaded records are read from cold storage
+func (backend *TieredBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, func(key []byte, value []byte) error {
+		value, err := backend.resolve(value)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// Snapshot takes a snapshot of the local database. Offloaded records are read from cold storage, a
+// record removed after the snapshot was taken may no longer be found there.
+func (backend *TieredBackend) Snapshot() (BackendSnapshot, error) {
+	snapshot, err := backend.Backend.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueSnapshot{snapshot: snapshot, decode: func(key []byte, value []byte) ([]byte, error) {
+		return backend.resolve(value)
+	}}, nil
+}
+
+// Flush flushes the local database, records are durable in cold storage once offloaded
+func (backend *TieredBackend) Flush() error {
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction batching its writes, reads resolve offloaded records like Get
+func (backend *TieredBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// resolve returns a local value, or the record in cold storage if it is a stub
+func (backend *TieredBackend) resolve(value []byte) ([]byte, error) {
+	name, ok := coldObjectName(value)
+	if !ok {
+		return value, nil
+	}
+
+	value, err := backend.Cold.Get(name)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("offloaded record %s is missing from cold storage", name)
+	}
+
+	return value, err
+}
+
+// Offload moves a record to cold storage, returning false if it is missing or already offloaded.
+// The upload happens without blocking writes, the stub only replaces the record if it did not
+// change in the meantime.
+func (backend *TieredBackend) Offload(key []byte) (bool, error) {
+	value, err := backend.Backend.Get(key)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := coldObjectName(value); ok || len(value) == 0 {
+		return false, nil
+	}
+
+	name := backend.Prefix + hex.EncodeToString(key)
+	if err = backend.Cold.Put(name, value); err != nil {
+		return false, err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	current, err := backend.Backend.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, value) {
+		return false, nil
+	}
+
+	stub := append(append([]byte{}, coldStubPrefix...), name...)
+	if err = backend.Backend.Put(key, stub); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// coldObjectName returns the object name of an offloaded record's stub
+func coldObjectName(value []byte) (string, bool) {
+	if !bytes.HasPrefix(value, coldStubPrefix) {
+		return "", false
+	}
+
+	return string(value[len(coldStubPrefix):]), true
+}
+
+// Close closes the local backend, if it needs closing
+func (backend *TieredBackend) Close() {
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the local backend
+func (backend *TieredBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the local backend, including the stubs of offloaded records
+func (backend *TieredBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the local backend
+func (backend *TieredBackend) Load(r io.Reader) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return backend.Backend.Load(r)
+}
+
+// Health reports the health of the local backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *TieredBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the local database, records in cold storage are not counted
+func (backend *TieredBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
+
+// Recompress recompresses a value of the local backend. Stubs of offloaded records are too small to be
+// compressed.
+func (backend *TieredBackend) Recompress(key []byte) (bool, error) {
+	inner, ok := backend.Backend.(recompressBackend)
+	if !ok {
+		return false, errors.New("backend does not support compression")
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	return inner.Recompress(key)
+}
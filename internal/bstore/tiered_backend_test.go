@@ -0,0 +1,76 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos"
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestTieredBackend(t *testing.T) {
+	local := NewMapBackend()
+	cold := newMapObjectStore()
+	backend := NewTieredBackend(local, cold, "chain/")
+
+	handler := RequestHandler{Backend: backend}
+	bt := buildLinearChain(t, &handler, 10)
+
+	offloader := &ColdStorageOffloader{Handler: &handler, Backend: backend, Depth: 3}
+	if err := offloader.HandleIrreversible(&koinos.BlockTopology{Id: bt.ByNum[108].GetId(), Height: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks below height 5 are moved, the local database keeps a stub
+	if len(cold.objects) != 4 {
+		t.Errorf("expected 4 offloaded blocks, got %d", len(cold.objects))
+	}
+	id := bt.ByNum[104].GetId()
+	key := blockRecordKey(id)
+	if _, ok := cold.objects["chain/"+hex.EncodeToString(key)]; !ok {
+		t.Error("expected block 4 in cold storage")
+	}
+	if value, _ := local.Get(key); !bytes.HasPrefix(value, coldStubPrefix) {
+		t.Error("expected stub in the local database")
+	}
+	if value, _ := local.Get(blockRecordKey(bt.ByNum[105].GetId())); bytes.HasPrefix(value, coldStubPrefix) {
+		t.Error("expected block 5 to stay in the local database")
+	}
+	if status := offloader.Status().(*ColdStorageStatus); status.OffloadedHeight != 5 || len(status.LastError) != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// Offloaded blocks are read from cold storage
+	resp, err := handler.GetBlocksByHeight(context.Background(), &block_store.GetBlocksByHeightRequest{
+		HeadBlockId: bt.ByNum[110].GetId(), AncestorStartHeight: 1, NumBlocks: 10, ReturnBlock: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.BlockItems) != 10 || !bytes.Equal(resp.BlockItems[3].BlockId, id) || resp.BlockItems[3].Block == nil {
+		t.Errorf("unexpected blocks %v", resp.BlockItems)
+	}
+
+	// Offloading resumes where it stopped
+	if err = offloader.HandleIrreversible(&koinos.BlockTopology{Id: bt.ByNum[110].GetId(), Height: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cold.objects) != 6 {
+		t.Errorf("expected 6 offloaded blocks, got %d", len(cold.objects))
+	}
+
+	if err = backend.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cold.objects["chain/"+hex.EncodeToString(key)]; ok {
+		t.Error("expected deleting a block to delete its object")
+	}
+
+	// A missing object is an error rather than a missing block
+	delete(cold.objects, "chain/"+hex.EncodeToString(blockRecordKey(bt.ByNum[101].GetId())))
+	if _, err = backend.Get(blockRecordKey(bt.ByNum[101].GetId())); err == nil {
+		t.Error("expected error for a missing object")
+	}
+}
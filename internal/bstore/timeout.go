@@ -0,0 +1,52 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// RequestTimeoutError is returned for a query which did not complete within the request timeout
+type RequestTimeoutError struct {
+	Request string
+	Timeout time.Duration
+}
+
+func (e *RequestTimeoutError) Error() string {
+	return fmt.Sprintf("Request '%s' did not complete within %s", e.Request, e.Timeout)
+}
+
+// Code returns the error code
+func (e *RequestTimeoutError) Code() ErrorCode {
+	return ErrorCodeTimeout
+}
+
+// Details returns the request and the timeout it exceeded
+func (e *RequestTimeoutError) Details() map[string]interface{} {
+	return map[string]interface{}{"request": e.Request, "timeout_ms": e.Timeout.Milliseconds()}
+}
+
+// withTimeout runs query with a context which is done once the request timeout passed, if the query
+// is bounded and a timeout is set. Queries check the context on each step of their walks along the
+// chain, so a query which exceeds the timeout stops, releasing its lock and its job, and fails with a
+// RequestTimeoutError.
+func (handler *RequestHandler) withTimeout(request string, bounded bool, query func(ctx context.Context) error) error {
+	timeout := handler.settings().RequestTimeout
+	if !bounded || timeout <= 0 {
+		return query(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := query(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Warnf("Request '%s' did not complete within %s, stopped it", request, timeout)
+		return &RequestTimeoutError{Request: request, Timeout: timeout}
+	}
+
+	return err
+}
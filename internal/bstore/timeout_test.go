@@ -0,0 +1,73 @@
+package bstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 20)
+	head := bt.ByNum[120].GetId()
+
+	req := &block_store.BlockStoreRequest{
+		Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+			GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{HeadBlockId: head, AncestorStartHeight: 1, NumBlocks: 20},
+		},
+	}
+
+	handler.Backend = &slowBackend{BlockStoreBackend: handler.Backend, delay: 20 * time.Millisecond}
+	handler.RequestTimeout = 10 * time.Millisecond
+
+	start := time.Now()
+	resp := handler.HandleRequest(req)
+	errval, ok := resp.GetResponse().(*block_store.BlockStoreResponse_Error)
+	if !ok {
+		t.Fatal("expected error response")
+	}
+	if code := ErrorCodeOfStatus(errval.Error); code != ErrorCodeTimeout {
+		t.Errorf("expected timeout error code, got %v", code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected request to stop after the timeout, took %s", elapsed)
+	}
+
+	// The query stopped, it does not hold the lock anymore
+	locked := make(chan struct{})
+	go func() {
+		handler.lock.Lock()
+		handler.lock.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("expected the query to release the lock once it timed out")
+	}
+
+	extResp := handler.HandleExtendedRequest(&ExtendedRequest{VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: head, StartHeight: 1}})
+	if extResp.Error == nil || extResp.Error.Code != ErrorCodeTimeout || extResp.Error.Details["request"] != "verify_chain_links" {
+		t.Errorf("expected timeout error, got %+v", extResp.Error)
+	}
+
+	caps, _ := handler.GetCapabilities(&GetCapabilitiesRequest{})
+	if caps.RequestTimeoutMS != 10 {
+		t.Errorf("expected request timeout in capabilities, got %d", caps.RequestTimeoutMS)
+	}
+
+	// Health requests are not bounded
+	if extResp = handler.HandleExtendedRequest(&ExtendedRequest{GetHealth: &GetHealthRequest{}}); extResp.Error != nil && extResp.Error.Code == ErrorCodeTimeout {
+		t.Error("expected health request not to time out")
+	}
+
+	// A reloaded timeout of 0 disables it
+	settings := handler.CurrentSettings()
+	settings.RequestTimeout = 0
+	handler.ApplySettings(settings)
+	resp = handler.HandleRequest(req)
+	if _, ok := resp.GetResponse().(*block_store.BlockStoreResponse_GetBlocksByHeight); !ok {
+		t.Errorf("expected blocks without a timeout, got %v", resp.GetResponse())
+	}
+}
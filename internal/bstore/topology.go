@@ -0,0 +1,167 @@
+package bstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	maxTopologyRequest = 10000
+
+	recordBlockIDField          protowire.Number = 1
+	recordBlockHeightField      protowire.Number = 2
+	recordPreviousBlockIdsField protowire.Number = 5
+)
+
+// Topology is the position of a block in the chain
+type Topology struct {
+	ID       HexBytes `json:"id"`
+	Height   uint64   `json:"height"`
+	Previous HexBytes `json:"previous"`
+}
+
+// GetTopologyAtHeightRangeRequest asks for the topology of NumBlocks blocks starting at
+// AncestorStartHeight on the chain ending at HeadBlockID
+type GetTopologyAtHeightRangeRequest struct {
+	HeadBlockID         HexBytes `json:"head_block_id"`
+	AncestorStartHeight uint64   `json:"ancestor_start_height"`
+	NumBlocks           uint32   `json:"num_blocks"`
+}
+
+// GetTopologyAtHeightRangeResponse contains block topologies in ascending height order
+type GetTopologyAtHeightRangeResponse struct {
+	Topologies []*Topology `json:"topologies"`
+}
+
+// GetTopologyAtHeightRange returns the topology of a range of blocks without deserializing the
+// block records. The range is truncated at the head block.
+func (handler *RequestHandler) GetTopologyAtHeightRange(ctx context.Context, req *GetTopologyAtHeightRangeRequest) (*GetTopologyAtHeightRangeResponse, error) {
+	if req.NumBlocks > maxTopologyRequest {
+		return nil, fmt.Errorf("cannot request more than %v blocks", maxTopologyRequest)
+	}
+
+	resp := &GetTopologyAtHeightRangeResponse{Topologies: []*Topology{}}
+
+	if req.NumBlocks == 0 {
+		return resp, nil
+	}
+
+	if req.AncestorStartHeight == 0 {
+		return nil, errors.New("ancestor_start_height must be greater than 0")
+	}
+
+	if req.HeadBlockID == nil {
+		return nil, errors.New("expected field 'head_block_id' was nil")
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil && req.AncestorStartHeight < checkpoint.Height {
+		return nil, &BelowCheckpoint{checkpoint.Height}
+	}
+
+	head, err := handler.getTopology(req.HeadBlockID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.AncestorStartHeight > head.Height {
+		return nil, &BlockHeightMismatch{}
+	}
+
+	endHeight := req.AncestorStartHeight + uint64(req.NumBlocks) - 1
+	if endHeight > head.Height {
+		endHeight = head.Height
+	}
+
+	blockID := []byte(head.ID)
+	if endHeight < head.Height {
+		blockID, err = getAncestorIDAtHeight(ctx, handler.Backend, req.HeadBlockID, endHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp.Topologies = make([]*Topology, endHeight-req.AncestorStartHeight+1)
+	for i := len(resp.Topologies) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		topology, err := handler.getTopology(blockID)
+		if err != nil {
+			return nil, err
+		}
+
+		if topology.Height != req.AncestorStartHeight+uint64(i) {
+			return nil, &UnexpectedHeightError{}
+		}
+
+		resp.Topologies[i] = topology
+		blockID = topology.Previous
+	}
+
+	return resp, nil
+}
+
+// getTopology reads the topology of a block from its record, skipping over the block and receipt
+func (handler *RequestHandler) getTopology(blockID []byte) (*Topology, error) {
+	recordBytes, err := handler.Backend.Get(blockRecordKey(blockID))
+	if err != nil {
+		return nil, err
+	}
+	if len(recordBytes) == 0 {
+		return nil, &BlockNotPresent{blockID}
+	}
+
+	topology := &Topology{}
+	hasPrevious := false
+
+	for data := recordBytes; len(data) > 0; {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, &DeserializeError{}
+		}
+		data = data[n:]
+
+		switch {
+		case num == recordBlockIDField && typ == protowire.BytesType:
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return nil, &DeserializeError{}
+			}
+			topology.ID = v
+			n = m
+		case num == recordBlockHeightField && typ == protowire.VarintType:
+			v, m := protowire.ConsumeVarint(data)
+			if m < 0 {
+				return nil, &DeserializeError{}
+			}
+			topology.Height = v
+			n = m
+		case num == recordPreviousBlockIdsField && typ == protowire.BytesType && !hasPrevious:
+			// The first previous block ID is the direct parent
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return nil, &DeserializeError{}
+			}
+			topology.Previous = v
+			hasPrevious = true
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, &DeserializeError{}
+			}
+		}
+		data = data[n:]
+	}
+
+	return topology, nil
+}
@@ -0,0 +1,56 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestGetTopologyAtHeightRange(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+		bt := buildLinearChain(t, &handler, 50)
+		head := bt.ByNum[140].GetId()
+
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{GetTopologyAtHeightRange: &GetTopologyAtHeightRangeRequest{
+			HeadBlockID:         head,
+			AncestorStartHeight: 10,
+			NumBlocks:           100,
+		}})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+
+		// The range is truncated at the head
+		topologies := resp.GetTopologyAtHeightRange.Topologies
+		if len(topologies) != 31 {
+			t.Fatalf("expected 31 topologies, got %d", len(topologies))
+		}
+
+		for i, topology := range topologies {
+			block := bt.ByNum[110+uint64(i)]
+			if topology.Height != block.GetHeader().GetHeight() || !bytes.Equal(topology.ID, block.GetId()) || !bytes.Equal(topology.Previous, block.GetHeader().GetPrevious()) {
+				t.Fatalf("unexpected topology at index %d: %+v", i, topology)
+			}
+		}
+
+		short, err := handler.GetTopologyAtHeightRange(context.Background(), &GetTopologyAtHeightRangeRequest{HeadBlockID: head, AncestorStartHeight: 1, NumBlocks: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(short.Topologies) != 3 || !bytes.Equal(short.Topologies[2].ID, bt.ByNum[103].GetId()) {
+			t.Error("unexpected topologies at the start of the chain")
+		}
+
+		if _, err = handler.GetTopologyAtHeightRange(context.Background(), &GetTopologyAtHeightRangeRequest{HeadBlockID: head, AncestorStartHeight: 41, NumBlocks: 1}); err == nil {
+			t.Error("expected error for start height above head")
+		}
+
+		if _, err = handler.GetTopologyAtHeightRange(context.Background(), &GetTopologyAtHeightRangeRequest{HeadBlockID: head, AncestorStartHeight: 1, NumBlocks: maxTopologyRequest + 1}); err == nil {
+			t.Error("expected error for too many blocks")
+		}
+
+		CloseBackend(b)
+	}
+}
@@ -0,0 +1,83 @@
+package bstore
+
+import (
+	"encoding/json"
+)
+
+const (
+	// continuationTokenJSONSize bounds the size of a continuation token field in a JSON response
+	continuationTokenJSONSize = 64
+)
+
+// fitBlockItems returns how many of the leading items fit in maxSize bytes, given the size of the
+// response without them and the serialized size of each item
+func fitBlockItems(baseSize int, itemSizes []int, maxSize int) int {
+	size := baseSize
+	for i, itemSize := range itemSizes {
+		size += itemSize
+		if size > maxSize {
+			return i
+		}
+	}
+
+	return len(itemSizes)
+}
+
+// truncateExtendedResponse drops blocks from a GetRecentBlocks or GetBlocksByIDPaged response until it
+// fits in the maximum message size. Recent blocks drop the oldest blocks and are flagged truncated,
+// paged requests return a continuation token for the dropped blocks. The proto responses cannot tell
+// the caller they were cut short, they are replaced with a message_too_large error by the caller.
+func (handler *RequestHandler) truncateExtendedResponse(response *ExtendedResponse) {
+	var items []*BlockItem
+	switch {
+	case response.GetRecentBlocks != nil:
+		items = response.GetRecentBlocks.Blocks
+	case response.GetBlocksByIDPaged != nil:
+		items = response.GetBlocksByIDPaged.BlockItems
+	default:
+		return
+	}
+
+	data, err := json.Marshal(response)
+	maxSize := handler.MaxResponseSize()
+	if err != nil || len(data) <= maxSize || len(items) == 0 {
+		return
+	}
+
+	sizes := make([]int, len(items))
+	total := 0
+	for i, item := range items {
+		itemData, err := json.Marshal(item)
+		if err != nil {
+			return
+		}
+		// Each item is followed by a comma except the last
+		sizes[i] = len(itemData) + 1
+		total += sizes[i]
+	}
+	baseSize := len(data) - total + 1
+
+	switch {
+	case response.GetRecentBlocks != nil:
+		// Keep the newest blocks, they are at the end
+		reversed := make([]int, len(sizes))
+		for i := range sizes {
+			reversed[i] = sizes[len(sizes)-1-i]
+		}
+
+		count := fitBlockItems(baseSize, reversed, maxSize)
+		if count > 0 {
+			response.GetRecentBlocks.Blocks = items[len(items)-count:]
+			response.GetRecentBlocks.Truncated = true
+		}
+	case response.GetBlocksByIDPaged != nil:
+		page := response.GetBlocksByIDPaged
+
+		// The continuation token which will be set is no larger than the one a truncated page needs
+		count := fitBlockItems(baseSize+continuationTokenJSONSize, sizes, maxSize)
+		if count > 0 {
+			page.BlockItems = items[:count]
+			page.ContinuationToken = makeContinuationToken(page.Offset+uint64(count), page.digest)
+		}
+	}
+}
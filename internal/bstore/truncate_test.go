@@ -0,0 +1,96 @@
+package bstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTruncateResponse(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 20)
+
+	byHeight := &block_store.BlockStoreRequest{Request: &block_store.BlockStoreRequest_GetBlocksByHeight{
+		GetBlocksByHeight: &block_store.GetBlocksByHeightRequest{
+			HeadBlockId:         bt.ByNum[120].GetId(),
+			AncestorStartHeight: 1,
+			NumBlocks:           20,
+			ReturnBlock:         true,
+		},
+	}}
+	full := handler.HandleRequest(byHeight)
+	if len(full.GetGetBlocksByHeight().GetBlockItems()) != 20 {
+		t.Fatalf("expected 20 blocks, got %d", len(full.GetGetBlocksByHeight().GetBlockItems()))
+	}
+
+	// The proto responses cannot flag missing blocks, an oversized response is left as is for the
+	// caller to replace with an error rather than passed off as the end of the chain
+	handler.MaxMessageSize = proto.Size(full) / 2
+	resp := handler.HandleRequest(byHeight)
+	if !proto.Equal(resp, full) {
+		t.Errorf("expected oversized response to be left as is, got %d blocks", len(resp.GetGetBlocksByHeight().GetBlockItems()))
+	}
+}
+
+func TestTruncateExtendedResponse(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+	bt := buildLinearChain(t, &handler, 20)
+
+	recent := &ExtendedRequest{GetRecentBlocks: &GetRecentBlocksRequest{NumBlocks: 20, ReturnBlock: true}}
+	full := handler.HandleExtendedRequest(recent)
+	if full.Error != nil {
+		t.Fatal(full.Error.Message)
+	}
+	data, _ := json.Marshal(full)
+	handler.MaxMessageSize = len(data) / 2
+
+	resp := handler.HandleExtendedRequest(recent)
+	blocks := resp.GetRecentBlocks.Blocks
+	if !resp.GetRecentBlocks.Truncated || len(blocks) == 0 || len(blocks) >= 20 {
+		t.Fatalf("expected a truncated response, got %d blocks", len(blocks))
+	}
+	if data, _ = json.Marshal(resp); len(data) > handler.MaxMessageSize {
+		t.Errorf("expected response to fit in %d bytes, got %d", handler.MaxMessageSize, len(data))
+	}
+	if blocks[len(blocks)-1].BlockHeight != 20 {
+		t.Error("expected the newest blocks to be kept")
+	}
+
+	// Truncated pages resume where they left off
+	ids := make([]HexBytes, 20)
+	for i := range ids {
+		ids[i] = bt.ByNum[uint64(101+i)].GetId()
+	}
+	req := &GetBlocksByIDPagedRequest{BlockIDs: ids, ReturnBlock: true}
+	var items []*BlockItem
+	for pages := 1; ; pages++ {
+		page := handler.HandleExtendedRequest(&ExtendedRequest{GetBlocksByIDPaged: req})
+		if page.Error != nil {
+			t.Fatal(page.Error.Message)
+		}
+		if data, _ = json.Marshal(page); len(data) > handler.MaxMessageSize {
+			t.Errorf("expected page to fit in %d bytes, got %d", handler.MaxMessageSize, len(data))
+		}
+
+		items = append(items, page.GetBlocksByIDPaged.BlockItems...)
+		if len(page.GetBlocksByIDPaged.ContinuationToken) == 0 {
+			if pages < 2 {
+				t.Error("expected more than one page")
+			}
+			break
+		}
+		req.ContinuationToken = page.GetBlocksByIDPaged.ContinuationToken
+	}
+
+	if len(items) != len(ids) {
+		t.Fatalf("expected %d blocks, got %d", len(ids), len(items))
+	}
+	for i, item := range items {
+		if !bytes.Equal(item.BlockID, ids[i]) {
+			t.Fatalf("unexpected block at index %d", i)
+		}
+	}
+}
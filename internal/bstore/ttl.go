@@ -0,0 +1,63 @@
+package bstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Ephemeral records, such as query cursors or rate limit counters, are only kept for a while. Each
+// carries the time it expires at, so it reads as missing once expired whatever the backend. Backends
+// which expire records themselves, such as Badger, are also given the TTL so the space of expired
+// records is reclaimed; other backends keep them until they are overwritten or deleted.
+
+// expiryHeaderSize is the size of the expiry time, in Unix nanoseconds, preceding the value of an
+// ephemeral record
+const expiryHeaderSize = 8
+
+// ttlBackend is implemented by backends which can remove a record once its TTL has passed. Wrapping
+// backends forward the TTL to the backend they wrap.
+type ttlBackend interface {
+	PutWithTTL(key []byte, value []byte, ttl time.Duration) error
+}
+
+// putWithTTL stores value in key, giving the TTL to backend if it can expire records
+func putWithTTL(backend BlockStoreBackend, key []byte, value []byte, ttl time.Duration) error {
+	if inner, ok := backend.(ttlBackend); ok {
+		return inner.PutWithTTL(key, value, ttl)
+	}
+
+	return backend.Put(key, value)
+}
+
+// PutEphemeral stores value in key as an ephemeral record, which GetEphemeral reads as missing once
+// ttl has passed
+func PutEphemeral(backend BlockStoreBackend, key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("the TTL of an ephemeral record must be positive")
+	}
+
+	record := make([]byte, expiryHeaderSize, expiryHeaderSize+len(value))
+	binary.BigEndian.PutUint64(record, uint64(time.Now().Add(ttl).UnixNano()))
+	record = append(record, value...)
+
+	return putWithTTL(backend, key, record, ttl)
+}
+
+// GetEphemeral reads an ephemeral record written by PutEphemeral, returning an empty value if it is
+// missing or expired
+func GetEphemeral(backend BlockStoreBackend, key []byte) ([]byte, error) {
+	record, err := backend.Get(key)
+	if err != nil || len(record) == 0 {
+		return nil, err
+	}
+	if len(record) < expiryHeaderSize {
+		return nil, errors.New("ephemeral record corrupted")
+	}
+
+	if time.Now().UnixNano() >= int64(binary.BigEndian.Uint64(record)) {
+		return nil, nil
+	}
+
+	return record[expiryHeaderSize:], nil
+}
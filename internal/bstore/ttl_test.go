@@ -0,0 +1,54 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEphemeralRecords(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+
+		// Ephemeral records are read through the wrapping backends like any other
+		backend, err := NewCompressedBackend(NewCacheBackend(NewChecksumBackend(b, true), 1<<20), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := []byte{0x42}
+		if err = PutEphemeral(backend, key, []byte("cursor"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := GetEphemeral(backend, key); err != nil || !bytes.Equal(value, []byte("cursor")) {
+			t.Errorf("backend %d: expected the ephemeral record, got %q, %v", bType, value, err)
+		}
+
+		if err = PutEphemeral(backend, key, []byte("cursor"), time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if value, err := GetEphemeral(backend, key); err != nil || len(value) != 0 {
+			t.Errorf("backend %d: expected the expired record to be missing, got %q, %v", bType, value, err)
+		}
+
+		if err = PutEphemeral(backend, key, []byte("cursor"), 0); err == nil {
+			t.Errorf("backend %d: expected an error for a TTL of 0", bType)
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestBadgerBackendTTL(t *testing.T) {
+	b := NewBackend(BadgerBackendType)
+	defer CloseBackend(b)
+
+	// Badger removes the record itself once its TTL has passed
+	if err := PutEphemeral(NewNamespaceBackend(b, NamespaceMetadata), []byte{highestBlockKey}, []byte{1}, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	if present, err := b.Has([]byte{highestBlockKey}); err != nil || present {
+		t.Errorf("expected the expired record to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package bstore
+
+import (
+	"errors"
+	"io"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+)
+
+// txnRetries is the number of times a transaction of the request handler is retried after a conflict
+const txnRetries = 3
+
+// TxnBackend serves the reads and writes of a transaction as a backend, so the request helpers can
+// read records and write their updates as a single atomic unit. Nothing is stored until Commit.
+//
+// Like MemoBackend it is meant to live for a single request.
+type TxnBackend struct {
+	Txn BackendTxn
+}
+
+// NewTxnBackend begins a transaction of backend and creates a TxnBackend over it
+func NewTxnBackend(backend BlockStoreBackend) (*TxnBackend, error) {
+	txn, err := backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxnBackend{Txn: txn}, nil
+}
+
+// Commit commits the transaction, returning ErrTxnConflict if a key it read was written since it began
+func (backend *TxnBackend) Commit() error {
+	return backend.Txn.Commit()
+}
+
+// Rollback discards the writes of the transaction
+func (backend *TxnBackend) Rollback() {
+	backend.Txn.Rollback()
+}
+
+// Reset returns an error, a reset cannot be part of a transaction
+func (backend *TxnBackend) Reset() error {
+	return errors.New("cannot reset the database within a transaction")
+}
+
+// Put stores the value when the transaction is committed
+func (backend *TxnBackend) Put(key []byte, value []byte) error {
+	return backend.Txn.Put(key, value)
+}
+
+// PutBatch stores the values when the transaction is committed, along with its other writes
+func (backend *TxnBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		if err := backend.Txn.Put(pair.Key, pair.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the key when the transaction is committed
+func (backend *TxnBackend) Delete(key []byte) error {
+	return backend.Txn.Delete(key)
+}
+
+// Get fetches the requested value within the transaction
+func (backend *TxnBackend) Get(key []byte) ([]byte, error) {
+	return backend.Txn.Get(key)
+}
+
+// Has reports whether key is stored within the transaction
+func (backend *TxnBackend) Has(key []byte) (bool, error) {
+	return backend.Txn.Has(key)
+}
+
+// Iterate iterates within the transaction
+func (backend *TxnBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Txn.Iterate(prefix, fn)
+}
+
+// Snapshot returns a view of the transaction, which sees its own uncommitted writes
+func (backend *TxnBackend) Snapshot() (BackendSnapshot, error) {
+	return &liveSnapshot{backend: backend}, nil
+}
+
+// Flush does nothing, the writes of the transaction are flushed with the database once committed
+func (backend *TxnBackend) Flush() error {
+	return nil
+}
+
+// Compact returns an error, a compaction cannot be part of a transaction
+func (backend *TxnBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return nil, errors.New("cannot compact the database within a transaction")
+}
+
+// Backup returns an error, a backup cannot be part of a transaction
+func (backend *TxnBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return 0, errors.New("cannot back up the database within a transaction")
+}
+
+// Load returns an error, a restore cannot be part of a transaction
+func (backend *TxnBackend) Load(r io.Reader) error {
+	return errors.New("cannot restore the database within a transaction")
+}
+
+// Begin begins a transaction batching its writes into this transaction on commit
+func (backend *TxnBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// transact calls fn with a transaction of the backend and commits it, unless fn fails. The
+// transaction is retried from the start if it conflicts with another write, fn must not have other
+// side effects.
+func (handler *RequestHandler) transact(fn func(backend BlockStoreBackend) error) error {
+	for attempt := 0; ; attempt++ {
+		txn, err := NewTxnBackend(handler.Backend)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+
+		err = txn.Commit()
+		if err != ErrTxnConflict || attempt == txnRetries {
+			return err
+		}
+
+		log.Debugf("Transaction conflicted with another write, retrying")
+	}
+}
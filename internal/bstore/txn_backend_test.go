@@ -0,0 +1,60 @@
+package bstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTxnConflict(t *testing.T) {
+	b := NewBackend(BadgerBackendType)
+	defer CloseBackend(b)
+
+	if err := b.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := b.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = txn.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write to a key read by the transaction fails its commit
+	if err = b.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Commit(); err != ErrTxnConflict {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if value, _ := b.Get([]byte("b")); len(value) != 0 {
+		t.Errorf("expected no value from the conflicting transaction, got %v", value)
+	}
+
+	// The request handler retries a conflicting transaction from the start
+	handler := RequestHandler{Backend: b}
+	attempts := 0
+	err = handler.transact(func(backend BlockStoreBackend) error {
+		attempts++
+		value, err := backend.Get([]byte("a"))
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if err = b.Put([]byte("a"), []byte("4")); err != nil {
+				return err
+			}
+		}
+		return backend.Put([]byte("b"), value)
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("expected the transaction to succeed on its second attempt, got %d attempts, %v", attempts, err)
+	}
+	if value, _ := b.Get([]byte("b")); !bytes.Equal(value, []byte("4")) {
+		t.Errorf("expected the value read by the retried transaction, got %v", value)
+	}
+}
@@ -0,0 +1,201 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	maxVerifyChainLinksRange = 10000
+)
+
+// VerifyChainLinksRequest asks the block store to verify the skip links of the chain ending at HeadBlockID
+// between StartHeight and EndHeight, inclusive. An EndHeight of 0 verifies up to the head.
+type VerifyChainLinksRequest struct {
+	HeadBlockID HexBytes `json:"head_block_id"`
+	StartHeight uint64   `json:"start_height"`
+	EndHeight   uint64   `json:"end_height,omitempty"`
+}
+
+// VerifyChainLinksResponse reports the result of a chain link verification
+type VerifyChainLinksResponse struct {
+	Valid         bool                    `json:"valid"`
+	BlocksChecked uint64                  `json:"blocks_checked"`
+	Inconsistency *ChainLinkInconsistency `json:"inconsistency,omitempty"`
+}
+
+// ChainLinkInconsistency describes the first inconsistency found while verifying chain links
+type ChainLinkInconsistency struct {
+	BlockID     HexBytes `json:"block_id"`
+	BlockHeight uint64   `json:"block_height"`
+
+	// LinkIndex is the index into the block's previous block IDs, or -1 if the block itself is inconsistent
+	LinkIndex     int      `json:"link_index"`
+	LinkedBlockID HexBytes `json:"linked_block_id,omitempty"`
+
+	Reason string `json:"reason"`
+}
+
+// VerifyChainLinks walks the previous block skip links of a chain and returns the first inconsistency found
+func (handler *RequestHandler) VerifyChainLinks(ctx context.Context, req *VerifyChainLinksRequest) (*VerifyChainLinksResponse, error) {
+	if req.HeadBlockID == nil {
+		return nil, errors.New("expected field 'head_block_id' was nil")
+	}
+
+	if req.StartHeight == 0 {
+		return nil, errors.New("start_height must be greater than 0")
+	}
+
+	checkpoint, err := handler.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	// Links to blocks below the checkpoint are unknown and are not verified
+	var minLinkHeight uint64
+	if checkpoint != nil {
+		if req.StartHeight < checkpoint.Height {
+			return nil, &BelowCheckpoint{checkpoint.Height}
+		}
+		minLinkHeight = checkpoint.Height
+	}
+
+	headHeight, err := getBlockHeight(handler.Backend, req.HeadBlockID)
+	if err != nil {
+		return nil, err
+	}
+
+	endHeight := req.EndHeight
+	if endHeight == 0 || endHeight > headHeight {
+		endHeight = headHeight
+	}
+
+	if req.StartHeight > endHeight {
+		return nil, &BlockHeightMismatch{}
+	}
+
+	if endHeight-req.StartHeight >= maxVerifyChainLinksRange {
+		return nil, fmt.Errorf("cannot verify more than %v blocks", maxVerifyChainLinksRange)
+	}
+
+	resp := &VerifyChainLinksResponse{}
+
+	blockID := []byte(req.HeadBlockID)
+	if endHeight < headHeight {
+		blockID, err = getAncestorIDAtHeight(ctx, handler.Backend, req.HeadBlockID, endHeight)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		} else if err != nil {
+			resp.Inconsistency = &ChainLinkInconsistency{
+				BlockID:     req.HeadBlockID,
+				BlockHeight: headHeight,
+				LinkIndex:   -1,
+				Reason:      fmt.Sprintf("could not resolve ancestor at height %d: %s", endHeight, err.Error()),
+			}
+			return resp, nil
+		}
+	}
+
+	for height := endHeight; height >= req.StartHeight; height-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		record, inconsistency := handler.verifyRecordLinks(blockID, height, minLinkHeight)
+		resp.BlocksChecked++
+
+		if inconsistency != nil {
+			resp.Inconsistency = inconsistency
+			return resp, nil
+		}
+
+		if height == 1 {
+			break
+		}
+
+		blockID = record.PreviousBlockIds[0]
+	}
+
+	resp.Valid = true
+	return resp, nil
+}
+
+func (handler *RequestHandler) verifyRecordLinks(blockID []byte, height uint64, minLinkHeight uint64) (*block_store.BlockRecord, *ChainLinkInconsistency) {
+	inconsistency := func(linkIndex int, linkedID []byte, format string, args ...interface{}) *ChainLinkInconsistency {
+		return &ChainLinkInconsistency{
+			BlockID:       blockID,
+			BlockHeight:   height,
+			LinkIndex:     linkIndex,
+			LinkedBlockID: linkedID,
+			Reason:        fmt.Sprintf(format, args...),
+		}
+	}
+
+	record, err := handler.getRecord(blockID)
+	if err != nil {
+		return nil, inconsistency(-1, nil, "could not load block: %s", err.Error())
+	}
+
+	if !bytes.Equal(record.GetBlockId(), blockID) {
+		return nil, inconsistency(-1, nil, "record is stored under a different block ID")
+	}
+
+	if record.GetBlockHeight() != height {
+		return nil, inconsistency(-1, nil, "expected height %d, record has height %d", height, record.GetBlockHeight())
+	}
+
+	previousHeights := getPreviousHeights(height)
+	if height == 1 {
+		// The first block links only to the zero block ID
+		previousHeights = []uint64{0}
+	}
+
+	if len(record.GetPreviousBlockIds()) != len(previousHeights) {
+		return nil, inconsistency(-1, nil, "expected %d previous block links, record has %d", len(previousHeights), len(record.GetPreviousBlockIds()))
+	}
+
+	for i, linkedID := range record.GetPreviousBlockIds() {
+		if previousHeights[i] == 0 || previousHeights[i] < minLinkHeight {
+			continue
+		}
+
+		linkedHeight, err := getBlockHeight(handler.Backend, linkedID)
+		if err != nil {
+			return nil, inconsistency(i, linkedID, "could not load linked block: %s", err.Error())
+		}
+
+		if linkedHeight != previousHeights[i] {
+			return nil, inconsistency(i, linkedID, "expected linked height %d, linked block has height %d", previousHeights[i], linkedHeight)
+		}
+	}
+
+	return record, nil
+}
+
+func (handler *RequestHandler) getRecord(blockID []byte) (*block_store.BlockRecord, error) {
+	return handler.getRecordAtKey(blockRecordKey(blockID), blockID)
+}
+
+// getRecordAtKey reads the block record of blockID stored under key
+func (handler *RequestHandler) getRecordAtKey(key []byte, blockID []byte) (*block_store.BlockRecord, error) {
+	recordBytes, err := handler.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(recordBytes) == 0 {
+		return nil, &BlockNotPresent{blockID}
+	}
+
+	record := &block_store.BlockRecord{}
+	err = proto.Unmarshal(recordBytes, record)
+	if err != nil {
+		return nil, &DeserializeError{}
+	}
+
+	return record, nil
+}
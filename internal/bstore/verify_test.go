@@ -0,0 +1,98 @@
+package bstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/koinos/koinos-proto-golang/v2/koinos/rpc/block_store"
+	"google.golang.org/protobuf/proto"
+)
+
+func buildLinearChain(t *testing.T, handler *RequestHandler, length uint64) *BlockTree {
+	chain := []uint64{0}
+	for i := uint64(1); i <= length; i++ {
+		chain = append(chain, 100+i)
+	}
+
+	bt := ToBlockTree(NewMockBlockTree([][]uint64{chain}))
+	BuildTestTree(t, handler, bt)
+	return bt
+}
+
+func TestVerifyChainLinks(t *testing.T) {
+	for _, bType := range backendTypes {
+		b := NewBackend(bType)
+		handler := RequestHandler{Backend: b}
+		bt := buildLinearChain(t, &handler, 20)
+		head := bt.ByNum[120].GetId()
+
+		resp := handler.HandleExtendedRequest(&ExtendedRequest{
+			VerifyChainLinks: &VerifyChainLinksRequest{HeadBlockID: head, StartHeight: 1},
+		})
+		if resp.Error != nil {
+			t.Fatal(resp.Error.Message)
+		}
+		if !resp.VerifyChainLinks.Valid || resp.VerifyChainLinks.BlocksChecked != 20 {
+			t.Errorf("expected valid chain of 20 blocks, got %+v", resp.VerifyChainLinks)
+		}
+
+		// Corrupt the height 8 skip link to height 4
+		recordBytes, _ := b.Get(blockRecordKey(bt.ByNum[108].GetId()))
+		record := block_store.BlockRecord{}
+		if err := proto.Unmarshal(recordBytes, &record); err != nil {
+			t.Fatal(err)
+		}
+		record.PreviousBlockIds[2] = GetNonExistentBlockID(4)
+		recordBytes, _ = proto.Marshal(&record)
+		if err := b.Put(blockRecordKey(record.GetBlockId()), recordBytes); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := handler.VerifyChainLinks(context.Background(), &VerifyChainLinksRequest{HeadBlockID: head, StartHeight: 5, EndHeight: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Valid || result.Inconsistency == nil {
+			t.Fatal("expected inconsistency")
+		}
+		if result.Inconsistency.BlockHeight != 8 || result.Inconsistency.LinkIndex != 2 {
+			t.Errorf("unexpected inconsistency %+v", result.Inconsistency)
+		}
+		if !bytes.Equal(result.Inconsistency.LinkedBlockID, GetNonExistentBlockID(4)) {
+			t.Error("unexpected linked block ID")
+		}
+		if result.BlocksChecked != 3 {
+			t.Errorf("expected 3 blocks checked, got %d", result.BlocksChecked)
+		}
+
+		// Ranges not containing the corruption remain valid
+		result, err = handler.VerifyChainLinks(context.Background(), &VerifyChainLinksRequest{HeadBlockID: head, StartHeight: 9, EndHeight: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid {
+			t.Errorf("unexpected inconsistency %+v", result.Inconsistency)
+		}
+
+		if _, err = handler.VerifyChainLinks(context.Background(), &VerifyChainLinksRequest{HeadBlockID: head, StartHeight: 0}); err == nil {
+			t.Error("expected error for start height 0")
+		}
+
+		CloseBackend(b)
+	}
+}
+
+func TestExtendedRequestEnvelope(t *testing.T) {
+	handler := RequestHandler{Backend: NewMapBackend()}
+
+	resp := handler.HandleExtendedRequest(&ExtendedRequest{})
+	if resp.Error == nil {
+		t.Error("expected error for empty request")
+	}
+
+	resp = handler.HandleExtendedRequest(nil)
+	if resp.Error == nil {
+		t.Error("expected error for nil request")
+	}
+}
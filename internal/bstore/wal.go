@@ -0,0 +1,521 @@
+package bstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// DefaultWALSegmentSize is the size at which a new log segment is started
+	DefaultWALSegmentSize = 16 << 20
+
+	// DefaultWALRetainedSegments is the number of log segments kept for standbys which fall behind
+	DefaultWALRetainedSegments = 64
+
+	walFilePattern  = "wal-%020d.log"
+	walHeaderLength = 8
+
+	// walMarkInterval is the distance in bytes between the offsets of a segment remembered for reads
+	walMarkInterval = 256 << 10
+
+	walPut    = 0x00
+	walDelete = 0x01
+
+	// walBatchPut is a put followed by more entries of the same batch
+	walBatchPut = 0x02
+)
+
+// WALEntry is a write recorded in the write-ahead log. Batch is set on the entries of a batch but the
+// last, the writes of a batch are applied together.
+type WALEntry struct {
+	Seq    uint64   `json:"seq"`
+	Key    HexBytes `json:"key"`
+	Value  HexBytes `json:"value"`
+	Delete bool     `json:"delete,omitempty"`
+	Batch  bool     `json:"batch,omitempty"`
+}
+
+// WALTruncatedError is returned when the entries following a position are no longer in the log
+type WALTruncatedError struct {
+	Position uint64
+	First    uint64
+}
+
+func (e *WALTruncatedError) Error() string {
+	return fmt.Sprintf("write-ahead log no longer holds the entries after position %d, it starts at %d", e.Position, e.First)
+}
+
+// walMark is the offset of an entry in a segment
+type walMark struct {
+	seq    uint64
+	offset int64
+}
+
+// walSegment is a log file, named after the sequence number of its first entry
+type walSegment struct {
+	first   uint64
+	path    string
+	marks   []walMark
+	indexed bool
+}
+
+// WriteAheadLog records the writes to the database in sequence, in segment files in Dir, so they can
+// be shipped to standby block stores. The oldest segments are removed once RetainedSegments are kept.
+//
+// Entries are framed with their length and CRC-32 checksum like the segment backend. A torn entry at
+// the end of the log, left by a crash, is discarded when the log is opened.
+type WriteAheadLog struct {
+	Dir string
+
+	// SegmentSize is the size at which a new segment is started
+	SegmentSize int64
+
+	// RetainedSegments is the number of segments kept
+	RetainedSegments int
+
+	lock     sync.Mutex
+	segments []*walSegment
+	next     uint64
+	file     *os.File
+	size     int64
+}
+
+// OpenWriteAheadLog opens the log in dir, creating it if missing, continuing after position, the
+// sequence number of the last entry applied to the database. Entries after position were logged but
+// never applied, they are discarded. A log which ends before position is missing entries, it is
+// restarted at position. A segmentSize or retained of 0 uses the defaults.
+func OpenWriteAheadLog(dir string, segmentSize int64, retained int, position uint64) (*WriteAheadLog, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultWALSegmentSize
+	}
+	if retained <= 0 {
+		retained = DefaultWALRetainedSegments
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	wal := &WriteAheadLog{Dir: dir, SegmentSize: segmentSize, RetainedSegments: retained}
+	if err := wal.recover(position); err != nil {
+		return nil, err
+	}
+
+	return wal, nil
+}
+
+func (wal *WriteAheadLog) segmentPath(first uint64) string {
+	return filepath.Join(wal.Dir, fmt.Sprintf(walFilePattern, first))
+}
+
+// recover opens the last segment for appending, discarding the entries after position
+func (wal *WriteAheadLog) recover(position uint64) error {
+	matches, err := filepath.Glob(filepath.Join(wal.Dir, "wal-*.log"))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		var first uint64
+		if _, err := fmt.Sscanf(filepath.Base(match), walFilePattern, &first); err != nil {
+			continue
+		}
+
+		if first > position {
+			if err = os.Remove(match); err != nil {
+				return err
+			}
+			continue
+		}
+		wal.segments = append(wal.segments, &walSegment{first: first, path: match})
+	}
+	sort.Slice(wal.segments, func(i, j int) bool { return wal.segments[i].first < wal.segments[j].first })
+
+	if len(wal.segments) > 0 {
+		last := wal.segments[len(wal.segments)-1]
+		end, lastSeq, err := wal.index(last, position)
+		if err != nil {
+			return err
+		}
+
+		if lastSeq == position {
+			if err = os.Truncate(last.path, end); err != nil {
+				return err
+			}
+
+			wal.next = position + 1
+			return wal.openSegment(last, end)
+		}
+
+		log.Warnf("Write-ahead log ends at %d before the database position %d, restarting it", lastSeq, position)
+		if err = wal.removeSegments(); err != nil {
+			return err
+		}
+	}
+
+	wal.next = position + 1
+	return wal.startSegment()
+}
+
+// index scans a segment up to the last entry at or before position, remembering the offsets of its
+// entries for reads. It returns the offset after that entry and its sequence number.
+func (wal *WriteAheadLog) index(segment *walSegment, position uint64) (int64, uint64, error) {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	segment.marks = segment.marks[:0]
+	offset := int64(0)
+	lastSeq := segment.first - 1
+	for {
+		entry, n, err := readWALEntry(reader)
+		if err != nil || entry.Seq > position {
+			// The rest of the segment is torn or was never applied
+			break
+		}
+
+		if len(segment.marks) == 0 || offset-segment.marks[len(segment.marks)-1].offset >= walMarkInterval {
+			segment.marks = append(segment.marks, walMark{seq: entry.Seq, offset: offset})
+		}
+
+		offset += n
+		lastSeq = entry.Seq
+	}
+
+	segment.indexed = true
+	return offset, lastSeq, nil
+}
+
+// openSegment opens a segment for appending at offset
+func (wal *WriteAheadLog) openSegment(segment *walSegment, offset int64) error {
+	file, err := os.OpenFile(segment.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	wal.file = file
+	wal.size = offset
+	return nil
+}
+
+// startSegment starts a new segment at the next sequence number, removing the segments which are no
+// longer retained
+func (wal *WriteAheadLog) startSegment() error {
+	if wal.file != nil {
+		err := wal.file.Sync()
+		if closeErr := wal.file.Close(); err == nil {
+			err = closeErr
+		}
+		wal.file = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	segment := &walSegment{first: wal.next, path: wal.segmentPath(wal.next), indexed: true}
+	if err := wal.openSegment(segment, 0); err != nil {
+		return err
+	}
+	wal.segments = append(wal.segments, segment)
+
+	for len(wal.segments) > wal.RetainedSegments {
+		if err := os.Remove(wal.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		wal.segments = wal.segments[1:]
+	}
+
+	return nil
+}
+
+// removeSegments removes every segment, the caller must start a new one
+func (wal *WriteAheadLog) removeSegments() error {
+	if wal.file != nil {
+		_ = wal.file.Close()
+		wal.file = nil
+	}
+
+	for _, segment := range wal.segments {
+		if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	wal.segments = nil
+
+	return nil
+}
+
+// Append records a write and returns its sequence number. A nil value records a delete.
+func (wal *WriteAheadLog) Append(key []byte, value []byte) (uint64, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	op := byte(walPut)
+	if value == nil {
+		op = walDelete
+	}
+
+	return wal.append([][]byte{encodeWALEntry(wal.next, op, key, value)})
+}
+
+// AppendBatch records the puts of a batch and returns the sequence number of its last entry. The
+// entries are written at once in a single segment, so the batch is never logged in part.
+func (wal *WriteAheadLog) AppendBatch(pairs []*KeyValue) (uint64, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	records := make([][]byte, 0, len(pairs))
+	for i, pair := range pairs {
+		op := byte(walBatchPut)
+		if i == len(pairs)-1 {
+			op = walPut
+		}
+		records = append(records, encodeWALEntry(wal.next+uint64(i), op, pair.Key, pair.Value))
+	}
+
+	return wal.append(records)
+}
+
+// append writes the records of consecutive entries starting at the next sequence number and returns
+// the sequence number of the last one. The caller must hold the lock.
+func (wal *WriteAheadLog) append(records [][]byte) (uint64, error) {
+	if wal.file == nil {
+		return 0, errors.New("write-ahead log is closed")
+	}
+
+	size := 0
+	for _, record := range records {
+		size += len(record)
+	}
+	if wal.size > 0 && wal.size+int64(size) > wal.SegmentSize {
+		if err := wal.startSegment(); err != nil {
+			return 0, err
+		}
+	}
+
+	data := make([]byte, 0, size)
+	for _, record := range records {
+		data = append(data, record...)
+	}
+	if _, err := wal.file.WriteAt(data, wal.size); err != nil {
+		return 0, err
+	}
+
+	segment := wal.segments[len(wal.segments)-1]
+	for _, record := range records {
+		if len(segment.marks) == 0 || wal.size-segment.marks[len(segment.marks)-1].offset >= walMarkInterval {
+			segment.marks = append(segment.marks, walMark{seq: wal.next, offset: wal.size})
+		}
+
+		wal.size += int64(len(record))
+		wal.next++
+	}
+
+	return wal.next - 1, nil
+}
+
+// Sync syncs the current segment to disk, earlier segments are synced when they are closed
+func (wal *WriteAheadLog) Sync() error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.file == nil {
+		return errors.New("write-ahead log is closed")
+	}
+
+	return wal.file.Sync()
+}
+
+// Position returns the sequence number of the last entry
+func (wal *WriteAheadLog) Position() uint64 {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	return wal.next - 1
+}
+
+// Read returns the entries after position, up to about maxBytes of keys and values but at least one
+// entry if there is any. A batch is never split. A WALTruncatedError is returned if the entries are
+// no longer retained.
+func (wal *WriteAheadLog) Read(position uint64, maxBytes int) ([]*WALEntry, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if position >= wal.next {
+		return nil, fmt.Errorf("position %d is ahead of the write-ahead log, which ends at %d", position, wal.next-1)
+	}
+	if position+1 == wal.next {
+		return nil, nil
+	}
+	if len(wal.segments) == 0 || position+1 < wal.segments[0].first {
+		first := wal.next
+		if len(wal.segments) > 0 {
+			first = wal.segments[0].first
+		}
+		return nil, &WALTruncatedError{Position: position, First: first}
+	}
+
+	i := sort.Search(len(wal.segments), func(i int) bool { return wal.segments[i].first > position+1 }) - 1
+
+	var entries []*WALEntry
+	size := 0
+	for ; i < len(wal.segments) && (size < maxBytes || inBatch(entries)); i++ {
+		read, err := wal.readSegment(wal.segments[i], position+1, maxBytes-size)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range read {
+			size += len(entry.Key) + len(entry.Value)
+			position = entry.Seq
+		}
+		entries = append(entries, read...)
+	}
+
+	return entries, nil
+}
+
+// readSegment reads the entries of a segment starting at sequence number from, up to about maxBytes
+func (wal *WriteAheadLog) readSegment(segment *walSegment, from uint64, maxBytes int) ([]*WALEntry, error) {
+	if !segment.indexed {
+		if _, _, err := wal.index(segment, wal.next-1); err != nil {
+			return nil, err
+		}
+	}
+
+	offset := int64(0)
+	for _, mark := range segment.marks {
+		if mark.seq > from {
+			break
+		}
+		offset = mark.offset
+	}
+
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	var entries []*WALEntry
+	size := 0
+	for size < maxBytes || len(entries) == 0 || inBatch(entries) {
+		entry, _, err := readWALEntry(reader)
+		if err == io.EOF || (err == nil && entry.Seq >= wal.next) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read write-ahead log segment %s, %w", segment.path, err)
+		}
+
+		if entry.Seq >= from {
+			entries = append(entries, entry)
+			size += len(entry.Key) + len(entry.Value)
+		}
+	}
+
+	return entries, nil
+}
+
+// inBatch returns true if the last entry read is followed by more entries of its batch
+func inBatch(entries []*WALEntry) bool {
+	return len(entries) > 0 && entries[len(entries)-1].Batch
+}
+
+// Restart discards every entry and continues the log after position, the database was replaced
+func (wal *WriteAheadLog) Restart(position uint64) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if err := wal.removeSegments(); err != nil {
+		return err
+	}
+
+	wal.next = position + 1
+	return wal.startSegment()
+}
+
+// Close flushes and closes the log
+func (wal *WriteAheadLog) Close() error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.file == nil {
+		return nil
+	}
+
+	err := wal.file.Sync()
+	if closeErr := wal.file.Close(); err == nil {
+		err = closeErr
+	}
+	wal.file = nil
+
+	return err
+}
+
+func encodeWALEntry(seq uint64, op byte, key []byte, value []byte) []byte {
+	payload := protowire.AppendVarint(nil, seq)
+	payload = append(payload, op)
+	payload = protowire.AppendBytes(payload, key)
+	payload = append(payload, value...)
+
+	record := make([]byte, walHeaderLength, walHeaderLength+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// readWALEntry reads the next entry and returns it with its framed size
+func readWALEntry(reader io.Reader) (*WALEntry, int64, error) {
+	header := make([]byte, walHeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("torn write-ahead log entry")
+		}
+		return nil, 0, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errors.New("torn write-ahead log entry")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("write-ahead log entry checksum mismatch")
+	}
+
+	seq, n := protowire.ConsumeVarint(payload)
+	if n < 0 || len(payload) <= n {
+		return nil, 0, errors.New("write-ahead log entry corrupted")
+	}
+	op := payload[n]
+	key, m := protowire.ConsumeBytes(payload[n+1:])
+	if m < 0 {
+		return nil, 0, errors.New("write-ahead log entry corrupted")
+	}
+
+	entry := &WALEntry{Seq: seq, Key: key, Delete: op == walDelete, Batch: op == walBatchPut}
+	if !entry.Delete {
+		entry.Value = payload[n+1+m:]
+	}
+
+	return entry, int64(walHeaderLength + len(payload)), nil
+}
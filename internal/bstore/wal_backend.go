@@ -0,0 +1,324 @@
+package bstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/koinos/koinos-log-golang/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// WALBackend records every write in a WriteAheadLog before applying it to the wrapped backend, so
+// standby block stores can follow the writes. The sequence number of the last applied write is
+// stored in the database, so a standby can be seeded from a backup of the primary and follow the log
+// from there.
+//
+// A standby applies the entries of the primary with Apply, keeping their sequence numbers, and refuses
+// other writes until it is promoted.
+//
+// Compaction, backup, restore and health requests are forwarded to the wrapped backend. A restore
+// replaces the database, the log is restarted at the position of the restored database and standbys
+// must be seeded again.
+type WALBackend struct {
+	Backend BlockStoreBackend
+	WAL     *WriteAheadLog
+
+	// lock orders the log entries with the writes to the wrapped backend
+	lock    sync.Mutex
+	standby bool
+}
+
+// NewWALBackend creates a WALBackend over backend, keeping its log in dir. A segmentSize or retained
+// of 0 uses the defaults of the WriteAheadLog.
+func NewWALBackend(backend BlockStoreBackend, dir string, segmentSize int64, retained int) (*WALBackend, error) {
+	position, err := walPosition(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	wal, err := OpenWriteAheadLog(dir, segmentSize, retained, position)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WALBackend{Backend: backend, WAL: wal}, nil
+}
+
+// walPosition reads the sequence number of the last write applied to the database
+func walPosition(backend BlockStoreBackend) (uint64, error) {
+	value, err := backend.Get([]byte{walPositionKey})
+	if err != nil || len(value) == 0 {
+		return 0, err
+	}
+
+	position, n := protowire.ConsumeVarint(value)
+	if n < 0 {
+		return 0, errors.New("write-ahead log position record corrupted")
+	}
+
+	return position, nil
+}
+
+// write logs and applies a write, a nil value is a delete. The caller must hold the lock.
+func (backend *WALBackend) write(key []byte, value []byte) (uint64, error) {
+	seq, err := backend.WAL.Append(key, value)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == nil {
+		err = backend.Backend.Delete(key)
+	} else {
+		err = backend.Backend.Put(key, value)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, backend.Backend.Put([]byte{walPositionKey}, protowire.AppendVarint(nil, seq))
+}
+
+// writeBatch logs and applies a batch, along with the position of its last entry. The caller must
+// hold the lock.
+func (backend *WALBackend) writeBatch(pairs []*KeyValue) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	seq, err := backend.WAL.AppendBatch(pairs)
+	if err != nil {
+		return err
+	}
+
+	batch := append(pairs[:len(pairs):len(pairs)], &KeyValue{Key: []byte{walPositionKey}, Value: protowire.AppendVarint(nil, seq)})
+	return backend.Backend.PutBatch(batch)
+}
+
+// Position returns the sequence number of the last logged write
+func (backend *WALBackend) Position() uint64 {
+	return backend.WAL.Position()
+}
+
+// setStandby refuses writes other than Apply while standby is set
+func (backend *WALBackend) setStandby(standby bool) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.standby = standby
+}
+
+// Reset resets the wrapped database and restarts the log
+func (backend *WALBackend) Reset() error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if err := backend.Backend.Reset(); err != nil {
+		return err
+	}
+
+	return backend.WAL.Restart(0)
+}
+
+// Put logs the write and stores the value in the wrapped database
+func (backend *WALBackend) Put(key []byte, value []byte) error {
+	if key == nil {
+		return errors.New("cannot put a nil key")
+	}
+	if value == nil {
+		return errors.New("cannot put a nil value")
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.standby {
+		return errors.New("standby does not accept writes until it is promoted")
+	}
+
+	_, err := backend.write(key, value)
+	return err
+}
+
+// PutBatch logs the batch and stores the values in the wrapped database
+func (backend *WALBackend) PutBatch(pairs []*KeyValue) error {
+	if err := checkPairs(pairs); err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.standby {
+		return errors.New("standby does not accept writes until it is promoted")
+	}
+
+	return backend.writeBatch(pairs)
+}
+
+// Delete logs the write and removes the item from the wrapped database
+func (backend *WALBackend) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot remove an empty key")
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if backend.standby {
+		return errors.New("standby does not accept writes until it is promoted")
+	}
+
+	_, err := backend.write(key, nil)
+	return err
+}
+
+// Get fetches the requested value from the wrapped database
+func (backend *WALBackend) Get(key []byte) ([]byte, error) {
+	return backend.Backend.Get(key)
+}
+
+// Has reports whether the wrapped database stores key
+func (backend *WALBackend) Has(key []byte) (bool, error) {
+	return backend.Backend.Has(key)
+}
+
+// Iterate iterates over the wrapped database
+func (backend *WALBackend) Iterate(prefix []byte, fn func(key []byte, value []byte) error) error {
+	return backend.Backend.Iterate(prefix, fn)
+}
+
+// Snapshot takes a snapshot of the wrapped database
+func (backend *WALBackend) Snapshot() (BackendSnapshot, error) {
+	return backend.Backend.Snapshot()
+}
+
+// Flush syncs the log, then flushes the wrapped database
+func (backend *WALBackend) Flush() error {
+	if err := backend.WAL.Sync(); err != nil {
+		return err
+	}
+
+	return backend.Backend.Flush()
+}
+
+// Begin begins a transaction batching its writes, which are logged as a single batch on commit
+func (backend *WALBackend) Begin() (BackendTxn, error) {
+	return newBatchTxn(backend), nil
+}
+
+// Apply applies entries of the log of another block store, which must directly follow the last
+// logged write. The entries of a batch are applied together, so they must not be split.
+func (backend *WALBackend) Apply(entries []*WALEntry) error {
+	if inBatch(entries) {
+		return fmt.Errorf("write-ahead log batch of entry %d is incomplete", entries[len(entries)-1].Seq)
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	var batch []*KeyValue
+	for _, entry := range entries {
+		if expected := backend.WAL.Position() + uint64(len(batch)) + 1; entry.Seq != expected {
+			return fmt.Errorf("expected write-ahead log entry %d, got %d", expected, entry.Seq)
+		}
+		if len(entry.Key) == 0 {
+			return fmt.Errorf("write-ahead log entry %d has an empty key", entry.Seq)
+		}
+
+		var value []byte
+		if !entry.Delete {
+			value = entry.Value
+			if value == nil {
+				value = make([]byte, 0)
+			}
+		}
+
+		if entry.Batch || len(batch) > 0 {
+			if entry.Delete {
+				return fmt.Errorf("write-ahead log entry %d is a delete within a batch", entry.Seq)
+			}
+			batch = append(batch, &KeyValue{Key: entry.Key, Value: value})
+
+			if !entry.Batch {
+				if err := backend.writeBatch(batch); err != nil {
+					return err
+				}
+				batch = nil
+			}
+			continue
+		}
+
+		if _, err := backend.write(entry.Key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the log and the wrapped backend, if it needs closing
+func (backend *WALBackend) Close() {
+	if err := backend.WAL.Close(); err != nil {
+		log.Warnf("Could not close write-ahead log, %s", err.Error())
+	}
+
+	if closer, ok := backend.Backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Compact compacts the wrapped backend
+func (backend *WALBackend) Compact(discardRatio float64) (*CompactionResult, error) {
+	return backend.Backend.Compact(discardRatio)
+}
+
+// Backup backs up the wrapped backend, including the position of the log
+func (backend *WALBackend) Backup(w io.Writer, sinceVersion uint64) (uint64, error) {
+	return backend.Backend.Backup(w, sinceVersion)
+}
+
+// Load restores the wrapped backend and restarts the log at the restored position
+func (backend *WALBackend) Load(r io.Reader) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if err := backend.Backend.Load(r); err != nil {
+		return err
+	}
+
+	position, err := walPosition(backend.Backend)
+	if err != nil {
+		return err
+	}
+
+	return backend.WAL.Restart(position)
+}
+
+// Health reports the health of the wrapped backend. A backend which does not report its health is
+// assumed to be writable.
+func (backend *WALBackend) Health() (*BackendHealth, error) {
+	inner, ok := backend.Backend.(healthBackend)
+	if !ok {
+		return &BackendHealth{Writable: true}, nil
+	}
+
+	return inner.Health()
+}
+
+// Stats reports the statistics of the wrapped database
+func (backend *WALBackend) Stats() (*BackendStats, error) {
+	return backendStats(backend.Backend)
+}
+
+// Recompress recompresses a value of the wrapped backend. The stored value changes but not the value
+// read, so it is not logged.
+func (backend *WALBackend) Recompress(key []byte) (bool, error) {
+	inner, ok := backend.Backend.(recompressBackend)
+	if !ok {
+		return false, errors.New("backend does not support compression")
+	}
+
+	return inner.Recompress(key)
+}
@@ -0,0 +1,218 @@
+package bstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestWriteAheadLog(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	wal, err := OpenWriteAheadLog(dir, 512, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := bytes.Repeat([]byte{0xab}, 100)
+	for i := 1; i <= 20; i++ {
+		if i%5 == 0 {
+			_, err = wal.Append([]byte(fmt.Sprintf("key%d", i-1)), nil)
+		} else {
+			_, err = wal.Append([]byte(fmt.Sprintf("key%d", i)), value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wal.Position() != 20 {
+		t.Errorf("expected position 20, got %d", wal.Position())
+	}
+
+	// Only the last segments are retained
+	if len(wal.segments) != 3 {
+		t.Errorf("expected 3 retained segments, got %d", len(wal.segments))
+	}
+	if _, err = wal.Read(0, 1<<20); err == nil {
+		t.Error("expected an error reading entries which are no longer retained")
+	} else if _, ok := err.(*WALTruncatedError); !ok {
+		t.Errorf("expected WALTruncatedError, got %v", err)
+	}
+
+	first := wal.segments[0].first
+	entries, err := wal.Read(first-1, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != int(21-first) {
+		t.Fatalf("expected %d entries, got %d", 21-first, len(entries))
+	}
+	for i, entry := range entries {
+		seq := first + uint64(i)
+		if entry.Seq != seq {
+			t.Errorf("expected entry %d, got %d", seq, entry.Seq)
+		}
+		if entry.Delete != (seq%5 == 0) {
+			t.Errorf("unexpected operation of entry %d", seq)
+		}
+		if !entry.Delete && !bytes.Equal(entry.Value, value) {
+			t.Errorf("unexpected value of entry %d", seq)
+		}
+	}
+
+	// Reads are bounded but return at least one entry
+	if entries, err = wal.Read(17, 1); err != nil || len(entries) != 1 || entries[0].Seq != 18 {
+		t.Errorf("expected entry 18 alone, got %v, %v", entries, err)
+	}
+	if entries, err = wal.Read(20, 1<<20); err != nil || len(entries) != 0 {
+		t.Errorf("expected no entries after the last one, got %v, %v", entries, err)
+	}
+	if _, err = wal.Read(21, 1<<20); err == nil {
+		t.Error("expected an error reading after the end of the log")
+	}
+
+	if err = wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries after the position of the database were never applied, they are discarded on open
+	wal, err = OpenWriteAheadLog(dir, 512, 3, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wal.Position() != 18 {
+		t.Errorf("expected position 18 after reopening, got %d", wal.Position())
+	}
+	if seq, err := wal.Append([]byte("next"), value); err != nil || seq != 19 {
+		t.Errorf("expected entry 19, got %d, %v", seq, err)
+	}
+	if entries, err = wal.Read(18, 1<<20); err != nil || len(entries) != 1 || string(entries[0].Key) != "next" {
+		t.Errorf("expected the new entry 19, got %v, %v", entries, err)
+	}
+	if err = wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A log which ends before the position of the database is restarted
+	wal, err = OpenWriteAheadLog(dir, 512, 3, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = wal.Close() }()
+
+	if wal.Position() != 100 {
+		t.Errorf("expected position 100 after reopening, got %d", wal.Position())
+	}
+	if _, err = wal.Read(50, 1<<20); err == nil {
+		t.Error("expected an error reading entries of the discarded log")
+	}
+}
+
+func TestWriteAheadLogTornEntry(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	wal, err := OpenWriteAheadLog(dir, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = wal.Append([]byte{byte(i + 1)}, []byte{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := wal.segments[0].path
+	if err = wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the last entry short, as a crash while appending would
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err = OpenWriteAheadLog(dir, 0, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = wal.Close() }()
+
+	if seq, err := wal.Append([]byte{4}, []byte{4}); err != nil || seq != 3 {
+		t.Fatalf("expected entry 3, got %d, %v", seq, err)
+	}
+
+	entries, err := wal.Read(0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || !bytes.Equal(entries[2].Key, []byte{4}) {
+		t.Errorf("expected the torn entry to be replaced, got %v", entries)
+	}
+}
+
+func TestWriteAheadLogBatch(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "bstore-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	wal, err := OpenWriteAheadLog(dir, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = wal.Close() }()
+
+	if _, err = wal.Append([]byte{1}, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	pairs := []*KeyValue{{Key: []byte{2}, Value: []byte{2}}, {Key: []byte{3}, Value: []byte{3}}, {Key: []byte{4}, Value: []byte{4}}}
+	if seq, err := wal.AppendBatch(pairs); err != nil || seq != 4 {
+		t.Fatalf("expected the batch to end at entry 4, got %d, %v", seq, err)
+	}
+
+	// A read bounded within a batch returns the whole batch
+	entries, err := wal.Read(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || !entries[0].Batch || !entries[1].Batch || entries[2].Batch {
+		t.Errorf("expected the 3 entries of the batch, got %v", entries)
+	}
+
+	// A standby applies the batch with the position of its last entry
+	backend, err := NewWALBackend(NewMapBackend(), dir+"/standby", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	all, err := wal.Read(0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = backend.Apply(all[:2]); err == nil || backend.Position() != 0 {
+		t.Errorf("expected an error applying part of a batch, got position %d", backend.Position())
+	}
+	if err = backend.Apply(all); err != nil {
+		t.Fatal(err)
+	}
+	if backend.Position() != 4 {
+		t.Errorf("expected position 4, got %d", backend.Position())
+	}
+	if position, err := walPosition(backend.Backend); err != nil || position != 4 {
+		t.Errorf("expected the stored position 4, got %d, %v", position, err)
+	}
+}